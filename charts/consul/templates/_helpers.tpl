@@ -272,6 +272,156 @@ This template is for an init container.
       cpu: "50m"
 {{- end -}}
 
+{{/*
+Sets up the flags of tls-init that are shared by the tls-init job and the
+tls-rotate deployment, so that renewed server certificates have the same SANs.
+*/}}
+{{- define "consul.tlsInitFlags" -}}
+-log-level={{ .Values.global.logLevel }} \
+-log-json={{ .Values.global.logJSON }} \
+-domain={{ .Values.global.domain }} \
+-days=730 \
+-name-prefix={{ template "consul.fullname" . }} \
+-k8s-namespace=${NAMESPACE} \
+{{- if (and .Values.global.tls.caCert.secretName .Values.global.tls.caKey.secretName) }}
+-ca=/consul/tls/ca/cert/tls.crt \
+-key=/consul/tls/ca/key/tls.key \
+{{- end }}
+-additional-dnsname="{{ template "consul.fullname" . }}-server" \
+-additional-dnsname="*.{{ template "consul.fullname" . }}-server" \
+-additional-dnsname="*.{{ template "consul.fullname" . }}-server.${NAMESPACE}" \
+-additional-dnsname="{{ template "consul.fullname" . }}-server.${NAMESPACE}" \
+-additional-dnsname="*.{{ template "consul.fullname" . }}-server.${NAMESPACE}.svc" \
+-additional-dnsname="{{ template "consul.fullname" . }}-server.${NAMESPACE}.svc" \
+-additional-dnsname="*.server.{{ .Values.global.datacenter }}.{{ .Values.global.domain }}" \
+{{- range .Values.global.tls.serverAdditionalIPSANs }}
+-additional-ipaddress={{ . }} \
+{{- end }}
+{{- range .Values.global.tls.serverAdditionalDNSSANs }}
+-additional-dnsname={{ . }} \
+{{- end }}
+{{- end -}}
+
+{{/*
+The volumes, environment variables, volume mounts and flags of the
+create-federation-secret job and of the federation-secret-refresh deployment.
+We can assume tls is enabled because there is a check in server-statefulset
+that requires tls to be enabled if federation is enabled.
+*/}}
+{{- define "consul.createFederationSecretVolumes" -}}
+- name: consul-ca-cert
+  secret:
+    {{- if .Values.global.tls.caCert.secretName }}
+    secretName: {{ .Values.global.tls.caCert.secretName }}
+    {{- else }}
+    secretName: {{ template "consul.fullname" . }}-ca-cert
+    {{- end }}
+    items:
+      - key: {{ default "tls.crt" .Values.global.tls.caCert.secretKey }}
+        path: tls.crt
+- name: consul-ca-key
+  secret:
+    {{- if .Values.global.tls.caKey.secretName }}
+    secretName: {{ .Values.global.tls.caKey.secretName }}
+    {{- else }}
+    secretName: {{ template "consul.fullname" . }}-ca-key
+    {{- end }}
+    items:
+      - key: {{ default "tls.key" .Values.global.tls.caKey.secretKey }}
+        path: tls.key
+{{- /* We must incude both auto-encrypt and server CAs because we make API calls to the local
+    Consul client (requiring the auto-encrypt CA) but the secret generated must include the server CA */}}
+{{- if .Values.global.tls.enableAutoEncrypt }}
+- name: consul-auto-encrypt-ca-cert
+  emptyDir:
+    medium: "Memory"
+{{- end }}
+{{- if (and .Values.global.gossipEncryption.secretName .Values.global.gossipEncryption.secretKey) }}
+- name: gossip-encryption-key
+  secret:
+    secretName: {{ .Values.global.gossipEncryption.secretName }}
+    items:
+      - key: {{ .Values.global.gossipEncryption.secretKey }}
+        path: gossip.key
+{{- else if .Values.global.gossipEncryption.autoGenerate }}
+- name: gossip-encryption-key
+  secret:
+    secretName: {{ template "consul.fullname" . }}-gossip-encryption-key
+    items:
+      - key: key
+        path: gossip.key
+{{- end }}
+{{- end -}}
+
+{{- define "consul.createFederationSecretEnv" -}}
+- name: NAMESPACE
+  valueFrom:
+    fieldRef:
+      fieldPath: metadata.namespace
+- name: HOST_IP
+  valueFrom:
+    fieldRef:
+      fieldPath: status.hostIP
+- name: CONSUL_HTTP_ADDR
+  value: https://$(HOST_IP):8501
+- name: CONSUL_CACERT
+  {{- if .Values.global.tls.enableAutoEncrypt }}
+  value: /consul/tls/client/ca/tls.crt
+  {{- else }}
+  value: /consul/tls/ca/tls.crt
+  {{- end }}
+{{- end -}}
+
+{{- define "consul.createFederationSecretVolumeMounts" -}}
+- name: consul-ca-cert
+  mountPath: /consul/tls/ca
+  readOnly: true
+- name: consul-ca-key
+  mountPath: /consul/tls/server/ca
+  readOnly: true
+{{- if .Values.global.tls.enableAutoEncrypt }}
+- name: consul-auto-encrypt-ca-cert
+  mountPath: /consul/tls/client/ca
+  readOnly: true
+{{- end }}
+{{- if (or .Values.global.gossipEncryption.autoGenerate (and .Values.global.gossipEncryption.secretName .Values.global.gossipEncryption.secretKey)) }}
+- name: gossip-encryption-key
+  mountPath: /consul/gossip
+  readOnly: true
+{{- end }}
+{{- end -}}
+
+{{- define "consul.createFederationSecretFlags" -}}
+-log-level={{ .Values.global.logLevel }} \
+-log-json={{ .Values.global.logJSON }} \
+{{- if (or .Values.global.gossipEncryption.autoGenerate (and .Values.global.gossipEncryption.secretName .Values.global.gossipEncryption.secretKey)) }}
+-gossip-key-file=/consul/gossip/gossip.key \
+{{- end }}
+{{- if .Values.global.acls.createReplicationToken }}
+-export-replication-token=true \
+{{- end }}
+-mesh-gateway-service-name={{ .Values.meshGateway.consulServiceName }} \
+-k8s-namespace="${NAMESPACE}" \
+-resource-prefix="{{ template "consul.fullname" . }}" \
+-server-ca-cert-file=/consul/tls/ca/tls.crt \
+-server-ca-key-file=/consul/tls/server/ca/tls.key \
+{{- end -}}
+
+{{/*
+Sets up the projected service account token volume that acl-init logs in with
+when global.acls.authMethod.type is jwt. The token is bound to the audience of
+the jwt auth methods.
+*/}}
+{{- define "consul.aclAuthMethodTokenVolume" -}}
+- name: consul-acl-auth-method-token
+  projected:
+    sources:
+    - serviceAccountToken:
+        audience: {{ .Values.global.acls.authMethod.jwt.audience }}
+        expirationSeconds: {{ .Values.global.acls.authMethod.jwt.expirationSeconds }}
+        path: token
+{{- end -}}
+
 {{/*
 Fails when a reserved name is passed in. This should be used to test against
 Consul namespaces and partition names.
@@ -312,3 +462,208 @@ Usage: {{ template "consul.validateVaultWebhookCertConfiguration" . }}
 {{ end }}
 {{ end }}
 {{- end -}}
+
+{{/*
+Sets the flags of the server-acl-init command that are shared between the
+server-acl-init job and the server-acl-reconcile deployment. Each flag is
+followed by a line continuation.
+
+Usage: {{ include "consul.serverACLInitFlags" . | nindent 16 }}
+
+*/}}
+{{- define "consul.serverACLInitFlags" -}}
+{{- $serverEnabled := (or (and (ne (.Values.server.enabled | toString) "-") .Values.server.enabled) (and (eq (.Values.server.enabled | toString) "-") .Values.global.enabled)) -}}
+-log-level={{ .Values.global.logLevel }} \
+-log-json={{ .Values.global.logJSON }} \
+-resource-prefix=${CONSUL_FULLNAME} \
+-k8s-namespace={{ .Release.Namespace }} \
+-set-server-tokens={{ $serverEnabled }} \
+-consul-api-timeout={{ .Values.global.consulAPITimeout }} \
+
+{{- if .Values.externalServers.enabled }}
+{{- if and .Values.externalServers.enabled (not .Values.externalServers.hosts) }}{{ fail "externalServers.hosts must be set if externalServers.enabled is true" }}{{ end -}}
+{{- range .Values.externalServers.hosts }}
+-server-address={{ quote . }} \
+{{- end }}
+-server-port={{ .Values.externalServers.httpsPort }} \
+{{- else }}
+{{- range $index := until (.Values.server.replicas | int) }}
+-server-address="${CONSUL_FULLNAME}-server-{{ $index }}.${CONSUL_FULLNAME}-server.${NAMESPACE}.svc" \
+{{- end }}
+{{- end }}
+
+{{- if .Values.global.tls.enabled }}
+-use-https \
+{{- if not (and .Values.externalServers.enabled .Values.externalServers.useSystemRoots) }}
+{{- if .Values.global.secretsBackend.vault.enabled }}
+-consul-ca-cert=/vault/secrets/serverca.crt \
+{{- else }}
+-consul-ca-cert=/consul/tls/ca/tls.crt \
+{{- end }}
+{{- end }}
+{{- if not .Values.externalServers.enabled }}
+-server-port=8501 \
+{{- end }}
+{{- if .Values.externalServers.tlsServerName }}
+-consul-tls-server-name={{ .Values.externalServers.tlsServerName }} \
+{{- end }}
+{{- end }}
+
+{{- if .Values.syncCatalog.enabled }}
+-sync-catalog=true \
+{{- if .Values.syncCatalog.consulNodeName }}
+-sync-consul-node-name={{ .Values.syncCatalog.consulNodeName }} \
+{{- end }}
+{{- end }}
+{{- if .Values.global.adminPartitions.enabled }}
+-enable-partitions=true \
+-partition={{ .Values.global.adminPartitions.name }} \
+{{- end }}
+{{- if .Values.global.peering.enabled }}
+-enable-peering=true \
+{{- end }}
+{{- if (or (and (ne (.Values.dns.enabled | toString) "-") .Values.dns.enabled) (and (eq (.Values.dns.enabled | toString) "-") .Values.global.enabled)) }}
+-allow-dns=true \
+{{- end }}
+
+{{- if (or (and (ne (.Values.connectInject.enabled | toString) "-") .Values.connectInject.enabled) (and (eq (.Values.connectInject.enabled | toString) "-") .Values.global.enabled)) }}
+-connect-inject=true \
+{{- end }}
+{{- if and .Values.externalServers.enabled .Values.externalServers.k8sAuthMethodHost }}
+-auth-method-host={{ .Values.externalServers.k8sAuthMethodHost }} \
+{{- end }}
+
+{{- if .Values.global.federation.k8sAuthMethodHost }}
+-auth-method-host={{ .Values.global.federation.k8sAuthMethodHost }} \
+{{- end }}
+
+{{- if .Values.meshGateway.enabled }}
+-mesh-gateway=true \
+{{- end }}
+
+{{- if .Values.ingressGateways.enabled }}
+{{- if .Values.global.enableConsulNamespaces }}
+{{- $root := . }}
+{{- range .Values.ingressGateways.gateways }}
+{{- if (or $root.Values.ingressGateways.defaults.consulNamespace .consulNamespace) }}
+-ingress-gateway-name="{{ .name }}.{{ (default $root.Values.ingressGateways.defaults.consulNamespace .consulNamespace) }}" \
+{{- else }}
+-ingress-gateway-name="{{ .name }}" \
+{{- end }}
+{{- end }}
+{{- else }}
+{{- range .Values.ingressGateways.gateways }}
+-ingress-gateway-name="{{ .name }}" \
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- if .Values.terminatingGateways.enabled }}
+{{- if .Values.global.enableConsulNamespaces }}
+{{- $root := . }}
+{{- range .Values.terminatingGateways.gateways }}
+{{- if (or $root.Values.terminatingGateways.defaults.consulNamespace .consulNamespace) }}
+-terminating-gateway-name="{{ .name }}.{{ (default $root.Values.terminatingGateways.defaults.consulNamespace .consulNamespace) }}" \
+{{- else }}
+-terminating-gateway-name="{{ .name }}" \
+{{- end }}
+{{- end }}
+{{- else }}
+{{- range .Values.terminatingGateways.gateways }}
+-terminating-gateway-name="{{ .name }}" \
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- if .Values.connectInject.aclBindingRuleSelector }}
+-acl-binding-rule-selector={{ .Values.connectInject.aclBindingRuleSelector }} \
+{{- end }}
+
+{{- if not (has .Values.global.acls.authMethod.type (list "kubernetes" "jwt")) }}{{ fail "global.acls.authMethod.type must be \"kubernetes\" or \"jwt\"" }}{{ end }}
+{{- if eq .Values.global.acls.authMethod.type "jwt" }}
+{{- if not .Values.global.acls.authMethod.jwt.audience }}{{ fail "global.acls.authMethod.jwt.audience must be set if global.acls.authMethod.type is jwt" }}{{ end }}
+{{- if .Values.apiGateway.enabled }}{{ fail "global.acls.authMethod.type jwt is not supported with apiGateway.enabled" }}{{ end }}
+-auth-method-type=jwt \
+-auth-method-jwt-audience={{ .Values.global.acls.authMethod.jwt.audience }} \
+{{- if .Values.global.acls.authMethod.jwt.issuer }}
+-auth-method-jwt-issuer={{ .Values.global.acls.authMethod.jwt.issuer }} \
+{{- end }}
+{{- end }}
+
+{{- if (and .Values.global.enterpriseLicense.secretName .Values.global.enterpriseLicense.secretKey) }}
+-create-enterprise-license-token=true \
+{{- end }}
+
+{{- if .Values.client.snapshotAgent.enabled }}
+-snapshot-agent=true \
+{{- end }}
+
+{{- if not (or (and (ne (.Values.client.enabled | toString) "-") .Values.client.enabled) (and (eq (.Values.client.enabled | toString) "-") .Values.global.enabled)) }}
+-client=false \
+{{- end }}
+
+{{- if .Values.global.acls.createReplicationToken }}
+-create-acl-replication-token=true \
+{{- end }}
+
+{{- if .Values.global.federation.enabled }}
+-federation=true \
+{{- end }}
+
+{{- if .Values.global.acls.bootstrapToken.secretName }}
+{{- if .Values.global.secretsBackend.vault.enabled }}
+-bootstrap-token-file=/vault/secrets/bootstrap-token \
+{{- else }}
+-bootstrap-token-file=/consul/acl/tokens/bootstrap-token \
+{{- end }}
+{{- end }}
+{{- if .Values.global.acls.replicationToken.secretName }}
+{{- if .Values.global.secretsBackend.vault.enabled }}
+-acl-replication-token-file=/vault/secrets/replication-token \
+{{- else }}
+-acl-replication-token-file=/consul/acl/tokens/acl-replication-token \
+{{- end }}
+{{- end }}
+{{- if and .Values.global.secretsBackend.vault.enabled .Values.global.acls.partitionToken.secretName }}
+-partition-token-file=/vault/secrets/partition-token \
+{{- end }}
+
+{{- if .Values.controller.enabled }}
+-controller=true \
+{{- end }}
+
+{{- if .Values.apiGateway.enabled }}
+-api-gateway-controller=true \
+{{- end }}
+
+{{- if .Values.global.enableConsulNamespaces }}
+-enable-namespaces=true \
+
+{{- /* syncCatalog must be enabled to set sync flags */}}
+{{- if (or (and (ne (.Values.syncCatalog.enabled | toString) "-") .Values.syncCatalog.enabled) (and (eq (.Values.syncCatalog.enabled | toString) "-") .Values.global.enabled)) }}
+{{- if .Values.syncCatalog.consulNamespaces.consulDestinationNamespace }}
+-consul-sync-destination-namespace={{ .Values.syncCatalog.consulNamespaces.consulDestinationNamespace }} \
+{{- end }}
+{{- if .Values.syncCatalog.consulNamespaces.mirroringK8S }}
+-enable-sync-k8s-namespace-mirroring=true \
+{{- if .Values.syncCatalog.consulNamespaces.mirroringK8SPrefix }}
+-sync-k8s-namespace-mirroring-prefix={{ .Values.syncCatalog.consulNamespaces.mirroringK8SPrefix }} \
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- /* connectInject must be enabled to set inject flags */}}
+{{- if (or (and (ne (.Values.connectInject.enabled | toString) "-") .Values.connectInject.enabled) (and (eq (.Values.connectInject.enabled | toString) "-") .Values.global.enabled)) }}
+{{- if .Values.connectInject.consulNamespaces.consulDestinationNamespace }}
+-consul-inject-destination-namespace={{ .Values.connectInject.consulNamespaces.consulDestinationNamespace }} \
+{{- end }}
+{{- if .Values.connectInject.consulNamespaces.mirroringK8S }}
+-enable-inject-k8s-namespace-mirroring=true \
+{{- if .Values.connectInject.consulNamespaces.mirroringK8SPrefix }}
+-inject-k8s-namespace-mirroring-prefix={{ .Values.connectInject.consulNamespaces.mirroringK8SPrefix }} \
+{{- end }}
+{{- end }}
+{{- end }}
+
+{{- end }}
+{{- end -}}
@@ -628,6 +628,27 @@ EOF
   [ "${actual}" != "" ]
 }
 
+@test "connectInject/Deployment: certs volume uses the webhook-cert-manager secret by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/connect-inject-deployment.yaml  \
+      --set 'connectInject.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.spec.template.spec.volumes[] | select(.name == "certs") | .secret.secretName' | tee /dev/stderr)
+  [ "${actual}" = "release-name-consul-connect-inject-webhook-cert" ]
+}
+
+@test "connectInject/Deployment: certs volume uses webhookCertManager.externalCerts.connectInject.secretName" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/connect-inject-deployment.yaml  \
+      --set 'connectInject.enabled=true' \
+      --set 'webhookCertManager.externalCerts.connectInject.secretName=inject-cert' \
+      . | tee /dev/stderr |
+      yq -r '.spec.template.spec.volumes[] | select(.name == "certs") | .secret.secretName' | tee /dev/stderr)
+  [ "${actual}" = "inject-cert" ]
+}
+
 #--------------------------------------------------------------------
 # global.tls.enableAutoEncrypt
 
@@ -1231,6 +1252,126 @@ EOF
   [ "${actual}" = "true" ]
 }
 
+@test "connectInject/Deployment: init container logs in with a projected token when global.acls.authMethod.type=jwt" {
+  cd `chart_dir`
+  local spec=$(helm template \
+      -s templates/connect-inject-deployment.yaml \
+      --set 'connectInject.enabled=true' \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.authMethod.type=jwt' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec' | tee /dev/stderr)
+
+  local actual=$(echo $spec |
+      yq -r '.volumes[] | select(.name == "consul-acl-auth-method-token") | .projected.sources[0].serviceAccountToken.audience' | tee /dev/stderr)
+  [ "${actual}" = "consul" ]
+
+  local object=$(echo $spec |
+      yq '.initContainers[] | select(.name == "connect-injector-acl-init")' | tee /dev/stderr)
+
+  local actual=$(echo $object |
+      yq -r '.volumeMounts[] | select(.name == "consul-acl-auth-method-token") | .mountPath' | tee /dev/stderr)
+  [ "${actual}" = "/consul/acl-auth-method" ]
+
+  local actual=$(echo $object |
+      yq -r '.command | any(contains("-bearer-token-file=/consul/acl-auth-method/token"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "connectInject/Deployment: init container does not use a projected token by default" {
+  cd `chart_dir`
+  local spec=$(helm template \
+      -s templates/connect-inject-deployment.yaml \
+      --set 'connectInject.enabled=true' \
+      --set 'global.acls.manageSystemACLs=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec' | tee /dev/stderr)
+
+  local actual=$(echo $spec |
+      yq '.volumes | map(select(.name == "consul-acl-auth-method-token")) | length' | tee /dev/stderr)
+  [ "${actual}" = "0" ]
+
+  local actual=$(echo $spec |
+      yq -r '.initContainers[] | select(.name == "connect-injector-acl-init") | .command | any(contains("-bearer-token-file"))' | tee /dev/stderr)
+  [ "${actual}" = "false" ]
+}
+
+@test "connectInject/Deployment: jwt audience flags set when global.acls.authMethod.type=jwt" {
+  cd `chart_dir`
+  local cmd=$(helm template \
+      -s templates/connect-inject-deployment.yaml \
+      --set 'connectInject.enabled=true' \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.authMethod.type=jwt' \
+      --set 'global.acls.authMethod.jwt.audience=foo' \
+      --set 'global.acls.authMethod.jwt.expirationSeconds=7200' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command' | tee /dev/stderr)
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-acl-auth-method-jwt-audience=foo"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-acl-auth-method-jwt-expiration-seconds=7200"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+#--------------------------------------------------------------------
+# orphanGC
+
+@test "connectInject/Deployment: orphan garbage collector disabled by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/connect-inject-deployment.yaml \
+      --set 'connectInject.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command | any(contains("orphan-gc"))' | tee /dev/stderr)
+  [ "${actual}" = "false" ]
+}
+
+@test "connectInject/Deployment: orphan garbage collector enabled with connectInject.orphanGC.enabled=true" {
+  cd `chart_dir`
+  local cmd=$(helm template \
+      -s templates/connect-inject-deployment.yaml \
+      --set 'connectInject.enabled=true' \
+      --set 'connectInject.orphanGC.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command' | tee /dev/stderr)
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-enable-orphan-gc=true"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-orphan-gc-interval=5m"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-orphan-gc-grace-period=5m"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "connectInject/Deployment: orphan garbage collector interval and grace period can be set" {
+  cd `chart_dir`
+  local cmd=$(helm template \
+      -s templates/connect-inject-deployment.yaml \
+      --set 'connectInject.enabled=true' \
+      --set 'connectInject.orphanGC.enabled=true' \
+      --set 'connectInject.orphanGC.interval=1m' \
+      --set 'connectInject.orphanGC.gracePeriod=10m' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command' | tee /dev/stderr)
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-orphan-gc-interval=1m"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-orphan-gc-grace-period=10m"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
 #--------------------------------------------------------------------
 # resources
 
@@ -229,3 +229,17 @@ load _helpers
   local actual=$(echo $object | yq -r '.verbs | index("watch")' | tee /dev/stderr)
   [ "${actual}" != null ]
 }
+
+#--------------------------------------------------------------------
+# namespaceTokens
+
+@test "controller/ClusterRole: allows reading namespaces and secrets when namespaceTokens.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/controller-clusterrole.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'controller.namespaceTokens.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.rules[] | select(.resources | index("secrets")) | .resources | join(",")' | tee /dev/stderr)
+  [ "${actual}" = "namespaces,secrets" ]
+}
@@ -92,6 +92,27 @@ load _helpers
 #--------------------------------------------------------------------
 # replicas
 
+@test "controller/Deployment: cert volume uses the webhook-cert-manager secret by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/controller-deployment.yaml  \
+      --set 'controller.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.spec.template.spec.volumes[] | select(.name == "cert") | .secret.secretName' | tee /dev/stderr)
+  [ "${actual}" = "release-name-consul-controller-webhook-cert" ]
+}
+
+@test "controller/Deployment: cert volume uses webhookCertManager.externalCerts.controller.secretName" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/controller-deployment.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'webhookCertManager.externalCerts.controller.secretName=controller-cert' \
+      . | tee /dev/stderr |
+      yq -r '.spec.template.spec.volumes[] | select(.name == "cert") | .secret.secretName' | tee /dev/stderr)
+  [ "${actual}" = "controller-cert" ]
+}
+
 @test "controller/Deployment: replicas defaults to 1" {
   cd `chart_dir`
   local actual=$(helm template \
@@ -1234,3 +1255,127 @@ load _helpers
 }
 
 
+
+#--------------------------------------------------------------------
+# aclResources
+
+@test "controller/Deployment: acl resources flags are not set by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/controller-deployment.yaml  \
+      --set 'controller.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command | any(contains("-enable-acl-resources"))' | tee /dev/stderr)
+  [ "${actual}" = "false" ]
+}
+
+@test "controller/Deployment: fails if aclResources.enabled=true and aclToken.secretName is not set" {
+  cd `chart_dir`
+  run helm template \
+      -s templates/controller-deployment.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'controller.aclResources.enabled=true' .
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "controller.aclResources.aclToken.secretName must be set if controller.aclResources.enabled=true" ]]
+}
+
+@test "controller/Deployment: acl resources flags are set when aclResources.enabled=true" {
+  cd `chart_dir`
+  local cmd=$(helm template \
+      -s templates/controller-deployment.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'controller.aclResources.enabled=true' \
+      --set 'controller.aclResources.aclToken.secretName=foo' \
+      --set 'controller.aclResources.aclToken.secretKey=bar' \
+      --namespace 'consul' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command' | tee /dev/stderr)
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-enable-acl-resources"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-acl-resources-token-secret-name=foo"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-acl-resources-token-secret-key=bar"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-acl-resources-token-secret-namespace=consul"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+#--------------------------------------------------------------------
+# networkPolicyIntentions
+
+@test "controller/Deployment: enable-network-policy-intentions flag is not set by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/controller-deployment.yaml  \
+      --set 'controller.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command | any(contains("-enable-network-policy-intentions"))' | tee /dev/stderr)
+  [ "${actual}" = "false" ]
+}
+
+@test "controller/Deployment: enable-network-policy-intentions flag is set when networkPolicyIntentions.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/controller-deployment.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'controller.networkPolicyIntentions.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command | any(contains("-enable-network-policy-intentions"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+#--------------------------------------------------------------------
+# namespaceTokens
+
+@test "controller/Deployment: enable-namespace-tokens flag is not set by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/controller-deployment.yaml  \
+      --set 'controller.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command | any(contains("-enable-namespace-tokens"))' | tee /dev/stderr)
+  [ "${actual}" = "false" ]
+}
+
+@test "controller/Deployment: namespace token flags are set when namespaceTokens.enabled=true" {
+  cd `chart_dir`
+  local cmd=$(helm template \
+      -s templates/controller-deployment.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'controller.namespaceTokens.enabled=true' \
+      --set 'controller.namespaceTokens.secretName=foo' \
+      --set 'controller.namespaceTokens.secretKey=bar' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command' | tee /dev/stderr)
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-enable-namespace-tokens"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-namespace-token-secret-name=foo"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  local actual=$(echo "$cmd" |
+    yq 'any(contains("-namespace-token-secret-key=bar"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "controller/Deployment: fails if namespaceTokens.enabled=true and secretName is not set" {
+  cd `chart_dir`
+  run helm template \
+      -s templates/controller-deployment.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'controller.namespaceTokens.enabled=true' \
+      --set 'controller.namespaceTokens.secretName=' .
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "controller.namespaceTokens.secretName and controller.namespaceTokens.secretKey must be set if controller.namespaceTokens.enabled=true" ]]
+}
@@ -0,0 +1,43 @@
+#!/usr/bin/env bats
+
+load _helpers
+
+@test "syncedService/CustomerResourceDefinition: disabled by default" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/crd-syncedservices.yaml  \
+      .
+}
+
+@test "syncedService/CustomerResourceDefinition: disabled with syncCatalog.enabled=true" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/crd-syncedservices.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      .
+}
+
+@test "syncedService/CustomerResourceDefinition: disabled with syncCatalog.toConsul=false" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/crd-syncedservices.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      --set 'syncCatalog.toConsul=false' \
+      --set 'syncCatalog.syncedServices.enabled=true' \
+      .
+}
+
+@test "syncedService/CustomerResourceDefinition: enabled with syncCatalog.syncedServices.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/crd-syncedservices.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      --set 'syncCatalog.syncedServices.enabled=true' \
+      . | tee /dev/stderr |
+      # The generated CRDs have "---" at the top which results in two objects
+      # being detected by yq, the first of which is null. We must therefore use
+      # yq -s so that length operates on both objects at once rather than
+      # individually, which would output false\ntrue and fail the test.
+      yq -s 'length > 0' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
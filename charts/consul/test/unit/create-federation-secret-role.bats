@@ -23,6 +23,20 @@ load _helpers
   [ "${actual}" = "true" ]
 }
 
+@test "createFederationSecret/Role: allows reading and updating the federation secret" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/create-federation-secret-role.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.rules | map(select(.resourceNames[0] == "release-name-consul-federation")) | .[0].verbs | join(",")' | tee /dev/stderr)
+  [ "${actual}" = "get,update" ]
+}
+
 #--------------------------------------------------------------------
 # global.acls.manageSystemACLs
 
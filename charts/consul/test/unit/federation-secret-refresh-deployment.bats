@@ -0,0 +1,188 @@
+#!/usr/bin/env bats
+
+load _helpers
+
+@test "federationSecretRefresh/Deployment: disabled by default" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/federation-secret-refresh-deployment.yaml  \
+      .
+}
+
+@test "federationSecretRefresh/Deployment: disabled with global.federation.createFederationSecret=true" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/federation-secret-refresh-deployment.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      .
+}
+
+@test "federationSecretRefresh/Deployment: fails without global.federation.createFederationSecret=true" {
+  cd `chart_dir`
+  run helm template \
+      -s templates/federation-secret-refresh-deployment.yaml  \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      .
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "global.federation.createFederationSecret must be true when global.federation.refreshFederationSecret.enabled is true" ]]
+}
+
+@test "federationSecretRefresh/Deployment: enabled with global.federation.refreshFederationSecret.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-deployment.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      . | tee /dev/stderr |
+      yq 'length > 0' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "federationSecretRefresh/Deployment: uses the federation-secret-refresh service account" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-deployment.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.spec.template.spec.serviceAccountName' | tee /dev/stderr)
+  [ "${actual}" = "release-name-consul-federation-secret-refresh" ]
+}
+
+#--------------------------------------------------------------------
+# command
+
+@test "federationSecretRefresh/Deployment: runs create-federation-secret in refresh mode" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/federation-secret-refresh-deployment.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command' | tee /dev/stderr)
+
+  local actual=$(echo $object |
+    yq 'any(contains("create-federation-secret"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq 'any(contains("-refresh=true"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq 'any(contains("-refresh-interval=1m"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq 'any(contains("-remote-kubeconfig-secret"))' | tee /dev/stderr)
+  [ "${actual}" = "false" ]
+}
+
+@test "federationSecretRefresh/Deployment: can set the refresh interval" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-deployment.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.interval=5m' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command | any(contains("-refresh-interval=5m"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "federationSecretRefresh/Deployment: sets -remote-kubeconfig-secret for each remote kubeconfig secret" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/federation-secret-refresh-deployment.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.remoteKubeconfigSecrets[0]=dc2-kubeconfig' \
+      --set 'global.federation.refreshFederationSecret.remoteKubeconfigSecrets[1]=dc3-kubeconfig' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command' | tee /dev/stderr)
+
+  local actual=$(echo $object |
+    yq 'any(contains("-remote-kubeconfig-secret=dc2-kubeconfig"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq 'any(contains("-remote-kubeconfig-secret=dc3-kubeconfig"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+#--------------------------------------------------------------------
+# global.tls.enableAutoEncrypt
+
+@test "federationSecretRefresh/Deployment: fetches the client CA with global.tls.enableAutoEncrypt=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-deployment.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      --set 'global.tls.enableAutoEncrypt=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.initContainers | map(select(.name == "get-auto-encrypt-client-ca")) | length' | tee /dev/stderr)
+  [ "${actual}" = "1" ]
+}
+
+#--------------------------------------------------------------------
+# global.metrics.enabled
+
+@test "federationSecretRefresh/Deployment: adds prometheus annotations with global.metrics.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-deployment.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      --set 'global.metrics.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.spec.template.metadata.annotations."prometheus.io/port"' | tee /dev/stderr)
+  [ "${actual}" = "8080" ]
+}
+
+@test "federationSecretRefresh/Deployment: does not add prometheus annotations by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-deployment.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.metadata.annotations | has("prometheus.io/scrape")' | tee /dev/stderr)
+  [ "${actual}" = "false" ]
+}
@@ -0,0 +1,39 @@
+#!/usr/bin/env bats
+
+load _helpers
+
+@test "federationSecretRefresh/PodSecurityPolicy: disabled by default" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/federation-secret-refresh-podsecuritypolicy.yaml  \
+      .
+}
+
+@test "federationSecretRefresh/PodSecurityPolicy: disabled with global.federation.refreshFederationSecret.enabled=true" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/federation-secret-refresh-podsecuritypolicy.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      .
+}
+
+@test "federationSecretRefresh/PodSecurityPolicy: enabled with global.federation.refreshFederationSecret.enabled=true and global.enablePodSecurityPolicies=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-podsecuritypolicy.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      --set 'global.enablePodSecurityPolicies=true' \
+      . | tee /dev/stderr |
+      yq 'length > 0' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
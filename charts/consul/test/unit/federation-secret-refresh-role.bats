@@ -0,0 +1,126 @@
+#!/usr/bin/env bats
+
+load _helpers
+
+@test "federationSecretRefresh/Role: disabled by default" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/federation-secret-refresh-role.yaml  \
+      .
+}
+
+@test "federationSecretRefresh/Role: disabled with global.federation.createFederationSecret=true" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/federation-secret-refresh-role.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      .
+}
+
+@test "federationSecretRefresh/Role: enabled with global.federation.refreshFederationSecret.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-role.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      . | tee /dev/stderr |
+      yq 'length > 0' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "federationSecretRefresh/Role: allows reading and updating the federation secret" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-role.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.rules[1].resourceNames[0] + ":" + (.rules[1].verbs | join(","))' | tee /dev/stderr)
+  [ "${actual}" = "release-name-consul-federation:get,update" ]
+}
+
+#--------------------------------------------------------------------
+# global.acls.manageSystemACLs
+
+@test "federationSecretRefresh/Role: allows read access for replication token with global.acls.manageSystemACLs=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-role.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.createReplicationToken=true' \
+      . | tee /dev/stderr |
+      yq -r '.rules | map(select(.resourceNames[0] == "release-name-consul-acl-replication-acl-token")) | length' | tee /dev/stderr)
+  [ "${actual}" = "1" ]
+}
+
+#--------------------------------------------------------------------
+# remoteKubeconfigSecrets
+
+@test "federationSecretRefresh/Role: does not grant access to other secrets by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-role.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.rules | length' | tee /dev/stderr)
+  [ "${actual}" = "2" ]
+}
+
+@test "federationSecretRefresh/Role: allows read access for remote kubeconfig secrets" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-role.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.remoteKubeconfigSecrets[0]=dc2-kubeconfig' \
+      --set 'global.federation.refreshFederationSecret.remoteKubeconfigSecrets[1]=dc3-kubeconfig' \
+      . | tee /dev/stderr |
+      yq -r '.rules[2].resourceNames | join(",")' | tee /dev/stderr)
+  [ "${actual}" = "dc2-kubeconfig,dc3-kubeconfig" ]
+}
+
+#--------------------------------------------------------------------
+# global.enablePodSecurityPolicies
+
+@test "federationSecretRefresh/Role: allows podsecuritypolicies access with global.enablePodSecurityPolicies=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-role.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      --set 'global.enablePodSecurityPolicies=true' \
+      . | tee /dev/stderr |
+      yq -r '.rules | map(select(.resources[0] == "podsecuritypolicies")) | length' | tee /dev/stderr)
+  [ "${actual}" = "1" ]
+}
@@ -0,0 +1,37 @@
+#!/usr/bin/env bats
+
+load _helpers
+
+@test "federationSecretRefresh/RoleBinding: disabled by default" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/federation-secret-refresh-rolebinding.yaml  \
+      .
+}
+
+@test "federationSecretRefresh/RoleBinding: disabled with global.federation.createFederationSecret=true" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/federation-secret-refresh-rolebinding.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      .
+}
+
+@test "federationSecretRefresh/RoleBinding: enabled with global.federation.refreshFederationSecret.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/federation-secret-refresh-rolebinding.yaml  \
+      --set 'global.federation.createFederationSecret=true' \
+      --set 'global.federation.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'global.federation.refreshFederationSecret.enabled=true' \
+      . | tee /dev/stderr |
+      yq 'length > 0' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
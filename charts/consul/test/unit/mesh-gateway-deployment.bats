@@ -1990,3 +1990,30 @@ EOF
       yq -r '.spec.template.metadata.annotations.foo' | tee /dev/stderr)
   [ "${actual}" = "bar" ]
 }
+
+@test "meshGateway/Deployment: acl-init logs in with a projected token when global.acls.authMethod.type=jwt" {
+  cd `chart_dir`
+  local spec=$(helm template \
+      -s templates/mesh-gateway-deployment.yaml \
+      --set 'connectInject.enabled=true' \
+      --set 'meshGateway.enabled=true' \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.authMethod.type=jwt' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec' | tee /dev/stderr)
+
+  local actual=$(echo $spec |
+      yq -r '.volumes[] | select(.name == "consul-acl-auth-method-token") | .projected.sources[0].serviceAccountToken.audience' | tee /dev/stderr)
+  [ "${actual}" = "consul" ]
+
+  local object=$(echo $spec |
+      yq '.initContainers[] | select(.name == "mesh-gateway-init")' | tee /dev/stderr)
+
+  local actual=$(echo $object |
+      yq -r '.volumeMounts[] | select(.name == "consul-acl-auth-method-token") | .mountPath' | tee /dev/stderr)
+  [ "${actual}" = "/consul/acl-auth-method" ]
+
+  local actual=$(echo $object |
+      yq -r '.command | any(contains("-bearer-token-file=/consul/acl-auth-method/token"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
@@ -0,0 +1,39 @@
+#!/usr/bin/env bats
+
+load _helpers
+
+@test "serverACLInit/ClusterRoleBinding: disabled by default" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/server-acl-init-clusterrolebinding.yaml  \
+      .
+}
+
+@test "serverACLInit/ClusterRoleBinding: disabled with global.acls.manageSystemACLs=true" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/server-acl-init-clusterrolebinding.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      .
+}
+
+@test "serverACLInit/ClusterRoleBinding: enabled with global.acls.authMethod.type=jwt" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/server-acl-init-clusterrolebinding.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.authMethod.type=jwt' \
+      . | tee /dev/stderr |
+      yq -r '.roleRef.name' | tee /dev/stderr)
+  [ "${actual}" = "system:service-account-issuer-discovery" ]
+}
+
+@test "serverACLInit/ClusterRoleBinding: disabled with server=false and global.acls.authMethod.type=jwt" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/server-acl-init-clusterrolebinding.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.authMethod.type=jwt' \
+      --set 'server.enabled=false' \
+      .
+}
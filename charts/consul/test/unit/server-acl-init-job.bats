@@ -1882,3 +1882,70 @@ load _helpers
       yq '.spec.template.spec.containers[0].command | any(contains("-federation"))' | tee /dev/stderr)
   [ "${actual}" = "true" ]
 }
+
+#--------------------------------------------------------------------
+# global.acls.authMethod
+
+@test "serverACLInit/Job: -auth-method-type not set by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/server-acl-init-job.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command | any(contains("-auth-method-type"))' | tee /dev/stderr)
+  [ "${actual}" = "false" ]
+}
+
+@test "serverACLInit/Job: jwt auth method flags set when global.acls.authMethod.type=jwt" {
+  cd `chart_dir`
+  local command=$(helm template \
+      -s templates/server-acl-init-job.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.authMethod.type=jwt' \
+      --set 'global.acls.authMethod.jwt.audience=foo' \
+      --set 'global.acls.authMethod.jwt.issuer=https://issuer' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command' | tee /dev/stderr)
+
+  local actual=$(echo $command | jq -r '. | any(contains("-auth-method-type=jwt"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $command | jq -r '. | any(contains("-auth-method-jwt-audience=foo"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $command | jq -r '. | any(contains("-auth-method-jwt-issuer=https://issuer"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "serverACLInit/Job: fails if global.acls.authMethod.type is invalid" {
+  cd `chart_dir`
+  run helm template \
+      -s templates/server-acl-init-job.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.authMethod.type=foo' .
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "global.acls.authMethod.type must be \"kubernetes\" or \"jwt\"" ]]
+}
+
+@test "serverACLInit/Job: fails if global.acls.authMethod.type=jwt and the audience is empty" {
+  cd `chart_dir`
+  run helm template \
+      -s templates/server-acl-init-job.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.authMethod.type=jwt' \
+      --set 'global.acls.authMethod.jwt.audience=' .
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "global.acls.authMethod.jwt.audience must be set if global.acls.authMethod.type is jwt" ]]
+}
+
+@test "serverACLInit/Job: fails if global.acls.authMethod.type=jwt and apiGateway.enabled=true" {
+  cd `chart_dir`
+  run helm template \
+      -s templates/server-acl-init-job.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.authMethod.type=jwt' \
+      --set 'apiGateway.enabled=true' \
+      --set 'apiGateway.image=foo' .
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "global.acls.authMethod.type jwt is not supported with apiGateway.enabled" ]]
+}
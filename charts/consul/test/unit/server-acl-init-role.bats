@@ -78,3 +78,27 @@ load _helpers
       yq -r '.rules | map(select(.resources[0] == "podsecuritypolicies")) | length' | tee /dev/stderr)
   [ "${actual}" = "1" ]
 }
+
+#--------------------------------------------------------------------
+# global.acls.reconcile.enabled
+
+@test "serverACLInit/Role: does not allow events access by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/server-acl-init-role.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      . | tee /dev/stderr |
+      yq -r '.rules | map(select(.resources[0] == "events")) | length' | tee /dev/stderr)
+  [ "${actual}" = "0" ]
+}
+
+@test "serverACLInit/Role: allows events access with global.acls.reconcile.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/server-acl-init-role.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.rules | map(select(.resources[0] == "events")) | .[0].verbs | join(",")' | tee /dev/stderr)
+  [ "${actual}" = "create,patch" ]
+}
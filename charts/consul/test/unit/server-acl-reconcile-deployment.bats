@@ -0,0 +1,264 @@
+#!/usr/bin/env bats
+
+load _helpers
+
+@test "serverACLReconcile/Deployment: disabled by default" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      .
+}
+
+@test "serverACLReconcile/Deployment: disabled with global.acls.manageSystemACLs=true" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      .
+}
+
+@test "serverACLReconcile/Deployment: disabled with global.acls.reconcile.enabled=true and global.acls.manageSystemACLs=false" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.reconcile.enabled=true' \
+      .
+}
+
+@test "serverACLReconcile/Deployment: enabled with global.acls.reconcile.enabled=true and global.acls.manageSystemACLs=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      . | tee /dev/stderr |
+      yq 'length > 0' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "serverACLReconcile/Deployment: disabled with server=false" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      --set 'server.enabled=false' \
+      .
+}
+
+@test "serverACLReconcile/Deployment: enabled with externalServers.enabled=true, but server.enabled set to false" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      --set 'server.enabled=false' \
+      --set 'externalServers.enabled=true' \
+      --set 'externalServers.hosts[0]=foo.com' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command | any(contains("-server-address=\"foo.com\""))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "serverACLReconcile/Deployment: fails with global.secretsBackend.vault.enabled=true" {
+  cd `chart_dir`
+  run helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      --set 'global.acls.bootstrapToken.secretName=foo' \
+      --set 'global.acls.bootstrapToken.secretKey=bar' \
+      --set 'global.secretsBackend.vault.enabled=true' \
+      --set 'global.secretsBackend.vault.consulClientRole=test' \
+      --set 'global.secretsBackend.vault.consulServerRole=foo' \
+      --set 'global.secretsBackend.vault.manageSystemACLsRole=aclrole' .
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "global.acls.reconcile.enabled is not supported when global.secretsBackend.vault.enabled is true" ]]
+}
+
+@test "serverACLReconcile/Deployment: uses the server-acl-init service account" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.spec.template.spec.serviceAccountName' | tee /dev/stderr)
+  [ "${actual}" = "release-name-consul-server-acl-init" ]
+}
+
+#--------------------------------------------------------------------
+# command
+
+@test "serverACLReconcile/Deployment: runs server-acl-init in reconcile mode" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command' | tee /dev/stderr)
+
+  local actual=$(echo $object |
+    yq 'any(contains("-reconcile=true"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq 'any(contains("-reconcile-interval=5m"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq 'any(contains("-pod-name=${POD_NAME}"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq 'any(contains("-set-server-tokens=false"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "serverACLReconcile/Deployment: can set the reconcile interval" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      --set 'global.acls.reconcile.interval=1m' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command | any(contains("-reconcile-interval=1m"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "serverACLReconcile/Deployment: configures the same components as the server-acl-init job" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      --set 'syncCatalog.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command | any(contains("-sync-catalog=true")) and any(contains("-connect-inject=true"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+#--------------------------------------------------------------------
+# bootstrap token
+
+@test "serverACLReconcile/Deployment: mounts the bootstrap token written by the server-acl-init job by default" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec' | tee /dev/stderr)
+
+  local actual=$(echo $object |
+    yq -r '.volumes[] | select(.name == "bootstrap-token") | .secret.secretName' | tee /dev/stderr)
+  [ "${actual}" = "release-name-consul-bootstrap-acl-token" ]
+
+  actual=$(echo $object |
+    yq -r '.volumes[] | select(.name == "bootstrap-token") | .secret.items[0].key' | tee /dev/stderr)
+  [ "${actual}" = "token" ]
+
+  actual=$(echo $object |
+    yq -r '.containers[0].volumeMounts[] | select(.name == "bootstrap-token") | .mountPath' | tee /dev/stderr)
+  [ "${actual}" = "/consul/acl/tokens" ]
+
+  actual=$(echo $object |
+    yq '.containers[0].command | any(contains("-bootstrap-token-file=/consul/acl/tokens/bootstrap-token"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "serverACLReconcile/Deployment: mounts the bootstrap token when global.acls.bootstrapToken is provided" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      --set 'global.acls.bootstrapToken.secretName=name' \
+      --set 'global.acls.bootstrapToken.secretKey=key' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec' | tee /dev/stderr)
+
+  local actual=$(echo $object |
+    yq -r '.volumes[] | select(.name == "bootstrap-token") | .secret.secretName' | tee /dev/stderr)
+  [ "${actual}" = "name" ]
+
+  actual=$(echo $object |
+    yq -r '.volumes[] | select(.name == "bootstrap-token") | .secret.items[0].key' | tee /dev/stderr)
+  [ "${actual}" = "key" ]
+
+  actual=$(echo $object |
+    yq '[.containers[0].command[2] | match("-bootstrap-token-file"; "g")] | length' | tee /dev/stderr)
+  [ "${actual}" = "1" ]
+}
+
+@test "serverACLReconcile/Deployment: mounts the replication token when global.acls.replicationToken is provided" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      --set 'global.acls.replicationToken.secretName=name' \
+      --set 'global.acls.replicationToken.secretKey=key' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec' | tee /dev/stderr)
+
+  local actual=$(echo $object |
+    yq -r '.volumes[] | select(.name == "acl-replication-token") | .secret.secretName' | tee /dev/stderr)
+  [ "${actual}" = "name" ]
+
+  actual=$(echo $object |
+    yq -r '.containers[0].volumeMounts[] | select(.name == "acl-replication-token") | .mountPath' | tee /dev/stderr)
+  [ "${actual}" = "/consul/acl/tokens" ]
+
+  actual=$(echo $object |
+    yq '.containers[0].command | any(contains("-acl-replication-token-file=/consul/acl/tokens/acl-replication-token"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq '.containers[0].command | any(contains("-bootstrap-token-file"))' | tee /dev/stderr)
+  [ "${actual}" = "false" ]
+}
+
+#--------------------------------------------------------------------
+# global.tls.enabled
+
+@test "serverACLReconcile/Deployment: sets TLS flags when global.tls.enabled=true" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      --set 'global.tls.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec' | tee /dev/stderr)
+
+  local actual=$(echo $object |
+    yq '.containers[0].command | any(contains("-use-https"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq '.containers[0].command | any(contains("-consul-ca-cert=/consul/tls/ca/tls.crt"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq -r '.volumes[] | select(.name == "consul-ca-cert") | .secret.secretName' | tee /dev/stderr)
+  [ "${actual}" = "release-name-consul-ca-cert" ]
+}
+
+#--------------------------------------------------------------------
+# global.metrics.enabled
+
+@test "serverACLReconcile/Deployment: adds prometheus annotations when global.metrics.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/server-acl-reconcile-deployment.yaml  \
+      --set 'global.acls.manageSystemACLs=true' \
+      --set 'global.acls.reconcile.enabled=true' \
+      --set 'global.metrics.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.spec.template.metadata.annotations["prometheus.io/port"]' | tee /dev/stderr)
+  [ "${actual}" = "8080" ]
+}
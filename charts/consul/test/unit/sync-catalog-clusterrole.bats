@@ -84,3 +84,188 @@ load _helpers
       yq -c '.rules[0].verbs' | tee /dev/stderr)
   [ "${actual}" = '["get","list","watch","update","patch","delete","create"]' ]
 }
+
+#--------------------------------------------------------------------
+# syncCatalog.k8sServiceType
+
+@test "syncCatalog/ClusterRole: no endpointslices access with k8sServiceType=ExternalName" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.rules | map(select(.resources[0] == "endpointslices")) | length' | tee /dev/stderr)
+  [ "${actual}" = "0" ]
+}
+
+@test "syncCatalog/ClusterRole: allows endpointslices access with k8sServiceType=ClusterIP" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      --set 'syncCatalog.k8sServiceType=ClusterIP' \
+      . | tee /dev/stderr |
+      yq -c '.rules[] | select(.resources[0] == "endpointslices") | .verbs' | tee /dev/stderr)
+  [ "${actual}" = '["get","list","create","update","delete"]' ]
+}
+
+@test "syncCatalog/ClusterRole: no endpointslices access with toK8S=false" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      --set 'syncCatalog.toK8S=false' \
+      --set 'syncCatalog.k8sServiceType=ClusterIP' \
+      . | tee /dev/stderr |
+      yq '.rules | map(select(.resources[0] == "endpointslices")) | length' | tee /dev/stderr)
+  [ "${actual}" = "0" ]
+}
+
+@test "syncCatalog/ClusterRole: allows watching endpointslices with syncEndpointSlices=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      --set 'syncCatalog.syncEndpointSlices=true' \
+      . | tee /dev/stderr |
+      yq -c '.rules[] | select(.resources[0] == "endpointslices") | .verbs' | tee /dev/stderr)
+  [ "${actual}" = '["get","list","watch"]' ]
+}
+
+@test "syncCatalog/ClusterRole: no endpointslices watch with syncEndpointSlices=true and toConsul=false" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      --set 'syncCatalog.toConsul=false' \
+      --set 'syncCatalog.syncEndpointSlices=true' \
+      . | tee /dev/stderr |
+      yq '.rules | map(select(.resources[0] == "endpointslices")) | length' | tee /dev/stderr)
+  [ "${actual}" = "0" ]
+}
+
+#--------------------------------------------------------------------
+# syncCatalog.toK8SFilter.configMapName
+
+@test "syncCatalog/ClusterRole: no configmaps access by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.rules | map(select(.resources[0] == "configmaps")) | length' | tee /dev/stderr)
+  [ "${actual}" = "0" ]
+}
+
+@test "syncCatalog/ClusterRole: allows configmaps access with toK8SFilter.configMapName" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      --set 'syncCatalog.toK8SFilter.configMapName=sync-filter' \
+      . | tee /dev/stderr |
+      yq -c '.rules[] | select(.resources[0] == "configmaps") | .verbs' | tee /dev/stderr)
+  [ "${actual}" = '["get","list","watch"]' ]
+}
+
+#--------------------------------------------------------------------
+# syncCatalog.syncedServices.enabled
+
+@test "syncCatalog/ClusterRole: no syncedservices access by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.rules | map(select(.apiGroups[0] == "consul.hashicorp.com")) | length' | tee /dev/stderr)
+  [ "${actual}" = "0" ]
+}
+
+@test "syncCatalog/ClusterRole: allows syncedservices access with syncedServices.enabled" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      --set 'syncCatalog.syncedServices.enabled=true' \
+      . | tee /dev/stderr |
+      yq -c '.rules | map(select(.apiGroups[0] == "consul.hashicorp.com"))' | tee /dev/stderr)
+
+  local actual=$(echo $object | yq -r '.[0].resources[0]' | tee /dev/stderr)
+  [ "${actual}" = "syncedservices" ]
+
+  local actual=$(echo $object | yq -r '.[1].resources[0]' | tee /dev/stderr)
+  [ "${actual}" = "syncedservices/status" ]
+
+  local actual=$(echo $object | yq -c '.[1].verbs' | tee /dev/stderr)
+  [ "${actual}" = '["get","update","patch"]' ]
+}
+
+#--------------------------------------------------------------------
+# syncCatalog.leaderElection.enabled
+
+@test "syncCatalog/ClusterRole: no leases access by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.rules | map(select(.apiGroups[0] == "coordination.k8s.io")) | length' | tee /dev/stderr)
+  [ "${actual}" = "0" ]
+}
+
+@test "syncCatalog/ClusterRole: allows leases access with leaderElection.enabled" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      --set 'syncCatalog.leaderElection.enabled=true' \
+      . | tee /dev/stderr |
+      yq -c '.rules | map(select(.apiGroups[0] == "coordination.k8s.io")) | .[0]' | tee /dev/stderr)
+
+  local actual=$(echo $object | yq -r '.resources[0]' | tee /dev/stderr)
+  [ "${actual}" = "leases" ]
+
+  local actual=$(echo $object | yq -c '.verbs' | tee /dev/stderr)
+  [ "${actual}" = '["get","create","update"]' ]
+}
+
+#--------------------------------------------------------------------
+# syncCatalog.ingress.enabled
+
+@test "syncCatalog/ClusterRole: no ingresses access by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.rules | map(select(.apiGroups[0] == "networking.k8s.io")) | length' | tee /dev/stderr)
+  [ "${actual}" = "0" ]
+}
+
+@test "syncCatalog/ClusterRole: allows ingresses access with ingress.enabled" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      --set 'syncCatalog.ingress.enabled=true' \
+      . | tee /dev/stderr |
+      yq -c '.rules | map(select(.apiGroups[0] == "networking.k8s.io")) | .[0]' | tee /dev/stderr)
+
+  local actual=$(echo $object | yq -r '.resources[0]' | tee /dev/stderr)
+  [ "${actual}" = "ingresses" ]
+
+  local actual=$(echo $object | yq -c '.verbs' | tee /dev/stderr)
+  [ "${actual}" = '["get","list","watch"]' ]
+}
+
+@test "syncCatalog/ClusterRole: no ingresses access with ingress.enabled and toConsul=false" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/sync-catalog-clusterrole.yaml  \
+      --set 'syncCatalog.enabled=true' \
+      --set 'syncCatalog.toConsul=false' \
+      --set 'syncCatalog.ingress.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.rules | map(select(.apiGroups[0] == "networking.k8s.io")) | length' | tee /dev/stderr)
+  [ "${actual}" = "0" ]
+}
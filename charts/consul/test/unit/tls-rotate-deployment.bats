@@ -0,0 +1,191 @@
+#!/usr/bin/env bats
+
+load _helpers
+
+@test "tlsRotate/Deployment: disabled by default" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      .
+}
+
+@test "tlsRotate/Deployment: disabled with global.tls.enabled=true" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      --set 'global.tls.enabled=true' \
+      .
+}
+
+@test "tlsRotate/Deployment: disabled with global.tls.serverCertRotation.enabled=true and global.tls.enabled=false" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      .
+}
+
+@test "tlsRotate/Deployment: enabled with global.tls.enabled=true and global.tls.serverCertRotation.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      . | tee /dev/stderr |
+      yq 'length > 0' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "tlsRotate/Deployment: disabled when server.enabled=false" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'server.enabled=false' \
+      .
+}
+
+@test "tlsRotate/Deployment: fails with server.serverCert.secretName!=null" {
+  cd `chart_dir`
+  run helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'global.tls.caCert.secretName=test' \
+      --set 'server.serverCert.secretName=test' \
+      .
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "global.tls.serverCertRotation.enabled is not supported when server.serverCert.secretName is set" ]]
+}
+
+@test "tlsRotate/Deployment: fails with global.secretsBackend.vault.enabled=true" {
+  cd `chart_dir`
+  run helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'global.tls.caCert.secretName=test' \
+      --set 'global.secretsBackend.vault.enabled=true' \
+      --set 'global.secretsBackend.vault.consulClientRole=foo' \
+      --set 'global.secretsBackend.vault.consulServerRole=test' \
+      --set 'global.secretsBackend.vault.consulCARole=test' \
+      .
+  [ "$status" -eq 1 ]
+  [[ "$output" =~ "global.tls.serverCertRotation.enabled is not supported when global.secretsBackend.vault.enabled is true" ]]
+}
+
+@test "tlsRotate/Deployment: uses the tls-rotate service account" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.spec.template.spec.serviceAccountName' | tee /dev/stderr)
+  [ "${actual}" = "release-name-consul-tls-rotate" ]
+}
+
+#--------------------------------------------------------------------
+# command
+
+@test "tlsRotate/Deployment: runs tls-init in rotate mode" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command' | tee /dev/stderr)
+
+  local actual=$(echo $object |
+    yq 'any(contains("-rotate=true"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq 'any(contains("-rotate-interval=1h"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq 'any(contains("-renew-before=720h"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq 'any(contains("-server-statefulset=release-name-consul-server"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "tlsRotate/Deployment: can set the rotation interval and renew before" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'global.tls.serverCertRotation.interval=10m' \
+      --set 'global.tls.serverCertRotation.renewBefore=48h' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command' | tee /dev/stderr)
+
+  local actual=$(echo $object |
+    yq 'any(contains("-rotate-interval=10m"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+
+  actual=$(echo $object |
+    yq 'any(contains("-renew-before=48h"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "tlsRotate/Deployment: sets the same SANs as the tls-init job" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'global.tls.serverAdditionalDNSSANs[0]=example.com' \
+      --set 'global.tls.serverAdditionalIPSANs[0]=1.1.1.1' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec.containers[0].command | any(contains("-additional-dnsname=example.com")) and any(contains("-additional-ipaddress=1.1.1.1"))' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+#--------------------------------------------------------------------
+# global.tls.caCert and global.tls.caKey
+
+@test "tlsRotate/Deployment: mounts the CA when global.tls.caCert and global.tls.caKey are provided" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'global.tls.caCert.secretName=foo-ca-cert' \
+      --set 'global.tls.caKey.secretName=foo-ca-key' \
+      . | tee /dev/stderr |
+      yq '.spec.template.spec' | tee /dev/stderr)
+
+  local actual=$(echo $object |
+    yq -r '.volumes | map(select(.name == "consul-ca-cert")) | .[0].secret.secretName' | tee /dev/stderr)
+  [ "${actual}" = "foo-ca-cert" ]
+
+  actual=$(echo $object |
+    yq -r '.volumes | map(select(.name == "consul-ca-key")) | .[0].secret.secretName' | tee /dev/stderr)
+  [ "${actual}" = "foo-ca-key" ]
+
+  actual=$(echo $object |
+    yq -r '.containers[0].volumeMounts | length' | tee /dev/stderr)
+  [ "${actual}" = "2" ]
+}
+
+#--------------------------------------------------------------------
+# global.metrics.enabled
+
+@test "tlsRotate/Deployment: adds prometheus annotations when global.metrics.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/tls-rotate-deployment.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'global.metrics.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.spec.template.metadata.annotations["prometheus.io/port"]' | tee /dev/stderr)
+  [ "${actual}" = "8080" ]
+}
@@ -0,0 +1,31 @@
+#!/usr/bin/env bats
+
+load _helpers
+
+@test "tlsRotate/PodSecurityPolicy: disabled by default" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-podsecuritypolicy.yaml  \
+      .
+}
+
+@test "tlsRotate/PodSecurityPolicy: disabled with global.tls.serverCertRotation.enabled=true" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-podsecuritypolicy.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      .
+}
+
+@test "tlsRotate/PodSecurityPolicy: enabled with global.tls.serverCertRotation.enabled=true and global.enablePodSecurityPolicies=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/tls-rotate-podsecuritypolicy.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'global.enablePodSecurityPolicies=true' \
+      . | tee /dev/stderr |
+      yq -s 'length > 0' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
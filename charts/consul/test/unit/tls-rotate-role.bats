@@ -0,0 +1,75 @@
+#!/usr/bin/env bats
+
+load _helpers
+
+@test "tlsRotate/Role: disabled by default" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-role.yaml  \
+      .
+}
+
+@test "tlsRotate/Role: disabled with global.tls.enabled=true" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-role.yaml  \
+      --set 'global.tls.enabled=true' \
+      .
+}
+
+@test "tlsRotate/Role: disabled when server.enabled=false" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-role.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'server.enabled=false' \
+      .
+}
+
+@test "tlsRotate/Role: enabled with global.tls.enabled=true and global.tls.serverCertRotation.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/tls-rotate-role.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      . | tee /dev/stderr |
+      yq 'length > 0' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
+
+@test "tlsRotate/Role: does not grant access to the CA Secrets when global.tls.caCert and global.tls.caKey are provided" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/tls-rotate-role.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'global.tls.caCert.secretName=foo-ca-cert' \
+      --set 'global.tls.caKey.secretName=foo-ca-key' \
+      . | tee /dev/stderr |
+      yq -r '.rules[0].resourceNames | join(",")' | tee /dev/stderr)
+  [ "${actual}" = "release-name-consul-server-cert" ]
+}
+
+@test "tlsRotate/Role: allows patching the server statefulset" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/tls-rotate-role.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.rules[1].resourceNames[0] + ":" + (.rules[1].verbs | join(","))' | tee /dev/stderr)
+  [ "${actual}" = "release-name-consul-server:get,patch" ]
+}
+
+@test "tlsRotate/Role: allows podsecuritypolicies access with global.enablePodSecurityPolicies=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/tls-rotate-role.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'global.enablePodSecurityPolicies=true' \
+      . | tee /dev/stderr |
+      yq -r '.rules[2].resources[0]' | tee /dev/stderr)
+  [ "${actual}" = "podsecuritypolicies" ]
+}
@@ -0,0 +1,39 @@
+#!/usr/bin/env bats
+
+load _helpers
+
+@test "tlsRotate/RoleBinding: disabled by default" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-rolebinding.yaml  \
+      .
+}
+
+@test "tlsRotate/RoleBinding: disabled with global.tls.enabled=true" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-rolebinding.yaml  \
+      --set 'global.tls.enabled=true' \
+      .
+}
+
+@test "tlsRotate/RoleBinding: disabled when server.enabled=false" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-rolebinding.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'server.enabled=false' \
+      .
+}
+
+@test "tlsRotate/RoleBinding: enabled with global.tls.enabled=true and global.tls.serverCertRotation.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/tls-rotate-rolebinding.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      . | tee /dev/stderr |
+      yq 'length > 0' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
@@ -0,0 +1,39 @@
+#!/usr/bin/env bats
+
+load _helpers
+
+@test "tlsRotate/ServiceAccount: disabled by default" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-serviceaccount.yaml  \
+      .
+}
+
+@test "tlsRotate/ServiceAccount: disabled with global.tls.enabled=true" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-serviceaccount.yaml  \
+      --set 'global.tls.enabled=true' \
+      .
+}
+
+@test "tlsRotate/ServiceAccount: disabled when server.enabled=false" {
+  cd `chart_dir`
+  assert_empty helm template \
+      -s templates/tls-rotate-serviceaccount.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      --set 'server.enabled=false' \
+      .
+}
+
+@test "tlsRotate/ServiceAccount: enabled with global.tls.enabled=true and global.tls.serverCertRotation.enabled=true" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/tls-rotate-serviceaccount.yaml  \
+      --set 'global.tls.enabled=true' \
+      --set 'global.tls.serverCertRotation.enabled=true' \
+      . | tee /dev/stderr |
+      yq 'length > 0' | tee /dev/stderr)
+  [ "${actual}" = "true" ]
+}
@@ -127,6 +127,87 @@ load _helpers
   [ "${actual}" != null ]
 }
 
+#--------------------------------------------------------------------
+# validatingWebhookConfigNames and crdNames
+
+@test "webhookCertManager/ClusterRole: no access to validatingwebhookconfigurations or customresourcedefinitions by default" {
+  cd `chart_dir`
+  local actual=$(helm template \
+      -s templates/webhook-cert-manager-clusterrole.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      . | tee /dev/stderr |
+      yq '[.rules[].resources[0]] | any(. == "validatingwebhookconfigurations" or . == "customresourcedefinitions")' | tee /dev/stderr)
+  [ "${actual}" = "false" ]
+}
+
+@test "webhookCertManager/ClusterRole: sets get and patch access to the configured validatingwebhookconfigurations" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/webhook-cert-manager-clusterrole.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'webhookCertManager.connectInject.validatingWebhookConfigNames[0]=inject-validating' \
+      --set 'webhookCertManager.controller.validatingWebhookConfigNames[0]=controller-validating' \
+      . | tee /dev/stderr |
+      yq -r '.rules[2]' | tee /dev/stderr)
+
+  local actual=$(echo $object | yq -r '.resources[0]' | tee /dev/stderr)
+  [ "${actual}" = "validatingwebhookconfigurations" ]
+
+  local actual=$(echo $object | yq -r '.apiGroups[0]' | tee /dev/stderr)
+  [ "${actual}" = "admissionregistration.k8s.io" ]
+
+  local actual=$(echo $object | yq -c '.resourceNames' | tee /dev/stderr)
+  [ "${actual}" = '["inject-validating","controller-validating"]' ]
+
+  local actual=$(echo $object | yq -c '.verbs' | tee /dev/stderr)
+  [ "${actual}" = '["get","patch"]' ]
+}
+
+@test "webhookCertManager/ClusterRole: sets get and patch access to the configured customresourcedefinitions" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/webhook-cert-manager-clusterrole.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'webhookCertManager.connectInject.crdNames[0]=foos.example.com' \
+      --set 'webhookCertManager.controller.crdNames[0]=bars.example.com' \
+      . | tee /dev/stderr |
+      yq -r '.rules[2]' | tee /dev/stderr)
+
+  local actual=$(echo $object | yq -r '.resources[0]' | tee /dev/stderr)
+  [ "${actual}" = "customresourcedefinitions" ]
+
+  local actual=$(echo $object | yq -r '.apiGroups[0]' | tee /dev/stderr)
+  [ "${actual}" = "apiextensions.k8s.io" ]
+
+  local actual=$(echo $object | yq -c '.resourceNames' | tee /dev/stderr)
+  [ "${actual}" = '["foos.example.com","bars.example.com"]' ]
+
+  local actual=$(echo $object | yq -c '.verbs' | tee /dev/stderr)
+  [ "${actual}" = '["get","patch"]' ]
+}
+
+@test "webhookCertManager/ClusterRole: ignores the names of a disabled component" {
+  cd `chart_dir`
+  local object=$(helm template \
+      -s templates/webhook-cert-manager-clusterrole.yaml  \
+      --set 'controller.enabled=false' \
+      --set 'connectInject.enabled=true' \
+      --set 'webhookCertManager.connectInject.validatingWebhookConfigNames[0]=inject-validating' \
+      --set 'webhookCertManager.controller.validatingWebhookConfigNames[0]=controller-validating' \
+      --set 'webhookCertManager.controller.crdNames[0]=bars.example.com' \
+      . | tee /dev/stderr |
+      yq -c '.rules' | tee /dev/stderr)
+
+  local actual=$(echo $object | yq -c '.[2].resourceNames' | tee /dev/stderr)
+  [ "${actual}" = '["inject-validating"]' ]
+
+  local actual=$(echo $object | yq '[.[].resources[0]] | any(. == "customresourcedefinitions")' | tee /dev/stderr)
+  [ "${actual}" = "false" ]
+}
+
 #--------------------------------------------------------------------
 # global.enablePodSecurityPolicies
 
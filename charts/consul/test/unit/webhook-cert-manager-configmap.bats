@@ -92,6 +92,130 @@ load _helpers
   [ "${actual}" = "true" ]
 }
 
+#--------------------------------------------------------------------
+# externalCerts
+
+@test "webhookCertManager/Configmap: webhook certificates are generated by default" {
+  cd `chart_dir`
+  local cfg=$(helm template \
+      -s templates/webhook-cert-manager-configmap.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.data["webhook-config.json"]' | tee /dev/stderr)
+
+  local actual=$(echo $cfg | jq -r '.[0].secretName')
+  [ "${actual}" = "release-name-consul-connect-inject-webhook-cert" ]
+
+  local actual=$(echo $cfg | jq -r '.[1].secretName')
+  [ "${actual}" = "release-name-consul-controller-webhook-cert" ]
+
+  local actual=$(echo $cfg | jq '[.[] | has("externalCert")] | any')
+  [ "${actual}" = "false" ]
+}
+
+@test "webhookCertManager/Configmap: can set externalCerts.connectInject.secretName" {
+  cd `chart_dir`
+  local cfg=$(helm template \
+      -s templates/webhook-cert-manager-configmap.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'webhookCertManager.externalCerts.connectInject.secretName=inject-cert' \
+      . | tee /dev/stderr |
+      yq -r '.data["webhook-config.json"]' | tee /dev/stderr)
+
+  local actual=$(echo $cfg | jq -r '.[0].secretName')
+  [ "${actual}" = "inject-cert" ]
+
+  local actual=$(echo $cfg | jq '.[0].externalCert')
+  [ "${actual}" = "true" ]
+
+  local actual=$(echo $cfg | jq -r '.[1].secretName')
+  [ "${actual}" = "release-name-consul-controller-webhook-cert" ]
+
+  local actual=$(echo $cfg | jq '.[1] | has("externalCert")')
+  [ "${actual}" = "false" ]
+}
+
+@test "webhookCertManager/Configmap: can set externalCerts.controller.secretName" {
+  cd `chart_dir`
+  local cfg=$(helm template \
+      -s templates/webhook-cert-manager-configmap.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'webhookCertManager.externalCerts.controller.secretName=controller-cert' \
+      . | tee /dev/stderr |
+      yq -r '.data["webhook-config.json"]' | tee /dev/stderr)
+
+  local actual=$(echo $cfg | jq -r '.[1].secretName')
+  [ "${actual}" = "controller-cert" ]
+
+  local actual=$(echo $cfg | jq '.[1].externalCert')
+  [ "${actual}" = "true" ]
+
+  local actual=$(echo $cfg | jq '.[0] | has("externalCert")')
+  [ "${actual}" = "false" ]
+}
+
+#--------------------------------------------------------------------
+# validatingWebhookConfigNames and crdNames
+
+@test "webhookCertManager/Configmap: no validating webhook configurations or CRDs by default" {
+  cd `chart_dir`
+  local cfg=$(helm template \
+      -s templates/webhook-cert-manager-configmap.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      . | tee /dev/stderr |
+      yq -r '.data["webhook-config.json"]' | tee /dev/stderr)
+
+  local actual=$(echo $cfg | jq '[.[] | has("validatingWebhookConfigNames") or has("crdNames")] | any')
+  [ "${actual}" = "false" ]
+}
+
+@test "webhookCertManager/Configmap: can set connectInject.validatingWebhookConfigNames and connectInject.crdNames" {
+  cd `chart_dir`
+  local cfg=$(helm template \
+      -s templates/webhook-cert-manager-configmap.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'webhookCertManager.connectInject.validatingWebhookConfigNames[0]=inject-validating' \
+      --set 'webhookCertManager.connectInject.crdNames[0]=foos.example.com' \
+      . | tee /dev/stderr |
+      yq -r '.data["webhook-config.json"]' | tee /dev/stderr)
+
+  local actual=$(echo $cfg | jq -c '.[0].validatingWebhookConfigNames')
+  [ "${actual}" = '["inject-validating"]' ]
+
+  local actual=$(echo $cfg | jq -c '.[0].crdNames')
+  [ "${actual}" = '["foos.example.com"]' ]
+
+  local actual=$(echo $cfg | jq '.[1] | has("validatingWebhookConfigNames") or has("crdNames")')
+  [ "${actual}" = "false" ]
+}
+
+@test "webhookCertManager/Configmap: can set controller.validatingWebhookConfigNames and controller.crdNames" {
+  cd `chart_dir`
+  local cfg=$(helm template \
+      -s templates/webhook-cert-manager-configmap.yaml  \
+      --set 'controller.enabled=true' \
+      --set 'connectInject.enabled=true' \
+      --set 'webhookCertManager.controller.validatingWebhookConfigNames[0]=controller-validating' \
+      --set 'webhookCertManager.controller.crdNames[0]=bars.example.com' \
+      --set 'webhookCertManager.controller.crdNames[1]=bazs.example.com' \
+      . | tee /dev/stderr |
+      yq -r '.data["webhook-config.json"]' | tee /dev/stderr)
+
+  local actual=$(echo $cfg | jq -c '.[1].validatingWebhookConfigNames')
+  [ "${actual}" = '["controller-validating"]' ]
+
+  local actual=$(echo $cfg | jq -c '.[1].crdNames')
+  [ "${actual}" = '["bars.example.com","bazs.example.com"]' ]
+
+  local actual=$(echo $cfg | jq '.[0] | has("validatingWebhookConfigNames") or has("crdNames")')
+  [ "${actual}" = "false" ]
+}
+
 #--------------------------------------------------------------------
 # Vault
 
@@ -0,0 +1,26 @@
+package acl
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-k8s/cli/common"
+	"github.com/mitchellh/cli"
+)
+
+// ACLCommand provides a synopsis for the acl subcommands (e.g. rotate-bootstrap-token).
+type ACLCommand struct {
+	*common.BaseCommand
+}
+
+// Run prints out information about the subcommands.
+func (c *ACLCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *ACLCommand) Help() string {
+	return fmt.Sprintf("%s\n\nUsage: consul-k8s acl <subcommand>", c.Synopsis())
+}
+
+func (c *ACLCommand) Synopsis() string {
+	return "Manage the ACL system of a Consul installation."
+}
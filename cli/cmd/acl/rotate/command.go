@@ -0,0 +1,297 @@
+package rotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/cli/common"
+	"github.com/hashicorp/consul-k8s/cli/common/flag"
+	"github.com/hashicorp/consul-k8s/cli/common/terminal"
+	helmCLI "helm.sh/helm/v3/pkg/cli"
+	apiv1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/validation"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// consulAPITimeout is how long the Job waits for a response from a
+	// server.
+	consulAPITimeout = 10 * time.Second
+
+	// caCertMountPath is where the CA certificate Secret is mounted in the
+	// Job.
+	caCertMountPath = "/consul/tls/ca"
+)
+
+// RotateBootstrapTokenCommand is the command struct for the acl
+// rotate-bootstrap-token command.
+type RotateBootstrapTokenCommand struct {
+	*common.BaseCommand
+
+	kubernetes kubernetes.Interface
+
+	set *flag.Sets
+
+	// Command Flags
+	flagNamespace        string
+	flagReleaseName      string
+	flagSecretName       string
+	flagSecretKey        string
+	flagCACertSecretName string
+	flagCACertSecretKey  string
+	flagImage            string
+	flagTimeout          time.Duration
+
+	// Global Flags
+	flagKubeConfig  string
+	flagKubeContext string
+
+	// pollInterval is how often the status of the rotation Job is checked.
+	// It is exposed for setting in tests.
+	pollInterval time.Duration
+
+	once sync.Once
+	help string
+}
+
+// init sets up flags and help text for the command.
+func (c *RotateBootstrapTokenCommand) init() {
+	c.set = flag.NewSets()
+
+	f := c.set.NewSet("Command Options")
+	f.StringVar(&flag.StringVar{
+		Name:    "namespace",
+		Target:  &c.flagNamespace,
+		Usage:   "The namespace where Consul is installed.",
+		Aliases: []string{"n"},
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "release-name",
+		Target: &c.flagReleaseName,
+		Usage:  "The name of the Helm release of Consul. Required if the namespace has several Consul installations.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "secret-name",
+		Target: &c.flagSecretName,
+		Usage:  "The name of the Secret that stores the bootstrap token. Defaults to the Secret created by the server-acl-init job.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:    "secret-key",
+		Target:  &c.flagSecretKey,
+		Default: "token",
+		Usage:   "The key of the bootstrap token in the Secret.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "ca-cert-secret-name",
+		Target: &c.flagCACertSecretName,
+		Usage:  "The name of the Secret that stores the CA certificate of the servers when TLS is enabled. Defaults to the Secret created by the tls-init job.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:    "ca-cert-secret-key",
+		Target:  &c.flagCACertSecretKey,
+		Default: "tls.crt",
+		Usage:   "The key of the CA certificate in the Secret.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "image",
+		Target: &c.flagImage,
+		Usage:  "The consul-k8s-control-plane image of the Job that rotates the token. Defaults to global.imageK8S of the Helm chart of this CLI.",
+	})
+	f.DurationVar(&flag.DurationVar{
+		Name:    "timeout",
+		Target:  &c.flagTimeout,
+		Default: 5 * time.Minute,
+		Usage:   "How long to wait for the rotation to complete.",
+	})
+
+	f = c.set.NewSet("Global Options")
+	f.StringVar(&flag.StringVar{
+		Name:    "kubeconfig",
+		Aliases: []string{"c"},
+		Target:  &c.flagKubeConfig,
+		Default: "",
+		Usage:   "Set the path to kubeconfig file.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:    "context",
+		Target:  &c.flagKubeContext,
+		Default: "",
+		Usage:   "Set the Kubernetes context to use.",
+	})
+
+	c.help = c.set.Help()
+}
+
+// Run executes the rotate-bootstrap-token command.
+func (c *RotateBootstrapTokenCommand) Run(args []string) int {
+	c.once.Do(c.init)
+	c.Log.ResetNamed("rotate-bootstrap-token")
+	defer common.CloseWithError(c.BaseCommand)
+
+	if err := c.set.Parse(args); err != nil {
+		c.UI.Output(err.Error(), terminal.WithErrorStyle())
+		c.UI.Output("\n" + c.Help())
+		return 1
+	}
+
+	if err := c.validateFlags(); err != nil {
+		c.UI.Output(err.Error(), terminal.WithErrorStyle())
+		c.UI.Output("\n" + c.Help())
+		return 1
+	}
+
+	if err := c.initKubernetes(); err != nil {
+		c.UI.Output("Error initializing Kubernetes client: %v", err, terminal.WithErrorStyle())
+		return 1
+	}
+
+	if err := c.rotate(); err != nil {
+		c.UI.Output(err.Error(), terminal.WithErrorStyle())
+		return 1
+	}
+	return 0
+}
+
+// Help returns a description of the command and how it is used.
+func (c *RotateBootstrapTokenCommand) Help() string {
+	c.once.Do(c.init)
+	return fmt.Sprintf("%s\n\nUsage: consul-k8s acl rotate-bootstrap-token [flags]\n\n"+
+		"  A Job in the namespace of Consul creates a new management token and verifies it\n"+
+		"  against every server before it replaces the token in the bootstrap Secret. The\n"+
+		"  old token is revoked afterwards. If the rotation is interrupted, running the\n"+
+		"  command again resumes it.\n\n%s",
+		c.Synopsis(), c.help)
+}
+
+// Synopsis returns a one-line command summary.
+func (c *RotateBootstrapTokenCommand) Synopsis() string {
+	return "Rotate the ACL bootstrap token of a Consul installation."
+}
+
+// validateFlags ensures that the flags passed in by the user can be used.
+func (c *RotateBootstrapTokenCommand) validateFlags() error {
+	if len(c.set.Args()) > 0 {
+		return errors.New("should have no non-flag arguments")
+	}
+	if errs := validation.ValidateNamespaceName(c.flagNamespace, false); c.flagNamespace != "" && len(errs) > 0 {
+		return fmt.Errorf("invalid namespace name passed for -namespace/-n: %v", strings.Join(errs, "; "))
+	}
+	if c.flagSecretKey == "" || c.flagCACertSecretKey == "" {
+		return errors.New("-secret-key and -ca-cert-secret-key must not be empty")
+	}
+	if c.flagTimeout <= 0 {
+		return errors.New("-timeout must be greater than 0")
+	}
+	return nil
+}
+
+// initKubernetes initializes the Kubernetes client unless it was set in a test.
+func (c *RotateBootstrapTokenCommand) initKubernetes() (err error) {
+	settings := helmCLI.New()
+
+	if c.flagKubeConfig != "" {
+		settings.KubeConfig = c.flagKubeConfig
+	}
+
+	if c.flagKubeContext != "" {
+		settings.KubeContext = c.flagKubeContext
+	}
+
+	if c.flagNamespace == "" {
+		c.flagNamespace = settings.Namespace()
+	}
+
+	if c.kubernetes != nil {
+		return nil
+	}
+
+	restConfig, err := settings.RESTClientGetter().ToRESTConfig()
+	if err != nil {
+		return fmt.Errorf("error creating Kubernetes REST config %v", err)
+	}
+	if c.kubernetes, err = kubernetes.NewForConfig(restConfig); err != nil {
+		return fmt.Errorf("error creating Kubernetes client %v", err)
+	}
+	return nil
+}
+
+// rotate finds the servers and runs the rotate-bootstrap-token subcommand of
+// the control plane against them in a Job.
+func (c *RotateBootstrapTokenCommand) rotate() error {
+	pods, fullName, err := common.ServerPods(c.Ctx, c.kubernetes, c.flagNamespace, c.flagReleaseName)
+	if err != nil {
+		return err
+	}
+
+	caCertSecretName := c.flagCACertSecretName
+	if caCertSecretName == "" {
+		caCertSecretName = fullName + "-ca-cert"
+	}
+	https, err := common.ServerUsesHTTPS(c.Ctx, c.kubernetes, pods[0], caCertSecretName, c.flagCACertSecretKey)
+	if err != nil {
+		return err
+	}
+
+	image := c.flagImage
+	if image == "" {
+		if image, err = common.DefaultImageK8S(); err != nil {
+			return fmt.Errorf("error reading the default image, set -image: %v", err)
+		}
+	}
+
+	secretName := c.flagSecretName
+	if secretName == "" {
+		secretName = fullName + "-bootstrap-acl-token"
+	}
+
+	job := &common.ControlPlaneJob{
+		Name:             fullName + "-rotate-bootstrap-token",
+		Namespace:        c.flagNamespace,
+		Image:            image,
+		ImagePullSecrets: pods[0].Spec.ImagePullSecrets,
+		Args: []string{
+			"rotate-bootstrap-token",
+			"-k8s-namespace=" + c.flagNamespace,
+			"-bootstrap-token-secret-name=" + secretName,
+			"-bootstrap-token-secret-key=" + c.flagSecretKey,
+			"-consul-api-timeout=" + consulAPITimeout.String(),
+			"-timeout=" + c.flagTimeout.String(),
+		},
+		SecretNames:  []string{secretName},
+		Timeout:      c.flagTimeout,
+		PollInterval: c.pollInterval,
+	}
+	for _, pod := range pods {
+		job.Args = append(job.Args, "-server-address="+common.ServerAddress(pod, fullName))
+	}
+	if https {
+		job.Args = append(job.Args,
+			"-use-https",
+			fmt.Sprintf("-server-port=%d", common.ServerHTTPSPort),
+			"-consul-ca-cert="+caCertMountPath+"/"+c.flagCACertSecretKey)
+		job.Volumes = []apiv1.Volume{{
+			Name:         "consul-ca-cert",
+			VolumeSource: apiv1.VolumeSource{Secret: &apiv1.SecretVolumeSource{SecretName: caCertSecretName}},
+		}}
+		job.VolumeMounts = []apiv1.VolumeMount{{Name: "consul-ca-cert", MountPath: caCertMountPath, ReadOnly: true}}
+	}
+
+	c.UI.Output("Rotating the bootstrap token in Secret %s/%s", c.flagNamespace, secretName, terminal.WithHeaderStyle())
+
+	ctx, cancel := context.WithTimeout(c.Ctx, c.flagTimeout)
+	defer cancel()
+	logs, err := job.Run(ctx, c.kubernetes)
+	if logs != "" {
+		c.UI.Output(logs)
+	}
+	if err != nil {
+		return fmt.Errorf("error rotating the bootstrap token: %v\nRun the command again to resume the rotation.", err)
+	}
+
+	c.UI.Output("Rotated the bootstrap token", terminal.WithSuccessStyle())
+	return nil
+}
@@ -0,0 +1,194 @@
+package rotate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/cli/common"
+	"github.com/hashicorp/consul-k8s/cli/common/terminal"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestFlagParsing(t *testing.T) {
+	cases := map[string]struct {
+		args []string
+		out  int
+	}{
+		"Non-flag argument": {
+			args: []string{"foo"},
+			out:  1,
+		},
+		"Invalid argument passed, -namespace YOLO": {
+			args: []string{"-namespace", "YOLO"},
+			out:  1,
+		},
+		"Empty secret key": {
+			args: []string{"-secret-key", ""},
+			out:  1,
+		},
+		"Invalid timeout": {
+			args: []string{"-timeout", "0s"},
+			out:  1,
+		},
+		"No servers": {
+			args: []string{"-namespace", "consul"},
+			out:  1,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := setupCommand(new(bytes.Buffer))
+			c.kubernetes = fake.NewSimpleClientset()
+
+			out := c.Run(tc.args)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestRun_Job(t *testing.T) {
+	cases := map[string]struct {
+		https   bool
+		expArgs []string
+	}{
+		"HTTP": {
+			expArgs: []string{
+				"consul-k8s-control-plane", "rotate-bootstrap-token",
+				"-k8s-namespace=consul",
+				"-bootstrap-token-secret-name=consul-bootstrap-acl-token",
+				"-bootstrap-token-secret-key=token",
+				"-consul-api-timeout=10s",
+				"-timeout=5m0s",
+				"-server-address=consul-server-0.consul-server.consul.svc",
+				"-server-address=consul-server-1.consul-server.consul.svc",
+			},
+		},
+		"HTTPS": {
+			https: true,
+			expArgs: []string{
+				"consul-k8s-control-plane", "rotate-bootstrap-token",
+				"-k8s-namespace=consul",
+				"-bootstrap-token-secret-name=consul-bootstrap-acl-token",
+				"-bootstrap-token-secret-key=token",
+				"-consul-api-timeout=10s",
+				"-timeout=5m0s",
+				"-server-address=consul-server-0.consul-server.consul.svc",
+				"-server-address=consul-server-1.consul-server.consul.svc",
+				"-use-https",
+				"-server-port=8501",
+				"-consul-ca-cert=/consul/tls/ca/tls.crt",
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			c := setupCommand(buf)
+			var objects []runtime.Object
+			for _, podName := range []string{"consul-server-0", "consul-server-1"} {
+				pod := serverPod(podName, "consul", "consul-server", apiv1.PodRunning)
+				if tc.https {
+					pod.Spec.Containers[0].Ports = append(pod.Spec.Containers[0].Ports, apiv1.ContainerPort{Name: "https", ContainerPort: common.ServerHTTPSPort})
+				}
+				objects = append(objects, pod)
+			}
+			objects = append(objects, &apiv1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "consul-ca-cert", Namespace: "consul"},
+				Data:       map[string][]byte{"tls.crt": []byte("ca-cert")},
+			})
+			k8s := fake.NewSimpleClientset(objects...)
+			var job *batchv1.Job
+			k8s.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				job = action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+				job.Status.Succeeded = 1
+				return false, nil, nil
+			})
+			c.kubernetes = k8s
+
+			out := c.Run([]string{"-namespace", "consul", "-image", "consul-k8s-control-plane:test"})
+			require.Equal(t, 0, out, buf.String())
+			require.Contains(t, buf.String(), "Rotated the bootstrap token")
+
+			require.Equal(t, "consul-rotate-bootstrap-token", job.Name)
+			container := job.Spec.Template.Spec.Containers[0]
+			require.Equal(t, "consul-k8s-control-plane:test", container.Image)
+			require.Equal(t, tc.expArgs, container.Command)
+			if tc.https {
+				require.Equal(t, "consul-ca-cert", job.Spec.Template.Spec.Volumes[0].Secret.SecretName)
+			} else {
+				require.Empty(t, job.Spec.Template.Spec.Volumes)
+			}
+		})
+	}
+}
+
+func TestRun_JobFailed(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := setupCommand(buf)
+	k8s := fake.NewSimpleClientset(serverPod("consul-server-0", "consul", "consul-server", apiv1.PodRunning))
+	k8s.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*batchv1.Job).Status.Failed = 1
+		return false, nil, nil
+	})
+	c.kubernetes = k8s
+
+	out := c.Run([]string{"-namespace", "consul"})
+	require.Equal(t, 1, out)
+	require.Contains(t, buf.String(), `error rotating the bootstrap token: job "consul-rotate-bootstrap-token" failed`)
+	require.Contains(t, buf.String(), "Run the command again to resume the rotation.")
+}
+
+func serverPod(name, release, statefulSet string, phase apiv1.PodPhase) *apiv1.Pod {
+	isController := true
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "consul",
+			Labels:    map[string]string{"component": "server", "release": release},
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "StatefulSet", Name: statefulSet, Controller: &isController},
+			},
+		},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{
+				{Name: "consul", Ports: []apiv1.ContainerPort{{Name: "http", ContainerPort: common.ServerHTTPPort}}},
+			},
+		},
+		Status: apiv1.PodStatus{Phase: phase},
+	}
+}
+
+func setupCommand(buf io.Writer) *RotateBootstrapTokenCommand {
+	// Log at a test level to standard out.
+	log := hclog.New(&hclog.LoggerOptions{
+		Name:   "test",
+		Level:  hclog.Debug,
+		Output: os.Stdout,
+	})
+
+	// Setup and initialize the command struct
+	command := &RotateBootstrapTokenCommand{
+		BaseCommand: &common.BaseCommand{
+			Ctx: context.Background(),
+			Log: log,
+			UI:  terminal.NewUI(context.Background(), buf),
+		},
+	}
+	command.init()
+	command.pollInterval = 10 * time.Millisecond
+
+	return command
+}
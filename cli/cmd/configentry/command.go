@@ -0,0 +1,26 @@
+package configentry
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-k8s/cli/common"
+	"github.com/mitchellh/cli"
+)
+
+// ConfigEntryCommand provides a synopsis for the config-entry subcommands (e.g. handover).
+type ConfigEntryCommand struct {
+	*common.BaseCommand
+}
+
+// Run prints out information about the subcommands.
+func (c *ConfigEntryCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *ConfigEntryCommand) Help() string {
+	return fmt.Sprintf("%s\n\nUsage: consul-k8s config-entry <subcommand>", c.Synopsis())
+}
+
+func (c *ConfigEntryCommand) Synopsis() string {
+	return "Manage config entry custom resources across datacenters."
+}
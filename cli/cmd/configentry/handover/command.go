@@ -0,0 +1,368 @@
+package handover
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/cli/common"
+	"github.com/hashicorp/consul-k8s/cli/common/flag"
+	"github.com/hashicorp/consul-k8s/cli/common/terminal"
+	helmCLI "helm.sh/helm/v3/pkg/cli"
+	"k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+)
+
+// The group version, annotations, reasons and condition below are those of the
+// config entry custom resources of the control plane.
+const (
+	// releaseEntryKey is the annotation that releases the ownership of a
+	// config entry.
+	releaseEntryKey = "consul.hashicorp.com/release-entry"
+	// claimEntryKey is the annotation that claims the ownership of a config
+	// entry.
+	claimEntryKey = "consul.hashicorp.com/claim-entry"
+
+	// ownershipReleased and ownershipClaimed are the reasons of the Synced
+	// condition once the ownership was released and claimed.
+	ownershipReleased = "OwnershipReleased"
+	ownershipClaimed  = "OwnershipClaimed"
+
+	// conditionSynced is the type of the condition that reports the sync of
+	// a resource with Consul.
+	conditionSynced = "Synced"
+)
+
+// groupVersion is the group version of the config entry custom resources.
+var groupVersion = schema.GroupVersion{Group: "consul.hashicorp.com", Version: "v1alpha1"}
+
+// configEntryResources maps the lowercased kinds of the config entry custom
+// resources to their resource names.
+var configEntryResources = map[string]string{
+	"exportedservices":   "exportedservices",
+	"ingressgateway":     "ingressgateways",
+	"mesh":               "meshes",
+	"proxydefaults":      "proxydefaults",
+	"servicedefaults":    "servicedefaults",
+	"serviceintentions":  "serviceintentions",
+	"serviceresolver":    "serviceresolvers",
+	"servicerouter":      "servicerouters",
+	"servicesplitter":    "servicesplitters",
+	"terminatinggateway": "terminatinggateways",
+}
+
+// HandoverCommand is the command struct for the config-entry handover command.
+type HandoverCommand struct {
+	*common.BaseCommand
+
+	// fromKubernetes and toKubernetes are the clients of the clusters of the
+	// current and the new owner of the config entry.
+	fromKubernetes dynamic.Interface
+	toKubernetes   dynamic.Interface
+
+	set *flag.Sets
+
+	// Command Flags
+	flagKind        string
+	flagName        string
+	flagNamespace   string
+	flagFromContext string
+	flagToContext   string
+	flagTimeout     time.Duration
+
+	// Global Flags
+	flagKubeConfig string
+
+	// pollInterval is how often the status of the resources is checked.
+	pollInterval time.Duration
+
+	once sync.Once
+	help string
+}
+
+// init sets up flags and help text for the command.
+func (c *HandoverCommand) init() {
+	c.set = flag.NewSets()
+
+	f := c.set.NewSet("Command Options")
+	f.StringVar(&flag.StringVar{
+		Name:   "kind",
+		Target: &c.flagKind,
+		Usage:  "The kind of the config entry custom resource, e.g. ProxyDefaults or Mesh.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "name",
+		Target: &c.flagName,
+		Usage:  "The name of the config entry custom resource.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:    "namespace",
+		Target:  &c.flagNamespace,
+		Usage:   "The Kubernetes namespace of the config entry custom resource in both clusters.",
+		Aliases: []string{"n"},
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "from-context",
+		Target: &c.flagFromContext,
+		Usage:  "The Kubernetes context of the cluster whose controller currently owns the config entry.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "to-context",
+		Target: &c.flagToContext,
+		Usage:  "The Kubernetes context of the cluster whose controller should own the config entry.",
+	})
+	f.DurationVar(&flag.DurationVar{
+		Name:    "timeout",
+		Target:  &c.flagTimeout,
+		Default: 2 * time.Minute,
+		Usage:   "How long to wait for each controller to process the handover.",
+	})
+
+	f = c.set.NewSet("Global Options")
+	f.StringVar(&flag.StringVar{
+		Name:    "kubeconfig",
+		Aliases: []string{"c"},
+		Target:  &c.flagKubeConfig,
+		Default: "",
+		Usage:   "Set the path to kubeconfig file.",
+	})
+
+	c.pollInterval = time.Second
+	c.help = c.set.Help()
+}
+
+// Run executes the handover command.
+func (c *HandoverCommand) Run(args []string) int {
+	c.once.Do(c.init)
+	c.Log.ResetNamed("handover")
+	defer common.CloseWithError(c.BaseCommand)
+
+	if err := c.set.Parse(args); err != nil {
+		c.UI.Output(err.Error(), terminal.WithErrorStyle())
+		c.UI.Output("\n" + c.Help())
+		return 1
+	}
+
+	if err := c.validateFlags(); err != nil {
+		c.UI.Output(err.Error(), terminal.WithErrorStyle())
+		c.UI.Output("\n" + c.Help())
+		return 1
+	}
+
+	if c.fromKubernetes == nil || c.toKubernetes == nil {
+		if err := c.initKubernetes(); err != nil {
+			c.UI.Output("Error initializing Kubernetes client: %v", err, terminal.WithErrorStyle())
+			return 1
+		}
+	}
+
+	if err := c.handover(); err != nil {
+		c.UI.Output(err.Error(), terminal.WithErrorStyle())
+		return 1
+	}
+	return 0
+}
+
+// Help returns a description of the command and how it is used.
+func (c *HandoverCommand) Help() string {
+	c.once.Do(c.init)
+	return fmt.Sprintf("%s\n\nUsage: consul-k8s config-entry handover -kind <kind> -name <name> -namespace <namespace> -from-context <context> -to-context <context> [flags]\n\n"+
+		"  The resource must exist in both clusters with the same spec. The resource in the\n"+
+		"  current owner's cluster is annotated with %q, and once its\n"+
+		"  controller has released the config entry, the resource in the new owner's cluster is\n"+
+		"  annotated with %q until its controller has claimed it.\n\n%s",
+		c.Synopsis(), releaseEntryKey, claimEntryKey, c.help)
+}
+
+// Synopsis returns a one-line command summary.
+func (c *HandoverCommand) Synopsis() string {
+	return "Hand over the ownership of a config entry from the controller of one datacenter to another."
+}
+
+// validateFlags ensures that the flags passed in by the user can be used.
+func (c *HandoverCommand) validateFlags() error {
+	if len(c.set.Args()) > 0 {
+		return errors.New("should have no non-flag arguments")
+	}
+	if c.flagKind == "" || c.flagName == "" || c.flagNamespace == "" {
+		return errors.New("-kind, -name and -namespace must be set")
+	}
+	if _, ok := configEntryResources[strings.ToLower(c.flagKind)]; !ok {
+		var kinds []string
+		for kind := range configEntryResources {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		return fmt.Errorf("-kind must be one of %s", strings.Join(kinds, ", "))
+	}
+	if errs := validation.ValidateNamespaceName(c.flagNamespace, false); len(errs) > 0 {
+		return fmt.Errorf("invalid namespace name passed for -namespace/-n: %v", strings.Join(errs, "; "))
+	}
+	if c.flagFromContext == "" || c.flagToContext == "" {
+		return errors.New("-from-context and -to-context must be set")
+	}
+	if c.flagFromContext == c.flagToContext {
+		return errors.New("-from-context and -to-context must be different")
+	}
+	if c.flagTimeout <= 0 {
+		return errors.New("-timeout must be greater than 0")
+	}
+	return nil
+}
+
+// initKubernetes initializes the Kubernetes clients of both contexts.
+func (c *HandoverCommand) initKubernetes() error {
+	var err error
+	if c.fromKubernetes, err = c.kubernetesClient(c.flagFromContext); err != nil {
+		return err
+	}
+	if c.toKubernetes, err = c.kubernetesClient(c.flagToContext); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *HandoverCommand) kubernetesClient(kubeContext string) (dynamic.Interface, error) {
+	settings := helmCLI.New()
+	if c.flagKubeConfig != "" {
+		settings.KubeConfig = c.flagKubeConfig
+	}
+	settings.KubeContext = kubeContext
+
+	restConfig, err := settings.RESTClientGetter().ToRESTConfig()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving Kubernetes authentication for context %q: %v", kubeContext, err)
+	}
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error creating Kubernetes client for context %q: %v", kubeContext, err)
+	}
+	return client, nil
+}
+
+// handover drives the release and claim of the config entry.
+func (c *HandoverCommand) handover() error {
+	resource := c.resource()
+	from := c.fromKubernetes.Resource(resource).Namespace(c.flagNamespace)
+	to := c.toKubernetes.Resource(resource).Namespace(c.flagNamespace)
+	description := fmt.Sprintf("%s %s/%s", c.flagKind, c.flagNamespace, c.flagName)
+
+	// Check that the resource exists in both clusters before changing anything.
+	if _, err := from.Get(c.Ctx, c.flagName, metav1.GetOptions{}); err != nil {
+		return fmt.Errorf("error reading %s in context %q: %v", description, c.flagFromContext, err)
+	}
+	if _, err := to.Get(c.Ctx, c.flagName, metav1.GetOptions{}); err != nil {
+		return fmt.Errorf("error reading %s in context %q: %v", description, c.flagToContext, err)
+	}
+
+	c.UI.Output("Handing over %s from context %q to context %q", description, c.flagFromContext, c.flagToContext, terminal.WithHeaderStyle())
+
+	if err := c.annotate(from, releaseEntryKey, "true"); err != nil {
+		return fmt.Errorf("error releasing %s in context %q: %v", description, c.flagFromContext, err)
+	}
+	if err := c.waitForReason(from, ownershipReleased); err != nil {
+		return fmt.Errorf("%s was not released in context %q: %v", description, c.flagFromContext, err)
+	}
+	c.UI.Output("Released %s in context %q", description, c.flagFromContext, terminal.WithSuccessStyle())
+
+	if err := c.annotate(to, claimEntryKey, "true"); err != nil {
+		return fmt.Errorf("error claiming %s in context %q: %v", description, c.flagToContext, err)
+	}
+	if err := c.waitForReason(to, ownershipClaimed); err != nil {
+		return fmt.Errorf("%s was not claimed in context %q: %v\n"+
+			"The config entry is still released. Fix the error and run the command again, or remove the %q annotation "+
+			"from the resource in context %q to cancel the handover.",
+			description, c.flagToContext, err, releaseEntryKey, c.flagFromContext)
+	}
+	c.UI.Output("Claimed %s in context %q", description, c.flagToContext, terminal.WithSuccessStyle())
+
+	// The claim annotation has no effect once the config entry is owned by
+	// the new owner, so it is removed to not claim it again after a later
+	// handover.
+	if err := c.annotate(to, claimEntryKey, nil); err != nil {
+		return fmt.Errorf("error removing the %q annotation from %s in context %q: %v", claimEntryKey, description, c.flagToContext, err)
+	}
+
+	c.UI.Output("The config entry is now managed in context %q. The resource in context %q no longer manages it and can be deleted.",
+		c.flagToContext, c.flagFromContext, terminal.WithInfoStyle())
+	return nil
+}
+
+// resource returns the resource of flagKind.
+func (c *HandoverCommand) resource() schema.GroupVersionResource {
+	return groupVersion.WithResource(configEntryResources[strings.ToLower(c.flagKind)])
+}
+
+// annotate sets the annotation key of the resource to value. A nil value
+// removes the annotation.
+func (c *HandoverCommand) annotate(client dynamic.ResourceInterface, key string, value interface{}) error {
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]interface{}{key: value},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = client.Patch(c.Ctx, c.flagName, types.MergePatchType, patch, metav1.PatchOptions{})
+	return err
+}
+
+// waitForReason waits until the synced condition of the resource is true
+// with the given reason. Other conditions are not treated as failures since
+// the controller may still be processing an earlier version of the resource,
+// but the last one is returned in the error if the timeout is reached.
+func (c *HandoverCommand) waitForReason(client dynamic.ResourceInterface, reason string) error {
+	ctx, cancel := context.WithTimeout(c.Ctx, c.flagTimeout)
+	defer cancel()
+
+	lastCondition := "the resource has no synced condition"
+	for {
+		obj, err := client.Get(ctx, c.flagName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		status, condReason, message, found := syncedCondition(obj)
+		if found {
+			if status == "True" && condReason == reason {
+				return nil
+			}
+			lastCondition = fmt.Sprintf("synced condition is %s", status)
+			if condReason != "" {
+				lastCondition = fmt.Sprintf("%s with reason %s: %s", lastCondition, condReason, message)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for the controller, %s", lastCondition)
+		case <-time.After(c.pollInterval):
+		}
+	}
+}
+
+// syncedCondition returns the status, reason and message of the synced
+// condition of the resource.
+func syncedCondition(obj *unstructured.Unstructured) (status, reason, message string, found bool) {
+	conditions, _, _ := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	for _, c := range conditions {
+		condition, ok := c.(map[string]interface{})
+		if !ok || condition["type"] != conditionSynced {
+			continue
+		}
+		status, _ = condition["status"].(string)
+		reason, _ = condition["reason"].(string)
+		message, _ = condition["message"].(string)
+		return status, reason, message, true
+	}
+	return "", "", "", false
+}
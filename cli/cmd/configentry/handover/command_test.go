@@ -0,0 +1,206 @@
+package handover
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/cli/common"
+	"github.com/hashicorp/consul-k8s/cli/common/terminal"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+func TestFlagParsing(t *testing.T) {
+	validArgs := []string{"-kind", "ProxyDefaults", "-name", "global", "-namespace", "default"}
+	cases := map[string]struct {
+		args []string
+		out  int
+	}{
+		"No args": {
+			args: []string{},
+			out:  1,
+		},
+		"Non-flag argument": {
+			args: append([]string{"foo"}, validArgs...),
+			out:  1,
+		},
+		"Unknown kind": {
+			args: []string{"-kind", "ServiceDefault", "-name", "global", "-namespace", "default", "-from-context", "dc1", "-to-context", "dc2"},
+			out:  1,
+		},
+		"Invalid argument passed, -namespace YOLO": {
+			args: []string{"-kind", "ProxyDefaults", "-name", "global", "-namespace", "YOLO", "-from-context", "dc1", "-to-context", "dc2"},
+			out:  1,
+		},
+		"Missing contexts": {
+			args: validArgs,
+			out:  1,
+		},
+		"Same contexts": {
+			args: append(validArgs, "-from-context", "dc1", "-to-context", "dc1"),
+			out:  1,
+		},
+		"Invalid timeout": {
+			args: append(validArgs, "-from-context", "dc1", "-to-context", "dc2", "-timeout", "0s"),
+			out:  1,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := setupCommand(new(bytes.Buffer))
+			c.fromKubernetes = newFakeClient()
+			c.toKubernetes = newFakeClient()
+
+			out := c.Run(tc.args)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestHandover(t *testing.T) {
+	args := []string{"-kind", "proxydefaults", "-name", "global", "-namespace", "default",
+		"-from-context", "dc1", "-to-context", "dc2", "-timeout", "100ms"}
+
+	cases := map[string]struct {
+		fromReason string
+		toReason   string
+		toStatus   string
+		toMessage  string
+		out        int
+		expOut     string
+	}{
+		"successful handover": {
+			fromReason: "OwnershipReleased",
+			toReason:   "OwnershipClaimed",
+			toStatus:   "True",
+			out:        0,
+			expOut:     `The config entry is now managed in context "dc2"`,
+		},
+		"claim fails": {
+			fromReason: "OwnershipReleased",
+			toReason:   "OwnershipClaimFailedError",
+			toStatus:   "False",
+			toMessage:  "claim failed: Kubernetes resource does not match existing Consul config entry",
+			out:        1,
+			expOut:     `proxydefaults default/global was not claimed in context "dc2": timed out waiting for the controller, synced condition is False with reason OwnershipClaimFailedError: claim failed`,
+		},
+		"release fails": {
+			fromReason: "",
+			out:        1,
+			expOut:     `proxydefaults default/global was not released in context "dc1": timed out waiting for the controller, synced condition is True`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			c := setupCommand(buf)
+			c.pollInterval = 10 * time.Millisecond
+			c.fromKubernetes = newFakeClient(proxyDefaults("True", tc.fromReason, ""))
+			c.toKubernetes = newFakeClient(proxyDefaults(tc.toStatus, tc.toReason, tc.toMessage))
+
+			out := c.Run(args)
+			require.Equal(t, tc.out, out, buf.String())
+			require.Contains(t, buf.String(), tc.expOut)
+
+			from := getProxyDefaults(t, c.fromKubernetes)
+			require.Equal(t, "true", from.GetAnnotations()[releaseEntryKey])
+
+			to := getProxyDefaults(t, c.toKubernetes)
+			_, claimed := to.GetAnnotations()[claimEntryKey]
+			// The claim annotation is removed once the claim succeeds, and
+			// isn't added if the release fails.
+			require.Equal(t, tc.out != 0 && tc.fromReason != "", claimed)
+		})
+	}
+}
+
+func TestHandover_resourceNotFound(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := setupCommand(buf)
+	c.fromKubernetes = newFakeClient(proxyDefaults("True", "", ""))
+	c.toKubernetes = newFakeClient()
+
+	out := c.Run([]string{"-kind", "ProxyDefaults", "-name", "global", "-namespace", "default",
+		"-from-context", "dc1", "-to-context", "dc2"})
+	require.Equal(t, 1, out)
+	require.Contains(t, buf.String(), `error reading ProxyDefaults default/global in context "dc2"`)
+
+	from := getProxyDefaults(t, c.fromKubernetes)
+	require.Empty(t, from.GetAnnotations())
+}
+
+func proxyDefaults(status, reason, message string) *unstructured.Unstructured {
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "consul.hashicorp.com/v1alpha1",
+		"kind":       "ProxyDefaults",
+		"metadata": map[string]interface{}{
+			"name":      "global",
+			"namespace": "default",
+		},
+		"status": map[string]interface{}{
+			"conditions": []interface{}{
+				map[string]interface{}{
+					"type":    "Synced",
+					"status":  status,
+					"reason":  reason,
+					"message": message,
+				},
+			},
+		},
+	}}
+}
+
+func proxyDefaultsResource() schema.GroupVersionResource {
+	return schema.GroupVersionResource{Group: "consul.hashicorp.com", Version: "v1alpha1", Resource: "proxydefaults"}
+}
+
+func newFakeClient(objects ...*unstructured.Unstructured) dynamic.Interface {
+	client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{proxyDefaultsResource(): "ProxyDefaultsList"})
+	for _, obj := range objects {
+		_, err := client.Resource(proxyDefaultsResource()).Namespace(obj.GetNamespace()).Create(context.Background(), obj, metav1.CreateOptions{})
+		if err != nil {
+			panic(err)
+		}
+	}
+	return client
+}
+
+func getProxyDefaults(t *testing.T, client dynamic.Interface) *unstructured.Unstructured {
+	obj, err := client.Resource(proxyDefaultsResource()).Namespace("default").Get(context.Background(), "global", metav1.GetOptions{})
+	require.NoError(t, err)
+	return obj
+}
+
+func setupCommand(buf io.Writer) *HandoverCommand {
+	// Log at a test level to standard out.
+	log := hclog.New(&hclog.LoggerOptions{
+		Name:   "test",
+		Level:  hclog.Debug,
+		Output: os.Stdout,
+	})
+
+	// Setup and initialize the command struct
+	command := &HandoverCommand{
+		BaseCommand: &common.BaseCommand{
+			Ctx: context.Background(),
+			Log: log,
+			UI:  terminal.NewUI(context.Background(), buf),
+		},
+	}
+	command.init()
+
+	return command
+}
@@ -0,0 +1,26 @@
+package gossip
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-k8s/cli/common"
+	"github.com/mitchellh/cli"
+)
+
+// GossipCommand provides a synopsis for the gossip subcommands (e.g. rotate-key).
+type GossipCommand struct {
+	*common.BaseCommand
+}
+
+// Run prints out information about the subcommands.
+func (c *GossipCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *GossipCommand) Help() string {
+	return fmt.Sprintf("%s\n\nUsage: consul-k8s gossip <subcommand>", c.Synopsis())
+}
+
+func (c *GossipCommand) Synopsis() string {
+	return "Manage the gossip encryption of a Consul installation."
+}
@@ -0,0 +1,346 @@
+package rotate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/cli/common"
+	"github.com/hashicorp/consul-k8s/cli/common/flag"
+	"github.com/hashicorp/consul-k8s/cli/common/terminal"
+	helmCLI "helm.sh/helm/v3/pkg/cli"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// consulAPITimeout is how long the Job waits for a response from a
+	// server.
+	consulAPITimeout = 10 * time.Second
+
+	// caCertMountPath and aclTokenMountPath are where the CA certificate
+	// Secret and the ACL token Secret are mounted in the Job.
+	caCertMountPath   = "/consul/tls/ca"
+	aclTokenMountPath = "/consul/acl"
+)
+
+// RotateKeyCommand is the command struct for the gossip rotate-key command.
+type RotateKeyCommand struct {
+	*common.BaseCommand
+
+	kubernetes kubernetes.Interface
+
+	set *flag.Sets
+
+	// Command Flags
+	flagNamespace          string
+	flagReleaseName        string
+	flagSecretName         string
+	flagSecretKey          string
+	flagACLTokenSecretName string
+	flagACLTokenSecretKey  string
+	flagCACertSecretName   string
+	flagCACertSecretKey    string
+	flagImage              string
+	flagTimeout            time.Duration
+
+	// Global Flags
+	flagKubeConfig  string
+	flagKubeContext string
+
+	// pollInterval is how often the status of the rotation Job is checked.
+	// It is exposed for setting in tests.
+	pollInterval time.Duration
+
+	once sync.Once
+	help string
+}
+
+// init sets up flags and help text for the command.
+func (c *RotateKeyCommand) init() {
+	c.set = flag.NewSets()
+
+	f := c.set.NewSet("Command Options")
+	f.StringVar(&flag.StringVar{
+		Name:    "namespace",
+		Target:  &c.flagNamespace,
+		Usage:   "The namespace where Consul is installed.",
+		Aliases: []string{"n"},
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "release-name",
+		Target: &c.flagReleaseName,
+		Usage:  "The name of the Helm release of Consul. Required if the namespace has several Consul installations.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "secret-name",
+		Target: &c.flagSecretName,
+		Usage:  "The name of the Secret that stores the gossip encryption key. Defaults to the Secret created when global.gossipEncryption.autoGenerate is true.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:    "secret-key",
+		Target:  &c.flagSecretKey,
+		Default: "key",
+		Usage:   "The key of the gossip encryption key in the Secret.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "acl-token-secret-name",
+		Target: &c.flagACLTokenSecretName,
+		Usage: "The name of the Secret that stores an ACL token with the operator:write permission when ACLs are enabled. " +
+			"Defaults to the bootstrap token Secret created by the server-acl-init job. No token is used if the default Secret does not exist.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:    "acl-token-secret-key",
+		Target:  &c.flagACLTokenSecretKey,
+		Default: "token",
+		Usage:   "The key of the ACL token in the Secret.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "ca-cert-secret-name",
+		Target: &c.flagCACertSecretName,
+		Usage:  "The name of the Secret that stores the CA certificate of the servers when TLS is enabled. Defaults to the Secret created by the tls-init job.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:    "ca-cert-secret-key",
+		Target:  &c.flagCACertSecretKey,
+		Default: "tls.crt",
+		Usage:   "The key of the CA certificate in the Secret.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "image",
+		Target: &c.flagImage,
+		Usage:  "The consul-k8s-control-plane image of the Job that rotates the key. Defaults to global.imageK8S of the Helm chart of this CLI.",
+	})
+	f.DurationVar(&flag.DurationVar{
+		Name:    "timeout",
+		Target:  &c.flagTimeout,
+		Default: 5 * time.Minute,
+		Usage:   "How long to wait for the rotation to complete.",
+	})
+
+	f = c.set.NewSet("Global Options")
+	f.StringVar(&flag.StringVar{
+		Name:    "kubeconfig",
+		Aliases: []string{"c"},
+		Target:  &c.flagKubeConfig,
+		Default: "",
+		Usage:   "Set the path to kubeconfig file.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:    "context",
+		Target:  &c.flagKubeContext,
+		Default: "",
+		Usage:   "Set the Kubernetes context to use.",
+	})
+
+	c.help = c.set.Help()
+}
+
+// Run executes the rotate-key command.
+func (c *RotateKeyCommand) Run(args []string) int {
+	c.once.Do(c.init)
+	c.Log.ResetNamed("rotate-key")
+	defer common.CloseWithError(c.BaseCommand)
+
+	if err := c.set.Parse(args); err != nil {
+		c.UI.Output(err.Error(), terminal.WithErrorStyle())
+		c.UI.Output("\n" + c.Help())
+		return 1
+	}
+
+	if err := c.validateFlags(); err != nil {
+		c.UI.Output(err.Error(), terminal.WithErrorStyle())
+		c.UI.Output("\n" + c.Help())
+		return 1
+	}
+
+	if err := c.initKubernetes(); err != nil {
+		c.UI.Output("Error initializing Kubernetes client: %v", err, terminal.WithErrorStyle())
+		return 1
+	}
+
+	if err := c.rotate(); err != nil {
+		c.UI.Output(err.Error(), terminal.WithErrorStyle())
+		return 1
+	}
+	return 0
+}
+
+// Help returns a description of the command and how it is used.
+func (c *RotateKeyCommand) Help() string {
+	c.once.Do(c.init)
+	return fmt.Sprintf("%s\n\nUsage: consul-k8s gossip rotate-key [flags]\n\n"+
+		"  A Job in the namespace of Consul installs a new key in the keyring and makes it\n"+
+		"  the primary key once every member has it, then it replaces the key in the gossip\n"+
+		"  encryption Secret. The old key is removed once every member uses the new key.\n"+
+		"  If the rotation is interrupted, running the command again resumes it.\n\n%s",
+		c.Synopsis(), c.help)
+}
+
+// Synopsis returns a one-line command summary.
+func (c *RotateKeyCommand) Synopsis() string {
+	return "Rotate the gossip encryption key of a Consul installation."
+}
+
+// validateFlags ensures that the flags passed in by the user can be used.
+func (c *RotateKeyCommand) validateFlags() error {
+	if len(c.set.Args()) > 0 {
+		return errors.New("should have no non-flag arguments")
+	}
+	if errs := validation.ValidateNamespaceName(c.flagNamespace, false); c.flagNamespace != "" && len(errs) > 0 {
+		return fmt.Errorf("invalid namespace name passed for -namespace/-n: %v", strings.Join(errs, "; "))
+	}
+	if c.flagSecretKey == "" || c.flagACLTokenSecretKey == "" || c.flagCACertSecretKey == "" {
+		return errors.New("-secret-key, -acl-token-secret-key and -ca-cert-secret-key must not be empty")
+	}
+	if c.flagTimeout <= 0 {
+		return errors.New("-timeout must be greater than 0")
+	}
+	return nil
+}
+
+// initKubernetes initializes the Kubernetes client unless it was set in a test.
+func (c *RotateKeyCommand) initKubernetes() (err error) {
+	settings := helmCLI.New()
+
+	if c.flagKubeConfig != "" {
+		settings.KubeConfig = c.flagKubeConfig
+	}
+
+	if c.flagKubeContext != "" {
+		settings.KubeContext = c.flagKubeContext
+	}
+
+	if c.flagNamespace == "" {
+		c.flagNamespace = settings.Namespace()
+	}
+
+	if c.kubernetes != nil {
+		return nil
+	}
+
+	restConfig, err := settings.RESTClientGetter().ToRESTConfig()
+	if err != nil {
+		return fmt.Errorf("error creating Kubernetes REST config %v", err)
+	}
+	if c.kubernetes, err = kubernetes.NewForConfig(restConfig); err != nil {
+		return fmt.Errorf("error creating Kubernetes client %v", err)
+	}
+	return nil
+}
+
+// rotate finds the servers and runs the rotate-gossip-key subcommand of the
+// control plane against the first of them in a Job. The server forwards the
+// keyring operations to every member.
+func (c *RotateKeyCommand) rotate() error {
+	pods, fullName, err := common.ServerPods(c.Ctx, c.kubernetes, c.flagNamespace, c.flagReleaseName)
+	if err != nil {
+		return err
+	}
+
+	caCertSecretName := c.flagCACertSecretName
+	if caCertSecretName == "" {
+		caCertSecretName = fullName + "-ca-cert"
+	}
+	https, err := common.ServerUsesHTTPS(c.Ctx, c.kubernetes, pods[0], caCertSecretName, c.flagCACertSecretKey)
+	if err != nil {
+		return err
+	}
+
+	aclTokenSecretName, err := c.aclTokenSecretName(fullName)
+	if err != nil {
+		return err
+	}
+
+	image := c.flagImage
+	if image == "" {
+		if image, err = common.DefaultImageK8S(); err != nil {
+			return fmt.Errorf("error reading the default image, set -image: %v", err)
+		}
+	}
+
+	secretName := c.flagSecretName
+	if secretName == "" {
+		secretName = fullName + "-gossip-encryption-key"
+	}
+
+	job := &common.ControlPlaneJob{
+		Name:             fullName + "-rotate-gossip-key",
+		Namespace:        c.flagNamespace,
+		Image:            image,
+		ImagePullSecrets: pods[0].Spec.ImagePullSecrets,
+		Args: []string{
+			"rotate-gossip-key",
+			"-k8s-namespace=" + c.flagNamespace,
+			"-secret-name=" + secretName,
+			"-secret-key=" + c.flagSecretKey,
+			"-consul-api-timeout=" + consulAPITimeout.String(),
+			"-timeout=" + c.flagTimeout.String(),
+			"-server-address=" + common.ServerAddress(pods[0], fullName),
+		},
+		SecretNames:  []string{secretName},
+		Timeout:      c.flagTimeout,
+		PollInterval: c.pollInterval,
+	}
+	if https {
+		job.Args = append(job.Args,
+			"-use-https",
+			fmt.Sprintf("-server-port=%d", common.ServerHTTPSPort),
+			"-consul-ca-cert="+caCertMountPath+"/"+c.flagCACertSecretKey)
+		job.Volumes = append(job.Volumes, apiv1.Volume{
+			Name:         "consul-ca-cert",
+			VolumeSource: apiv1.VolumeSource{Secret: &apiv1.SecretVolumeSource{SecretName: caCertSecretName}},
+		})
+		job.VolumeMounts = append(job.VolumeMounts, apiv1.VolumeMount{Name: "consul-ca-cert", MountPath: caCertMountPath, ReadOnly: true})
+	}
+	if aclTokenSecretName != "" {
+		job.Args = append(job.Args, "-acl-token-file="+aclTokenMountPath+"/"+c.flagACLTokenSecretKey)
+		job.Volumes = append(job.Volumes, apiv1.Volume{
+			Name:         "consul-acl-token",
+			VolumeSource: apiv1.VolumeSource{Secret: &apiv1.SecretVolumeSource{SecretName: aclTokenSecretName}},
+		})
+		job.VolumeMounts = append(job.VolumeMounts, apiv1.VolumeMount{Name: "consul-acl-token", MountPath: aclTokenMountPath, ReadOnly: true})
+	}
+
+	c.UI.Output("Rotating the gossip encryption key in Secret %s/%s", c.flagNamespace, secretName, terminal.WithHeaderStyle())
+
+	ctx, cancel := context.WithTimeout(c.Ctx, c.flagTimeout)
+	defer cancel()
+	logs, err := job.Run(ctx, c.kubernetes)
+	if logs != "" {
+		c.UI.Output(logs)
+	}
+	if err != nil {
+		return fmt.Errorf("error rotating the gossip encryption key: %v\nRun the command again to resume the rotation.", err)
+	}
+
+	c.UI.Output("Rotated the gossip encryption key", terminal.WithSuccessStyle())
+	return nil
+}
+
+// aclTokenSecretName returns the name of the Secret with the ACL token for the
+// keyring operations. It is empty when the default bootstrap token Secret does
+// not exist because ACLs are disabled.
+func (c *RotateKeyCommand) aclTokenSecretName(fullName string) (string, error) {
+	secretName := c.flagACLTokenSecretName
+	if secretName == "" {
+		secretName = fullName + "-bootstrap-acl-token"
+	}
+	secret, err := c.kubernetes.CoreV1().Secrets(c.flagNamespace).Get(c.Ctx, secretName, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) && c.flagACLTokenSecretName == "" {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error reading the ACL token Secret %q: %v", secretName, err)
+	}
+	if _, ok := secret.Data[c.flagACLTokenSecretKey]; !ok {
+		return "", fmt.Errorf("ACL token Secret %q has no key %q", secretName, c.flagACLTokenSecretKey)
+	}
+	return secretName, nil
+}
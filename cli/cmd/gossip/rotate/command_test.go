@@ -0,0 +1,222 @@
+package rotate
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/cli/common"
+	"github.com/hashicorp/consul-k8s/cli/common/terminal"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const gossipKey = "H6MLnzGpvjPhkgVyqsqXDlwzN+zGhvvBDYpAvdDjuHk="
+
+func TestFlagParsing(t *testing.T) {
+	cases := map[string]struct {
+		args []string
+		out  int
+	}{
+		"Non-flag argument": {
+			args: []string{"foo"},
+			out:  1,
+		},
+		"Invalid argument passed, -namespace YOLO": {
+			args: []string{"-namespace", "YOLO"},
+			out:  1,
+		},
+		"Empty secret key": {
+			args: []string{"-secret-key", ""},
+			out:  1,
+		},
+		"Empty ACL token secret key": {
+			args: []string{"-acl-token-secret-key", ""},
+			out:  1,
+		},
+		"Invalid timeout": {
+			args: []string{"-timeout", "0s"},
+			out:  1,
+		},
+		"No servers": {
+			args: []string{"-namespace", "consul"},
+			out:  1,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := setupCommand(new(bytes.Buffer))
+			c.kubernetes = fake.NewSimpleClientset()
+
+			out := c.Run(tc.args)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestACLTokenSecretName(t *testing.T) {
+	c := setupCommand(new(bytes.Buffer))
+	c.kubernetes = fake.NewSimpleClientset()
+	c.flagNamespace = "consul"
+
+	// Without the bootstrap token Secret, ACLs are disabled.
+	name, err := c.aclTokenSecretName("consul")
+	require.NoError(t, err)
+	require.Empty(t, name)
+
+	c.kubernetes = fake.NewSimpleClientset(secret("consul-bootstrap-acl-token", "token", "bootstrap-token"))
+	name, err = c.aclTokenSecretName("consul")
+	require.NoError(t, err)
+	require.Equal(t, "consul-bootstrap-acl-token", name)
+
+	// A Secret that is set explicitly must exist.
+	c.flagACLTokenSecretName = "operator-token"
+	_, err = c.aclTokenSecretName("consul")
+	require.EqualError(t, err, `error reading the ACL token Secret "operator-token": secrets "operator-token" not found`)
+
+	c.kubernetes = fake.NewSimpleClientset(secret("operator-token", "other", "operator"))
+	_, err = c.aclTokenSecretName("consul")
+	require.EqualError(t, err, `ACL token Secret "operator-token" has no key "token"`)
+}
+
+func TestRun(t *testing.T) {
+	cases := map[string]struct {
+		objects    []runtime.Object
+		expArgs    []string
+		expVolumes []string
+	}{
+		"ACLs disabled": {
+			expArgs: []string{
+				"consul-k8s-control-plane", "rotate-gossip-key",
+				"-k8s-namespace=consul",
+				"-secret-name=consul-gossip-encryption-key",
+				"-secret-key=key",
+				"-consul-api-timeout=10s",
+				"-timeout=5m0s",
+				"-server-address=consul-server-0.consul-server.consul.svc",
+			},
+		},
+		"ACLs enabled": {
+			objects: []runtime.Object{secret("consul-bootstrap-acl-token", "token", "bootstrap-token")},
+			expArgs: []string{
+				"consul-k8s-control-plane", "rotate-gossip-key",
+				"-k8s-namespace=consul",
+				"-secret-name=consul-gossip-encryption-key",
+				"-secret-key=key",
+				"-consul-api-timeout=10s",
+				"-timeout=5m0s",
+				"-server-address=consul-server-0.consul-server.consul.svc",
+				"-acl-token-file=/consul/acl/token",
+			},
+			expVolumes: []string{"consul-bootstrap-acl-token"},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			c := setupCommand(buf)
+			objects := append([]runtime.Object{
+				serverPod("consul-server-0", "consul", "consul-server"),
+				serverPod("consul-server-1", "consul", "consul-server"),
+				secret("consul-gossip-encryption-key", "key", gossipKey),
+			}, tc.objects...)
+			k8s := fake.NewSimpleClientset(objects...)
+			var job *batchv1.Job
+			k8s.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				job = action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+				job.Status.Succeeded = 1
+				return false, nil, nil
+			})
+			c.kubernetes = k8s
+
+			out := c.Run([]string{"-namespace", "consul", "-image", "consul-k8s-control-plane:test"})
+			require.Equal(t, 0, out, buf.String())
+			require.Contains(t, buf.String(), "Rotated the gossip encryption key")
+
+			require.Equal(t, "consul-rotate-gossip-key", job.Name)
+			container := job.Spec.Template.Spec.Containers[0]
+			require.Equal(t, "consul-k8s-control-plane:test", container.Image)
+			require.Equal(t, tc.expArgs, container.Command)
+			var volumes []string
+			for _, volume := range job.Spec.Template.Spec.Volumes {
+				volumes = append(volumes, volume.Secret.SecretName)
+			}
+			require.Equal(t, tc.expVolumes, volumes)
+		})
+	}
+}
+
+func TestRun_JobFailed(t *testing.T) {
+	buf := new(bytes.Buffer)
+	c := setupCommand(buf)
+	k8s := fake.NewSimpleClientset(serverPod("consul-server-0", "consul", "consul-server"))
+	k8s.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*batchv1.Job).Status.Failed = 1
+		return false, nil, nil
+	})
+	c.kubernetes = k8s
+
+	out := c.Run([]string{"-namespace", "consul"})
+	require.Equal(t, 1, out)
+	require.Contains(t, buf.String(), `error rotating the gossip encryption key: job "consul-rotate-gossip-key" failed`)
+}
+
+func serverPod(name, release, statefulSet string) *apiv1.Pod {
+	isController := true
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "consul",
+			Labels:    map[string]string{"component": "server", "release": release},
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "StatefulSet", Name: statefulSet, Controller: &isController},
+			},
+		},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{
+				{Name: "consul", Ports: []apiv1.ContainerPort{{Name: "http", ContainerPort: common.ServerHTTPPort}}},
+			},
+		},
+		Status: apiv1.PodStatus{Phase: apiv1.PodRunning},
+	}
+}
+
+func secret(name, key, value string) *apiv1.Secret {
+	return &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "consul"},
+		Data:       map[string][]byte{key: []byte(value)},
+	}
+}
+
+func setupCommand(buf io.Writer) *RotateKeyCommand {
+	// Log at a test level to standard out.
+	log := hclog.New(&hclog.LoggerOptions{
+		Name:   "test",
+		Level:  hclog.Debug,
+		Output: os.Stdout,
+	})
+
+	// Setup and initialize the command struct
+	command := &RotateKeyCommand{
+		BaseCommand: &common.BaseCommand{
+			Ctx: context.Background(),
+			Log: log,
+			UI:  terminal.NewUI(context.Background(), buf),
+		},
+	}
+	command.init()
+	command.pollInterval = 10 * time.Millisecond
+
+	return command
+}
@@ -0,0 +1,379 @@
+package check
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/cli/common"
+	"github.com/hashicorp/consul-k8s/cli/common/flag"
+	"github.com/hashicorp/consul-k8s/cli/common/terminal"
+	helmCLI "helm.sh/helm/v3/pkg/cli"
+	"k8s.io/apimachinery/pkg/api/validation"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/dynamic"
+)
+
+const (
+	Allow = "allow"
+	Deny  = "deny"
+
+	// exitDenied is returned when the request is denied and no expectation
+	// was passed with -expect.
+	exitDenied = 2
+)
+
+var (
+	// groupVersion is the group version of the config entry custom resources.
+	groupVersion = schema.GroupVersion{Group: "consul.hashicorp.com", Version: "v1alpha1"}
+	// serviceIntentionsResource is the resource of the ServiceIntentions custom resource.
+	serviceIntentionsResource = groupVersion.WithResource("serviceintentions")
+	// serviceIntentionsKind is the kind of the ServiceIntentions custom resource.
+	serviceIntentionsKind = groupVersion.WithKind("ServiceIntentions")
+)
+
+// CheckCommand is the command struct for the intentions check command.
+type CheckCommand struct {
+	*common.BaseCommand
+
+	kubernetes dynamic.Interface
+
+	set *flag.Sets
+
+	// Command Flags
+	flagSource          string
+	flagSourcePartition string
+	flagSourcePeer      string
+	flagDestination     string
+	flagPath            string
+	flagMethod          string
+	flagHeaders         map[string]string
+	flagDefaultAllow    bool
+	flagExpect          string
+	flagFiles           []string
+	flagNamespace       string
+
+	// Global Flags
+	flagKubeConfig  string
+	flagKubeContext string
+
+	once sync.Once
+	help string
+}
+
+// init sets up flags and help text for the command.
+func (c *CheckCommand) init() {
+	c.set = flag.NewSets()
+
+	f := c.set.NewSet("Command Options")
+	f.StringVar(&flag.StringVar{
+		Name:   "source",
+		Target: &c.flagSource,
+		Usage:  "The Consul service making the request, in the form [namespace/]name.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "source-partition",
+		Target: &c.flagSourcePartition,
+		Usage:  "The Consul admin partition of the source service.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "source-peer",
+		Target: &c.flagSourcePeer,
+		Usage:  "The name of the cluster peer the source service is imported from.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "destination",
+		Target: &c.flagDestination,
+		Usage:  "The Consul service receiving the request, in the form [namespace/]name.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "path",
+		Target: &c.flagPath,
+		Usage:  "The HTTP path of the request. If neither -path nor -method are set, the request is checked as a TCP connection.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "method",
+		Target: &c.flagMethod,
+		Usage:  "The HTTP method of the request.",
+	})
+	f.StringMapVar(&flag.StringMapVar{
+		Name:   "header",
+		Target: &c.flagHeaders,
+		Usage:  "An HTTP header of the request in the form name=value. Can be specified multiple times.",
+	})
+	f.BoolVar(&flag.BoolVar{
+		Name:    "default-allow",
+		Target:  &c.flagDefaultAllow,
+		Default: false,
+		Usage:   "Allow requests that are not matched by any intention. This should match the ACL default policy of the Consul datacenter.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:   "expect",
+		Target: &c.flagExpect,
+		Usage: "The expected result of the check, either 'allow' or 'deny'. If set, the command exits with 0 if the result " +
+			"matches and 2 otherwise. If not set, the command exits with 0 if the request is allowed and 2 if it is denied.",
+	})
+	f.StringSliceVar(&flag.StringSliceVar{
+		Name:    "file",
+		Target:  &c.flagFiles,
+		Usage:   "Read ServiceIntentions from the given YAML or JSON file instead of the cluster. Can be specified multiple times.",
+		Aliases: []string{"f"},
+	})
+	f.StringVar(&flag.StringVar{
+		Name:    "namespace",
+		Target:  &c.flagNamespace,
+		Usage:   "Only read ServiceIntentions from the given Kubernetes namespace. Defaults to all namespaces.",
+		Aliases: []string{"n"},
+	})
+
+	f = c.set.NewSet("Global Options")
+	f.StringVar(&flag.StringVar{
+		Name:    "kubeconfig",
+		Aliases: []string{"c"},
+		Target:  &c.flagKubeConfig,
+		Default: "",
+		Usage:   "Set the path to kubeconfig file.",
+	})
+	f.StringVar(&flag.StringVar{
+		Name:    "context",
+		Target:  &c.flagKubeContext,
+		Default: "",
+		Usage:   "Set the Kubernetes context to use.",
+	})
+
+	c.help = c.set.Help()
+}
+
+// Run executes the check command.
+func (c *CheckCommand) Run(args []string) int {
+	c.once.Do(c.init)
+	c.Log.ResetNamed("check")
+	defer common.CloseWithError(c.BaseCommand)
+
+	if err := c.set.Parse(args); err != nil {
+		c.UI.Output(err.Error(), terminal.WithErrorStyle())
+		c.UI.Output("\n" + c.Help())
+		return 1
+	}
+
+	if err := c.validateFlags(); err != nil {
+		c.UI.Output(err.Error(), terminal.WithErrorStyle())
+		c.UI.Output("\n" + c.Help())
+		return 1
+	}
+
+	var intentions []*ServiceIntentions
+	var err error
+	if len(c.flagFiles) > 0 {
+		intentions, err = c.readFiles()
+	} else {
+		if c.kubernetes == nil {
+			if err := c.initKubernetes(); err != nil {
+				c.UI.Output("Error initializing Kubernetes client: %v", err, terminal.WithErrorStyle())
+				return 1
+			}
+		}
+		intentions, err = c.fetchIntentions()
+	}
+	if err != nil {
+		c.UI.Output("Error reading ServiceIntentions: %v", err, terminal.WithErrorStyle())
+		return 1
+	}
+
+	req := c.request()
+	decision := EvaluateIntentions(intentions, req, c.flagDefaultAllow)
+	c.output(req, decision, len(intentions))
+
+	allowed := decision.Allowed
+	if c.flagExpect != "" {
+		if allowed == (c.flagExpect == Allow) {
+			return 0
+		}
+		return exitDenied
+	}
+	if !allowed {
+		return exitDenied
+	}
+	return 0
+}
+
+// Help returns a description of the command and how it is used.
+func (c *CheckCommand) Help() string {
+	c.once.Do(c.init)
+	return fmt.Sprintf("%s\n\nUsage: consul-k8s intentions check -source <service> -destination <service> [flags]\n\n%s", c.Synopsis(), c.help)
+}
+
+// Synopsis returns a one-line command summary.
+func (c *CheckCommand) Synopsis() string {
+	return "Check whether ServiceIntentions allow a request from one service to another."
+}
+
+// validateFlags ensures that the flags passed in by the user can be used.
+func (c *CheckCommand) validateFlags() error {
+	if len(c.set.Args()) > 0 {
+		return errors.New("should have no non-flag arguments")
+	}
+	if c.flagSource == "" || c.flagDestination == "" {
+		return errors.New("-source and -destination must be set")
+	}
+	if c.flagExpect != "" && c.flagExpect != Allow && c.flagExpect != Deny {
+		return fmt.Errorf("-expect must be one of %s, %s", Allow, Deny)
+	}
+	if c.flagSourcePeer != "" && c.flagSourcePartition != "" {
+		return errors.New("-source-peer and -source-partition cannot both be set")
+	}
+	if errs := validation.ValidateNamespaceName(c.flagNamespace, false); c.flagNamespace != "" && len(errs) > 0 {
+		return fmt.Errorf("invalid namespace name passed for -namespace/-n: %v", strings.Join(errs, "; "))
+	}
+	if len(c.flagFiles) > 0 && c.flagNamespace != "" {
+		return errors.New("-namespace cannot be used with -file")
+	}
+	return nil
+}
+
+// initKubernetes initializes the Kubernetes client.
+func (c *CheckCommand) initKubernetes() error {
+	settings := helmCLI.New()
+
+	if c.flagKubeConfig != "" {
+		settings.KubeConfig = c.flagKubeConfig
+	}
+
+	if c.flagKubeContext != "" {
+		settings.KubeContext = c.flagKubeContext
+	}
+
+	restConfig, err := settings.RESTClientGetter().ToRESTConfig()
+	if err != nil {
+		return fmt.Errorf("error retrieving Kubernetes authentication %v", err)
+	}
+	if c.kubernetes, err = dynamic.NewForConfig(restConfig); err != nil {
+		return fmt.Errorf("error creating Kubernetes client %v", err)
+	}
+
+	return nil
+}
+
+// fetchIntentions fetches the ServiceIntentions in flagNamespace, or in all
+// namespaces if it is not set.
+func (c *CheckCommand) fetchIntentions() ([]*ServiceIntentions, error) {
+	list, err := c.kubernetes.Resource(serviceIntentionsResource).Namespace(c.flagNamespace).List(c.Ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var intentions []*ServiceIntentions
+	for _, item := range list.Items {
+		serviceIntentions, err := toServiceIntentions(item.Object)
+		if err != nil {
+			return nil, err
+		}
+		intentions = append(intentions, serviceIntentions)
+	}
+	return intentions, nil
+}
+
+// readFiles reads the ServiceIntentions from flagFiles. Other resources in the
+// files are ignored so that a directory of manifests can be checked.
+func (c *CheckCommand) readFiles() ([]*ServiceIntentions, error) {
+	var intentions []*ServiceIntentions
+	for _, file := range c.flagFiles {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		fileIntentions, err := decodeIntentions(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		intentions = append(intentions, fileIntentions...)
+	}
+	return intentions, nil
+}
+
+// decodeIntentions decodes the ServiceIntentions from a stream of YAML
+// documents or JSON objects.
+func decodeIntentions(r io.Reader) ([]*ServiceIntentions, error) {
+	var intentions []*ServiceIntentions
+	decoder := k8syaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		var obj unstructured.Unstructured
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if err == io.EOF {
+				return intentions, nil
+			}
+			return nil, err
+		}
+		if obj.Object == nil {
+			continue
+		}
+		if obj.GroupVersionKind() != serviceIntentionsKind {
+			continue
+		}
+		serviceIntentions, err := toServiceIntentions(obj.Object)
+		if err != nil {
+			return nil, err
+		}
+		intentions = append(intentions, serviceIntentions)
+	}
+}
+
+func toServiceIntentions(obj map[string]interface{}) (*ServiceIntentions, error) {
+	var serviceIntentions ServiceIntentions
+	if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj, &serviceIntentions); err != nil {
+		return nil, err
+	}
+	return &serviceIntentions, nil
+}
+
+// request returns the request described by the flags.
+func (c *CheckCommand) request() IntentionRequest {
+	req := IntentionRequest{
+		Source:      parseService(c.flagSource),
+		Destination: parseService(c.flagDestination),
+	}
+	req.Source.Partition = c.flagSourcePartition
+	req.Source.Peer = c.flagSourcePeer
+	if c.flagPath != "" || c.flagMethod != "" || len(c.flagHeaders) > 0 {
+		req.HTTP = &IntentionHTTPRequest{
+			Path:   c.flagPath,
+			Method: strings.ToUpper(c.flagMethod),
+			Header: c.flagHeaders,
+		}
+	}
+	return req
+}
+
+// parseService parses a service in the form [namespace/]name.
+func parseService(s string) IntentionRequestService {
+	if idx := strings.Index(s, "/"); idx != -1 {
+		return IntentionRequestService{Namespace: s[:idx], Name: s[idx+1:]}
+	}
+	return IntentionRequestService{Name: s}
+}
+
+// output prints the result of the check to the terminal.
+func (c *CheckCommand) output(req IntentionRequest, decision IntentionDecision, count int) {
+	request := fmt.Sprintf("%s -> %s", c.flagSource, c.flagDestination)
+	if req.HTTP != nil {
+		request = fmt.Sprintf("%s %s %s", request, req.HTTP.Method, req.HTTP.Path)
+	}
+	c.UI.Output("Checking %s against %d ServiceIntentions", request, count, terminal.WithHeaderStyle())
+
+	if decision.Intentions != nil {
+		c.UI.Output("Matched ServiceIntentions %s/%s with precedence %d",
+			decision.Intentions.Namespace, decision.Intentions.Name, decision.Precedence, terminal.WithInfoStyle())
+	}
+	if decision.Allowed {
+		c.UI.Output("Allowed: %s", decision.Reason, terminal.WithSuccessStyle())
+	} else {
+		c.UI.Output("Denied: %s", decision.Reason, terminal.WithErrorStyle())
+	}
+}
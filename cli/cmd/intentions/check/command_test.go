@@ -0,0 +1,238 @@
+package check
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/cli/common"
+	"github.com/hashicorp/consul-k8s/cli/common/terminal"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+)
+
+const testIntentions = `
+apiVersion: consul.hashicorp.com/v1alpha1
+kind: ServiceIntentions
+metadata:
+  name: api
+  namespace: default
+spec:
+  destination:
+    name: api
+  sources:
+    - name: web
+      permissions:
+        - action: deny
+          http:
+            pathPrefix: /admin
+        - action: allow
+          http:
+            pathPrefix: /
+    - name: "*"
+      action: deny
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: api
+---
+{"apiVersion": "consul.hashicorp.com/v1alpha1", "kind": "ServiceIntentions", "metadata": {"name": "db", "namespace": "default"}, "spec": {"destination": {"name": "db"}, "sources": [{"name": "api", "action": "allow"}]}}
+`
+
+func TestFlagParsing(t *testing.T) {
+	cases := map[string]struct {
+		args []string
+		out  int
+	}{
+		"No args": {
+			args: []string{},
+			out:  1,
+		},
+		"Missing destination": {
+			args: []string{"-source", "web"},
+			out:  1,
+		},
+		"Non-flag argument": {
+			args: []string{"-source", "web", "-destination", "api", "foo"},
+			out:  1,
+		},
+		"Nonexistent flag passed, -foo bar": {
+			args: []string{"-source", "web", "-destination", "api", "-foo", "bar"},
+			out:  1,
+		},
+		"Invalid expectation": {
+			args: []string{"-source", "web", "-destination", "api", "-expect", "maybe"},
+			out:  1,
+		},
+		"Peer and partition passed": {
+			args: []string{"-source", "web", "-destination", "api", "-source-peer", "dc2", "-source-partition", "ap1"},
+			out:  1,
+		},
+		"Invalid argument passed, -namespace YOLO": {
+			args: []string{"-source", "web", "-destination", "api", "-namespace", "YOLO"},
+			out:  1,
+		},
+		"Namespace passed with file": {
+			args: []string{"-source", "web", "-destination", "api", "-namespace", "default", "-file", "intentions.yaml"},
+			out:  1,
+		},
+		"Nonexistent file": {
+			args: []string{"-source", "web", "-destination", "api", "-file", "does-not-exist.yaml"},
+			out:  1,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			c := setupCommand(new(bytes.Buffer))
+			c.kubernetes = fake.NewSimpleDynamicClient(runtime.NewScheme())
+
+			out := c.Run(tc.args)
+			require.Equal(t, tc.out, out)
+		})
+	}
+}
+
+func TestCheckFromFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "intentions.yaml")
+	require.NoError(t, os.WriteFile(file, []byte(testIntentions), 0600))
+
+	cases := map[string]struct {
+		args   []string
+		out    int
+		expOut string
+	}{
+		"L4 request to L7 intention uses default": {
+			args:   []string{"-source", "web", "-destination", "api"},
+			out:    exitDenied,
+			expOut: "Denied: intention from \"web\" to \"api\" (default/api) only has L7 permissions",
+		},
+		"allowed HTTP request": {
+			args:   []string{"-source", "web", "-destination", "api", "-path", "/users", "-method", "get"},
+			out:    0,
+			expOut: "Allowed: permission 1 of intention",
+		},
+		"denied HTTP request": {
+			args:   []string{"-source", "web", "-destination", "api", "-path", "/admin/users"},
+			out:    exitDenied,
+			expOut: "Denied: permission 0 of intention",
+		},
+		"denied request matching expectation": {
+			args:   []string{"-source", "web", "-destination", "api", "-path", "/admin/users", "-expect", "deny"},
+			out:    0,
+			expOut: "Denied:",
+		},
+		"allowed request not matching expectation": {
+			args:   []string{"-source", "api", "-destination", "db", "-expect", "deny"},
+			out:    exitDenied,
+			expOut: "Allowed: intention from \"api\" to \"db\" (default/db)",
+		},
+		"wildcard source": {
+			args:   []string{"-source", "admin", "-destination", "api", "-default-allow"},
+			out:    exitDenied,
+			expOut: "Matched ServiceIntentions default/api with precedence 8",
+		},
+		"no matching intention with default allow": {
+			args:   []string{"-source", "web", "-destination", "db", "-default-allow"},
+			out:    0,
+			expOut: "no intention matches the request, using the default action \"allow\"",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			c := setupCommand(buf)
+
+			out := c.Run(append(tc.args, "-file", file))
+			require.Equal(t, tc.out, out, buf.String())
+			require.Contains(t, buf.String(), "against 2 ServiceIntentions")
+			require.Contains(t, buf.String(), tc.expOut)
+		})
+	}
+}
+
+func TestCheckFromCluster(t *testing.T) {
+	intentions, err := decodeIntentions(strings.NewReader(testIntentions))
+	require.NoError(t, err)
+	require.Len(t, intentions, 2)
+
+	cases := map[string]struct {
+		args   []string
+		out    int
+		expOut string
+	}{
+		"all namespaces": {
+			args:   []string{"-source", "api", "-destination", "db"},
+			out:    0,
+			expOut: "against 2 ServiceIntentions",
+		},
+		"single namespace": {
+			args:   []string{"-source", "api", "-destination", "db", "-namespace", "default"},
+			out:    exitDenied,
+			expOut: "against 1 ServiceIntentions",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			c := setupCommand(buf)
+			c.kubernetes = fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+				map[schema.GroupVersionResource]string{serviceIntentionsResource: "ServiceIntentionsList"})
+			for i, namespace := range []string{"default", "other"} {
+				obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(intentions[i])
+				require.NoError(t, err)
+				u := &unstructured.Unstructured{Object: obj}
+				u.SetGroupVersionKind(serviceIntentionsKind)
+				u.SetNamespace(namespace)
+				_, err = c.kubernetes.Resource(serviceIntentionsResource).Namespace(namespace).Create(context.Background(), u, metav1.CreateOptions{})
+				require.NoError(t, err)
+			}
+
+			out := c.Run(tc.args)
+			require.Equal(t, tc.out, out, buf.String())
+			require.Contains(t, buf.String(), tc.expOut)
+		})
+	}
+}
+
+func TestParseService(t *testing.T) {
+	require.Equal(t, "web", parseService("web").Name)
+	require.Equal(t, "", parseService("web").Namespace)
+
+	svc := parseService("ns/web")
+	require.Equal(t, "web", svc.Name)
+	require.Equal(t, "ns", svc.Namespace)
+}
+
+func setupCommand(buf io.Writer) *CheckCommand {
+	// Log at a test level to standard out.
+	log := hclog.New(&hclog.LoggerOptions{
+		Name:   "test",
+		Level:  hclog.Debug,
+		Output: os.Stdout,
+	})
+
+	// Setup and initialize the command struct
+	command := &CheckCommand{
+		BaseCommand: &common.BaseCommand{
+			Ctx: context.Background(),
+			Log: log,
+			UI:  terminal.NewUI(context.Background(), buf),
+		},
+	}
+	command.init()
+
+	return command
+}
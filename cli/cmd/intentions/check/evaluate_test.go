@@ -0,0 +1,239 @@
+package check
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestEvaluateIntentions(t *testing.T) {
+	intentions := func(name, destNS, dest string, sources ...*SourceIntention) *ServiceIntentions {
+		return &ServiceIntentions{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+			},
+			Spec: ServiceIntentionsSpec{
+				Destination: IntentionDestination{
+					Name:      dest,
+					Namespace: destNS,
+				},
+				Sources: sources,
+			},
+		}
+	}
+	l7 := &SourceIntention{
+		Name: "web",
+		Permissions: IntentionPermissions{
+			{
+				Action: "deny",
+				HTTP: &IntentionHTTPPermission{
+					PathPrefix: "/admin",
+				},
+			},
+			{
+				Action: "allow",
+				HTTP: &IntentionHTTPPermission{
+					PathRegex: "/api/v[0-9]+/.*",
+					Methods:   []string{"GET", "POST"},
+					Header: IntentionHTTPHeaderPermissions{
+						{Name: "x-debug", Present: true, Invert: true},
+					},
+				},
+			},
+		},
+	}
+
+	cases := map[string]struct {
+		intentions   []*ServiceIntentions
+		req          IntentionRequest
+		defaultAllow bool
+		expAllowed   bool
+		expMatch     string
+		expReason    string
+	}{
+		"no intentions uses default deny": {
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web"},
+				Destination: IntentionRequestService{Name: "api"},
+			},
+			expAllowed: false,
+			expReason:  `no intention matches the request, using the default action "deny"`,
+		},
+		"no intentions uses default allow": {
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web"},
+				Destination: IntentionRequestService{Name: "api"},
+			},
+			defaultAllow: true,
+			expAllowed:   true,
+		},
+		"exact L4 intention": {
+			intentions: []*ServiceIntentions{
+				intentions("api", "", "api", &SourceIntention{Name: "web", Action: "allow"}),
+			},
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web"},
+				Destination: IntentionRequestService{Name: "api"},
+			},
+			expAllowed: true,
+			expMatch:   "api",
+			expReason:  `intention from "web" to "api" (default/api) has action "allow"`,
+		},
+		"exact source takes precedence over wildcard source": {
+			intentions: []*ServiceIntentions{
+				intentions("api", "", "api",
+					&SourceIntention{Name: "*", Action: "allow"},
+					&SourceIntention{Name: "web", Action: "deny"},
+				),
+			},
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web"},
+				Destination: IntentionRequestService{Name: "api"},
+			},
+			expAllowed: false,
+			expMatch:   "api",
+		},
+		"exact destination takes precedence over wildcard destination": {
+			intentions: []*ServiceIntentions{
+				intentions("all", "", "*", &SourceIntention{Name: "web", Action: "deny"}),
+				intentions("api", "", "api", &SourceIntention{Name: "*", Action: "allow"}),
+			},
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web"},
+				Destination: IntentionRequestService{Name: "api"},
+			},
+			expAllowed: true,
+			expMatch:   "api",
+		},
+		"wildcard destination matches": {
+			intentions: []*ServiceIntentions{
+				intentions("all", "", "*", &SourceIntention{Name: "web", Action: "deny"}),
+			},
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web"},
+				Destination: IntentionRequestService{Name: "api"},
+			},
+			defaultAllow: true,
+			expAllowed:   false,
+			expMatch:     "all",
+		},
+		"source in another namespace does not match": {
+			intentions: []*ServiceIntentions{
+				intentions("api", "default", "api", &SourceIntention{Name: "web", Action: "allow"}),
+			},
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web", Namespace: "other"},
+				Destination: IntentionRequestService{Name: "api"},
+			},
+			expAllowed: false,
+		},
+		"wildcard source namespace": {
+			intentions: []*ServiceIntentions{
+				intentions("api", "ns", "api", &SourceIntention{Name: "*", Namespace: "*", Action: "allow"}),
+			},
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web", Namespace: "other"},
+				Destination: IntentionRequestService{Name: "api", Namespace: "ns"},
+			},
+			expAllowed: true,
+			expMatch:   "api",
+		},
+		"source from a peer": {
+			intentions: []*ServiceIntentions{
+				intentions("api", "", "api", &SourceIntention{Name: "web", Peer: "dc2", Action: "allow"}),
+			},
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web"},
+				Destination: IntentionRequestService{Name: "api"},
+			},
+			expAllowed: false,
+		},
+		"L7 deny permission": {
+			intentions: []*ServiceIntentions{intentions("api", "", "api", l7)},
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web"},
+				Destination: IntentionRequestService{Name: "api"},
+				HTTP:        &IntentionHTTPRequest{Path: "/admin/users", Method: "GET"},
+			},
+			defaultAllow: true,
+			expAllowed:   false,
+			expMatch:     "api",
+			expReason:    `permission 0 of intention from "web" to "api" (default/api) has action "deny"`,
+		},
+		"L7 allow permission": {
+			intentions: []*ServiceIntentions{intentions("api", "", "api", l7)},
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web"},
+				Destination: IntentionRequestService{Name: "api"},
+				HTTP:        &IntentionHTTPRequest{Path: "/api/v1/users", Method: "post"},
+			},
+			expAllowed: true,
+			expMatch:   "api",
+		},
+		"L7 inverted header does not match": {
+			intentions: []*ServiceIntentions{intentions("api", "", "api", l7)},
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web"},
+				Destination: IntentionRequestService{Name: "api"},
+				HTTP: &IntentionHTTPRequest{
+					Path:   "/api/v1/users",
+					Method: "GET",
+					Header: map[string]string{"X-Debug": "1"},
+				},
+			},
+			expAllowed: false,
+			expMatch:   "api",
+			expReason:  `no permission of intention from "web" to "api" (default/api) matches the request, using the default action "deny"`,
+		},
+		"L7 intention with L4 request": {
+			intentions: []*ServiceIntentions{intentions("api", "", "api", l7)},
+			req: IntentionRequest{
+				Source:      IntentionRequestService{Name: "web"},
+				Destination: IntentionRequestService{Name: "api"},
+			},
+			defaultAllow: true,
+			expAllowed:   true,
+			expMatch:     "api",
+			expReason:    `intention from "web" to "api" (default/api) only has L7 permissions but the request is not an HTTP request, using the default action "allow"`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			decision := EvaluateIntentions(c.intentions, c.req, c.defaultAllow)
+			require.Equal(t, c.expAllowed, decision.Allowed, decision.Reason)
+			if c.expMatch == "" {
+				require.Nil(t, decision.Intentions)
+			} else {
+				require.NotNil(t, decision.Intentions)
+				require.Equal(t, c.expMatch, decision.Intentions.Name)
+			}
+			if c.expReason != "" {
+				require.Equal(t, c.expReason, decision.Reason)
+			}
+		})
+	}
+}
+
+func TestIntentionHTTPHeaderPermission_matches(t *testing.T) {
+	header := map[string]string{"Content-Type": "application/json"}
+	cases := map[string]struct {
+		permission IntentionHTTPHeaderPermission
+		exp        bool
+	}{
+		"present":           {IntentionHTTPHeaderPermission{Name: "content-type", Present: true}, true},
+		"not present":       {IntentionHTTPHeaderPermission{Name: "accept", Present: true}, false},
+		"exact":             {IntentionHTTPHeaderPermission{Name: "Content-Type", Exact: "application/json"}, true},
+		"prefix":            {IntentionHTTPHeaderPermission{Name: "Content-Type", Prefix: "application/"}, true},
+		"suffix":            {IntentionHTTPHeaderPermission{Name: "Content-Type", Suffix: "xml"}, false},
+		"regex":             {IntentionHTTPHeaderPermission{Name: "Content-Type", Regex: "application/(json|xml)"}, true},
+		"regex is anchored": {IntentionHTTPHeaderPermission{Name: "Content-Type", Regex: "json"}, false},
+		"inverted":          {IntentionHTTPHeaderPermission{Name: "Content-Type", Exact: "text/plain", Invert: true}, true},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.exp, c.permission.matches(header))
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package intentions
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/consul-k8s/cli/common"
+	"github.com/mitchellh/cli"
+)
+
+// IntentionsCommand provides a synopsis for the intentions subcommands (e.g. check).
+type IntentionsCommand struct {
+	*common.BaseCommand
+}
+
+// Run prints out information about the subcommands.
+func (c *IntentionsCommand) Run(args []string) int {
+	return cli.RunResultHelp
+}
+
+func (c *IntentionsCommand) Help() string {
+	return fmt.Sprintf("%s\n\nUsage: consul-k8s intentions <subcommand>", c.Synopsis())
+}
+
+func (c *IntentionsCommand) Synopsis() string {
+	return "Inspect ServiceIntentions custom resources."
+}
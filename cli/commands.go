@@ -3,7 +3,15 @@ package main
 import (
 	"context"
 
+	"github.com/hashicorp/consul-k8s/cli/cmd/acl"
+	"github.com/hashicorp/consul-k8s/cli/cmd/acl/rotate"
+	"github.com/hashicorp/consul-k8s/cli/cmd/configentry"
+	"github.com/hashicorp/consul-k8s/cli/cmd/configentry/handover"
+	"github.com/hashicorp/consul-k8s/cli/cmd/gossip"
+	gossiprotate "github.com/hashicorp/consul-k8s/cli/cmd/gossip/rotate"
 	"github.com/hashicorp/consul-k8s/cli/cmd/install"
+	"github.com/hashicorp/consul-k8s/cli/cmd/intentions"
+	"github.com/hashicorp/consul-k8s/cli/cmd/intentions/check"
 	"github.com/hashicorp/consul-k8s/cli/cmd/proxy"
 	"github.com/hashicorp/consul-k8s/cli/cmd/proxy/list"
 	"github.com/hashicorp/consul-k8s/cli/cmd/proxy/read"
@@ -68,6 +76,46 @@ func initializeCommands(ctx context.Context, log hclog.Logger) (*common.BaseComm
 				BaseCommand: baseCommand,
 			}, nil
 		},
+		"intentions": func() (cli.Command, error) {
+			return &intentions.IntentionsCommand{
+				BaseCommand: baseCommand,
+			}, nil
+		},
+		"intentions check": func() (cli.Command, error) {
+			return &check.CheckCommand{
+				BaseCommand: baseCommand,
+			}, nil
+		},
+		"acl": func() (cli.Command, error) {
+			return &acl.ACLCommand{
+				BaseCommand: baseCommand,
+			}, nil
+		},
+		"acl rotate-bootstrap-token": func() (cli.Command, error) {
+			return &rotate.RotateBootstrapTokenCommand{
+				BaseCommand: baseCommand,
+			}, nil
+		},
+		"gossip": func() (cli.Command, error) {
+			return &gossip.GossipCommand{
+				BaseCommand: baseCommand,
+			}, nil
+		},
+		"gossip rotate-key": func() (cli.Command, error) {
+			return &gossiprotate.RotateKeyCommand{
+				BaseCommand: baseCommand,
+			}, nil
+		},
+		"config-entry": func() (cli.Command, error) {
+			return &configentry.ConfigEntryCommand{
+				BaseCommand: baseCommand,
+			}, nil
+		},
+		"config-entry handover": func() (cli.Command, error) {
+			return &handover.HandoverCommand{
+				BaseCommand: baseCommand,
+			}, nil
+		},
 	}
 
 	return baseCommand, commands
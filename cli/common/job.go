@@ -0,0 +1,238 @@
+package common
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	consulChart "github.com/hashicorp/consul-k8s/charts"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/yaml"
+)
+
+// jobCleanupTimeout is how long to wait for the resources of a Job to be
+// deleted and for the logs of its Pod to be read once the Job has completed.
+const jobCleanupTimeout = 30 * time.Second
+
+// ControlPlaneJob runs a subcommand of the consul-k8s-control-plane image in a
+// Job so that it can reach the servers and the Secrets of a Consul
+// installation from within the cluster. The Job runs with a ServiceAccount
+// that can only read and update the Secrets it is given.
+type ControlPlaneJob struct {
+	// Name is the name of the Job, and of the ServiceAccount, Role and
+	// RoleBinding it runs with.
+	Name string
+	// Namespace is the namespace of the Consul installation.
+	Namespace string
+	// Image is the consul-k8s-control-plane image to run.
+	Image string
+	// ImagePullSecrets are used to pull the image.
+	ImagePullSecrets []apiv1.LocalObjectReference
+	// Args are the arguments of the consul-k8s-control-plane command,
+	// starting with the name of the subcommand.
+	Args []string
+	// SecretNames are the names of the Secrets that the Job can read and
+	// update.
+	SecretNames []string
+	// Volumes are the volumes of the Pod of the Job and VolumeMounts where
+	// they are mounted.
+	Volumes      []apiv1.Volume
+	VolumeMounts []apiv1.VolumeMount
+	// Timeout is how long the Job may run for.
+	Timeout time.Duration
+	// PollInterval is how often the status of the Job is checked. It
+	// defaults to one second.
+	PollInterval time.Duration
+}
+
+// Run creates the Job and waits for it to complete. It returns the logs of
+// the Pod of the Job, also when the Job fails. The Job and the resources it
+// runs with are deleted before Run returns. An error is returned if a Job of
+// the same name already exists, which means that the subcommand is already
+// running.
+func (j *ControlPlaneJob) Run(ctx context.Context, k8s kubernetes.Interface) (string, error) {
+	labels := map[string]string{"app": "consul", "component": j.Name}
+
+	var cleanups []func(context.Context) error
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), jobCleanupTimeout)
+		defer cancel()
+		for i := len(cleanups) - 1; i >= 0; i-- {
+			// The resources are only left behind if they can't be deleted,
+			// which doesn't affect the result of the Job.
+			_ = cleanups[i](ctx)
+		}
+	}()
+
+	serviceAccounts := k8s.CoreV1().ServiceAccounts(j.Namespace)
+	_, err := serviceAccounts.Create(ctx, &apiv1.ServiceAccount{
+		ObjectMeta: metav1.ObjectMeta{Name: j.Name, Namespace: j.Namespace, Labels: labels},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", j.createError("ServiceAccount", err)
+	}
+	cleanups = append(cleanups, func(ctx context.Context) error {
+		return serviceAccounts.Delete(ctx, j.Name, metav1.DeleteOptions{})
+	})
+
+	roles := k8s.RbacV1().Roles(j.Namespace)
+	_, err = roles.Create(ctx, &rbacv1.Role{
+		ObjectMeta: metav1.ObjectMeta{Name: j.Name, Namespace: j.Namespace, Labels: labels},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups:     []string{""},
+				Resources:     []string{"secrets"},
+				ResourceNames: j.SecretNames,
+				Verbs:         []string{"get", "update"},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", j.createError("Role", err)
+	}
+	cleanups = append(cleanups, func(ctx context.Context) error {
+		return roles.Delete(ctx, j.Name, metav1.DeleteOptions{})
+	})
+
+	roleBindings := k8s.RbacV1().RoleBindings(j.Namespace)
+	_, err = roleBindings.Create(ctx, &rbacv1.RoleBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: j.Name, Namespace: j.Namespace, Labels: labels},
+		RoleRef:    rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "Role", Name: j.Name},
+		Subjects:   []rbacv1.Subject{{Kind: "ServiceAccount", Name: j.Name, Namespace: j.Namespace}},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", j.createError("RoleBinding", err)
+	}
+	cleanups = append(cleanups, func(ctx context.Context) error {
+		return roleBindings.Delete(ctx, j.Name, metav1.DeleteOptions{})
+	})
+
+	backoffLimit := int32(0)
+	activeDeadlineSeconds := int64(j.Timeout.Seconds())
+	jobs := k8s.BatchV1().Jobs(j.Namespace)
+	job, err := jobs.Create(ctx, &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: j.Name, Namespace: j.Namespace, Labels: labels},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &activeDeadlineSeconds,
+			Template: apiv1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: apiv1.PodSpec{
+					RestartPolicy:      apiv1.RestartPolicyNever,
+					ServiceAccountName: j.Name,
+					ImagePullSecrets:   j.ImagePullSecrets,
+					Volumes:            j.Volumes,
+					Containers: []apiv1.Container{
+						{
+							Name:         "consul-k8s-control-plane",
+							Image:        j.Image,
+							Command:      append([]string{"consul-k8s-control-plane"}, j.Args...),
+							VolumeMounts: j.VolumeMounts,
+						},
+					},
+				},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return "", j.createError("Job", err)
+	}
+	cleanups = append(cleanups, func(ctx context.Context) error {
+		// The Pods of the Job are deleted with it.
+		propagation := metav1.DeletePropagationBackground
+		return jobs.Delete(ctx, j.Name, metav1.DeleteOptions{PropagationPolicy: &propagation})
+	})
+
+	waitErr := j.wait(ctx, k8s, job.Name)
+
+	logsCtx, cancel := context.WithTimeout(context.Background(), jobCleanupTimeout)
+	defer cancel()
+	logs, err := j.logs(logsCtx, k8s, job.Name)
+	if waitErr != nil {
+		return logs, waitErr
+	}
+	return logs, err
+}
+
+// wait waits until the Job has succeeded or failed.
+func (j *ControlPlaneJob) wait(ctx context.Context, k8s kubernetes.Interface, name string) error {
+	interval := j.PollInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		job, err := k8s.BatchV1().Jobs(j.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("error reading Job %q: %v", name, err)
+		}
+		if job.Status.Succeeded > 0 {
+			return nil
+		}
+		if job.Status.Failed > 0 {
+			return fmt.Errorf("job %q failed", name)
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for Job %q to complete", name)
+		case <-ticker.C:
+		}
+	}
+}
+
+// logs returns the logs of the Pods of the Job.
+func (j *ControlPlaneJob) logs(ctx context.Context, k8s kubernetes.Interface, name string) (string, error) {
+	pods, err := k8s.CoreV1().Pods(j.Namespace).List(ctx, metav1.ListOptions{LabelSelector: "job-name=" + name})
+	if err != nil {
+		return "", fmt.Errorf("error listing the Pods of Job %q: %v", name, err)
+	}
+
+	var logs []string
+	for _, pod := range pods.Items {
+		raw, err := k8s.CoreV1().Pods(j.Namespace).GetLogs(pod.Name, &apiv1.PodLogOptions{}).DoRaw(ctx)
+		if err != nil {
+			return strings.Join(logs, ""), fmt.Errorf("error reading the logs of Pod %q: %v", pod.Name, err)
+		}
+		logs = append(logs, string(raw))
+	}
+	return strings.Join(logs, ""), nil
+}
+
+// createError returns the error of creating a resource of the Job.
+func (j *ControlPlaneJob) createError(kind string, err error) error {
+	if k8serrors.IsAlreadyExists(err) {
+		return fmt.Errorf("%s %q already exists in namespace %q, wait for the command that is running to complete "+
+			"or delete the resources labeled component=%s if it was interrupted", kind, j.Name, j.Namespace, j.Name)
+	}
+	return fmt.Errorf("error creating %s %q: %v", kind, j.Name, err)
+}
+
+// DefaultImageK8S returns the default consul-k8s-control-plane image of the
+// Helm chart embedded in the CLI.
+func DefaultImageK8S() (string, error) {
+	raw, err := consulChart.ConsulHelmChart.ReadFile(TopLevelChartDirName + "/values.yaml")
+	if err != nil {
+		return "", err
+	}
+	var values struct {
+		Global struct {
+			ImageK8S string `json:"imageK8S"`
+		} `json:"global"`
+	}
+	if err := yaml.Unmarshal(raw, &values); err != nil {
+		return "", err
+	}
+	if values.Global.ImageK8S == "" {
+		return "", errors.New("the Helm chart has no default global.imageK8S")
+	}
+	return values.Global.ImageK8S, nil
+}
@@ -0,0 +1,122 @@
+package common
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	batchv1 "k8s.io/api/batch/v1"
+	apiv1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestControlPlaneJob_Run(t *testing.T) {
+	cases := map[string]struct {
+		status       batchv1.JobStatus
+		existing     []runtime.Object
+		expErrSubstr string
+	}{
+		"Job succeeds": {
+			status: batchv1.JobStatus{Succeeded: 1},
+		},
+		"Job fails": {
+			status:       batchv1.JobStatus{Failed: 1},
+			expErrSubstr: `job "consul-rotate" failed`,
+		},
+		"Job times out": {
+			expErrSubstr: `timed out waiting for Job "consul-rotate" to complete`,
+		},
+		"Job already running": {
+			existing: []runtime.Object{
+				&apiv1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "consul-rotate", Namespace: "consul"}},
+			},
+			expErrSubstr: `ServiceAccount "consul-rotate" already exists in namespace "consul"`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			k8s := fake.NewSimpleClientset(tc.existing...)
+			var created *batchv1.Job
+			k8s.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+				// The Job completes as soon as it is created.
+				created = action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+				created.Status = tc.status
+				return false, nil, nil
+			})
+
+			job := &ControlPlaneJob{
+				Name:         "consul-rotate",
+				Namespace:    "consul",
+				Image:        "hashicorp/consul-k8s-control-plane:test",
+				Args:         []string{"rotate-gossip-key", "-k8s-namespace=consul"},
+				SecretNames:  []string{"consul-gossip-encryption-key"},
+				Timeout:      time.Minute,
+				PollInterval: 10 * time.Millisecond,
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			_, err := job.Run(ctx, k8s)
+			if tc.expErrSubstr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expErrSubstr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			if tc.existing == nil {
+				container := created.Spec.Template.Spec.Containers[0]
+				require.Equal(t, "hashicorp/consul-k8s-control-plane:test", container.Image)
+				require.Equal(t, []string{"consul-k8s-control-plane", "rotate-gossip-key", "-k8s-namespace=consul"}, container.Command)
+				require.Equal(t, "consul-rotate", created.Spec.Template.Spec.ServiceAccountName)
+				require.Equal(t, int64(60), *created.Spec.ActiveDeadlineSeconds)
+			}
+
+			// The Job and the resources it runs with are deleted, unless they
+			// belong to a Job that is already running.
+			_, err = k8s.CoreV1().ServiceAccounts("consul").Get(context.Background(), "consul-rotate", metav1.GetOptions{})
+			require.Equal(t, tc.existing != nil, err == nil)
+			_, err = k8s.RbacV1().Roles("consul").Get(context.Background(), "consul-rotate", metav1.GetOptions{})
+			require.Error(t, err)
+			_, err = k8s.RbacV1().RoleBindings("consul").Get(context.Background(), "consul-rotate", metav1.GetOptions{})
+			require.Error(t, err)
+			_, err = k8s.BatchV1().Jobs("consul").Get(context.Background(), "consul-rotate", metav1.GetOptions{})
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestControlPlaneJob_RunRole(t *testing.T) {
+	k8s := fake.NewSimpleClientset()
+	k8s.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		action.(k8stesting.CreateAction).GetObject().(*batchv1.Job).Status.Succeeded = 1
+		return false, nil, nil
+	})
+	var secretNames []string
+	k8s.PrependReactor("create", "roles", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		role := action.(k8stesting.CreateAction).GetObject().(*rbacv1.Role)
+		secretNames = role.Rules[0].ResourceNames
+		return false, nil, nil
+	})
+
+	job := &ControlPlaneJob{
+		Name:        "consul-rotate",
+		Namespace:   "consul",
+		SecretNames: []string{"consul-bootstrap-acl-token"},
+		Timeout:     time.Minute,
+	}
+	_, err := job.Run(context.Background(), k8s)
+	require.NoError(t, err)
+	require.Equal(t, []string{"consul-bootstrap-acl-token"}, secretNames)
+}
+
+func TestDefaultImageK8S(t *testing.T) {
+	image, err := DefaultImageK8S()
+	require.NoError(t, err)
+	require.Contains(t, image, "hashicorp/consul-k8s-control-plane:")
+}
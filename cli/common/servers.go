@@ -0,0 +1,100 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// ServerHTTPPort and ServerHTTPSPort are the ports of the HTTP API of the
+	// servers.
+	ServerHTTPPort  = 8500
+	ServerHTTPSPort = 8501
+)
+
+// ServerPods returns the Pods of the server StatefulSet of the Consul
+// installation in the namespace and its full name, which prefixes the names of
+// its resources. The release name is required when the namespace has several
+// Consul installations. Every server must be running.
+func ServerPods(ctx context.Context, k8s kubernetes.Interface, namespace, releaseName string) ([]apiv1.Pod, string, error) {
+	selector := "component=server"
+	if releaseName != "" {
+		selector += ",release=" + releaseName
+	}
+	list, err := k8s.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, "", fmt.Errorf("error listing the server Pods in namespace %q: %v", namespace, err)
+	}
+
+	var pods []apiv1.Pod
+	statefulSets := make(map[string]struct{})
+	for _, pod := range list.Items {
+		owner := metav1.GetControllerOf(&pod)
+		if owner == nil || owner.Kind != "StatefulSet" || !strings.HasSuffix(owner.Name, "-server") {
+			continue
+		}
+		if pod.Status.Phase != apiv1.PodRunning {
+			return nil, "", fmt.Errorf("server Pod %q is %s, every server must be running", pod.Name, pod.Status.Phase)
+		}
+		statefulSets[owner.Name] = struct{}{}
+		pods = append(pods, pod)
+	}
+	if len(pods) == 0 {
+		return nil, "", fmt.Errorf("no Consul server Pods found in namespace %q", namespace)
+	}
+	if len(statefulSets) > 1 {
+		var names []string
+		for name := range statefulSets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return nil, "", fmt.Errorf("found the servers of several Consul installations in namespace %q (%s), set -release-name to choose one",
+			namespace, strings.Join(names, ", "))
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+
+	owner := metav1.GetControllerOf(&pods[0])
+	return pods, strings.TrimSuffix(owner.Name, "-server"), nil
+}
+
+// ServerUsesHTTPS returns true if the server Pod exposes its HTTP API over
+// HTTPS. The CA certificate to verify the server with must then be at the key
+// of the Secret, which is checked here so that a missing certificate is
+// reported before anything runs against the servers.
+func ServerUsesHTTPS(ctx context.Context, k8s kubernetes.Interface, pod apiv1.Pod, caCertSecretName, caCertSecretKey string) (bool, error) {
+	if !hasPort(pod, "https") {
+		return false, nil
+	}
+
+	secret, err := k8s.CoreV1().Secrets(pod.Namespace).Get(ctx, caCertSecretName, metav1.GetOptions{})
+	if err != nil {
+		return false, fmt.Errorf("error reading the CA certificate Secret %q: %v", caCertSecretName, err)
+	}
+	if _, ok := secret.Data[caCertSecretKey]; !ok {
+		return false, fmt.Errorf("CA certificate Secret %q has no key %q", caCertSecretName, caCertSecretKey)
+	}
+	return true, nil
+}
+
+// ServerAddress returns the DNS name of the server Pod within the cluster.
+func ServerAddress(pod apiv1.Pod, fullName string) string {
+	return fmt.Sprintf("%s.%s-server.%s.svc", pod.Name, fullName, pod.Namespace)
+}
+
+// hasPort returns true if a container of the Pod has a port with the name.
+func hasPort(pod apiv1.Pod, name string) bool {
+	for _, container := range pod.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,129 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestServerPods(t *testing.T) {
+	cases := map[string]struct {
+		pods         []*apiv1.Pod
+		releaseName  string
+		expPods      []string
+		expFullName  string
+		expErrSubstr string
+	}{
+		"No servers": {
+			pods:         []*apiv1.Pod{serverPod("consul-client-abcde", "consul", "consul-client", apiv1.PodRunning)},
+			expErrSubstr: `no Consul server Pods found in namespace "consul"`,
+		},
+		"Servers of one installation": {
+			pods: []*apiv1.Pod{
+				serverPod("consul-server-1", "consul", "consul-server", apiv1.PodRunning),
+				serverPod("consul-server-0", "consul", "consul-server", apiv1.PodRunning),
+			},
+			expPods:     []string{"consul-server-0", "consul-server-1"},
+			expFullName: "consul",
+		},
+		"Servers of several installations": {
+			pods: []*apiv1.Pod{
+				serverPod("consul-server-0", "consul", "consul-server", apiv1.PodRunning),
+				serverPod("other-consul-server-0", "other", "other-consul-server", apiv1.PodRunning),
+			},
+			expErrSubstr: "found the servers of several Consul installations in namespace \"consul\" (consul-server, other-consul-server)",
+		},
+		"Servers of the release": {
+			pods: []*apiv1.Pod{
+				serverPod("consul-server-0", "consul", "consul-server", apiv1.PodRunning),
+				serverPod("other-consul-server-0", "other", "other-consul-server", apiv1.PodRunning),
+			},
+			releaseName: "other",
+			expPods:     []string{"other-consul-server-0"},
+			expFullName: "other-consul",
+		},
+		"Server not running": {
+			pods: []*apiv1.Pod{
+				serverPod("consul-server-0", "consul", "consul-server", apiv1.PodRunning),
+				serverPod("consul-server-1", "consul", "consul-server", apiv1.PodPending),
+			},
+			expErrSubstr: `server Pod "consul-server-1" is Pending`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			k8s := fake.NewSimpleClientset()
+			for _, pod := range tc.pods {
+				_, err := k8s.CoreV1().Pods("consul").Create(context.Background(), pod, metav1.CreateOptions{})
+				require.NoError(t, err)
+			}
+
+			pods, fullName, err := ServerPods(context.Background(), k8s, "consul", tc.releaseName)
+			if tc.expErrSubstr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), tc.expErrSubstr)
+				return
+			}
+			require.NoError(t, err)
+			var names []string
+			for _, pod := range pods {
+				names = append(names, pod.Name)
+			}
+			require.Equal(t, tc.expPods, names)
+			require.Equal(t, tc.expFullName, fullName)
+		})
+	}
+}
+
+func TestServerUsesHTTPS(t *testing.T) {
+	k8s := fake.NewSimpleClientset(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "consul-ca-cert", Namespace: "consul"},
+		Data:       map[string][]byte{"tls.crt": []byte("ca-cert")},
+	})
+
+	pod := serverPod("consul-server-0", "consul", "consul-server", apiv1.PodRunning)
+	https, err := ServerUsesHTTPS(context.Background(), k8s, *pod, "consul-ca-cert", "tls.crt")
+	require.NoError(t, err)
+	require.False(t, https)
+
+	pod.Spec.Containers[0].Ports = append(pod.Spec.Containers[0].Ports, apiv1.ContainerPort{Name: "https", ContainerPort: ServerHTTPSPort})
+	https, err = ServerUsesHTTPS(context.Background(), k8s, *pod, "consul-ca-cert", "tls.crt")
+	require.NoError(t, err)
+	require.True(t, https)
+
+	_, err = ServerUsesHTTPS(context.Background(), k8s, *pod, "custom-ca", "tls.crt")
+	require.EqualError(t, err, `error reading the CA certificate Secret "custom-ca": secrets "custom-ca" not found`)
+
+	_, err = ServerUsesHTTPS(context.Background(), k8s, *pod, "consul-ca-cert", "ca.crt")
+	require.EqualError(t, err, `CA certificate Secret "consul-ca-cert" has no key "ca.crt"`)
+}
+
+func TestServerAddress(t *testing.T) {
+	pod := serverPod("consul-server-0", "consul", "consul-server", apiv1.PodRunning)
+	require.Equal(t, "consul-server-0.consul-server.consul.svc", ServerAddress(*pod, "consul"))
+}
+
+func serverPod(name, release, statefulSet string, phase apiv1.PodPhase) *apiv1.Pod {
+	isController := true
+	return &apiv1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "consul",
+			Labels:    map[string]string{"component": "server", "release": release},
+			OwnerReferences: []metav1.OwnerReference{
+				{Kind: "StatefulSet", Name: statefulSet, Controller: &isController},
+			},
+		},
+		Spec: apiv1.PodSpec{
+			Containers: []apiv1.Container{
+				{Name: "consul", Ports: []apiv1.ContainerPort{{Name: "http", ContainerPort: ServerHTTPPort}}},
+			},
+		},
+		Status: apiv1.PodStatus{Phase: phase},
+	}
+}
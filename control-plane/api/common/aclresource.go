@@ -0,0 +1,98 @@
+package common
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// aclMetaRegex matches the JSON metadata suffix of an ACL object description.
+var aclMetaRegex = regexp.MustCompile(`(?s)^(.*?)\s*(\{[^{}]*\})$`)
+
+// ACLResource is a generic ACL custom resource, i.e. an ACL policy, role or
+// binding rule. It is implemented by each ACL type so that they can be acted
+// upon generically. It is not tied to a specific CRD version.
+type ACLResource interface {
+	// GetObjectMeta returns object meta.
+	GetObjectMeta() metav1.ObjectMeta
+	// AddFinalizer adds a finalizer to the list of finalizers.
+	AddFinalizer(name string)
+	// RemoveFinalizer removes this finalizer from the list.
+	RemoveFinalizer(name string)
+	// Finalizers returns the list of finalizers for this object.
+	Finalizers() []string
+	// ConsulKind returns the kind of the ACL object in Consul, i.e. acl-policy,
+	// not aclpolicy.
+	ConsulKind() string
+	// ConsulMirroringNS returns the Consul namespace that the ACL object should
+	// be created in if namespaces and mirroring are enabled.
+	ConsulMirroringNS() string
+	// KubeKind returns the Kube kind, i.e. aclpolicy, not acl-policy.
+	KubeKind() string
+	// ConsulName returns the name of the ACL object as saved in Consul.
+	// It is empty for objects that have no name in Consul, e.g. binding rules.
+	ConsulName() string
+	// KubernetesName returns the name of the Kubernetes resource.
+	KubernetesName() string
+	// SetSyncedCondition updates the synced condition.
+	SetSyncedCondition(status corev1.ConditionStatus, reason, message string)
+	// SetLastSyncedTime updates the last synced time.
+	SetLastSyncedTime(time *metav1.Time)
+	// SyncedCondition gets the synced condition.
+	SyncedCondition() (status corev1.ConditionStatus, reason, message string)
+	// SyncedConditionStatus returns the status of the synced condition.
+	SyncedConditionStatus() corev1.ConditionStatus
+	// GetObjectKind should be implemented by the generated code.
+	GetObjectKind() schema.ObjectKind
+	// DeepCopyObject should be implemented by the generated code.
+	DeepCopyObject() runtime.Object
+	// Validate returns an error if the resource is invalid.
+	Validate(consulMeta ConsulMeta) error
+
+	// ACLResource has to implement metav1.Object so that structs
+	// that implement it effectively implement client.Object which is
+	// the interface supported by controller-runtime reconcile-able resources.
+	metav1.Object
+}
+
+// ACLMeta returns the metadata that is stored on ACL objects managed by
+// Kubernetes. ACL objects do not support metadata in Consul so it is appended
+// to their descriptions as JSON, see ACLDescription.
+func ACLMeta(datacenter string, resource ACLResource) map[string]string {
+	return map[string]string{
+		SourceKey:             SourceValue,
+		DatacenterKey:         datacenter,
+		KubernetesResourceKey: fmt.Sprintf("%s/%s", resource.GetNamespace(), resource.GetName()),
+	}
+}
+
+// ACLDescription appends meta to description as JSON.
+func ACLDescription(description string, meta map[string]string) string {
+	// A map of strings can always be marshalled so the error is ignored.
+	metaJSON, _ := json.Marshal(meta)
+	if description == "" {
+		return string(metaJSON)
+	}
+	return fmt.Sprintf("%s %s", description, metaJSON)
+}
+
+// ParseACLDescription splits an ACL object description into the user
+// provided description and the metadata appended by ACLDescription.
+// If the description has no metadata, the returned meta is nil.
+func ParseACLDescription(description string) (string, map[string]string) {
+	matches := aclMetaRegex.FindStringSubmatch(description)
+	if len(matches) != 3 {
+		return strings.TrimSpace(description), nil
+	}
+	var meta map[string]string
+	if err := json.Unmarshal([]byte(matches[2]), &meta); err != nil || meta[SourceKey] != SourceValue {
+		return strings.TrimSpace(description), nil
+	}
+	return matches[1], meta
+}
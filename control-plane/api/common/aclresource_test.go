@@ -0,0 +1,72 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestACLDescription(t *testing.T) {
+	cases := map[string]struct {
+		description string
+		meta        map[string]string
+		exp         string
+	}{
+		"empty description": {
+			description: "",
+			meta:        map[string]string{SourceKey: SourceValue},
+			exp:         `{"external-source":"kubernetes"}`,
+		},
+		"with description": {
+			description: "my policy",
+			meta:        map[string]string{SourceKey: SourceValue, DatacenterKey: "dc1"},
+			exp:         `my policy {"consul.hashicorp.com/source-datacenter":"dc1","external-source":"kubernetes"}`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			act := ACLDescription(c.description, c.meta)
+			require.Equal(t, c.exp, act)
+
+			description, meta := ParseACLDescription(act)
+			require.Equal(t, c.description, description)
+			require.Equal(t, c.meta, meta)
+		})
+	}
+}
+
+func TestParseACLDescription(t *testing.T) {
+	cases := map[string]struct {
+		description    string
+		expDescription string
+		expMeta        map[string]string
+	}{
+		"empty": {
+			description:    "",
+			expDescription: "",
+			expMeta:        nil,
+		},
+		"no meta": {
+			description:    "created by hand",
+			expDescription: "created by hand",
+			expMeta:        nil,
+		},
+		"JSON not created by Kubernetes": {
+			description:    `token created via login: {"pod":"default/pod"}`,
+			expDescription: `token created via login: {"pod":"default/pod"}`,
+			expMeta:        nil,
+		},
+		"multiline description with meta": {
+			description:    "line one\nline two {\"external-source\":\"kubernetes\"}",
+			expDescription: "line one\nline two",
+			expMeta:        map[string]string{SourceKey: SourceValue},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			description, meta := ParseACLDescription(c.description)
+			require.Equal(t, c.expDescription, description)
+			require.Equal(t, c.expMeta, meta)
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package common
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	admissionv1 "k8s.io/api/admission/v1"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// ACLResourceLister is implemented by ACL CRD-specific webhooks.
+type ACLResourceLister interface {
+	// List returns all resources of this type across all namespaces in a
+	// Kubernetes cluster.
+	List(ctx context.Context) ([]ACLResource, error)
+}
+
+// ValidateACLResource validates aclResource. It is a generic method that
+// can be used by all ACL CRD-specific validators.
+// Callers should pass themselves as lister.
+func ValidateACLResource(
+	ctx context.Context,
+	req admission.Request,
+	logger logr.Logger,
+	lister ACLResourceLister,
+	aclResource ACLResource,
+	consulMeta ConsulMeta) admission.Response {
+
+	// Policies and roles have unique names within a Consul namespace, so on
+	// create we need to validate that there isn't already a resource with the
+	// same Consul name in a Kube namespace that maps to the same Consul
+	// namespace. Binding rules have no name so they are never duplicates.
+	singleConsulDestNS := !(consulMeta.NamespacesEnabled && consulMeta.Mirroring)
+	if req.Operation == admissionv1.Create && aclResource.ConsulName() != "" {
+		logger.Info("validate create", "name", aclResource.KubernetesName())
+
+		list, err := lister.List(ctx)
+		if err != nil {
+			return admission.Errored(http.StatusInternalServerError, err)
+		}
+		for _, item := range list {
+			if !singleConsulDestNS && item.GetNamespace() != aclResource.GetNamespace() {
+				continue
+			}
+			if item.ConsulName() == aclResource.ConsulName() {
+				return admission.Errored(http.StatusBadRequest,
+					fmt.Errorf("%s resource with Consul name %q is already defined by %s/%s",
+						aclResource.KubeKind(),
+						aclResource.ConsulName(),
+						item.GetNamespace(),
+						item.KubernetesName()))
+			}
+		}
+	}
+	if err := aclResource.Validate(consulMeta); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	return admission.Allowed(fmt.Sprintf("valid %s request", aclResource.KubeKind()))
+}
@@ -11,6 +11,9 @@ const (
 	ExportedServices   string = "exportedservices"
 	IngressGateway     string = "ingressgateway"
 	TerminatingGateway string = "terminatinggateway"
+	ACLPolicy          string = "aclpolicy"
+	ACLRole            string = "aclrole"
+	ACLBindingRule     string = "aclbindingrule"
 
 	Global                 string = "global"
 	Mesh                   string = "mesh"
@@ -23,4 +26,25 @@ const (
 	MigrateEntryKey  string = "consul.hashicorp.com/migrate-entry"
 	MigrateEntryTrue string = "true"
 	SourceValue      string = "kubernetes"
+
+	// ReleaseEntryKey is the annotation that releases the ownership of a
+	// config entry so that the controller of another datacenter can claim it.
+	ReleaseEntryKey string = "consul.hashicorp.com/release-entry"
+	// ClaimEntryKey is the annotation that claims the ownership of a config
+	// entry released by the controller of another datacenter.
+	ClaimEntryKey string = "consul.hashicorp.com/claim-entry"
+	// ReleasedKey is the key in config entry metadata that records that its
+	// owning datacenter has released it.
+	ReleasedKey string = "consul.hashicorp.com/released"
+	// KubernetesResourceKey is the key in ACL object metadata that records
+	// the namespace/name of the custom resource managing that object. It is
+	// needed to find binding rules since they have no name in Consul.
+	KubernetesResourceKey string = "consul.hashicorp.com/k8s-resource"
+
+	// OwnershipReleased, OwnershipClaimed and OwnershipTransferred are the
+	// reasons of the synced condition while the ownership of a config entry
+	// is handed over from the controller of one datacenter to another.
+	OwnershipReleased    string = "OwnershipReleased"
+	OwnershipClaimed     string = "OwnershipClaimed"
+	OwnershipTransferred string = "OwnershipTransferred"
 )
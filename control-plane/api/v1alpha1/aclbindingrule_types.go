@@ -0,0 +1,191 @@
+package v1alpha1
+
+import (
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	capi "github.com/hashicorp/consul/api"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	ACLBindingRuleKubeKind   = "aclbindingrule"
+	ACLBindingRuleConsulKind = "acl-binding-rule"
+
+	// aclBindingRuleBindTypeNode binds to a node identity. It is not defined
+	// by the version of the Consul API client we use.
+	aclBindingRuleBindTypeNode = "node"
+)
+
+func init() {
+	SchemeBuilder.Register(&ACLBindingRule{}, &ACLBindingRuleList{})
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// ACLBindingRule is the Schema for the aclbindingrules API.
+// +kubebuilder:printcolumn:name="Synced",type="string",JSONPath=".status.conditions[?(@.type==\"Synced\")].status",description="The sync status of the resource with Consul"
+// +kubebuilder:printcolumn:name="Last Synced",type="date",JSONPath=".status.lastSyncedTime",description="The last successful synced time of the resource with Consul"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="The age of the resource"
+// +kubebuilder:resource:shortName="acl-binding-rule"
+type ACLBindingRule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ACLBindingRuleSpec `json:"spec,omitempty"`
+	Status `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ACLBindingRuleList contains a list of ACLBindingRule.
+type ACLBindingRuleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ACLBindingRule `json:"items"`
+}
+
+// ACLBindingRuleSpec defines the desired state of ACLBindingRule.
+type ACLBindingRuleSpec struct {
+	// Description is a human-readable description of the binding rule.
+	Description string `json:"description,omitempty"`
+	// AuthMethod is the name of the auth method this rule applies to.
+	AuthMethod string `json:"authMethod,omitempty"`
+	// Selector is an expression that matches against verified identity
+	// attributes returned from the auth method during login.
+	Selector string `json:"selector,omitempty"`
+	// BindType adjusts how this binding rule is applied at login time.
+	// One of `service`, `node` or `role`.
+	BindType string `json:"bindType,omitempty"`
+	// BindName is the name to bind to the token at login time.
+	BindName string `json:"bindName,omitempty"`
+}
+
+func (in *ACLBindingRule) GetObjectMeta() metav1.ObjectMeta {
+	return in.ObjectMeta
+}
+
+func (in *ACLBindingRule) AddFinalizer(name string) {
+	in.ObjectMeta.Finalizers = append(in.Finalizers(), name)
+}
+
+func (in *ACLBindingRule) RemoveFinalizer(name string) {
+	var newFinalizers []string
+	for _, oldF := range in.Finalizers() {
+		if oldF != name {
+			newFinalizers = append(newFinalizers, oldF)
+		}
+	}
+	in.ObjectMeta.Finalizers = newFinalizers
+}
+
+func (in *ACLBindingRule) Finalizers() []string {
+	return in.ObjectMeta.Finalizers
+}
+
+func (in *ACLBindingRule) ConsulKind() string {
+	return ACLBindingRuleConsulKind
+}
+
+func (in *ACLBindingRule) ConsulMirroringNS() string {
+	return in.Namespace
+}
+
+func (in *ACLBindingRule) KubeKind() string {
+	return ACLBindingRuleKubeKind
+}
+
+// ConsulName returns an empty string since binding rules have no name in Consul.
+func (in *ACLBindingRule) ConsulName() string {
+	return ""
+}
+
+func (in *ACLBindingRule) KubernetesName() string {
+	return in.ObjectMeta.Name
+}
+
+func (in *ACLBindingRule) SetSyncedCondition(status corev1.ConditionStatus, reason string, message string) {
+	in.Status.Conditions = Conditions{
+		{
+			Type:               ConditionSynced,
+			Status:             status,
+			LastTransitionTime: metav1.Now(),
+			Reason:             reason,
+			Message:            message,
+		},
+	}
+}
+
+func (in *ACLBindingRule) SetLastSyncedTime(time *metav1.Time) {
+	in.Status.LastSyncedTime = time
+}
+
+func (in *ACLBindingRule) SyncedCondition() (status corev1.ConditionStatus, reason, message string) {
+	cond := in.Status.GetCondition(ConditionSynced)
+	if cond == nil {
+		return corev1.ConditionUnknown, "", ""
+	}
+	return cond.Status, cond.Reason, cond.Message
+}
+
+func (in *ACLBindingRule) SyncedConditionStatus() corev1.ConditionStatus {
+	cond := in.Status.GetCondition(ConditionSynced)
+	if cond == nil {
+		return corev1.ConditionUnknown
+	}
+	return cond.Status
+}
+
+// ToConsul converts the resource to a Consul ACL binding rule. The ownership
+// metadata is appended to the description since binding rules have no Meta
+// field. It is also used to find the binding rule in Consul since binding
+// rules have no name.
+func (in *ACLBindingRule) ToConsul(datacenter string) *capi.ACLBindingRule {
+	return &capi.ACLBindingRule{
+		Description: common.ACLDescription(in.Spec.Description, common.ACLMeta(datacenter, in)),
+		AuthMethod:  in.Spec.AuthMethod,
+		Selector:    in.Spec.Selector,
+		BindType:    capi.BindingRuleBindType(in.Spec.BindType),
+		BindName:    in.Spec.BindName,
+	}
+}
+
+// MatchesConsul returns true if the resource has the same fields as the Consul
+// ACL binding rule. The metadata in the description is ignored.
+func (in *ACLBindingRule) MatchesConsul(candidate *capi.ACLBindingRule) bool {
+	if candidate == nil {
+		return false
+	}
+	description, _ := common.ParseACLDescription(candidate.Description)
+	return description == in.Spec.Description &&
+		candidate.AuthMethod == in.Spec.AuthMethod &&
+		candidate.Selector == in.Spec.Selector &&
+		string(candidate.BindType) == in.Spec.BindType &&
+		candidate.BindName == in.Spec.BindName
+}
+
+func (in *ACLBindingRule) Validate(_ common.ConsulMeta) error {
+	var errs field.ErrorList
+	path := field.NewPath("spec")
+
+	if in.Spec.AuthMethod == "" {
+		errs = append(errs, field.Required(path.Child("authMethod"), "authMethod must be specified"))
+	}
+	bindTypes := []string{string(capi.BindingRuleBindTypeService), aclBindingRuleBindTypeNode, string(capi.BindingRuleBindTypeRole)}
+	if !sliceContains(bindTypes, in.Spec.BindType) {
+		errs = append(errs, field.Invalid(path.Child("bindType"), in.Spec.BindType, notInSliceMessage(bindTypes)))
+	}
+	if in.Spec.BindName == "" {
+		errs = append(errs, field.Required(path.Child("bindName"), "bindName must be specified"))
+	}
+
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(
+			schema.GroupKind{Group: ConsulHashicorpGroup, Kind: ACLBindingRuleKubeKind},
+			in.KubernetesName(), errs)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	capi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestACLBindingRule_ToConsul(t *testing.T) {
+	rule := ACLBindingRule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "default",
+		},
+		Spec: ACLBindingRuleSpec{
+			Description: "my rule",
+			AuthMethod:  "method",
+			Selector:    `serviceaccount.name=="ci"`,
+			BindType:    "role",
+			BindName:    "ci-role",
+		},
+	}
+	exp := &capi.ACLBindingRule{
+		Description: `my rule {"consul.hashicorp.com/k8s-resource":"default/name","consul.hashicorp.com/source-datacenter":"datacenter","external-source":"kubernetes"}`,
+		AuthMethod:  "method",
+		Selector:    `serviceaccount.name=="ci"`,
+		BindType:    capi.BindingRuleBindTypeRole,
+		BindName:    "ci-role",
+	}
+	require.Equal(t, exp, rule.ToConsul("datacenter"))
+	require.Equal(t, "", rule.ConsulName())
+}
+
+func TestACLBindingRule_MatchesConsul(t *testing.T) {
+	ours := ACLBindingRule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "name",
+		},
+		Spec: ACLBindingRuleSpec{
+			AuthMethod: "method",
+			Selector:   `serviceaccount.name=="ci"`,
+			BindType:   "role",
+			BindName:   "ci-role",
+		},
+	}
+	cases := map[string]struct {
+		Theirs  *capi.ACLBindingRule
+		Matches bool
+	}{
+		"matches": {
+			Theirs: &capi.ACLBindingRule{
+				ID:          "id",
+				Description: `{"external-source":"kubernetes"}`,
+				AuthMethod:  "method",
+				Selector:    `serviceaccount.name=="ci"`,
+				BindType:    capi.BindingRuleBindTypeRole,
+				BindName:    "ci-role",
+			},
+			Matches: true,
+		},
+		"different bind name does not match": {
+			Theirs: &capi.ACLBindingRule{
+				AuthMethod: "method",
+				Selector:   `serviceaccount.name=="ci"`,
+				BindType:   capi.BindingRuleBindTypeRole,
+				BindName:   "other",
+			},
+			Matches: false,
+		},
+		"nil does not match": {
+			Theirs:  nil,
+			Matches: false,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.Matches, ours.MatchesConsul(c.Theirs))
+		})
+	}
+}
+
+func TestACLBindingRule_Validate(t *testing.T) {
+	cases := map[string]struct {
+		input           *ACLBindingRule
+		expectedErrMsgs []string
+	}{
+		"valid": {
+			input: &ACLBindingRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+				Spec: ACLBindingRuleSpec{
+					AuthMethod: "method",
+					BindType:   "node",
+					BindName:   "node",
+				},
+			},
+		},
+		"missing fields": {
+			input: &ACLBindingRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+				Spec: ACLBindingRuleSpec{
+					BindType: "foo",
+				},
+			},
+			expectedErrMsgs: []string{
+				"spec.authMethod: Required value",
+				`spec.bindType: Invalid value: "foo": must be one of "service", "node", "role"`,
+				"spec.bindName: Required value",
+			},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := testCase.input.Validate(common.ConsulMeta{})
+			if len(testCase.expectedErrMsgs) != 0 {
+				require.Error(t, err)
+				for _, s := range testCase.expectedErrMsgs {
+					require.Contains(t, err.Error(), s)
+				}
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,60 @@
+package v1alpha1
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	capi "github.com/hashicorp/consul/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:object:generate=false
+
+type ACLBindingRuleWebhook struct {
+	ConsulClient *capi.Client
+	Logger       logr.Logger
+
+	// ConsulMeta contains metadata specific to the Consul installation.
+	ConsulMeta common.ConsulMeta
+
+	decoder *admission.Decoder
+	client.Client
+}
+
+// NOTE: The path value in the below line is the path to the webhook.
+// If it is updated, run code-gen, update subcommand/controller/command.go
+// and the consul-helm value for the path to the webhook.
+//
+// NOTE: The below line cannot be combined with any other comment. If it is it will break the code generation.
+//
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-v1alpha1-aclbindingrule,mutating=true,failurePolicy=fail,groups=consul.hashicorp.com,resources=aclbindingrules,versions=v1alpha1,name=mutate-aclbindingrules.consul.hashicorp.com,sideEffects=None,admissionReviewVersions=v1beta1;v1
+
+func (v *ACLBindingRuleWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var aclBindingRule ACLBindingRule
+	err := v.decoder.Decode(req, &aclBindingRule)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	return common.ValidateACLResource(ctx, req, v.Logger, v, &aclBindingRule, v.ConsulMeta)
+}
+
+func (v *ACLBindingRuleWebhook) List(ctx context.Context) ([]common.ACLResource, error) {
+	var aclBindingRuleList ACLBindingRuleList
+	if err := v.Client.List(ctx, &aclBindingRuleList); err != nil {
+		return nil, err
+	}
+	var resources []common.ACLResource
+	for i := range aclBindingRuleList.Items {
+		resources = append(resources, common.ACLResource(&aclBindingRuleList.Items[i]))
+	}
+	return resources, nil
+}
+
+func (v *ACLBindingRuleWebhook) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
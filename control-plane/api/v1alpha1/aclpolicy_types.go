@@ -0,0 +1,204 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	capi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/hcl"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	ACLPolicyKubeKind   = "aclpolicy"
+	ACLPolicyConsulKind = "acl-policy"
+)
+
+// aclPolicyNameRegex is the regex Consul uses to validate policy names.
+var aclPolicyNameRegex = regexp.MustCompile(`^[A-Za-z0-9\-_]{1,128}$`)
+
+func init() {
+	SchemeBuilder.Register(&ACLPolicy{}, &ACLPolicyList{})
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// ACLPolicy is the Schema for the aclpolicies API.
+// +kubebuilder:printcolumn:name="Synced",type="string",JSONPath=".status.conditions[?(@.type==\"Synced\")].status",description="The sync status of the resource with Consul"
+// +kubebuilder:printcolumn:name="Last Synced",type="date",JSONPath=".status.lastSyncedTime",description="The last successful synced time of the resource with Consul"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="The age of the resource"
+// +kubebuilder:resource:shortName="acl-policy"
+type ACLPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ACLPolicySpec `json:"spec,omitempty"`
+	Status `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ACLPolicyList contains a list of ACLPolicy.
+type ACLPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ACLPolicy `json:"items"`
+}
+
+// ACLPolicySpec defines the desired state of ACLPolicy.
+type ACLPolicySpec struct {
+	// Name is the name of the policy in Consul. Defaults to the name of the
+	// resource.
+	Name string `json:"name,omitempty"`
+	// Description is a human-readable description of the policy.
+	Description string `json:"description,omitempty"`
+	// Rules is the HCL or JSON ACL rules of the policy.
+	Rules string `json:"rules,omitempty"`
+	// Datacenters restricts the datacenters in which the policy is valid.
+	// If empty, the policy is valid in all datacenters.
+	Datacenters []string `json:"datacenters,omitempty"`
+}
+
+func (in *ACLPolicy) GetObjectMeta() metav1.ObjectMeta {
+	return in.ObjectMeta
+}
+
+func (in *ACLPolicy) AddFinalizer(name string) {
+	in.ObjectMeta.Finalizers = append(in.Finalizers(), name)
+}
+
+func (in *ACLPolicy) RemoveFinalizer(name string) {
+	var newFinalizers []string
+	for _, oldF := range in.Finalizers() {
+		if oldF != name {
+			newFinalizers = append(newFinalizers, oldF)
+		}
+	}
+	in.ObjectMeta.Finalizers = newFinalizers
+}
+
+func (in *ACLPolicy) Finalizers() []string {
+	return in.ObjectMeta.Finalizers
+}
+
+func (in *ACLPolicy) ConsulKind() string {
+	return ACLPolicyConsulKind
+}
+
+func (in *ACLPolicy) ConsulMirroringNS() string {
+	return in.Namespace
+}
+
+func (in *ACLPolicy) KubeKind() string {
+	return ACLPolicyKubeKind
+}
+
+func (in *ACLPolicy) ConsulName() string {
+	if in.Spec.Name != "" {
+		return in.Spec.Name
+	}
+	return in.ObjectMeta.Name
+}
+
+func (in *ACLPolicy) KubernetesName() string {
+	return in.ObjectMeta.Name
+}
+
+func (in *ACLPolicy) SetSyncedCondition(status corev1.ConditionStatus, reason string, message string) {
+	in.Status.Conditions = Conditions{
+		{
+			Type:               ConditionSynced,
+			Status:             status,
+			LastTransitionTime: metav1.Now(),
+			Reason:             reason,
+			Message:            message,
+		},
+	}
+}
+
+func (in *ACLPolicy) SetLastSyncedTime(time *metav1.Time) {
+	in.Status.LastSyncedTime = time
+}
+
+func (in *ACLPolicy) SyncedCondition() (status corev1.ConditionStatus, reason, message string) {
+	cond := in.Status.GetCondition(ConditionSynced)
+	if cond == nil {
+		return corev1.ConditionUnknown, "", ""
+	}
+	return cond.Status, cond.Reason, cond.Message
+}
+
+func (in *ACLPolicy) SyncedConditionStatus() corev1.ConditionStatus {
+	cond := in.Status.GetCondition(ConditionSynced)
+	if cond == nil {
+		return corev1.ConditionUnknown
+	}
+	return cond.Status
+}
+
+// ToConsul converts the resource to a Consul ACL policy. The ownership
+// metadata is appended to the description since policies have no Meta field.
+func (in *ACLPolicy) ToConsul(datacenter string) *capi.ACLPolicy {
+	return &capi.ACLPolicy{
+		Name:        in.ConsulName(),
+		Description: common.ACLDescription(in.Spec.Description, common.ACLMeta(datacenter, in)),
+		Rules:       in.Spec.Rules,
+		Datacenters: in.Spec.Datacenters,
+	}
+}
+
+// MatchesConsul returns true if the resource has the same fields as the Consul
+// ACL policy. The metadata in the description is ignored.
+func (in *ACLPolicy) MatchesConsul(candidate *capi.ACLPolicy) bool {
+	if candidate == nil {
+		return false
+	}
+	// The description metadata is ignored when checking for equality.
+	description, _ := common.ParseACLDescription(candidate.Description)
+	compare := *candidate
+	compare.Description = description
+	expected := in.ToConsul("")
+	expected.Description = in.Spec.Description
+	return cmp.Equal(expected, &compare, cmpopts.IgnoreFields(capi.ACLPolicy{}, "ID", "Hash", "Partition", "Namespace", "ModifyIndex", "CreateIndex"), cmpopts.EquateEmpty())
+}
+
+func (in *ACLPolicy) Validate(_ common.ConsulMeta) error {
+	var errs field.ErrorList
+	path := field.NewPath("spec")
+
+	if !aclPolicyNameRegex.MatchString(in.ConsulName()) {
+		errs = append(errs, field.Invalid(path.Child("name"), in.ConsulName(),
+			fmt.Sprintf("must match %q", aclPolicyNameRegex.String())))
+	}
+	if err := validateACLRules(path.Child("rules"), in.Spec.Rules); err != nil {
+		errs = append(errs, err)
+	}
+
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(
+			schema.GroupKind{Group: ConsulHashicorpGroup, Kind: ACLPolicyKubeKind},
+			in.KubernetesName(), errs)
+	}
+	return nil
+}
+
+// validateACLRules returns an error if rules cannot be parsed as HCL or JSON.
+// Only the syntax is checked; Consul validates the semantics of the rules
+// when the policy is written.
+func validateACLRules(path *field.Path, rules string) *field.Error {
+	if rules == "" {
+		return field.Required(path, "rules must be specified")
+	}
+	if _, err := hcl.Parse(rules); err != nil {
+		return field.Invalid(path, rules, fmt.Sprintf("failed to parse rules: %s", err))
+	}
+	return nil
+}
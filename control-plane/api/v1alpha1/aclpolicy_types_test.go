@@ -0,0 +1,301 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	capi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestACLPolicy_ToConsul(t *testing.T) {
+	cases := map[string]struct {
+		Ours ACLPolicy
+		Exp  *capi.ACLPolicy
+	}{
+		"empty fields": {
+			Ours: ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "name",
+					Namespace: "default",
+				},
+			},
+			Exp: &capi.ACLPolicy{
+				Name:        "name",
+				Description: `{"consul.hashicorp.com/k8s-resource":"default/name","consul.hashicorp.com/source-datacenter":"datacenter","external-source":"kubernetes"}`,
+			},
+		},
+		"every field set": {
+			Ours: ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "name",
+					Namespace: "default",
+				},
+				Spec: ACLPolicySpec{
+					Name:        "consul-name",
+					Description: "my policy",
+					Rules:       `service "web" { policy = "write" }`,
+					Datacenters: []string{"dc1"},
+				},
+			},
+			Exp: &capi.ACLPolicy{
+				Name:        "consul-name",
+				Description: `my policy {"consul.hashicorp.com/k8s-resource":"default/name","consul.hashicorp.com/source-datacenter":"datacenter","external-source":"kubernetes"}`,
+				Rules:       `service "web" { policy = "write" }`,
+				Datacenters: []string{"dc1"},
+			},
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			act := c.Ours.ToConsul("datacenter")
+			require.Equal(t, c.Exp, act)
+		})
+	}
+}
+
+func TestACLPolicy_MatchesConsul(t *testing.T) {
+	cases := map[string]struct {
+		Ours    ACLPolicy
+		Theirs  *capi.ACLPolicy
+		Matches bool
+	}{
+		"empty fields matches": {
+			Ours: ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+			},
+			Theirs: &capi.ACLPolicy{
+				ID:          "id",
+				Name:        "name",
+				Description: `{"external-source":"kubernetes","consul.hashicorp.com/source-datacenter":"dc1"}`,
+				CreateIndex: 1,
+				ModifyIndex: 2,
+			},
+			Matches: true,
+		},
+		"all fields set matches": {
+			Ours: ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+				Spec: ACLPolicySpec{
+					Description: "my policy",
+					Rules:       `service "web" { policy = "write" }`,
+					Datacenters: []string{"dc1"},
+				},
+			},
+			Theirs: &capi.ACLPolicy{
+				Name:        "name",
+				Description: `my policy {"external-source":"kubernetes","consul.hashicorp.com/source-datacenter":"dc2"}`,
+				Rules:       `service "web" { policy = "write" }`,
+				Datacenters: []string{"dc1"},
+			},
+			Matches: true,
+		},
+		"unmanaged policy with same fields matches": {
+			Ours: ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+				Spec: ACLPolicySpec{
+					Description: "my policy",
+					Rules:       `operator = "read"`,
+				},
+			},
+			Theirs: &capi.ACLPolicy{
+				Name:        "name",
+				Description: "my policy",
+				Rules:       `operator = "read"`,
+			},
+			Matches: true,
+		},
+		"different rules does not match": {
+			Ours: ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+				Spec: ACLPolicySpec{
+					Rules: `operator = "read"`,
+				},
+			},
+			Theirs: &capi.ACLPolicy{
+				Name:  "name",
+				Rules: `operator = "write"`,
+			},
+			Matches: false,
+		},
+		"different description does not match": {
+			Ours: ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+				Spec: ACLPolicySpec{
+					Description: "ours",
+				},
+			},
+			Theirs: &capi.ACLPolicy{
+				Name:        "name",
+				Description: `theirs {"external-source":"kubernetes"}`,
+			},
+			Matches: false,
+		},
+		"nil does not match": {
+			Ours: ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+			},
+			Theirs:  nil,
+			Matches: false,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.Matches, c.Ours.MatchesConsul(c.Theirs))
+		})
+	}
+}
+
+func TestACLPolicy_Validate(t *testing.T) {
+	cases := map[string]struct {
+		input          *ACLPolicy
+		expectedErrMsg string
+	}{
+		"valid": {
+			input: &ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+				Spec: ACLPolicySpec{
+					Rules: `
+service_prefix "" {
+  policy = "read"
+}
+key "foo" { policy = "write" }`,
+				},
+			},
+			expectedErrMsg: "",
+		},
+		"valid JSON rules": {
+			input: &ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+				Spec: ACLPolicySpec{
+					Rules: `{"operator": "read"}`,
+				},
+			},
+			expectedErrMsg: "",
+		},
+		"rules missing": {
+			input: &ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+			},
+			expectedErrMsg: `aclpolicy.consul.hashicorp.com "name" is invalid: spec.rules: Required value: rules must be specified`,
+		},
+		"invalid rules": {
+			input: &ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+				Spec: ACLPolicySpec{
+					Rules: `service "web" { policy = "read"`,
+				},
+			},
+			expectedErrMsg: `spec.rules: Invalid value: "service \"web\" { policy = \"read\"": failed to parse rules`,
+		},
+		"invalid name": {
+			input: &ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+				Spec: ACLPolicySpec{
+					Name:  "invalid.name",
+					Rules: `operator = "read"`,
+				},
+			},
+			expectedErrMsg: `spec.name: Invalid value: "invalid.name": must match "^[A-Za-z0-9\\-_]{1,128}$"`,
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := testCase.input.Validate(common.ConsulMeta{})
+			if testCase.expectedErrMsg != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), testCase.expectedErrMsg)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestACLPolicy_ConsulName(t *testing.T) {
+	policy := &ACLPolicy{ObjectMeta: metav1.ObjectMeta{Name: "name"}}
+	require.Equal(t, "name", policy.ConsulName())
+	policy.Spec.Name = "consul-name"
+	require.Equal(t, "consul-name", policy.ConsulName())
+	require.Equal(t, "name", policy.KubernetesName())
+}
+
+func TestACLPolicy_AddFinalizer(t *testing.T) {
+	policy := &ACLPolicy{}
+	policy.AddFinalizer("finalizer")
+	require.Equal(t, []string{"finalizer"}, policy.ObjectMeta.Finalizers)
+}
+
+func TestACLPolicy_RemoveFinalizer(t *testing.T) {
+	policy := &ACLPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Finalizers: []string{"f1", "f2"},
+		},
+	}
+	policy.RemoveFinalizer("f1")
+	require.Equal(t, []string{"f2"}, policy.ObjectMeta.Finalizers)
+}
+
+func TestACLPolicy_SetSyncedCondition(t *testing.T) {
+	policy := &ACLPolicy{}
+	policy.SetSyncedCondition(corev1.ConditionTrue, "reason", "message")
+
+	status, reason, message := policy.SyncedCondition()
+	require.Equal(t, corev1.ConditionTrue, status)
+	require.Equal(t, "reason", reason)
+	require.Equal(t, "message", message)
+	require.Equal(t, corev1.ConditionTrue, policy.SyncedConditionStatus())
+	now := metav1.Now()
+	require.True(t, policy.Status.Conditions[0].LastTransitionTime.Before(&now))
+}
+
+func TestACLPolicy_SetLastSyncedTime(t *testing.T) {
+	policy := &ACLPolicy{}
+	syncedTime := metav1.NewTime(time.Now())
+	policy.SetLastSyncedTime(&syncedTime)
+
+	require.Equal(t, &syncedTime, policy.Status.LastSyncedTime)
+}
+
+func TestACLPolicy_SyncedConditionWhenStatusNil(t *testing.T) {
+	policy := &ACLPolicy{}
+	status, reason, message := policy.SyncedCondition()
+	require.Equal(t, corev1.ConditionUnknown, status)
+	require.Equal(t, "", reason)
+	require.Equal(t, "", message)
+	require.Equal(t, corev1.ConditionUnknown, policy.SyncedConditionStatus())
+}
+
+func TestACLPolicy_KubeKind(t *testing.T) {
+	require.Equal(t, "aclpolicy", (&ACLPolicy{}).KubeKind())
+	require.Equal(t, "acl-policy", (&ACLPolicy{}).ConsulKind())
+}
+
+func TestACLPolicy_ConsulMirroringNS(t *testing.T) {
+	require.Equal(t, "ns", (&ACLPolicy{ObjectMeta: metav1.ObjectMeta{Namespace: "ns"}}).ConsulMirroringNS())
+}
@@ -0,0 +1,60 @@
+package v1alpha1
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	capi "github.com/hashicorp/consul/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:object:generate=false
+
+type ACLPolicyWebhook struct {
+	ConsulClient *capi.Client
+	Logger       logr.Logger
+
+	// ConsulMeta contains metadata specific to the Consul installation.
+	ConsulMeta common.ConsulMeta
+
+	decoder *admission.Decoder
+	client.Client
+}
+
+// NOTE: The path value in the below line is the path to the webhook.
+// If it is updated, run code-gen, update subcommand/controller/command.go
+// and the consul-helm value for the path to the webhook.
+//
+// NOTE: The below line cannot be combined with any other comment. If it is it will break the code generation.
+//
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-v1alpha1-aclpolicy,mutating=true,failurePolicy=fail,groups=consul.hashicorp.com,resources=aclpolicies,versions=v1alpha1,name=mutate-aclpolicies.consul.hashicorp.com,sideEffects=None,admissionReviewVersions=v1beta1;v1
+
+func (v *ACLPolicyWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var aclPolicy ACLPolicy
+	err := v.decoder.Decode(req, &aclPolicy)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	return common.ValidateACLResource(ctx, req, v.Logger, v, &aclPolicy, v.ConsulMeta)
+}
+
+func (v *ACLPolicyWebhook) List(ctx context.Context) ([]common.ACLResource, error) {
+	var aclPolicyList ACLPolicyList
+	if err := v.Client.List(ctx, &aclPolicyList); err != nil {
+		return nil, err
+	}
+	var resources []common.ACLResource
+	for i := range aclPolicyList.Items {
+		resources = append(resources, common.ACLResource(&aclPolicyList.Items[i]))
+	}
+	return resources, nil
+}
+
+func (v *ACLPolicyWebhook) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
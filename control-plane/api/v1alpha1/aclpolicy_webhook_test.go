@@ -0,0 +1,152 @@
+package v1alpha1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	logrtest "github.com/go-logr/logr/testing"
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestValidateACLPolicy(t *testing.T) {
+	otherNS := "other"
+
+	cases := map[string]struct {
+		existingResources []runtime.Object
+		newResource       *ACLPolicy
+		mirror            bool
+		expAllow          bool
+		expErrMessage     string
+	}{
+		"no duplicates, valid": {
+			existingResources: nil,
+			newResource: &ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "policy",
+					Namespace: otherNS,
+				},
+				Spec: ACLPolicySpec{
+					Rules: `operator = "read"`,
+				},
+			},
+			expAllow: true,
+		},
+		"invalid rules": {
+			existingResources: nil,
+			newResource: &ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "policy",
+					Namespace: otherNS,
+				},
+				Spec: ACLPolicySpec{
+					Rules: `service "web" {`,
+				},
+			},
+			expAllow:      false,
+			expErrMessage: "failed to parse rules",
+		},
+		"duplicate Consul name in another namespace": {
+			existingResources: []runtime.Object{
+				&ACLPolicy{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "other-policy",
+						Namespace: "default",
+					},
+					Spec: ACLPolicySpec{
+						Name:  "policy",
+						Rules: `operator = "read"`,
+					},
+				},
+				&ACLPolicy{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "unrelated",
+						Namespace: "default",
+					},
+					Spec: ACLPolicySpec{
+						Rules: `operator = "read"`,
+					},
+				},
+			},
+			newResource: &ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "policy",
+					Namespace: otherNS,
+				},
+				Spec: ACLPolicySpec{
+					Rules: `operator = "read"`,
+				},
+			},
+			expAllow:      false,
+			expErrMessage: `aclpolicy resource with Consul name "policy" is already defined by default/other-policy`,
+		},
+		"duplicate Consul name in another namespace with mirroring": {
+			existingResources: []runtime.Object{
+				&ACLPolicy{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "policy",
+						Namespace: "default",
+					},
+					Spec: ACLPolicySpec{
+						Rules: `operator = "read"`,
+					},
+				},
+			},
+			newResource: &ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "policy",
+					Namespace: otherNS,
+				},
+				Spec: ACLPolicySpec{
+					Rules: `operator = "read"`,
+				},
+			},
+			mirror:   true,
+			expAllow: true,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			marshalledRequestObject, err := json.Marshal(c.newResource)
+			require.NoError(t, err)
+			s := runtime.NewScheme()
+			s.AddKnownTypes(GroupVersion, &ACLPolicy{}, &ACLPolicyList{})
+			client := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(c.existingResources...).Build()
+			decoder, err := admission.NewDecoder(s)
+			require.NoError(t, err)
+
+			validator := &ACLPolicyWebhook{
+				Client:       client,
+				ConsulClient: nil,
+				Logger:       logrtest.TestLogger{T: t},
+				decoder:      decoder,
+				ConsulMeta: common.ConsulMeta{
+					NamespacesEnabled: c.mirror,
+					Mirroring:         c.mirror,
+				},
+			}
+			response := validator.Handle(ctx, admission.Request{
+				AdmissionRequest: admissionv1.AdmissionRequest{
+					Name:      c.newResource.KubernetesName(),
+					Namespace: otherNS,
+					Operation: admissionv1.Create,
+					Object: runtime.RawExtension{
+						Raw: marshalledRequestObject,
+					},
+				},
+			})
+
+			require.Equal(t, c.expAllow, response.Allowed)
+			if c.expErrMessage != "" {
+				require.Contains(t, response.AdmissionResponse.Result.Message, c.expErrMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	capi "github.com/hashicorp/consul/api"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	ACLRoleKubeKind   = "aclrole"
+	ACLRoleConsulKind = "acl-role"
+)
+
+// aclRoleNameRegex is the regex Consul uses to validate role names.
+var aclRoleNameRegex = regexp.MustCompile(`^[A-Za-z0-9\-_]{1,256}$`)
+
+func init() {
+	SchemeBuilder.Register(&ACLRole{}, &ACLRoleList{})
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// ACLRole is the Schema for the aclroles API.
+// +kubebuilder:printcolumn:name="Synced",type="string",JSONPath=".status.conditions[?(@.type==\"Synced\")].status",description="The sync status of the resource with Consul"
+// +kubebuilder:printcolumn:name="Last Synced",type="date",JSONPath=".status.lastSyncedTime",description="The last successful synced time of the resource with Consul"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="The age of the resource"
+// +kubebuilder:resource:shortName="acl-role"
+type ACLRole struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ACLRoleSpec `json:"spec,omitempty"`
+	Status `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ACLRoleList contains a list of ACLRole.
+type ACLRoleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ACLRole `json:"items"`
+}
+
+// ACLRoleSpec defines the desired state of ACLRole.
+type ACLRoleSpec struct {
+	// Name is the name of the role in Consul. Defaults to the name of the
+	// resource.
+	Name string `json:"name,omitempty"`
+	// Description is a human-readable description of the role.
+	Description string `json:"description,omitempty"`
+	// Policies is the list of policies linked to the role.
+	Policies []ACLRolePolicy `json:"policies,omitempty"`
+	// ServiceIdentities is the list of service identities granted by the role.
+	ServiceIdentities []ACLServiceIdentity `json:"serviceIdentities,omitempty"`
+	// NodeIdentities is the list of node identities granted by the role.
+	NodeIdentities []ACLNodeIdentity `json:"nodeIdentities,omitempty"`
+}
+
+// ACLRolePolicy links a policy to a role. Exactly one of Name or ID must be set.
+type ACLRolePolicy struct {
+	// Name is the name of the policy.
+	Name string `json:"name,omitempty"`
+	// ID is the ID of the policy.
+	ID string `json:"id,omitempty"`
+}
+
+// ACLServiceIdentity grants the privileges needed for a service to
+// participate in the service mesh.
+type ACLServiceIdentity struct {
+	// ServiceName is the name of the service.
+	ServiceName string `json:"serviceName,omitempty"`
+	// Datacenters restricts the datacenters in which the identity is valid.
+	Datacenters []string `json:"datacenters,omitempty"`
+}
+
+// ACLNodeIdentity grants the privileges needed for a node to register
+// itself and read services in the catalog.
+type ACLNodeIdentity struct {
+	// NodeName is the name of the node.
+	NodeName string `json:"nodeName,omitempty"`
+	// Datacenter is the datacenter in which the identity is valid.
+	Datacenter string `json:"datacenter,omitempty"`
+}
+
+func (in *ACLRole) GetObjectMeta() metav1.ObjectMeta {
+	return in.ObjectMeta
+}
+
+func (in *ACLRole) AddFinalizer(name string) {
+	in.ObjectMeta.Finalizers = append(in.Finalizers(), name)
+}
+
+func (in *ACLRole) RemoveFinalizer(name string) {
+	var newFinalizers []string
+	for _, oldF := range in.Finalizers() {
+		if oldF != name {
+			newFinalizers = append(newFinalizers, oldF)
+		}
+	}
+	in.ObjectMeta.Finalizers = newFinalizers
+}
+
+func (in *ACLRole) Finalizers() []string {
+	return in.ObjectMeta.Finalizers
+}
+
+func (in *ACLRole) ConsulKind() string {
+	return ACLRoleConsulKind
+}
+
+func (in *ACLRole) ConsulMirroringNS() string {
+	return in.Namespace
+}
+
+func (in *ACLRole) KubeKind() string {
+	return ACLRoleKubeKind
+}
+
+func (in *ACLRole) ConsulName() string {
+	if in.Spec.Name != "" {
+		return in.Spec.Name
+	}
+	return in.ObjectMeta.Name
+}
+
+func (in *ACLRole) KubernetesName() string {
+	return in.ObjectMeta.Name
+}
+
+func (in *ACLRole) SetSyncedCondition(status corev1.ConditionStatus, reason string, message string) {
+	in.Status.Conditions = Conditions{
+		{
+			Type:               ConditionSynced,
+			Status:             status,
+			LastTransitionTime: metav1.Now(),
+			Reason:             reason,
+			Message:            message,
+		},
+	}
+}
+
+func (in *ACLRole) SetLastSyncedTime(time *metav1.Time) {
+	in.Status.LastSyncedTime = time
+}
+
+func (in *ACLRole) SyncedCondition() (status corev1.ConditionStatus, reason, message string) {
+	cond := in.Status.GetCondition(ConditionSynced)
+	if cond == nil {
+		return corev1.ConditionUnknown, "", ""
+	}
+	return cond.Status, cond.Reason, cond.Message
+}
+
+func (in *ACLRole) SyncedConditionStatus() corev1.ConditionStatus {
+	cond := in.Status.GetCondition(ConditionSynced)
+	if cond == nil {
+		return corev1.ConditionUnknown
+	}
+	return cond.Status
+}
+
+// ToConsul converts the resource to a Consul ACL role. The ownership
+// metadata is appended to the description since roles have no Meta field.
+func (in *ACLRole) ToConsul(datacenter string) *capi.ACLRole {
+	role := &capi.ACLRole{
+		Name:        in.ConsulName(),
+		Description: common.ACLDescription(in.Spec.Description, common.ACLMeta(datacenter, in)),
+	}
+	for _, p := range in.Spec.Policies {
+		role.Policies = append(role.Policies, &capi.ACLRolePolicyLink{ID: p.ID, Name: p.Name})
+	}
+	for _, s := range in.Spec.ServiceIdentities {
+		role.ServiceIdentities = append(role.ServiceIdentities, &capi.ACLServiceIdentity{
+			ServiceName: s.ServiceName,
+			Datacenters: s.Datacenters,
+		})
+	}
+	for _, n := range in.Spec.NodeIdentities {
+		role.NodeIdentities = append(role.NodeIdentities, &capi.ACLNodeIdentity{
+			NodeName:   n.NodeName,
+			Datacenter: n.Datacenter,
+		})
+	}
+	return role
+}
+
+// MatchesConsul returns true if the resource has the same fields as the Consul
+// ACL role. The metadata in the description is ignored.
+func (in *ACLRole) MatchesConsul(candidate *capi.ACLRole) bool {
+	if candidate == nil {
+		return false
+	}
+	description, _ := common.ParseACLDescription(candidate.Description)
+	if description != in.Spec.Description || len(candidate.Policies) != len(in.Spec.Policies) {
+		return false
+	}
+	// Consul returns both the ID and name of linked policies but the resource
+	// may only specify one of them.
+	for i, p := range in.Spec.Policies {
+		link := candidate.Policies[i]
+		if link == nil || (p.Name != "" && p.Name != link.Name) || (p.ID != "" && p.ID != link.ID) {
+			return false
+		}
+	}
+	expected := in.ToConsul("")
+	return cmp.Equal(expected.ServiceIdentities, candidate.ServiceIdentities, cmpopts.EquateEmpty()) &&
+		cmp.Equal(expected.NodeIdentities, candidate.NodeIdentities, cmpopts.EquateEmpty())
+}
+
+func (in *ACLRole) Validate(_ common.ConsulMeta) error {
+	var errs field.ErrorList
+	path := field.NewPath("spec")
+
+	if !aclRoleNameRegex.MatchString(in.ConsulName()) {
+		errs = append(errs, field.Invalid(path.Child("name"), in.ConsulName(),
+			fmt.Sprintf("must match %q", aclRoleNameRegex.String())))
+	}
+	if len(in.Spec.Policies) == 0 && len(in.Spec.ServiceIdentities) == 0 && len(in.Spec.NodeIdentities) == 0 {
+		errs = append(errs, field.Required(path,
+			"at least one of policies, serviceIdentities or nodeIdentities must be specified"))
+	}
+	for i, p := range in.Spec.Policies {
+		if (p.Name == "") == (p.ID == "") {
+			errs = append(errs, field.Invalid(path.Child("policies").Index(i), p,
+				"exactly one of name or id must be specified"))
+		}
+	}
+	for i, s := range in.Spec.ServiceIdentities {
+		if s.ServiceName == "" {
+			errs = append(errs, field.Required(path.Child("serviceIdentities").Index(i).Child("serviceName"),
+				"serviceName must be specified"))
+		}
+	}
+	for i, n := range in.Spec.NodeIdentities {
+		if n.NodeName == "" {
+			errs = append(errs, field.Required(path.Child("nodeIdentities").Index(i).Child("nodeName"),
+				"nodeName must be specified"))
+		}
+		if n.Datacenter == "" {
+			errs = append(errs, field.Required(path.Child("nodeIdentities").Index(i).Child("datacenter"),
+				"datacenter must be specified"))
+		}
+	}
+
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(
+			schema.GroupKind{Group: ConsulHashicorpGroup, Kind: ACLRoleKubeKind},
+			in.KubernetesName(), errs)
+	}
+	return nil
+}
@@ -0,0 +1,203 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	capi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestACLRole_ToConsul(t *testing.T) {
+	role := ACLRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "name",
+			Namespace: "default",
+		},
+		Spec: ACLRoleSpec{
+			Description: "my role",
+			Policies: []ACLRolePolicy{
+				{Name: "policy"},
+				{ID: "id"},
+			},
+			ServiceIdentities: []ACLServiceIdentity{
+				{ServiceName: "web", Datacenters: []string{"dc1"}},
+			},
+			NodeIdentities: []ACLNodeIdentity{
+				{NodeName: "node", Datacenter: "dc1"},
+			},
+		},
+	}
+	exp := &capi.ACLRole{
+		Name:        "name",
+		Description: `my role {"consul.hashicorp.com/k8s-resource":"default/name","consul.hashicorp.com/source-datacenter":"datacenter","external-source":"kubernetes"}`,
+		Policies: []*capi.ACLRolePolicyLink{
+			{Name: "policy"},
+			{ID: "id"},
+		},
+		ServiceIdentities: []*capi.ACLServiceIdentity{
+			{ServiceName: "web", Datacenters: []string{"dc1"}},
+		},
+		NodeIdentities: []*capi.ACLNodeIdentity{
+			{NodeName: "node", Datacenter: "dc1"},
+		},
+	}
+	require.Equal(t, exp, role.ToConsul("datacenter"))
+}
+
+func TestACLRole_MatchesConsul(t *testing.T) {
+	ours := ACLRole{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "name",
+		},
+		Spec: ACLRoleSpec{
+			Description: "my role",
+			Policies: []ACLRolePolicy{
+				{Name: "policy"},
+				{ID: "id"},
+			},
+			ServiceIdentities: []ACLServiceIdentity{
+				{ServiceName: "web"},
+			},
+		},
+	}
+	cases := map[string]struct {
+		Theirs  *capi.ACLRole
+		Matches bool
+	}{
+		"matches with policy IDs and names filled in by Consul": {
+			Theirs: &capi.ACLRole{
+				ID:          "role-id",
+				Name:        "name",
+				Description: `my role {"external-source":"kubernetes","consul.hashicorp.com/source-datacenter":"dc1"}`,
+				Policies: []*capi.ACLRolePolicyLink{
+					{Name: "policy", ID: "policy-id"},
+					{Name: "other", ID: "id"},
+				},
+				ServiceIdentities: []*capi.ACLServiceIdentity{
+					{ServiceName: "web"},
+				},
+			},
+			Matches: true,
+		},
+		"different policy does not match": {
+			Theirs: &capi.ACLRole{
+				Name:        "name",
+				Description: "my role",
+				Policies: []*capi.ACLRolePolicyLink{
+					{Name: "other", ID: "policy-id"},
+					{Name: "other", ID: "id"},
+				},
+				ServiceIdentities: []*capi.ACLServiceIdentity{
+					{ServiceName: "web"},
+				},
+			},
+			Matches: false,
+		},
+		"missing policy does not match": {
+			Theirs: &capi.ACLRole{
+				Name:        "name",
+				Description: "my role",
+				Policies: []*capi.ACLRolePolicyLink{
+					{Name: "policy", ID: "policy-id"},
+				},
+				ServiceIdentities: []*capi.ACLServiceIdentity{
+					{ServiceName: "web"},
+				},
+			},
+			Matches: false,
+		},
+		"different service identities does not match": {
+			Theirs: &capi.ACLRole{
+				Name:        "name",
+				Description: "my role",
+				Policies: []*capi.ACLRolePolicyLink{
+					{Name: "policy", ID: "policy-id"},
+					{Name: "other", ID: "id"},
+				},
+				ServiceIdentities: []*capi.ACLServiceIdentity{
+					{ServiceName: "web", Datacenters: []string{"dc1"}},
+				},
+			},
+			Matches: false,
+		},
+		"nil does not match": {
+			Theirs:  nil,
+			Matches: false,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.Matches, ours.MatchesConsul(c.Theirs))
+		})
+	}
+}
+
+func TestACLRole_Validate(t *testing.T) {
+	cases := map[string]struct {
+		input           *ACLRole
+		expectedErrMsgs []string
+	}{
+		"valid": {
+			input: &ACLRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+				Spec: ACLRoleSpec{
+					Policies: []ACLRolePolicy{{Name: "policy"}},
+				},
+			},
+		},
+		"nothing granted": {
+			input: &ACLRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+			},
+			expectedErrMsgs: []string{
+				"spec: Required value: at least one of policies, serviceIdentities or nodeIdentities must be specified",
+			},
+		},
+		"invalid links and identities": {
+			input: &ACLRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "name",
+				},
+				Spec: ACLRoleSpec{
+					Name:              "invalid name",
+					Policies:          []ACLRolePolicy{{Name: "policy", ID: "id"}, {}},
+					ServiceIdentities: []ACLServiceIdentity{{}},
+					NodeIdentities:    []ACLNodeIdentity{{}},
+				},
+			},
+			expectedErrMsgs: []string{
+				`spec.name: Invalid value: "invalid name"`,
+				"spec.policies[0]: Invalid value",
+				"spec.policies[1]: Invalid value",
+				"exactly one of name or id must be specified",
+				"spec.serviceIdentities[0].serviceName: Required value",
+				"spec.nodeIdentities[0].nodeName: Required value",
+				"spec.nodeIdentities[0].datacenter: Required value",
+			},
+		},
+	}
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := testCase.input.Validate(common.ConsulMeta{})
+			if len(testCase.expectedErrMsgs) != 0 {
+				require.Error(t, err)
+				for _, s := range testCase.expectedErrMsgs {
+					require.Contains(t, err.Error(), s)
+				}
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestACLRole_KubeKind(t *testing.T) {
+	require.Equal(t, "aclrole", (&ACLRole{}).KubeKind())
+	require.Equal(t, "acl-role", (&ACLRole{}).ConsulKind())
+}
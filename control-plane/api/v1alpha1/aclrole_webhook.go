@@ -0,0 +1,60 @@
+package v1alpha1
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	capi "github.com/hashicorp/consul/api"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// +kubebuilder:object:generate=false
+
+type ACLRoleWebhook struct {
+	ConsulClient *capi.Client
+	Logger       logr.Logger
+
+	// ConsulMeta contains metadata specific to the Consul installation.
+	ConsulMeta common.ConsulMeta
+
+	decoder *admission.Decoder
+	client.Client
+}
+
+// NOTE: The path value in the below line is the path to the webhook.
+// If it is updated, run code-gen, update subcommand/controller/command.go
+// and the consul-helm value for the path to the webhook.
+//
+// NOTE: The below line cannot be combined with any other comment. If it is it will break the code generation.
+//
+// +kubebuilder:webhook:verbs=create;update,path=/mutate-v1alpha1-aclrole,mutating=true,failurePolicy=fail,groups=consul.hashicorp.com,resources=aclroles,versions=v1alpha1,name=mutate-aclroles.consul.hashicorp.com,sideEffects=None,admissionReviewVersions=v1beta1;v1
+
+func (v *ACLRoleWebhook) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var aclRole ACLRole
+	err := v.decoder.Decode(req, &aclRole)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+
+	return common.ValidateACLResource(ctx, req, v.Logger, v, &aclRole, v.ConsulMeta)
+}
+
+func (v *ACLRoleWebhook) List(ctx context.Context) ([]common.ACLResource, error) {
+	var aclRoleList ACLRoleList
+	if err := v.Client.List(ctx, &aclRoleList); err != nil {
+		return nil, err
+	}
+	var resources []common.ACLResource
+	for i := range aclRoleList.Items {
+		resources = append(resources, common.ACLResource(&aclRoleList.Items[i]))
+	}
+	return resources, nil
+}
+
+func (v *ACLRoleWebhook) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
@@ -0,0 +1,326 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	capi "github.com/hashicorp/consul/api"
+)
+
+const (
+	// defaultIntentionNamespace and defaultIntentionPartition are the Consul
+	// namespace and partition that empty values refer to.
+	defaultIntentionNamespace = "default"
+	defaultIntentionPartition = "default"
+
+	wildcardIntentionName = "*"
+)
+
+// IntentionRequest describes a request from a source service to a destination
+// service to be evaluated against intentions.
+type IntentionRequest struct {
+	// Source is the service making the request.
+	Source IntentionRequestService
+	// Destination is the service receiving the request.
+	Destination IntentionRequestService
+	// HTTP describes the HTTP request. If nil, the request is evaluated as
+	// an L4 connection and no L7 permissions match it.
+	HTTP *IntentionHTTPRequest
+}
+
+// IntentionRequestService identifies a Consul service. Empty namespaces and
+// partitions refer to the "default" namespace and partition.
+type IntentionRequestService struct {
+	Name      string
+	Namespace string
+	Partition string
+	// Peer is the name of the peer the service is imported from. It is
+	// only used for the source service.
+	Peer string
+}
+
+// IntentionHTTPRequest describes the L7 attributes of a request.
+type IntentionHTTPRequest struct {
+	Path   string
+	Method string
+	// Header is keyed by header name. Header names are case-insensitive.
+	Header map[string]string
+}
+
+// IntentionDecision is the result of evaluating a request against intentions.
+type IntentionDecision struct {
+	// Allowed is true if the request is allowed.
+	Allowed bool
+	// Intentions is the resource holding the intention that decided the
+	// request. It is nil if no intention matched the request.
+	Intentions *ServiceIntentions
+	// Source is the intention source that matched the request. It is nil if
+	// no intention matched the request.
+	Source *SourceIntention
+	// Permission is the L7 permission that matched the request. It is nil if
+	// the matching intention is an L4 intention or none of its permissions
+	// matched.
+	Permission *IntentionPermission
+	// Precedence is the precedence of the matching intention as computed by
+	// Consul. Higher precedence intentions are evaluated first.
+	Precedence int
+	// Reason describes how the decision was reached.
+	Reason string
+}
+
+// EvaluateIntentions evaluates req against intentions the way Consul does.
+//
+// Only the intention with the highest precedence matching the source and
+// destination of the request is considered. An L4 intention decides the
+// request by its action. An L7 intention decides the request by the action of
+// the first of its permissions matching the request; if none match, the
+// request is decided by the default intention behavior. If no intention
+// matches, the request is also decided by the default behavior, which is to
+// allow if defaultAllow is true and deny otherwise. In Consul the default
+// behavior is defined by the ACL default policy.
+//
+// Empty destination namespaces of intentions are treated as the "default"
+// namespace and empty source namespaces as the namespace of the destination,
+// so the intentions should have their namespace fields defaulted the same way
+// the webhook does.
+func EvaluateIntentions(intentions []*ServiceIntentions, req IntentionRequest, defaultAllow bool) IntentionDecision {
+	var match *intentionMatch
+	for _, serviceIntentions := range intentions {
+		destNS := normalizeIntentionNamespace(serviceIntentions.Spec.Destination.Namespace)
+		if !matchesIntentionName(serviceIntentions.Spec.Destination.Name, req.Destination.Name) ||
+			!matchesIntentionName(destNS, normalizeIntentionNamespace(req.Destination.Namespace)) {
+			continue
+		}
+		for _, source := range serviceIntentions.Spec.Sources {
+			if !source.matches(destNS, req.Source) {
+				continue
+			}
+			precedence := intentionPrecedence(destNS, serviceIntentions.Spec.Destination.Name, source.namespaceOr(destNS), source.Name)
+			if match == nil || precedence > match.precedence {
+				match = &intentionMatch{
+					intentions: serviceIntentions,
+					source:     source,
+					precedence: precedence,
+				}
+			}
+		}
+	}
+
+	if match == nil {
+		return defaultIntentionDecision(defaultAllow, "no intention matches the request")
+	}
+
+	decision := IntentionDecision{
+		Intentions: match.intentions,
+		Source:     match.source,
+		Precedence: match.precedence,
+	}
+	if len(match.source.Permissions) == 0 {
+		decision.Allowed = match.source.Action.toConsul() == capi.IntentionActionAllow
+		decision.Reason = fmt.Sprintf("intention from %s to %s has action %q",
+			match.source.describe(), match.intentions.describeDestination(), match.source.Action)
+		return decision
+	}
+
+	for i, permission := range match.source.Permissions {
+		if !permission.matches(req.HTTP) {
+			continue
+		}
+		decision.Allowed = permission.Action.toConsul() == capi.IntentionActionAllow
+		decision.Permission = permission
+		decision.Reason = fmt.Sprintf("permission %d of intention from %s to %s has action %q",
+			i, match.source.describe(), match.intentions.describeDestination(), permission.Action)
+		return decision
+	}
+
+	reason := fmt.Sprintf("no permission of intention from %s to %s matches the request",
+		match.source.describe(), match.intentions.describeDestination())
+	if req.HTTP == nil {
+		reason = fmt.Sprintf("intention from %s to %s only has L7 permissions but the request is not an HTTP request",
+			match.source.describe(), match.intentions.describeDestination())
+	}
+	defaultDecision := defaultIntentionDecision(defaultAllow, reason)
+	decision.Allowed = defaultDecision.Allowed
+	decision.Reason = defaultDecision.Reason
+	return decision
+}
+
+type intentionMatch struct {
+	intentions *ServiceIntentions
+	source     *SourceIntention
+	precedence int
+}
+
+func defaultIntentionDecision(defaultAllow bool, reason string) IntentionDecision {
+	action := capi.IntentionActionDeny
+	if defaultAllow {
+		action = capi.IntentionActionAllow
+	}
+	return IntentionDecision{
+		Allowed: defaultAllow,
+		Reason:  fmt.Sprintf("%s, using the default action %q", reason, action),
+	}
+}
+
+// intentionPrecedence returns the precedence of an intention. It mirrors the
+// precedence table documented by Consul: intentions with exact destinations
+// take precedence over wildcard destinations, and for the same destination,
+// exact sources take precedence over wildcard sources.
+func intentionPrecedence(destNS, destName, sourceNS, sourceName string) int {
+	precedence := 0
+	switch {
+	case destNS != wildcardIntentionName && destName != wildcardIntentionName:
+		precedence = 6
+	case destNS != wildcardIntentionName:
+		precedence = 3
+	}
+	switch {
+	case sourceNS != wildcardIntentionName && sourceName != wildcardIntentionName:
+		precedence += 3
+	case sourceNS != wildcardIntentionName:
+		precedence += 2
+	default:
+		precedence++
+	}
+	return precedence
+}
+
+func matchesIntentionName(pattern, name string) bool {
+	return pattern == wildcardIntentionName || pattern == name
+}
+
+func normalizeIntentionNamespace(namespace string) string {
+	if namespace == "" {
+		return defaultIntentionNamespace
+	}
+	return namespace
+}
+
+func normalizeIntentionPartition(partition string) string {
+	if partition == "" {
+		return defaultIntentionPartition
+	}
+	return partition
+}
+
+// namespaceOr returns the namespace of the source, or destNS if it is not
+// set since Consul defaults it to the namespace of the destination.
+func (in *SourceIntention) namespaceOr(destNS string) string {
+	if in.Namespace == "" {
+		return destNS
+	}
+	return in.Namespace
+}
+
+func (in *SourceIntention) matches(destNS string, source IntentionRequestService) bool {
+	if in.Peer != source.Peer {
+		return false
+	}
+	// Sources from peers don't have a partition.
+	if source.Peer == "" && normalizeIntentionPartition(in.Partition) != normalizeIntentionPartition(source.Partition) {
+		return false
+	}
+	return matchesIntentionName(in.namespaceOr(destNS), normalizeIntentionNamespace(source.Namespace)) &&
+		matchesIntentionName(in.Name, source.Name)
+}
+
+func (in *SourceIntention) describe() string {
+	name := in.Name
+	if in.Namespace != "" {
+		name = in.Namespace + "/" + name
+	}
+	if in.Partition != "" {
+		name = in.Partition + "/" + name
+	}
+	if in.Peer != "" {
+		name = fmt.Sprintf("%s (peer %s)", name, in.Peer)
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+func (in *ServiceIntentions) describeDestination() string {
+	name := in.Spec.Destination.Name
+	if in.Spec.Destination.Namespace != "" {
+		name = in.Spec.Destination.Namespace + "/" + name
+	}
+	return fmt.Sprintf("%q (%s/%s)", name, in.Namespace, in.Name)
+}
+
+// matches returns true if the permission matches the HTTP request. A
+// permission never matches a request that isn't an HTTP request.
+func (in *IntentionPermission) matches(req *IntentionHTTPRequest) bool {
+	if req == nil {
+		return false
+	}
+	if in.HTTP == nil {
+		return true
+	}
+	return in.HTTP.matches(req)
+}
+
+func (in *IntentionHTTPPermission) matches(req *IntentionHTTPRequest) bool {
+	switch {
+	case in.PathExact != "":
+		if req.Path != in.PathExact {
+			return false
+		}
+	case in.PathPrefix != "":
+		if !strings.HasPrefix(req.Path, in.PathPrefix) {
+			return false
+		}
+	case in.PathRegex != "":
+		if !matchesFullRegex(in.PathRegex, req.Path) {
+			return false
+		}
+	}
+	if len(in.Methods) > 0 && !sliceContains(in.Methods, strings.ToUpper(req.Method)) {
+		return false
+	}
+	for _, header := range in.Header {
+		if !header.matches(req.Header) {
+			return false
+		}
+	}
+	return true
+}
+
+func (in IntentionHTTPHeaderPermission) matches(header map[string]string) bool {
+	value, present := lookupHeader(header, in.Name)
+	var matches bool
+	switch {
+	case in.Present:
+		matches = present
+	case in.Exact != "":
+		matches = present && value == in.Exact
+	case in.Prefix != "":
+		matches = present && strings.HasPrefix(value, in.Prefix)
+	case in.Suffix != "":
+		matches = present && strings.HasSuffix(value, in.Suffix)
+	case in.Regex != "":
+		matches = present && matchesFullRegex(in.Regex, value)
+	}
+	if in.Invert {
+		return !matches
+	}
+	return matches
+}
+
+func lookupHeader(header map[string]string, name string) (string, bool) {
+	for k, v := range header {
+		if strings.EqualFold(k, name) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// matchesFullRegex returns true if pattern matches all of s, which is how
+// Envoy evaluates regular expressions. Invalid patterns never match.
+func matchesFullRegex(pattern, s string) bool {
+	re, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return false
+	}
+	return re.MatchString(s)
+}
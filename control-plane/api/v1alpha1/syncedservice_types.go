@@ -0,0 +1,167 @@
+package v1alpha1
+
+import (
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const SyncedServiceKubeKind = "syncedservice"
+
+func init() {
+	SchemeBuilder.Register(&SyncedService{}, &SyncedServiceList{})
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+
+// SyncedService is the Schema for the syncedservices API. It configures how
+// a Kubernetes service is synced to the Consul catalog and reports the
+// status of the sync.
+// +kubebuilder:printcolumn:name="Service",type="string",JSONPath=".spec.service",description="The Kubernetes service that is synced"
+// +kubebuilder:printcolumn:name="Instances",type="integer",JSONPath=".status.instances",description="The number of service instances registered in Consul"
+// +kubebuilder:printcolumn:name="Synced",type="string",JSONPath=".status.conditions[?(@.type==\"Synced\")].status",description="The sync status of the resource with Consul"
+// +kubebuilder:printcolumn:name="Last Synced",type="date",JSONPath=".status.lastSyncedTime",description="The last successful synced time of the resource with Consul"
+// +kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp",description="The age of the resource"
+// +kubebuilder:resource:shortName="synced-service"
+type SyncedService struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SyncedServiceSpec   `json:"spec,omitempty"`
+	Status SyncedServiceStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SyncedServiceList contains a list of SyncedService.
+type SyncedServiceList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SyncedService `json:"items"`
+}
+
+// SyncedServiceSpec defines the desired state of SyncedService.
+// A SyncedService enables the sync of the service it references regardless
+// of the sync annotations on the service, and its fields take precedence
+// over them.
+type SyncedServiceSpec struct {
+	// Service is the name of the Kubernetes service in the namespace of the
+	// resource that is synced to Consul.
+	Service string `json:"service"`
+	// ConsulName is the name of the service in Consul. Defaults to the name
+	// of the Kubernetes service with the configured prefix and suffix.
+	ConsulName string `json:"consulName,omitempty"`
+	// Port is the name or number of the port of the Kubernetes service to
+	// register in Consul. Defaults to the first port.
+	Port string `json:"port,omitempty"`
+	// Tags are added to the tags of the service instances in Consul.
+	Tags []string `json:"tags,omitempty"`
+	// Meta is added to the meta of the service instances in Consul.
+	Meta map[string]string `json:"meta,omitempty"`
+	// ConsulNamespace is the Consul namespace to register the service in.
+	// Only used when Consul namespaces are enabled. Defaults to the namespace
+	// the sync process registers services in.
+	ConsulNamespace string `json:"consulNamespace,omitempty"`
+	// Health configures the health checks of the service instances.
+	Health *SyncedServiceHealth `json:"health,omitempty"`
+}
+
+// SyncedServiceHealth configures the health checks of synced service
+// instances.
+type SyncedServiceHealth struct {
+	// SyncReadinessChecks registers not ready endpoints as well as ready ones
+	// with a health check that reflects the readiness of their endpoint.
+	// Defaults to the setting of the sync process.
+	SyncReadinessChecks *bool `json:"syncReadinessChecks,omitempty"`
+}
+
+// SyncedServiceStatus defines the observed state of SyncedService.
+type SyncedServiceStatus struct {
+	// Instances is the number of service instances registered in Consul.
+	Instances int `json:"instances"`
+	// Conditions indicate the latest available observations of a resource's current state.
+	// +optional
+	// +patchMergeKey=type
+	// +patchStrategy=merge
+	Conditions Conditions `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+	// LastSyncedTime is the last time the resource successfully synced with Consul.
+	// +optional
+	LastSyncedTime *metav1.Time `json:"lastSyncedTime,omitempty" description:"last time the condition transitioned from one status to another"`
+}
+
+func (in *SyncedService) KubeKind() string {
+	return SyncedServiceKubeKind
+}
+
+func (in *SyncedService) KubernetesName() string {
+	return in.ObjectMeta.Name
+}
+
+// SyncedCondition returns the Synced condition of the resource or nil if it
+// has none.
+func (in *SyncedService) SyncedCondition() *Condition {
+	for i, cond := range in.Status.Conditions {
+		if cond.Type == ConditionSynced {
+			return &in.Status.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetSyncedCondition sets the Synced condition of the resource. The last
+// transition time is only updated if the status, reason or message change.
+func (in *SyncedService) SetSyncedCondition(status corev1.ConditionStatus, reason string, message string) {
+	if cond := in.SyncedCondition(); cond != nil &&
+		cond.Status == status && cond.Reason == reason && cond.Message == message {
+		return
+	}
+	in.Status.Conditions = Conditions{
+		{
+			Type:               ConditionSynced,
+			Status:             status,
+			LastTransitionTime: metav1.Now(),
+			Reason:             reason,
+			Message:            message,
+		},
+	}
+}
+
+func (in *SyncedService) Validate() error {
+	var errs field.ErrorList
+	path := field.NewPath("spec")
+
+	if in.Spec.Service == "" {
+		errs = append(errs, field.Required(path.Child("service"), "service must be specified"))
+	} else {
+		for _, msg := range validation.IsDNS1035Label(in.Spec.Service) {
+			errs = append(errs, field.Invalid(path.Child("service"), in.Spec.Service, msg))
+		}
+	}
+
+	if in.Spec.Port != "" {
+		if port, err := strconv.ParseInt(in.Spec.Port, 0, 0); err == nil {
+			for _, msg := range validation.IsValidPortNum(int(port)) {
+				errs = append(errs, field.Invalid(path.Child("port"), in.Spec.Port, msg))
+			}
+		}
+	}
+
+	for i, tag := range in.Spec.Tags {
+		if tag == "" {
+			errs = append(errs, field.Invalid(path.Child("tags").Index(i), tag, "tags must not be empty"))
+		}
+	}
+
+	if len(errs) > 0 {
+		return apierrors.NewInvalid(
+			schema.GroupKind{Group: ConsulHashicorpGroup, Kind: SyncedServiceKubeKind},
+			in.KubernetesName(), errs)
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package v1alpha1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestSyncedService_Validate(t *testing.T) {
+	cases := map[string]struct {
+		syncedService   *SyncedService
+		expectedErrMsgs []string
+	}{
+		"valid": {
+			syncedService: &SyncedService{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "web",
+				},
+				Spec: SyncedServiceSpec{
+					Service:    "web",
+					ConsulName: "web-k8s",
+					Port:       "http",
+					Tags:       []string{"v1"},
+					Meta:       map[string]string{"team": "a"},
+				},
+			},
+		},
+		"valid port number": {
+			syncedService: &SyncedService{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "web",
+				},
+				Spec: SyncedServiceSpec{
+					Service: "web",
+					Port:    "8080",
+				},
+			},
+		},
+		"no service specified": {
+			syncedService: &SyncedService{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "web",
+				},
+			},
+			expectedErrMsgs: []string{
+				`spec.service: Required value: service must be specified`,
+			},
+		},
+		"invalid service, port and tags": {
+			syncedService: &SyncedService{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: "web",
+				},
+				Spec: SyncedServiceSpec{
+					Service: "Web_1",
+					Port:    "70000",
+					Tags:    []string{"v1", ""},
+				},
+			},
+			expectedErrMsgs: []string{
+				`spec.service: Invalid value: "Web_1"`,
+				`spec.port: Invalid value: "70000": must be between 1 and 65535, inclusive`,
+				`spec.tags[1]: Invalid value: "": tags must not be empty`,
+			},
+		},
+	}
+
+	for name, testCase := range cases {
+		t.Run(name, func(t *testing.T) {
+			err := testCase.syncedService.Validate()
+			if len(testCase.expectedErrMsgs) != 0 {
+				require.Error(t, err)
+				for _, s := range testCase.expectedErrMsgs {
+					require.Contains(t, err.Error(), s)
+				}
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSyncedService_SetSyncedCondition(t *testing.T) {
+	syncedService := &SyncedService{}
+	syncedService.SetSyncedCondition(corev1.ConditionFalse, "Conflict", "message")
+	cond := syncedService.SyncedCondition()
+	require.NotNil(t, cond)
+	require.True(t, cond.IsFalse())
+	transitionTime := cond.LastTransitionTime
+
+	// The transition time is kept if nothing changes.
+	syncedService.Status.Conditions[0].LastTransitionTime = metav1.NewTime(transitionTime.Add(-1))
+	syncedService.SetSyncedCondition(corev1.ConditionFalse, "Conflict", "message")
+	require.Equal(t, metav1.NewTime(transitionTime.Add(-1)), syncedService.SyncedCondition().LastTransitionTime)
+
+	syncedService.SetSyncedCondition(corev1.ConditionTrue, "", "")
+	require.Len(t, syncedService.Status.Conditions, 1)
+	require.True(t, syncedService.SyncedCondition().IsTrue())
+}
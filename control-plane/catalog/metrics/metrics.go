@@ -0,0 +1,176 @@
+// Package metrics contains the Prometheus metrics of the catalog sync in
+// both directions.
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// DirectionToConsul and DirectionToK8S are the values of the direction
+	// label.
+	DirectionToConsul = "to-consul"
+	DirectionToK8S    = "to-k8s"
+
+	// Operations of Consul API calls that are counted when they fail.
+	OpRegister        = "register"
+	OpDeregister      = "deregister"
+	OpCreateNamespace = "create_namespace"
+	OpCatalogService  = "catalog_service"
+	OpCatalogServices = "catalog_services"
+	OpCatalogNodes    = "catalog_nodes"
+	OpNodeServices    = "node_services"
+	OpHealthService   = "health_service"
+	OpListNamespaces  = "list_namespaces"
+	OpListPartitions  = "list_partitions"
+
+	// Watch loops that are counted when they restart after an error.
+	WatchReapableServices = "reapable_services"
+	WatchService          = "service"
+	WatchConsulServices   = "consul_services"
+	WatchConsulNamespaces = "consul_namespaces"
+	WatchServiceInstances = "service_instances"
+)
+
+// Metrics are the metrics of the catalog sync. All methods may be called on
+// a nil *Metrics, in which case they do nothing, so that metrics are
+// optional for the syncers.
+type Metrics struct {
+	registrations   *prometheus.CounterVec
+	deregistrations *prometheus.CounterVec
+	reaped          *prometheus.CounterVec
+	consulErrors    *prometheus.CounterVec
+	services        *prometheus.GaugeVec
+	watchRestarts   *prometheus.CounterVec
+	lastSync        prometheus.Gauge
+
+	lock sync.Mutex
+
+	// namespaces holds the namespaces the number of services was set for
+	// per direction.
+	namespaces map[string]map[string]struct{}
+}
+
+// New returns Metrics registered with reg.
+func New(reg prometheus.Registerer) *Metrics {
+	m := &Metrics{
+		registrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "consul_sync_catalog_registrations_total",
+			Help: "The number of services registered in Consul or created and updated in Kubernetes.",
+		}, []string{"direction", "namespace"}),
+		deregistrations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "consul_sync_catalog_deregistrations_total",
+			Help: "The number of services deregistered from Consul or deleted from Kubernetes.",
+		}, []string{"direction", "namespace"}),
+		reaped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "consul_sync_catalog_reaped_services_total",
+			Help: "The number of services in Consul found to be no longer valid and scheduled for deregistration.",
+		}, []string{"namespace"}),
+		consulErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "consul_sync_catalog_consul_api_errors_total",
+			Help: "The number of failed Consul API calls.",
+		}, []string{"direction", "operation", "namespace"}),
+		services: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "consul_sync_catalog_services",
+			Help: "The number of Kubernetes services eligible for sync to Consul, or watched by the sync to Kubernetes.",
+		}, []string{"direction", "namespace"}),
+		watchRestarts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "consul_sync_catalog_watch_restarts_total",
+			Help: "The number of times a watch loop restarted after an error.",
+		}, []string{"direction", "watch", "namespace"}),
+		lastSync: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "consul_sync_catalog_last_sync_timestamp_seconds",
+			Help: "The Unix time of the last full sync to Consul without errors, or zero if there was none.",
+		}),
+	}
+
+	reg.MustRegister(
+		m.registrations,
+		m.deregistrations,
+		m.reaped,
+		m.consulErrors,
+		m.services,
+		m.watchRestarts,
+		m.lastSync,
+	)
+	return m
+}
+
+// Registered counts a registered service.
+func (m *Metrics) Registered(direction, namespace string) {
+	if m == nil {
+		return
+	}
+	m.registrations.WithLabelValues(direction, namespace).Inc()
+}
+
+// Deregistered counts a deregistered service.
+func (m *Metrics) Deregistered(direction, namespace string) {
+	if m == nil {
+		return
+	}
+	m.deregistrations.WithLabelValues(direction, namespace).Inc()
+}
+
+// Reaped counts a service scheduled for deregistration because it's no
+// longer valid.
+func (m *Metrics) Reaped(namespace string) {
+	if m == nil {
+		return
+	}
+	m.reaped.WithLabelValues(namespace).Inc()
+}
+
+// ConsulError counts a failed Consul API call.
+func (m *Metrics) ConsulError(direction, operation, namespace string) {
+	if m == nil {
+		return
+	}
+	m.consulErrors.WithLabelValues(direction, operation, namespace).Inc()
+}
+
+// SetServices sets the number of services of a direction per namespace.
+// Namespaces that are not in counts are removed.
+func (m *Metrics) SetServices(direction string, counts map[string]int) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.namespaces == nil {
+		m.namespaces = make(map[string]map[string]struct{})
+	}
+	for namespace := range m.namespaces[direction] {
+		if _, ok := counts[namespace]; !ok {
+			m.services.DeleteLabelValues(direction, namespace)
+		}
+	}
+
+	namespaces := make(map[string]struct{}, len(counts))
+	for namespace, count := range counts {
+		m.services.WithLabelValues(direction, namespace).Set(float64(count))
+		namespaces[namespace] = struct{}{}
+	}
+	m.namespaces[direction] = namespaces
+}
+
+// WatchRestarted counts a restart of a watch loop after an error.
+func (m *Metrics) WatchRestarted(direction, watch, namespace string) {
+	if m == nil {
+		return
+	}
+	m.watchRestarts.WithLabelValues(direction, watch, namespace).Inc()
+}
+
+// Synced records a full sync to Consul without errors. Only the leader
+// syncs, so the time stays at zero on standbys rather than growing.
+func (m *Metrics) Synced() {
+	if m == nil {
+		return
+	}
+	m.lastSync.Set(float64(time.Now().UnixNano()) / float64(time.Second))
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+)
+
+// Test that the methods of a nil *Metrics do nothing.
+func TestMetrics_nil(t *testing.T) {
+	var m *Metrics
+	m.Registered(DirectionToConsul, "")
+	m.Deregistered(DirectionToConsul, "")
+	m.Reaped("")
+	m.ConsulError(DirectionToConsul, OpRegister, "")
+	m.SetServices(DirectionToConsul, map[string]int{"": 1})
+	m.WatchRestarted(DirectionToConsul, WatchService, "")
+	m.Synced()
+}
+
+func TestMetrics_counters(t *testing.T) {
+	m := New(prometheus.NewRegistry())
+
+	m.Registered(DirectionToConsul, "ns1")
+	m.Registered(DirectionToConsul, "ns1")
+	m.Registered(DirectionToK8S, "ns1")
+	m.Deregistered(DirectionToConsul, "ns2")
+	m.Reaped("ns1")
+	m.ConsulError(DirectionToConsul, OpRegister, "ns1")
+	m.WatchRestarted(DirectionToK8S, WatchServiceInstances, "ns1")
+
+	require.Equal(t, float64(2), testutil.ToFloat64(m.registrations.WithLabelValues(DirectionToConsul, "ns1")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.registrations.WithLabelValues(DirectionToK8S, "ns1")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.deregistrations.WithLabelValues(DirectionToConsul, "ns2")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.reaped.WithLabelValues("ns1")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.consulErrors.WithLabelValues(DirectionToConsul, OpRegister, "ns1")))
+	require.Equal(t, float64(1), testutil.ToFloat64(m.watchRestarts.WithLabelValues(DirectionToK8S, WatchServiceInstances, "ns1")))
+}
+
+// Test that the number of services of namespaces that are gone is removed
+// without affecting the other direction.
+func TestMetrics_SetServices(t *testing.T) {
+	m := New(prometheus.NewRegistry())
+
+	m.SetServices(DirectionToConsul, map[string]int{"ns1": 2, "ns2": 1})
+	m.SetServices(DirectionToK8S, map[string]int{"": 3})
+	require.Equal(t, 3, testutil.CollectAndCount(m.services))
+
+	m.SetServices(DirectionToConsul, map[string]int{"ns1": 4})
+	require.Equal(t, 2, testutil.CollectAndCount(m.services))
+	require.Equal(t, float64(4), testutil.ToFloat64(m.services.WithLabelValues(DirectionToConsul, "ns1")))
+	require.Equal(t, float64(3), testutil.ToFloat64(m.services.WithLabelValues(DirectionToK8S, "")))
+}
+
+func TestMetrics_Synced(t *testing.T) {
+	m := New(prometheus.NewRegistry())
+	require.Zero(t, testutil.ToFloat64(m.lastSync))
+
+	before := time.Now()
+	m.Synced()
+	lastSync := testutil.ToFloat64(m.lastSync)
+	require.GreaterOrEqual(t, lastSync, float64(before.Unix()))
+	require.LessOrEqual(t, lastSync, float64(time.Now().Unix()+1))
+}
@@ -15,6 +15,11 @@ const (
 	// service or an integer value.
 	annotationServicePort = "consul.hashicorp.com/service-port"
 
+	// annotationServicePortSync specifies how the ports of a service are
+	// synced. It overrides the default of the syncer configuration and must
+	// be one of the ServicePortSyncType values.
+	annotationServicePortSync = "consul.hashicorp.com/service-port-sync"
+
 	// annotationServiceTags specifies the tags for the registered service
 	// instance. Multiple tags should be comma separated. Whitespace around
 	// the tags is automatically trimmed.
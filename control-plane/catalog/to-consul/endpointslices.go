@@ -0,0 +1,216 @@
+package catalog
+
+import (
+	"context"
+	"sort"
+
+	apiv1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// serviceEndpointSlicesResource implements controller.Resource and starts
+// a background watcher on EndpointSlices that is used by the ServiceResource
+// to keep track of changing endpoints for registered services when
+// EndpointSlicesSync is set. It is the counterpart of
+// serviceEndpointsResource.
+type serviceEndpointSlicesResource struct {
+	Service *ServiceResource
+	Ctx     context.Context
+}
+
+func (t *serviceEndpointSlicesResource) Informer() cache.SharedIndexInformer {
+	// Watch all k8s namespaces. Events will be filtered out as appropriate in the
+	// `shouldTrackEndpoints` function like for Endpoints.
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return t.Service.Client.DiscoveryV1().
+					EndpointSlices(metav1.NamespaceAll).
+					List(t.Ctx, options)
+			},
+
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return t.Service.Client.DiscoveryV1().
+					EndpointSlices(metav1.NamespaceAll).
+					Watch(t.Ctx, options)
+			},
+		},
+		&discoveryv1.EndpointSlice{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+func (t *serviceEndpointSlicesResource) Upsert(key string, raw interface{}) error {
+	svc := t.Service
+	slice, ok := raw.(*discoveryv1.EndpointSlice)
+	if !ok {
+		svc.Log.Warn("upsert got invalid type", "raw", raw)
+		return nil
+	}
+
+	// EndpointSlices that don't belong to a service are of no interest.
+	serviceName := slice.Labels[discoveryv1.LabelServiceName]
+	if serviceName == "" {
+		return nil
+	}
+	serviceKey := slice.Namespace + "/" + serviceName
+
+	svc.serviceLock.Lock()
+	defer svc.serviceLock.Unlock()
+
+	// Check if we care about endpoints for this service
+	if !svc.shouldTrackEndpoints(serviceKey) {
+		return nil
+	}
+
+	if svc.endpointSlicesMap == nil {
+		svc.endpointSlicesMap = make(map[string]map[string]*discoveryv1.EndpointSlice)
+	}
+	if svc.endpointSlicesMap[serviceKey] == nil {
+		svc.endpointSlicesMap[serviceKey] = make(map[string]*discoveryv1.EndpointSlice)
+	}
+	svc.endpointSlicesMap[serviceKey][key] = slice
+	svc.setEndpointsFromSlices(serviceKey)
+
+	// Update the registration and trigger a sync
+	svc.generateRegistrations(serviceKey)
+	svc.sync()
+	svc.Log.Info("upsert endpointslice", "key", key, "service", serviceKey)
+	return nil
+}
+
+func (t *serviceEndpointSlicesResource) Delete(key string, raw interface{}) error {
+	svc := t.Service
+	svc.serviceLock.Lock()
+	defer svc.serviceLock.Unlock()
+
+	// Find the service of the EndpointSlice. The deleted object may not be
+	// available so fall back to looking the slice up.
+	var serviceKey string
+	if slice, ok := raw.(*discoveryv1.EndpointSlice); ok && slice.Labels[discoveryv1.LabelServiceName] != "" {
+		serviceKey = slice.Namespace + "/" + slice.Labels[discoveryv1.LabelServiceName]
+	} else {
+		for k, slices := range svc.endpointSlicesMap {
+			if _, ok := slices[key]; ok {
+				serviceKey = k
+				break
+			}
+		}
+	}
+
+	// We only want to force a resync if we were tracking this EndpointSlice
+	// to begin with.
+	if _, ok := svc.endpointSlicesMap[serviceKey][key]; ok {
+		delete(svc.endpointSlicesMap[serviceKey], key)
+		svc.setEndpointsFromSlices(serviceKey)
+		svc.generateRegistrations(serviceKey)
+		svc.sync()
+	}
+
+	svc.Log.Info("delete endpointslice", "key", key, "service", serviceKey)
+	return nil
+}
+
+// loadEndpointSlices does the initial load of the EndpointSlices of the
+// service with the given key.
+//
+// Precondition: the lock t.lock is held.
+func (t *ServiceResource) loadEndpointSlices(key string, service *apiv1.Service) {
+	list, err := t.Client.DiscoveryV1().
+		EndpointSlices(service.Namespace).
+		List(t.Ctx, metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(labels.Set{discoveryv1.LabelServiceName: service.Name}).String(),
+		})
+	if err != nil {
+		t.Log.Warn("error loading initial endpointslices",
+			"key", key,
+			"err", err)
+		return
+	}
+
+	slices := make(map[string]*discoveryv1.EndpointSlice, len(list.Items))
+	for i := range list.Items {
+		slice := &list.Items[i]
+		slices[slice.Namespace+"/"+slice.Name] = slice
+	}
+	if t.endpointSlicesMap == nil {
+		t.endpointSlicesMap = make(map[string]map[string]*discoveryv1.EndpointSlice)
+	}
+	t.endpointSlicesMap[key] = slices
+	t.setEndpointsFromSlices(key)
+	t.Log.Debug("[ServiceResource.Upsert] adding service's endpointslices to endpointSlicesMap", "key", key, "service", service, "endpointslices", len(slices))
+}
+
+// setEndpointsFromSlices sets the endpoints of the service with the given
+// key in endpointsMap to the ones converted from its EndpointSlices so that
+// the registrations are generated the same way for both.
+//
+// Precondition: the lock t.lock is held.
+func (t *ServiceResource) setEndpointsFromSlices(key string) {
+	if t.endpointsMap == nil {
+		t.endpointsMap = make(map[string]*apiv1.Endpoints)
+	}
+	t.endpointsMap[key] = endpointsFromSlices(t.endpointSlicesMap[key])
+}
+
+// endpointsFromSlices converts EndpointSlices into Endpoints with a subset
+// per EndpointSlice. Endpoints without a ready condition are considered
+// ready like Kubernetes does. EndpointSlices of FQDN addresses are ignored
+// since Endpoints only support IP addresses.
+func endpointsFromSlices(slices map[string]*discoveryv1.EndpointSlice) *apiv1.Endpoints {
+	keys := make([]string, 0, len(slices))
+	for k := range slices {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	endpoints := &apiv1.Endpoints{}
+	for _, k := range keys {
+		slice := slices[k]
+		if slice.AddressType == discoveryv1.AddressTypeFQDN {
+			continue
+		}
+
+		var subset apiv1.EndpointSubset
+		for _, p := range slice.Ports {
+			port := apiv1.EndpointPort{}
+			if p.Name != nil {
+				port.Name = *p.Name
+			}
+			if p.Port != nil {
+				port.Port = *p.Port
+			}
+			if p.Protocol != nil {
+				port.Protocol = *p.Protocol
+			}
+			subset.Ports = append(subset.Ports, port)
+		}
+
+		for _, ep := range slice.Endpoints {
+			ready := ep.Conditions.Ready == nil || *ep.Conditions.Ready
+			for _, ip := range ep.Addresses {
+				addr := apiv1.EndpointAddress{
+					IP:        ip,
+					NodeName:  ep.NodeName,
+					TargetRef: ep.TargetRef,
+				}
+				if ep.Hostname != nil {
+					addr.Hostname = *ep.Hostname
+				}
+				if ready {
+					subset.Addresses = append(subset.Addresses, addr)
+				} else {
+					subset.NotReadyAddresses = append(subset.NotReadyAddresses, addr)
+				}
+			}
+		}
+		endpoints.Subsets = append(endpoints.Subsets, subset)
+	}
+	return endpoints
+}
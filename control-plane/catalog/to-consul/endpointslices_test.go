@@ -0,0 +1,392 @@
+package catalog
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/control-plane/helper/controller"
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test that the instances of a service are registered from its
+// EndpointSlices and follow changes to them.
+func TestServiceResource_endpointSlices(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ClusterIPSync = true
+	serviceResource.EndpointSlicesSync = true
+
+	// Insert an EndpointSlice before the service to test the initial load.
+	createEndpointSlice(t, client, "foo-abc", "foo", metav1.NamespaceDefault, "1.1.1.1", nodeName1, true)
+
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	svc := clusterIPService("foo", metav1.NamespaceDefault)
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 1)
+		require.Equal(r, "foo", actual[0].Service.Service)
+		require.Equal(r, "1.1.1.1", actual[0].Service.Address)
+		require.Equal(r, 8080, actual[0].Service.Port)
+		require.Equal(r, "foobar", actual[0].Service.Meta[ConsulK8SRefValue])
+		require.Equal(r, nodeName1, actual[0].Service.Meta[ConsulK8SNodeName])
+	})
+
+	// A second EndpointSlice adds instances while not ready endpoints are
+	// ignored without readiness checks.
+	createEndpointSlice(t, client, "foo-def", "foo", metav1.NamespaceDefault, "2.2.2.2", nodeName2, true)
+	createEndpointSlice(t, client, "foo-ghi", "foo", metav1.NamespaceDefault, "3.3.3.3", nodeName2, false)
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		require.ElementsMatch(r, []string{"1.1.1.1", "2.2.2.2"}, registrationAddresses(syncer.Registrations))
+	})
+
+	// Deleting an EndpointSlice removes its instances.
+	err = client.DiscoveryV1().EndpointSlices(metav1.NamespaceDefault).Delete(context.Background(), "foo-abc", metav1.DeleteOptions{})
+	require.NoError(t, err)
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		require.ElementsMatch(r, []string{"2.2.2.2"}, registrationAddresses(syncer.Registrations))
+	})
+}
+
+// Test that not ready endpoints of EndpointSlices are registered with a
+// critical readiness check when readiness checks are synced.
+func TestServiceResource_endpointSlicesReadinessChecks(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ClusterIPSync = true
+	serviceResource.EndpointSlicesSync = true
+	serviceResource.SyncReadinessChecks = true
+
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	svc := clusterIPService("foo", metav1.NamespaceDefault)
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	createEndpointSlice(t, client, "foo-abc", "foo", metav1.NamespaceDefault, "1.1.1.1", nodeName1, true)
+	createEndpointSlice(t, client, "foo-def", "foo", metav1.NamespaceDefault, "2.2.2.2", nodeName2, false)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 2)
+		statuses := make(map[string]string)
+		for _, reg := range actual {
+			require.NotNil(r, reg.Check)
+			statuses[reg.Service.Address] = reg.Check.Status
+		}
+		require.Equal(r, map[string]string{
+			"1.1.1.1": consulapi.HealthPassing,
+			"2.2.2.2": consulapi.HealthCritical,
+		}, statuses)
+	})
+}
+
+// Test that each port of a multi-port ClusterIP service is registered as a
+// Consul service of its own with per-port sync.
+func TestServiceResource_perPort(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ClusterIPSync = true
+	serviceResource.ServicePortSync = ServicePortPerPort
+
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	svc := clusterIPService("foo", metav1.NamespaceDefault)
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	createEndpoints(t, client, "foo", metav1.NamespaceDefault)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 4)
+		ports := make(map[string]int)
+		ids := make(map[string]struct{})
+		for _, reg := range actual {
+			ports[reg.Service.Service+"/"+reg.Service.Address] = reg.Service.Port
+			ids[reg.Service.ID] = struct{}{}
+		}
+		require.Equal(r, map[string]int{
+			"foo-http/1.1.1.1": 8080,
+			"foo-http/2.2.2.2": 8080,
+			"foo-rpc/1.1.1.1":  2000,
+			"foo-rpc/2.2.2.2":  2000,
+		}, ports)
+		require.Len(r, ids, 4)
+	})
+}
+
+// Test that each node port of a multi-port NodePort service is registered as
+// a Consul service of its own with per-port sync.
+func TestServiceResource_perPortNodePort(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ServicePortSync = ServicePortPerPort
+
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	createNodes(t, client)
+	createEndpoints(t, client, "foo", metav1.NamespaceDefault)
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), nodePortService("foo", metav1.NamespaceDefault), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 4)
+		ports := make(map[string]int)
+		for _, reg := range actual {
+			ports[reg.Service.Service+"/"+reg.Service.Address] = reg.Service.Port
+		}
+		require.Equal(r, map[string]int{
+			"foo-http/1.2.3.4": 30000,
+			"foo-http/2.3.4.5": 30000,
+			"foo-rpc/1.2.3.4":  30001,
+			"foo-rpc/2.3.4.5":  30001,
+		}, ports)
+	})
+}
+
+// Test that the service-port-sync annotation overrides the default and that
+// an invalid value falls back to it.
+func TestServiceResource_servicePortSyncAnnotation(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		Default    ServicePortSyncType
+		Annotation string
+		Expected   []string
+	}{
+		"default": {
+			Expected: []string{"foo", "foo"},
+		},
+		"per-port annotation": {
+			Annotation: "PerPort",
+			Expected:   []string{"foo-http", "foo-http", "foo-rpc", "foo-rpc"},
+		},
+		"single annotation overrides per-port default": {
+			Default:    ServicePortPerPort,
+			Annotation: "Single",
+			Expected:   []string{"foo", "foo"},
+		},
+		"invalid annotation": {
+			Default:    ServicePortPerPort,
+			Annotation: "Foo",
+			Expected:   []string{"foo-http", "foo-http", "foo-rpc", "foo-rpc"},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client := fake.NewSimpleClientset()
+			syncer := newTestSyncer()
+			serviceResource := defaultServiceResource(client, syncer)
+			serviceResource.ClusterIPSync = true
+			serviceResource.ServicePortSync = c.Default
+
+			closer := controller.TestControllerRun(&serviceResource)
+			defer closer()
+
+			svc := clusterIPService("foo", metav1.NamespaceDefault)
+			if c.Annotation != "" {
+				svc.Annotations[annotationServicePortSync] = c.Annotation
+			}
+			_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+			require.NoError(t, err)
+			createEndpoints(t, client, "foo", metav1.NamespaceDefault)
+
+			retry.Run(t, func(r *retry.R) {
+				syncer.Lock()
+				defer syncer.Unlock()
+				var names []string
+				for _, reg := range syncer.Registrations {
+					names = append(names, reg.Service.Service)
+				}
+				require.ElementsMatch(r, c.Expected, names)
+			})
+		})
+	}
+}
+
+// Test that the port meta of the instances is set to the endpoint ports with
+// ports meta sync.
+func TestServiceResource_portsMeta(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ClusterIPSync = true
+	serviceResource.ServicePortSync = ServicePortMeta
+
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	svc := clusterIPService("foo", metav1.NamespaceDefault)
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	createEndpoints(t, client, "foo", metav1.NamespaceDefault)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 2)
+		for _, reg := range actual {
+			require.Equal(r, "foo", reg.Service.Service)
+			require.Equal(r, 8080, reg.Service.Port)
+			require.Equal(r, "8080", reg.Service.Meta["port-http"])
+			require.Equal(r, "2000", reg.Service.Meta["port-rpc"])
+		}
+	})
+}
+
+// Test that the port meta of the instances of NodePort services is set to
+// the node ports with ports meta sync.
+func TestServiceResource_nodePortPortsMeta(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ServicePortSync = ServicePortMeta
+
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	createNodes(t, client)
+	createEndpoints(t, client, "foo", metav1.NamespaceDefault)
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), nodePortService("foo", metav1.NamespaceDefault), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 2)
+		for _, reg := range actual {
+			require.Equal(r, 30000, reg.Service.Port)
+			require.Equal(r, "30000", reg.Service.Meta["port-http"])
+			require.Equal(r, "30001", reg.Service.Meta["port-rpc"])
+		}
+	})
+}
+
+func TestEndpointsFromSlices(t *testing.T) {
+	t.Parallel()
+	ready, notReady := true, false
+	http, port := "http", int32(8080)
+	node, hostname := nodeName1, "foo-0"
+	targetRef := &apiv1.ObjectReference{Kind: "pod", Name: "foo-0"}
+
+	actual := endpointsFromSlices(map[string]*discoveryv1.EndpointSlice{
+		"default/foo-b": {
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"2.2.2.2"}, Conditions: discoveryv1.EndpointConditions{Ready: &notReady}},
+			},
+			Ports: []discoveryv1.EndpointPort{{Name: &http, Port: &port}},
+		},
+		"default/foo-a": {
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"1.1.1.1"}, Conditions: discoveryv1.EndpointConditions{Ready: &ready}, NodeName: &node, Hostname: &hostname, TargetRef: targetRef},
+				{Addresses: []string{"3.3.3.3"}},
+			},
+			Ports: []discoveryv1.EndpointPort{{Name: &http, Port: &port}},
+		},
+		"default/foo-c": {
+			AddressType: discoveryv1.AddressTypeFQDN,
+			Endpoints: []discoveryv1.Endpoint{
+				{Addresses: []string{"foo.example.com"}},
+			},
+		},
+	})
+
+	require.Equal(t, &apiv1.Endpoints{
+		Subsets: []apiv1.EndpointSubset{
+			{
+				Addresses: []apiv1.EndpointAddress{
+					{IP: "1.1.1.1", Hostname: "foo-0", NodeName: &node, TargetRef: targetRef},
+					{IP: "3.3.3.3"},
+				},
+				Ports: []apiv1.EndpointPort{{Name: "http", Port: 8080}},
+			},
+			{
+				NotReadyAddresses: []apiv1.EndpointAddress{
+					{IP: "2.2.2.2"},
+				},
+				Ports: []apiv1.EndpointPort{{Name: "http", Port: 8080}},
+			},
+		},
+	}, actual)
+}
+
+// createEndpointSlice calls the fake k8s client to create an EndpointSlice
+// of a service with a single endpoint.
+func createEndpointSlice(t *testing.T, client *fake.Clientset, name, serviceName, namespace, ip, nodeName string, ready bool) {
+	http, rpc := "http", "rpc"
+	httpPort, rpcPort := int32(8080), int32(2000)
+	_, err := client.DiscoveryV1().EndpointSlices(namespace).Create(
+		context.Background(),
+		&discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{discoveryv1.LabelServiceName: serviceName},
+			},
+			AddressType: discoveryv1.AddressTypeIPv4,
+			Endpoints: []discoveryv1.Endpoint{
+				{
+					Addresses:  []string{ip},
+					Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+					NodeName:   &nodeName,
+					TargetRef:  &apiv1.ObjectReference{Kind: "pod", Name: "foobar"},
+				},
+			},
+			Ports: []discoveryv1.EndpointPort{
+				{Name: &http, Port: &httpPort},
+				{Name: &rpc, Port: &rpcPort},
+			},
+		},
+		metav1.CreateOptions{})
+	require.NoError(t, err)
+}
+
+// registrationAddresses returns the service addresses of the registrations.
+func registrationAddresses(rs []*consulapi.CatalogRegistration) []string {
+	addrs := make([]string, 0, len(rs))
+	for _, r := range rs {
+		addrs = append(addrs, r.Service.Address)
+	}
+	return addrs
+}
@@ -0,0 +1,266 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hashicorp/consul-k8s/control-plane/namespaces"
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// ConsulK8SIngressHost and ConsulK8SIngressPath are the keys used in the
+	// meta to record the host and path of the Ingress rule a service instance
+	// was registered for.
+	ConsulK8SIngressHost = "external-k8s-ingress-host"
+	ConsulK8SIngressPath = "external-k8s-ingress-path"
+
+	// ingressRefKind is the value of the ConsulK8SRefKind meta of service
+	// instances registered for Ingresses.
+	ingressRefKind = "ingress"
+
+	// ingressHTTPPort and ingressHTTPSPort are the ports of the service
+	// instances of Ingress rules without and with TLS.
+	ingressHTTPPort  = 80
+	ingressHTTPSPort = 443
+
+	// ingressServiceSuffix is appended to the name of an Ingress to get the
+	// name of its Consul service so that it doesn't collide with the service
+	// of a Kubernetes Service with the same name.
+	ingressServiceSuffix = "-ingress"
+)
+
+// IngressResource implements controller.Resource to sync the rules of
+// networking.k8s.io/v1 Ingress resources to Consul. Every path of every
+// rule is registered as an instance of a Consul service named after the
+// Ingress with an "-ingress" suffix for each load balancer address of the
+// Ingress, with the host and path of the rule in the service meta. The name
+// can be overridden with the service-name annotation.
+//
+// Ingresses follow the same namespace allow and deny lists and sync
+// annotations as Services. The registrations are synced by Service along
+// with the registrations of Services.
+type IngressResource struct {
+	Log    hclog.Logger
+	Client kubernetes.Interface
+
+	// Service is the ServiceResource that syncs the registrations and
+	// whose settings are used for the Ingresses.
+	Service *ServiceResource
+
+	// Ctx is used to cancel processes kicked off by IngressResource.
+	Ctx context.Context
+}
+
+// Informer implements the controller.Resource interface.
+func (t *IngressResource) Informer() cache.SharedIndexInformer {
+	// Watch all k8s namespaces. Ingresses will be filtered out by namespace
+	// in shouldSync.
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				return t.Client.NetworkingV1().Ingresses(metav1.NamespaceAll).List(t.Ctx, options)
+			},
+
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return t.Client.NetworkingV1().Ingresses(metav1.NamespaceAll).Watch(t.Ctx, options)
+			},
+		},
+		&networkingv1.Ingress{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+// Upsert implements the controller.Resource interface.
+func (t *IngressResource) Upsert(key string, raw interface{}) error {
+	ingress, ok := raw.(*networkingv1.Ingress)
+	if !ok {
+		t.Log.Warn("upsert got invalid type", "raw", raw)
+		return nil
+	}
+
+	if !t.shouldSync(ingress) {
+		t.Log.Debug("[IngressResource.Upsert] syncing disabled for ingress, ignoring", "key", key)
+		t.Service.SetIngressRegistrations(key, nil)
+		return nil
+	}
+
+	t.Service.SetIngressRegistrations(key, t.registrations(ingress))
+	t.Log.Info("upsert", "key", key)
+	return nil
+}
+
+// Delete implements the controller.Resource interface.
+func (t *IngressResource) Delete(key string, _ interface{}) error {
+	t.Service.SetIngressRegistrations(key, nil)
+	t.Log.Info("delete", "key", key)
+	return nil
+}
+
+// shouldSync returns true if the given Ingress should be synced.
+func (t *IngressResource) shouldSync(ingress *networkingv1.Ingress) bool {
+	// If in deny list, don't sync
+	if t.Service.DenyK8sNamespacesSet.Contains(ingress.Namespace) {
+		return false
+	}
+
+	// If not in allow list or allow list is not *, don't sync
+	if !t.Service.AllowK8sNamespacesSet.Contains("*") && !t.Service.AllowK8sNamespacesSet.Contains(ingress.Namespace) {
+		return false
+	}
+
+	raw, ok := ingress.Annotations[annotationServiceSync]
+	if !ok {
+		return !t.Service.ExplicitEnable
+	}
+
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		t.Log.Warn("error parsing service-sync annotation",
+			"ingress", ingress.Namespace+"/"+ingress.Name,
+			"err", err)
+		return !t.Service.ExplicitEnable
+	}
+
+	return v
+}
+
+// registrations returns the registrations of the rules of an Ingress.
+// Ingresses without a load balancer address have none.
+func (t *IngressResource) registrations(ingress *networkingv1.Ingress) []*consulapi.CatalogRegistration {
+	s := t.Service
+
+	var addresses []string
+	seen := make(map[string]struct{})
+	for _, lb := range ingress.Status.LoadBalancer.Ingress {
+		addr := lb.IP
+		if addr == "" {
+			addr = lb.Hostname
+		}
+		if addr == "" {
+			continue
+		}
+		if _, ok := seen[addr]; ok {
+			continue
+		}
+		seen[addr] = struct{}{}
+		addresses = append(addresses, addr)
+	}
+	if len(addresses) == 0 {
+		return nil
+	}
+
+	name := s.addPrefixAndK8SNamespace(ingress.Name+ingressServiceSuffix, ingress.Namespace)
+	if v, ok := ingress.Annotations[annotationServiceName]; ok {
+		name = strings.TrimSpace(v)
+	}
+
+	tags := []string{s.ConsulK8STag}
+	if rawTags, ok := ingress.Annotations[annotationServiceTags]; ok {
+		tags = append(tags, parseTags(rawTags)...)
+	}
+
+	meta := map[string]string{
+		ConsulSourceKey:   ConsulSourceValue,
+		ConsulK8SNS:       ingress.Namespace,
+		ConsulK8SRefKind:  ingressRefKind,
+		ConsulK8SRefValue: ingress.Name,
+	}
+	for k, v := range ingress.Annotations {
+		if strings.HasPrefix(k, annotationServiceMetaPrefix) {
+			meta[strings.TrimPrefix(k, annotationServiceMetaPrefix)] = v
+		}
+	}
+
+	var overridePort int
+	if v, ok := ingress.Annotations[annotationServicePort]; ok {
+		if port, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			overridePort = port
+		} else {
+			t.Log.Warn("error parsing service-port annotation of ingress, it must be a number",
+				"ingress", ingress.Namespace+"/"+ingress.Name, "err", err)
+		}
+	}
+
+	consulNS := namespaces.ConsulNamespace(ingress.Namespace,
+		s.EnableNamespaces,
+		s.ConsulDestinationNamespace,
+		s.EnableK8SNSMirroring,
+		s.K8SNSMirroringPrefix)
+
+	tlsHosts := make(map[string]struct{})
+	for _, tls := range ingress.Spec.TLS {
+		for _, host := range tls.Hosts {
+			tlsHosts[host] = struct{}{}
+		}
+	}
+
+	// An Ingress without rules sends all traffic to its default backend.
+	rules := ingress.Spec.Rules
+	if len(rules) == 0 && ingress.Spec.DefaultBackend != nil {
+		rules = []networkingv1.IngressRule{{}}
+	}
+
+	var rs []*consulapi.CatalogRegistration
+	for _, rule := range rules {
+		port := ingressHTTPPort
+		if _, ok := tlsHosts[rule.Host]; ok {
+			port = ingressHTTPSPort
+		}
+		if overridePort != 0 {
+			port = overridePort
+		}
+
+		paths := []string{""}
+		if rule.HTTP != nil && len(rule.HTTP.Paths) > 0 {
+			paths = paths[:0]
+			for _, path := range rule.HTTP.Paths {
+				paths = append(paths, path.Path)
+			}
+		}
+
+		for _, path := range paths {
+			for _, addr := range addresses {
+				instanceMeta := make(map[string]string, len(meta)+2)
+				for k, v := range meta {
+					instanceMeta[k] = v
+				}
+				if rule.Host != "" {
+					instanceMeta[ConsulK8SIngressHost] = rule.Host
+				}
+				if path != "" {
+					instanceMeta[ConsulK8SIngressPath] = path
+				}
+
+				rs = append(rs, &consulapi.CatalogRegistration{
+					SkipNodeUpdate: true,
+					Node:           s.ConsulNodeName,
+					Address:        "127.0.0.1",
+					NodeMeta: map[string]string{
+						ConsulSourceKey: ConsulSourceValue,
+					},
+					Service: &consulapi.AgentService{
+						ID:        serviceID(name, fmt.Sprintf("%s-%s%s", addr, rule.Host, path)),
+						Service:   name,
+						Tags:      tags,
+						Meta:      instanceMeta,
+						Address:   addr,
+						Port:      port,
+						Namespace: consulNS,
+					},
+				})
+			}
+		}
+	}
+	return rs
+}
@@ -0,0 +1,316 @@
+package catalog
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/hashicorp/consul-k8s/control-plane/helper/controller"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// Test that the rules of Ingresses are registered for each load balancer
+// address.
+func TestIngressResource(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ConsulK8STag = TestConsulK8STag
+	closer := startIngressResource(&serviceResource, client)
+	defer closer()
+
+	ingress := testIngress("web", metav1.NamespaceDefault, "1.2.3.4")
+	ingress.Annotations = map[string]string{
+		annotationServiceTags:                "v1",
+		annotationServiceMetaPrefix + "team": "a",
+	}
+	_, err := client.NetworkingV1().Ingresses(metav1.NamespaceDefault).Create(context.Background(), ingress, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 3)
+		sort.Slice(actual, func(i, j int) bool {
+			return actual[i].Service.Meta[ConsulK8SIngressHost]+actual[i].Service.Meta[ConsulK8SIngressPath] <
+				actual[j].Service.Meta[ConsulK8SIngressHost]+actual[j].Service.Meta[ConsulK8SIngressPath]
+		})
+
+		for _, reg := range actual {
+			require.Equal(r, ConsulSyncNodeName, reg.Node)
+			require.Equal(r, "web-ingress", reg.Service.Service)
+			require.Equal(r, "1.2.3.4", reg.Service.Address)
+			require.Equal(r, []string{TestConsulK8STag, "v1"}, reg.Service.Tags)
+			require.Equal(r, "a", reg.Service.Meta["team"])
+			require.Equal(r, ingressRefKind, reg.Service.Meta[ConsulK8SRefKind])
+			require.Equal(r, "web", reg.Service.Meta[ConsulK8SRefValue])
+		}
+
+		require.Equal(r, "api.example.com", actual[0].Service.Meta[ConsulK8SIngressHost])
+		require.Equal(r, "/", actual[0].Service.Meta[ConsulK8SIngressPath])
+		require.Equal(r, 80, actual[0].Service.Port)
+
+		require.Equal(r, "web.example.com", actual[1].Service.Meta[ConsulK8SIngressHost])
+		require.Equal(r, "/", actual[1].Service.Meta[ConsulK8SIngressPath])
+		require.Equal(r, 443, actual[1].Service.Port)
+		require.Equal(r, "/static", actual[2].Service.Meta[ConsulK8SIngressPath])
+		require.NotEqual(r, actual[1].Service.ID, actual[2].Service.ID)
+	})
+
+	// Disabling the sync with the annotation deregisters the service.
+	ingress.Annotations = map[string]string{annotationServiceSync: "false"}
+	_, err = client.NetworkingV1().Ingresses(metav1.NamespaceDefault).Update(context.Background(), ingress, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		require.Len(r, syncer.Registrations, 0)
+	})
+}
+
+// Test that Ingresses are registered along with Services and removed when
+// they are deleted.
+func TestIngressResource_withServices(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	closer := startIngressResource(&serviceResource, client)
+	defer closer()
+
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), lbService("foo", metav1.NamespaceDefault, "5.6.7.8"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	_, err = client.NetworkingV1().Ingresses(metav1.NamespaceDefault).Create(context.Background(), testIngress("web", metav1.NamespaceDefault, "1.2.3.4"), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		require.Len(r, syncer.Registrations, 4)
+	})
+
+	err = client.NetworkingV1().Ingresses(metav1.NamespaceDefault).Delete(context.Background(), "web", metav1.DeleteOptions{})
+	require.NoError(t, err)
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		require.Len(r, syncer.Registrations, 1)
+		require.Equal(r, "foo", syncer.Registrations[0].Service.Service)
+	})
+}
+
+func TestIngressResource_shouldSync(t *testing.T) {
+	cases := map[string]struct {
+		namespace      string
+		annotations    map[string]string
+		explicitEnable bool
+		expected       bool
+	}{
+		"default enabled": {
+			namespace: "default",
+			expected:  true,
+		},
+		"explicit enable without annotation": {
+			namespace:      "default",
+			explicitEnable: true,
+			expected:       false,
+		},
+		"explicit enable with annotation": {
+			namespace:      "default",
+			annotations:    map[string]string{annotationServiceSync: "true"},
+			explicitEnable: true,
+			expected:       true,
+		},
+		"disabled by annotation": {
+			namespace:   "default",
+			annotations: map[string]string{annotationServiceSync: "false"},
+			expected:    false,
+		},
+		"denied namespace": {
+			namespace:   "kube-system",
+			annotations: map[string]string{annotationServiceSync: "true"},
+			expected:    false,
+		},
+		"namespace not allowed": {
+			namespace: "other",
+			expected:  false,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ingressResource := &IngressResource{
+				Log: hclog.NewNullLogger(),
+				Service: &ServiceResource{
+					AllowK8sNamespacesSet: mapset.NewSet("default", "kube-system"),
+					DenyK8sNamespacesSet:  mapset.NewSet("kube-system"),
+					ExplicitEnable:        c.explicitEnable,
+				},
+			}
+			ingress := testIngress("web", c.namespace, "1.2.3.4")
+			ingress.Annotations = c.annotations
+			require.Equal(t, c.expected, ingressResource.shouldSync(ingress))
+		})
+	}
+}
+
+// Test that an Ingress and a Service with the same name are registered as
+// different Consul services and that the name of the Ingress service can be
+// overridden.
+func TestIngressResource_serviceName(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	closer := startIngressResource(&serviceResource, client)
+	defer closer()
+
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), lbService("web", metav1.NamespaceDefault, "5.6.7.8"), metav1.CreateOptions{})
+	require.NoError(t, err)
+	ingress := testIngress("web", metav1.NamespaceDefault, "1.2.3.4")
+	_, err = client.NetworkingV1().Ingresses(metav1.NamespaceDefault).Create(context.Background(), ingress, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		require.Len(r, syncer.Registrations, 4)
+		for _, reg := range syncer.Registrations {
+			if reg.Service.Meta[ConsulK8SRefKind] == ingressRefKind {
+				require.Equal(r, "web-ingress", reg.Service.Service)
+				require.Equal(r, "1.2.3.4", reg.Service.Address)
+			} else {
+				require.Equal(r, "web", reg.Service.Service)
+				require.Equal(r, "5.6.7.8", reg.Service.Address)
+			}
+		}
+	})
+
+	ingress.Annotations = map[string]string{annotationServiceName: "web-public"}
+	_, err = client.NetworkingV1().Ingresses(metav1.NamespaceDefault).Update(context.Background(), ingress, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		require.Len(r, syncer.Registrations, 4)
+		for _, reg := range syncer.Registrations {
+			if reg.Service.Meta[ConsulK8SRefKind] == ingressRefKind {
+				require.Equal(r, "web-public", reg.Service.Service)
+			}
+		}
+	})
+}
+
+// Test that Ingresses without a load balancer address aren't registered and
+// that Ingresses without rules are registered for their default backend.
+func TestIngressResource_registrations(t *testing.T) {
+	ingressResource := &IngressResource{
+		Log: hclog.NewNullLogger(),
+		Service: &ServiceResource{
+			ConsulNodeName:        ConsulSyncNodeName,
+			ConsulServicePrefix:   "k8s-",
+			AddK8SNamespaceSuffix: true,
+		},
+	}
+
+	ingress := testIngress("web", metav1.NamespaceDefault, "")
+	require.Empty(t, ingressResource.registrations(ingress))
+
+	ingress = &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web",
+			Namespace:   metav1.NamespaceDefault,
+			Annotations: map[string]string{annotationServicePort: "8080"},
+		},
+		Spec: networkingv1.IngressSpec{
+			DefaultBackend: &networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{Name: "web"},
+			},
+		},
+		Status: networkingv1.IngressStatus{
+			LoadBalancer: apiv1.LoadBalancerStatus{
+				Ingress: []apiv1.LoadBalancerIngress{{Hostname: "lb.example.com"}},
+			},
+		},
+	}
+	rs := ingressResource.registrations(ingress)
+	require.Len(t, rs, 1)
+	require.Equal(t, "k8s-web-ingress-default", rs[0].Service.Service)
+	require.Equal(t, "lb.example.com", rs[0].Service.Address)
+	require.Equal(t, 8080, rs[0].Service.Port)
+	require.NotContains(t, rs[0].Service.Meta, ConsulK8SIngressHost)
+	require.NotContains(t, rs[0].Service.Meta, ConsulK8SIngressPath)
+}
+
+// startIngressResource starts the ServiceResource and an IngressResource
+// using it and returns a function to stop them.
+func startIngressResource(serviceResource *ServiceResource, client *fake.Clientset) func() {
+	serviceCloser := controller.TestControllerRun(serviceResource)
+	ingressCloser := controller.TestControllerRun(&IngressResource{
+		Log:     hclog.Default(),
+		Client:  client,
+		Service: serviceResource,
+		Ctx:     context.Background(),
+	})
+	return func() {
+		ingressCloser()
+		serviceCloser()
+	}
+}
+
+// testIngress returns an Ingress with a rule with one path for
+// api.example.com and a TLS rule with two paths for web.example.com.
+func testIngress(name, namespace, lbIP string) *networkingv1.Ingress {
+	pathType := networkingv1.PathTypePrefix
+	backend := networkingv1.IngressBackend{
+		Service: &networkingv1.IngressServiceBackend{
+			Name: name,
+			Port: networkingv1.ServiceBackendPort{Number: 80},
+		},
+	}
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{{Hosts: []string{"web.example.com"}}},
+			Rules: []networkingv1.IngressRule{
+				{
+					Host: "api.example.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{Path: "/", PathType: &pathType, Backend: backend},
+							},
+						},
+					},
+				},
+				{
+					Host: "web.example.com",
+					IngressRuleValue: networkingv1.IngressRuleValue{
+						HTTP: &networkingv1.HTTPIngressRuleValue{
+							Paths: []networkingv1.HTTPIngressPath{
+								{Path: "/", PathType: &pathType, Backend: backend},
+								{Path: "/static", PathType: &pathType, Backend: backend},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	if lbIP != "" {
+		ingress.Status.LoadBalancer.Ingress = []apiv1.LoadBalancerIngress{{IP: lbIP}}
+	}
+	return ingress
+}
@@ -8,11 +8,15 @@ import (
 	"sync"
 
 	mapset "github.com/deckarep/golang-set"
+	"github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/control-plane/catalog/metrics"
 	"github.com/hashicorp/consul-k8s/control-plane/helper/controller"
 	"github.com/hashicorp/consul-k8s/control-plane/namespaces"
 	consulapi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 	apiv1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
@@ -32,6 +36,24 @@ const (
 	ConsulK8SRefKind  = "external-k8s-ref-kind"
 	ConsulK8SRefValue = "external-k8s-ref-name"
 	ConsulK8SNodeName = "external-k8s-node-name"
+
+	// ConsulK8STopologyZone and ConsulK8STopologyRegion are the keys used in
+	// the meta to record the zone and region of the Kubernetes node a service
+	// instance is running on.
+	ConsulK8STopologyZone   = "external-k8s-topology-zone"
+	ConsulK8STopologyRegion = "external-k8s-topology-region"
+
+	// ConsulK8SSyncNode is the key used in the node meta of the Consul nodes
+	// registered for each Kubernetes node to record the name of the sync node
+	// they belong to.
+	ConsulK8SSyncNode = "external-k8s-sync-node"
+
+	// ConsulK8SReadinessCheckName is the name of the health check registered
+	// for each service instance when readiness checks are synced.
+	ConsulK8SReadinessCheckName = "Kubernetes Readiness Check"
+
+	kubernetesReadyOutput    = "Kubernetes endpoint is ready"
+	kubernetesNotReadyOutput = "Kubernetes endpoint is not ready"
 )
 
 type NodePortSyncType string
@@ -49,6 +71,35 @@ const (
 	InternalOnly NodePortSyncType = "InternalOnly"
 )
 
+// ServicePortSyncType determines how the ports of a service are synced.
+type ServicePortSyncType string
+
+const (
+	// ServicePortSingle registers a single Consul service with the port
+	// chosen by the service-port annotation or the first port of the
+	// service. This is the default.
+	ServicePortSingle ServicePortSyncType = "Single"
+
+	// ServicePortPerPort registers a Consul service per port of multi-port
+	// services named <name>-<port name>.
+	ServicePortPerPort ServicePortSyncType = "PerPort"
+
+	// ServicePortMeta registers a single Consul service like
+	// ServicePortSingle but sets the port-<port name> meta of each instance
+	// to the port the instance listens on, i.e. the endpoint port or the
+	// node port, rather than the service port.
+	ServicePortMeta ServicePortSyncType = "Meta"
+)
+
+// ValidServicePortSyncType returns true if v is a valid ServicePortSyncType.
+func ValidServicePortSyncType(v ServicePortSyncType) bool {
+	switch v {
+	case ServicePortSingle, ServicePortPerPort, ServicePortMeta:
+		return true
+	}
+	return false
+}
+
 // ServiceResource implements controller.Resource to sync Service resource
 // types from K8S.
 type ServiceResource struct {
@@ -94,6 +145,25 @@ type ServiceResource struct {
 	// ip address will be used instead.
 	NodePortSync NodePortSyncType
 
+	// SyncReadinessChecks set to true (default false) registers the not ready
+	// addresses of endpoints as well as the ready ones and attaches a health
+	// check to each of their service instances reflecting whether the endpoint
+	// is ready in Kubernetes, so that Consul DNS and health queries only return
+	// ready instances. It applies to the service instances registered from
+	// endpoints, i.e. ClusterIP and NodePort services and LoadBalancer services
+	// when LoadBalancerEndpointsSync is true.
+	SyncReadinessChecks bool
+
+	// ServicePortSync determines how the ports of services are synced by
+	// default. It can be overridden per service with the service-port-sync
+	// annotation. An empty value is ServicePortSingle.
+	ServicePortSync ServicePortSyncType
+
+	// EndpointSlicesSync set to true (default false) watches the
+	// EndpointSlices of services instead of their Endpoints. Endpoints are
+	// truncated at 1000 addresses while EndpointSlices aren't.
+	EndpointSlicesSync bool
+
 	// AddK8SNamespaceSuffix set to true appends Kubernetes namespace
 	// to the service name being synced to Consul separated by a dash.
 	// For example, service 'foo' in the 'default' namespace will be synced
@@ -125,6 +195,24 @@ type ServiceResource struct {
 	// The Consul node name to register service with.
 	ConsulNodeName string
 
+	// SyncTopologyMeta set to true (default false) adds the zone and region
+	// labels of the Kubernetes node of each endpoint to the meta of its
+	// service instance so that Consul clients can prefer instances in their
+	// own zone or region.
+	SyncTopologyMeta bool
+
+	// ConsulNodePerK8SNode set to true (default false) registers the service
+	// instances of endpoints on one Consul node per Kubernetes node instead of
+	// on ConsulNodeName. The Consul nodes are named
+	// <ConsulNodeName>-<Kubernetes node name> and carry the address, zone and
+	// region of the Kubernetes node. Instances that aren't running on a
+	// Kubernetes node, e.g. of external IPs and load balancers, are still
+	// registered on ConsulNodeName.
+	ConsulNodePerK8SNode bool
+
+	// Metrics records the number of services that are synced if set.
+	Metrics *metrics.Metrics
+
 	// serviceLock must be held for any read/write to these maps.
 	serviceLock sync.RWMutex
 
@@ -136,10 +224,24 @@ type ServiceResource struct {
 	// of each service.
 	endpointsMap map[string]*apiv1.Endpoints
 
+	// endpointSlicesMap uses the same keys as serviceMap but maps to the
+	// EndpointSlices of each service by their key in the form
+	// <kube namespace>/<slice name>. It's only used with EndpointSlicesSync
+	// and endpointsMap holds the Endpoints converted from them then.
+	endpointSlicesMap map[string]map[string]*discoveryv1.EndpointSlice
+
 	// consulMap holds the services in Consul that we've registered from kube.
 	// It's populated via Consul's API and lets us diff what is actually in
 	// Consul vs. what we expect to be there.
 	consulMap map[string][]*consulapi.CatalogRegistration
+
+	// syncedServices holds the SyncedServices that configure the sync of
+	// services. It uses the same keys as serviceMap.
+	syncedServices map[string]*v1alpha1.SyncedService
+
+	// ingressMap holds the registrations of the Ingresses synced by an
+	// IngressResource. Keys are in the form <kube namespace>/<kube ingress name>.
+	ingressMap map[string][]*consulapi.CatalogRegistration
 }
 
 // Informer implements the controller.Resource interface.
@@ -194,7 +296,9 @@ func (t *ServiceResource) Upsert(key string, raw interface{}) error {
 	t.Log.Debug("[ServiceResource.Upsert] adding service to serviceMap", "key", key, "service", service)
 
 	// If we care about endpoints, we should do the initial endpoints load.
-	if t.shouldTrackEndpoints(key) {
+	if t.shouldTrackEndpoints(key) && t.EndpointSlicesSync {
+		t.loadEndpointSlices(key, service)
+	} else if t.shouldTrackEndpoints(key) {
 		endpoints, err := t.Client.CoreV1().
 			Endpoints(service.Namespace).
 			Get(t.Ctx, service.Name, metav1.GetOptions{})
@@ -227,6 +331,71 @@ func (t *ServiceResource) Delete(key string, _ interface{}) error {
 	return nil
 }
 
+// SetSyncedService sets the SyncedService that configures the sync of the
+// service with the given key, or removes it if syncedService is nil, and
+// regenerates the registrations of the service.
+func (t *ServiceResource) SetSyncedService(key string, syncedService *v1alpha1.SyncedService) {
+	t.serviceLock.Lock()
+	if syncedService == nil {
+		delete(t.syncedServices, key)
+	} else {
+		if t.syncedServices == nil {
+			t.syncedServices = make(map[string]*v1alpha1.SyncedService)
+		}
+		t.syncedServices[key] = syncedService
+	}
+	t.serviceLock.Unlock()
+
+	// The service may not be in serviceMap if it wasn't enabled for syncing
+	// before so read it again.
+	parts := strings.SplitN(key, "/", 2)
+	if len(parts) != 2 {
+		return
+	}
+	svc, err := t.Client.CoreV1().Services(parts[0]).Get(t.Ctx, parts[1], metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_ = t.Delete(key, nil)
+		return
+	}
+	if err != nil {
+		t.Log.Warn("error loading service", "key", key, "err", err)
+		return
+	}
+	_ = t.Upsert(key, svc)
+}
+
+// SetIngressRegistrations sets the registrations of the Ingress with the
+// given key, or removes them if rs is empty, and triggers a sync.
+func (t *ServiceResource) SetIngressRegistrations(key string, rs []*consulapi.CatalogRegistration) {
+	t.serviceLock.Lock()
+	defer t.serviceLock.Unlock()
+
+	if len(rs) == 0 {
+		if _, ok := t.ingressMap[key]; !ok {
+			return
+		}
+		delete(t.ingressMap, key)
+	} else {
+		if t.ingressMap == nil {
+			t.ingressMap = make(map[string][]*consulapi.CatalogRegistration)
+		}
+		t.ingressMap[key] = rs
+	}
+	t.sync()
+}
+
+// Registrations returns the registrations generated for the service with
+// the given key and whether the service is synced at all.
+func (t *ServiceResource) Registrations(key string) ([]*consulapi.CatalogRegistration, bool) {
+	t.serviceLock.RLock()
+	defer t.serviceLock.RUnlock()
+
+	if _, ok := t.serviceMap[key]; !ok {
+		return nil, false
+	}
+	return append([]*consulapi.CatalogRegistration(nil), t.consulMap[key]...), true
+}
+
 // doDelete is a helper function for deletion.
 //
 // Precondition: assumes t.serviceLock is held.
@@ -235,6 +404,7 @@ func (t *ServiceResource) doDelete(key string) {
 	t.Log.Debug("[doDelete] deleting service from serviceMap", "key", key)
 	delete(t.endpointsMap, key)
 	t.Log.Debug("[doDelete] deleting endpoints from endpointsMap", "key", key)
+	delete(t.endpointSlicesMap, key)
 	// If there were registrations related to this service, then
 	// delete them and sync.
 	if _, ok := t.consulMap[key]; ok {
@@ -245,6 +415,15 @@ func (t *ServiceResource) doDelete(key string) {
 
 // Run implements the controller.Backgrounder interface.
 func (t *ServiceResource) Run(ch <-chan struct{}) {
+	if t.EndpointSlicesSync {
+		t.Log.Info("starting runner for endpointslices")
+		(&controller.Controller{
+			Log:      t.Log.Named("controller/endpointslices"),
+			Resource: &serviceEndpointSlicesResource{Service: t, Ctx: t.Ctx},
+		}).Run(ch)
+		return
+	}
+
 	t.Log.Info("starting runner for endpoints")
 	(&controller.Controller{
 		Log:      t.Log.Named("controller/endpoints"),
@@ -273,6 +452,11 @@ func (t *ServiceResource) shouldSync(svc *apiv1.Service) bool {
 		return false
 	}
 
+	// A SyncedService enables the sync regardless of the annotation.
+	if _, ok := t.syncedServices[svc.Namespace+"/"+svc.Name]; ok {
+		return true
+	}
+
 	raw, ok := svc.Annotations[annotationServiceSync]
 	if !ok {
 		// If there is no explicit value, then set it to our current default.
@@ -314,6 +498,24 @@ func (t *ServiceResource) shouldTrackEndpoints(key string) bool {
 		(t.LoadBalancerEndpointsSync && svc.Spec.Type == apiv1.ServiceTypeLoadBalancer)
 }
 
+// servicePortSync returns how the ports of the given service are synced. The
+// service-port-sync annotation takes precedence over ServicePortSync.
+func (t *ServiceResource) servicePortSync(svc *apiv1.Service) ServicePortSyncType {
+	if raw, ok := svc.Annotations[annotationServicePortSync]; ok {
+		v := ServicePortSyncType(strings.TrimSpace(raw))
+		if ValidServicePortSyncType(v) {
+			return v
+		}
+		t.Log.Warn("invalid service-port-sync annotation",
+			"service-name", t.addPrefixAndK8SNamespace(svc.Name, svc.Namespace),
+			"value", raw)
+	}
+	if t.ServicePortSync == "" {
+		return ServicePortSingle
+	}
+	return t.ServicePortSync
+}
+
 // generateRegistrations generates the necessary Consul registrations for
 // the given key. This is best effort: if there isn't enough information
 // yet to register a service, then no registration will be generated.
@@ -328,6 +530,10 @@ func (t *ServiceResource) generateRegistrations(key string) {
 
 	t.Log.Debug("[generateRegistrations] generating registration", "key", key)
 
+	// nodes caches the Kubernetes nodes looked up while generating the
+	// registrations since many endpoints usually run on the same node.
+	nodes := make(map[string]*apiv1.Node)
+
 	// Initialize our consul service map here if it isn't already.
 	if t.consulMap == nil {
 		t.consulMap = make(map[string][]*consulapi.CatalogRegistration)
@@ -363,12 +569,21 @@ func (t *ServiceResource) generateRegistrations(key string) {
 		baseService.Service = strings.TrimSpace(v)
 	}
 
+	// A SyncedService takes precedence over the annotations.
+	syncedService := t.syncedServices[key]
+	if syncedService != nil && syncedService.Spec.ConsulName != "" {
+		baseService.Service = syncedService.Spec.ConsulName
+	}
+
 	// Update the Consul namespace based on namespace settings
 	consulNS := namespaces.ConsulNamespace(svc.Namespace,
 		t.EnableNamespaces,
 		t.ConsulDestinationNamespace,
 		t.EnableK8SNSMirroring,
 		t.K8SNSMirroringPrefix)
+	if consulNS != "" && syncedService != nil && syncedService.Spec.ConsulNamespace != "" {
+		consulNS = syncedService.Spec.ConsulNamespace
+	}
 	if consulNS != "" {
 		t.Log.Debug("[generateRegistrations] namespace being used", "key", key, "namespace", consulNS)
 		baseService.Namespace = consulNS
@@ -383,6 +598,9 @@ func (t *ServiceResource) generateRegistrations(key string) {
 
 		// If a specific port is specified, then use that port value
 		portAnnotation, ok := svc.Annotations[annotationServicePort]
+		if syncedService != nil && syncedService.Spec.Port != "" {
+			portAnnotation, ok = syncedService.Spec.Port, true
+		}
 		if ok {
 			if v, err := strconv.ParseInt(portAnnotation, 0, 0); err == nil {
 				port = int(v)
@@ -449,6 +667,13 @@ func (t *ServiceResource) generateRegistrations(key string) {
 		}
 	}
 
+	if syncedService != nil {
+		baseService.Tags = append(baseService.Tags, syncedService.Spec.Tags...)
+		for k, v := range syncedService.Spec.Meta {
+			baseService.Meta[k] = v
+		}
+	}
+
 	// Always log what we generated
 	defer func() {
 		t.Log.Debug("generated registration",
@@ -458,6 +683,43 @@ func (t *ServiceResource) generateRegistrations(key string) {
 			"instances", len(t.consulMap[key]))
 	}()
 
+	// With per-port sync, each port of a multi-port service is registered as
+	// a service of its own named <name>-<port name>. Kubernetes requires the
+	// ports of multi-port services to be named.
+	portSync := t.servicePortSync(svc)
+	if portSync == ServicePortPerPort && len(svc.Spec.Ports) > 1 {
+		for _, p := range svc.Spec.Ports {
+			portService := baseService
+			portService.Service = fmt.Sprintf("%s-%s", baseService.Service, p.Name)
+			portService.Port = int(p.Port)
+			if svc.Spec.Type == apiv1.ServiceTypeNodePort {
+				portService.Port = int(p.NodePort)
+			}
+			t.generateInstances(key, svc, baseNode, portService, p.Name, 0, false, nodes)
+		}
+		return
+	}
+
+	t.generateInstances(key, svc, baseNode, baseService, overridePortName, overridePortNumber, portSync == ServicePortMeta, nodes)
+}
+
+// generateInstances generates the registrations of the instances of the
+// Consul service baseService for the service with the given key. If
+// portsMeta is true, the port-<name> meta of the instances registered from
+// endpoints is set to the ports the instances listen on rather than the
+// service ports.
+//
+// Precondition: the lock t.lock is held.
+func (t *ServiceResource) generateInstances(
+	key string,
+	svc *apiv1.Service,
+	baseNode consulapi.CatalogRegistration,
+	baseService consulapi.AgentService,
+	overridePortName string,
+	overridePortNumber int,
+	portsMeta bool,
+	nodes map[string]*apiv1.Node) {
+
 	// If there are external IPs then those become the instance registrations
 	// for any type of service.
 	if ips := svc.Spec.ExternalIPs; len(ips) > 0 {
@@ -480,7 +742,7 @@ func (t *ServiceResource) generateRegistrations(key string) {
 	// If LoadBalancerEndpointsSync is true sync LB endpoints instead of loadbalancer ingress.
 	case apiv1.ServiceTypeLoadBalancer:
 		if t.LoadBalancerEndpointsSync {
-			t.registerServiceInstance(baseNode, baseService, key, overridePortName, overridePortNumber, false)
+			t.registerServiceInstance(baseNode, baseService, key, overridePortName, overridePortNumber, false, portsMeta, nodes)
 		} else {
 			seen := map[string]struct{}{}
 			for _, ingress := range svc.Status.LoadBalancer.Ingress {
@@ -521,8 +783,22 @@ func (t *ServiceResource) generateRegistrations(key string) {
 			return
 		}
 
+		// The instances of NodePort services listen on the node ports.
+		if portsMeta {
+			meta := make(map[string]string, len(baseService.Meta))
+			for k, v := range baseService.Meta {
+				meta[k] = v
+			}
+			for _, p := range svc.Spec.Ports {
+				if p.NodePort > 0 {
+					meta["port-"+p.Name] = strconv.FormatInt(int64(p.NodePort), 10)
+				}
+			}
+			baseService.Meta = meta
+		}
+
 		for _, subset := range endpoints.Subsets {
-			for _, subsetAddr := range subset.Addresses {
+			for _, subsetAddr := range t.subsetAddresses(key, subset) {
 				// Check that the node name exists
 				// subsetAddr.NodeName is of type *string
 				if subsetAddr.NodeName == nil {
@@ -530,9 +806,8 @@ func (t *ServiceResource) generateRegistrations(key string) {
 				}
 
 				// Look up the node's ip address by getting node info
-				node, err := t.Client.CoreV1().Nodes().Get(t.Ctx, *subsetAddr.NodeName, metav1.GetOptions{})
-				if err != nil {
-					t.Log.Warn("error getting node info", "error", err)
+				node := t.k8sNode(*subsetAddr.NodeName, nodes)
+				if node == nil {
 					continue
 				}
 
@@ -555,6 +830,8 @@ func (t *ServiceResource) generateRegistrations(key string) {
 						r.Service = &rs
 						r.Service.ID = serviceID(r.Service.Service, subsetAddr.IP)
 						r.Service.Address = address.Address
+						t.setK8SNode(&r, node)
+						r.Check = t.readinessCheck(key, r.Service, subsetAddr.ready)
 
 						t.consulMap[key] = append(t.consulMap[key], &r)
 						// Only consider the first address that matches. In some cases
@@ -576,6 +853,8 @@ func (t *ServiceResource) generateRegistrations(key string) {
 							r.Service = &rs
 							r.Service.ID = serviceID(r.Service.Service, subsetAddr.IP)
 							r.Service.Address = address.Address
+							t.setK8SNode(&r, node)
+							r.Check = t.readinessCheck(key, r.Service, subsetAddr.ready)
 
 							t.consulMap[key] = append(t.consulMap[key], &r)
 							// Only consider the first address that matches. In some cases
@@ -593,7 +872,7 @@ func (t *ServiceResource) generateRegistrations(key string) {
 	// For ClusterIP services, we register a service instance
 	// for each endpoint.
 	case apiv1.ServiceTypeClusterIP:
-		t.registerServiceInstance(baseNode, baseService, key, overridePortName, overridePortNumber, true)
+		t.registerServiceInstance(baseNode, baseService, key, overridePortName, overridePortNumber, true, portsMeta, nodes)
 	}
 }
 
@@ -603,7 +882,9 @@ func (t *ServiceResource) registerServiceInstance(
 	key string,
 	overridePortName string,
 	overridePortNumber int,
-	useHostname bool) {
+	useHostname bool,
+	portsMeta bool,
+	nodes map[string]*apiv1.Node) {
 
 	if t.endpointsMap == nil {
 		return
@@ -636,7 +917,7 @@ func (t *ServiceResource) registerServiceInstance(
 				break
 			}
 		}
-		for _, subsetAddr := range subset.Addresses {
+		for _, subsetAddr := range t.subsetAddresses(key, subset) {
 			addr := subsetAddr.IP
 			if addr == "" && useHostname {
 				addr = subsetAddr.Hostname
@@ -665,19 +946,211 @@ func (t *ServiceResource) registerServiceInstance(
 			for k, v := range baseService.Meta {
 				r.Service.Meta[k] = v
 			}
+			if portsMeta {
+				for _, p := range subset.Ports {
+					r.Service.Meta["port-"+p.Name] = strconv.FormatInt(int64(p.Port), 10)
+				}
+			}
 			if subsetAddr.TargetRef != nil {
 				r.Service.Meta[ConsulK8SRefValue] = subsetAddr.TargetRef.Name
 				r.Service.Meta[ConsulK8SRefKind] = subsetAddr.TargetRef.Kind
 			}
 			if subsetAddr.NodeName != nil {
 				r.Service.Meta[ConsulK8SNodeName] = *subsetAddr.NodeName
+				if t.SyncTopologyMeta || t.ConsulNodePerK8SNode {
+					if node := t.k8sNode(*subsetAddr.NodeName, nodes); node != nil {
+						t.setK8SNode(&r, node)
+					}
+				}
 			}
+			r.Check = t.readinessCheck(key, r.Service, subsetAddr.ready)
 
 			t.consulMap[key] = append(t.consulMap[key], &r)
 		}
 	}
 }
 
+// k8sNode returns the Kubernetes node with the given name, looking it up
+// in nodes first. It returns nil if the node can't be retrieved.
+func (t *ServiceResource) k8sNode(name string, nodes map[string]*apiv1.Node) *apiv1.Node {
+	if node, ok := nodes[name]; ok {
+		return node
+	}
+
+	node, err := t.Client.CoreV1().Nodes().Get(t.Ctx, name, metav1.GetOptions{})
+	if err != nil {
+		t.Log.Warn("error getting node info", "node", name, "error", err)
+		return nil
+	}
+	nodes[name] = node
+	return node
+}
+
+// setK8SNode updates the registration of a service instance running on the
+// given Kubernetes node. It adds the zone and region of the node to the
+// service meta if SyncTopologyMeta is set and registers the instance on the
+// Consul node of the Kubernetes node if ConsulNodePerK8SNode is set.
+func (t *ServiceResource) setK8SNode(r *consulapi.CatalogRegistration, node *apiv1.Node) {
+	zone, region := nodeTopology(node)
+
+	if t.SyncTopologyMeta && (zone != "" || region != "") {
+		// Copy the meta since it may be shared with other instances.
+		meta := make(map[string]string, len(r.Service.Meta)+2)
+		for k, v := range r.Service.Meta {
+			meta[k] = v
+		}
+		if zone != "" {
+			meta[ConsulK8STopologyZone] = zone
+		}
+		if region != "" {
+			meta[ConsulK8STopologyRegion] = region
+		}
+		r.Service.Meta = meta
+	}
+
+	if !t.ConsulNodePerK8SNode {
+		return
+	}
+
+	r.Node = t.consulNodeName(node.Name)
+	r.Address = "127.0.0.1"
+	for _, addrType := range []apiv1.NodeAddressType{apiv1.NodeInternalIP, apiv1.NodeExternalIP} {
+		if addr := nodeAddress(node, addrType); addr != "" {
+			r.Address = addr
+			break
+		}
+	}
+	r.NodeMeta = map[string]string{
+		ConsulSourceKey:   ConsulSourceValue,
+		ConsulK8SNodeName: node.Name,
+		ConsulK8SSyncNode: t.ConsulNodeName,
+	}
+	if zone != "" {
+		r.NodeMeta[ConsulK8STopologyZone] = zone
+	}
+	if region != "" {
+		r.NodeMeta[ConsulK8STopologyRegion] = region
+	}
+	// Keep the address and meta of the node up to date with the Kubernetes
+	// node.
+	r.SkipNodeUpdate = false
+}
+
+// consulNodeName returns the name of the Consul node that the service
+// instances running on the Kubernetes node with the given name are
+// registered on when ConsulNodePerK8SNode is set.
+func (t *ServiceResource) consulNodeName(k8sNodeName string) string {
+	return fmt.Sprintf("%s-%s", t.ConsulNodeName, k8sNodeName)
+}
+
+// nodeTopology returns the zone and region of a Kubernetes node from its
+// well-known topology labels, falling back to the deprecated failure domain
+// labels.
+func nodeTopology(node *apiv1.Node) (string, string) {
+	zone := node.Labels[apiv1.LabelTopologyZone]
+	if zone == "" {
+		zone = node.Labels[apiv1.LabelFailureDomainBetaZone]
+	}
+	region := node.Labels[apiv1.LabelTopologyRegion]
+	if region == "" {
+		region = node.Labels[apiv1.LabelFailureDomainBetaRegion]
+	}
+	return zone, region
+}
+
+// nodeAddress returns the first address of the given type of a Kubernetes
+// node or an empty string if it has none.
+func nodeAddress(node *apiv1.Node, addrType apiv1.NodeAddressType) string {
+	for _, address := range node.Status.Addresses {
+		if address.Type == addrType {
+			return address.Address
+		}
+	}
+	return ""
+}
+
+// endpointAddress is an address of an Endpoints subset along with whether
+// it is ready.
+type endpointAddress struct {
+	apiv1.EndpointAddress
+	ready bool
+}
+
+// subsetAddresses returns the addresses of the subset to register as service
+// instances. Addresses are deduplicated by their IP, or by their hostname if
+// they have no IP. The ready addresses come first so that an address that is
+// listed as both ready and not ready is registered as ready. Not ready
+// addresses are only registered when readiness checks are synced for the
+// service with the given key.
+func (t *ServiceResource) subsetAddresses(key string, subset apiv1.EndpointSubset) []endpointAddress {
+	addresses := make([]endpointAddress, 0, len(subset.Addresses)+len(subset.NotReadyAddresses))
+	seen := make(map[string]struct{})
+	add := func(addr apiv1.EndpointAddress, ready bool) {
+		id := addr.IP
+		if id == "" {
+			id = addr.Hostname
+		}
+		if id != "" {
+			if _, ok := seen[id]; ok {
+				return
+			}
+			seen[id] = struct{}{}
+		}
+		addresses = append(addresses, endpointAddress{EndpointAddress: addr, ready: ready})
+	}
+
+	for _, addr := range subset.Addresses {
+		add(addr, true)
+	}
+	if t.syncReadinessChecks(key) {
+		for _, addr := range subset.NotReadyAddresses {
+			add(addr, false)
+		}
+	}
+	return addresses
+}
+
+// readinessCheck returns the health check to register with a service instance
+// reflecting whether its endpoint is ready. It returns nil if readiness checks
+// are not synced for the service with the given key.
+func (t *ServiceResource) readinessCheck(key string, service *consulapi.AgentService, ready bool) *consulapi.AgentCheck {
+	if !t.syncReadinessChecks(key) {
+		return nil
+	}
+	status, output := consulapi.HealthPassing, kubernetesReadyOutput
+	if !ready {
+		status, output = consulapi.HealthCritical, kubernetesNotReadyOutput
+	}
+	return &consulapi.AgentCheck{
+		CheckID:     readinessCheckID(service.ID),
+		Name:        ConsulK8SReadinessCheckName,
+		Status:      status,
+		Output:      output,
+		ServiceID:   service.ID,
+		ServiceName: service.Service,
+		Namespace:   service.Namespace,
+	}
+}
+
+// syncReadinessChecks returns whether readiness checks are synced for the
+// service with the given key. The SyncedService of the service takes
+// precedence over SyncReadinessChecks.
+//
+// Precondition: the lock t.lock is held.
+func (t *ServiceResource) syncReadinessChecks(key string) bool {
+	if syncedService, ok := t.syncedServices[key]; ok {
+		if health := syncedService.Spec.Health; health != nil && health.SyncReadinessChecks != nil {
+			return *health.SyncReadinessChecks
+		}
+	}
+	return t.SyncReadinessChecks
+}
+
+// readinessCheckID returns the ID of the readiness check of a service instance.
+func readinessCheckID(serviceID string) string {
+	return fmt.Sprintf("%s/kubernetes-readiness-check", serviceID)
+}
+
 // sync calls the Syncer.Sync function from the generated registrations.
 //
 // Precondition: lock must be held.
@@ -687,9 +1160,17 @@ func (t *ServiceResource) sync() {
 	// of these are implementation details so lets improve this later when
 	// it becomes a performance issue and just do the easy thing first.
 	rs := make([]*consulapi.CatalogRegistration, 0, len(t.consulMap)*4)
+	counts := make(map[string]int)
 	for _, set := range t.consulMap {
 		rs = append(rs, set...)
+		if len(set) > 0 {
+			counts[set[0].Service.Namespace]++
+		}
+	}
+	for _, set := range t.ingressMap {
+		rs = append(rs, set...)
 	}
+	t.Metrics.SetServices(metrics.DirectionToConsul, counts)
 
 	// Sync, which should be non-blocking in real-world cases
 	t.Syncer.Sync(rs)
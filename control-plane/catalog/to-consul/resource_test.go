@@ -2,12 +2,17 @@ package catalog
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	mapset "github.com/deckarep/golang-set"
+	"github.com/hashicorp/consul-k8s/control-plane/catalog/metrics"
 	"github.com/hashicorp/consul-k8s/control-plane/helper/controller"
+	consulapi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -76,6 +81,34 @@ func TestServiceResource_defaultEnable(t *testing.T) {
 	})
 }
 
+// Test that the number of services synced to Consul is recorded.
+func TestServiceResource_metrics(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	reg := prometheus.NewRegistry()
+	serviceResource.Metrics = metrics.New(reg)
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	// Insert LB services
+	for _, name := range []string{"foo", "bar"} {
+		_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), lbService(name, metav1.NamespaceDefault, "1.2.3.4"), metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	retry.Run(t, func(r *retry.R) {
+		require.NoError(r, promtestutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP consul_sync_catalog_services The number of Kubernetes services eligible for sync to Consul, or watched by the sync to Kubernetes.
+# TYPE consul_sync_catalog_services gauge
+consul_sync_catalog_services{direction="to-consul",namespace=""} 2
+`), "consul_sync_catalog_services"))
+	})
+}
+
 // Test that we can explicitly disable.
 func TestServiceResource_defaultEnableDisable(t *testing.T) {
 	t.Parallel()
@@ -1490,6 +1523,340 @@ func TestServiceResource_MirroredPrefixNamespace(t *testing.T) {
 	})
 }
 
+// Test that not ready addresses are only registered with readiness checks
+// when readiness checks are synced.
+func TestServiceResource_readinessChecks(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		syncReadinessChecks bool
+		expAddresses        []string
+		expStatuses         []string
+	}{
+		"readiness checks disabled": {
+			syncReadinessChecks: false,
+			expAddresses:        []string{"1.1.1.1"},
+		},
+		"readiness checks enabled": {
+			syncReadinessChecks: true,
+			expAddresses:        []string{"1.1.1.1", "2.2.2.2"},
+			expStatuses:         []string{consulapi.HealthPassing, consulapi.HealthCritical},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client := fake.NewSimpleClientset()
+			syncer := newTestSyncer()
+			serviceResource := defaultServiceResource(client, syncer)
+			serviceResource.ClusterIPSync = true
+			serviceResource.SyncReadinessChecks = c.syncReadinessChecks
+
+			// Start the controller
+			closer := controller.TestControllerRun(&serviceResource)
+			defer closer()
+
+			// Insert the service
+			svc := clusterIPService("foo", metav1.NamespaceDefault)
+			_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+			require.NoError(t, err)
+
+			// Insert the endpoints with one ready and one not ready address
+			createEndpointsWithNotReady(t, client, "foo", metav1.NamespaceDefault)
+
+			// Verify what we got
+			retry.Run(t, func(r *retry.R) {
+				syncer.Lock()
+				defer syncer.Unlock()
+				actual := syncer.Registrations
+				require.Len(r, actual, len(c.expAddresses))
+				for i, addr := range c.expAddresses {
+					require.Equal(r, addr, actual[i].Service.Address)
+					if !c.syncReadinessChecks {
+						require.Nil(r, actual[i].Check)
+						continue
+					}
+					require.NotNil(r, actual[i].Check)
+					require.Equal(r, c.expStatuses[i], actual[i].Check.Status)
+					require.Equal(r, ConsulK8SReadinessCheckName, actual[i].Check.Name)
+					require.Equal(r, actual[i].Service.ID, actual[i].Check.ServiceID)
+					require.Equal(r, actual[i].Service.ID+"/kubernetes-readiness-check", actual[i].Check.CheckID)
+				}
+			})
+		})
+	}
+}
+
+// Test that the addresses of a subset are deduplicated and that an address
+// listed as both ready and not ready is registered as ready.
+func TestServiceResource_subsetAddresses(t *testing.T) {
+	t.Parallel()
+
+	subset := apiv1.EndpointSubset{
+		Addresses: []apiv1.EndpointAddress{
+			{IP: "1.1.1.1"},
+			{IP: "1.1.1.1"},
+			{Hostname: "foo.example.com"},
+			{Hostname: "foo.example.com"},
+		},
+		NotReadyAddresses: []apiv1.EndpointAddress{
+			{IP: "1.1.1.1"},
+			{IP: "2.2.2.2"},
+			{IP: "2.2.2.2"},
+		},
+	}
+
+	cases := map[string]struct {
+		syncReadinessChecks bool
+		exp                 []endpointAddress
+	}{
+		"readiness checks disabled": {
+			syncReadinessChecks: false,
+			exp: []endpointAddress{
+				{EndpointAddress: apiv1.EndpointAddress{IP: "1.1.1.1"}, ready: true},
+				{EndpointAddress: apiv1.EndpointAddress{Hostname: "foo.example.com"}, ready: true},
+			},
+		},
+		"readiness checks enabled": {
+			syncReadinessChecks: true,
+			exp: []endpointAddress{
+				{EndpointAddress: apiv1.EndpointAddress{IP: "1.1.1.1"}, ready: true},
+				{EndpointAddress: apiv1.EndpointAddress{Hostname: "foo.example.com"}, ready: true},
+				{EndpointAddress: apiv1.EndpointAddress{IP: "2.2.2.2"}, ready: false},
+			},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			serviceResource := ServiceResource{SyncReadinessChecks: c.syncReadinessChecks}
+			require.Equal(t, c.exp, serviceResource.subsetAddresses("default/foo", subset))
+		})
+	}
+}
+
+// Test that readiness checks are updated when the endpoints change.
+func TestServiceResource_readinessChecksUpdate(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ClusterIPSync = true
+	serviceResource.SyncReadinessChecks = true
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	// Insert the service and endpoints
+	svc := clusterIPService("foo", metav1.NamespaceDefault)
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	createEndpointsWithNotReady(t, client, "foo", metav1.NamespaceDefault)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 2)
+		require.Equal(r, consulapi.HealthCritical, actual[1].Check.Status)
+	})
+
+	// Make the not ready address ready
+	endpoints, err := client.CoreV1().Endpoints(metav1.NamespaceDefault).Get(context.Background(), "foo", metav1.GetOptions{})
+	require.NoError(t, err)
+	endpoints.Subsets[0].Addresses = append(endpoints.Subsets[0].Addresses, endpoints.Subsets[0].NotReadyAddresses...)
+	endpoints.Subsets[0].NotReadyAddresses = nil
+	_, err = client.CoreV1().Endpoints(metav1.NamespaceDefault).Update(context.Background(), endpoints, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 2)
+		require.Equal(r, consulapi.HealthPassing, actual[0].Check.Status)
+		require.Equal(r, consulapi.HealthPassing, actual[1].Check.Status)
+		require.Equal(r, "Kubernetes endpoint is ready", actual[1].Check.Output)
+	})
+}
+
+// Test that NodePort service instances get readiness checks.
+func TestServiceResource_nodePortReadinessChecks(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.NodePortSync = ExternalOnly
+	serviceResource.SyncReadinessChecks = true
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	node1, _ := createNodes(t, client)
+
+	// Insert the service and endpoints
+	svc := nodePortService("foo", metav1.NamespaceDefault)
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	createEndpointsWithNotReady(t, client, "foo", metav1.NamespaceDefault)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 2)
+		require.Equal(r, node1.Status.Addresses[0].Address, actual[0].Service.Address)
+		require.Equal(r, consulapi.HealthPassing, actual[0].Check.Status)
+		require.Equal(r, consulapi.HealthCritical, actual[1].Check.Status)
+		require.Equal(r, "Kubernetes endpoint is not ready", actual[1].Check.Output)
+	})
+}
+
+// Test that the zone and region of the node of an endpoint are added to the
+// meta of its service instance.
+func TestServiceResource_topologyMeta(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ClusterIPSync = true
+	serviceResource.SyncTopologyMeta = true
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	node1, node2 := createNodes(t, client)
+	node1.Labels = map[string]string{
+		apiv1.LabelTopologyZone:   "us-east-1a",
+		apiv1.LabelTopologyRegion: "us-east-1",
+	}
+	_, err := client.CoreV1().Nodes().Update(context.Background(), node1, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	node2.Labels = map[string]string{
+		apiv1.LabelFailureDomainBetaZone: "us-east-1b",
+	}
+	_, err = client.CoreV1().Nodes().Update(context.Background(), node2, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	// Insert the service and endpoints
+	svc := clusterIPService("foo", metav1.NamespaceDefault)
+	_, err = client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	createEndpoints(t, client, "foo", metav1.NamespaceDefault)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 2)
+		require.Equal(r, "us-east-1a", actual[0].Service.Meta[ConsulK8STopologyZone])
+		require.Equal(r, "us-east-1", actual[0].Service.Meta[ConsulK8STopologyRegion])
+		require.Equal(r, "us-east-1b", actual[1].Service.Meta[ConsulK8STopologyZone])
+		require.NotContains(r, actual[1].Service.Meta, ConsulK8STopologyRegion)
+
+		// The instances are still registered on the sync node.
+		require.Equal(r, ConsulSyncNodeName, actual[0].Node)
+		require.Equal(r, "127.0.0.1", actual[0].Address)
+	})
+}
+
+// Test that service instances are registered on one Consul node per
+// Kubernetes node.
+func TestServiceResource_consulNodePerK8SNode(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ClusterIPSync = true
+	serviceResource.ConsulNodePerK8SNode = true
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	node1, _ := createNodes(t, client)
+	node1.Labels = map[string]string{apiv1.LabelTopologyZone: "us-east-1a"}
+	_, err := client.CoreV1().Nodes().Update(context.Background(), node1, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	// Insert the service and endpoints
+	svc := clusterIPService("foo", metav1.NamespaceDefault)
+	_, err = client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	createEndpoints(t, client, "foo", metav1.NamespaceDefault)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 2)
+
+		require.Equal(r, ConsulSyncNodeName+"-"+nodeName1, actual[0].Node)
+		require.Equal(r, "4.5.6.7", actual[0].Address)
+		require.False(r, actual[0].SkipNodeUpdate)
+		require.Equal(r, map[string]string{
+			ConsulSourceKey:       ConsulSourceValue,
+			ConsulK8SNodeName:     nodeName1,
+			ConsulK8SSyncNode:     ConsulSyncNodeName,
+			ConsulK8STopologyZone: "us-east-1a",
+		}, actual[0].NodeMeta)
+		require.Equal(r, "1.1.1.1", actual[0].Service.Address)
+		// Topology meta is only added to services when enabled.
+		require.NotContains(r, actual[0].Service.Meta, ConsulK8STopologyZone)
+
+		require.Equal(r, ConsulSyncNodeName+"-"+nodeName2, actual[1].Node)
+		require.Equal(r, "3.4.5.6", actual[1].Address)
+		require.Equal(r, "2.2.2.2", actual[1].Service.Address)
+	})
+}
+
+// Test that NodePort service instances are registered on the Consul node of
+// their Kubernetes node and that instances without a Kubernetes node stay on
+// the sync node.
+func TestServiceResource_nodePortConsulNodePerK8SNode(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.NodePortSync = ExternalOnly
+	serviceResource.ConsulNodePerK8SNode = true
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	createNodes(t, client)
+
+	// Insert the services and endpoints
+	svc := nodePortService("foo", metav1.NamespaceDefault)
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	createEndpoints(t, client, "foo", metav1.NamespaceDefault)
+	_, err = client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), lbService("bar", metav1.NamespaceDefault, "5.6.7.8"), metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		nodes := make(map[string]string)
+		for _, reg := range syncer.Registrations {
+			nodes[reg.Service.Service+"/"+reg.Service.Address] = reg.Node
+		}
+		require.Equal(r, map[string]string{
+			"foo/1.2.3.4": ConsulSyncNodeName + "-" + nodeName1,
+			"foo/2.3.4.5": ConsulSyncNodeName + "-" + nodeName2,
+			"bar/5.6.7.8": ConsulSyncNodeName,
+		}, nodes)
+	})
+}
+
 func TestParseTags(t *testing.T) {
 	cases := []struct {
 		tagsAnno string
@@ -1674,6 +2041,38 @@ func createEndpoints(t *testing.T, client *fake.Clientset, serviceName string, n
 	require.NoError(t, err)
 }
 
+// createEndpointsWithNotReady creates endpoints with one ready and one not
+// ready address.
+func createEndpointsWithNotReady(t *testing.T, client *fake.Clientset, serviceName string, namespace string) {
+	node1 := nodeName1
+	node2 := nodeName2
+	_, err := client.CoreV1().Endpoints(namespace).Create(
+		context.Background(),
+		&apiv1.Endpoints{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      serviceName,
+				Namespace: namespace,
+			},
+
+			Subsets: []apiv1.EndpointSubset{
+				{
+					Addresses: []apiv1.EndpointAddress{
+						{NodeName: &node1, IP: "1.1.1.1"},
+					},
+					NotReadyAddresses: []apiv1.EndpointAddress{
+						{NodeName: &node2, IP: "2.2.2.2"},
+					},
+					Ports: []apiv1.EndpointPort{
+						{Name: "http", Port: 8080},
+					},
+				},
+			},
+		},
+		metav1.CreateOptions{})
+
+	require.NoError(t, err)
+}
+
 func defaultServiceResource(client kubernetes.Interface, syncer Syncer) ServiceResource {
 	return ServiceResource{
 		Log:                   hclog.Default(),
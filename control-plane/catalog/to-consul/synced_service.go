@@ -0,0 +1,304 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/hashicorp/go-hclog"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// Reasons of the Synced condition of SyncedServices.
+	syncedServiceReasonInvalid   = "InvalidSpec"
+	syncedServiceReasonConflict  = "Conflict"
+	syncedServiceReasonNotSynced = "ServiceNotSynced"
+	syncedServiceReasonError     = "ConsulRegistrationError"
+)
+
+// SyncedServiceResource implements controller.Resource to watch the
+// SyncedService resources that configure the sync of Kubernetes services,
+// pass them on to the ServiceResource and report the status of the sync
+// on them.
+//
+// If several SyncedServices reference the same service, the oldest one is
+// used and the others report a conflict.
+type SyncedServiceResource struct {
+	Log    hclog.Logger
+	Client client.WithWatch
+
+	// Service is the ServiceResource that syncs the services.
+	Service *ServiceResource
+
+	// Ctx is used to cancel processes kicked off by SyncedServiceResource.
+	Ctx context.Context
+
+	lock sync.Mutex
+
+	// syncedServices holds all SyncedServices keyed by
+	// <kube namespace>/<resource name>.
+	syncedServices map[string]*v1alpha1.SyncedService
+
+	// owners maps the keys of services, in the form
+	// <kube namespace>/<kube svc name>, to the SyncedService used for them.
+	owners map[string]*v1alpha1.SyncedService
+
+	// result is the result of the last full sync of the syncer.
+	result *SyncResult
+
+	// triggerCh is used to trigger a status update.
+	triggerCh chan struct{}
+}
+
+// Informer implements the controller.Resource interface.
+func (r *SyncedServiceResource) Informer() cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				list := &v1alpha1.SyncedServiceList{}
+				err := r.Client.List(r.Ctx, list, &client.ListOptions{Raw: &options})
+				return list, err
+			},
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				return r.Client.Watch(r.Ctx, &v1alpha1.SyncedServiceList{}, &client.ListOptions{Raw: &options})
+			},
+		},
+		&v1alpha1.SyncedService{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+// Upsert implements the controller.Resource interface.
+func (r *SyncedServiceResource) Upsert(key string, raw interface{}) error {
+	syncedService, ok := raw.(*v1alpha1.SyncedService)
+	if !ok {
+		r.Log.Warn("upsert got invalid type", "raw", raw)
+		return nil
+	}
+
+	r.lock.Lock()
+	if r.syncedServices == nil {
+		r.syncedServices = make(map[string]*v1alpha1.SyncedService)
+	}
+	r.syncedServices[key] = syncedService
+	changed := r.updateOwnersLocked()
+	r.lock.Unlock()
+
+	r.setSyncedServices(changed)
+	r.Log.Info("upsert", "key", key)
+	return nil
+}
+
+// Delete implements the controller.Resource interface.
+func (r *SyncedServiceResource) Delete(key string, _ interface{}) error {
+	r.lock.Lock()
+	delete(r.syncedServices, key)
+	changed := r.updateOwnersLocked()
+	r.lock.Unlock()
+
+	r.setSyncedServices(changed)
+	r.Log.Info("delete", "key", key)
+	return nil
+}
+
+// HandleSyncResult stores the result of a full sync of the syncer and
+// triggers a status update. It is meant to be used as the SyncResultHandler
+// of the ConsulSyncer.
+func (r *SyncedServiceResource) HandleSyncResult(result SyncResult) {
+	r.lock.Lock()
+	r.result = &result
+	r.lock.Unlock()
+	r.trigger()
+}
+
+// Run implements the controller.Backgrounder interface. It updates the
+// status of the SyncedServices whenever they change or the syncer reports
+// the result of a sync.
+func (r *SyncedServiceResource) Run(stopCh <-chan struct{}) {
+	r.lock.Lock()
+	if r.triggerCh == nil {
+		r.triggerCh = make(chan struct{}, 1)
+	}
+	triggerCh := r.triggerCh
+	r.lock.Unlock()
+
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-triggerCh:
+			r.updateStatuses()
+		}
+	}
+}
+
+// trigger triggers a status update without blocking.
+func (r *SyncedServiceResource) trigger() {
+	r.lock.Lock()
+	if r.triggerCh == nil {
+		r.triggerCh = make(chan struct{}, 1)
+	}
+	triggerCh := r.triggerCh
+	r.lock.Unlock()
+
+	select {
+	case triggerCh <- struct{}{}:
+	default:
+	}
+}
+
+// updateOwnersLocked recomputes which SyncedService is used for each
+// service. It returns the services whose SyncedService changed mapped to
+// their new SyncedService, which is nil if they don't have one anymore.
+//
+// Precondition: lock must be held.
+func (r *SyncedServiceResource) updateOwnersLocked() map[string]*v1alpha1.SyncedService {
+	owners := make(map[string]*v1alpha1.SyncedService)
+	for _, syncedService := range r.syncedServices {
+		if syncedService.Validate() != nil {
+			continue
+		}
+		key := syncedServiceTarget(syncedService)
+		if owner, ok := owners[key]; ok && !olderSyncedService(syncedService, owner) {
+			continue
+		}
+		owners[key] = syncedService
+	}
+
+	changed := make(map[string]*v1alpha1.SyncedService)
+	for key, owner := range owners {
+		old, ok := r.owners[key]
+		if ok && old.Namespace == owner.Namespace && old.Name == owner.Name && old.Generation == owner.Generation {
+			continue
+		}
+		changed[key] = owner
+	}
+	for key := range r.owners {
+		if _, ok := owners[key]; !ok {
+			changed[key] = nil
+		}
+	}
+	r.owners = owners
+	return changed
+}
+
+// setSyncedServices passes the changed SyncedServices on to the
+// ServiceResource and triggers a status update.
+func (r *SyncedServiceResource) setSyncedServices(changed map[string]*v1alpha1.SyncedService) {
+	for key, syncedService := range changed {
+		r.Service.SetSyncedService(key, syncedService)
+	}
+	r.trigger()
+}
+
+// updateStatuses updates the status of all SyncedServices that changed.
+func (r *SyncedServiceResource) updateStatuses() {
+	r.lock.Lock()
+	var updates []*v1alpha1.SyncedService
+	for _, syncedService := range r.syncedServices {
+		updated := syncedService.DeepCopy()
+		if !r.setStatusLocked(updated) || reflect.DeepEqual(updated.Status, syncedService.Status) {
+			continue
+		}
+		updates = append(updates, updated)
+	}
+	r.lock.Unlock()
+
+	for _, syncedService := range updates {
+		if err := r.Client.Status().Update(r.Ctx, syncedService); err != nil {
+			r.Log.Warn("error updating status",
+				"namespace", syncedService.Namespace, "name", syncedService.Name, "err", err)
+		}
+	}
+}
+
+// setStatusLocked sets the status of a SyncedService. It returns false if
+// the status can't be determined yet.
+//
+// Precondition: lock must be held.
+func (r *SyncedServiceResource) setStatusLocked(syncedService *v1alpha1.SyncedService) bool {
+	if err := syncedService.Validate(); err != nil {
+		syncedService.Status.Instances = 0
+		syncedService.SetSyncedCondition(corev1.ConditionFalse, syncedServiceReasonInvalid, err.Error())
+		return true
+	}
+
+	key := syncedServiceTarget(syncedService)
+	if owner := r.owners[key]; owner.Namespace != syncedService.Namespace || owner.Name != syncedService.Name {
+		syncedService.Status.Instances = 0
+		syncedService.SetSyncedCondition(corev1.ConditionFalse, syncedServiceReasonConflict,
+			fmt.Sprintf("service %q is already synced by SyncedService %q", syncedService.Spec.Service, owner.Name))
+		return true
+	}
+
+	registrations, ok := r.Service.Registrations(key)
+	if !ok {
+		syncedService.Status.Instances = 0
+		syncedService.SetSyncedCondition(corev1.ConditionFalse, syncedServiceReasonNotSynced,
+			fmt.Sprintf("service %q does not exist or is not eligible for sync", syncedService.Spec.Service))
+		return true
+	}
+
+	// The registrations are only known once the syncer has run.
+	if r.result == nil {
+		return false
+	}
+
+	instances := 0
+	var syncErr error
+	for _, registration := range registrations {
+		err, ok := r.result.Registrations[registration.Service.ID]
+		if !ok {
+			// The instance hasn't been registered yet.
+			continue
+		}
+		if err != nil {
+			if syncErr == nil {
+				syncErr = err
+			}
+			continue
+		}
+		instances++
+	}
+
+	previous := syncedService.Status
+	syncedService.Status.Instances = instances
+	if syncErr != nil {
+		syncedService.SetSyncedCondition(corev1.ConditionFalse, syncedServiceReasonError, syncErr.Error())
+		return true
+	}
+	syncedService.SetSyncedCondition(corev1.ConditionTrue, "", "")
+
+	// Only record the time of the sync when its result changes so that the
+	// status isn't written again on every periodic sync.
+	if previous.LastSyncedTime == nil || previous.Instances != instances ||
+		!reflect.DeepEqual(previous.Conditions, syncedService.Status.Conditions) {
+		syncedTime := metav1.NewTime(r.result.Time)
+		syncedService.Status.LastSyncedTime = &syncedTime
+	}
+	return true
+}
+
+// syncedServiceTarget returns the key of the service a SyncedService
+// references in the form <kube namespace>/<kube svc name>.
+func syncedServiceTarget(syncedService *v1alpha1.SyncedService) string {
+	return syncedService.Namespace + "/" + syncedService.Spec.Service
+}
+
+// olderSyncedService returns true if a was created before b. SyncedServices
+// created at the same time are ordered by name.
+func olderSyncedService(a, b *v1alpha1.SyncedService) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Name < b.Name
+}
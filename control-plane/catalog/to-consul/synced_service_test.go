@@ -0,0 +1,238 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/control-plane/helper/controller"
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// Test that a SyncedService enables the sync of a service and takes
+// precedence over its annotations.
+func TestServiceResource_syncedService(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ClusterIPSync = true
+	serviceResource.ExplicitEnable = true
+	serviceResource.ConsulK8STag = TestConsulK8STag
+
+	// Start the controller
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	// Insert the service and endpoints
+	svc := clusterIPService("foo", metav1.NamespaceDefault)
+	svc.Annotations = map[string]string{
+		annotationServiceName: "annotated",
+		annotationServiceTags: "annotated",
+	}
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	createEndpointsWithNotReady(t, client, "foo", metav1.NamespaceDefault)
+
+	// The service isn't synced since it's not enabled explicitly.
+	time.Sleep(100 * time.Millisecond)
+	syncer.Lock()
+	require.Len(t, syncer.Registrations, 0)
+	syncer.Unlock()
+
+	syncReadinessChecks := true
+	serviceResource.SetSyncedService("default/foo", &v1alpha1.SyncedService{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: metav1.NamespaceDefault},
+		Spec: v1alpha1.SyncedServiceSpec{
+			Service:    "foo",
+			ConsulName: "bar",
+			Port:       "http",
+			Tags:       []string{"synced"},
+			Meta:       map[string]string{"team": "a"},
+			Health:     &v1alpha1.SyncedServiceHealth{SyncReadinessChecks: &syncReadinessChecks},
+		},
+	})
+
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		actual := syncer.Registrations
+		require.Len(r, actual, 2)
+		require.Equal(r, "bar", actual[0].Service.Service)
+		require.Equal(r, 8080, actual[0].Service.Port)
+		require.Equal(r, []string{TestConsulK8STag, "annotated", "synced"}, actual[0].Service.Tags)
+		require.Equal(r, "a", actual[0].Service.Meta["team"])
+		require.Equal(r, consulapi.HealthPassing, actual[0].Check.Status)
+		require.Equal(r, consulapi.HealthCritical, actual[1].Check.Status)
+	})
+
+	// Removing the SyncedService disables the sync again.
+	serviceResource.SetSyncedService("default/foo", nil)
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		require.Len(r, syncer.Registrations, 0)
+	})
+}
+
+// Test that SyncedServiceResource configures the sync of services and
+// reports its status.
+func TestSyncedServiceResource(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	syncer := newTestSyncer()
+	serviceResource := defaultServiceResource(client, syncer)
+	serviceResource.ClusterIPSync = true
+	serviceResource.ExplicitEnable = true
+
+	closer := controller.TestControllerRun(&serviceResource)
+	defer closer()
+
+	svc := clusterIPService("foo", metav1.NamespaceDefault)
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+	createEndpoints(t, client, "foo", metav1.NamespaceDefault)
+
+	now := time.Now()
+	syncedServices := []runtime.Object{
+		&v1alpha1.SyncedService{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "foo",
+				Namespace:         metav1.NamespaceDefault,
+				CreationTimestamp: metav1.NewTime(now.Add(-time.Hour)),
+			},
+			Spec: v1alpha1.SyncedServiceSpec{Service: "foo", ConsulName: "bar"},
+		},
+		&v1alpha1.SyncedService{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "conflict",
+				Namespace:         metav1.NamespaceDefault,
+				CreationTimestamp: metav1.NewTime(now),
+			},
+			Spec: v1alpha1.SyncedServiceSpec{Service: "foo"},
+		},
+		&v1alpha1.SyncedService{
+			ObjectMeta: metav1.ObjectMeta{Name: "missing", Namespace: metav1.NamespaceDefault},
+			Spec:       v1alpha1.SyncedServiceSpec{Service: "missing"},
+		},
+		&v1alpha1.SyncedService{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid", Namespace: metav1.NamespaceDefault},
+		},
+	}
+	s := runtime.NewScheme()
+	s.AddKnownTypes(v1alpha1.GroupVersion, &v1alpha1.SyncedService{}, &v1alpha1.SyncedServiceList{})
+	metav1.AddToGroupVersion(s, v1alpha1.GroupVersion)
+	ctrlClient := ctrlfake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(syncedServices...).Build()
+
+	syncedServiceResource := &SyncedServiceResource{
+		Log:     hclog.Default(),
+		Client:  ctrlClient,
+		Service: &serviceResource,
+		Ctx:     context.Background(),
+	}
+	syncedCloser := controller.TestControllerRun(syncedServiceResource)
+	defer syncedCloser()
+
+	// The SyncedService enables the sync of the service.
+	var registrations []*consulapi.CatalogRegistration
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		registrations = syncer.Registrations
+		require.Len(r, registrations, 2)
+		require.Equal(r, "bar", registrations[0].Service.Service)
+	})
+
+	// The status of invalid, conflicting and missing services is reported
+	// before the first sync.
+	retry.Run(t, func(r *retry.R) {
+		requireSyncedServiceCondition(r, ctrlClient, "invalid", corev1.ConditionFalse, syncedServiceReasonInvalid)
+		requireSyncedServiceCondition(r, ctrlClient, "conflict", corev1.ConditionFalse, syncedServiceReasonConflict)
+		requireSyncedServiceCondition(r, ctrlClient, "missing", corev1.ConditionFalse, syncedServiceReasonNotSynced)
+	})
+
+	// One instance fails to register.
+	syncTime := time.Now().Truncate(time.Second)
+	syncedServiceResource.HandleSyncResult(SyncResult{
+		Time: syncTime,
+		Registrations: map[string]error{
+			registrations[0].Service.ID: nil,
+			registrations[1].Service.ID: errors.New("creating Consul namespace \"ns\": boom"),
+		},
+	})
+	retry.Run(t, func(r *retry.R) {
+		syncedService := requireSyncedServiceCondition(r, ctrlClient, "foo", corev1.ConditionFalse, syncedServiceReasonError)
+		require.Equal(r, 1, syncedService.Status.Instances)
+		require.Contains(r, syncedService.SyncedCondition().Message, "boom")
+	})
+
+	// All instances are registered.
+	syncedServiceResource.HandleSyncResult(SyncResult{
+		Time: syncTime,
+		Registrations: map[string]error{
+			registrations[0].Service.ID: nil,
+			registrations[1].Service.ID: nil,
+		},
+	})
+	retry.Run(t, func(r *retry.R) {
+		syncedService := requireSyncedServiceCondition(r, ctrlClient, "foo", corev1.ConditionTrue, "")
+		require.Equal(r, 2, syncedService.Status.Instances)
+		require.NotNil(r, syncedService.Status.LastSyncedTime)
+		require.True(r, syncedService.Status.LastSyncedTime.Time.Equal(syncTime))
+	})
+
+	// The status isn't written again when a later sync has the same result.
+	var synced v1alpha1.SyncedService
+	require.NoError(t, ctrlClient.Get(context.Background(), types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "foo"}, &synced))
+	syncedServiceResource.HandleSyncResult(SyncResult{
+		Time: syncTime.Add(time.Minute),
+		Registrations: map[string]error{
+			registrations[0].Service.ID: nil,
+			registrations[1].Service.ID: nil,
+		},
+	})
+	syncedServiceResource.updateStatuses()
+	var resynced v1alpha1.SyncedService
+	require.NoError(t, ctrlClient.Get(context.Background(), types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "foo"}, &resynced))
+	require.Equal(t, synced.ResourceVersion, resynced.ResourceVersion)
+	require.True(t, resynced.Status.LastSyncedTime.Time.Equal(syncTime))
+
+	// Deleting the SyncedService disables the sync of the service and the
+	// conflicting one takes over.
+	err = ctrlClient.Delete(context.Background(), &v1alpha1.SyncedService{
+		ObjectMeta: metav1.ObjectMeta{Name: "foo", Namespace: metav1.NamespaceDefault},
+	})
+	require.NoError(t, err)
+	retry.Run(t, func(r *retry.R) {
+		syncer.Lock()
+		defer syncer.Unlock()
+		require.Len(r, syncer.Registrations, 2)
+		require.Equal(r, "foo", syncer.Registrations[0].Service.Service)
+	})
+}
+
+// requireSyncedServiceCondition requires the SyncedService with the given
+// name in the default namespace to have a Synced condition with the given
+// status and reason and returns it.
+func requireSyncedServiceCondition(r *retry.R, c client.Client, name string, status corev1.ConditionStatus, reason string) *v1alpha1.SyncedService {
+	syncedService := &v1alpha1.SyncedService{}
+	err := c.Get(context.Background(), types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: name}, syncedService)
+	require.NoError(r, err)
+	cond := syncedService.SyncedCondition()
+	require.NotNil(r, cond)
+	require.Equal(r, status, cond.Status)
+	require.Equal(r, reason, cond.Reason)
+	return syncedService
+}
@@ -2,11 +2,14 @@ package catalog
 
 import (
 	"context"
+	"fmt"
+	"reflect"
 	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
 	mapset "github.com/deckarep/golang-set"
+	"github.com/hashicorp/consul-k8s/control-plane/catalog/metrics"
 	"github.com/hashicorp/consul-k8s/control-plane/namespaces"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
@@ -31,6 +34,18 @@ type Syncer interface {
 	Sync([]*api.CatalogRegistration)
 }
 
+// SyncResult is the result of registering the service instances in Consul
+// during a full sync.
+type SyncResult struct {
+	// Time is when the sync finished.
+	Time time.Time
+
+	// Registrations maps the IDs of the service instances that the sync
+	// tried to register to the error registering them, which is nil if the
+	// instance was registered.
+	Registrations map[string]error
+}
+
 // ConsulSyncer is a Syncer that takes the set of registrations and
 // registers them with Consul. It also watches Consul for changes to the
 // services and ensures the local set of registrations represents the
@@ -69,10 +84,30 @@ type ConsulSyncer struct {
 	// The Consul node name to register services with.
 	ConsulNodeName string
 
+	// ConsulNodePerK8SNode set to true means that services are also
+	// registered on one Consul node per Kubernetes node. Those nodes are
+	// found by their ConsulK8SSyncNode node meta, their services are reaped
+	// like the services of ConsulNodeName and nodes left without services
+	// are deregistered.
+	ConsulNodePerK8SNode bool
+
 	// ConsulNodeServicesClient is used to list services for a node. We use a
 	// separate client for this API call that handles older version of Consul.
 	ConsulNodeServicesClient ConsulNodeServicesClient
 
+	// SyncOnStart set to true makes Run reconcile right away instead of
+	// after SyncPeriod if Sync was already called, e.g. because the syncer
+	// was kept up to date on a standby.
+	SyncOnStart bool
+
+	// Metrics records the metrics of the sync if set.
+	Metrics *metrics.Metrics
+
+	// SyncResultHandler is called with the result of every full sync if set.
+	// It's called with the lock held so it must not block or call the
+	// syncer.
+	SyncResultHandler func(SyncResult)
+
 	lock sync.Mutex
 	once sync.Once
 
@@ -93,6 +128,11 @@ type ConsulSyncer struct {
 	namespaces map[string]map[string]*api.CatalogRegistration
 	deregs     map[string]*api.CatalogDeregistration
 
+	// registered holds the registrations that were last registered
+	// successfully by service ID so that the registrations of a periodic
+	// sync that didn't change aren't counted in the metrics.
+	registered map[string]*api.CatalogRegistration
+
 	// watchers is all namespaces mapped to a map of Consul service
 	// names mapped to a cancel function for watcher routines
 	watchers map[string]map[string]context.CancelFunc
@@ -100,6 +140,10 @@ type ConsulSyncer struct {
 
 // Sync implements Syncer.
 func (s *ConsulSyncer) Sync(rs []*api.CatalogRegistration) {
+	// Sync may be called before Run, e.g. while the syncer is kept up to
+	// date on a standby.
+	s.once.Do(s.init)
+
 	// Grab the lock so we can replace the sync state
 	s.lock.Lock()
 	defer s.lock.Unlock()
@@ -141,7 +185,15 @@ func (s *ConsulSyncer) Run(ctx context.Context) {
 	// Start the background watchers
 	go s.watchReapableServices(ctx)
 
-	reconcileTimer := time.NewTimer(s.SyncPeriod)
+	firstSync := s.SyncPeriod
+	if s.SyncOnStart {
+		select {
+		case <-s.initialSync:
+			firstSync = 0
+		default:
+		}
+	}
+	reconcileTimer := time.NewTimer(firstSync)
 	defer reconcileTimer.Stop()
 
 	for {
@@ -184,15 +236,17 @@ func (s *ConsulSyncer) watchReapableServices(ctx context.Context) {
 	minWaitCh := time.After(0)
 	for {
 		var services []ConsulService
+		var emptyNodes []string
 		var meta *api.QueryMeta
 		err := backoff.Retry(func() error {
 			var err error
-			services, meta, err = s.ConsulNodeServicesClient.NodeServices(s.ConsulK8STag, s.ConsulNodeName, *opts)
+			services, emptyNodes, meta, err = s.reapableServices(*opts)
 			return err
 		}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
 
 		if err != nil {
 			s.Log.Warn("error querying services, will retry", "err", err)
+			s.Metrics.WatchRestarted(metrics.DirectionToConsul, metrics.WatchReapableServices, "")
 		} else {
 			s.Log.Debug("[watchReapableServices] services returned from catalog",
 				"services", services)
@@ -232,6 +286,7 @@ func (s *ConsulSyncer) watchReapableServices(ctx context.Context) {
 
 			s.Log.Info("invalid service found, scheduling for delete",
 				"service-name", service.Name, "service-consul-namespace", service.Namespace)
+			s.Metrics.Reaped(service.Namespace)
 			if err := s.scheduleReapServiceLocked(service.Name, service.Namespace); err != nil {
 				s.Log.Info("error querying service for delete",
 					"service-name", service.Name,
@@ -240,10 +295,92 @@ func (s *ConsulSyncer) watchReapableServices(ctx context.Context) {
 			}
 		}
 
+		s.scheduleReapNodesLocked(emptyNodes)
+
 		s.lock.Unlock()
 	}
 }
 
+// reapableServices returns the services tagged with the k8s tag registered
+// on the sync node and, if ConsulNodePerK8SNode is set, on the Consul nodes
+// of the Kubernetes nodes. It also returns the Consul nodes of Kubernetes
+// nodes without any such services. The query blocks on the services of the
+// sync node or, if ConsulNodePerK8SNode is set, on the list of nodes, in
+// which case changes to the services are picked up at least every WaitTime.
+func (s *ConsulSyncer) reapableServices(opts api.QueryOptions) ([]ConsulService, []string, *api.QueryMeta, error) {
+	if !s.ConsulNodePerK8SNode {
+		services, meta, err := s.ConsulNodeServicesClient.NodeServices(s.ConsulK8STag, s.ConsulNodeName, opts)
+		if err != nil {
+			s.Metrics.ConsulError(metrics.DirectionToConsul, metrics.OpNodeServices, "")
+		}
+		return services, nil, meta, err
+	}
+
+	nodeOpts := opts
+	nodeOpts.Namespace = ""
+	nodeOpts.NodeMeta = map[string]string{ConsulK8SSyncNode: s.ConsulNodeName}
+	nodes, meta, err := s.Client.Catalog().Nodes(&nodeOpts)
+	if err != nil {
+		s.Metrics.ConsulError(metrics.DirectionToConsul, metrics.OpCatalogNodes, "")
+		return nil, nil, nil, err
+	}
+
+	// Only the node list query blocks.
+	opts.WaitIndex = 0
+	services, _, err := s.ConsulNodeServicesClient.NodeServices(s.ConsulK8STag, s.ConsulNodeName, opts)
+	if err != nil {
+		s.Metrics.ConsulError(metrics.DirectionToConsul, metrics.OpNodeServices, "")
+		return nil, nil, nil, err
+	}
+
+	var emptyNodes []string
+	for _, node := range nodes {
+		nodeServices, _, err := s.ConsulNodeServicesClient.NodeServices(s.ConsulK8STag, node.Node, opts)
+		if err != nil {
+			s.Metrics.ConsulError(metrics.DirectionToConsul, metrics.OpNodeServices, "")
+			return nil, nil, nil, err
+		}
+		if len(nodeServices) == 0 {
+			emptyNodes = append(emptyNodes, node.Node)
+		}
+		services = append(services, nodeServices...)
+	}
+	return services, emptyNodes, meta, nil
+}
+
+// scheduleReapNodesLocked schedules the deregistration of the given Consul
+// nodes of Kubernetes nodes unless services are about to be registered on
+// them.
+//
+// Precondition: lock must be held.
+func (s *ConsulSyncer) scheduleReapNodesLocked(nodes []string) {
+	if len(nodes) == 0 {
+		return
+	}
+
+	inUse := make(map[string]struct{})
+	for _, services := range s.namespaces {
+		for _, r := range services {
+			inUse[r.Node] = struct{}{}
+		}
+	}
+
+	for _, node := range nodes {
+		if _, ok := inUse[node]; ok {
+			continue
+		}
+		s.Log.Info("node without services found, scheduling for delete", "node-name", node)
+		s.deregs[nodeDeregKey(node)] = &api.CatalogDeregistration{Node: node}
+	}
+}
+
+// nodeDeregKey returns the key of the deregistration of a node in the
+// deregs map. It can't collide with service IDs since they never end in a
+// slash.
+func nodeDeregKey(node string) string {
+	return node + "/"
+}
+
 // watchService watches all instances of a service by name for changes
 // and schedules re-registration or deletion if necessary.
 func (s *ConsulSyncer) watchService(ctx context.Context, name, namespace string) {
@@ -274,6 +411,9 @@ func (s *ConsulSyncer) watchService(ctx context.Context, name, namespace string)
 		err := backoff.Retry(func() error {
 			var err error
 			services, _, err = s.Client.Catalog().Service(name, s.ConsulK8STag, queryOpts)
+			if err != nil {
+				s.Metrics.ConsulError(metrics.DirectionToConsul, metrics.OpCatalogService, namespace)
+			}
 			return err
 		}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
 		if err != nil {
@@ -281,6 +421,7 @@ func (s *ConsulSyncer) watchService(ctx context.Context, name, namespace string)
 				"service-name", name,
 				"service-namespace", namespace, // will be "" if namespaces aren't enabled
 				"err", err)
+			s.Metrics.WatchRestarted(metrics.DirectionToConsul, metrics.WatchService, namespace)
 			continue
 		}
 
@@ -328,6 +469,7 @@ func (s *ConsulSyncer) scheduleReapServiceLocked(name, namespace string) error {
 	// Only consider services that are tagged from k8s
 	services, _, err := s.Client.Catalog().Service(name, s.ConsulK8STag, &opts)
 	if err != nil {
+		s.Metrics.ConsulError(metrics.DirectionToConsul, metrics.OpCatalogService, namespace)
 		return err
 	}
 
@@ -392,6 +534,7 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 	}
 
 	// Do all deregistrations first
+	synced := true
 	for _, r := range s.deregs {
 		s.Log.Info("deregistering service",
 			"node-name", r.Node,
@@ -404,7 +547,11 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 				"service-id", r.ServiceID,
 				"service-consul-namespace", r.Namespace,
 				"err", err)
+			s.Metrics.ConsulError(metrics.DirectionToConsul, metrics.OpDeregister, r.Namespace)
+			synced = false
+			continue
 		}
+		s.Metrics.Deregistered(metrics.DirectionToConsul, r.Namespace)
 	}
 
 	// Always clear deregistrations, they'll repopulate if we had errors
@@ -412,6 +559,8 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 
 	// Register all the services. This will overwrite any changes that
 	// may have been made to the registered services.
+	registrations := make(map[string]error)
+	registered := make(map[string]*api.CatalogRegistration)
 	for _, services := range s.namespaces {
 		for _, r := range services {
 			if s.EnableNamespaces {
@@ -422,6 +571,9 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 						"service-name", r.Service.Service,
 						"consul-namespace-name", r.Service.Namespace,
 						"err", err)
+					registrations[r.Service.ID] = fmt.Errorf("creating Consul namespace %q: %w", r.Service.Namespace, err)
+					s.Metrics.ConsulError(metrics.DirectionToConsul, metrics.OpCreateNamespace, r.Service.Namespace)
+					synced = false
 					continue
 				}
 			}
@@ -434,8 +586,34 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 					"service-name", r.Service.Service,
 					"service", r.Service,
 					"err", err)
+				registrations[r.Service.ID] = fmt.Errorf("registering service instance %q: %w", r.Service.ID, err)
+				s.Metrics.ConsulError(metrics.DirectionToConsul, metrics.OpRegister, r.Service.Namespace)
+				synced = false
 				continue
 			}
+			// Registering a service instance without a check keeps the
+			// checks it was registered with, so the readiness check is
+			// deregistered when readiness checks are no longer synced for
+			// it. Instances that were not registered by this process yet
+			// may still have one from before a restart.
+			if previous := s.registered[r.Service.ID]; r.Check == nil && (previous == nil || previous.Check != nil) {
+				if err := s.deregisterReadinessCheck(r); err != nil {
+					registrations[r.Service.ID] = err
+					// Keep the previous registration so that the check is
+					// deregistered again on the next sync.
+					if previous != nil {
+						registered[r.Service.ID] = previous
+					}
+					synced = false
+					continue
+				}
+			}
+
+			registrations[r.Service.ID] = nil
+			registered[r.Service.ID] = r
+			if !reflect.DeepEqual(s.registered[r.Service.ID], r) {
+				s.Metrics.Registered(metrics.DirectionToConsul, r.Service.Namespace)
+			}
 
 			s.Log.Debug("registered service instance",
 				"node-name", r.Node,
@@ -444,6 +622,40 @@ func (s *ConsulSyncer) syncFull(ctx context.Context) {
 				"service", r.Service)
 		}
 	}
+
+	s.registered = registered
+
+	if synced {
+		s.Metrics.Synced()
+	}
+
+	if s.SyncResultHandler != nil {
+		s.SyncResultHandler(SyncResult{Time: time.Now(), Registrations: registrations})
+	}
+}
+
+// deregisterReadinessCheck deregisters the readiness check of the service
+// instance of the registration. Deregistering a check that doesn't exist is
+// not an error.
+//
+// Precondition: lock must be held.
+func (s *ConsulSyncer) deregisterReadinessCheck(r *api.CatalogRegistration) error {
+	_, err := s.Client.Catalog().Deregister(&api.CatalogDeregistration{
+		Node:      r.Node,
+		CheckID:   readinessCheckID(r.Service.ID),
+		Namespace: r.Service.Namespace,
+		Partition: r.Partition,
+	}, nil)
+	if err != nil {
+		s.Log.Warn("error deregistering readiness check",
+			"node-name", r.Node,
+			"service-id", r.Service.ID,
+			"service-consul-namespace", r.Service.Namespace,
+			"err", err)
+		s.Metrics.ConsulError(metrics.DirectionToConsul, metrics.OpDeregister, r.Service.Namespace)
+		return fmt.Errorf("deregistering readiness check of service instance %q: %w", r.Service.ID, err)
+	}
+	return nil
 }
 
 func (s *ConsulSyncer) init() {
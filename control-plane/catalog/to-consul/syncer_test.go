@@ -2,16 +2,22 @@ package catalog
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/hashicorp/consul-k8s/control-plane/catalog/metrics"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/sdk/testutil"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 )
 
@@ -62,6 +68,41 @@ func TestConsulSyncer_register(t *testing.T) {
 	require.Equal("127.0.0.1", service.Address)
 }
 
+// Test that the syncer reports the result of registering service instances.
+func TestConsulSyncer_syncResult(t *testing.T) {
+	t.Parallel()
+
+	a, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	defer a.Stop()
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPAddr,
+	})
+	require.NoError(t, err)
+
+	resultCh := make(chan SyncResult, 1)
+	s, closer := testConsulSyncerWithConfig(client, func(s *ConsulSyncer) {
+		s.SyncResultHandler = func(result SyncResult) {
+			select {
+			case resultCh <- result:
+			default:
+			}
+		}
+	})
+	defer closer()
+
+	registration := testRegistration(ConsulSyncNodeName, "bar", "default")
+	s.Sync([]*api.CatalogRegistration{registration})
+
+	select {
+	case result := <-resultCh:
+		require.Equal(t, map[string]error{registration.Service.ID: nil}, result.Registrations)
+		require.False(t, result.Time.IsZero())
+	case <-time.After(5 * time.Second):
+		t.Fatal("no sync result")
+	}
+}
+
 // Test that the syncer reaps individual invalid service instances.
 func TestConsulSyncer_reapServiceInstance(t *testing.T) {
 	t.Parallel()
@@ -180,6 +221,75 @@ func TestConsulSyncer_reapService(t *testing.T) {
 	}
 }
 
+// Test that the syncer reaps services on the Consul nodes of Kubernetes nodes
+// and deregisters those nodes once they have no services.
+func TestConsulSyncer_reapConsulNodePerK8SNode(t *testing.T) {
+	t.Parallel()
+
+	a, err := testutil.NewTestServerConfigT(t, nil)
+	require.NoError(t, err)
+	defer a.Stop()
+	client, err := api.NewClient(&api.Config{
+		Address: a.HTTPAddr,
+	})
+	require.NoError(t, err)
+
+	s, closer := testConsulSyncerWithConfig(client, func(s *ConsulSyncer) {
+		s.ConsulNodePerK8SNode = true
+	})
+	defer closer()
+
+	node1 := ConsulSyncNodeName + "-node1"
+	node2 := ConsulSyncNodeName + "-node2"
+
+	// Run the sync with a test service on the node of node1.
+	s.Sync([]*api.CatalogRegistration{
+		testK8SNodeRegistration(node1, "bar"),
+	})
+
+	// Create services directly in Consul on the nodes of both Kubernetes
+	// nodes. Since they were created directly we expect them to be deleted,
+	// along with node2 which is left without services.
+	_, err = client.Catalog().Register(testK8SNodeRegistration(node1, "baz"), nil)
+	require.NoError(t, err)
+	_, err = client.Catalog().Register(testK8SNodeRegistration(node2, "qux"), nil)
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		for _, name := range []string{"baz", "qux"} {
+			instances, _, err := client.Catalog().Service(name, "", nil)
+			require.NoError(r, err)
+			require.Len(r, instances, 0)
+		}
+
+		barInstances, _, err := client.Catalog().Service("bar", "", nil)
+		require.NoError(r, err)
+		require.Len(r, barInstances, 1)
+		require.Equal(r, node1, barInstances[0].Node)
+
+		node, _, err := client.Catalog().Node(node2, nil)
+		require.NoError(r, err)
+		require.Nil(r, node)
+	})
+}
+
+func TestConsulSyncer_scheduleReapNodesLocked(t *testing.T) {
+	t.Parallel()
+
+	s := &ConsulSyncer{Log: hclog.NewNullLogger()}
+	s.init()
+	s.Sync([]*api.CatalogRegistration{
+		testK8SNodeRegistration("k8s-sync-node1", "bar"),
+	})
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.scheduleReapNodesLocked([]string{"k8s-sync-node1", "k8s-sync-node2"})
+	require.Equal(t, map[string]*api.CatalogDeregistration{
+		nodeDeregKey("k8s-sync-node2"): {Node: "k8s-sync-node2"},
+	}, s.deregs)
+}
+
 // Test that the syncer doesn't reap any services until the initial sync has
 // been performed.
 func TestConsulSyncer_noReapingUntilInitialSync(t *testing.T) {
@@ -264,6 +374,341 @@ func TestConsulSyncer_stopsGracefully(t *testing.T) {
 	require.LessOrEqual(t, callCount-beforeStopAPICount, 2)
 }
 
+// Test that a syncer that received the registrations before it's run, as a
+// standby does, registers them right away instead of after the sync period.
+func TestConsulSyncer_syncsRightAwayWhenWarm(t *testing.T) {
+	t.Parallel()
+
+	registerCh := make(chan struct{}, 1)
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/catalog/register" {
+			select {
+			case registerCh <- struct{}{}:
+			default:
+			}
+		}
+		w.WriteHeader(500)
+	}))
+	defer consulServer.Close()
+
+	client, err := api.NewClient(&api.Config{
+		Address: consulServer.URL,
+	})
+	require.NoError(t, err)
+
+	s := &ConsulSyncer{
+		Client:            client,
+		Log:               hclog.NewNullLogger(),
+		SyncPeriod:        time.Hour,
+		ServicePollPeriod: time.Hour,
+		SyncOnStart:       true,
+		ConsulK8STag:      TestConsulK8STag,
+		ConsulNodeName:    ConsulSyncNodeName,
+		ConsulNodeServicesClient: &PreNamespacesNodeServicesClient{
+			Client: client,
+		},
+	}
+	s.Sync([]*api.CatalogRegistration{
+		testRegistration(ConsulSyncNodeName, "bar", "default"),
+	})
+
+	ctx, cancelF := context.WithCancel(context.Background())
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		s.Run(ctx)
+	}()
+	defer func() {
+		cancelF()
+		<-doneCh
+	}()
+
+	select {
+	case <-registerCh:
+	case <-time.After(5 * time.Second):
+		t.Fatal("service was not registered right away")
+	}
+}
+
+// Test that registrations and Consul API errors of a full sync are counted.
+func TestConsulSyncer_metrics(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		status   int
+		expected string
+	}{
+		"registered": {
+			status: http.StatusOK,
+			expected: `
+# HELP consul_sync_catalog_registrations_total The number of services registered in Consul or created and updated in Kubernetes.
+# TYPE consul_sync_catalog_registrations_total counter
+consul_sync_catalog_registrations_total{direction="to-consul",namespace=""} 1
+`,
+		},
+		"error": {
+			status: http.StatusInternalServerError,
+			expected: `
+# HELP consul_sync_catalog_consul_api_errors_total The number of failed Consul API calls.
+# TYPE consul_sync_catalog_consul_api_errors_total counter
+consul_sync_catalog_consul_api_errors_total{direction="to-consul",namespace="",operation="register"} 1
+`,
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			stopCh := make(chan struct{})
+			consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v1/catalog/register" {
+					w.WriteHeader(c.status)
+					return
+				}
+				if r.URL.Path == "/v1/catalog/deregister" {
+					// The readiness check the service instance may have.
+					return
+				}
+				// Block the other queries until the test ends so that only
+				// the registration is made.
+				<-stopCh
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer consulServer.Close()
+			defer close(stopCh)
+
+			client, err := api.NewClient(&api.Config{
+				Address: consulServer.URL,
+			})
+			require.NoError(t, err)
+
+			reg := prometheus.NewRegistry()
+			s := &ConsulSyncer{
+				Client:            client,
+				Log:               hclog.NewNullLogger(),
+				SyncPeriod:        time.Hour,
+				ServicePollPeriod: time.Hour,
+				SyncOnStart:       true,
+				ConsulK8STag:      TestConsulK8STag,
+				ConsulNodeName:    ConsulSyncNodeName,
+				ConsulNodeServicesClient: &PreNamespacesNodeServicesClient{
+					Client: client,
+				},
+				Metrics: metrics.New(reg),
+			}
+			s.Sync([]*api.CatalogRegistration{
+				testRegistration(ConsulSyncNodeName, "bar", "default"),
+			})
+
+			ctx, cancelF := context.WithCancel(context.Background())
+			doneCh := make(chan struct{})
+			go func() {
+				defer close(doneCh)
+				s.Run(ctx)
+			}()
+			defer func() {
+				cancelF()
+				<-doneCh
+			}()
+
+			retry.Run(t, func(r *retry.R) {
+				require.NoError(r, promtestutil.GatherAndCompare(reg, strings.NewReader(c.expected),
+					"consul_sync_catalog_registrations_total", "consul_sync_catalog_consul_api_errors_total"))
+			})
+		})
+	}
+}
+
+// Test that only registrations that changed are counted, not the ones
+// re-registered by every periodic sync.
+func TestConsulSyncer_metricsCountChanges(t *testing.T) {
+	t.Parallel()
+	stopCh := make(chan struct{})
+	var lock sync.Mutex
+	registers := 0
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v1/catalog/register" {
+			lock.Lock()
+			registers++
+			lock.Unlock()
+			return
+		}
+		if r.URL.Path == "/v1/catalog/deregister" {
+			// The readiness check the service instance may have.
+			return
+		}
+		// Block the other queries until the test ends so that only the
+		// registrations are made.
+		<-stopCh
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer consulServer.Close()
+	defer close(stopCh)
+
+	client, err := api.NewClient(&api.Config{Address: consulServer.URL})
+	require.NoError(t, err)
+
+	reg := prometheus.NewRegistry()
+	s := &ConsulSyncer{
+		Client:            client,
+		Log:               hclog.NewNullLogger(),
+		SyncPeriod:        10 * time.Millisecond,
+		ServicePollPeriod: time.Hour,
+		SyncOnStart:       true,
+		ConsulK8STag:      TestConsulK8STag,
+		ConsulNodeName:    ConsulSyncNodeName,
+		ConsulNodeServicesClient: &PreNamespacesNodeServicesClient{
+			Client: client,
+		},
+		Metrics: metrics.New(reg),
+	}
+	s.Sync([]*api.CatalogRegistration{
+		testRegistration(ConsulSyncNodeName, "bar", "default"),
+	})
+
+	ctx, cancelF := context.WithCancel(context.Background())
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		s.Run(ctx)
+	}()
+	defer func() {
+		cancelF()
+		<-doneCh
+	}()
+
+	requireRegistrations := func(registersAtLeast int, expected string) {
+		retry.Run(t, func(r *retry.R) {
+			lock.Lock()
+			defer lock.Unlock()
+			require.GreaterOrEqual(r, registers, registersAtLeast)
+		})
+		require.NoError(t, promtestutil.GatherAndCompare(reg, strings.NewReader(expected),
+			"consul_sync_catalog_registrations_total"))
+	}
+
+	requireRegistrations(3, `
+# HELP consul_sync_catalog_registrations_total The number of services registered in Consul or created and updated in Kubernetes.
+# TYPE consul_sync_catalog_registrations_total counter
+consul_sync_catalog_registrations_total{direction="to-consul",namespace=""} 1
+`)
+
+	changed := testRegistration(ConsulSyncNodeName, "bar", "default")
+	changed.Service.Tags = append(changed.Service.Tags, "v2")
+	s.Sync([]*api.CatalogRegistration{changed})
+	lock.Lock()
+	registersBefore := registers
+	lock.Unlock()
+
+	requireRegistrations(registersBefore+3, `
+# HELP consul_sync_catalog_registrations_total The number of services registered in Consul or created and updated in Kubernetes.
+# TYPE consul_sync_catalog_registrations_total counter
+consul_sync_catalog_registrations_total{direction="to-consul",namespace=""} 2
+`)
+}
+
+// Test that the readiness check of a service instance is deregistered once
+// readiness checks are no longer synced for it, since registering the instance
+// without a check keeps its checks.
+func TestConsulSyncer_deregistersReadinessCheck(t *testing.T) {
+	t.Parallel()
+	stopCh := make(chan struct{})
+	var lock sync.Mutex
+	var registers []*api.CatalogRegistration
+	var deregisters []*api.CatalogDeregistration
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/catalog/register":
+			var reg api.CatalogRegistration
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&reg))
+			lock.Lock()
+			registers = append(registers, &reg)
+			lock.Unlock()
+			return
+		case "/v1/catalog/deregister":
+			var dereg api.CatalogDeregistration
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&dereg))
+			lock.Lock()
+			deregisters = append(deregisters, &dereg)
+			lock.Unlock()
+			return
+		}
+		// Block the other queries until the test ends so that only the
+		// registrations are made.
+		<-stopCh
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer consulServer.Close()
+	defer close(stopCh)
+
+	client, err := api.NewClient(&api.Config{Address: consulServer.URL})
+	require.NoError(t, err)
+
+	s := &ConsulSyncer{
+		Client:            client,
+		Log:               hclog.NewNullLogger(),
+		SyncPeriod:        10 * time.Millisecond,
+		ServicePollPeriod: time.Hour,
+		SyncOnStart:       true,
+		ConsulK8STag:      TestConsulK8STag,
+		ConsulNodeName:    ConsulSyncNodeName,
+		ConsulNodeServicesClient: &PreNamespacesNodeServicesClient{
+			Client: client,
+		},
+	}
+	withCheck := testRegistration(ConsulSyncNodeName, "bar", "default")
+	withCheck.Check = &api.AgentCheck{
+		CheckID:     readinessCheckID(withCheck.Service.ID),
+		Name:        ConsulK8SReadinessCheckName,
+		Status:      api.HealthCritical,
+		ServiceID:   withCheck.Service.ID,
+		ServiceName: withCheck.Service.Service,
+	}
+	s.Sync([]*api.CatalogRegistration{withCheck})
+
+	ctx, cancelF := context.WithCancel(context.Background())
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		s.Run(ctx)
+	}()
+	defer func() {
+		cancelF()
+		<-doneCh
+	}()
+
+	// While readiness checks are synced, the check is registered and never
+	// deregistered.
+	retry.Run(t, func(r *retry.R) {
+		lock.Lock()
+		defer lock.Unlock()
+		require.GreaterOrEqual(r, len(registers), 2)
+	})
+	lock.Lock()
+	require.NotNil(t, registers[0].Check)
+	require.Empty(t, deregisters)
+	lock.Unlock()
+
+	// Turn readiness checks off for the service instance.
+	s.Sync([]*api.CatalogRegistration{testRegistration(ConsulSyncNodeName, "bar", "default")})
+	lock.Lock()
+	registersBefore := len(registers)
+	lock.Unlock()
+
+	retry.Run(t, func(r *retry.R) {
+		lock.Lock()
+		defer lock.Unlock()
+		require.GreaterOrEqual(r, len(registers), registersBefore+3)
+	})
+	lock.Lock()
+	defer lock.Unlock()
+	require.Nil(t, registers[len(registers)-1].Check)
+	// The check is only deregistered once, not by every periodic sync.
+	require.Len(t, deregisters, 1)
+	require.Equal(t, ConsulSyncNodeName, deregisters[0].Node)
+	require.Equal(t, readinessCheckID(withCheck.Service.ID), deregisters[0].CheckID)
+	require.Empty(t, deregisters[0].ServiceID)
+}
+
 func testRegistration(node, service, k8sSrcNamespace string) *api.CatalogRegistration {
 	return &api.CatalogRegistration{
 		Node:           node,
@@ -282,6 +727,15 @@ func testRegistration(node, service, k8sSrcNamespace string) *api.CatalogRegistr
 	}
 }
 
+// testK8SNodeRegistration returns the registration of a service on the
+// Consul node of a Kubernetes node.
+func testK8SNodeRegistration(node, service string) *api.CatalogRegistration {
+	r := testRegistration(node, service, "default")
+	r.NodeMeta[ConsulK8SSyncNode] = ConsulSyncNodeName
+	r.SkipNodeUpdate = false
+	return r
+}
+
 func testConsulSyncer(client *api.Client) (*ConsulSyncer, func()) {
 	return testConsulSyncerWithConfig(client, func(syncer *ConsulSyncer) {})
 }
@@ -0,0 +1,342 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul-k8s/control-plane/catalog/metrics"
+	"github.com/hashicorp/consul/api"
+	apiv1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// servicePortName is the name of the port of services with endpoints.
+	// EndpointSlice ports have the same name so that instances of a Consul
+	// service can listen on different ports.
+	servicePortName = "tcp"
+
+	// endpointSliceManagedBy is the value of the managed-by label of the
+	// EndpointSlices written by the sink. It keeps the Kubernetes
+	// EndpointSlice controller from touching them.
+	endpointSliceManagedBy = "consul-k8s-sync-catalog"
+)
+
+// consulInstances holds the instances of a Consul service.
+type consulInstances struct {
+	// Port is the port of the Kube service. It's the lowest port of all
+	// instances, or zero if the Consul service has no instances.
+	Port int32
+
+	// Endpoints are the healthy instances of the service.
+	Endpoints []endpoint
+}
+
+// endpoint is the address and port of a healthy Consul service instance.
+type endpoint struct {
+	Address     string
+	Port        int32
+	AddressType discoveryv1.AddressType
+}
+
+// serviceWatch is a running watch of the instances of a Consul service.
+type serviceWatch struct {
+	name      string
+	namespace string
+	partition string
+	cancel    context.CancelFunc
+}
+
+// updateWatches starts watches for new source services and stops the
+// watches of the services that were removed. lock must be held.
+func (s *K8SSink) updateWatches() {
+	if s.watches == nil {
+		s.watches = make(map[string]*serviceWatch)
+	}
+	if s.serviceInstances == nil {
+		s.serviceInstances = make(map[string]*consulInstances)
+	}
+
+	for key, w := range s.watches {
+		svc, ok := s.sourceServices[key]
+		if ok && svc.Name == w.name && svc.Namespace == w.namespace && svc.Partition == w.partition {
+			continue
+		}
+
+		w.cancel()
+		delete(s.watches, key)
+		delete(s.serviceInstances, key)
+	}
+
+	for key, svc := range s.sourceServices {
+		if _, ok := s.watches[key]; ok {
+			continue
+		}
+
+		ctx, cancel := context.WithCancel(s.Ctx)
+		w := &serviceWatch{
+			name:      svc.Name,
+			namespace: svc.Namespace,
+			partition: svc.Partition,
+			cancel:    cancel,
+		}
+		s.watches[key] = w
+		go s.watchService(ctx, key, w)
+	}
+}
+
+// watchService watches the instances of a Consul service with blocking
+// queries and stores them until ctx is cancelled.
+func (s *K8SSink) watchService(ctx context.Context, key string, w *serviceWatch) {
+	opts := (&api.QueryOptions{
+		AllowStale: true,
+		WaitIndex:  1,
+		WaitTime:   1 * time.Minute,
+		Namespace:  w.namespace,
+		Partition:  w.partition,
+	}).WithContext(ctx)
+	for {
+		var entries []*api.ServiceEntry
+		var meta *api.QueryMeta
+		err := backoff.Retry(func() error {
+			var err error
+			entries, meta, err = s.ConsulClient.Health().Service(w.name, "", false, opts)
+			if err != nil && ctx.Err() == nil {
+				s.Metrics.ConsulError(metrics.DirectionToK8S, metrics.OpHealthService, w.namespace)
+			}
+			return err
+		}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
+
+		// If the context is ended, then we end
+		if ctx.Err() != nil {
+			return
+		}
+
+		// If there was an error, handle that
+		if err != nil {
+			s.Log.Warn("error querying service instances, will retry", "name", w.name, "err", err)
+			s.Metrics.WatchRestarted(metrics.DirectionToK8S, metrics.WatchServiceInstances, w.namespace)
+			continue
+		}
+
+		// Update our blocking index. If the index went backwards, e.g.
+		// because the Consul servers were restored from a snapshot, start
+		// over so that we don't wait for an index that may never come.
+		if meta.LastIndex < opts.WaitIndex {
+			opts.WaitIndex = 1
+		} else {
+			opts.WaitIndex = meta.LastIndex
+		}
+
+		s.setServiceInstances(key, w, entries)
+	}
+}
+
+// setServiceInstances stores the instances of the Consul service of a watch
+// and triggers a sync.
+func (s *K8SSink) setServiceInstances(key string, w *serviceWatch, entries []*api.ServiceEntry) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Ignore the result if the watch was stopped in the meantime.
+	if s.watches[key] != w {
+		return
+	}
+
+	instances := &consulInstances{}
+	for _, entry := range entries {
+		port := int32(entry.Service.Port)
+		if port == 0 {
+			continue
+		}
+		if instances.Port == 0 || port < instances.Port {
+			instances.Port = port
+		}
+
+		if entry.Checks.AggregatedStatus() != api.HealthPassing {
+			continue
+		}
+
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		ip := net.ParseIP(address)
+		if ip == nil {
+			s.Log.Debug("skipping service instance without an IP address",
+				"name", w.name, "id", entry.Service.ID, "address", address)
+			continue
+		}
+
+		addressType := discoveryv1.AddressTypeIPv4
+		if ip.To4() == nil {
+			addressType = discoveryv1.AddressTypeIPv6
+		}
+		instances.Endpoints = append(instances.Endpoints, endpoint{
+			Address:     ip.String(),
+			Port:        port,
+			AddressType: addressType,
+		})
+	}
+
+	sort.Slice(instances.Endpoints, func(i, j int) bool {
+		return instances.Endpoints[i].Address < instances.Endpoints[j].Address
+	})
+
+	if reflect.DeepEqual(s.serviceInstances[key], instances) {
+		return
+	}
+
+	s.serviceInstances[key] = instances
+	s.Log.Debug("received service instances from Consul", "name", w.name, "count", len(instances.Endpoints))
+	s.trigger()
+}
+
+// desiredEndpointSlices returns the EndpointSlices that should exist, keyed
+// in the form <kube namespace>/<slice name>. EndpointSlices are only written
+// for Kube services that exist and are not about to be deleted. lock must be
+// held.
+func (s *K8SSink) desiredEndpointSlices(deletedServices []string) map[string]*discoveryv1.EndpointSlice {
+	deleted := make(map[string]struct{}, len(deletedServices))
+	for _, key := range deletedServices {
+		deleted[key] = struct{}{}
+	}
+
+	desired := make(map[string]*discoveryv1.EndpointSlice)
+	for key, svc := range s.serviceMapConsul {
+		if _, ok := deleted[key]; ok {
+			continue
+		}
+		instances, ok := s.serviceInstances[key]
+		if !ok {
+			continue
+		}
+
+		for _, slice := range endpointSlices(svc.Name, instances) {
+			slice.Namespace = svc.Namespace
+			slice.OwnerReferences = []metav1.OwnerReference{
+				{
+					APIVersion: "v1",
+					Kind:       "Service",
+					Name:       svc.Name,
+					UID:        svc.UID,
+				},
+			}
+			desired[serviceKey(slice.Namespace, slice.Name)] = slice
+		}
+	}
+	return desired
+}
+
+// endpointSliceCrudList returns the EndpointSlices to create, update, and
+// delete (respectively) so that the EndpointSlices written by the sink match
+// the desired ones. EndpointSlices to delete are returned as keys in the form
+// <kube namespace>/<slice name>. It lists the EndpointSlices from Kubernetes,
+// so lock must not be held.
+func (s *K8SSink) endpointSliceCrudList(desired map[string]*discoveryv1.EndpointSlice) ([]*discoveryv1.EndpointSlice, []*discoveryv1.EndpointSlice, []string, error) {
+	var create, update []*discoveryv1.EndpointSlice
+	var remove []string
+
+	list, err := s.Client.DiscoveryV1().EndpointSlices(s.watchNamespace()).List(s.Ctx, metav1.ListOptions{
+		LabelSelector: labels.SelectorFromSet(labels.Set{discoveryv1.LabelManagedBy: endpointSliceManagedBy}).String(),
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for i := range list.Items {
+		existing := &list.Items[i]
+		key := serviceKey(existing.Namespace, existing.Name)
+		slice, ok := desired[key]
+		if !ok {
+			remove = append(remove, key)
+			continue
+		}
+		delete(desired, key)
+
+		// The address type is part of the name so it always matches.
+		if reflect.DeepEqual(existing.Endpoints, slice.Endpoints) &&
+			reflect.DeepEqual(existing.Ports, slice.Ports) &&
+			reflect.DeepEqual(existing.Labels, slice.Labels) {
+			continue
+		}
+
+		existing.Labels = slice.Labels
+		existing.OwnerReferences = slice.OwnerReferences
+		existing.Endpoints = slice.Endpoints
+		existing.Ports = slice.Ports
+		update = append(update, existing)
+	}
+
+	for _, slice := range desired {
+		create = append(create, slice)
+	}
+
+	return create, update, remove, nil
+}
+
+// endpointSlices returns the EndpointSlices of a Kube service. The instances
+// are grouped into one EndpointSlice per address type and port.
+func endpointSlices(name string, instances *consulInstances) []*discoveryv1.EndpointSlice {
+	slices := make(map[string]*discoveryv1.EndpointSlice)
+	var names []string
+	for _, e := range instances.Endpoints {
+		sliceName := fmt.Sprintf("%s-%s-%d", name, strings.ToLower(string(e.AddressType)), e.Port)
+		slice, ok := slices[sliceName]
+		if !ok {
+			portName := servicePortName
+			protocol := apiv1.ProtocolTCP
+			port := e.Port
+			slice = &discoveryv1.EndpointSlice{
+				ObjectMeta: metav1.ObjectMeta{
+					Name: sliceName,
+					Labels: map[string]string{
+						"consul":                     "true",
+						discoveryv1.LabelServiceName: name,
+						discoveryv1.LabelManagedBy:   endpointSliceManagedBy,
+					},
+				},
+				AddressType: e.AddressType,
+				Ports: []discoveryv1.EndpointPort{
+					{
+						Name:     &portName,
+						Protocol: &protocol,
+						Port:     &port,
+					},
+				},
+			}
+			slices[sliceName] = slice
+			names = append(names, sliceName)
+		}
+
+		ready := true
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{e.Address},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+		})
+	}
+
+	sort.Strings(names)
+	result := make([]*discoveryv1.EndpointSlice, 0, len(names))
+	for _, n := range names {
+		result = append(result, slices[n])
+	}
+	return result
+}
+
+// consulServiceName returns the name of the Consul service from its Consul
+// DNS entry, e.g. foo.service.consul => foo.
+func consulServiceName(consulDNS string) string {
+	if i := strings.LastIndex(consulDNS, ".service."); i != -1 {
+		return consulDNS[:i]
+	}
+	return consulDNS
+}
@@ -0,0 +1,389 @@
+package catalog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/helper/controller"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Test that ClusterIP and headless services are created with EndpointSlices
+// holding the healthy Consul service instances.
+func TestK8SSink_endpoints(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		serviceType  ServiceSyncType
+		expClusterIP string
+	}{
+		"ClusterIP": {
+			serviceType:  ClusterIP,
+			expClusterIP: "",
+		},
+		"Headless": {
+			serviceType:  Headless,
+			expClusterIP: apiv1.ClusterIPNone,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client := fake.NewSimpleClientset()
+			consul := newTestConsulHealth(t)
+			consul.set("web", []*api.ServiceEntry{
+				testServiceEntry("web-1", "10.0.0.1", 8080, api.HealthPassing),
+				testServiceEntry("web-2", "10.0.0.2", 9090, api.HealthPassing),
+				testServiceEntry("web-3", "10.0.0.3", 8080, api.HealthCritical),
+				testServiceEntry("web-4", "fd00::4", 8080, api.HealthPassing),
+			})
+
+			sink, closer := testEndpointsSink(t, client, consul.client, c.serviceType)
+			defer closer()
+
+			sink.SetServices(map[string]string{"web": "web.service.consul"})
+
+			retry.Run(t, func(r *retry.R) {
+				svc, err := client.CoreV1().Services(metav1.NamespaceDefault).Get(context.Background(), "web", metav1.GetOptions{})
+				require.NoError(r, err)
+				require.Equal(r, apiv1.ServiceTypeClusterIP, svc.Spec.Type)
+				require.Equal(r, c.expClusterIP, svc.Spec.ClusterIP)
+				require.Len(r, svc.Spec.Ports, 1)
+				require.Equal(r, "tcp", svc.Spec.Ports[0].Name)
+				require.Equal(r, int32(8080), svc.Spec.Ports[0].Port)
+
+				slices := testEndpointSlices(r, client)
+				require.Len(r, slices, 3)
+				require.Equal(r, []string{"10.0.0.1"}, slices["web-ipv4-8080"])
+				require.Equal(r, []string{"10.0.0.2"}, slices["web-ipv4-9090"])
+				require.Equal(r, []string{"fd00::4"}, slices["web-ipv6-8080"])
+			})
+
+			slice, err := client.DiscoveryV1().EndpointSlices(metav1.NamespaceDefault).Get(context.Background(), "web-ipv4-9090", metav1.GetOptions{})
+			require.NoError(t, err)
+			require.Equal(t, "web", slice.Labels[discoveryv1.LabelServiceName])
+			require.Equal(t, endpointSliceManagedBy, slice.Labels[discoveryv1.LabelManagedBy])
+			require.Equal(t, discoveryv1.AddressTypeIPv4, slice.AddressType)
+			require.Equal(t, "tcp", *slice.Ports[0].Name)
+			require.Equal(t, int32(9090), *slice.Ports[0].Port)
+			require.Equal(t, "web", slice.OwnerReferences[0].Name)
+		})
+	}
+}
+
+// Test that EndpointSlices follow changes of the Consul service instances and
+// are deleted with the service.
+func TestK8SSink_endpointsUpdate(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	consul := newTestConsulHealth(t)
+	consul.set("web", []*api.ServiceEntry{
+		testServiceEntry("web-1", "10.0.0.1", 8080, api.HealthPassing),
+	})
+
+	sink, closer := testEndpointsSink(t, client, consul.client, ClusterIP)
+	defer closer()
+
+	sink.SetServices(map[string]string{"web": "web.service.consul"})
+
+	retry.Run(t, func(r *retry.R) {
+		slices := testEndpointSlices(r, client)
+		require.Equal(r, map[string][]string{"web-ipv4-8080": {"10.0.0.1"}}, slices)
+	})
+
+	// The first instance becomes unhealthy and a new one is registered.
+	consul.set("web", []*api.ServiceEntry{
+		testServiceEntry("web-1", "10.0.0.1", 8080, api.HealthCritical),
+		testServiceEntry("web-2", "10.0.0.2", 8080, api.HealthPassing),
+		testServiceEntry("web-3", "10.0.0.3", 8080, api.HealthPassing),
+	})
+
+	retry.Run(t, func(r *retry.R) {
+		slices := testEndpointSlices(r, client)
+		require.Equal(r, map[string][]string{"web-ipv4-8080": {"10.0.0.2", "10.0.0.3"}}, slices)
+	})
+
+	// The instances move to another port.
+	consul.set("web", []*api.ServiceEntry{
+		testServiceEntry("web-2", "10.0.0.2", 9090, api.HealthPassing),
+	})
+
+	retry.Run(t, func(r *retry.R) {
+		svc, err := client.CoreV1().Services(metav1.NamespaceDefault).Get(context.Background(), "web", metav1.GetOptions{})
+		require.NoError(r, err)
+		require.Equal(r, int32(9090), svc.Spec.Ports[0].Port)
+
+		slices := testEndpointSlices(r, client)
+		require.Equal(r, map[string][]string{"web-ipv4-9090": {"10.0.0.2"}}, slices)
+	})
+
+	// The service is removed from Consul.
+	sink.SetServices(map[string]string{})
+
+	retry.Run(t, func(r *retry.R) {
+		list, err := client.CoreV1().Services(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+		require.NoError(r, err)
+		require.Empty(r, list.Items)
+		require.Empty(r, testEndpointSlices(r, client))
+	})
+}
+
+// Test that a headless service is recreated when the sink switches to
+// ClusterIP since the cluster IP of a service can't be changed.
+func TestK8SSink_endpointsHeadlessToClusterIP(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	consul := newTestConsulHealth(t)
+	consul.set("web", []*api.ServiceEntry{
+		testServiceEntry("web-1", "10.0.0.1", 8080, api.HealthPassing),
+	})
+
+	svc := newService(serviceKey(metav1.NamespaceDefault, "web"), apiv1.ServiceSpec{
+		Type:      apiv1.ServiceTypeClusterIP,
+		ClusterIP: apiv1.ClusterIPNone,
+		Ports:     []apiv1.ServicePort{{Name: "tcp", Port: 8080}},
+	})
+	_, err := client.CoreV1().Services(metav1.NamespaceDefault).Create(context.Background(), svc, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	sink, closer := testEndpointsSink(t, client, consul.client, ClusterIP)
+	defer closer()
+
+	sink.SetServices(map[string]string{"web": "web.service.consul"})
+
+	retry.Run(t, func(r *retry.R) {
+		svc, err := client.CoreV1().Services(metav1.NamespaceDefault).Get(context.Background(), "web", metav1.GetOptions{})
+		require.NoError(r, err)
+		require.NotEqual(r, apiv1.ClusterIPNone, svc.Spec.ClusterIP)
+	})
+}
+
+// Test that EndpointSlices are listed without holding the lock of the sink so
+// that the watches of the Consul services aren't blocked on Kubernetes.
+func TestK8SSink_endpointsListedWithoutLock(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	consul := newTestConsulHealth(t)
+	consul.set("web", []*api.ServiceEntry{
+		testServiceEntry("web-1", "10.0.0.1", 8080, api.HealthPassing),
+	})
+
+	// The first list is blocked until the test has acquired the lock of the
+	// sink, which it can't if the lock is held while listing.
+	listing := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	client.PrependReactor("list", "endpointslices", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		once.Do(func() {
+			close(listing)
+			<-release
+		})
+		return false, nil, nil
+	})
+
+	sink, closer := testEndpointsSink(t, client, consul.client, ClusterIP)
+	defer closer()
+
+	sink.SetServices(map[string]string{"web": "web.service.consul"})
+
+	select {
+	case <-listing:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the EndpointSlices to be listed")
+	}
+	locked := make(chan struct{})
+	go func() {
+		sink.lock.Lock()
+		sink.lock.Unlock()
+		close(locked)
+	}()
+	select {
+	case <-locked:
+		close(release)
+	case <-time.After(5 * time.Second):
+		close(release)
+		t.Fatal("the lock of the sink is held while listing the EndpointSlices")
+	}
+
+	retry.Run(t, func(r *retry.R) {
+		slices := testEndpointSlices(r, client)
+		require.Equal(r, map[string][]string{"web-ipv4-8080": {"10.0.0.1"}}, slices)
+	})
+}
+
+func TestConsulServiceName(t *testing.T) {
+	cases := map[string]string{
+		"web.service.consul":          "web",
+		"web.service.dc1.consul":      "web",
+		"web":                         "web",
+		"a.service.b.service.consul.": "a.service.b",
+	}
+	for dns, exp := range cases {
+		require.Equal(t, exp, consulServiceName(dns), dns)
+	}
+}
+
+func TestServiceSpecMatches(t *testing.T) {
+	ports := []apiv1.ServicePort{{Name: "tcp", Port: 8080}}
+	cases := map[string]struct {
+		existing apiv1.ServiceSpec
+		desired  apiv1.ServiceSpec
+		exp      bool
+	}{
+		"allocated cluster IP": {
+			existing: apiv1.ServiceSpec{Type: apiv1.ServiceTypeClusterIP, ClusterIP: "10.96.0.10", Ports: ports},
+			desired:  apiv1.ServiceSpec{Type: apiv1.ServiceTypeClusterIP, Ports: ports},
+			exp:      true,
+		},
+		"headless to cluster IP": {
+			existing: apiv1.ServiceSpec{Type: apiv1.ServiceTypeClusterIP, ClusterIP: apiv1.ClusterIPNone, Ports: ports},
+			desired:  apiv1.ServiceSpec{Type: apiv1.ServiceTypeClusterIP, Ports: ports},
+			exp:      false,
+		},
+		"different port": {
+			existing: apiv1.ServiceSpec{Type: apiv1.ServiceTypeClusterIP, Ports: ports},
+			desired:  apiv1.ServiceSpec{Type: apiv1.ServiceTypeClusterIP, Ports: []apiv1.ServicePort{{Name: "tcp", Port: 9090}}},
+			exp:      false,
+		},
+		"external name to cluster IP": {
+			existing: apiv1.ServiceSpec{Type: apiv1.ServiceTypeExternalName, ExternalName: "web.service.consul"},
+			desired:  apiv1.ServiceSpec{Type: apiv1.ServiceTypeClusterIP, Ports: ports},
+			exp:      false,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.exp, serviceSpecMatches(c.existing, c.desired))
+		})
+	}
+}
+
+// testConsulHealth is a fake Consul health API that supports blocking
+// queries.
+type testConsulHealth struct {
+	client *api.Client
+
+	lock     sync.Mutex
+	index    uint64
+	services map[string][]*api.ServiceEntry
+	changed  chan struct{}
+}
+
+func newTestConsulHealth(t *testing.T) *testConsulHealth {
+	h := &testConsulHealth{
+		index:    1,
+		services: make(map[string][]*api.ServiceEntry),
+		changed:  make(chan struct{}),
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+		waitIndex, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+
+		h.lock.Lock()
+		for h.index <= waitIndex {
+			changed := h.changed
+			h.lock.Unlock()
+			select {
+			case <-changed:
+			case <-r.Context().Done():
+				return
+			}
+			h.lock.Lock()
+		}
+		entries := h.services[name]
+		index := h.index
+		h.lock.Unlock()
+
+		if entries == nil {
+			entries = []*api.ServiceEntry{}
+		}
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+		require.NoError(t, json.NewEncoder(w).Encode(entries))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	require.NoError(t, err)
+	h.client = client
+	return h
+}
+
+// set sets the instances of a service and unblocks the queries.
+func (h *testConsulHealth) set(name string, entries []*api.ServiceEntry) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.services[name] = entries
+	h.index++
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+func testServiceEntry(id, address string, port int, status string) *api.ServiceEntry {
+	return &api.ServiceEntry{
+		Node: &api.Node{Node: "node", Address: "127.0.0.1"},
+		Service: &api.AgentService{
+			ID:      id,
+			Service: "web",
+			Address: address,
+			Port:    port,
+		},
+		Checks: api.HealthChecks{
+			{CheckID: "check", Status: status},
+		},
+	}
+}
+
+// testEndpointSlices returns the addresses of the EndpointSlices written by
+// the sink keyed by EndpointSlice name.
+func testEndpointSlices(r *retry.R, client kubernetes.Interface) map[string][]string {
+	list, err := client.DiscoveryV1().EndpointSlices(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+	require.NoError(r, err)
+
+	slices := make(map[string][]string)
+	for _, slice := range list.Items {
+		var addresses []string
+		for _, e := range slice.Endpoints {
+			addresses = append(addresses, e.Addresses...)
+			require.True(r, *e.Conditions.Ready)
+		}
+		slices[slice.Name] = addresses
+	}
+	return slices
+}
+
+func testEndpointsSink(t *testing.T, client kubernetes.Interface, consulClient *api.Client, serviceType ServiceSyncType) (*K8SSink, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sink := &K8SSink{
+		Client:       client,
+		Log:          hclog.Default(),
+		Ctx:          ctx,
+		ServiceType:  serviceType,
+		ConsulClient: consulClient,
+	}
+
+	closer := controller.TestControllerRun(sink)
+	return sink, func() {
+		closer()
+		cancel()
+	}
+}
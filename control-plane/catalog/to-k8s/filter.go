@@ -0,0 +1,110 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+
+	"github.com/hashicorp/go-hclog"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// FilterConfigMap watches a ConfigMap that holds a filter expression in
+// Consul's filtering syntax and sets it on the Source.
+//
+// FilterConfigMap implements controller.Resource and is meant to run as a K8S
+// controller that watches the ConfigMap.
+type FilterConfigMap struct {
+	Client    kubernetes.Interface // Client is the K8S API client
+	Namespace string               // Namespace is the namespace of the ConfigMap
+	Name      string               // Name is the name of the ConfigMap
+	Key       string               // Key is the key of the filter in the ConfigMap
+	Source    *Source              // Source is the source to set the filter on
+	Log       hclog.Logger         // Logger
+
+	// Ctx is used to cancel the FilterConfigMap.
+	Ctx context.Context
+}
+
+// Load reads the filter from the ConfigMap and sets it on the Source. The
+// filter is empty if the ConfigMap doesn't exist. It should be called before
+// the Source is run so that services aren't synced without the filter, and
+// it returns an error if Consul rejects the filter for the same reason.
+func (f *FilterConfigMap) Load() error {
+	configMap, err := f.Client.CoreV1().ConfigMaps(f.Namespace).Get(f.Ctx, f.Name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return f.Source.SetFilter("")
+	}
+	if err != nil {
+		return err
+	}
+
+	filter := configMap.Data[f.Key]
+	if err := f.Source.CheckFilter(f.Ctx, filter); err != nil {
+		return err
+	}
+	return f.Source.SetFilter(filter)
+}
+
+// Informer implements the controller.Resource interface.
+// It tells Kubernetes that we want to watch for changes to the ConfigMap.
+func (f *FilterConfigMap) Informer() cache.SharedIndexInformer {
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", f.Name).String()
+	return cache.NewSharedIndexInformer(
+		&cache.ListWatch{
+			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+				options.FieldSelector = fieldSelector
+				return f.Client.CoreV1().ConfigMaps(f.Namespace).List(f.Ctx, options)
+			},
+
+			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+				options.FieldSelector = fieldSelector
+				return f.Client.CoreV1().ConfigMaps(f.Namespace).Watch(f.Ctx, options)
+			},
+		},
+		&apiv1.ConfigMap{},
+		0,
+		cache.Indexers{},
+	)
+}
+
+// Upsert implements the controller.Resource interface.
+func (f *FilterConfigMap) Upsert(key string, raw interface{}) error {
+	configMap, ok := raw.(*apiv1.ConfigMap)
+	if !ok {
+		f.Log.Warn("upsert got invalid type", "raw", raw)
+		return nil
+	}
+
+	filter := configMap.Data[f.Key]
+	if err := f.Source.CheckFilter(f.Ctx, filter); err != nil {
+		if !errors.Is(err, errInvalidFilter) {
+			// Consul couldn't be queried, so check the filter again later.
+			return err
+		}
+		// Retrying won't fix the expression, so keep the previous filter
+		// until the ConfigMap is changed again.
+		f.Log.Error("ignoring invalid filter from ConfigMap, keeping the previous filter", "key", key, "err", err)
+		return nil
+	}
+	if err := f.Source.SetFilter(filter); err != nil {
+		return err
+	}
+	f.Log.Info("setting filter from ConfigMap", "key", key, "filter", filter)
+	return nil
+}
+
+// Delete implements the controller.Resource interface. The filter is kept so
+// that the services it excludes aren't synced because the ConfigMap was
+// deleted by mistake. It's removed by setting an empty filter in the
+// ConfigMap.
+func (f *FilterConfigMap) Delete(key string, _ interface{}) error {
+	f.Log.Warn("ConfigMap deleted, keeping the last filter until the ConfigMap is created again", "key", key)
+	return nil
+}
@@ -0,0 +1,182 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/control-plane/helper/controller"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFilterConfigMap_impl(t *testing.T) {
+	var _ controller.Resource = &FilterConfigMap{}
+}
+
+// Test that the filter of the Source follows the ConfigMap.
+func TestFilterConfigMap(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	source := &Source{Client: newTestConsulCatalog(t)}
+	filterConfigMap := &FilterConfigMap{
+		Client:    client,
+		Namespace: metav1.NamespaceDefault,
+		Name:      "sync-filter",
+		Key:       "filter",
+		Source:    source,
+		Log:       hclog.Default(),
+		Ctx:       context.Background(),
+	}
+
+	// A missing ConfigMap is an empty filter.
+	require.NoError(t, filterConfigMap.Load())
+	filter, _ := source.filter()
+	require.Equal(t, "", filter)
+
+	configMap := &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "sync-filter", Namespace: metav1.NamespaceDefault},
+		Data:       map[string]string{"filter": `ServiceMeta.team == "a"`},
+	}
+	_, err := client.CoreV1().ConfigMaps(metav1.NamespaceDefault).Create(context.Background(), configMap, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	require.NoError(t, filterConfigMap.Load())
+	filter, filterCh := source.filter()
+	require.Equal(t, `ServiceMeta.team == "a"`, filter)
+
+	closer := controller.TestControllerRun(filterConfigMap)
+	defer closer()
+
+	// Update the ConfigMap
+	configMap.Data["filter"] = `ServiceMeta.team == "b"`
+	_, err = client.CoreV1().ConfigMaps(metav1.NamespaceDefault).Update(context.Background(), configMap, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	retry.Run(t, func(r *retry.R) {
+		filter, _ := source.filter()
+		require.Equal(r, `ServiceMeta.team == "b"`, filter)
+	})
+
+	// The previous filter channel is closed on change.
+	select {
+	case <-filterCh:
+	default:
+		t.Fatal("filter channel not closed")
+	}
+
+	// Delete the ConfigMap. The filter is kept so that services aren't
+	// synced because the ConfigMap is gone.
+	_, filterCh = source.filter()
+	require.NoError(t, filterConfigMap.Delete("default/sync-filter", configMap))
+	filter, _ = source.filter()
+	require.Equal(t, `ServiceMeta.team == "b"`, filter)
+	select {
+	case <-filterCh:
+		t.Fatal("filter channel closed on delete")
+	default:
+	}
+}
+
+// Test that an invalid filter in the ConfigMap is not set on the Source.
+func TestFilterConfigMap_invalidFilter(t *testing.T) {
+	t.Parallel()
+	configMap := &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "sync-filter", Namespace: metav1.NamespaceDefault},
+		Data:       map[string]string{"filter": `ServiceMeta.team == "a"`},
+	}
+	client := fake.NewSimpleClientset(configMap)
+	consulClient := newTestConsulCatalog(t)
+	source := &Source{Client: consulClient}
+	filterConfigMap := &FilterConfigMap{
+		Client:    client,
+		Namespace: metav1.NamespaceDefault,
+		Name:      "sync-filter",
+		Key:       "filter",
+		Source:    source,
+		Log:       hclog.Default(),
+		Ctx:       context.Background(),
+	}
+	require.NoError(t, filterConfigMap.Load())
+
+	// The previous filter is kept when the ConfigMap changes to an invalid
+	// filter.
+	invalid := configMap.DeepCopy()
+	invalid.Data["filter"] = `ServiceMeta.team ==`
+	require.NoError(t, filterConfigMap.Upsert("default/sync-filter", invalid))
+	filter, _ := source.filter()
+	require.Equal(t, `ServiceMeta.team == "a"`, filter)
+
+	// The previous filter is also kept when Consul rejects the filter.
+	rejected := configMap.DeepCopy()
+	rejected.Data["filter"] = `ServiceMetadata.team == "b"`
+	require.NoError(t, filterConfigMap.Upsert("default/sync-filter", rejected))
+	filter, _ = source.filter()
+	require.Equal(t, `ServiceMeta.team == "a"`, filter)
+
+	// Loading an invalid filter is an error so that services aren't synced
+	// without the filter.
+	_, err := client.CoreV1().ConfigMaps(metav1.NamespaceDefault).Update(context.Background(), invalid, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	require.Error(t, (&FilterConfigMap{
+		Client:    client,
+		Namespace: metav1.NamespaceDefault,
+		Name:      "sync-filter",
+		Key:       "filter",
+		Source:    &Source{Client: consulClient},
+		Log:       hclog.Default(),
+		Ctx:       context.Background(),
+	}).Load())
+}
+
+// Test that the filter is checked again when Consul can't be queried.
+func TestFilterConfigMap_consulUnavailable(t *testing.T) {
+	t.Parallel()
+	consulServer := httptest.NewServer(http.NotFoundHandler())
+	consulServer.Close()
+	consulClient, err := api.NewClient(&api.Config{Address: consulServer.URL})
+	require.NoError(t, err)
+
+	source := &Source{Client: consulClient}
+	filterConfigMap := &FilterConfigMap{
+		Source: source,
+		Key:    "filter",
+		Log:    hclog.Default(),
+		Ctx:    context.Background(),
+	}
+	require.Error(t, filterConfigMap.Upsert("default/sync-filter", &apiv1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "sync-filter", Namespace: metav1.NamespaceDefault},
+		Data:       map[string]string{"filter": `ServiceMeta.team == "a"`},
+	}))
+	filter, _ := source.filter()
+	require.Equal(t, "", filter)
+}
+
+// newTestConsulCatalog returns a client of a Consul server that lists no
+// services and rejects filters with the ServiceMetadata selector, which
+// doesn't exist.
+func newTestConsulCatalog(t *testing.T) *api.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/catalog/services", r.URL.Path)
+		filter := r.URL.Query().Get("filter")
+		if strings.Contains(filter, "ServiceMetadata") {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "Failed to create boolean expression evaluator: Selector %q is not valid", "ServiceMetadata")
+			return
+		}
+		w.Write([]byte("{}"))
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := api.NewClient(&api.Config{Address: server.URL})
+	require.NoError(t, err)
+	return client
+}
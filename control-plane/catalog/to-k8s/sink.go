@@ -2,13 +2,17 @@ package catalog
 
 import (
 	"context"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/hashicorp/consul-k8s/control-plane/catalog/metrics"
 	"github.com/hashicorp/consul-k8s/control-plane/helper/coalesce"
+	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 	apiv1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/watch"
@@ -25,6 +29,24 @@ const (
 	K8SMaxPeriod = 5 * time.Second
 )
 
+// ServiceSyncType defines the type of Kubernetes services that are created
+// for Consul services.
+type ServiceSyncType string
+
+const (
+	// ExternalName creates services of type ExternalName that point at the
+	// Consul DNS entry of the Consul service.
+	ExternalName ServiceSyncType = "ExternalName"
+
+	// ClusterIP creates selector-less services of type ClusterIP whose
+	// EndpointSlices hold the addresses of the healthy Consul service instances.
+	ClusterIP ServiceSyncType = "ClusterIP"
+
+	// Headless is like ClusterIP but creates headless services so that
+	// cluster DNS resolves directly to the Consul service instances.
+	Headless ServiceSyncType = "Headless"
+)
+
 // Sink is the destination where services are registered.
 //
 // While in practice we only have one sink (K8S), the interface abstraction
@@ -34,6 +56,26 @@ type Sink interface {
 	// The key is the service name and the destination is the external DNS
 	// entry to point to.
 	SetServices(map[string]string)
+
+	// SetConsulServices is called with the services that should be created
+	// when services are read from Consul namespaces or partitions.
+	SetConsulServices([]ConsulService)
+}
+
+// ConsulService is a Consul service that should be created in Kubernetes.
+type ConsulService struct {
+	// K8SName is the name of the Kube service.
+	K8SName string
+
+	// Name, Namespace and Partition identify the Consul service. Namespace
+	// and Partition are empty for the namespace and partition of the Consul
+	// client.
+	Name      string
+	Namespace string
+	Partition string
+
+	// DNS is the Consul DNS entry of the service.
+	DNS string
 }
 
 // K8SSink is a Sink implementation that registers services with Kubernetes.
@@ -54,51 +96,132 @@ type K8SSink struct {
 	// Ctx is used to cancel the Sink.
 	Ctx context.Context
 
+	// ServiceType is the type of Kubernetes service created for each Consul
+	// service. Defaults to ExternalName. For ClusterIP and Headless, the sink
+	// watches the instances of each Consul service with ConsulClient and
+	// writes the healthy ones to EndpointSlices of the service.
+	ServiceType ServiceSyncType
+
+	// ConsulClient is the Consul API client used to watch service instances.
+	// It is only used if ServiceType is ClusterIP or Headless.
+	ConsulClient *api.Client
+
+	// EnableNamespaces writes the services of each Consul namespace to the
+	// Kube namespace it maps to, as set by K8SNamespaceMapping and
+	// EnableConsulNSMirroring. Services of namespaces that don't map to a
+	// Kube namespace are written to Namespace.
+	EnableNamespaces bool
+
+	// K8SNamespaceMapping maps Consul namespaces to Kube namespaces. Keys
+	// are either a Consul namespace, or an admin partition and a namespace
+	// separated by a slash, e.g. ap1/ns1. The latter take precedence. The
+	// mapping takes precedence over mirroring.
+	K8SNamespaceMapping map[string]string
+
+	// EnableConsulNSMirroring writes services to the Kube namespace with the
+	// name of their Consul namespace prefixed with ConsulNSMirroringPrefix.
+	EnableConsulNSMirroring bool
+	ConsulNSMirroringPrefix string
+
+	// Metrics records the metrics of the sync if set.
+	Metrics *metrics.Metrics
+
 	// lock gates concurrent access to all the maps.
 	lock sync.Mutex
 
 	// sourceServices holds Consul services that should be synced to Kube.
-	// It maps from Consul service names to Consul DNS entry, e.g.
-	// foo => foo.service.consul. It's populated from the Consul API.
-	// We lowercase the Consul service names and DNS entries
-	// because Kube names must be lowercase.
-	sourceServices map[string]string
+	// It maps from Kube service keys to Consul services. Service keys are
+	// in the form <kube namespace>/<kube svc name>, e.g. default/foo. It's
+	// populated from the Consul API. We lowercase the Kube service names and
+	// DNS entries because Kube names must be lowercase.
+	sourceServices map[string]ConsulService
 
-	// keyToName maps from Kube controller keys to Kube service names.
+	// keyToName maps from Kube controller keys to Kube service keys.
 	// Controller keys are in the form <kube namespace>/<kube svc name>
 	// e.g. default/foo, and are the keys Kube uses to inform that something
 	// changed.
 	keyToName map[string]string
 
-	// serviceMap holds all Kubernetes service names in the namespaces
-	// we're watching. The keys are Kubernetes service names and there are no
+	// serviceMap holds all Kubernetes services in the namespaces we're
+	// watching. The keys are Kubernetes service keys and there are no
 	// values.
 	serviceMap map[string]struct{}
 
 	// serviceMapConsul is a subset of serviceMap. It holds all Kube services
-	// that were created by this sync process. Keys are Kube service names.
+	// that were created by this sync process. Keys are Kube service keys.
 	// It's populated from Kubernetes data.
 	serviceMapConsul map[string]*apiv1.Service
-	triggerCh        chan struct{}
+
+	// serviceInstances holds the instances of the Consul services that are
+	// watched when syncing endpoints. Keys are Kube service keys. A service
+	// is missing until the first response of its watch is received.
+	serviceInstances map[string]*consulInstances
+
+	// watches holds the running watches of Consul services. Keys are Kube
+	// service keys.
+	watches map[string]*serviceWatch
+
+	triggerCh chan struct{}
 }
 
 // SetServices implements Sink.
 func (s *K8SSink) SetServices(svcs map[string]string) {
+	services := make([]ConsulService, 0, len(svcs))
+	for name, consulDNS := range svcs {
+		services = append(services, ConsulService{
+			K8SName: name,
+			Name:    consulServiceName(consulDNS),
+			DNS:     consulDNS,
+		})
+	}
+	s.SetConsulServices(services)
+}
+
+// SetConsulServices implements Sink.
+func (s *K8SSink) SetConsulServices(services []ConsulService) {
 	s.lock.Lock()
 	defer s.lock.Unlock()
 
+	// Sort the services so that name collisions are always resolved the
+	// same way: the service of the first partition and namespace in
+	// lexicographic order wins.
+	sorted := make([]ConsulService, len(services))
+	copy(sorted, services)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Partition != sorted[j].Partition {
+			return sorted[i].Partition < sorted[j].Partition
+		}
+		if sorted[i].Namespace != sorted[j].Namespace {
+			return sorted[i].Namespace < sorted[j].Namespace
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
 	// Kubernetes service names must be lowercase. We also lowercase the
 	// consulDNS entry because it becomes an externalName which also must be
 	// lowercase.
-	// There is no chance of collision here because the Consul catalog is
-	// case insensitive, i.e. there won't be two services with the same name
-	// but different cases, and so svcs will be unique even after lowercasing.
-	lowercasedSvcs := make(map[string]string)
-	for consulName, consulDNS := range svcs {
-		lowercasedSvcs[strings.ToLower(consulName)] = strings.ToLower(consulDNS)
+	// There is no chance of collision within a Consul namespace because the
+	// Consul catalog is case insensitive, i.e. there won't be two services
+	// with the same name but different cases. Services of different Consul
+	// namespaces can collide if they map to the same Kube namespace.
+	sourceServices := make(map[string]ConsulService, len(sorted))
+	for _, svc := range sorted {
+		svc.K8SName = strings.ToLower(svc.K8SName)
+		svc.DNS = strings.ToLower(svc.DNS)
+		key := serviceKey(s.k8sNamespace(svc), svc.K8SName)
+		if existing, ok := sourceServices[key]; ok {
+			s.Log.Warn("service name collision, not registering",
+				"key", key, "name", svc.Name, "namespace", svc.Namespace, "partition", svc.Partition,
+				"registered-namespace", existing.Namespace, "registered-partition", existing.Partition)
+			continue
+		}
+		sourceServices[key] = svc
 	}
 
-	s.sourceServices = lowercasedSvcs
+	s.sourceServices = sourceServices
+	if s.syncEndpoints() {
+		s.updateWatches()
+	}
 	s.trigger() // Any service change probably requires syncing
 }
 
@@ -108,11 +231,11 @@ func (s *K8SSink) Informer() cache.SharedIndexInformer {
 	return cache.NewSharedIndexInformer(
 		&cache.ListWatch{
 			ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
-				return s.Client.CoreV1().Services(s.namespace()).List(s.Ctx, options)
+				return s.Client.CoreV1().Services(s.watchNamespace()).List(s.Ctx, options)
 			},
 
 			WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
-				return s.Client.CoreV1().Services(s.namespace()).Watch(s.Ctx, options)
+				return s.Client.CoreV1().Services(s.watchNamespace()).Watch(s.Ctx, options)
 			},
 		},
 		&apiv1.Service{},
@@ -134,16 +257,18 @@ func (s *K8SSink) Upsert(key string, raw interface{}) error {
 	defer s.lock.Unlock()
 
 	// Store all the key to name mappings. We need this because the key
-	// is opaque but we want to do all the lookups by service name.
+	// is opaque but we want to do all the lookups by service key.
 	if s.keyToName == nil {
 		s.keyToName = make(map[string]string)
 	}
-	s.keyToName[key] = service.Name
+	svcKey := serviceKey(service.Namespace, service.Name)
+	s.keyToName[key] = svcKey
 
 	if s.serviceMap == nil {
 		s.serviceMap = make(map[string]struct{})
 	}
-	s.serviceMap[service.Name] = struct{}{}
+	s.serviceMap[svcKey] = struct{}{}
+	s.Metrics.SetServices(metrics.DirectionToK8S, map[string]int{"": len(s.serviceMap)})
 
 	// If the service is a Consul-sourced service, then keep track of it
 	// separately for a quick lookup.
@@ -152,7 +277,7 @@ func (s *K8SSink) Upsert(key string, raw interface{}) error {
 			s.serviceMapConsul = make(map[string]*apiv1.Service)
 		}
 
-		s.serviceMapConsul[service.Name] = service
+		s.serviceMapConsul[svcKey] = service
 		s.trigger() // Always trigger sync
 	}
 
@@ -178,6 +303,7 @@ func (s *K8SSink) Delete(key string, _ interface{}) error {
 	delete(s.keyToName, key)
 	delete(s.serviceMap, name)
 	delete(s.serviceMapConsul, name)
+	s.Metrics.SetServices(metrics.DirectionToK8S, map[string]int{"": len(s.serviceMap)})
 
 	// If the service that is deleted is part of Consul services, then
 	// we need to trigger a sync to recreate it.
@@ -222,79 +348,130 @@ func (s *K8SSink) Run(ch <-chan struct{}) {
 
 		s.lock.Lock()
 		create, update, delete := s.crudList()
+		// consulNamespaces maps the keys of the Kube services to the Consul
+		// namespace of their Consul service, if it's still known.
+		consulNamespaces := make(map[string]string)
+		for key, svc := range s.sourceServices {
+			consulNamespaces[key] = svc.Namespace
+		}
+		// Only compute the desired EndpointSlices while holding the lock
+		// and list the existing ones after releasing it, so that the
+		// watches and informers aren't blocked on the Kubernetes API.
+		var desiredSlices map[string]*discoveryv1.EndpointSlice
+		syncEndpoints := s.syncEndpoints()
+		if syncEndpoints {
+			desiredSlices = s.desiredEndpointSlices(delete)
+		}
 		s.lock.Unlock()
+
+		var sliceCreate, sliceUpdate []*discoveryv1.EndpointSlice
+		var sliceDelete []string
+		if syncEndpoints {
+			var err error
+			sliceCreate, sliceUpdate, sliceDelete, err = s.endpointSliceCrudList(desiredSlices)
+			if err != nil {
+				s.Log.Warn("error listing endpoint slices", "error", err)
+			}
+		}
 		s.Log.Debug("sync triggered", "create", len(create), "update", len(update), "delete", len(delete))
 
-		svcClient := s.Client.CoreV1().Services(s.namespace())
-		for _, name := range delete {
-			if err := svcClient.Delete(s.Ctx, name, metav1.DeleteOptions{}); err != nil {
-				s.Log.Warn("error deleting service", "name", name, "error", err)
+		for _, key := range delete {
+			namespace, name := splitServiceKey(key)
+			if err := s.Client.CoreV1().Services(namespace).Delete(s.Ctx, name, metav1.DeleteOptions{}); err != nil {
+				s.Log.Warn("error deleting service", "name", name, "namespace", namespace, "error", err)
+				continue
 			}
+			s.Metrics.Deregistered(metrics.DirectionToK8S, consulNamespaces[key])
 		}
 
 		for _, svc := range update {
-			_, err := svcClient.Update(s.Ctx, svc, metav1.UpdateOptions{})
+			_, err := s.Client.CoreV1().Services(svc.Namespace).Update(s.Ctx, svc, metav1.UpdateOptions{})
 			if err != nil {
-				s.Log.Warn("error updating service", "name", svc.Name, "error", err)
+				s.Log.Warn("error updating service", "name", svc.Name, "namespace", svc.Namespace, "error", err)
+				continue
 			}
+			s.Metrics.Registered(metrics.DirectionToK8S, consulNamespaces[serviceKey(svc.Namespace, svc.Name)])
 		}
 
 		for _, svc := range create {
-			_, err := svcClient.Create(s.Ctx, svc, metav1.CreateOptions{})
+			_, err := s.Client.CoreV1().Services(svc.Namespace).Create(s.Ctx, svc, metav1.CreateOptions{})
 			if err != nil {
-				s.Log.Warn("error creating service", "name", svc.Name, "error", err)
+				s.Log.Warn("error creating service", "name", svc.Name, "namespace", svc.Namespace, "error", err)
+				continue
+			}
+			s.Metrics.Registered(metrics.DirectionToK8S, consulNamespaces[serviceKey(svc.Namespace, svc.Name)])
+		}
+
+		for _, key := range sliceDelete {
+			namespace, name := splitServiceKey(key)
+			if err := s.Client.DiscoveryV1().EndpointSlices(namespace).Delete(s.Ctx, name, metav1.DeleteOptions{}); err != nil {
+				s.Log.Warn("error deleting endpoint slice", "name", name, "namespace", namespace, "error", err)
+			}
+		}
+
+		for _, slice := range sliceUpdate {
+			if _, err := s.Client.DiscoveryV1().EndpointSlices(slice.Namespace).Update(s.Ctx, slice, metav1.UpdateOptions{}); err != nil {
+				s.Log.Warn("error updating endpoint slice", "name", slice.Name, "namespace", slice.Namespace, "error", err)
+			}
+		}
+
+		for _, slice := range sliceCreate {
+			if _, err := s.Client.DiscoveryV1().EndpointSlices(slice.Namespace).Create(s.Ctx, slice, metav1.CreateOptions{}); err != nil {
+				s.Log.Warn("error creating endpoint slice", "name", slice.Name, "namespace", slice.Namespace, "error", err)
 			}
 		}
 	}
 }
 
 // crudList returns the services to create, update, and delete (respectively).
+// Services to delete are returned as service keys. A service whose cluster IP
+// cannot be updated in place is both deleted and created.
 func (s *K8SSink) crudList() ([]*apiv1.Service, []*apiv1.Service, []string) {
 	var create, update []*apiv1.Service
 	var delete []string
 
 	// Determine what needs to be created or updated
-	for consulName, consulDNS := range s.sourceServices {
+	for key, consulSvc := range s.sourceServices {
+		spec, ok := s.serviceSpec(key, consulSvc.DNS)
+		if !ok {
+			// The instances of the service are not known yet.
+			continue
+		}
+
 		// If this is an already registered service, then update it
 		if s.serviceMapConsul != nil {
-			if svc, ok := s.serviceMapConsul[consulName]; ok {
-				if svc.Spec.ExternalName == consulDNS {
+			if svc, ok := s.serviceMapConsul[key]; ok {
+				if serviceSpecMatches(svc.Spec, spec) {
 					// Matching service, no update required.
 					continue
 				}
 
-				svc.Spec = apiv1.ServiceSpec{
-					Type:         apiv1.ServiceTypeExternalName,
-					ExternalName: consulDNS,
+				if !clusterIPUpdatable(svc.Spec, spec) {
+					delete = append(delete, key)
+					create = append(create, newService(key, spec))
+					continue
 				}
 
+				// Keep the cluster IP that Kubernetes allocated.
+				if spec.Type == apiv1.ServiceTypeClusterIP && svc.Spec.Type == apiv1.ServiceTypeClusterIP {
+					spec.ClusterIP = svc.Spec.ClusterIP
+					spec.ClusterIPs = svc.Spec.ClusterIPs
+				}
+
+				svc.Spec = spec
 				update = append(update, svc)
 				continue
 			}
 		}
 
 		// If this is a registered K8S service, ignore.
-		if _, ok := s.serviceMap[consulName]; ok {
-			s.Log.Warn("service already registered in K8S, not registering", "name", consulName)
+		if _, ok := s.serviceMap[key]; ok {
+			s.Log.Warn("service already registered in K8S, not registering", "key", key)
 			continue
 		}
 
 		// Register!
-		create = append(create, &apiv1.Service{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:   consulName,
-				Labels: map[string]string{"consul": "true"},
-				Annotations: map[string]string{
-					// Ensure we don't sync the service back to Consul
-					"consul.hashicorp.com/service-sync": "false",
-				},
-			},
-
-			Spec: apiv1.ServiceSpec{
-				Type:         apiv1.ServiceTypeExternalName,
-				ExternalName: consulDNS,
-			},
-		})
+		create = append(create, newService(key, spec))
 	}
 
 	// Determine what needs to be deleted
@@ -307,6 +484,92 @@ func (s *K8SSink) crudList() ([]*apiv1.Service, []*apiv1.Service, []string) {
 	return create, update, delete
 }
 
+// serviceSpec returns the spec of the Kube service for a Consul service. It
+// returns false if the spec cannot be built yet because the instances of the
+// Consul service are not known. lock must be held.
+func (s *K8SSink) serviceSpec(key, consulDNS string) (apiv1.ServiceSpec, bool) {
+	if !s.syncEndpoints() {
+		return apiv1.ServiceSpec{
+			Type:         apiv1.ServiceTypeExternalName,
+			ExternalName: consulDNS,
+		}, true
+	}
+
+	instances, ok := s.serviceInstances[key]
+	if !ok || instances.Port == 0 {
+		return apiv1.ServiceSpec{}, false
+	}
+
+	spec := apiv1.ServiceSpec{
+		Type: apiv1.ServiceTypeClusterIP,
+		Ports: []apiv1.ServicePort{
+			{
+				Name:     servicePortName,
+				Protocol: apiv1.ProtocolTCP,
+				Port:     instances.Port,
+			},
+		},
+	}
+	if s.ServiceType == Headless {
+		spec.ClusterIP = apiv1.ClusterIPNone
+	}
+	return spec, true
+}
+
+// syncEndpoints returns true if the sink creates services with endpoints
+// rather than ExternalName services.
+func (s *K8SSink) syncEndpoints() bool {
+	return s.ServiceType == ClusterIP || s.ServiceType == Headless
+}
+
+// newService returns a new Consul-sourced Kube service with the service key.
+func newService(key string, spec apiv1.ServiceSpec) *apiv1.Service {
+	namespace, name := splitServiceKey(key)
+	return &apiv1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{"consul": "true"},
+			Annotations: map[string]string{
+				// Ensure we don't sync the service back to Consul
+				"consul.hashicorp.com/service-sync": "false",
+			},
+		},
+
+		Spec: spec,
+	}
+}
+
+// serviceSpecMatches returns true if the fields of the existing service spec
+// that are managed by the sink match the desired spec.
+func serviceSpecMatches(existing, desired apiv1.ServiceSpec) bool {
+	if existing.Type != desired.Type || existing.ExternalName != desired.ExternalName {
+		return false
+	}
+	if (existing.ClusterIP == apiv1.ClusterIPNone) != (desired.ClusterIP == apiv1.ClusterIPNone) {
+		return false
+	}
+	if len(existing.Ports) != len(desired.Ports) {
+		return false
+	}
+	for i := range desired.Ports {
+		if existing.Ports[i].Name != desired.Ports[i].Name || existing.Ports[i].Port != desired.Ports[i].Port {
+			return false
+		}
+	}
+	return true
+}
+
+// clusterIPUpdatable returns false if changing the existing service to the
+// desired spec requires changing an allocated cluster IP to none or the other
+// way around, which Kubernetes does not allow.
+func clusterIPUpdatable(existing, desired apiv1.ServiceSpec) bool {
+	if existing.Type != apiv1.ServiceTypeClusterIP || desired.Type != apiv1.ServiceTypeClusterIP {
+		return true
+	}
+	return (existing.ClusterIP == apiv1.ClusterIPNone) == (desired.ClusterIP == apiv1.ClusterIPNone)
+}
+
 // namespace returns the K8S namespace to setup the resource watchers in.
 func (s *K8SSink) namespace() string {
 	if s.Namespace != "" {
@@ -318,6 +581,54 @@ func (s *K8SSink) namespace() string {
 	return metav1.NamespaceDefault
 }
 
+// watchNamespace returns the K8S namespace to watch services in. All
+// namespaces are watched if namespaces are enabled since services can be
+// written to any of them.
+func (s *K8SSink) watchNamespace() string {
+	if s.EnableNamespaces {
+		return metav1.NamespaceAll
+	}
+	return s.namespace()
+}
+
+// k8sNamespace returns the K8S namespace to write a Consul service to.
+func (s *K8SSink) k8sNamespace(svc ConsulService) string {
+	if !s.EnableNamespaces {
+		return s.namespace()
+	}
+
+	namespace := svc.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	partition := svc.Partition
+	if partition == "" {
+		partition = "default"
+	}
+
+	if ns, ok := s.K8SNamespaceMapping[partition+"/"+namespace]; ok {
+		return ns
+	}
+	if ns, ok := s.K8SNamespaceMapping[namespace]; ok {
+		return ns
+	}
+	if s.EnableConsulNSMirroring {
+		return s.ConsulNSMirroringPrefix + namespace
+	}
+	return s.namespace()
+}
+
+// serviceKey returns the key of a Kube service.
+func serviceKey(namespace, name string) string {
+	return namespace + "/" + name
+}
+
+// splitServiceKey returns the namespace and name of a Kube service key.
+func splitServiceKey(key string) (string, string) {
+	i := strings.Index(key, "/")
+	return key[:i], key[i+1:]
+}
+
 // trigger will notify a sync should occur. lock must be held.
 //
 // This is not synchronous and does not guarantee a sync will happen. This
@@ -2,11 +2,15 @@ package catalog
 
 import (
 	"context"
+	"strings"
 	"testing"
 
+	"github.com/hashicorp/consul-k8s/control-plane/catalog/metrics"
 	"github.com/hashicorp/consul-k8s/control-plane/helper/controller"
 	"github.com/hashicorp/consul/sdk/testutil/retry"
 	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	apiv1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -393,6 +397,176 @@ func TestK8SSink_deleteReconcileLocal(t *testing.T) {
 	})
 }
 
+// Test that services of Consul namespaces are written to the Kube namespaces
+// they map to.
+func TestK8SSink_namespaces(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		mapping   map[string]string
+		mirroring bool
+		prefix    string
+		exp       map[string]string
+	}{
+		"no mapping": {
+			exp: map[string]string{
+				"default/web": "web.service.consul",
+				"default/api": "api.service.ns1.ns.consul",
+			},
+		},
+		"mirroring": {
+			mirroring: true,
+			exp: map[string]string{
+				"default/web": "web.service.consul",
+				"ns1/api":     "api.service.ns1.ns.consul",
+				"ns1/db":      "db.service.ns1.ns.ap1.ap.consul",
+			},
+		},
+		"mirroring with prefix": {
+			mirroring: true,
+			prefix:    "consul-",
+			exp: map[string]string{
+				"consul-default/web": "web.service.consul",
+				"consul-ns1/api":     "api.service.ns1.ns.consul",
+				"consul-ns1/db":      "db.service.ns1.ns.ap1.ap.consul",
+			},
+		},
+		"mapping takes precedence over mirroring": {
+			mapping:   map[string]string{"ns1": "apps", "ap1/ns1": "partition1"},
+			mirroring: true,
+			exp: map[string]string{
+				"default/web":   "web.service.consul",
+				"apps/api":      "api.service.ns1.ns.consul",
+				"partition1/db": "db.service.ns1.ns.ap1.ap.consul",
+			},
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			client := fake.NewSimpleClientset()
+			sink := &K8SSink{
+				Client:                  client,
+				Log:                     hclog.Default(),
+				Ctx:                     context.Background(),
+				EnableNamespaces:        true,
+				K8SNamespaceMapping:     c.mapping,
+				EnableConsulNSMirroring: c.mirroring,
+				ConsulNSMirroringPrefix: c.prefix,
+			}
+			closer := controller.TestControllerRun(sink)
+			defer closer()
+
+			services := []ConsulService{
+				{K8SName: "web", Name: "web", DNS: "web.service.consul"},
+				{K8SName: "api", Name: "api", Namespace: "ns1", DNS: "api.service.ns1.ns.consul"},
+			}
+			if c.mirroring {
+				services = append(services, ConsulService{
+					K8SName: "db", Name: "db", Namespace: "ns1", Partition: "ap1", DNS: "db.service.ns1.ns.ap1.ap.consul",
+				})
+			}
+			sink.SetConsulServices(services)
+
+			retry.Run(t, func(r *retry.R) {
+				list, err := client.CoreV1().Services(metav1.NamespaceAll).List(context.Background(), metav1.ListOptions{})
+				require.NoError(r, err)
+
+				actual := make(map[string]string)
+				for _, svc := range list.Items {
+					actual[svc.Namespace+"/"+svc.Name] = svc.Spec.ExternalName
+				}
+				require.Equal(r, c.exp, actual)
+			})
+		})
+	}
+}
+
+// Test that name collisions between Consul namespaces are resolved by
+// the order of partitions and namespaces.
+func TestK8SSink_namespacesCollision(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	sink := &K8SSink{
+		Client:           client,
+		Log:              hclog.Default(),
+		Ctx:              context.Background(),
+		EnableNamespaces: true,
+	}
+	closer := controller.TestControllerRun(sink)
+	defer closer()
+
+	sink.SetConsulServices([]ConsulService{
+		{K8SName: "web", Name: "web", Namespace: "ns2", DNS: "web.service.ns2.ns.consul"},
+		{K8SName: "web", Name: "web", Namespace: "ns1", Partition: "ap1", DNS: "web.service.ns1.ns.ap1.ap.consul"},
+		{K8SName: "web", Name: "web", Namespace: "ns1", DNS: "web.service.ns1.ns.consul"},
+	})
+
+	retry.Run(t, func(r *retry.R) {
+		list, err := client.CoreV1().Services(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+		require.NoError(r, err)
+		require.Len(r, list.Items, 1)
+		require.Equal(r, "web.service.ns1.ns.consul", list.Items[0].Spec.ExternalName)
+	})
+
+	// Removing the winner hands the name over to the next service.
+	sink.SetConsulServices([]ConsulService{
+		{K8SName: "web", Name: "web", Namespace: "ns2", DNS: "web.service.ns2.ns.consul"},
+		{K8SName: "web", Name: "web", Namespace: "ns1", Partition: "ap1", DNS: "web.service.ns1.ns.ap1.ap.consul"},
+	})
+
+	retry.Run(t, func(r *retry.R) {
+		list, err := client.CoreV1().Services(metav1.NamespaceDefault).List(context.Background(), metav1.ListOptions{})
+		require.NoError(r, err)
+		require.Len(r, list.Items, 1)
+		require.Equal(r, "web.service.ns2.ns.consul", list.Items[0].Spec.ExternalName)
+	})
+}
+
+// Test that services created and deleted in Kubernetes are counted.
+func TestK8SSink_metrics(t *testing.T) {
+	t.Parallel()
+	client := fake.NewSimpleClientset()
+	reg := prometheus.NewRegistry()
+
+	sink := &K8SSink{
+		Client:  client,
+		Log:     hclog.Default(),
+		Ctx:     context.Background(),
+		Metrics: metrics.New(reg),
+	}
+	closer := controller.TestControllerRun(sink)
+	defer closer()
+
+	sink.SetConsulServices([]ConsulService{
+		{K8SName: "web", Name: "web", Namespace: "ns1", DNS: "web.service.ns1.consul."},
+	})
+	retry.Run(t, func(r *retry.R) {
+		require.NoError(r, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP consul_sync_catalog_registrations_total The number of services registered in Consul or created and updated in Kubernetes.
+# TYPE consul_sync_catalog_registrations_total counter
+consul_sync_catalog_registrations_total{direction="to-k8s",namespace="ns1"} 1
+# HELP consul_sync_catalog_services The number of Kubernetes services eligible for sync to Consul, or watched by the sync to Kubernetes.
+# TYPE consul_sync_catalog_services gauge
+consul_sync_catalog_services{direction="to-k8s",namespace=""} 1
+`), "consul_sync_catalog_registrations_total", "consul_sync_catalog_services"))
+	})
+
+	sink.SetConsulServices(nil)
+	retry.Run(t, func(r *retry.R) {
+		require.NoError(r, testutil.GatherAndCompare(reg, strings.NewReader(`
+# HELP consul_sync_catalog_deregistrations_total The number of services deregistered from Consul or deleted from Kubernetes.
+# TYPE consul_sync_catalog_deregistrations_total counter
+consul_sync_catalog_deregistrations_total{direction="to-k8s",namespace=""} 1
+# HELP consul_sync_catalog_services The number of Kubernetes services eligible for sync to Consul, or watched by the sync to Kubernetes.
+# TYPE consul_sync_catalog_services gauge
+consul_sync_catalog_services{direction="to-k8s",namespace=""} 0
+`), "consul_sync_catalog_deregistrations_total", "consul_sync_catalog_services"))
+	})
+}
+
 func testSink(t *testing.T, client kubernetes.Interface) (*K8SSink, func()) {
 	sink := &K8SSink{
 		Client: client,
@@ -2,14 +2,29 @@ package catalog
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff"
+	"github.com/hashicorp/consul-k8s/control-plane/catalog/metrics"
 	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-bexpr"
 	"github.com/hashicorp/go-hclog"
 )
 
+// WildcardNamespace selects all Consul namespaces or partitions.
+const WildcardNamespace = "*"
+
+// errInvalidFilter is returned when a filter is invalid or Consul rejects it.
+var errInvalidFilter = errors.New("invalid filter")
+
 // Source is the source for the sync that watches Consul services and
 // updates a Sink whenever the set of services to register changes.
 type Source struct {
@@ -19,34 +34,415 @@ type Source struct {
 	Prefix       string       // Prefix is a prefix to prepend to services
 	Log          hclog.Logger // Logger
 	ConsulK8STag string       // The tag value for services registered
+
+	// EnableNamespaces indicates that services are read from the Consul
+	// namespaces in ConsulNamespaces and the partitions in ConsulPartitions
+	// and are passed to the Sink with SetConsulServices.
+	EnableNamespaces bool
+
+	// ConsulNamespaces are the Consul namespaces to read services from.
+	// WildcardNamespace selects all namespaces. If empty, services are read
+	// from the namespace of the Consul client.
+	ConsulNamespaces []string
+
+	// ConsulPartitions are the Consul admin partitions to read services
+	// from. WildcardNamespace selects all partitions. If empty, services are
+	// read from the partition of the Consul client.
+	ConsulPartitions []string
+
+	// NamespacePollPeriod is the interval to look for new namespaces and
+	// partitions when ConsulNamespaces or ConsulPartitions hold
+	// WildcardNamespace. Defaults to 1 minute.
+	NamespacePollPeriod time.Duration
+
+	// AllowTags and AllowMetaKeys limit the services to sync to the ones
+	// with at least one of the tags or service meta keys if either is set.
+	AllowTags     []string
+	AllowMetaKeys []string
+
+	// AllowServices and DenyServices are patterns of Consul service names,
+	// as defined by path.Match, to sync and not to sync. DenyServices takes
+	// precedence. All services are allowed if AllowServices is empty.
+	AllowServices []string
+	DenyServices  []string
+
+	// Filter is an expression in Consul's filtering syntax that services to
+	// sync must match, e.g. ServiceMeta.team == "a". It's combined with the
+	// filter set with SetFilter.
+	Filter string
+
+	// RenameRules rename the services to sync. The first rule that matches
+	// the name of a Consul service renames it before Prefix is prepended.
+	RenameRules []RenameRule
+
+	// Metrics records the metrics of the sync if set.
+	Metrics *metrics.Metrics
+
+	// filterLock gates access to dynamicFilter and filterCh.
+	filterLock sync.Mutex
+
+	// dynamicFilter is the filter set with SetFilter.
+	dynamicFilter string
+
+	// filterCh is closed when dynamicFilter changes.
+	filterCh chan struct{}
+}
+
+// RenameRule renames the Consul services whose name matches Pattern to the
+// expansion of Replacement, as defined by regexp.Regexp.ReplaceAllString.
+type RenameRule struct {
+	Pattern     *regexp.Regexp
+	Replacement string
+}
+
+// ParseRenameRule parses a rename rule in the form <pattern>=<replacement>.
+func ParseRenameRule(rule string) (RenameRule, error) {
+	i := strings.LastIndex(rule, "=")
+	if i <= 0 {
+		return RenameRule{}, fmt.Errorf("rename rule %q must be in the form <pattern>=<replacement>", rule)
+	}
+	pattern, err := regexp.Compile(rule[:i])
+	if err != nil {
+		return RenameRule{}, fmt.Errorf("rename rule %q has an invalid pattern: %w", rule, err)
+	}
+	return RenameRule{Pattern: pattern, Replacement: rule[i+1:]}, nil
+}
+
+// SetFilter sets a filter expression in Consul's filtering syntax that
+// services to sync must match in addition to Filter. Services are read
+// again from Consul if the filter changes. An error is returned and the
+// previous filter is kept if the expression is invalid.
+func (s *Source) SetFilter(filter string) error {
+	if _, err := bexpr.CreateFilter(filter); err != nil {
+		return fmt.Errorf("%w %q: %v", errInvalidFilter, filter, err)
+	}
+
+	s.filterLock.Lock()
+	defer s.filterLock.Unlock()
+
+	if filter == s.dynamicFilter {
+		return nil
+	}
+	s.dynamicFilter = filter
+	if s.filterCh != nil {
+		close(s.filterCh)
+		s.filterCh = nil
+	}
+	return nil
+}
+
+// CheckFilter returns an error if Consul rejects the catalog services query
+// with the filter set with SetFilter instead of the current one. Selectors
+// that don't exist, e.g. Service instead of ServiceName, are only rejected by
+// Consul and not when the expression is parsed.
+func (s *Source) CheckFilter(ctx context.Context, filter string) error {
+	if _, err := bexpr.CreateFilter(filter); err != nil {
+		return fmt.Errorf("%w %q: %v", errInvalidFilter, filter, err)
+	}
+
+	opts := (&api.QueryOptions{
+		AllowStale: true,
+		Filter:     s.filterExpression(filter),
+	}).WithContext(ctx)
+	_, _, err := s.Client.Catalog().Services(opts)
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) {
+		return fmt.Errorf("%w %q: %v", errInvalidFilter, filter, err)
+	}
+	if err != nil {
+		return fmt.Errorf("querying services with filter %q: %w", filter, err)
+	}
+	return nil
+}
+
+// filter returns the filter expression for the catalog services endpoint and
+// a channel that is closed when it changes.
+func (s *Source) filter() (string, <-chan struct{}) {
+	s.filterLock.Lock()
+	defer s.filterLock.Unlock()
+
+	if s.filterCh == nil {
+		s.filterCh = make(chan struct{})
+	}
+	return s.filterExpression(s.dynamicFilter), s.filterCh
+}
+
+// filterExpression returns the filter expression for the catalog services
+// endpoint with the given filter in place of the one set with SetFilter.
+func (s *Source) filterExpression(dynamicFilter string) string {
+	var allow []string
+	for _, tag := range s.AllowTags {
+		allow = append(allow, fmt.Sprintf("%s in ServiceTags", strconv.Quote(tag)))
+	}
+	for _, key := range s.AllowMetaKeys {
+		allow = append(allow, fmt.Sprintf("%s in ServiceMeta", strconv.Quote(key)))
+	}
+
+	var filters []string
+	if len(allow) > 0 {
+		filters = append(filters, strings.Join(allow, " or "))
+	}
+	if s.Filter != "" {
+		filters = append(filters, s.Filter)
+	}
+	if dynamicFilter != "" {
+		filters = append(filters, dynamicFilter)
+	}
+	if len(filters) == 1 {
+		return filters[0]
+	}
+	for i, f := range filters {
+		filters[i] = fmt.Sprintf("(%s)", f)
+	}
+	return strings.Join(filters, " and ")
+}
+
+// allowed returns true if the Consul service name passes the allow and deny
+// patterns.
+func (s *Source) allowed(name string) bool {
+	for _, pattern := range s.DenyServices {
+		if ok, _ := path.Match(pattern, name); ok {
+			return false
+		}
+	}
+	if len(s.AllowServices) == 0 {
+		return true
+	}
+	for _, pattern := range s.AllowServices {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// k8sName returns the name of the Kube service for a Consul service.
+func (s *Source) k8sName(name string) string {
+	for _, rule := range s.RenameRules {
+		if rule.Pattern.MatchString(name) {
+			name = rule.Pattern.ReplaceAllString(name, rule.Replacement)
+			break
+		}
+	}
+	return s.Prefix + name
+}
+
+// consulNamespace is a Consul namespace in a partition.
+type consulNamespace struct {
+	Partition string
+	Namespace string
+}
+
+// namespaceServices holds the services of a Consul namespace.
+type namespaceServices struct {
+	namespace consulNamespace
+	services  []ConsulService
 }
 
 // Run is the long-running runloop for watching Consul services and
 // updating the Sink.
 func (s *Source) Run(ctx context.Context) {
+	if !s.EnableNamespaces {
+		s.watchServices(ctx, consulNamespace{}, func(services []ConsulService) {
+			// Sort the services so that the same service wins if services
+			// are renamed to the same name.
+			sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+			svcs := make(map[string]string, len(services))
+			for _, svc := range services {
+				if _, ok := svcs[svc.K8SName]; ok {
+					s.Log.Warn("service name collision, not syncing", "name", svc.Name, "k8s-name", svc.K8SName)
+					continue
+				}
+				svcs[svc.K8SName] = svc.DNS
+			}
+			s.Sink.SetServices(svcs)
+		})
+		return
+	}
+
+	s.runNamespaces(ctx)
+}
+
+// runNamespaces watches the services of all selected Consul namespaces and
+// updates the Sink with the services of all of them.
+func (s *Source) runNamespaces(ctx context.Context) {
+	updateCh := make(chan namespaceServices)
+	watches := make(map[consulNamespace]context.CancelFunc)
+	services := make(map[consulNamespace][]ConsulService)
+	defer func() {
+		for _, cancel := range watches {
+			cancel()
+		}
+	}()
+
+	pollPeriod := s.NamespacePollPeriod
+	if pollPeriod == 0 {
+		pollPeriod = 1 * time.Minute
+	}
+	ticker := time.NewTicker(pollPeriod)
+	defer ticker.Stop()
+
+	for {
+		namespaces, err := s.consulNamespaces(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			s.Log.Warn("error listing Consul namespaces, will retry", "err", err)
+			s.Metrics.WatchRestarted(metrics.DirectionToK8S, metrics.WatchConsulNamespaces, "")
+		} else {
+			// Stop the watches of namespaces that no longer exist.
+			removed := false
+			for ns, cancel := range watches {
+				if _, ok := namespaces[ns]; !ok {
+					cancel()
+					delete(watches, ns)
+					delete(services, ns)
+					removed = true
+				}
+			}
+			if removed && len(services) == len(watches) {
+				s.Sink.SetConsulServices(flattenServices(services))
+			}
+
+			// Start the watches of new namespaces.
+			for ns := range namespaces {
+				if _, ok := watches[ns]; ok {
+					continue
+				}
+				ns := ns
+				watchCtx, cancel := context.WithCancel(ctx)
+				watches[ns] = cancel
+				go s.watchServices(watchCtx, ns, func(svcs []ConsulService) {
+					select {
+					case updateCh <- namespaceServices{namespace: ns, services: svcs}:
+					case <-watchCtx.Done():
+					}
+				})
+			}
+		}
+
+	WAIT:
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				break WAIT
+			case update := <-updateCh:
+				// Ignore updates of watches that were stopped.
+				if _, ok := watches[update.namespace]; !ok {
+					continue
+				}
+				services[update.namespace] = update.services
+
+				// Wait until the services of all namespaces are known so
+				// that the services of the other namespaces aren't deleted
+				// from Kubernetes in the meantime.
+				if len(services) == len(watches) {
+					s.Sink.SetConsulServices(flattenServices(services))
+				}
+			}
+		}
+	}
+}
+
+// consulNamespaces returns the Consul namespaces to watch.
+func (s *Source) consulNamespaces(ctx context.Context) (map[consulNamespace]struct{}, error) {
+	partitions := s.ConsulPartitions
+	if len(partitions) == 0 {
+		partitions = []string{""}
+	} else if containsWildcard(partitions) {
+		list, _, err := s.Client.Partitions().List(ctx, &api.QueryOptions{AllowStale: true})
+		if err != nil {
+			s.Metrics.ConsulError(metrics.DirectionToK8S, metrics.OpListPartitions, "")
+			return nil, fmt.Errorf("listing partitions: %w", err)
+		}
+		partitions = nil
+		for _, p := range list {
+			partitions = append(partitions, p.Name)
+		}
+	}
+
+	result := make(map[consulNamespace]struct{})
+	for _, partition := range partitions {
+		namespaces := s.ConsulNamespaces
+		if len(namespaces) == 0 {
+			namespaces = []string{""}
+		} else if containsWildcard(namespaces) {
+			nsOpts := (&api.QueryOptions{AllowStale: true, Partition: partition}).WithContext(ctx)
+			list, _, err := s.Client.Namespaces().List(nsOpts)
+			if err != nil {
+				s.Metrics.ConsulError(metrics.DirectionToK8S, metrics.OpListNamespaces, "")
+				return nil, fmt.Errorf("listing namespaces of partition %q: %w", partition, err)
+			}
+			namespaces = nil
+			for _, ns := range list {
+				namespaces = append(namespaces, ns.Name)
+			}
+		}
+
+		for _, namespace := range namespaces {
+			result[consulNamespace{Partition: partition, Namespace: namespace}] = struct{}{}
+		}
+	}
+	return result, nil
+}
+
+// watchServices watches the services of a Consul namespace with blocking
+// queries and calls set with the services until ctx is cancelled.
+func (s *Source) watchServices(ctx context.Context, ns consulNamespace, set func([]ConsulService)) {
 	opts := (&api.QueryOptions{
 		AllowStale: true,
 		WaitIndex:  1,
 		WaitTime:   1 * time.Minute,
+		Namespace:  ns.Namespace,
+		Partition:  ns.Partition,
 	}).WithContext(ctx)
 	for {
+		// Cancel the query if the filter changes.
+		filter, filterCh := s.filter()
+		queryCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			select {
+			case <-filterCh:
+				cancel()
+			case <-queryCtx.Done():
+			}
+		}()
+		queryOpts := opts.WithContext(queryCtx)
+		queryOpts.Filter = filter
+
 		// Get all services with tags.
 		var serviceMap map[string][]string
 		var meta *api.QueryMeta
 		err := backoff.Retry(func() error {
 			var err error
-			serviceMap, meta, err = s.Client.Catalog().Services(opts)
+			serviceMap, meta, err = s.Client.Catalog().Services(queryOpts)
+			if err != nil && queryCtx.Err() == nil {
+				s.Metrics.ConsulError(metrics.DirectionToK8S, metrics.OpCatalogServices, ns.Namespace)
+			}
 			return err
-		}, backoff.WithContext(backoff.NewExponentialBackOff(), ctx))
+		}, backoff.WithContext(backoff.NewExponentialBackOff(), queryCtx))
+		cancel()
 
 		// If the context is ended, then we end
 		if ctx.Err() != nil {
 			return
 		}
 
+		// If the filter changed, query the services again right away.
+		select {
+		case <-filterCh:
+			opts.WaitIndex = 1
+			continue
+		default:
+		}
+
 		// If there was an error, handle that
 		if err != nil {
 			s.Log.Warn("error querying services, will retry", "err", err)
+			s.Metrics.WatchRestarted(metrics.DirectionToK8S, metrics.WatchConsulServices, ns.Namespace)
 			continue
 		}
 
@@ -54,7 +450,7 @@ func (s *Source) Run(ctx context.Context) {
 		opts.WaitIndex = meta.LastIndex
 
 		// Setup the services
-		services := make(map[string]string, len(serviceMap))
+		services := make([]ConsulService, 0, len(serviceMap))
 		for name, tags := range serviceMap {
 			// We ignore services that are synced from k8s so we can avoid
 			// circular syncing. Realistically this shouldn't happen since
@@ -68,12 +464,50 @@ func (s *Source) Run(ctx context.Context) {
 				}
 			}
 
-			if !k8s {
-				services[s.Prefix+name] = fmt.Sprintf("%s.service.%s", name, s.Domain)
+			if !k8s && s.allowed(name) {
+				services = append(services, ConsulService{
+					K8SName:   s.k8sName(name),
+					Name:      name,
+					Namespace: ns.Namespace,
+					Partition: ns.Partition,
+					DNS:       s.dnsName(name, ns),
+				})
 			}
 		}
-		s.Log.Info("received services from Consul", "count", len(services))
+		s.Log.Info("received services from Consul", "count", len(services),
+			"namespace", ns.Namespace, "partition", ns.Partition)
 
-		s.Sink.SetServices(services)
+		set(services)
+	}
+}
+
+// dnsName returns the Consul DNS entry of a service, e.g.
+// foo.service.ns1.ns.ap1.ap.consul.
+func (s *Source) dnsName(name string, ns consulNamespace) string {
+	dns := fmt.Sprintf("%s.service", name)
+	if ns.Namespace != "" {
+		dns += fmt.Sprintf(".%s.ns", ns.Namespace)
+	}
+	if ns.Partition != "" {
+		dns += fmt.Sprintf(".%s.ap", ns.Partition)
+	}
+	return fmt.Sprintf("%s.%s", dns, s.Domain)
+}
+
+// flattenServices returns the services of all namespaces.
+func flattenServices(services map[consulNamespace][]ConsulService) []ConsulService {
+	var result []ConsulService
+	for _, svcs := range services {
+		result = append(result, svcs...)
+	}
+	return result
+}
+
+func containsWildcard(values []string) bool {
+	for _, v := range values {
+		if v == WildcardNamespace {
+			return true
+		}
 	}
+	return false
 }
@@ -2,7 +2,13 @@ package catalog
 
 import (
 	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 
 	toconsul "github.com/hashicorp/consul-k8s/control-plane/catalog/to-consul"
@@ -271,6 +277,230 @@ func testRegistration(node, service string, tags []string) *api.CatalogRegistrat
 	}
 }
 
+// Test that services are read from all Consul namespaces and partitions.
+func TestSource_namespaces(t *testing.T) {
+	t.Parallel()
+
+	// Serve namespaces and the services of each namespace. Blocking queries
+	// block until the request is cancelled.
+	services := map[string]map[string][]string{
+		"ap1/default": {"web": nil},
+		"ap1/ns1":     {"api": nil, "k8s-svc": {toconsul.TestConsulK8STag}},
+		"ap2/default": {"db": nil},
+	}
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		partition := r.URL.Query().Get("partition")
+		switch r.URL.Path {
+		case "/v1/partitions":
+			require.NoError(t, json.NewEncoder(w).Encode([]*api.Partition{{Name: "ap1"}, {Name: "ap2"}}))
+		case "/v1/namespaces":
+			var namespaces []*api.Namespace
+			for key := range services {
+				if strings.HasPrefix(key, partition+"/") {
+					namespaces = append(namespaces, &api.Namespace{Name: strings.TrimPrefix(key, partition+"/")})
+				}
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(namespaces))
+		case "/v1/catalog/services":
+			if r.URL.Query().Get("index") == "2" {
+				<-r.Context().Done()
+				return
+			}
+			w.Header().Set("X-Consul-Index", "2")
+			require.NoError(t, json.NewEncoder(w).Encode(services[partition+"/"+r.URL.Query().Get("ns")]))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer consulServer.Close()
+
+	client, err := api.NewClient(&api.Config{Address: consulServer.URL})
+	require.NoError(t, err)
+
+	_, sink, closer := testSourceWithConfig(client, func(s *Source) {
+		s.EnableNamespaces = true
+		s.ConsulNamespaces = []string{WildcardNamespace}
+		s.ConsulPartitions = []string{WildcardNamespace}
+		s.Prefix = "consul-"
+	})
+	defer closer()
+
+	var actual []ConsulService
+	retry.Run(t, func(r *retry.R) {
+		sink.Lock()
+		defer sink.Unlock()
+		actual = sink.ConsulServices
+		if len(actual) != 3 {
+			r.Fatal("services not found")
+		}
+	})
+
+	sort.Slice(actual, func(i, j int) bool { return actual[i].Name < actual[j].Name })
+	expected := []ConsulService{
+		{K8SName: "consul-api", Name: "api", Namespace: "ns1", Partition: "ap1", DNS: "api.service.ns1.ns.ap1.ap.test"},
+		{K8SName: "consul-db", Name: "db", Namespace: "default", Partition: "ap2", DNS: "db.service.default.ns.ap2.ap.test"},
+		{K8SName: "consul-web", Name: "web", Namespace: "default", Partition: "ap1", DNS: "web.service.default.ns.ap1.ap.test"},
+	}
+	require.Equal(t, expected, actual)
+}
+
+// Test that services are filtered and renamed and that they are read again
+// when the filter changes.
+func TestSource_filter(t *testing.T) {
+	t.Parallel()
+
+	// Serve services depending on the filter. Blocking queries block until
+	// the request is cancelled.
+	var lock sync.Mutex
+	var filters []string
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		filter := r.URL.Query().Get("filter")
+		lock.Lock()
+		filters = append(filters, filter)
+		lock.Unlock()
+
+		if r.URL.Query().Get("index") == "2" {
+			<-r.Context().Done()
+			return
+		}
+		services := map[string][]string{
+			"web":        {"public"},
+			"web-admin":  {"public"},
+			"legacy-api": {"public"},
+			"legacy-db":  {"public", toconsul.TestConsulK8STag},
+		}
+		if strings.Contains(filter, "ServiceMeta.team") {
+			services = map[string][]string{"legacy-api": {"public"}}
+		}
+		w.Header().Set("X-Consul-Index", "2")
+		require.NoError(t, json.NewEncoder(w).Encode(services))
+	}))
+	defer consulServer.Close()
+
+	client, err := api.NewClient(&api.Config{Address: consulServer.URL})
+	require.NoError(t, err)
+
+	rule, err := ParseRenameRule("^legacy-(.*)$=$1")
+	require.NoError(t, err)
+	source, sink, closer := testSourceWithConfig(client, func(s *Source) {
+		s.AllowTags = []string{"public"}
+		s.DenyServices = []string{"*-admin"}
+		s.RenameRules = []RenameRule{rule}
+		s.Prefix = "consul-"
+	})
+	defer closer()
+
+	retry.Run(t, func(r *retry.R) {
+		sink.Lock()
+		defer sink.Unlock()
+		require.Equal(r, map[string]string{
+			"consul-web": "web.service.test",
+			"consul-api": "legacy-api.service.test",
+		}, sink.Services)
+	})
+
+	// Services that no longer match are removed.
+	require.NoError(t, source.SetFilter(`ServiceMeta.team == "a"`))
+
+	retry.Run(t, func(r *retry.R) {
+		sink.Lock()
+		defer sink.Unlock()
+		require.Equal(r, map[string]string{
+			"consul-api": "legacy-api.service.test",
+		}, sink.Services)
+	})
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Equal(t, `"public" in ServiceTags`, filters[0])
+	require.Equal(t, `("public" in ServiceTags) and (ServiceMeta.team == "a")`, filters[len(filters)-1])
+}
+
+func TestSource_filterExpression(t *testing.T) {
+	cases := map[string]struct {
+		source  *Source
+		dynamic string
+		exp     string
+	}{
+		"no filter": {
+			source: &Source{},
+			exp:    "",
+		},
+		"tags and meta keys": {
+			source: &Source{AllowTags: []string{"a", "b"}, AllowMetaKeys: []string{"team"}},
+			exp:    `"a" in ServiceTags or "b" in ServiceTags or "team" in ServiceMeta`,
+		},
+		"filter": {
+			source: &Source{Filter: `ServiceMeta.team == "a"`},
+			exp:    `ServiceMeta.team == "a"`,
+		},
+		"dynamic filter": {
+			source:  &Source{},
+			dynamic: `ServiceName matches "^web"`,
+			exp:     `ServiceName matches "^web"`,
+		},
+		"all": {
+			source:  &Source{AllowTags: []string{"a"}, Filter: `ServiceMeta.team == "a"`},
+			dynamic: `ServiceName matches "^web"`,
+			exp:     `("a" in ServiceTags) and (ServiceMeta.team == "a") and (ServiceName matches "^web")`,
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, c.source.SetFilter(c.dynamic))
+			actual, _ := c.source.filter()
+			require.Equal(t, c.exp, actual)
+		})
+	}
+}
+
+// Test that an invalid filter is rejected and the previous filter is kept.
+func TestSource_setInvalidFilter(t *testing.T) {
+	source := &Source{}
+	require.NoError(t, source.SetFilter(`ServiceMeta.team == "a"`))
+	_, filterCh := source.filter()
+
+	err := source.SetFilter(`ServiceMeta.team ==`)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `invalid filter "ServiceMeta.team =="`)
+
+	filter, _ := source.filter()
+	require.Equal(t, `ServiceMeta.team == "a"`, filter)
+	select {
+	case <-filterCh:
+		t.Fatal("filter channel closed for an invalid filter")
+	default:
+	}
+}
+
+func TestSource_allowed(t *testing.T) {
+	s := &Source{
+		AllowServices: []string{"web*", "api"},
+		DenyServices:  []string{"*-canary"},
+	}
+	require.True(t, s.allowed("web"))
+	require.True(t, s.allowed("web-frontend"))
+	require.True(t, s.allowed("api"))
+	require.False(t, s.allowed("api-v2"))
+	require.False(t, s.allowed("web-canary"))
+	require.True(t, (&Source{}).allowed("anything"))
+}
+
+func TestParseRenameRule(t *testing.T) {
+	rule, err := ParseRenameRule("^(.*)-v[0-9]+$=$1")
+	require.NoError(t, err)
+
+	s := &Source{Prefix: "consul-", RenameRules: []RenameRule{rule}}
+	require.Equal(t, "consul-api", s.k8sName("api-v2"))
+	require.Equal(t, "consul-web", s.k8sName("web"))
+
+	_, err = ParseRenameRule("no-replacement")
+	require.EqualError(t, err, `rename rule "no-replacement" must be in the form <pattern>=<replacement>`)
+
+	_, err = ParseRenameRule("(=foo")
+	require.Error(t, err)
+}
+
 // testSource creates a Source and Sink for testing.
 func testSource(client *api.Client) (*Source, *TestSink, func()) {
 	return testSourceWithConfig(client, func(source *Source) {})
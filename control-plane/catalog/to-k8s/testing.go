@@ -8,7 +8,8 @@ import (
 // Reading/writing the services should be done only while the lock is held.
 type TestSink struct {
 	sync.Mutex
-	Services map[string]string
+	Services       map[string]string
+	ConsulServices []ConsulService
 }
 
 func (s *TestSink) SetServices(raw map[string]string) {
@@ -16,3 +17,9 @@ func (s *TestSink) SetServices(raw map[string]string) {
 	defer s.Unlock()
 	s.Services = raw
 }
+
+func (s *TestSink) SetConsulServices(raw []ConsulService) {
+	s.Lock()
+	defer s.Unlock()
+	s.ConsulServices = raw
+}
@@ -15,6 +15,8 @@ import (
 	cmdInjectConnect "github.com/hashicorp/consul-k8s/control-plane/subcommand/inject-connect"
 	cmdInstallCNI "github.com/hashicorp/consul-k8s/control-plane/subcommand/install-cni"
 	cmdPartitionInit "github.com/hashicorp/consul-k8s/control-plane/subcommand/partition-init"
+	cmdRotateBootstrapToken "github.com/hashicorp/consul-k8s/control-plane/subcommand/rotate-bootstrap-token"
+	cmdRotateGossipKey "github.com/hashicorp/consul-k8s/control-plane/subcommand/rotate-gossip-key"
 	cmdServerACLInit "github.com/hashicorp/consul-k8s/control-plane/subcommand/server-acl-init"
 	cmdServiceAddress "github.com/hashicorp/consul-k8s/control-plane/subcommand/service-address"
 	cmdSyncCatalog "github.com/hashicorp/consul-k8s/control-plane/subcommand/sync-catalog"
@@ -60,6 +62,14 @@ func init() {
 			return &cmdPartitionInit.Command{UI: ui}, nil
 		},
 
+		"rotate-bootstrap-token": func() (cli.Command, error) {
+			return &cmdRotateBootstrapToken.Command{UI: ui}, nil
+		},
+
+		"rotate-gossip-key": func() (cli.Command, error) {
+			return &cmdRotateGossipKey.Command{UI: ui}, nil
+		},
+
 		"sync-catalog": func() (cli.Command, error) {
 			return &cmdSyncCatalog.Command{UI: ui}, nil
 		},
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"text/template"
@@ -198,15 +199,25 @@ func (w *MeshWebhook) containerInit(namespace corev1.Namespace, pod corev1.Pod,
 		} else {
 			data.ServiceAccountName = pod.Spec.ServiceAccountName
 		}
-		// Extract the service account token's volume mount
-		saTokenVolumeMount, bearerTokenFile, err := findServiceAccountVolumeMount(pod, multiPort, mpi.serviceName)
-		if err != nil {
-			return corev1.Container{}, err
-		}
-		data.BearerTokenFile = bearerTokenFile
+		if w.AuthMethodJWTAudience != "" {
+			// Log in with the projected token added to the pod.
+			volMounts = append(volMounts, corev1.VolumeMount{
+				Name:      authMethodTokenVolumeName,
+				ReadOnly:  true,
+				MountPath: authMethodTokenMountPath,
+			})
+			data.BearerTokenFile = filepath.Join(authMethodTokenMountPath, "token")
+		} else {
+			// Extract the service account token's volume mount
+			saTokenVolumeMount, bearerTokenFile, err := findServiceAccountVolumeMount(pod, multiPort, mpi.serviceName)
+			if err != nil {
+				return corev1.Container{}, err
+			}
+			data.BearerTokenFile = bearerTokenFile
 
-		// Append to volume mounts
-		volMounts = append(volMounts, saTokenVolumeMount)
+			// Append to volume mounts
+			volMounts = append(volMounts, saTokenVolumeMount)
+		}
 	}
 
 	// This determines how to configure the consul connect envoy command: what
@@ -293,6 +304,12 @@ func (w *MeshWebhook) containerInit(namespace corev1.Namespace, pod corev1.Pod,
 					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.namespace"},
 				},
 			},
+			{
+				Name: "POD_UID",
+				ValueFrom: &corev1.EnvVarSource{
+					FieldRef: &corev1.ObjectFieldSelector{FieldPath: "metadata.uid"},
+				},
+			},
 		},
 		Resources:    w.InitContainerResources,
 		VolumeMounts: volMounts,
@@ -402,6 +419,7 @@ consul-k8s-control-plane connect-init -pod-name=${POD_NAME} -pod-namespace=${POD
   -service-account-name="{{ .ServiceAccountName }}" \
   -service-name="{{ .ServiceName }}" \
   -bearer-token-file={{ .BearerTokenFile }} \
+  -pod-uid=${POD_UID} \
   {{- if .MultiPort }}
   -acl-token-sink=/consul/connect-inject/acl-token-{{ .ServiceName }} \
   {{- end }}
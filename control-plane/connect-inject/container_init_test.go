@@ -724,6 +724,7 @@ consul-k8s-control-plane connect-init -pod-name=${POD_NAME} -pod-namespace=${POD
   -service-account-name="web" \
   -service-name="" \
   -bearer-token-file=/var/run/secrets/kubernetes.io/serviceaccount/token \
+  -pod-uid=${POD_UID} \
   -auth-method-namespace="non-default" \
   -partition="default" \
   -consul-service-namespace="non-default" \
@@ -759,6 +760,7 @@ consul-k8s-control-plane connect-init -pod-name=${POD_NAME} -pod-namespace=${POD
   -service-account-name="web" \
   -service-name="" \
   -bearer-token-file=/var/run/secrets/kubernetes.io/serviceaccount/token \
+  -pod-uid=${POD_UID} \
   -auth-method-namespace="default" \
   -partition="non-default" \
   -consul-service-namespace="k8snamespace" \
@@ -863,6 +865,7 @@ consul-k8s-control-plane connect-init -pod-name=${POD_NAME} -pod-namespace=${POD
   -service-account-name="web" \
   -service-name="web" \
   -bearer-token-file=/var/run/secrets/kubernetes.io/serviceaccount/token \
+  -pod-uid=${POD_UID} \
   -auth-method-namespace="default" \
   -partition="non-default" \
   -consul-service-namespace="k8snamespace" \
@@ -1028,6 +1031,7 @@ consul-k8s-control-plane connect-init -pod-name=${POD_NAME} -pod-namespace=${POD
   -service-account-name="web" \
   -service-name="web" \
   -bearer-token-file=/var/run/secrets/kubernetes.io/serviceaccount/token \
+  -pod-uid=${POD_UID} \
   -acl-token-sink=/consul/connect-inject/acl-token-web \
   -multiport=true \
   -proxy-id-file=/consul/connect-inject/proxyid-web \
@@ -1048,6 +1052,7 @@ consul-k8s-control-plane connect-init -pod-name=${POD_NAME} -pod-namespace=${POD
   -service-account-name="web-admin" \
   -service-name="web-admin" \
   -bearer-token-file=/consul/serviceaccount-web-admin/token \
+  -pod-uid=${POD_UID} \
   -acl-token-sink=/consul/connect-inject/acl-token-web-admin \
   -multiport=true \
   -proxy-id-file=/consul/connect-inject/proxyid-web-admin \
@@ -1121,6 +1126,46 @@ consul-k8s-control-plane connect-init -pod-name=${POD_NAME} -pod-namespace=${POD
   -bootstrap > /consul/connect-inject/envoy-bootstrap.yaml`)
 }
 
+// If the auth method is a jwt auth method, connect-init logs in with the
+// projected service account token instead of the service account's token.
+func TestHandlerContainerInit_authMethodJWT(t *testing.T) {
+	require := require.New(t)
+	w := MeshWebhook{
+		AuthMethod:            "release-name-consul-k8s-auth-method",
+		AuthMethodJWTAudience: "consul",
+		ConsulAPITimeout:      5 * time.Second,
+	}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Annotations: map[string]string{
+				annotationService: "foo",
+			},
+		},
+
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{
+					Name: "web",
+				},
+			},
+			ServiceAccountName: "foo",
+		},
+	}
+	container, err := w.containerInit(testNS, *pod, multiPortInfo{})
+	require.NoError(err)
+	require.Contains(container.VolumeMounts, corev1.VolumeMount{
+		Name:      authMethodTokenVolumeName,
+		ReadOnly:  true,
+		MountPath: "/consul/acl-auth-method",
+	})
+	actual := strings.Join(container.Command, " ")
+	require.Contains(actual, `
+  -acl-auth-method="release-name-consul-k8s-auth-method" \
+  -service-account-name="foo" \
+  -service-name="foo" \
+  -bearer-token-file=/consul/acl-auth-method/token \`)
+}
+
 // If Consul CA cert is set,
 // Consul addresses should use HTTPS
 // and CA cert should be set as env variable.
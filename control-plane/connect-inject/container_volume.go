@@ -18,3 +18,35 @@ func (w *MeshWebhook) containerVolume() corev1.Volume {
 		},
 	}
 }
+
+const (
+	// authMethodTokenVolumeName is the name of the volume that holds the
+	// projected service account token used to log in to a jwt auth method.
+	authMethodTokenVolumeName = "consul-connect-inject-acl-auth-method"
+	authMethodTokenMountPath  = "/consul/acl-auth-method"
+)
+
+// authMethodTokenVolume returns the volume with a service account token
+// projected for the audience of the jwt auth method.
+func (w *MeshWebhook) authMethodTokenVolume() corev1.Volume {
+	var expirationSeconds *int64
+	if w.AuthMethodJWTExpirySeconds != 0 {
+		expirationSeconds = &w.AuthMethodJWTExpirySeconds
+	}
+	return corev1.Volume{
+		Name: authMethodTokenVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{
+					{
+						ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+							Audience:          w.AuthMethodJWTAudience,
+							ExpirationSeconds: expirationSeconds,
+							Path:              "token",
+						},
+					},
+				},
+			},
+		},
+	}
+}
@@ -34,10 +34,14 @@ import (
 
 const (
 	MetaKeyPodName             = "pod-name"
+	MetaKeyPodUID              = "pod-uid"
 	MetaKeyKubeServiceName     = "k8s-service-name"
 	MetaKeyKubeNS              = "k8s-namespace"
 	MetaKeyManagedBy           = "managed-by"
+	MetaKeyKubeNodeName        = "k8s-node-name"
+	MetaKeyKubeClusterID       = "k8s-cluster-id"
 	TokenMetaPodNameKey        = "pod"
+	TokenMetaPodUIDKey         = "pod-uid"
 	kubernetesSuccessReasonMsg = "Kubernetes health checks passing"
 	envoyPrometheusBindAddr    = "envoy_prometheus_bind_addr"
 	envoySidecarContainer      = "envoy-sidecar"
@@ -103,6 +107,9 @@ type EndpointsController struct {
 	ReleaseName string
 	// ReleaseNamespace is the namespace where Consul is installed.
 	ReleaseNamespace string
+	// ClusterID identifies the Kubernetes cluster. It is recorded in the meta
+	// of the service instances.
+	ClusterID string
 	// EnableTransparentProxy controls whether transparent proxy should be enabled
 	// for all proxy service registrations.
 	EnableTransparentProxy bool
@@ -438,6 +445,19 @@ func (r *EndpointsController) createServiceRegistrations(pod corev1.Pod, service
 		MetaKeyKubeNS:          serviceEndpoints.Namespace,
 		MetaKeyManagedBy:       managedByValue,
 	}
+	// The UID tells the garbage collector apart a pod from a later pod with
+	// the same name, e.g. of a StatefulSet.
+	if pod.UID != "" {
+		meta[MetaKeyPodUID] = string(pod.UID)
+	}
+	// The cluster and node tell the garbage collector apart the instances of
+	// this cluster on a failed node from those of other clusters.
+	if r.ClusterID != "" {
+		meta[MetaKeyKubeClusterID] = r.ClusterID
+	}
+	if pod.Spec.NodeName != "" {
+		meta[MetaKeyKubeNodeName] = pod.Spec.NodeName
+	}
 	for k, v := range pod.Annotations {
 		if strings.HasPrefix(k, annotationMeta) && strings.TrimPrefix(k, annotationMeta) != "" {
 			if v == "$POD_NAME" {
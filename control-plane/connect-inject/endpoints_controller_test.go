@@ -5845,6 +5845,51 @@ func TestCreateServiceRegistrations_withTransparentProxy(t *testing.T) {
 	}
 }
 
+// Test that the UID of the pod is recorded in the meta of its service
+// instances so that the garbage collector can tell it apart from a later pod
+// with the same name, and that the cluster and node are recorded so that it
+// can tell apart the instances of other clusters.
+func TestCreateServiceRegistrations_podUID(t *testing.T) {
+	t.Parallel()
+	pod := createPod("pod1", "1.2.3.4", true, true)
+	pod.UID = "pod1-uid"
+	pod.Spec.NodeName = "node1"
+	endpoints := &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "service-created",
+			Namespace: "default",
+		},
+		Subsets: []corev1.EndpointSubset{
+			{
+				Addresses: []corev1.EndpointAddress{
+					{
+						IP: "1.2.3.4",
+						TargetRef: &corev1.ObjectReference{
+							Kind:      "Pod",
+							Name:      pod.Name,
+							Namespace: pod.Namespace,
+						},
+					},
+				},
+			},
+		},
+	}
+	ns := corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: pod.Namespace}}
+	epCtrl := EndpointsController{
+		Client:    fake.NewClientBuilder().WithRuntimeObjects(pod, endpoints, &ns).Build(),
+		ClusterID: "cluster-id",
+		Log:       logrtest.TestLogger{T: t},
+	}
+
+	serviceRegistration, proxyServiceRegistration, err := epCtrl.createServiceRegistrations(*pod, *endpoints)
+	require.NoError(t, err)
+	for _, registration := range []*api.AgentServiceRegistration{serviceRegistration, proxyServiceRegistration} {
+		require.Equal(t, "pod1-uid", registration.Meta[MetaKeyPodUID])
+		require.Equal(t, "cluster-id", registration.Meta[MetaKeyKubeClusterID])
+		require.Equal(t, "node1", registration.Meta[MetaKeyKubeNodeName])
+	}
+}
+
 func TestGetTokenMetaFromDescription(t *testing.T) {
 	t.Parallel()
 	cases := map[string]struct {
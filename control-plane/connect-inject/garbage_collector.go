@@ -0,0 +1,496 @@
+package connectinject
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	mapset "github.com/deckarep/golang-set"
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/consul-k8s/control-plane/consul"
+	"github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// orphanKindACLToken and orphanKindService are the values of the "kind"
+	// label of the garbage collector metrics.
+	orphanKindACLToken = "acl-token"
+	orphanKindService  = "service"
+
+	// serfHealthCheckID is the ID of the check that Consul uses to track
+	// whether the agent on a node is alive.
+	serfHealthCheckID = "serfHealth"
+)
+
+// GarbageCollector periodically deletes the ACL tokens and service instances
+// that were created for pods that no longer exist. The endpoints controller
+// deletes them when it sees the pod go away, but they are left behind if the
+// controller is not running while pods churn or if a node disappears along
+// with its Consul client agent.
+//
+// A token or service instance is only deleted once its pod has been missing
+// for GracePeriod so that pods that are still starting up, and objects that
+// the endpoints controller is about to delete itself, are left alone.
+type GarbageCollector struct {
+	// Client is the Kubernetes client.
+	Client client.Client
+	// ConsulClient points at the agent local to the connect-inject deployment pod.
+	ConsulClient *api.Client
+	// ConsulClientCfg is the client config used to create clients for the
+	// Consul client agents.
+	ConsulClientCfg *api.Config
+	// ConsulScheme is the scheme to use when making API calls to the Consul
+	// client agents, i.e. "http" or "https".
+	ConsulScheme string
+	// ConsulPort is the port to make HTTP API calls to the Consul client
+	// agents on.
+	ConsulPort string
+	// ConsulAPITimeout is the timeout of the clients for the Consul client agents.
+	ConsulAPITimeout time.Duration
+	// EnableConsulNamespaces indicates that a user is running Consul Enterprise
+	// with namespaces, in which case tokens and services are looked up in
+	// every Consul namespace.
+	EnableConsulNamespaces bool
+	// ConsulPartition is the Consul admin partition of this cluster, if
+	// partitions are enabled. Failed nodes are only looked up in it.
+	ConsulPartition string
+	// ClusterID identifies the Kubernetes cluster. Service instances on failed
+	// nodes are only deregistered if the endpoints controller of this cluster
+	// recorded it in their meta, since the nodes of other clusters can fail
+	// in the same datacenter.
+	ClusterID string
+	// AllowK8sNamespacesSet and DenyK8sNamespacesSet are the Kubernetes
+	// namespaces that the endpoints controller registers services from.
+	// Service instances on failed nodes from other namespaces are kept.
+	AllowK8sNamespacesSet mapset.Set
+	DenyK8sNamespacesSet  mapset.Set
+	// ReleaseName is the Consul Helm installation release.
+	ReleaseName string
+	// ReleaseNamespace is the namespace where Consul is installed.
+	ReleaseNamespace string
+	// AuthMethod is the name of the auth method that connect-inject pods
+	// log in with. If empty, ACL tokens are not collected.
+	AuthMethod string
+	// Interval is the time between two collections.
+	Interval time.Duration
+	// GracePeriod is how long the pod of a token or service instance must
+	// have been missing before it is deleted.
+	GracePeriod time.Duration
+	// Registerer registers the garbage collector metrics.
+	Registerer prometheus.Registerer
+	Log        logr.Logger
+
+	metrics *gcMetrics
+	// orphans holds the time each token or service instance was first seen
+	// without a pod, keyed by orphanKey.
+	orphans map[string]time.Time
+	// now returns the current time. It is overridden in tests.
+	now func() time.Time
+}
+
+type gcMetrics struct {
+	lastRun  prometheus.Gauge
+	orphans  *prometheus.GaugeVec
+	deleted  *prometheus.CounterVec
+	failures prometheus.Counter
+}
+
+func newGCMetrics(reg prometheus.Registerer) *gcMetrics {
+	m := &gcMetrics{
+		lastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "consul_connect_inject_gc_last_run_timestamp_seconds",
+			Help: "The Unix time of the last successful run of the orphaned ACL token and service garbage collector.",
+		}),
+		orphans: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "consul_connect_inject_gc_orphans",
+			Help: "The number of ACL tokens and service instances whose pod is missing but that are still in their grace period.",
+		}, []string{"kind"}),
+		deleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "consul_connect_inject_gc_deleted_total",
+			Help: "The number of orphaned ACL tokens and service instances deleted by the garbage collector.",
+		}, []string{"kind"}),
+		failures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "consul_connect_inject_gc_failures_total",
+			Help: "The number of runs of the orphaned ACL token and service garbage collector that failed.",
+		}),
+	}
+	reg.MustRegister(m.lastRun, m.orphans, m.deleted, m.failures)
+	return m
+}
+
+// Start runs the garbage collector every Interval until ctx is cancelled. It
+// implements manager.Runnable so that it only runs on the leader.
+func (g *GarbageCollector) Start(ctx context.Context) error {
+	g.init()
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	for {
+		if err := g.collect(ctx); err != nil {
+			g.metrics.failures.Inc()
+			g.Log.Error(err, "failed to collect orphaned ACL tokens and services")
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+func (g *GarbageCollector) init() {
+	if g.metrics == nil {
+		g.metrics = newGCMetrics(g.Registerer)
+	}
+	if g.orphans == nil {
+		g.orphans = make(map[string]time.Time)
+	}
+	if g.now == nil {
+		g.now = time.Now
+	}
+}
+
+// collect deletes the orphaned ACL tokens and service instances once. An
+// error for one token or service instance does not stop the others from being
+// collected.
+func (g *GarbageCollector) collect(ctx context.Context) error {
+	// seen holds the keys of the orphans found in this run so that objects
+	// that have since been deleted, or whose pod came back, are forgotten.
+	seen := make(map[string]bool)
+	var errs []error
+
+	if g.AuthMethod != "" {
+		if err := g.collectACLTokens(ctx, seen); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := g.collectAgentServices(ctx, seen); err != nil {
+		errs = append(errs, err)
+	}
+	if err := g.collectFailedNodeServices(ctx, seen); err != nil {
+		errs = append(errs, err)
+	}
+
+	// Only forget orphans if every list succeeded, otherwise their grace
+	// period would start again.
+	if len(errs) == 0 {
+		for key := range g.orphans {
+			if !seen[key] {
+				delete(g.orphans, key)
+			}
+		}
+	}
+	g.updateOrphanGauge()
+
+	if len(errs) > 0 {
+		return firstError(errs)
+	}
+	g.metrics.lastRun.Set(float64(g.now().Unix()))
+	return nil
+}
+
+// collectACLTokens deletes the tokens created by AuthMethod whose pod no
+// longer exists.
+func (g *GarbageCollector) collectACLTokens(ctx context.Context, seen map[string]bool) error {
+	tokens, _, err := g.ConsulClient.ACL().TokenList(g.allNamespacesQueryOptions())
+	if err != nil {
+		return fmt.Errorf("failed to get a list of tokens from Consul: %s", err)
+	}
+
+	var errs []error
+	for _, token := range tokens {
+		if token.AuthMethod != g.AuthMethod {
+			continue
+		}
+		tokenMeta, err := getTokenMetaFromDescription(token.Description)
+		if err != nil {
+			// Tokens created without the pod meta can't be matched to a pod.
+			g.Log.Info("skipping ACL token without pod metadata", "accessor-id", token.AccessorID)
+			continue
+		}
+		podKey := tokenMeta[TokenMetaPodNameKey]
+		orphan, err := g.isOrphan(ctx, podKey, tokenMeta[TokenMetaPodUIDKey])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !orphan {
+			continue
+		}
+
+		key := orphanKey(orphanKindACLToken, token.Namespace, token.AccessorID)
+		seen[key] = true
+		if !g.gracePeriodExpired(key) {
+			continue
+		}
+		g.Log.Info("deleting orphaned ACL token", "accessor-id", token.AccessorID, "pod", podKey)
+		if _, err := g.ConsulClient.ACL().TokenDelete(token.AccessorID, &api.WriteOptions{Namespace: token.Namespace}); err != nil {
+			errs = append(errs, fmt.Errorf("failed to delete token from Consul: %s", err))
+			continue
+		}
+		g.deleted(orphanKindACLToken, key)
+	}
+	return firstError(errs)
+}
+
+// collectAgentServices deregisters the service instances whose pod no longer
+// exists from every ready Consul client agent.
+func (g *GarbageCollector) collectAgentServices(ctx context.Context, seen map[string]bool) error {
+	agents := corev1.PodList{}
+	listOptions := client.ListOptions{
+		Namespace: g.ReleaseNamespace,
+		LabelSelector: labels.SelectorFromSet(map[string]string{
+			"component": "client",
+			"app":       "consul",
+			"release":   g.ReleaseName,
+		}),
+	}
+	if err := g.Client.List(ctx, &agents, &listOptions); err != nil {
+		return fmt.Errorf("failed to get Consul client agent pods: %s", err)
+	}
+
+	var errs []error
+	for _, agent := range agents.Items {
+		if !isReady(agent) {
+			// Services on agents that are gone for good are collected
+			// through the catalog once the agent's node is marked failed.
+			continue
+		}
+		agentClient, err := g.remoteConsulClient(agent.Status.PodIP)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to create a new Consul client for %s: %s", agent.Status.PodIP, err))
+			continue
+		}
+		svcs, err := agentClient.Agent().ServicesWithFilterOpts(managedByFilter(), g.allNamespacesQueryOptions())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get services from Consul client agent %s: %s", agent.Name, err))
+			continue
+		}
+
+		for _, svc := range svcs {
+			podKey := podKeyFromServiceMeta(svc.Meta)
+			orphan, err := g.isOrphan(ctx, podKey, svc.Meta[MetaKeyPodUID])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if !orphan {
+				continue
+			}
+
+			key := orphanKey(orphanKindService, agent.Name, svc.Namespace, svc.ID)
+			seen[key] = true
+			if !g.gracePeriodExpired(key) {
+				continue
+			}
+			g.Log.Info("deregistering orphaned service instance", "svc", svc.ID, "consul-agent", agent.Name, "pod", podKey)
+			err = agentClient.Agent().ServiceDeregisterOpts(svc.ID, &api.QueryOptions{Namespace: svc.Namespace})
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to deregister service instance %s: %s", svc.ID, err))
+				continue
+			}
+			g.deleted(orphanKindService, key)
+		}
+	}
+	return firstError(errs)
+}
+
+// collectFailedNodeServices deregisters the service instances whose pod no
+// longer exists from the catalog of nodes whose agent has failed. These are
+// left behind when a Kubernetes node disappears along with its Consul client
+// agent, and are otherwise only removed when Consul reaps the node. Only the
+// instances registered by this cluster are considered, see
+// registeredByCluster.
+func (g *GarbageCollector) collectFailedNodeServices(ctx context.Context, seen map[string]bool) error {
+	if g.ClusterID == "" {
+		// The instances of this cluster can't be told apart from those of
+		// other clusters.
+		return nil
+	}
+	checks, _, err := g.ConsulClient.Health().State(api.HealthCritical, &api.QueryOptions{
+		Filter:    fmt.Sprintf("CheckID == %q", serfHealthCheckID),
+		Partition: g.ConsulPartition,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get failed nodes from Consul: %s", err)
+	}
+
+	var errs []error
+	for _, check := range checks {
+		node := check.Node
+		opts := g.allNamespacesQueryOptions()
+		opts.Filter = managedByFilter()
+		opts.Partition = g.ConsulPartition
+		services, _, err := g.ConsulClient.Catalog().NodeServiceList(node, opts)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to get services of node %s from Consul: %s", node, err))
+			continue
+		}
+		if services == nil {
+			continue
+		}
+
+		for _, svc := range services.Services {
+			if !g.registeredByCluster(node, svc) {
+				continue
+			}
+			podKey := podKeyFromServiceMeta(svc.Meta)
+			orphan, err := g.isOrphan(ctx, podKey, svc.Meta[MetaKeyPodUID])
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			if !orphan {
+				continue
+			}
+
+			key := orphanKey(orphanKindService, node, svc.Namespace, svc.ID)
+			seen[key] = true
+			if !g.gracePeriodExpired(key) {
+				continue
+			}
+			g.Log.Info("deregistering orphaned service instance from failed node", "svc", svc.ID, "node", node, "pod", podKey)
+			_, err = g.ConsulClient.Catalog().Deregister(&api.CatalogDeregistration{
+				Node:      node,
+				ServiceID: svc.ID,
+				Namespace: svc.Namespace,
+				Partition: g.ConsulPartition,
+			}, nil)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to deregister service instance %s from node %s: %s", svc.ID, node, err))
+				continue
+			}
+			g.deleted(orphanKindService, key)
+		}
+	}
+	return firstError(errs)
+}
+
+// registeredByCluster returns true if the service instance on the failed node
+// was registered by the endpoints controller of this cluster for a pod on that
+// node. The pods of other clusters don't exist in this cluster, so their
+// instances would otherwise be orphans.
+func (g *GarbageCollector) registeredByCluster(node string, svc *api.AgentService) bool {
+	if svc.Meta[MetaKeyKubeClusterID] != g.ClusterID || svc.Meta[MetaKeyKubeNodeName] != node {
+		return false
+	}
+	return !shouldIgnore(svc.Meta[MetaKeyKubeNS], g.DenyK8sNamespacesSet, g.AllowK8sNamespacesSet)
+}
+
+// isOrphan returns true if the pod with the given "namespace/name" key does
+// not exist, or if it was replaced by a pod with the same name but a UID other
+// than podUID. Objects created before the UID was recorded have an empty
+// podUID and are only matched by name. Objects without a pod key are never
+// orphans.
+func (g *GarbageCollector) isOrphan(ctx context.Context, podKey, podUID string) (bool, error) {
+	name, ok := parsePodKey(podKey)
+	if !ok {
+		return false, nil
+	}
+	var pod corev1.Pod
+	err := g.Client.Get(ctx, name, &pod)
+	if k8serrors.IsNotFound(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get pod %s: %s", podKey, err)
+	}
+	return podUID != "" && string(pod.UID) != podUID, nil
+}
+
+// gracePeriodExpired records when the orphan with the given key was first
+// seen and returns true once it has been an orphan for GracePeriod.
+func (g *GarbageCollector) gracePeriodExpired(key string) bool {
+	firstSeen, ok := g.orphans[key]
+	if !ok {
+		firstSeen = g.now()
+		g.orphans[key] = firstSeen
+	}
+	return g.now().Sub(firstSeen) >= g.GracePeriod
+}
+
+func (g *GarbageCollector) deleted(kind, key string) {
+	delete(g.orphans, key)
+	g.metrics.deleted.WithLabelValues(kind).Inc()
+}
+
+func (g *GarbageCollector) updateOrphanGauge() {
+	counts := map[string]int{orphanKindACLToken: 0, orphanKindService: 0}
+	for key := range g.orphans {
+		counts[strings.SplitN(key, "/", 2)[0]]++
+	}
+	for kind, count := range counts {
+		g.metrics.orphans.WithLabelValues(kind).Set(float64(count))
+	}
+}
+
+// allNamespacesQueryOptions returns query options that select objects in
+// every Consul namespace when namespaces are enabled.
+func (g *GarbageCollector) allNamespacesQueryOptions() *api.QueryOptions {
+	if g.EnableConsulNamespaces {
+		return &api.QueryOptions{Namespace: "*"}
+	}
+	return &api.QueryOptions{}
+}
+
+func (g *GarbageCollector) remoteConsulClient(ip string) (*api.Client, error) {
+	localConfig := *g.ConsulClientCfg
+	localConfig.Address = fmt.Sprintf("%s://%s:%s", g.ConsulScheme, ip, g.ConsulPort)
+	return consul.NewClient(&localConfig, g.ConsulAPITimeout)
+}
+
+// managedByFilter selects the service instances registered by the endpoints
+// controller.
+func managedByFilter() string {
+	return fmt.Sprintf(`Meta[%q] == %q`, MetaKeyManagedBy, managedByValue)
+}
+
+// podKeyFromServiceMeta returns the "namespace/name" key of the pod of a
+// service instance registered by the endpoints controller.
+func podKeyFromServiceMeta(meta map[string]string) string {
+	if meta[MetaKeyKubeNS] == "" || meta[MetaKeyPodName] == "" {
+		return ""
+	}
+	return meta[MetaKeyKubeNS] + "/" + meta[MetaKeyPodName]
+}
+
+// parsePodKey parses a "namespace/name" pod key.
+func parsePodKey(podKey string) (types.NamespacedName, bool) {
+	parts := strings.SplitN(podKey, "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return types.NamespacedName{}, false
+	}
+	return types.NamespacedName{Namespace: parts[0], Name: parts[1]}, true
+}
+
+// orphanKey returns the key of an orphan in GarbageCollector.orphans. The
+// kind is always the first element.
+func orphanKey(kind string, parts ...string) string {
+	return strings.Join(append([]string{kind}, parts...), "/")
+}
+
+// isReady returns true if the pod's Ready condition is true.
+func isReady(pod corev1.Pod) bool {
+	for _, cond := range pod.Status.Conditions {
+		if cond.Type == corev1.PodReady {
+			return cond.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func firstError(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return fmt.Errorf("%s (and %d more errors)", errs[0], len(errs)-1)
+}
@@ -0,0 +1,393 @@
+package connectinject
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	mapset "github.com/deckarep/golang-set"
+	logrtest "github.com/go-logr/logr/testing"
+	"github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// Test that tokens and service instances whose pod is missing are deleted
+// once the grace period has passed and that everything else is kept.
+func TestGarbageCollector_DeletesOrphansAfterGracePeriod(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsulGC(t)
+	consul.tokens = []*api.ACLTokenListEntry{
+		{AccessorID: "live-token", AuthMethod: "auth-method", Description: tokenDescription("default/live")},
+		{AccessorID: "orphan-token", AuthMethod: "auth-method", Description: tokenDescription("default/gone")},
+		{AccessorID: "other-auth-method", AuthMethod: "other", Description: tokenDescription("default/gone")},
+		{AccessorID: "no-meta", AuthMethod: "auth-method", Description: "token created via login"},
+	}
+	consul.agentServices = map[string]*api.AgentService{
+		"live-svc":   {ID: "live-svc", Meta: serviceMeta("default", "live")},
+		"orphan-svc": {ID: "orphan-svc", Meta: serviceMeta("default", "gone")},
+	}
+	consul.failedNodes = map[string][]*api.AgentService{
+		"failed-node": {
+			{ID: "failed-live-svc", Meta: failedNodeServiceMeta("default", "live", "failed-node")},
+			{ID: "failed-orphan-svc", Meta: failedNodeServiceMeta("default", "gone", "failed-node")},
+		},
+	}
+	gc, now := consul.garbageCollector(t, createPod("live", "1.2.3.4", true, true), consulAgentPod("consul-client-abcde"))
+
+	// Orphans are not deleted during the grace period.
+	require.NoError(t, gc.collect(context.Background()))
+	require.Empty(t, consul.deletedTokens())
+	require.Empty(t, consul.deregistered())
+	require.Equal(t, 1.0, promtestutil.ToFloat64(gc.metrics.orphans.WithLabelValues(orphanKindACLToken)))
+	require.Equal(t, 2.0, promtestutil.ToFloat64(gc.metrics.orphans.WithLabelValues(orphanKindService)))
+
+	*now = now.Add(time.Minute)
+	require.NoError(t, gc.collect(context.Background()))
+	require.Equal(t, []string{"orphan-token"}, consul.deletedTokens())
+	require.ElementsMatch(t, []string{"agent/orphan-svc", "catalog/failed-node/failed-orphan-svc"}, consul.deregistered())
+	require.Equal(t, 1.0, promtestutil.ToFloat64(gc.metrics.deleted.WithLabelValues(orphanKindACLToken)))
+	require.Equal(t, 2.0, promtestutil.ToFloat64(gc.metrics.deleted.WithLabelValues(orphanKindService)))
+	require.Equal(t, 0.0, promtestutil.ToFloat64(gc.metrics.orphans.WithLabelValues(orphanKindACLToken)))
+	require.Equal(t, 0.0, promtestutil.ToFloat64(gc.metrics.orphans.WithLabelValues(orphanKindService)))
+	require.Equal(t, float64(now.Unix()), promtestutil.ToFloat64(gc.metrics.lastRun))
+}
+
+// Test that an orphan whose pod comes back during the grace period is not
+// deleted and that its grace period starts again if the pod goes away.
+func TestGarbageCollector_PodReturnsDuringGracePeriod(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsulGC(t)
+	consul.tokens = []*api.ACLTokenListEntry{
+		{AccessorID: "token", AuthMethod: "auth-method", Description: tokenDescription("default/web")},
+	}
+	gc, now := consul.garbageCollector(t)
+
+	require.NoError(t, gc.collect(context.Background()))
+	require.Len(t, gc.orphans, 1)
+
+	pod := createPod("web", "1.2.3.4", true, true)
+	require.NoError(t, gc.Client.Create(context.Background(), pod))
+	*now = now.Add(time.Minute)
+	require.NoError(t, gc.collect(context.Background()))
+	require.Empty(t, gc.orphans)
+	require.Empty(t, consul.deletedTokens())
+
+	require.NoError(t, gc.Client.Delete(context.Background(), pod))
+	require.NoError(t, gc.collect(context.Background()))
+	require.Empty(t, consul.deletedTokens())
+	*now = now.Add(time.Minute)
+	require.NoError(t, gc.collect(context.Background()))
+	require.Equal(t, []string{"token"}, consul.deletedTokens())
+}
+
+// Test that a failing Consul request fails the run and that orphans found by the
+// other requests keep their grace period.
+func TestGarbageCollector_Failure(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsulGC(t)
+	consul.tokens = []*api.ACLTokenListEntry{
+		{AccessorID: "token", AuthMethod: "auth-method", Description: tokenDescription("default/web")},
+	}
+	consul.agentServices = map[string]*api.AgentService{
+		"svc": {ID: "svc", Meta: serviceMeta("default", "web")},
+	}
+	gc, now := consul.garbageCollector(t, consulAgentPod("consul-client-abcde"))
+
+	require.NoError(t, gc.collect(context.Background()))
+	require.Len(t, gc.orphans, 2)
+	lastRun := float64(now.Unix())
+
+	consul.failTokenList = true
+	*now = now.Add(time.Minute)
+	require.Error(t, gc.collect(context.Background()))
+	require.Equal(t, []string{"agent/svc"}, consul.deregistered())
+	require.Len(t, gc.orphans, 1)
+	require.Equal(t, lastRun, promtestutil.ToFloat64(gc.metrics.lastRun))
+}
+
+// Test that tokens and service instances of a pod that was replaced by a pod
+// with the same name are orphans, and that objects without a pod UID are
+// only matched by name.
+func TestGarbageCollector_MatchesPodUID(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsulGC(t)
+	consul.tokens = []*api.ACLTokenListEntry{
+		{AccessorID: "current-token", AuthMethod: "auth-method", Description: tokenDescriptionWithUID("default/web", "uid-2")},
+		{AccessorID: "replaced-token", AuthMethod: "auth-method", Description: tokenDescriptionWithUID("default/web", "uid-1")},
+		{AccessorID: "no-uid-token", AuthMethod: "auth-method", Description: tokenDescription("default/web")},
+	}
+	consul.agentServices = map[string]*api.AgentService{
+		"current-svc":  {ID: "current-svc", Meta: serviceMetaWithUID("default", "web", "uid-2")},
+		"replaced-svc": {ID: "replaced-svc", Meta: serviceMetaWithUID("default", "web", "uid-1")},
+		"no-uid-svc":   {ID: "no-uid-svc", Meta: serviceMeta("default", "web")},
+	}
+	pod := createPod("web", "1.2.3.4", true, true)
+	pod.UID = "uid-2"
+	gc, now := consul.garbageCollector(t, pod, consulAgentPod("consul-client-abcde"))
+
+	require.NoError(t, gc.collect(context.Background()))
+	*now = now.Add(time.Minute)
+	require.NoError(t, gc.collect(context.Background()))
+	require.Equal(t, []string{"replaced-token"}, consul.deletedTokens())
+	require.Equal(t, []string{"agent/replaced-svc"}, consul.deregistered())
+}
+
+// Test that only the service instances that were registered by this cluster
+// for a pod on the failed node are deregistered from failed nodes, since the
+// pods of other clusters and of ignored namespaces don't exist in this
+// cluster.
+func TestGarbageCollector_FailedNodeServicesOfThisCluster(t *testing.T) {
+	t.Parallel()
+	otherCluster := failedNodeServiceMeta("default", "gone", "failed-node")
+	otherCluster[MetaKeyKubeClusterID] = "other-cluster-id"
+	noClusterID := failedNodeServiceMeta("default", "gone", "failed-node")
+	delete(noClusterID, MetaKeyKubeClusterID)
+	consul := newFakeConsulGC(t)
+	consul.failedNodes = map[string][]*api.AgentService{
+		"failed-node": {
+			{ID: "orphan-svc", Meta: failedNodeServiceMeta("default", "gone", "failed-node")},
+			{ID: "other-cluster-svc", Meta: otherCluster},
+			{ID: "no-cluster-id-svc", Meta: noClusterID},
+			{ID: "other-node-svc", Meta: failedNodeServiceMeta("default", "gone", "other-node")},
+			{ID: "denied-namespace-svc", Meta: failedNodeServiceMeta("denied", "gone", "failed-node")},
+			{ID: "system-namespace-svc", Meta: failedNodeServiceMeta("kube-system", "gone", "failed-node")},
+		},
+	}
+	gc, now := consul.garbageCollector(t)
+	gc.DenyK8sNamespacesSet = mapset.NewSet("denied")
+
+	require.NoError(t, gc.collect(context.Background()))
+	*now = now.Add(time.Minute)
+	require.NoError(t, gc.collect(context.Background()))
+	require.Equal(t, []string{"catalog/failed-node/orphan-svc"}, consul.deregistered())
+
+	// Nothing is deregistered from failed nodes without a cluster ID.
+	consul.failedNodes["failed-node"] = append(consul.failedNodes["failed-node"],
+		&api.AgentService{ID: "orphan-svc-2", Meta: failedNodeServiceMeta("default", "gone", "failed-node")})
+	gc.ClusterID = ""
+	require.NoError(t, gc.collect(context.Background()))
+	*now = now.Add(time.Minute)
+	require.NoError(t, gc.collect(context.Background()))
+	require.Equal(t, []string{"catalog/failed-node/orphan-svc"}, consul.deregistered())
+}
+
+func TestGarbageCollector_SkipsAgentsThatAreNotReady(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsulGC(t)
+	consul.agentServices = map[string]*api.AgentService{
+		"svc": {ID: "svc", Meta: serviceMeta("default", "web")},
+	}
+	agent := consulAgentPod("consul-client-abcde")
+	agent.Status.Conditions[0].Status = corev1.ConditionFalse
+	gc, now := consul.garbageCollector(t, agent)
+
+	require.NoError(t, gc.collect(context.Background()))
+	*now = now.Add(time.Minute)
+	require.NoError(t, gc.collect(context.Background()))
+	require.Empty(t, consul.deregistered())
+}
+
+func TestParsePodKey(t *testing.T) {
+	t.Parallel()
+	cases := map[string]struct {
+		key      string
+		expected types.NamespacedName
+		ok       bool
+	}{
+		"namespace and name": {key: "default/web", expected: types.NamespacedName{Namespace: "default", Name: "web"}, ok: true},
+		"empty":              {key: ""},
+		"no namespace":       {key: "/web"},
+		"no name":            {key: "default/"},
+		"no separator":       {key: "web"},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			actual, ok := parsePodKey(c.key)
+			require.Equal(t, c.ok, ok)
+			require.Equal(t, c.expected, actual)
+		})
+	}
+}
+
+// fakeConsulGC is a Consul HTTP API that serves the ACL tokens, agent
+// services and failed nodes that the garbage collector reads and records what
+// it deletes.
+type fakeConsulGC struct {
+	*httptest.Server
+
+	mu            sync.Mutex
+	tokens        []*api.ACLTokenListEntry
+	agentServices map[string]*api.AgentService
+	failedNodes   map[string][]*api.AgentService
+	failTokenList bool
+	deleted       []string
+	deregs        []string
+}
+
+func newFakeConsulGC(t *testing.T) *fakeConsulGC {
+	f := &fakeConsulGC{}
+	f.Server = httptest.NewServer(http.HandlerFunc(f.handle))
+	t.Cleanup(f.Close)
+	return f
+}
+
+func (f *fakeConsulGC) handle(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.URL.Path == "/v1/acl/tokens":
+		if f.failTokenList {
+			http.Error(w, "rpc error", http.StatusInternalServerError)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(f.tokens)
+	case strings.HasPrefix(r.URL.Path, "/v1/acl/token/") && r.Method == http.MethodDelete:
+		id := strings.TrimPrefix(r.URL.Path, "/v1/acl/token/")
+		f.deleted = append(f.deleted, id)
+		for i, token := range f.tokens {
+			if token.AccessorID == id {
+				f.tokens = append(f.tokens[:i], f.tokens[i+1:]...)
+				break
+			}
+		}
+		_ = json.NewEncoder(w).Encode(true)
+	case r.URL.Path == "/v1/agent/services":
+		_ = json.NewEncoder(w).Encode(f.agentServices)
+	case strings.HasPrefix(r.URL.Path, "/v1/agent/service/deregister/"):
+		id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")
+		f.deregs = append(f.deregs, "agent/"+id)
+		delete(f.agentServices, id)
+	case r.URL.Path == "/v1/health/state/critical":
+		var checks api.HealthChecks
+		for node := range f.failedNodes {
+			checks = append(checks, &api.HealthCheck{Node: node, CheckID: serfHealthCheckID, Status: api.HealthCritical})
+		}
+		_ = json.NewEncoder(w).Encode(checks)
+	case strings.HasPrefix(r.URL.Path, "/v1/catalog/node-services/"):
+		node := strings.TrimPrefix(r.URL.Path, "/v1/catalog/node-services/")
+		_ = json.NewEncoder(w).Encode(api.CatalogNodeServiceList{Node: &api.Node{Node: node}, Services: f.failedNodes[node]})
+	case r.URL.Path == "/v1/catalog/deregister":
+		var dereg api.CatalogDeregistration
+		if err := json.NewDecoder(r.Body).Decode(&dereg); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.deregs = append(f.deregs, "catalog/"+dereg.Node+"/"+dereg.ServiceID)
+		services := f.failedNodes[dereg.Node]
+		for i, svc := range services {
+			if svc.ID == dereg.ServiceID {
+				f.failedNodes[dereg.Node] = append(services[:i], services[i+1:]...)
+				break
+			}
+		}
+		_ = json.NewEncoder(w).Encode(true)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (f *fakeConsulGC) deletedTokens() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.deleted...)
+}
+
+func (f *fakeConsulGC) deregistered() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.deregs...)
+}
+
+// garbageCollector returns a garbage collector with a 30s grace period that
+// talks to f and to a Kubernetes cluster with the given pods. The returned
+// time is the garbage collector's clock.
+func (f *fakeConsulGC) garbageCollector(t *testing.T, pods ...client.Object) (*GarbageCollector, *time.Time) {
+	consulURL, err := url.Parse(f.URL)
+	require.NoError(t, err)
+	cfg := &api.Config{Address: consulURL.Host, Scheme: consulURL.Scheme}
+	consulClient, err := api.NewClient(cfg)
+	require.NoError(t, err)
+
+	now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	gc := &GarbageCollector{
+		Client:                fake.NewClientBuilder().WithObjects(pods...).Build(),
+		ConsulClient:          consulClient,
+		ConsulClientCfg:       cfg,
+		ConsulScheme:          consulURL.Scheme,
+		ConsulPort:            consulURL.Port(),
+		ConsulAPITimeout:      5 * time.Second,
+		ClusterID:             "cluster-id",
+		AllowK8sNamespacesSet: mapset.NewSet("*"),
+		DenyK8sNamespacesSet:  mapset.NewSet(),
+		ReleaseName:           "consul",
+		ReleaseNamespace:      "default",
+		AuthMethod:            "auth-method",
+		Interval:              time.Second,
+		GracePeriod:           30 * time.Second,
+		Registerer:            prometheus.NewRegistry(),
+		Log:                   logrtest.TestLogger{T: t},
+		now:                   func() time.Time { return now },
+	}
+	gc.init()
+	return gc, &now
+}
+
+// consulAgentPod returns a ready Consul client agent pod that listens on
+// 127.0.0.1.
+func consulAgentPod(name string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: "default",
+			Labels:    map[string]string{"component": "client", "app": "consul", "release": "consul"},
+		},
+		Status: corev1.PodStatus{
+			PodIP:      "127.0.0.1",
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+func tokenDescription(podKey string) string {
+	return `token created via login: {"pod":"` + podKey + `"}`
+}
+
+func tokenDescriptionWithUID(podKey, uid string) string {
+	return `token created via login: {"pod":"` + podKey + `","pod-uid":"` + uid + `"}`
+}
+
+func serviceMeta(namespace, pod string) map[string]string {
+	return map[string]string{
+		MetaKeyManagedBy: managedByValue,
+		MetaKeyKubeNS:    namespace,
+		MetaKeyPodName:   pod,
+	}
+}
+
+// failedNodeServiceMeta returns the meta of a service instance registered by
+// the cluster of the garbage collector for a pod on the given node.
+func failedNodeServiceMeta(namespace, pod, node string) map[string]string {
+	meta := serviceMeta(namespace, pod)
+	meta[MetaKeyKubeClusterID] = "cluster-id"
+	meta[MetaKeyKubeNodeName] = node
+	return meta
+}
+
+func serviceMetaWithUID(namespace, pod, uid string) map[string]string {
+	meta := serviceMeta(namespace, pod)
+	meta[MetaKeyPodUID] = uid
+	return meta
+}
@@ -57,6 +57,15 @@ type MeshWebhook struct {
 	// use for identity with connectInjection if ACLs are enabled.
 	AuthMethod string
 
+	// AuthMethodJWTAudience is the audience of the projected service account
+	// token that connect-init logs in with when AuthMethod is a jwt auth
+	// method. If not set, the token of the service account is used.
+	AuthMethodJWTAudience string
+
+	// AuthMethodJWTExpirySeconds is the requested lifetime of the projected
+	// service account token.
+	AuthMethodJWTExpirySeconds int64
+
 	// The PEM-encoded CA certificate string
 	// to use when communicating with Consul clients over HTTPS.
 	// If not set, will use HTTP.
@@ -220,6 +229,12 @@ func (w *MeshWebhook) Handle(ctx context.Context, req admission.Request) admissi
 	// the sidecar for passing data in the pod.
 	pod.Spec.Volumes = append(pod.Spec.Volumes, w.containerVolume())
 
+	// Add the projected service account token that connect-init logs in with
+	// when a jwt auth method is used.
+	if w.AuthMethod != "" && w.AuthMethodJWTAudience != "" {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, w.authMethodTokenVolume())
+	}
+
 	// Optionally mount data volume to other containers
 	w.injectVolumeMount(pod)
 
@@ -686,6 +701,9 @@ func (w *MeshWebhook) checkUnsupportedMultiPortCases(ns corev1.Namespace, pod co
 	if metricsMergingEnabled {
 		return fmt.Errorf("multi port services are not compatible with metrics merging")
 	}
+	if w.AuthMethod != "" && w.AuthMethodJWTAudience != "" {
+		return fmt.Errorf("multi port services are not compatible with the jwt auth method")
+	}
 	return nil
 }
 
@@ -1936,6 +1936,7 @@ func TestOverwriteProbes(t *testing.T) {
 func TestHandler_checkUnsupportedMultiPortCases(t *testing.T) {
 	cases := []struct {
 		name        string
+		webhook     MeshWebhook
 		annotations map[string]string
 		expErr      string
 	}{
@@ -1954,10 +1955,15 @@ func TestHandler_checkUnsupportedMultiPortCases(t *testing.T) {
 			annotations: map[string]string{annotationEnableMetricsMerging: "true"},
 			expErr:      "multi port services are not compatible with metrics merging",
 		},
+		{
+			name:    "jwt auth method",
+			webhook: MeshWebhook{AuthMethod: "auth-method", AuthMethodJWTAudience: "consul"},
+			expErr:  "multi port services are not compatible with the jwt auth method",
+		},
 	}
 	for _, tt := range cases {
 		t.Run(tt.name, func(t *testing.T) {
-			w := MeshWebhook{}
+			w := tt.webhook
 			pod := minimal()
 			pod.Annotations = tt.annotations
 			err := w.checkUnsupportedMultiPortCases(corev1.Namespace{}, *pod)
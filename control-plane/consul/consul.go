@@ -49,3 +49,41 @@ func NewClient(config *capi.Config, consulAPITimeout time.Duration) (*capi.Clien
 	client.AddHeader("User-Agent", fmt.Sprintf("consul-k8s/%s", version.GetHumanVersion()))
 	return client, nil
 }
+
+// ServerConfig is the configuration of a client of the HTTP API of a single
+// Consul server. It only holds plain values so that callers can configure
+// the helpers that talk to individual servers without depending on the
+// Consul API module.
+type ServerConfig struct {
+	// Address is the host:port of the HTTP API of the server.
+	Address string
+	// UseHTTPS is true if the HTTP API is served over TLS.
+	UseHTTPS bool
+	// CAFile is the path to the CA certificate to verify the server with.
+	CAFile string
+	// CAPem is the PEM-encoded CA certificate to verify the server with.
+	CAPem []byte
+	// TLSServerName is the name to verify the certificate of the server
+	// against instead of Address.
+	TLSServerName string
+	// Token is the ACL token of the requests.
+	Token string
+}
+
+// APIConfig returns the configuration of a Consul API client for the server.
+func (s *ServerConfig) APIConfig() *capi.Config {
+	config := &capi.Config{
+		Address: s.Address,
+		Scheme:  "http",
+		Token:   s.Token,
+		TLSConfig: capi.TLSConfig{
+			Address: s.TLSServerName,
+			CAFile:  s.CAFile,
+			CAPem:   s.CAPem,
+		},
+	}
+	if s.UseHTTPS {
+		config.Scheme = "https"
+	}
+	return config
+}
@@ -65,3 +65,25 @@ func TestNewClient_httpClientDefaultTimeout(t *testing.T) {
 	require.Error(t, err, "Get \"http://126.0.0.1/v1/agent/checks\": context deadline exceeded (Client.Timeout exceeded while awaiting headers)")
 
 }
+
+func TestServerConfig_APIConfig(t *testing.T) {
+	server := &ServerConfig{Address: "127.0.0.1:8500", Token: "token"}
+	require.Equal(t, &capi.Config{Address: "127.0.0.1:8500", Scheme: "http", Token: "token"}, server.APIConfig())
+
+	server = &ServerConfig{
+		Address:       "127.0.0.1:8501",
+		UseHTTPS:      true,
+		CAFile:        "/consul/tls/ca.crt",
+		CAPem:         []byte("pem"),
+		TLSServerName: "server.dc1.consul",
+	}
+	require.Equal(t, &capi.Config{
+		Address: "127.0.0.1:8501",
+		Scheme:  "https",
+		TLSConfig: capi.TLSConfig{
+			Address: "server.dc1.consul",
+			CAFile:  "/consul/tls/ca.crt",
+			CAPem:   []byte("pem"),
+		},
+	}, server.APIConfig())
+}
@@ -0,0 +1,40 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	consulv1alpha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+)
+
+// ACLPolicyController reconciles a ACLPolicy object.
+type ACLPolicyController struct {
+	client.Client
+	Log                   logr.Logger
+	Scheme                *runtime.Scheme
+	ACLResourceController *ACLResourceController
+}
+
+// +kubebuilder:rbac:groups=consul.hashicorp.com,resources=aclpolicies,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=consul.hashicorp.com,resources=aclpolicies/status,verbs=get;update;patch
+
+func (r *ACLPolicyController) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	return r.ACLResourceController.ReconcileACLResource(ctx, r, req, &consulv1alpha1.ACLPolicy{})
+}
+
+func (r *ACLPolicyController) Logger(name types.NamespacedName) logr.Logger {
+	return r.Log.WithValues("request", name)
+}
+
+func (r *ACLPolicyController) UpdateStatus(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	return r.Status().Update(ctx, obj, opts...)
+}
+
+func (r *ACLPolicyController) SetupWithManager(mgr ctrl.Manager) error {
+	return setupWithManager(mgr, &consulv1alpha1.ACLPolicy{}, r)
+}
@@ -0,0 +1,420 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	"github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/control-plane/namespaces"
+	capi "github.com/hashicorp/consul/api"
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	ACLTokenSecretError = "ACLTokenSecretError"
+)
+
+// ACLResourceController is a generic controller that is used to reconcile
+// all ACL types, i.e. ACLPolicy, ACLRole and ACLBindingRule, since they share
+// the same reconcile behaviour. It follows the same datacenter ownership and
+// migration semantics as ConfigEntryController, but since ACL objects have no
+// Meta field in Consul, the ownership metadata is stored in their descriptions.
+type ACLResourceController struct {
+	ConsulClient *capi.Client
+
+	// DatacenterName indicates the Consul Datacenter name the controller is
+	// operating in. Adds this value as metadata on managed ACL objects.
+	DatacenterName string
+
+	// TokenSecretName, TokenSecretKey and TokenSecretNamespace identify the
+	// Kubernetes Secret holding the privileged ACL token used to manage
+	// ACL objects. The Secret is read on every reconcile so that the token
+	// can be rotated without restarting the controller. If TokenSecretName
+	// is empty, the token of ConsulClient is used.
+	TokenSecretName      string
+	TokenSecretKey       string
+	TokenSecretNamespace string
+
+	// APIReader reads the token Secret directly from the Kubernetes API
+	// rather than from the manager's cache, so that the controller doesn't
+	// need to watch all Secrets.
+	APIReader client.Reader
+
+	// EnableConsulNamespaces indicates that a user is running Consul Enterprise
+	// with version 1.7+ which supports namespaces.
+	EnableConsulNamespaces bool
+
+	// ConsulDestinationNamespace is the name of the Consul namespace to create
+	// all ACL objects in. If EnableNSMirroring is true this is ignored.
+	ConsulDestinationNamespace string
+
+	// EnableNSMirroring causes Consul namespaces to be created to match the
+	// k8s namespace of any ACL custom resource. ACL objects will
+	// be created in the matching Consul namespace.
+	EnableNSMirroring bool
+
+	// NSMirroringPrefix is an optional prefix that can be added to the Consul
+	// namespaces created while mirroring.
+	NSMirroringPrefix string
+
+	// CrossNSACLPolicy is the name of the ACL policy to attach to
+	// any created Consul namespaces to allow cross namespace service discovery.
+	CrossNSACLPolicy string
+}
+
+// consulACLObject is an ACL object as read from Consul.
+type consulACLObject struct {
+	// ID is the Consul ID of the object.
+	ID string
+	// Meta is the ownership metadata parsed from the object's description.
+	Meta map[string]string
+	// Matches is true if the object has the same fields as the resource.
+	Matches bool
+	// JSON is the object marshalled to JSON, used in error messages.
+	JSON string
+}
+
+// ReconcileACLResource reconciles an update to an ACL resource. CRD-specific
+// controller's call this function because it handles reconciliation of ACL
+// objects generically.
+// CRD-specific controller should pass themselves in as updater since we
+// need to call back into their own update methods to ensure they update their
+// internal state.
+func (r *ACLResourceController) ReconcileACLResource(ctx context.Context, crdCtrl Controller, req ctrl.Request, resource common.ACLResource) (ctrl.Result, error) {
+	logger := crdCtrl.Logger(req.NamespacedName)
+	err := crdCtrl.Get(ctx, req.NamespacedName, resource)
+	if k8serr.IsNotFound(err) {
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	} else if err != nil {
+		logger.Error(err, "retrieving resource")
+		return ctrl.Result{}, err
+	}
+
+	if resource.GetDeletionTimestamp().IsZero() {
+		// The object is not being deleted, so if it does not have our finalizer,
+		// then let's add the finalizer and update the object. This is equivalent
+		// registering our finalizer.
+		if !containsString(resource.GetFinalizers(), FinalizerName) {
+			resource.AddFinalizer(FinalizerName)
+			if err := syncUnknown(ctx, crdCtrl, resource); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+	}
+
+	token, err := r.aclToken(ctx)
+	if err != nil {
+		if !resource.GetDeletionTimestamp().IsZero() && k8serr.IsNotFound(err) {
+			// The ACL object can't be deleted from Consul without the token.
+			// The Secret is usually gone because the installation is being
+			// uninstalled, so the resource is not kept around waiting for it.
+			logger.Info("ACL token secret not found, removing finalizer without deleting from Consul",
+				"secret", fmt.Sprintf("%s/%s", r.TokenSecretNamespace, r.TokenSecretName))
+			return ctrl.Result{}, r.removeFinalizer(ctx, logger, crdCtrl, resource)
+		}
+		return syncFailed(ctx, logger, crdCtrl, resource, ACLTokenSecretError, err)
+	}
+	consulNS := r.consulNamespace(resource)
+	queryOpts := &capi.QueryOptions{Namespace: consulNS, Token: token}
+	writeOpts := &capi.WriteOptions{Namespace: consulNS, Token: token}
+
+	existing, err := r.readACLObject(resource, queryOpts)
+
+	if !resource.GetDeletionTimestamp().IsZero() {
+		// The object is being deleted
+		if containsString(resource.GetFinalizers(), FinalizerName) {
+			logger.Info("deletion event")
+			if err != nil {
+				return ctrl.Result{}, fmt.Errorf("getting %s from consul: %w", resource.ConsulKind(), err)
+			}
+			// Only delete the object from Consul if it is owned by our datacenter.
+			if existing != nil {
+				if existing.Meta[common.DatacenterKey] == r.DatacenterName {
+					err := r.deleteACLObject(resource, existing.ID, writeOpts)
+					if isNotFoundErr(err) {
+						// It was deleted from Consul since we read it.
+						logger.Info("ACL object already deleted from Consul")
+					} else if err != nil {
+						return syncFailed(ctx, logger, crdCtrl, resource, ConsulAgentError,
+							fmt.Errorf("deleting %s from consul: %w", resource.ConsulKind(), err))
+					} else {
+						logger.Info("deletion from Consul successful")
+					}
+				} else {
+					logger.Info("ACL object in Consul was created in another datacenter - skipping delete from Consul", "external-datacenter", existing.Meta[common.DatacenterKey])
+				}
+			}
+			if err := r.removeFinalizer(ctx, logger, crdCtrl, resource); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		// Stop reconciliation as the item is being deleted
+		return ctrl.Result{}, nil
+	}
+
+	// If there is an error when trying to get the ACL object from Consul,
+	// fail the reconcile.
+	if err != nil {
+		return syncFailed(ctx, logger, crdCtrl, resource, ConsulAgentError, err)
+	}
+
+	// If an ACL object with this name does not exist, create it.
+	if existing == nil {
+		logger.Info("ACL object not found in consul")
+
+		// If Consul namespaces are enabled we may need to create the
+		// destination consul namespace first.
+		if r.EnableConsulNamespaces {
+			created, err := namespaces.EnsureExists(r.ConsulClient, consulNS, r.CrossNSACLPolicy)
+			if err != nil {
+				return syncFailed(ctx, logger, crdCtrl, resource, ConsulAgentError,
+					fmt.Errorf("creating consul namespace %q: %w", consulNS, err))
+			}
+			if created {
+				logger.Info("consul namespace created", "ns", consulNS)
+			}
+		}
+
+		if err := r.writeACLObject(resource, "", writeOpts); err != nil {
+			return syncFailed(ctx, logger, crdCtrl, resource, ConsulAgentError,
+				fmt.Errorf("writing %s to consul: %w", resource.ConsulKind(), err))
+		}
+		logger.Info("ACL object created")
+		return syncSuccessful(ctx, crdCtrl, resource)
+	}
+
+	requiresMigration := false
+	sourceDatacenter := existing.Meta[common.DatacenterKey]
+
+	// Check if the ACL object is managed by our datacenter.
+	// Do not process resource if the object was not created within our datacenter
+	// as it was created in a different cluster which will be managing that object.
+	if sourceDatacenter != r.DatacenterName {
+		// As with config entries, we will migrate an ACL object that wasn't
+		// created by the controller if it has the migrate-entry annotation set to true.
+		// This is how ACL objects created by hand can be moved under management
+		// of custom resources.
+		if resource.GetObjectMeta().Annotations[common.MigrateEntryKey] != common.MigrateEntryTrue {
+			return syncFailed(ctx, logger, crdCtrl, resource, ExternallyManagedConfigError,
+				aclSourceDatacenterMismatchErr(resource.ConsulKind(), sourceDatacenter))
+		}
+		requiresMigration = true
+	}
+
+	if !existing.Matches {
+		if requiresMigration {
+			// If we're migrating this ACL object but the custom resource
+			// doesn't match what's in Consul currently we error out so that
+			// it doesn't overwrite something accidentally.
+			return syncFailed(ctx, logger, crdCtrl, resource, MigrationFailedError,
+				r.nonMatchingACLMigrationError(resource, existing))
+		}
+
+		logger.Info("ACL object does not match consul", "id", existing.ID)
+		if err := r.writeACLObject(resource, existing.ID, writeOpts); err != nil {
+			return syncUnknownWithError(ctx, logger, crdCtrl, resource, ConsulAgentError,
+				fmt.Errorf("updating %s in consul: %w", resource.ConsulKind(), err))
+		}
+		logger.Info("ACL object updated")
+		return syncSuccessful(ctx, crdCtrl, resource)
+	} else if requiresMigration {
+		// If we get here then we're doing a migration and the object in Consul
+		// matches the resource in Kubernetes. We just need to update the
+		// description of the object in Consul to say that it's now managed by Kubernetes.
+		logger.Info("migrating ACL object to be managed by Kubernetes")
+		if err := r.writeACLObject(resource, existing.ID, writeOpts); err != nil {
+			return syncUnknownWithError(ctx, logger, crdCtrl, resource, ConsulAgentError,
+				fmt.Errorf("updating %s in consul: %w", resource.ConsulKind(), err))
+		}
+		logger.Info("ACL object migrated")
+		return syncSuccessful(ctx, crdCtrl, resource)
+	} else if status, _, _ := resource.SyncedCondition(); status != corev1.ConditionTrue {
+		return syncSuccessful(ctx, crdCtrl, resource)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// removeFinalizer removes our finalizer from the resource, if it has it, and
+// updates it so that its deletion can complete.
+func (r *ACLResourceController) removeFinalizer(ctx context.Context, logger logr.Logger, crdCtrl Controller, resource common.ACLResource) error {
+	if !containsString(resource.GetFinalizers(), FinalizerName) {
+		return nil
+	}
+	resource.RemoveFinalizer(FinalizerName)
+	if err := crdCtrl.Update(ctx, resource); err != nil {
+		return err
+	}
+	logger.Info("finalizer removed")
+	return nil
+}
+
+// aclToken returns the ACL token to use when managing ACL objects. An empty
+// token means the token of the Consul client is used.
+func (r *ACLResourceController) aclToken(ctx context.Context) (string, error) {
+	if r.TokenSecretName == "" {
+		return "", nil
+	}
+	var secret corev1.Secret
+	err := r.APIReader.Get(ctx, types.NamespacedName{Name: r.TokenSecretName, Namespace: r.TokenSecretNamespace}, &secret)
+	if err != nil {
+		return "", fmt.Errorf("getting ACL token secret %s/%s: %w", r.TokenSecretNamespace, r.TokenSecretName, err)
+	}
+	token, ok := secret.Data[r.TokenSecretKey]
+	if !ok || len(token) == 0 {
+		return "", fmt.Errorf("ACL token secret %s/%s has no key %q", r.TokenSecretNamespace, r.TokenSecretName, r.TokenSecretKey)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// readACLObject reads the ACL object managed by resource from Consul.
+// It returns nil if the object does not exist.
+func (r *ACLResourceController) readACLObject(resource common.ACLResource, opts *capi.QueryOptions) (*consulACLObject, error) {
+	switch res := resource.(type) {
+	case *v1alpha1.ACLPolicy:
+		policy, _, err := r.ConsulClient.ACL().PolicyReadByName(res.ConsulName(), opts)
+		if err != nil || policy == nil {
+			return nil, err
+		}
+		return newConsulACLObject(policy.ID, policy.Description, res.MatchesConsul(policy), policy), nil
+	case *v1alpha1.ACLRole:
+		role, _, err := r.ConsulClient.ACL().RoleReadByName(res.ConsulName(), opts)
+		if err != nil || role == nil {
+			return nil, err
+		}
+		return newConsulACLObject(role.ID, role.Description, res.MatchesConsul(role), role), nil
+	case *v1alpha1.ACLBindingRule:
+		// Binding rules have no name so we look for the rule that was created
+		// for this resource. If we're migrating, an unmanaged rule with the same
+		// fields is adopted instead.
+		rules, _, err := r.ConsulClient.ACL().BindingRuleList("", opts)
+		if err != nil {
+			return nil, err
+		}
+		resourceKey := common.ACLMeta(r.DatacenterName, res)[common.KubernetesResourceKey]
+		var unmanaged *capi.ACLBindingRule
+		for _, rule := range rules {
+			_, meta := common.ParseACLDescription(rule.Description)
+			if meta != nil && meta[common.KubernetesResourceKey] == resourceKey {
+				return newConsulACLObject(rule.ID, rule.Description, res.MatchesConsul(rule), rule), nil
+			}
+			if meta == nil && unmanaged == nil && res.MatchesConsul(rule) {
+				unmanaged = rule
+			}
+		}
+		if unmanaged != nil && res.GetObjectMeta().Annotations[common.MigrateEntryKey] == common.MigrateEntryTrue {
+			return newConsulACLObject(unmanaged.ID, unmanaged.Description, true, unmanaged), nil
+		}
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unsupported ACL resource kind %q", resource.KubeKind())
+	}
+}
+
+// writeACLObject creates the ACL object for resource in Consul if id is empty
+// and updates the object with that id otherwise.
+func (r *ACLResourceController) writeACLObject(resource common.ACLResource, id string, opts *capi.WriteOptions) error {
+	acl := r.ConsulClient.ACL()
+	var err error
+	switch res := resource.(type) {
+	case *v1alpha1.ACLPolicy:
+		policy := res.ToConsul(r.DatacenterName)
+		policy.ID = id
+		if id == "" {
+			_, _, err = acl.PolicyCreate(policy, opts)
+		} else {
+			_, _, err = acl.PolicyUpdate(policy, opts)
+		}
+	case *v1alpha1.ACLRole:
+		role := res.ToConsul(r.DatacenterName)
+		role.ID = id
+		if id == "" {
+			_, _, err = acl.RoleCreate(role, opts)
+		} else {
+			_, _, err = acl.RoleUpdate(role, opts)
+		}
+	case *v1alpha1.ACLBindingRule:
+		rule := res.ToConsul(r.DatacenterName)
+		rule.ID = id
+		if id == "" {
+			_, _, err = acl.BindingRuleCreate(rule, opts)
+		} else {
+			_, _, err = acl.BindingRuleUpdate(rule, opts)
+		}
+	default:
+		err = fmt.Errorf("unsupported ACL resource kind %q", resource.KubeKind())
+	}
+	return err
+}
+
+// deleteACLObject deletes the ACL object with the given id from Consul.
+func (r *ACLResourceController) deleteACLObject(resource common.ACLResource, id string, opts *capi.WriteOptions) error {
+	acl := r.ConsulClient.ACL()
+	var err error
+	switch resource.(type) {
+	case *v1alpha1.ACLPolicy:
+		_, err = acl.PolicyDelete(id, opts)
+	case *v1alpha1.ACLRole:
+		_, err = acl.RoleDelete(id, opts)
+	case *v1alpha1.ACLBindingRule:
+		_, err = acl.BindingRuleDelete(id, opts)
+	default:
+		err = fmt.Errorf("unsupported ACL resource kind %q", resource.KubeKind())
+	}
+	return err
+}
+
+func (r *ACLResourceController) consulNamespace(resource common.ACLResource) string {
+	return namespaces.ConsulNamespace(resource.ConsulMirroringNS(), r.EnableConsulNamespaces, r.ConsulDestinationNamespace, r.EnableNSMirroring, r.NSMirroringPrefix)
+}
+
+// nonMatchingACLMigrationError returns an error that indicates the migration
+// failed because the ACL objects did not match.
+func (r *ACLResourceController) nonMatchingACLMigrationError(resource common.ACLResource, existing *consulACLObject) error {
+	var kubeObject interface{}
+	switch res := resource.(type) {
+	case *v1alpha1.ACLPolicy:
+		kubeObject = res.ToConsul(r.DatacenterName)
+	case *v1alpha1.ACLRole:
+		kubeObject = res.ToConsul(r.DatacenterName)
+	case *v1alpha1.ACLBindingRule:
+		kubeObject = res.ToConsul(r.DatacenterName)
+	}
+	kubeJSON, err := json.Marshal(kubeObject)
+	if err != nil {
+		return fmt.Errorf("migration failed: unable to marshal Kubernetes resource: %s", err)
+	}
+	return fmt.Errorf("migration failed: Kubernetes resource does not match existing Consul %s: consul=%s, kube=%s", resource.ConsulKind(), existing.JSON, kubeJSON)
+}
+
+func newConsulACLObject(id, description string, matches bool, object interface{}) *consulACLObject {
+	_, meta := common.ParseACLDescription(description)
+	// Marshalling the Consul API structs cannot fail.
+	objJSON, _ := json.Marshal(object)
+	return &consulACLObject{
+		ID:      id,
+		Meta:    meta,
+		Matches: matches,
+		JSON:    string(objJSON),
+	}
+}
+
+// aclSourceDatacenterMismatchErr returns an error for when the source
+// datacenter in the ACL object's metadata does not match our datacenter.
+func aclSourceDatacenterMismatchErr(kind, sourceDatacenter string) error {
+	// If the datacenter is empty, then they likely created it in Consul
+	// directly (vs. another controller in another DC creating it).
+	if sourceDatacenter == "" {
+		return fmt.Errorf("%s already exists in Consul", kind)
+	}
+	return fmt.Errorf("%s managed in different datacenter: %q", kind, sourceDatacenter)
+}
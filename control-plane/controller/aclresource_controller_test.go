@@ -0,0 +1,483 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	logrtest "github.com/go-logr/logr/testing"
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	"github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/control-plane/helper/test"
+	capi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const aclManagementToken = "b78d37c7-0ca7-5f4d-99ee-6d9975ce4586"
+
+// testACLServer starts a Consul test server with ACLs enabled. It returns a
+// client that has no token so that the controller must use the token from
+// the returned secret, and a client that uses the management token.
+func testACLServer(t *testing.T) (*capi.Client, *capi.Client, *corev1.Secret) {
+	consul, err := testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
+		c.ACL.Enabled = true
+		c.ACL.DefaultPolicy = "deny"
+		c.ACL.Tokens.InitialManagement = aclManagementToken
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = consul.Stop()
+	})
+	consul.WaitForLeader(t)
+	consulClient, err := capi.NewClient(&capi.Config{
+		Address: consul.HTTPAddr,
+	})
+	require.NoError(t, err)
+	managementClient, err := capi.NewClient(&capi.Config{
+		Address: consul.HTTPAddr,
+		Token:   aclManagementToken,
+	})
+	require.NoError(t, err)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "acl-token",
+			Namespace: "consul",
+		},
+		Data: map[string][]byte{
+			"token": []byte(aclManagementToken),
+		},
+	}
+	return consulClient, managementClient, secret
+}
+
+func testACLReconciler(fakeClient client.Client, consulClient *capi.Client, r *ACLResourceController) {
+	r.ConsulClient = consulClient
+	r.DatacenterName = datacenterName
+	r.TokenSecretName = "acl-token"
+	r.TokenSecretKey = "token"
+	r.TokenSecretNamespace = "consul"
+	r.APIReader = fakeClient
+}
+
+func TestACLResourceControllers_createsACLObjects(t *testing.T) {
+	t.Parallel()
+	kubeNS := "default"
+
+	cases := map[string]struct {
+		resource   client.Object
+		reconciler func(client.Client, *ACLResourceController, *testing.T) testReconciler
+		compare    func(t *testing.T, consulClient *capi.Client)
+	}{
+		"ACLPolicy": {
+			resource: &v1alpha1.ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "policy",
+					Namespace: kubeNS,
+				},
+				Spec: v1alpha1.ACLPolicySpec{
+					Description: "my policy",
+					Rules:       `service "web" { policy = "write" }`,
+				},
+			},
+			reconciler: func(c client.Client, r *ACLResourceController, t *testing.T) testReconciler {
+				return &ACLPolicyController{Client: c, Log: logrtest.TestLogger{T: t}, ACLResourceController: r}
+			},
+			compare: func(t *testing.T, consulClient *capi.Client) {
+				policy, _, err := consulClient.ACL().PolicyReadByName("policy", &capi.QueryOptions{Token: aclManagementToken})
+				require.NoError(t, err)
+				require.NotNil(t, policy)
+				require.Equal(t, `service "web" { policy = "write" }`, policy.Rules)
+				description, meta := common.ParseACLDescription(policy.Description)
+				require.Equal(t, "my policy", description)
+				require.Equal(t, datacenterName, meta[common.DatacenterKey])
+				require.Equal(t, "default/policy", meta[common.KubernetesResourceKey])
+			},
+		},
+		"ACLRole": {
+			resource: &v1alpha1.ACLRole{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "role",
+					Namespace: kubeNS,
+				},
+				Spec: v1alpha1.ACLRoleSpec{
+					ServiceIdentities: []v1alpha1.ACLServiceIdentity{{ServiceName: "web"}},
+				},
+			},
+			reconciler: func(c client.Client, r *ACLResourceController, t *testing.T) testReconciler {
+				return &ACLRoleController{Client: c, Log: logrtest.TestLogger{T: t}, ACLResourceController: r}
+			},
+			compare: func(t *testing.T, consulClient *capi.Client) {
+				role, _, err := consulClient.ACL().RoleReadByName("role", &capi.QueryOptions{Token: aclManagementToken})
+				require.NoError(t, err)
+				require.NotNil(t, role)
+				require.Len(t, role.ServiceIdentities, 1)
+				require.Equal(t, "web", role.ServiceIdentities[0].ServiceName)
+			},
+		},
+		"ACLBindingRule": {
+			resource: &v1alpha1.ACLBindingRule{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "rule",
+					Namespace: kubeNS,
+				},
+				Spec: v1alpha1.ACLBindingRuleSpec{
+					AuthMethod: test.AuthMethod,
+					Selector:   `serviceaccount.name=="ci"`,
+					BindType:   "service",
+					BindName:   "ci",
+				},
+			},
+			reconciler: func(c client.Client, r *ACLResourceController, t *testing.T) testReconciler {
+				return &ACLBindingRuleController{Client: c, Log: logrtest.TestLogger{T: t}, ACLResourceController: r}
+			},
+			compare: func(t *testing.T, consulClient *capi.Client) {
+				rules, _, err := consulClient.ACL().BindingRuleList(test.AuthMethod, &capi.QueryOptions{Token: aclManagementToken})
+				require.NoError(t, err)
+				var managed []*capi.ACLBindingRule
+				for _, rule := range rules {
+					if _, meta := common.ParseACLDescription(rule.Description); meta[common.KubernetesResourceKey] == "default/rule" {
+						managed = append(managed, rule)
+					}
+				}
+				require.Len(t, managed, 1)
+				require.Equal(t, "ci", managed[0].BindName)
+			},
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			consulClient, managementClient, secret := testACLServer(t)
+
+			// Binding rules require their auth method to exist.
+			test.SetupK8sAuthMethod(t, managementClient, "ci", kubeNS)
+
+			s := runtime.NewScheme()
+			s.AddKnownTypes(v1alpha1.GroupVersion, c.resource)
+			s.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Secret{})
+			fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(c.resource, secret).Build()
+
+			aclController := &ACLResourceController{}
+			testACLReconciler(fakeClient, consulClient, aclController)
+			r := c.reconciler(fakeClient, aclController, t)
+			namespacedName := types.NamespacedName{
+				Namespace: kubeNS,
+				Name:      c.resource.GetName(),
+			}
+			resp, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+			require.NoError(t, err)
+			require.False(t, resp.Requeue)
+
+			c.compare(t, consulClient)
+
+			// Check that the status is "synced".
+			err = fakeClient.Get(ctx, namespacedName, c.resource)
+			require.NoError(t, err)
+			status, _, _ := c.resource.(common.ACLResource).SyncedCondition()
+			require.Equal(t, corev1.ConditionTrue, status)
+			require.Contains(t, c.resource.GetFinalizers(), FinalizerName)
+
+			// Reconciling again must not create a second object.
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+			require.NoError(t, err)
+			c.compare(t, consulClient)
+		})
+	}
+}
+
+func TestACLResourceController_updatesAndDeletesPolicy(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	consulClient, _, secret := testACLServer(t)
+
+	policy := &v1alpha1.ACLPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "policy",
+			Namespace:  "default",
+			Finalizers: []string{FinalizerName},
+		},
+		Spec: v1alpha1.ACLPolicySpec{
+			Rules: `operator = "read"`,
+		},
+	}
+	s := runtime.NewScheme()
+	s.AddKnownTypes(v1alpha1.GroupVersion, policy)
+	s.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Secret{})
+	fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(policy, secret).Build()
+
+	aclController := &ACLResourceController{}
+	testACLReconciler(fakeClient, consulClient, aclController)
+	r := &ACLPolicyController{Client: fakeClient, Log: logrtest.TestLogger{T: t}, ACLResourceController: aclController}
+	namespacedName := types.NamespacedName{Namespace: "default", Name: "policy"}
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	require.NoError(t, err)
+
+	// Update the rules.
+	err = fakeClient.Get(ctx, namespacedName, policy)
+	require.NoError(t, err)
+	policy.Spec.Rules = `operator = "write"`
+	err = fakeClient.Update(ctx, policy)
+	require.NoError(t, err)
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	require.NoError(t, err)
+
+	consulPolicy, _, err := consulClient.ACL().PolicyReadByName("policy", &capi.QueryOptions{Token: aclManagementToken})
+	require.NoError(t, err)
+	require.Equal(t, `operator = "write"`, consulPolicy.Rules)
+
+	// Delete the resource.
+	err = fakeClient.Get(ctx, namespacedName, policy)
+	require.NoError(t, err)
+	policy.SetDeletionTimestamp(&metav1.Time{Time: time.Now()})
+	err = fakeClient.Update(ctx, policy)
+	require.NoError(t, err)
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	require.NoError(t, err)
+
+	consulPolicy, _, err = consulClient.ACL().PolicyReadByName("policy", &capi.QueryOptions{Token: aclManagementToken})
+	require.NoError(t, err)
+	require.Nil(t, consulPolicy)
+}
+
+func TestACLResourceController_ownershipAndMigration(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		consulDescription string
+		consulRules       string
+		migrate           bool
+		expErr            string
+		expReason         string
+		expDescription    string
+	}{
+		"policy created in Consul is not managed": {
+			consulDescription: "created by hand",
+			consulRules:       `operator = "read"`,
+			expErr:            "acl-policy already exists in Consul",
+			expReason:         ExternallyManagedConfigError,
+			expDescription:    "created by hand",
+		},
+		"policy owned by another datacenter is not managed": {
+			consulDescription: `{"external-source":"kubernetes","consul.hashicorp.com/source-datacenter":"other"}`,
+			consulRules:       `operator = "read"`,
+			expErr:            `acl-policy managed in different datacenter: "other"`,
+			expReason:         ExternallyManagedConfigError,
+			expDescription:    `{"external-source":"kubernetes","consul.hashicorp.com/source-datacenter":"other"}`,
+		},
+		"identical policy is migrated": {
+			consulDescription: "",
+			consulRules:       `operator = "read"`,
+			migrate:           true,
+			expDescription:    `{"consul.hashicorp.com/k8s-resource":"default/policy","consul.hashicorp.com/source-datacenter":"datacenter","external-source":"kubernetes"}`,
+		},
+		"different policy is not migrated": {
+			consulDescription: "",
+			consulRules:       `operator = "write"`,
+			migrate:           true,
+			expErr:            "migration failed: Kubernetes resource does not match existing Consul acl-policy",
+			expReason:         MigrationFailedError,
+			expDescription:    "",
+		},
+	}
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			consulClient, _, secret := testACLServer(t)
+
+			_, _, err := consulClient.ACL().PolicyCreate(&capi.ACLPolicy{
+				Name:        "policy",
+				Description: c.consulDescription,
+				Rules:       c.consulRules,
+			}, &capi.WriteOptions{Token: aclManagementToken})
+			require.NoError(t, err)
+
+			policy := &v1alpha1.ACLPolicy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "policy",
+					Namespace: "default",
+				},
+				Spec: v1alpha1.ACLPolicySpec{
+					Rules: `operator = "read"`,
+				},
+			}
+			if c.migrate {
+				policy.Annotations = map[string]string{common.MigrateEntryKey: common.MigrateEntryTrue}
+			}
+			s := runtime.NewScheme()
+			s.AddKnownTypes(v1alpha1.GroupVersion, policy)
+			s.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Secret{})
+			fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(policy, secret).Build()
+
+			aclController := &ACLResourceController{}
+			testACLReconciler(fakeClient, consulClient, aclController)
+			r := &ACLPolicyController{Client: fakeClient, Log: logrtest.TestLogger{T: t}, ACLResourceController: aclController}
+			namespacedName := types.NamespacedName{Namespace: "default", Name: "policy"}
+
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+			if c.expErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			err = fakeClient.Get(ctx, namespacedName, policy)
+			require.NoError(t, err)
+			status, reason, _ := policy.SyncedCondition()
+			if c.expErr != "" {
+				require.Equal(t, corev1.ConditionFalse, status)
+				require.Equal(t, c.expReason, reason)
+			} else {
+				require.Equal(t, corev1.ConditionTrue, status)
+			}
+
+			consulPolicy, _, err := consulClient.ACL().PolicyReadByName("policy", &capi.QueryOptions{Token: aclManagementToken})
+			require.NoError(t, err)
+			require.Equal(t, c.expDescription, consulPolicy.Description)
+			require.Equal(t, c.consulRules, consulPolicy.Rules)
+		})
+	}
+}
+
+func TestACLResourceController_missingTokenSecret(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	policy := &v1alpha1.ACLPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "policy",
+			Namespace: "default",
+		},
+		Spec: v1alpha1.ACLPolicySpec{
+			Rules: `operator = "read"`,
+		},
+	}
+	s := runtime.NewScheme()
+	s.AddKnownTypes(v1alpha1.GroupVersion, policy)
+	s.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Secret{})
+	fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(policy).Build()
+
+	aclController := &ACLResourceController{}
+	testACLReconciler(fakeClient, nil, aclController)
+	r := &ACLPolicyController{Client: fakeClient, Log: logrtest.TestLogger{T: t}, ACLResourceController: aclController}
+	namespacedName := types.NamespacedName{Namespace: "default", Name: "policy"}
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "getting ACL token secret consul/acl-token")
+
+	err = fakeClient.Get(ctx, namespacedName, policy)
+	require.NoError(t, err)
+	status, reason, _ := policy.SyncedCondition()
+	require.Equal(t, corev1.ConditionFalse, status)
+	require.Equal(t, ACLTokenSecretError, reason)
+}
+
+// Test that the finalizer is removed when the resource is deleted after the
+// token Secret, since the ACL object can't be deleted from Consul anymore.
+func TestACLResourceController_missingTokenSecretOnDeletion(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	policy := &v1alpha1.ACLPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "policy",
+			Namespace:         "default",
+			Finalizers:        []string{FinalizerName},
+			DeletionTimestamp: &metav1.Time{Time: time.Now()},
+		},
+		Spec: v1alpha1.ACLPolicySpec{
+			Rules: `operator = "read"`,
+		},
+	}
+	s := runtime.NewScheme()
+	s.AddKnownTypes(v1alpha1.GroupVersion, policy)
+	s.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Secret{})
+	fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(policy).Build()
+
+	aclController := &ACLResourceController{}
+	testACLReconciler(fakeClient, nil, aclController)
+	r := &ACLPolicyController{Client: fakeClient, Log: logrtest.TestLogger{T: t}, ACLResourceController: aclController}
+	namespacedName := types.NamespacedName{Namespace: "default", Name: "policy"}
+
+	_, err := r.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	require.NoError(t, err)
+
+	err = fakeClient.Get(ctx, namespacedName, policy)
+	if !k8serr.IsNotFound(err) {
+		require.NoError(t, err)
+		require.Empty(t, policy.Finalizers)
+	}
+}
+
+// Test that the finalizer is removed when the ACL object is deleted from
+// Consul between reading and deleting it.
+func TestACLResourceController_alreadyDeletedFromConsul(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	policy := &v1alpha1.ACLPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "policy",
+			Namespace:         "default",
+			Finalizers:        []string{FinalizerName},
+			DeletionTimestamp: &metav1.Time{Time: time.Now()},
+		},
+		Spec: v1alpha1.ACLPolicySpec{
+			Rules: `operator = "read"`,
+		},
+	}
+	consulPolicy := policy.ToConsul(datacenterName)
+	consulPolicy.ID = "policy-id"
+	consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/acl/policy/name/policy":
+			_ = json.NewEncoder(w).Encode(consulPolicy)
+		case r.Method == http.MethodDelete && r.URL.Path == "/v1/acl/policy/policy-id":
+			http.Error(w, "ACL not found", http.StatusNotFound)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(consulServer.Close)
+	consulClient, err := capi.NewClient(&capi.Config{Address: consulServer.URL})
+	require.NoError(t, err)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "acl-token", Namespace: "consul"},
+		Data:       map[string][]byte{"token": []byte(aclManagementToken)},
+	}
+	s := runtime.NewScheme()
+	s.AddKnownTypes(v1alpha1.GroupVersion, policy)
+	s.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Secret{})
+	fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(policy, secret).Build()
+
+	aclController := &ACLResourceController{}
+	testACLReconciler(fakeClient, consulClient, aclController)
+	r := &ACLPolicyController{Client: fakeClient, Log: logrtest.TestLogger{T: t}, ACLResourceController: aclController}
+	namespacedName := types.NamespacedName{Namespace: "default", Name: "policy"}
+
+	_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	require.NoError(t, err)
+
+	err = fakeClient.Get(ctx, namespacedName, policy)
+	if !k8serr.IsNotFound(err) {
+		require.NoError(t, err)
+		require.Empty(t, policy.Finalizers)
+	}
+}
@@ -3,7 +3,9 @@ package controller
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"time"
 
@@ -28,6 +30,12 @@ const (
 	ConsulAgentError             = "ConsulAgentError"
 	ExternallyManagedConfigError = "ExternallyManagedConfigError"
 	MigrationFailedError         = "MigrationFailedError"
+	OwnershipClaimFailedError    = "OwnershipClaimFailedError"
+
+	// NamespaceTokenSecretLabel is the label on a Kubernetes namespace that
+	// overrides the name of the Secret holding the ACL token used to write
+	// the config entries of that namespace when namespace tokens are enabled.
+	NamespaceTokenSecretLabel = "consul.hashicorp.com/controller-token-secret"
 )
 
 // Controller is implemented by CRD-specific controllers. It is used by
@@ -79,6 +87,29 @@ type ConfigEntryController struct {
 	// any created Consul namespaces to allow cross namespace service discovery.
 	// Only necessary if ACLs are enabled.
 	CrossNSACLPolicy string
+
+	// ConsulPartition is the Consul admin partition config entries are
+	// written to. It is empty if admin partitions are not enabled.
+	ConsulPartition string
+
+	// EnableNamespaceTokens causes config entries to be read from and written
+	// to Consul with an ACL token read from a Secret in the Kubernetes namespace
+	// of the custom resource rather than with the token of ConsulClient, so that
+	// each namespace can only manage the config entries its token allows.
+	// Consul namespaces are still created with the token of ConsulClient.
+	EnableNamespaceTokens bool
+
+	// NamespaceTokenSecretName and NamespaceTokenSecretKey identify the Secret
+	// holding the ACL token of a namespace when EnableNamespaceTokens is true.
+	// The name can be overridden per namespace with the
+	// NamespaceTokenSecretLabel label on the namespace.
+	NamespaceTokenSecretName string
+	NamespaceTokenSecretKey  string
+
+	// APIReader reads namespaces and token Secrets directly from the
+	// Kubernetes API rather than from the manager's cache, so that the
+	// controller doesn't need to watch all Secrets.
+	APIReader client.Reader
 }
 
 // ReconcileEntry reconciles an update to a resource. CRD-specific controller's
@@ -98,6 +129,9 @@ func (r *ConfigEntryController) ReconcileEntry(ctx context.Context, crdCtrl Cont
 	}
 
 	consulEntry := configEntry.ToConsul(r.DatacenterName)
+	if isReleased(configEntry) {
+		consulEntry.GetMeta()[common.ReleasedKey] = common.MigrateEntryTrue
+	}
 
 	if configEntry.GetDeletionTimestamp().IsZero() {
 		// The object is not being deleted, so if it does not have our finalizer,
@@ -105,44 +139,61 @@ func (r *ConfigEntryController) ReconcileEntry(ctx context.Context, crdCtrl Cont
 		// registering our finalizer.
 		if !containsString(configEntry.GetFinalizers(), FinalizerName) {
 			configEntry.AddFinalizer(FinalizerName)
-			if err := r.syncUnknown(ctx, crdCtrl, configEntry); err != nil {
+			if err := syncUnknown(ctx, crdCtrl, configEntry); err != nil {
 				return ctrl.Result{}, err
 			}
 		}
-	} else {
+	}
+
+	token, err := r.namespaceToken(ctx, configEntry.GetNamespace())
+	if err != nil {
+		if !configEntry.GetDeletionTimestamp().IsZero() && k8serr.IsNotFound(err) {
+			// The config entry can't be deleted from Consul without the token.
+			// The Secret is usually gone because the namespace or the
+			// installation is being deleted, so the resource is not kept
+			// around waiting for it.
+			logger.Info("ACL token secret of namespace not found, removing finalizer without deleting from Consul", "err", err.Error())
+			return ctrl.Result{}, r.removeFinalizer(ctx, logger, crdCtrl, configEntry)
+		}
+		// Never fall back to the token of the Consul client, even on deletion,
+		// since it would let the resource change config entries its namespace
+		// is not allowed to manage.
+		return syncFailed(ctx, logger, crdCtrl, configEntry, ACLTokenSecretError, err)
+	}
+	consulNS := r.consulNamespace(consulEntry, configEntry.ConsulMirroringNS(), configEntry.ConsulGlobalResource())
+	queryOpts := &capi.QueryOptions{Namespace: consulNS, Partition: r.ConsulPartition, Token: token}
+	writeOpts := &capi.WriteOptions{Namespace: consulNS, Partition: r.ConsulPartition, Token: token}
+
+	if !configEntry.GetDeletionTimestamp().IsZero() {
 		// The object is being deleted
 		if containsString(configEntry.GetFinalizers(), FinalizerName) {
 			logger.Info("deletion event")
 			// Check to see if consul has config entry with the same name
-			entry, _, err := r.ConsulClient.ConfigEntries().Get(configEntry.ConsulKind(), configEntry.ConsulName(), &capi.QueryOptions{
-				Namespace: r.consulNamespace(consulEntry, configEntry.ConsulMirroringNS(), configEntry.ConsulGlobalResource()),
-			})
+			entry, _, err := r.ConsulClient.ConfigEntries().Get(configEntry.ConsulKind(), configEntry.ConsulName(), queryOpts)
 
 			// Ignore the error where the config entry isn't found in Consul.
 			// It is indicative of desired state.
 			if err != nil && !isNotFoundErr(err) {
-				return ctrl.Result{}, fmt.Errorf("getting config entry from consul: %w", err)
+				return ctrl.Result{}, fmt.Errorf("getting config entry from consul: %w", r.consulError(configEntry, err))
 			} else if err == nil {
-				// Only delete the resource from Consul if it is owned by our datacenter.
-				if entry.GetMeta()[common.DatacenterKey] == r.DatacenterName {
-					_, err := r.ConsulClient.ConfigEntries().Delete(configEntry.ConsulKind(), configEntry.ConsulName(), &capi.WriteOptions{
-						Namespace: r.consulNamespace(consulEntry, configEntry.ConsulMirroringNS(), configEntry.ConsulGlobalResource()),
-					})
+				// Only delete the resource from Consul if it is owned by our datacenter
+				// and has not been released for another datacenter to claim.
+				if entry.GetMeta()[common.ReleasedKey] == common.MigrateEntryTrue {
+					logger.Info("config entry in Consul has been released - skipping delete from Consul")
+				} else if entry.GetMeta()[common.DatacenterKey] == r.DatacenterName {
+					_, err := r.ConsulClient.ConfigEntries().Delete(configEntry.ConsulKind(), configEntry.ConsulName(), writeOpts)
 					if err != nil {
-						return r.syncFailed(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
-							fmt.Errorf("deleting config entry from consul: %w", err))
+						return syncFailed(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
+							fmt.Errorf("deleting config entry from consul: %w", r.consulError(configEntry, err)))
 					}
 					logger.Info("deletion from Consul successful")
 				} else {
 					logger.Info("config entry in Consul was created in another datacenter - skipping delete from Consul", "external-datacenter", entry.GetMeta()[common.DatacenterKey])
 				}
 			}
-			// remove our finalizer from the list and update it.
-			configEntry.RemoveFinalizer(FinalizerName)
-			if err := crdCtrl.Update(ctx, configEntry); err != nil {
+			if err := r.removeFinalizer(ctx, logger, crdCtrl, configEntry); err != nil {
 				return ctrl.Result{}, err
 			}
-			logger.Info("finalizer removed")
 		}
 
 		// Stop reconciliation as the item is being deleted
@@ -150,9 +201,7 @@ func (r *ConfigEntryController) ReconcileEntry(ctx context.Context, crdCtrl Cont
 	}
 
 	// Check to see if consul has config entry with the same name
-	entry, _, err := r.ConsulClient.ConfigEntries().Get(configEntry.ConsulKind(), configEntry.ConsulName(), &capi.QueryOptions{
-		Namespace: r.consulNamespace(consulEntry, configEntry.ConsulMirroringNS(), configEntry.ConsulGlobalResource()),
-	})
+	entry, _, err := r.ConsulClient.ConfigEntries().Get(configEntry.ConsulKind(), configEntry.ConsulName(), queryOpts)
 	// If a config entry with this name does not exist
 	if isNotFoundErr(err) {
 		logger.Info("config entry not found in consul")
@@ -160,10 +209,9 @@ func (r *ConfigEntryController) ReconcileEntry(ctx context.Context, crdCtrl Cont
 		// If Consul namespaces are enabled we may need to create the
 		// destination consul namespace first.
 		if r.EnableConsulNamespaces {
-			consulNS := r.consulNamespace(consulEntry, configEntry.ConsulMirroringNS(), configEntry.ConsulGlobalResource())
 			created, err := namespaces.EnsureExists(r.ConsulClient, consulNS, r.CrossNSACLPolicy)
 			if err != nil {
-				return r.syncFailed(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
+				return syncFailed(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
 					fmt.Errorf("creating consul namespace %q: %w", consulNS, err))
 			}
 			if created {
@@ -172,21 +220,19 @@ func (r *ConfigEntryController) ReconcileEntry(ctx context.Context, crdCtrl Cont
 		}
 
 		// Create the config entry
-		_, writeMeta, err := r.ConsulClient.ConfigEntries().Set(consulEntry, &capi.WriteOptions{
-			Namespace: r.consulNamespace(consulEntry, configEntry.ConsulMirroringNS(), configEntry.ConsulGlobalResource()),
-		})
+		_, writeMeta, err := r.ConsulClient.ConfigEntries().Set(consulEntry, writeOpts)
 		if err != nil {
-			return r.syncFailed(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
-				fmt.Errorf("writing config entry to consul: %w", err))
+			return syncFailed(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
+				fmt.Errorf("writing config entry to consul: %w", r.consulError(configEntry, err)))
 		}
 		logger.Info("config entry created", "request-time", writeMeta.RequestTime)
-		return r.syncSuccessful(ctx, crdCtrl, configEntry)
+		return syncEntrySuccessful(ctx, crdCtrl, configEntry)
 	}
 
 	// If there is an error when trying to get the config entry from the api server,
 	// fail the reconcile.
 	if err != nil {
-		return r.syncFailed(ctx, logger, crdCtrl, configEntry, ConsulAgentError, err)
+		return syncFailed(ctx, logger, crdCtrl, configEntry, ConsulAgentError, r.consulError(configEntry, err))
 	}
 
 	requiresMigration := false
@@ -196,6 +242,16 @@ func (r *ConfigEntryController) ReconcileEntry(ctx context.Context, crdCtrl Cont
 	// Do not process resource if the entry was not created within our datacenter
 	// as it was created in a different cluster which will be managing that config entry.
 	if sourceDatacenter != r.DatacenterName {
+		// Config entries can be handed over between the controllers of
+		// different datacenters: the current owner releases the entry and the
+		// new owner claims it. Once claimed, the resource of the previous owner
+		// no longer manages the entry and can be deleted.
+		if isReleased(configEntry) && sourceDatacenter != "" {
+			return syncTransferred(ctx, crdCtrl, configEntry, sourceDatacenter)
+		}
+		if configEntry.GetObjectMeta().Annotations[common.ClaimEntryKey] == common.MigrateEntryTrue {
+			return r.claimEntry(ctx, logger, crdCtrl, configEntry, consulEntry, entry, writeOpts)
+		}
 
 		// Note that there is a special case where we will migrate a config entry
 		// that wasn't created by the controller if it has the migrate-entry annotation set to true.
@@ -203,7 +259,7 @@ func (r *ConfigEntryController) ReconcileEntry(ctx context.Context, crdCtrl Cont
 		// chart versions where they had previously created config entries themselves but
 		// now want to manage them through custom resources.
 		if configEntry.GetObjectMeta().Annotations[common.MigrateEntryKey] != common.MigrateEntryTrue {
-			return r.syncFailed(ctx, logger, crdCtrl, configEntry, ExternallyManagedConfigError,
+			return syncFailed(ctx, logger, crdCtrl, configEntry, ExternallyManagedConfigError,
 				sourceDatacenterMismatchErr(sourceDatacenter))
 		}
 
@@ -215,41 +271,78 @@ func (r *ConfigEntryController) ReconcileEntry(ctx context.Context, crdCtrl Cont
 			// If we're migrating this config entry but the custom resource
 			// doesn't match what's in Consul currently we error out so that
 			// it doesn't overwrite something accidentally.
-			return r.syncFailed(ctx, logger, crdCtrl, configEntry, MigrationFailedError,
+			return syncFailed(ctx, logger, crdCtrl, configEntry, MigrationFailedError,
 				r.nonMatchingMigrationError(configEntry, entry))
 		}
 
 		logger.Info("config entry does not match consul", "modify-index", entry.GetModifyIndex())
-		_, writeMeta, err := r.ConsulClient.ConfigEntries().Set(consulEntry, &capi.WriteOptions{
-			Namespace: r.consulNamespace(consulEntry, configEntry.ConsulMirroringNS(), configEntry.ConsulGlobalResource()),
-		})
+		_, writeMeta, err := r.ConsulClient.ConfigEntries().Set(consulEntry, writeOpts)
 		if err != nil {
-			return r.syncUnknownWithError(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
-				fmt.Errorf("updating config entry in consul: %w", err))
+			return syncUnknownWithError(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
+				fmt.Errorf("updating config entry in consul: %w", r.consulError(configEntry, err)))
 		}
 		logger.Info("config entry updated", "request-time", writeMeta.RequestTime)
-		return r.syncSuccessful(ctx, crdCtrl, configEntry)
+		return syncEntrySuccessful(ctx, crdCtrl, configEntry)
 	} else if requiresMigration && entry.GetMeta()[common.DatacenterKey] != r.DatacenterName {
 		// If we get here then we're doing a migration and the entry in Consul
 		// matches the entry in Kubernetes. We just need to update the metadata
 		// of the entry in Consul to say that it's now managed by Kubernetes.
 		logger.Info("migrating config entry to be managed by Kubernetes")
-		_, writeMeta, err := r.ConsulClient.ConfigEntries().Set(consulEntry, &capi.WriteOptions{
-			Namespace: r.consulNamespace(consulEntry, configEntry.ConsulMirroringNS(), configEntry.ConsulGlobalResource()),
-		})
+		_, writeMeta, err := r.ConsulClient.ConfigEntries().Set(consulEntry, writeOpts)
 		if err != nil {
-			return r.syncUnknownWithError(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
-				fmt.Errorf("updating config entry in consul: %w", err))
+			return syncUnknownWithError(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
+				fmt.Errorf("updating config entry in consul: %w", r.consulError(configEntry, err)))
 		}
 		logger.Info("config entry migrated", "request-time", writeMeta.RequestTime)
-		return r.syncSuccessful(ctx, crdCtrl, configEntry)
+		return syncEntrySuccessful(ctx, crdCtrl, configEntry)
+	} else if entry.GetMeta()[common.ReleasedKey] != consulEntry.GetMeta()[common.ReleasedKey] {
+		// The release annotation was added or removed, so only the metadata
+		// of the entry in Consul needs updating.
+		_, writeMeta, err := r.ConsulClient.ConfigEntries().Set(consulEntry, writeOpts)
+		if err != nil {
+			return syncUnknownWithError(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
+				fmt.Errorf("updating config entry in consul: %w", r.consulError(configEntry, err)))
+		}
+		logger.Info("config entry release updated", "released", isReleased(configEntry), "request-time", writeMeta.RequestTime)
+		return syncEntrySuccessful(ctx, crdCtrl, configEntry)
 	} else if configEntry.SyncedConditionStatus() != corev1.ConditionTrue {
-		return r.syncSuccessful(ctx, crdCtrl, configEntry)
+		return syncEntrySuccessful(ctx, crdCtrl, configEntry)
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// claimEntry takes over the ownership of a config entry that the controller of
+// another datacenter has released. The entry is only claimed if the resource
+// matches it, and it is written with a check-and-set so that concurrent
+// changes by the previous owner are not overwritten.
+func (r *ConfigEntryController) claimEntry(ctx context.Context, logger logr.Logger, crdCtrl Controller, configEntry common.ConfigEntryResource, consulEntry, entry capi.ConfigEntry, writeOpts *capi.WriteOptions) (ctrl.Result, error) {
+	sourceDatacenter := entry.GetMeta()[common.DatacenterKey]
+	if entry.GetMeta()[common.ReleasedKey] != common.MigrateEntryTrue {
+		return syncFailed(ctx, logger, crdCtrl, configEntry, ExternallyManagedConfigError,
+			fmt.Errorf("%w: it must be released with the %q annotation before it can be claimed",
+				sourceDatacenterMismatchErr(sourceDatacenter), common.ReleaseEntryKey))
+	}
+	if !configEntry.MatchesConsul(entry) {
+		return syncFailed(ctx, logger, crdCtrl, configEntry, OwnershipClaimFailedError,
+			r.nonMatchingError("claim", configEntry, entry))
+	}
+
+	logger.Info("claiming config entry", "previous-datacenter", sourceDatacenter)
+	ok, writeMeta, err := r.ConsulClient.ConfigEntries().CAS(consulEntry, entry.GetModifyIndex(), writeOpts)
+	if err != nil {
+		return syncUnknownWithError(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
+			fmt.Errorf("claiming config entry in consul: %w", r.consulError(configEntry, err)))
+	}
+	if !ok {
+		return syncUnknownWithError(ctx, logger, crdCtrl, configEntry, ConsulAgentError,
+			errors.New("claiming config entry in consul: config entry was modified during the claim"))
+	}
+	logger.Info("config entry claimed", "request-time", writeMeta.RequestTime)
+	return syncSuccessfulWithReason(ctx, crdCtrl, configEntry, common.OwnershipClaimed,
+		fmt.Sprintf("ownership claimed from datacenter %q", sourceDatacenter))
+}
+
 // setupWithManager sets up the controller manager for the given resource
 // with our default options.
 func setupWithManager(mgr ctrl.Manager, resource client.Object, reconciler reconcile.Reconciler) error {
@@ -304,7 +397,17 @@ func (r *ConfigEntryController) consulNamespace(configEntry capi.ConfigEntry, na
 	return ""
 }
 
-func (r *ConfigEntryController) syncFailed(ctx context.Context, logger logr.Logger, updater Controller, configEntry common.ConfigEntryResource, errType string, err error) (ctrl.Result, error) {
+// syncedResource is implemented by all custom resources whose synced
+// condition is managed by the controllers in this package.
+type syncedResource interface {
+	client.Object
+	// SetSyncedCondition updates the synced condition.
+	SetSyncedCondition(status corev1.ConditionStatus, reason, message string)
+	// SetLastSyncedTime updates the last synced time.
+	SetLastSyncedTime(time *metav1.Time)
+}
+
+func syncFailed(ctx context.Context, logger logr.Logger, updater Controller, configEntry syncedResource, errType string, err error) (ctrl.Result, error) {
 	configEntry.SetSyncedCondition(corev1.ConditionFalse, errType, err.Error())
 	if updateErr := updater.UpdateStatus(ctx, configEntry); updateErr != nil {
 		// Log the original error here because we are returning the updateErr.
@@ -315,22 +418,48 @@ func (r *ConfigEntryController) syncFailed(ctx context.Context, logger logr.Logg
 	return ctrl.Result{}, err
 }
 
-func (r *ConfigEntryController) syncSuccessful(ctx context.Context, updater Controller, configEntry common.ConfigEntryResource) (ctrl.Result, error) {
-	configEntry.SetSyncedCondition(corev1.ConditionTrue, "", "")
+func syncSuccessful(ctx context.Context, updater Controller, configEntry syncedResource) (ctrl.Result, error) {
+	return syncSuccessfulWithReason(ctx, updater, configEntry, "", "")
+}
+
+func syncSuccessfulWithReason(ctx context.Context, updater Controller, configEntry syncedResource, reason, message string) (ctrl.Result, error) {
+	configEntry.SetSyncedCondition(corev1.ConditionTrue, reason, message)
 	timeNow := metav1.NewTime(time.Now())
 	configEntry.SetLastSyncedTime(&timeNow)
 	return ctrl.Result{}, updater.UpdateStatus(ctx, configEntry)
 }
 
-func (r *ConfigEntryController) syncUnknown(ctx context.Context, updater Controller, configEntry common.ConfigEntryResource) error {
+// syncEntrySuccessful marks a config entry as synced, recording in the
+// condition whether it has been released.
+func syncEntrySuccessful(ctx context.Context, updater Controller, configEntry common.ConfigEntryResource) (ctrl.Result, error) {
+	if isReleased(configEntry) {
+		return syncSuccessfulWithReason(ctx, updater, configEntry, common.OwnershipReleased,
+			"config entry has been released and can be claimed by the controller of another datacenter")
+	}
+	return syncSuccessful(ctx, updater, configEntry)
+}
+
+// syncTransferred marks a released config entry as claimed by the controller
+// of another datacenter. It is not an error so it is not retried.
+func syncTransferred(ctx context.Context, updater Controller, configEntry common.ConfigEntryResource, datacenter string) (ctrl.Result, error) {
+	_, reason, _ := configEntry.SyncedCondition()
+	if reason == common.OwnershipTransferred {
+		return ctrl.Result{}, nil
+	}
+	configEntry.SetSyncedCondition(corev1.ConditionFalse, common.OwnershipTransferred,
+		fmt.Sprintf("ownership transferred to datacenter %q, this resource can be deleted", datacenter))
+	return ctrl.Result{}, updater.UpdateStatus(ctx, configEntry)
+}
+
+func syncUnknown(ctx context.Context, updater Controller, configEntry syncedResource) error {
 	configEntry.SetSyncedCondition(corev1.ConditionUnknown, "", "")
 	return updater.Update(ctx, configEntry)
 }
 
-func (r *ConfigEntryController) syncUnknownWithError(ctx context.Context,
+func syncUnknownWithError(ctx context.Context,
 	logger logr.Logger,
 	updater Controller,
-	configEntry common.ConfigEntryResource,
+	configEntry syncedResource,
 	errType string,
 	err error) (ctrl.Result, error) {
 
@@ -347,18 +476,88 @@ func (r *ConfigEntryController) syncUnknownWithError(ctx context.Context,
 // nonMatchingMigrationError returns an error that indicates the migration failed
 // because the config entries did not match.
 func (r *ConfigEntryController) nonMatchingMigrationError(kubeEntry common.ConfigEntryResource, consulEntry capi.ConfigEntry) error {
+	return r.nonMatchingError("migration", kubeEntry, consulEntry)
+}
+
+// nonMatchingError returns an error that indicates the operation failed
+// because the config entries did not match.
+func (r *ConfigEntryController) nonMatchingError(operation string, kubeEntry common.ConfigEntryResource, consulEntry capi.ConfigEntry) error {
 	// We marshal into JSON to include in the error message so users will know
 	// which fields aren't matching.
 	kubeJSON, err := json.Marshal(kubeEntry.ToConsul(r.DatacenterName))
 	if err != nil {
-		return fmt.Errorf("migration failed: unable to marshal Kubernetes resource: %s", err)
+		return fmt.Errorf("%s failed: unable to marshal Kubernetes resource: %s", operation, err)
 	}
 	consulJSON, err := json.Marshal(consulEntry)
 	if err != nil {
-		return fmt.Errorf("migration failed: unable to marshal Consul resource: %s", err)
+		return fmt.Errorf("%s failed: unable to marshal Consul resource: %s", operation, err)
 	}
 
-	return fmt.Errorf("migration failed: Kubernetes resource does not match existing Consul config entry: consul=%s, kube=%s", consulJSON, kubeJSON)
+	return fmt.Errorf("%s failed: Kubernetes resource does not match existing Consul config entry: consul=%s, kube=%s", operation, consulJSON, kubeJSON)
+}
+
+// removeFinalizer removes our finalizer from the resource, if it has it, and
+// updates it so that its deletion can complete.
+func (r *ConfigEntryController) removeFinalizer(ctx context.Context, logger logr.Logger, crdCtrl Controller, configEntry common.ConfigEntryResource) error {
+	if !containsString(configEntry.GetFinalizers(), FinalizerName) {
+		return nil
+	}
+	configEntry.RemoveFinalizer(FinalizerName)
+	if err := crdCtrl.Update(ctx, configEntry); err != nil {
+		return err
+	}
+	logger.Info("finalizer removed")
+	return nil
+}
+
+// namespaceToken returns the ACL token to use for the config entries of the
+// given Kubernetes namespace. An empty token means the token of the Consul
+// client is used.
+func (r *ConfigEntryController) namespaceToken(ctx context.Context, namespace string) (string, error) {
+	if !r.EnableNamespaceTokens {
+		return "", nil
+	}
+	secretName := r.NamespaceTokenSecretName
+	var ns corev1.Namespace
+	if err := r.APIReader.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		return "", fmt.Errorf("getting namespace %s: %w", namespace, err)
+	}
+	if name := ns.Labels[NamespaceTokenSecretLabel]; name != "" {
+		secretName = name
+	}
+
+	var secret corev1.Secret
+	err := r.APIReader.Get(ctx, types.NamespacedName{Name: secretName, Namespace: namespace}, &secret)
+	if err != nil {
+		return "", fmt.Errorf("getting ACL token secret %s/%s: %w", namespace, secretName, err)
+	}
+	token, ok := secret.Data[r.NamespaceTokenSecretKey]
+	if !ok || len(token) == 0 {
+		return "", fmt.Errorf("ACL token secret %s/%s has no key %q", namespace, secretName, r.NamespaceTokenSecretKey)
+	}
+	return strings.TrimSpace(string(token)), nil
+}
+
+// consulError adds context to errors caused by the ACL token of a namespace
+// not having the permissions to manage the config entry, since with namespace
+// tokens they are usually fixed by changing the token rather than by retrying.
+func (r *ConfigEntryController) consulError(configEntry common.ConfigEntryResource, err error) error {
+	if !r.EnableNamespaceTokens || !isPermissionDeniedErr(err) {
+		return err
+	}
+	return fmt.Errorf("ACL token of namespace %q is not allowed to manage %s %q: %w",
+		configEntry.GetNamespace(), configEntry.ConsulKind(), configEntry.ConsulName(), err)
+}
+
+// isReleased returns true if the resource releases the ownership of its
+// config entry.
+func isReleased(configEntry common.ConfigEntryResource) bool {
+	return configEntry.GetObjectMeta().Annotations[common.ReleaseEntryKey] == common.MigrateEntryTrue
+}
+
+func isPermissionDeniedErr(err error) bool {
+	var statusErr capi.StatusError
+	return errors.As(err, &statusErr) && statusErr.Code == http.StatusForbidden
 }
 
 func isNotFoundErr(err error) bool {
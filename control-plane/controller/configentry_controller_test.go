@@ -3,6 +3,8 @@ package controller
 import (
 	"context"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -16,6 +18,7 @@ import (
 	"github.com/hashicorp/consul/sdk/testutil"
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -1893,3 +1896,374 @@ func TestConfigEntryController_Migration(t *testing.T) {
 		})
 	}
 }
+
+// Test that with namespace tokens enabled config entries are written with the
+// token of the namespace of the resource.
+func TestConfigEntryControllers_namespaceTokens(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		service       string
+		namespaceLbls map[string]string
+		secretName    string
+		expReason     string
+		expErr        string
+	}{
+		"token is allowed to write config entry": {
+			service:    "foo",
+			secretName: "consul-controller-token",
+		},
+		"token is not allowed to write config entry": {
+			service:    "bar",
+			secretName: "consul-controller-token",
+			expReason:  ConsulAgentError,
+			expErr:     `ACL token of namespace "default" is not allowed to manage service-defaults "bar"`,
+		},
+		"secret name from namespace label": {
+			service:       "foo",
+			namespaceLbls: map[string]string{NamespaceTokenSecretLabel: "other-token"},
+			secretName:    "other-token",
+		},
+		"secret does not exist": {
+			service:    "foo",
+			secretName: "other-token",
+			expReason:  ACLTokenSecretError,
+			expErr:     "getting ACL token secret default/consul-controller-token",
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			req := require.New(t)
+			ctx := context.Background()
+			consulClient, managementClient, _ := testACLServer(t)
+
+			policy, _, err := managementClient.ACL().PolicyCreate(&capi.ACLPolicy{
+				Name:  "default-namespace",
+				Rules: `service "foo" { policy = "write" }`,
+			}, nil)
+			req.NoError(err)
+			token, _, err := managementClient.ACL().TokenCreate(&capi.ACLToken{
+				Policies: []*capi.ACLTokenPolicyLink{{ID: policy.ID}},
+			}, nil)
+			req.NoError(err)
+
+			svcDefaults := &v1alpha1.ServiceDefaults{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      c.service,
+					Namespace: "default",
+				},
+				Spec: v1alpha1.ServiceDefaultsSpec{
+					Protocol: "http",
+				},
+			}
+			ns := &corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   "default",
+					Labels: c.namespaceLbls,
+				},
+			}
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      c.secretName,
+					Namespace: "default",
+				},
+				Data: map[string][]byte{
+					"token": []byte(token.SecretID),
+				},
+			}
+			s := runtime.NewScheme()
+			s.AddKnownTypes(v1alpha1.GroupVersion, svcDefaults)
+			s.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Namespace{}, &corev1.Secret{})
+			fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(svcDefaults, ns, secret).Build()
+
+			r := &ServiceDefaultsController{
+				Client: fakeClient,
+				Log:    logrtest.TestLogger{T: t},
+				ConfigEntryController: &ConfigEntryController{
+					ConsulClient:             consulClient,
+					DatacenterName:           datacenterName,
+					EnableNamespaceTokens:    true,
+					NamespaceTokenSecretName: "consul-controller-token",
+					NamespaceTokenSecretKey:  "token",
+					APIReader:                fakeClient,
+				},
+			}
+			namespacedName := types.NamespacedName{Namespace: "default", Name: c.service}
+			_, reconcileErr := r.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+
+			err = fakeClient.Get(ctx, namespacedName, svcDefaults)
+			req.NoError(err)
+			status, reason, message := svcDefaults.SyncedCondition()
+			if c.expErr != "" {
+				req.Error(reconcileErr)
+				req.Contains(reconcileErr.Error(), c.expErr)
+				req.Equal(corev1.ConditionFalse, status)
+				req.Equal(c.expReason, reason)
+				req.Contains(message, c.expErr)
+				return
+			}
+			req.NoError(reconcileErr)
+			req.Equal(corev1.ConditionTrue, status)
+
+			entry, _, err := managementClient.ConfigEntries().Get(capi.ServiceDefaults, c.service, nil)
+			req.NoError(err)
+			req.Equal("http", entry.(*capi.ServiceConfigEntry).Protocol)
+		})
+	}
+}
+
+func TestConfigEntryController_namespaceToken(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	s := runtime.NewScheme()
+	s.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Namespace{}, &corev1.Secret{})
+	fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "labelled", Labels: map[string]string{NamespaceTokenSecretLabel: "custom"}}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "empty"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "default"}, Data: map[string][]byte{"token": []byte("default-token\n")}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "custom", Namespace: "labelled"}, Data: map[string][]byte{"token": []byte("custom-token")}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "token", Namespace: "empty"}},
+	).Build()
+
+	r := &ConfigEntryController{
+		NamespaceTokenSecretName: "token",
+		NamespaceTokenSecretKey:  "token",
+		APIReader:                fakeClient,
+	}
+	token, err := r.namespaceToken(ctx, "default")
+	require.NoError(t, err)
+	require.Equal(t, "", token, "namespace tokens are disabled")
+
+	r.EnableNamespaceTokens = true
+	token, err = r.namespaceToken(ctx, "default")
+	require.NoError(t, err)
+	require.Equal(t, "default-token", token)
+
+	token, err = r.namespaceToken(ctx, "labelled")
+	require.NoError(t, err)
+	require.Equal(t, "custom-token", token)
+
+	_, err = r.namespaceToken(ctx, "empty")
+	require.EqualError(t, err, `ACL token secret empty/token has no key "token"`)
+
+	_, err = r.namespaceToken(ctx, "missing")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "getting namespace missing")
+}
+
+// Test that the deletion of a config entry never falls back to the token of
+// the controller when the ACL token of its namespace can't be read. The
+// finalizer is removed without calling Consul when the Secret is gone, and the
+// deletion is retried otherwise.
+func TestConfigEntryControllers_namespaceTokenMissingOnDeletion(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		secrets         []runtime.Object
+		expErr          string
+		expFinalizer    bool
+		expSyncedReason string
+	}{
+		"token secret deleted": {},
+		"token secret without token": {
+			secrets: []runtime.Object{
+				&corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "consul-controller-token", Namespace: "default"},
+					Data:       map[string][]byte{"other": []byte("token")},
+				},
+			},
+			expErr:          `ACL token secret default/consul-controller-token has no key "token"`,
+			expFinalizer:    true,
+			expSyncedReason: ACLTokenSecretError,
+		},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			consulServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				t.Errorf("unexpected request to Consul: %s %s", r.Method, r.URL.Path)
+				w.WriteHeader(http.StatusInternalServerError)
+			}))
+			defer consulServer.Close()
+			consulClient, err := capi.NewClient(&capi.Config{Address: consulServer.URL})
+			require.NoError(t, err)
+
+			svcDefaults := &v1alpha1.ServiceDefaults{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "foo",
+					Namespace:         "default",
+					DeletionTimestamp: &metav1.Time{Time: time.Now()},
+					Finalizers:        []string{FinalizerName},
+				},
+				Spec: v1alpha1.ServiceDefaultsSpec{
+					Protocol: "http",
+				},
+			}
+			s := runtime.NewScheme()
+			s.AddKnownTypes(v1alpha1.GroupVersion, svcDefaults)
+			s.AddKnownTypes(corev1.SchemeGroupVersion, &corev1.Namespace{}, &corev1.Secret{})
+			objects := append([]runtime.Object{
+				svcDefaults,
+				&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}},
+			}, c.secrets...)
+			fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objects...).Build()
+
+			r := &ServiceDefaultsController{
+				Client: fakeClient,
+				Log:    logrtest.TestLogger{T: t},
+				ConfigEntryController: &ConfigEntryController{
+					ConsulClient:             consulClient,
+					DatacenterName:           datacenterName,
+					EnableNamespaceTokens:    true,
+					NamespaceTokenSecretName: "consul-controller-token",
+					NamespaceTokenSecretKey:  "token",
+					APIReader:                fakeClient,
+				},
+			}
+			namespacedName := types.NamespacedName{Namespace: "default", Name: "foo"}
+			_, err = r.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+			if c.expErr != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expErr)
+			} else {
+				require.NoError(t, err)
+			}
+
+			err = fakeClient.Get(ctx, namespacedName, svcDefaults)
+			if !c.expFinalizer {
+				// The resource is deleted once its finalizer is removed.
+				require.True(t, k8serrors.IsNotFound(err), "expected the resource to be deleted, got %v", err)
+				return
+			}
+			require.NoError(t, err)
+			require.Contains(t, svcDefaults.GetFinalizers(), FinalizerName)
+			status, reason, _ := svcDefaults.SyncedCondition()
+			require.Equal(t, corev1.ConditionFalse, status)
+			require.Equal(t, c.expSyncedReason, reason)
+		})
+	}
+}
+
+func TestIsPermissionDeniedErr(t *testing.T) {
+	t.Parallel()
+
+	require.True(t, isPermissionDeniedErr(capi.StatusError{Code: http.StatusForbidden, Body: "Permission denied"}))
+	require.True(t, isPermissionDeniedErr(fmt.Errorf("writing config entry: %w", capi.StatusError{Code: http.StatusForbidden})))
+	require.False(t, isPermissionDeniedErr(capi.StatusError{Code: http.StatusInternalServerError, Body: "port 403 is in use"}))
+	require.False(t, isPermissionDeniedErr(fmt.Errorf("Unexpected response code: 403")))
+	require.False(t, isPermissionDeniedErr(nil))
+}
+
+// Test handing over the ownership of a config entry from the controller of
+// one datacenter to the controller of another.
+func TestConfigEntryController_OwnershipHandover(t *testing.T) {
+	t.Parallel()
+	req := require.New(t)
+	ctx := context.Background()
+	kubeNS := "default"
+	namespacedName := types.NamespacedName{Namespace: kubeNS, Name: common.Global}
+
+	consul, err := testutil.NewTestServerConfigT(t, nil)
+	req.NoError(err)
+	defer consul.Stop()
+	consul.WaitForServiceIntentions(t)
+	consulClient, err := capi.NewClient(&capi.Config{Address: consul.HTTPAddr})
+	req.NoError(err)
+
+	newController := func(datacenter string, proxyDefaults *v1alpha1.ProxyDefaults) (*ProxyDefaultsController, client.Client) {
+		s := runtime.NewScheme()
+		s.AddKnownTypes(v1alpha1.GroupVersion, &v1alpha1.ProxyDefaults{})
+		fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(proxyDefaults).Build()
+		return &ProxyDefaultsController{
+			Client: fakeClient,
+			Log:    logrtest.TestLogger{T: t},
+			ConfigEntryController: &ConfigEntryController{
+				ConsulClient:   consulClient,
+				DatacenterName: datacenter,
+			},
+		}, fakeClient
+	}
+	proxyDefaults := func(mode string, annotations map[string]string) *v1alpha1.ProxyDefaults {
+		return &v1alpha1.ProxyDefaults{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        common.Global,
+				Namespace:   kubeNS,
+				Annotations: annotations,
+			},
+			Spec: v1alpha1.ProxyDefaultsSpec{
+				MeshGateway: v1alpha1.MeshGateway{Mode: mode},
+			},
+		}
+	}
+	requireCondition := func(fakeClient client.Client, status corev1.ConditionStatus, reason string) {
+		var resource v1alpha1.ProxyDefaults
+		req.NoError(fakeClient.Get(ctx, namespacedName, &resource))
+		actualStatus, actualReason, message := resource.SyncedCondition()
+		req.Equal(status, actualStatus, message)
+		req.Equal(reason, actualReason, message)
+	}
+	requireConsulMeta := func(datacenter, released string) {
+		entry, _, err := consulClient.ConfigEntries().Get(capi.ProxyDefaults, common.Global, nil)
+		req.NoError(err)
+		req.Equal(datacenter, entry.GetMeta()[common.DatacenterKey])
+		req.Equal(released, entry.GetMeta()[common.ReleasedKey])
+	}
+
+	// The old owner creates the config entry.
+	oldOwner, oldClient := newController("dc1", proxyDefaults("local", nil))
+	_, err = oldOwner.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	req.NoError(err)
+	requireConsulMeta("dc1", "")
+
+	// The new owner can't claim it before it is released.
+	newOwner, newClient := newController("dc2", proxyDefaults("remote",
+		map[string]string{common.ClaimEntryKey: "true"}))
+	_, err = newOwner.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	req.EqualError(err, `config entry managed in different datacenter: "dc1": it must be released with the "consul.hashicorp.com/release-entry" annotation before it can be claimed`)
+	requireCondition(newClient, corev1.ConditionFalse, ExternallyManagedConfigError)
+
+	// The old owner releases it.
+	resource := &v1alpha1.ProxyDefaults{}
+	req.NoError(oldClient.Get(ctx, namespacedName, resource))
+	resource.Annotations = map[string]string{common.ReleaseEntryKey: "true"}
+	req.NoError(oldClient.Update(ctx, resource))
+	_, err = oldOwner.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	req.NoError(err)
+	requireConsulMeta("dc1", "true")
+	requireCondition(oldClient, corev1.ConditionTrue, common.OwnershipReleased)
+
+	// The new owner can't claim it if the resources don't match.
+	_, err = newOwner.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	req.Error(err)
+	req.Contains(err.Error(), "claim failed: Kubernetes resource does not match existing Consul config entry")
+	requireCondition(newClient, corev1.ConditionFalse, OwnershipClaimFailedError)
+
+	// The new owner claims it once the resources match.
+	req.NoError(newClient.Get(ctx, namespacedName, resource))
+	resource.Spec.MeshGateway.Mode = "local"
+	req.NoError(newClient.Update(ctx, resource))
+	_, err = newOwner.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	req.NoError(err)
+	requireConsulMeta("dc2", "")
+	requireCondition(newClient, corev1.ConditionTrue, common.OwnershipClaimed)
+
+	// The old owner records the transfer without retrying.
+	result, err := oldOwner.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	req.NoError(err)
+	req.False(result.Requeue)
+	requireCondition(oldClient, corev1.ConditionFalse, common.OwnershipTransferred)
+
+	// Deleting the resource of the old owner doesn't delete the config entry.
+	req.NoError(oldClient.Get(ctx, namespacedName, resource))
+	resource.SetDeletionTimestamp(&metav1.Time{Time: time.Now()})
+	req.NoError(oldClient.Update(ctx, resource))
+	_, err = oldOwner.Reconcile(ctx, ctrl.Request{NamespacedName: namespacedName})
+	req.NoError(err)
+	requireConsulMeta("dc2", "")
+}
@@ -0,0 +1,602 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	consulv1alpha1 "github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/hashicorp/consul-k8s/control-plane/namespaces"
+	capi "github.com/hashicorp/consul/api"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+const (
+	// NetworkPolicyIntentionsLabel is set on ServiceIntentions resources that
+	// are generated from NetworkPolicies. Resources without this label are
+	// never modified or deleted by the NetworkPolicyController.
+	NetworkPolicyIntentionsLabel = "consul.hashicorp.com/network-policy"
+
+	// UnmappableNetworkPolicyRule is the reason of the Event recorded on a
+	// NetworkPolicy when a part of it cannot be translated into intentions.
+	UnmappableNetworkPolicyRule = "UnmappableNetworkPolicyRule"
+	// NetworkPolicyIntentionsError is the reason of the Event recorded on a
+	// NetworkPolicy when its generated ServiceIntentions cannot be written.
+	NetworkPolicyIntentionsError = "NetworkPolicyIntentionsError"
+
+	wildcard = "*"
+)
+
+// NetworkPolicyController generates ServiceIntentions resources from
+// Kubernetes NetworkPolicies.
+//
+// A NetworkPolicy's pod selector is mapped to a Consul service by finding the
+// Kubernetes Services whose selectors are matched by it. The pod selector must
+// match exactly one Service for the policy to be translated. The ingress rules
+// of every policy selecting a service are merged into a single ServiceIntentions
+// resource for that service which allows the sources from the rules and denies
+// everything else, just as the NetworkPolicy isolates the selected pods.
+//
+// The generated ServiceIntentions are written to Kubernetes and synced to
+// Consul by the ServiceIntentionsController like any other ServiceIntentions.
+// Rules that cannot be expressed as intentions are reported as Events on the
+// NetworkPolicy.
+//
+// Reconciliation is done per Kubernetes namespace since several
+// NetworkPolicies can select the same service.
+type NetworkPolicyController struct {
+	client.Client
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// ConsulMeta holds the Consul Enterprise namespace settings used to
+	// determine the Consul namespaces of intention sources.
+	ConsulMeta common.ConsulMeta
+
+	// events holds the Events recorded by the last reconcile of each
+	// namespace so that they are not recorded again on every resync.
+	events policyEvents
+}
+
+// +kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=services;namespaces,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=consul.hashicorp.com,resources=serviceintentions,verbs=get;list;watch;create;update;patch;delete
+
+func (r *NetworkPolicyController) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	logger := r.Log.WithValues("namespace", req.Namespace)
+	events := r.events.begin(req.Namespace, r.Recorder)
+	defer func() { r.events.end(events, err == nil) }()
+
+	var policyList networkingv1.NetworkPolicyList
+	if err := r.List(ctx, &policyList, client.InNamespace(req.Namespace)); err != nil {
+		logger.Error(err, "failed to list network policies")
+		return ctrl.Result{}, err
+	}
+
+	translator := &networkPolicyTranslator{
+		ctx:        ctx,
+		client:     r.Client,
+		consulMeta: r.ConsulMeta,
+		services:   make(map[string][]corev1.Service),
+	}
+	desired := make(map[string]*networkPolicyIntentions)
+	for i := range policyList.Items {
+		policy := &policyList.Items[i]
+		if !policy.DeletionTimestamp.IsZero() {
+			continue
+		}
+		translated, warnings, err := translator.translate(policy)
+		if err != nil {
+			logger.Error(err, "failed to translate network policy", "name", policy.Name)
+			return ctrl.Result{}, err
+		}
+		for _, warning := range warnings {
+			events.record(policy, corev1.EventTypeWarning, UnmappableNetworkPolicyRule, warning)
+		}
+		if translated == nil {
+			continue
+		}
+		intentions, ok := desired[translated.destination]
+		if !ok {
+			intentions = &networkPolicyIntentions{
+				destination: translated.destination,
+				sources:     make(map[string]*consulv1alpha1.SourceIntention),
+			}
+			desired[translated.destination] = intentions
+		}
+		intentions.merge(policy, translated)
+	}
+
+	var intentionsList consulv1alpha1.ServiceIntentionsList
+	if err := r.List(ctx, &intentionsList, client.InNamespace(req.Namespace)); err != nil {
+		logger.Error(err, "failed to list service intentions")
+		return ctrl.Result{}, err
+	}
+	existing := make(map[string]*consulv1alpha1.ServiceIntentions)
+	for i := range intentionsList.Items {
+		serviceIntentions := &intentionsList.Items[i]
+		if serviceIntentions.Labels[NetworkPolicyIntentionsLabel] == "true" {
+			existing[serviceIntentions.Name] = serviceIntentions
+			continue
+		}
+		// ServiceIntentions that were written by hand take precedence over
+		// the ones we would generate since they configure the same
+		// config entry in Consul.
+		if intentions, ok := desired[serviceIntentions.Spec.Destination.Name]; ok {
+			for _, policy := range intentions.policies {
+				events.record(policy, corev1.EventTypeWarning, UnmappableNetworkPolicyRule,
+					fmt.Sprintf("intentions for service %q are already defined by ServiceIntentions %q", intentions.destination, serviceIntentions.Name))
+			}
+			delete(desired, serviceIntentions.Spec.Destination.Name)
+		}
+	}
+
+	desiredNames := make(map[string]bool)
+	for _, intentions := range desired {
+		desiredNames[networkPolicyIntentionsName(intentions.destination)] = true
+		if err := r.upsertIntentions(ctx, logger, events, req.Namespace, intentions, existing); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+
+	// Delete generated ServiceIntentions whose NetworkPolicies no longer exist
+	// or no longer select the service.
+	for name, serviceIntentions := range existing {
+		if desiredNames[name] {
+			continue
+		}
+		logger.Info("deleting service intentions generated from network policies", "name", name)
+		if err := r.Delete(ctx, serviceIntentions); err != nil && !k8serr.IsNotFound(err) {
+			logger.Error(err, "failed to delete service intentions", "name", name)
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{}, nil
+}
+
+func (r *NetworkPolicyController) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&networkingv1.NetworkPolicy{}).
+		Watches(
+			&source.Kind{Type: &consulv1alpha1.ServiceIntentions{}},
+			handler.EnqueueRequestsFromMapFunc(requestForNamespace),
+			builder.WithPredicates(predicate.NewPredicateFuncs(isNetworkPolicyIntentions)),
+		).
+		Watches(
+			&source.Kind{Type: &corev1.Service{}},
+			handler.EnqueueRequestsFromMapFunc(r.requestsForService),
+		).Complete(r)
+}
+
+// upsertIntentions creates or updates the ServiceIntentions generated for
+// intentions. The generated resource is validated before it is written so
+// that invalid resources are reported on the NetworkPolicies rather than
+// rejected by the webhook.
+func (r *NetworkPolicyController) upsertIntentions(ctx context.Context, logger logr.Logger, events *namespaceEvents, namespace string, intentions *networkPolicyIntentions, existing map[string]*consulv1alpha1.ServiceIntentions) error {
+	generated := &consulv1alpha1.ServiceIntentions{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      networkPolicyIntentionsName(intentions.destination),
+			Namespace: namespace,
+			Labels:    map[string]string{NetworkPolicyIntentionsLabel: "true"},
+		},
+		Spec: consulv1alpha1.ServiceIntentionsSpec{
+			Destination: consulv1alpha1.IntentionDestination{
+				Name: intentions.destination,
+			},
+			Sources: intentions.sortedSources(),
+		},
+	}
+	for _, policy := range intentions.policies {
+		if err := controllerutil.SetOwnerReference(policy, generated, r.Scheme); err != nil {
+			return err
+		}
+	}
+	generated.DefaultNamespaceFields(r.ConsulMeta)
+	if err := generated.Validate(r.ConsulMeta); err != nil {
+		recordIntentionsError(events, intentions, err)
+		return nil
+	}
+
+	current, ok := existing[generated.Name]
+	if !ok {
+		logger.Info("creating service intentions from network policies", "name", generated.Name)
+		if err := r.Create(ctx, generated); err != nil {
+			logger.Error(err, "failed to create service intentions", "name", generated.Name)
+			recordIntentionsError(events, intentions, err)
+			return err
+		}
+		return nil
+	}
+
+	if equality.Semantic.DeepEqual(current.Spec, generated.Spec) &&
+		equality.Semantic.DeepEqual(current.OwnerReferences, generated.OwnerReferences) {
+		return nil
+	}
+	current.Spec = generated.Spec
+	current.OwnerReferences = generated.OwnerReferences
+	logger.Info("updating service intentions from network policies", "name", current.Name)
+	if err := r.Update(ctx, current); err != nil {
+		logger.Error(err, "failed to update service intentions", "name", current.Name)
+		recordIntentionsError(events, intentions, err)
+		return err
+	}
+	return nil
+}
+
+func recordIntentionsError(events *namespaceEvents, intentions *networkPolicyIntentions, err error) {
+	for _, policy := range intentions.policies {
+		events.record(policy, corev1.EventTypeWarning, NetworkPolicyIntentionsError,
+			fmt.Sprintf("failed to write intentions for service %q: %s", intentions.destination, err))
+	}
+}
+
+// policyEvents keeps track of the Events recorded on the NetworkPolicies of
+// each namespace. An Event is only recorded when the previous reconcile of
+// the namespace did not record it, so that Events are recorded when the
+// translation of a policy changes rather than on every reconcile.
+type policyEvents struct {
+	mu       sync.Mutex
+	recorded map[string]map[string]bool
+}
+
+// namespaceEvents records the Events of one reconcile of a namespace.
+type namespaceEvents struct {
+	namespace string
+	recorder  record.EventRecorder
+	previous  map[string]bool
+	current   map[string]bool
+}
+
+func (p *policyEvents) begin(namespace string, recorder record.EventRecorder) *namespaceEvents {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return &namespaceEvents{
+		namespace: namespace,
+		recorder:  recorder,
+		previous:  p.recorded[namespace],
+		current:   make(map[string]bool),
+	}
+}
+
+// end stores the Events of the reconcile. If the reconcile did not complete,
+// the Events it did not get to are kept so that they are not recorded again
+// by the next reconcile.
+func (p *policyEvents) end(events *namespaceEvents, complete bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.recorded == nil {
+		p.recorded = make(map[string]map[string]bool)
+	}
+	if !complete {
+		for key := range events.previous {
+			events.current[key] = true
+		}
+	}
+	if len(events.current) == 0 {
+		delete(p.recorded, events.namespace)
+		return
+	}
+	p.recorded[events.namespace] = events.current
+}
+
+// record records the Event on the policy unless this or the previous
+// reconcile recorded it.
+func (e *namespaceEvents) record(policy *networkingv1.NetworkPolicy, eventType, reason, message string) {
+	key := strings.Join([]string{policy.Name, string(policy.UID), eventType, reason, message}, "/")
+	if e.current[key] {
+		return
+	}
+	e.current[key] = true
+	if e.previous[key] {
+		return
+	}
+	e.recorder.Event(policy, eventType, reason, message)
+}
+
+// requestsForService enqueues a request for every namespace that has
+// NetworkPolicies since their namespace selectors can select Services in
+// other namespaces.
+func (r *NetworkPolicyController) requestsForService(object client.Object) []reconcile.Request {
+	var policyList networkingv1.NetworkPolicyList
+	if err := r.List(context.Background(), &policyList); err != nil {
+		r.Log.Error(err, "failed to list network policies")
+		return nil
+	}
+	seen := make(map[string]bool)
+	var requests []reconcile.Request
+	for _, policy := range policyList.Items {
+		if seen[policy.Namespace] {
+			continue
+		}
+		seen[policy.Namespace] = true
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: policy.Namespace}})
+	}
+	return requests
+}
+
+func requestForNamespace(object client.Object) []reconcile.Request {
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: object.GetNamespace()}}}
+}
+
+func isNetworkPolicyIntentions(object client.Object) bool {
+	return object.GetLabels()[NetworkPolicyIntentionsLabel] == "true"
+}
+
+// networkPolicyIntentionsName returns the name of the ServiceIntentions
+// resource generated for service.
+func networkPolicyIntentionsName(service string) string {
+	return fmt.Sprintf("%s-network-policy", service)
+}
+
+// networkPolicyIntentions are the intentions for a destination merged from
+// all NetworkPolicies that select it.
+type networkPolicyIntentions struct {
+	destination string
+	policies    []*networkingv1.NetworkPolicy
+	sources     map[string]*consulv1alpha1.SourceIntention
+	// denyOthers is true if the sources that are not allowed should be denied.
+	denyOthers bool
+	// denyNamespace is the namespace of the wildcard deny source.
+	denyNamespace string
+}
+
+func (in *networkPolicyIntentions) merge(policy *networkingv1.NetworkPolicy, translated *translatedNetworkPolicy) {
+	in.policies = append(in.policies, policy)
+	for _, source := range translated.sources {
+		in.sources[source.Namespace+"/"+source.Name] = source
+	}
+	if translated.isolated {
+		in.denyOthers = true
+		in.denyNamespace = translated.denyNamespace
+	}
+}
+
+// sortedSources returns the allowed sources sorted by namespace and name
+// followed by the wildcard deny source, unless all sources are allowed.
+func (in *networkPolicyIntentions) sortedSources() consulv1alpha1.SourceIntentions {
+	keys := make([]string, 0, len(in.sources))
+	for key := range in.sources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var sources consulv1alpha1.SourceIntentions
+	allowsAll := false
+	for _, key := range keys {
+		source := in.sources[key]
+		if source.Name == wildcard && (source.Namespace == wildcard || source.Namespace == in.denyNamespace) {
+			allowsAll = true
+		}
+		sources = append(sources, source)
+	}
+	if in.denyOthers && !allowsAll {
+		sources = append(sources, &consulv1alpha1.SourceIntention{
+			Name:      wildcard,
+			Namespace: in.denyNamespace,
+			Action:    consulv1alpha1.IntentionAction(capi.IntentionActionDeny),
+		})
+	}
+	return sources
+}
+
+// translatedNetworkPolicy is the result of translating a single NetworkPolicy.
+type translatedNetworkPolicy struct {
+	destination string
+	sources     []*consulv1alpha1.SourceIntention
+	// isolated is true if the policy restricts ingress to its destination.
+	isolated      bool
+	denyNamespace string
+}
+
+// networkPolicyTranslator translates NetworkPolicies into intention sources.
+// It caches the Services of each namespace for the duration of a reconcile.
+type networkPolicyTranslator struct {
+	ctx        context.Context
+	client     client.Client
+	consulMeta common.ConsulMeta
+	services   map[string][]corev1.Service
+}
+
+// translate translates policy into intentions. It returns nil if the policy
+// cannot be translated. The returned warnings describe the parts of the
+// policy that were not translated.
+func (t *networkPolicyTranslator) translate(policy *networkingv1.NetworkPolicy) (*translatedNetworkPolicy, []string, error) {
+	var warnings []string
+
+	ingress := len(policy.Spec.PolicyTypes) == 0
+	for _, policyType := range policy.Spec.PolicyTypes {
+		switch policyType {
+		case networkingv1.PolicyTypeIngress:
+			ingress = true
+		case networkingv1.PolicyTypeEgress:
+			warnings = append(warnings, "egress rules cannot be translated into intentions")
+		}
+	}
+	if !ingress {
+		return nil, warnings, nil
+	}
+
+	destinations, err := t.servicesForSelector(policy.Namespace, &policy.Spec.PodSelector)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(destinations) != 1 {
+		warnings = append(warnings, unmappableSelectorMessage("podSelector", policy.Namespace, destinations))
+		return nil, warnings, nil
+	}
+
+	translated := &translatedNetworkPolicy{
+		destination:   destinations[0],
+		isolated:      true,
+		denyNamespace: t.wildcardNamespace(),
+	}
+	for i, rule := range policy.Spec.Ingress {
+		rulePath := fmt.Sprintf("ingress[%d]", i)
+		if len(rule.Ports) > 0 {
+			warnings = append(warnings, fmt.Sprintf("%s: port restrictions cannot be translated into intentions, rule is ignored", rulePath))
+			continue
+		}
+		if len(rule.From) == 0 {
+			translated.sources = append(translated.sources, t.allowSource(wildcard, t.wildcardNamespace()))
+			continue
+		}
+		for j, peer := range rule.From {
+			peerPath := fmt.Sprintf("%s.from[%d]", rulePath, j)
+			sources, warning, err := t.translatePeer(policy.Namespace, peer)
+			if err != nil {
+				return nil, nil, err
+			}
+			if warning != "" {
+				warnings = append(warnings, fmt.Sprintf("%s: %s", peerPath, warning))
+				continue
+			}
+			translated.sources = append(translated.sources, sources...)
+		}
+	}
+	return translated, warnings, nil
+}
+
+// translatePeer returns the intention sources for peer. If the peer cannot
+// be translated, the returned warning describes why.
+func (t *networkPolicyTranslator) translatePeer(namespace string, peer networkingv1.NetworkPolicyPeer) ([]*consulv1alpha1.SourceIntention, string, error) {
+	if peer.IPBlock != nil {
+		return nil, fmt.Sprintf("ipBlock %q cannot be translated into intentions", peer.IPBlock.CIDR), nil
+	}
+
+	if peer.NamespaceSelector == nil {
+		services, err := t.servicesForSelector(namespace, peer.PodSelector)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(services) != 1 {
+			return nil, unmappableSelectorMessage("podSelector", namespace, services), nil
+		}
+		return []*consulv1alpha1.SourceIntention{t.allowSource(services[0], t.consulNamespace(namespace))}, "", nil
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(peer.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Sprintf("invalid namespaceSelector: %s", err), nil
+	}
+
+	// A peer without a pod selector allows all pods in the selected namespaces.
+	// This can only be expressed if each Kubernetes namespace has its own
+	// Consul namespace.
+	if peer.PodSelector == nil {
+		if selector.Empty() {
+			return []*consulv1alpha1.SourceIntention{t.allowSource(wildcard, t.wildcardNamespace())}, "", nil
+		}
+		if !t.consulMeta.NamespacesEnabled || !t.consulMeta.Mirroring {
+			return nil, "namespaceSelector without a podSelector can only be translated when Consul namespace mirroring is enabled", nil
+		}
+	}
+
+	var namespaceList corev1.NamespaceList
+	if err := t.client.List(t.ctx, &namespaceList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, "", err
+	}
+	sort.Slice(namespaceList.Items, func(i, j int) bool {
+		return namespaceList.Items[i].Name < namespaceList.Items[j].Name
+	})
+
+	var sources []*consulv1alpha1.SourceIntention
+	for _, ns := range namespaceList.Items {
+		if peer.PodSelector == nil {
+			sources = append(sources, t.allowSource(wildcard, t.consulNamespace(ns.Name)))
+			continue
+		}
+		services, err := t.servicesForSelector(ns.Name, peer.PodSelector)
+		if err != nil {
+			return nil, "", err
+		}
+		if len(services) != 1 {
+			return nil, unmappableSelectorMessage("podSelector", ns.Name, services), nil
+		}
+		sources = append(sources, t.allowSource(services[0], t.consulNamespace(ns.Name)))
+	}
+	if len(sources) == 0 {
+		return nil, "namespaceSelector does not select any namespaces", nil
+	}
+	return sources, "", nil
+}
+
+// servicesForSelector returns the sorted names of the Services in namespace
+// whose pods are selected by selector. A Service's pods are selected if the
+// labels of the Service's selector are matched by selector.
+func (t *networkPolicyTranslator) servicesForSelector(namespace string, labelSelector *metav1.LabelSelector) ([]string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(labelSelector)
+	if err != nil {
+		return nil, err
+	}
+	services, ok := t.services[namespace]
+	if !ok {
+		var serviceList corev1.ServiceList
+		if err := t.client.List(t.ctx, &serviceList, client.InNamespace(namespace)); err != nil {
+			return nil, err
+		}
+		services = serviceList.Items
+		t.services[namespace] = services
+	}
+
+	var names []string
+	for _, service := range services {
+		if len(service.Spec.Selector) == 0 {
+			continue
+		}
+		if selector.Matches(labels.Set(service.Spec.Selector)) {
+			names = append(names, service.Name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (t *networkPolicyTranslator) allowSource(name, namespace string) *consulv1alpha1.SourceIntention {
+	return &consulv1alpha1.SourceIntention{
+		Name:      name,
+		Namespace: namespace,
+		Action:    consulv1alpha1.IntentionAction(capi.IntentionActionAllow),
+	}
+}
+
+func (t *networkPolicyTranslator) consulNamespace(namespace string) string {
+	return namespaces.ConsulNamespace(namespace, t.consulMeta.NamespacesEnabled, t.consulMeta.DestinationNamespace, t.consulMeta.Mirroring, t.consulMeta.Prefix)
+}
+
+// wildcardNamespace returns the namespace of sources that match all services.
+// It is empty if Consul namespaces are not enabled.
+func (t *networkPolicyTranslator) wildcardNamespace() string {
+	if t.consulMeta.NamespacesEnabled {
+		return wildcard
+	}
+	return ""
+}
+
+func unmappableSelectorMessage(field, namespace string, services []string) string {
+	if len(services) == 0 {
+		return fmt.Sprintf("%s does not select any Kubernetes services in namespace %q", field, namespace)
+	}
+	return fmt.Sprintf("%s selects multiple Kubernetes services in namespace %q: %s", field, namespace, strings.Join(services, ", "))
+}
@@ -0,0 +1,423 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	logrtest "github.com/go-logr/logr/testing"
+	"github.com/hashicorp/consul-k8s/control-plane/api/common"
+	"github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	k8serr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNetworkPolicyController(t *testing.T) {
+	t.Parallel()
+	kubeNS := "default"
+
+	service := func(name, namespace string, selector map[string]string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec:       corev1.ServiceSpec{Selector: selector},
+		}
+	}
+	podSelector := func(app string) *metav1.LabelSelector {
+		return &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}
+	}
+	services := []runtime.Object{
+		service("web", kubeNS, map[string]string{"app": "web"}),
+		service("api", kubeNS, map[string]string{"app": "api", "tier": "backend"}),
+		service("db", kubeNS, map[string]string{"app": "db", "tier": "backend"}),
+		service("other", "other", map[string]string{"app": "api"}),
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other", Labels: map[string]string{"team": "other"}}},
+	}
+
+	cases := map[string]struct {
+		consulMeta    common.ConsulMeta
+		policies      []*networkingv1.NetworkPolicy
+		existing      []runtime.Object
+		expIntentions map[string]v1alpha1.ServiceIntentionsSpec
+		expEvents     []string
+	}{
+		"allows pod selectors in the same namespace": {
+			policies: []*networkingv1.NetworkPolicy{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: kubeNS},
+					Spec: networkingv1.NetworkPolicySpec{
+						PodSelector: *podSelector("web"),
+						Ingress: []networkingv1.NetworkPolicyIngressRule{
+							{From: []networkingv1.NetworkPolicyPeer{{PodSelector: podSelector("api")}}},
+						},
+					},
+				},
+			},
+			expIntentions: map[string]v1alpha1.ServiceIntentionsSpec{
+				"web-network-policy": {
+					Destination: v1alpha1.IntentionDestination{Name: "web"},
+					Sources: v1alpha1.SourceIntentions{
+						{Name: "api", Action: "allow"},
+						{Name: "*", Action: "deny"},
+					},
+				},
+			},
+		},
+		"merges policies selecting the same service": {
+			policies: []*networkingv1.NetworkPolicy{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "web-from-api", Namespace: kubeNS},
+					Spec: networkingv1.NetworkPolicySpec{
+						PodSelector: *podSelector("web"),
+						Ingress: []networkingv1.NetworkPolicyIngressRule{
+							{From: []networkingv1.NetworkPolicyPeer{{PodSelector: podSelector("api")}}},
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "web-from-db", Namespace: kubeNS},
+					Spec: networkingv1.NetworkPolicySpec{
+						PodSelector: *podSelector("web"),
+						Ingress: []networkingv1.NetworkPolicyIngressRule{
+							{From: []networkingv1.NetworkPolicyPeer{{PodSelector: podSelector("db")}}},
+						},
+					},
+				},
+			},
+			expIntentions: map[string]v1alpha1.ServiceIntentionsSpec{
+				"web-network-policy": {
+					Destination: v1alpha1.IntentionDestination{Name: "web"},
+					Sources: v1alpha1.SourceIntentions{
+						{Name: "api", Action: "allow"},
+						{Name: "db", Action: "allow"},
+						{Name: "*", Action: "deny"},
+					},
+				},
+			},
+		},
+		"rule without peers allows all sources": {
+			policies: []*networkingv1.NetworkPolicy{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: kubeNS},
+					Spec: networkingv1.NetworkPolicySpec{
+						PodSelector: *podSelector("web"),
+						Ingress:     []networkingv1.NetworkPolicyIngressRule{{}},
+					},
+				},
+			},
+			expIntentions: map[string]v1alpha1.ServiceIntentionsSpec{
+				"web-network-policy": {
+					Destination: v1alpha1.IntentionDestination{Name: "web"},
+					Sources: v1alpha1.SourceIntentions{
+						{Name: "*", Action: "allow"},
+					},
+				},
+			},
+		},
+		"namespace selector with namespaces enabled": {
+			consulMeta: common.ConsulMeta{
+				NamespacesEnabled: true,
+				Mirroring:         true,
+			},
+			policies: []*networkingv1.NetworkPolicy{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: kubeNS},
+					Spec: networkingv1.NetworkPolicySpec{
+						PodSelector: *podSelector("web"),
+						Ingress: []networkingv1.NetworkPolicyIngressRule{
+							{From: []networkingv1.NetworkPolicyPeer{
+								{
+									NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "other"}},
+									PodSelector:       podSelector("api"),
+								},
+							}},
+						},
+					},
+				},
+			},
+			expIntentions: map[string]v1alpha1.ServiceIntentionsSpec{
+				"web-network-policy": {
+					Destination: v1alpha1.IntentionDestination{Name: "web", Namespace: kubeNS},
+					Sources: v1alpha1.SourceIntentions{
+						{Name: "other", Namespace: "other", Action: "allow"},
+						{Name: "*", Namespace: "*", Action: "deny"},
+					},
+				},
+			},
+		},
+		"reports unmappable rules": {
+			policies: []*networkingv1.NetworkPolicy{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: kubeNS},
+					Spec: networkingv1.NetworkPolicySpec{
+						PodSelector: *podSelector("web"),
+						PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+						Ingress: []networkingv1.NetworkPolicyIngressRule{
+							{From: []networkingv1.NetworkPolicyPeer{
+								{IPBlock: &networkingv1.IPBlock{CIDR: "10.0.0.0/8"}},
+								{PodSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "backend"}}},
+							}},
+							{
+								Ports: []networkingv1.NetworkPolicyPort{{}},
+								From:  []networkingv1.NetworkPolicyPeer{{PodSelector: podSelector("api")}},
+							},
+						},
+					},
+				},
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "all", Namespace: kubeNS},
+					Spec:       networkingv1.NetworkPolicySpec{},
+				},
+			},
+			expIntentions: map[string]v1alpha1.ServiceIntentionsSpec{
+				"web-network-policy": {
+					Destination: v1alpha1.IntentionDestination{Name: "web"},
+					Sources: v1alpha1.SourceIntentions{
+						{Name: "*", Action: "deny"},
+					},
+				},
+			},
+			expEvents: []string{
+				"Warning UnmappableNetworkPolicyRule egress rules cannot be translated into intentions",
+				`Warning UnmappableNetworkPolicyRule ingress[0].from[0]: ipBlock "10.0.0.0/8" cannot be translated into intentions`,
+				`Warning UnmappableNetworkPolicyRule ingress[0].from[1]: podSelector selects multiple Kubernetes services in namespace "default": api, db`,
+				"Warning UnmappableNetworkPolicyRule ingress[1]: port restrictions cannot be translated into intentions, rule is ignored",
+				`Warning UnmappableNetworkPolicyRule podSelector selects multiple Kubernetes services in namespace "default": api, db, web`,
+			},
+		},
+		"does not generate intentions already defined by hand": {
+			policies: []*networkingv1.NetworkPolicy{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: kubeNS},
+					Spec: networkingv1.NetworkPolicySpec{
+						PodSelector: *podSelector("web"),
+					},
+				},
+			},
+			existing: []runtime.Object{
+				&v1alpha1.ServiceIntentions{
+					ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: kubeNS},
+					Spec: v1alpha1.ServiceIntentionsSpec{
+						Destination: v1alpha1.IntentionDestination{Name: "web"},
+						Sources:     v1alpha1.SourceIntentions{{Name: "api", Action: "allow"}},
+					},
+				},
+			},
+			expIntentions: map[string]v1alpha1.ServiceIntentionsSpec{
+				"web": {
+					Destination: v1alpha1.IntentionDestination{Name: "web"},
+					Sources:     v1alpha1.SourceIntentions{{Name: "api", Action: "allow"}},
+				},
+			},
+			expEvents: []string{
+				`Warning UnmappableNetworkPolicyRule intentions for service "web" are already defined by ServiceIntentions "web"`,
+			},
+		},
+		"updates and deletes stale generated intentions": {
+			policies: []*networkingv1.NetworkPolicy{
+				{
+					ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: kubeNS},
+					Spec: networkingv1.NetworkPolicySpec{
+						PodSelector: *podSelector("web"),
+					},
+				},
+			},
+			existing: []runtime.Object{
+				&v1alpha1.ServiceIntentions{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "web-network-policy",
+						Namespace: kubeNS,
+						Labels:    map[string]string{NetworkPolicyIntentionsLabel: "true"},
+					},
+					Spec: v1alpha1.ServiceIntentionsSpec{
+						Destination: v1alpha1.IntentionDestination{Name: "web"},
+						Sources:     v1alpha1.SourceIntentions{{Name: "api", Action: "allow"}},
+					},
+				},
+				&v1alpha1.ServiceIntentions{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "db-network-policy",
+						Namespace: kubeNS,
+						Labels:    map[string]string{NetworkPolicyIntentionsLabel: "true"},
+					},
+					Spec: v1alpha1.ServiceIntentionsSpec{
+						Destination: v1alpha1.IntentionDestination{Name: "db"},
+						Sources:     v1alpha1.SourceIntentions{{Name: "api", Action: "allow"}},
+					},
+				},
+			},
+			expIntentions: map[string]v1alpha1.ServiceIntentionsSpec{
+				"web-network-policy": {
+					Destination: v1alpha1.IntentionDestination{Name: "web"},
+					Sources:     v1alpha1.SourceIntentions{{Name: "*", Action: "deny"}},
+				},
+			},
+		},
+	}
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			require.NoError(t, clientgoscheme.AddToScheme(s))
+			s.AddKnownTypes(v1alpha1.GroupVersion, &v1alpha1.ServiceIntentions{}, &v1alpha1.ServiceIntentionsList{})
+
+			objs := append([]runtime.Object{}, services...)
+			objs = append(objs, c.existing...)
+			for _, policy := range c.policies {
+				objs = append(objs, policy)
+			}
+			fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objs...).Build()
+			recorder := record.NewFakeRecorder(20)
+
+			r := &NetworkPolicyController{
+				Client:     fakeClient,
+				Log:        logrtest.TestLogger{T: t},
+				Scheme:     s,
+				Recorder:   recorder,
+				ConsulMeta: c.consulMeta,
+			}
+			resp, err := r.Reconcile(context.Background(), ctrl.Request{
+				NamespacedName: types.NamespacedName{Namespace: kubeNS},
+			})
+			require.NoError(t, err)
+			require.False(t, resp.Requeue)
+
+			var intentionsList v1alpha1.ServiceIntentionsList
+			require.NoError(t, fakeClient.List(context.Background(), &intentionsList, client.InNamespace(kubeNS)))
+			actual := make(map[string]v1alpha1.ServiceIntentionsSpec)
+			for _, intentions := range intentionsList.Items {
+				actual[intentions.Name] = intentions.Spec
+			}
+			require.Equal(t, c.expIntentions, actual)
+
+			close(recorder.Events)
+			var events []string
+			for event := range recorder.Events {
+				events = append(events, event)
+			}
+			require.ElementsMatch(t, c.expEvents, events)
+		})
+	}
+}
+
+func TestNetworkPolicyController_setsOwnerReferences(t *testing.T) {
+	t.Parallel()
+	kubeNS := "default"
+
+	s := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(s))
+	s.AddKnownTypes(v1alpha1.GroupVersion, &v1alpha1.ServiceIntentions{}, &v1alpha1.ServiceIntentionsList{})
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: kubeNS, UID: "uid"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+		},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: kubeNS},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "web"}},
+	}
+	fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(policy, svc).Build()
+	r := &NetworkPolicyController{
+		Client:   fakeClient,
+		Log:      logrtest.TestLogger{T: t},
+		Scheme:   s,
+		Recorder: record.NewFakeRecorder(10),
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: kubeNS}}
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	var intentions v1alpha1.ServiceIntentions
+	key := types.NamespacedName{Name: "web-network-policy", Namespace: kubeNS}
+	require.NoError(t, fakeClient.Get(context.Background(), key, &intentions))
+	require.Equal(t, "true", intentions.Labels[NetworkPolicyIntentionsLabel])
+	require.Len(t, intentions.OwnerReferences, 1)
+	require.Equal(t, "NetworkPolicy", intentions.OwnerReferences[0].Kind)
+	require.Equal(t, "web", intentions.OwnerReferences[0].Name)
+
+	// Once the policy is deleted, the generated intentions are deleted too.
+	require.NoError(t, fakeClient.Delete(context.Background(), policy))
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	err = fakeClient.Get(context.Background(), key, &intentions)
+	require.True(t, k8serr.IsNotFound(err))
+}
+
+// Test that Events are only recorded when the translation of a policy
+// changes and not on every reconcile.
+func TestNetworkPolicyController_recordsEventsOnce(t *testing.T) {
+	t.Parallel()
+	kubeNS := "default"
+
+	s := runtime.NewScheme()
+	require.NoError(t, clientgoscheme.AddToScheme(s))
+	s.AddKnownTypes(v1alpha1.GroupVersion, &v1alpha1.ServiceIntentions{}, &v1alpha1.ServiceIntentionsList{})
+
+	policy := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: kubeNS, UID: "uid"},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+		},
+	}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: kubeNS},
+		Spec:       corev1.ServiceSpec{Selector: map[string]string{"app": "web"}},
+	}
+	fakeClient := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(policy, svc).Build()
+	recorder := record.NewFakeRecorder(10)
+	r := &NetworkPolicyController{
+		Client:   fakeClient,
+		Log:      logrtest.TestLogger{T: t},
+		Scheme:   s,
+		Recorder: recorder,
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: kubeNS}}
+	egressWarning := "Warning UnmappableNetworkPolicyRule egress rules cannot be translated into intentions"
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, []string{egressWarning}, drainEvents(recorder))
+
+	// A resync doesn't record the Event again.
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	require.Empty(t, drainEvents(recorder))
+
+	// Once the warning goes away and comes back, it is recorded again.
+	require.NoError(t, fakeClient.Get(context.Background(), types.NamespacedName{Name: "web", Namespace: kubeNS}, policy))
+	policy.Spec.PolicyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}
+	require.NoError(t, fakeClient.Update(context.Background(), policy))
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	require.Empty(t, drainEvents(recorder))
+
+	policy.Spec.PolicyTypes = []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}
+	require.NoError(t, fakeClient.Update(context.Background(), policy))
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	require.Equal(t, []string{egressWarning}, drainEvents(recorder))
+}
+
+// drainEvents returns the Events recorded so far.
+func drainEvents(recorder *record.FakeRecorder) []string {
+	var events []string
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
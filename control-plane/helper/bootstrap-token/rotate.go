@@ -0,0 +1,230 @@
+package bootstraptoken
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/consul"
+	secretrotation "github.com/hashicorp/consul-k8s/control-plane/helper/secret-rotation"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-uuid"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// globalManagementPolicyID is the ID of the builtin global-management policy.
+	globalManagementPolicyID = "00000000-0000-0000-0000-000000000001"
+
+	// tokenDescription is the description Consul gives the bootstrap token.
+	tokenDescription = "Bootstrap Token (Global Management)"
+)
+
+// Rotator replaces the ACL bootstrap token stored in a Kubernetes Secret with
+// a new management token.
+//
+// The progress of a rotation is kept in the bootstrap Secret by a
+// secretrotation.Rotator. The new token is activated by creating it in Consul
+// and reading it back from every server, and the old token is retired by
+// revoking it.
+type Rotator struct {
+	Clientset  kubernetes.Interface
+	Namespace  string
+	SecretName string
+	SecretKey  string
+
+	// Servers holds the configuration of a Consul client for each server.
+	// Writes go to the first server and the new token is verified against
+	// all of them. The tokens of the configurations are ignored.
+	Servers          []*consul.ServerConfig
+	ConsulAPITimeout time.Duration
+
+	// RetryInterval is how long to wait before verifying the new token again
+	// against a server that has not replicated it yet. Defaults to 1s.
+	RetryInterval time.Duration
+
+	Log hclog.Logger
+}
+
+// Rotate rotates the bootstrap token, resuming an interrupted rotation if
+// there is one. It returns the accessor ID of the new token.
+func (r *Rotator) Rotate(ctx context.Context) (string, error) {
+	if len(r.Servers) == 0 {
+		return "", errors.New("no Consul servers to rotate the bootstrap token on")
+	}
+	if r.RetryInterval == 0 {
+		r.RetryInterval = 1 * time.Second
+	}
+	if r.Log == nil {
+		r.Log = hclog.NewNullLogger()
+	}
+
+	secretRotator := &secretrotation.Rotator{
+		Clientset:  r.Clientset,
+		Namespace:  r.Namespace,
+		SecretName: r.SecretName,
+		SecretKey:  r.SecretKey,
+		Kind:       "bootstrap",
+		Value:      "token",
+		Log:        r.Log,
+	}
+	current, err := secretRotator.Rotate(ctx, &tokenSteps{Rotator: r})
+	if err != nil {
+		return "", err
+	}
+
+	client, err := r.client(r.Servers[0], current)
+	if err != nil {
+		return "", err
+	}
+	token, _, err := client.ACL().TokenReadSelf(nil)
+	if err != nil {
+		return "", fmt.Errorf("reading the new bootstrap token: %w", err)
+	}
+	r.Log.Info("Rotated bootstrap token", "accessor-id", token.AccessorID)
+	return token.AccessorID, nil
+}
+
+// tokenSteps rotates the token through the ACL API of the servers.
+type tokenSteps struct {
+	*Rotator
+}
+
+// Generate returns the SecretID of a new token.
+func (s *tokenSteps) Generate() (string, error) {
+	return uuid.GenerateUUID()
+}
+
+// Activate creates the new token and waits until every server has it.
+func (s *tokenSteps) Activate(ctx context.Context, current, pending string) error {
+	if err := s.createToken(current, pending); err != nil {
+		return err
+	}
+	return s.verifyToken(ctx, pending)
+}
+
+// Retire revokes the previous token.
+func (s *tokenSteps) Retire(_ context.Context, current, previous string, _ bool) error {
+	return s.revokeToken(current, previous)
+}
+
+// createToken creates a management token with the given SecretID unless it
+// was already created by an interrupted rotation.
+func (r *Rotator) createToken(bootstrapToken, secretID string) error {
+	client, err := r.client(r.Servers[0], secretID)
+	if err != nil {
+		return err
+	}
+	if _, _, err := client.ACL().TokenReadSelf(nil); err == nil {
+		r.Log.Info("New bootstrap token already exists")
+		return nil
+	} else if !isACLNotFound(err) {
+		return fmt.Errorf("checking for the new bootstrap token: %w", err)
+	}
+
+	client, err = r.client(r.Servers[0], bootstrapToken)
+	if err != nil {
+		return err
+	}
+	token, _, err := client.ACL().TokenCreate(&api.ACLToken{
+		SecretID:    secretID,
+		Description: tokenDescription,
+		Policies:    []*api.ACLTokenPolicyLink{{ID: globalManagementPolicyID}},
+	}, nil)
+	if err != nil {
+		return fmt.Errorf("creating the new bootstrap token: %w", err)
+	}
+	r.Log.Info("Created new bootstrap token", "accessor-id", token.AccessorID)
+	return nil
+}
+
+// verifyToken waits until every server resolves the token to a management
+// token. The reads are stale so that each server answers from its own state.
+func (r *Rotator) verifyToken(ctx context.Context, secretID string) error {
+	for _, cfg := range r.Servers {
+		client, err := r.client(cfg, secretID)
+		if err != nil {
+			return err
+		}
+		for {
+			err = checkManagementToken(client)
+			if err == nil {
+				r.Log.Info("Verified new bootstrap token", "server", cfg.Address)
+				break
+			}
+			r.Log.Info("New bootstrap token not usable on server yet", "server", cfg.Address, "err", err)
+			select {
+			case <-time.After(r.RetryInterval):
+			case <-ctx.Done():
+				return fmt.Errorf("verifying the new bootstrap token against server %s: %w", cfg.Address, err)
+			}
+		}
+	}
+	return nil
+}
+
+// revokeToken deletes the old bootstrap token using the new one. A token that
+// was already deleted is not an error.
+func (r *Rotator) revokeToken(bootstrapToken, oldToken string) error {
+	oldClient, err := r.client(r.Servers[0], oldToken)
+	if err != nil {
+		return err
+	}
+	old, _, err := oldClient.ACL().TokenReadSelf(nil)
+	if isACLNotFound(err) {
+		r.Log.Info("Previous bootstrap token is already revoked")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading the previous bootstrap token: %w", err)
+	}
+
+	client, err := r.client(r.Servers[0], bootstrapToken)
+	if err != nil {
+		return err
+	}
+	self, _, err := client.ACL().TokenReadSelf(nil)
+	if err != nil {
+		return fmt.Errorf("reading the new bootstrap token: %w", err)
+	}
+	if self.AccessorID == old.AccessorID {
+		return errors.New("the previous and the new bootstrap token are the same token")
+	}
+	if _, err := client.ACL().TokenDelete(old.AccessorID, nil); err != nil {
+		return fmt.Errorf("revoking the previous bootstrap token: %w", err)
+	}
+	r.Log.Info("Revoked previous bootstrap token", "accessor-id", old.AccessorID)
+	return nil
+}
+
+func (r *Rotator) client(cfg *consul.ServerConfig, token string) (*api.Client, error) {
+	clientConfig := cfg.APIConfig()
+	clientConfig.Token = token
+	client, err := consul.NewClient(clientConfig, r.ConsulAPITimeout)
+	if err != nil {
+		return nil, fmt.Errorf("creating Consul client for address %s: %w", cfg.Address, err)
+	}
+	return client, nil
+}
+
+// checkManagementToken returns an error unless the token of the client has
+// the global-management policy.
+func checkManagementToken(client *api.Client) error {
+	token, _, err := client.ACL().TokenReadSelf(&api.QueryOptions{AllowStale: true})
+	if err != nil {
+		return err
+	}
+	for _, policy := range token.Policies {
+		if policy.ID == globalManagementPolicyID {
+			return nil
+		}
+	}
+	return fmt.Errorf("token %s does not have the global-management policy", token.AccessorID)
+}
+
+func isACLNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "ACL not found")
+}
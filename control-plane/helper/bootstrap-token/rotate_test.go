@@ -0,0 +1,188 @@
+package bootstraptoken
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/consul"
+	secretrotation "github.com/hashicorp/consul-k8s/control-plane/helper/secret-rotation"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	ns         = "default"
+	secretName = "consul-bootstrap-acl-token"
+	secretKey  = "token"
+	bootToken  = "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+)
+
+func TestRotate(t *testing.T) {
+	t.Parallel()
+	svr := testServer(t)
+	k8s := fake.NewSimpleClientset(bootstrapSecret(map[string]string{secretKey: bootToken}))
+
+	r := rotator(k8s, svr)
+	accessorID, err := r.Rotate(context.Background())
+	require.NoError(t, err)
+
+	secret := readSecret(t, k8s)
+	newToken := string(secret.Data[secretKey])
+	require.NotEqual(t, bootToken, newToken)
+	require.NotContains(t, secret.Data, secretKey+secretrotation.PendingKeySuffix)
+	require.NotContains(t, secret.Data, secretKey+secretrotation.PreviousKeySuffix)
+
+	// The new token is a management token and the old token is revoked.
+	token, _, err := client(t, svr, newToken).ACL().TokenReadSelf(nil)
+	require.NoError(t, err)
+	require.Equal(t, accessorID, token.AccessorID)
+	require.Equal(t, globalManagementPolicyID, token.Policies[0].ID)
+	_, _, err = client(t, svr, bootToken).ACL().TokenReadSelf(nil)
+	require.True(t, isACLNotFound(err), "expected the old token to be revoked, got %v", err)
+
+	// The new token can be rotated again.
+	_, err = r.Rotate(context.Background())
+	require.NoError(t, err)
+	require.NotEqual(t, newToken, string(readSecret(t, k8s).Data[secretKey]))
+}
+
+// Test that a rotation interrupted before the new token was created creates
+// the token that was recorded as pending.
+func TestRotate_ResumePending(t *testing.T) {
+	t.Parallel()
+	svr := testServer(t)
+	pending := "11111111-2222-3333-4444-555555555555"
+	k8s := fake.NewSimpleClientset(bootstrapSecret(map[string]string{
+		secretKey: bootToken,
+		secretKey + secretrotation.PendingKeySuffix: pending,
+	}))
+
+	_, err := rotator(k8s, svr).Rotate(context.Background())
+	require.NoError(t, err)
+	secret := readSecret(t, k8s)
+	require.Equal(t, pending, string(secret.Data[secretKey]))
+	require.Len(t, secret.Data, 1)
+}
+
+// Test that a rotation interrupted after the new token was stored revokes the
+// previous token.
+func TestRotate_ResumePrevious(t *testing.T) {
+	t.Parallel()
+	svr := testServer(t)
+	newToken, _, err := client(t, svr, bootToken).ACL().TokenCreate(&api.ACLToken{
+		Policies: []*api.ACLTokenPolicyLink{{ID: globalManagementPolicyID}},
+	}, nil)
+	require.NoError(t, err)
+	k8s := fake.NewSimpleClientset(bootstrapSecret(map[string]string{
+		secretKey: newToken.SecretID,
+		secretKey + secretrotation.PreviousKeySuffix: bootToken,
+	}))
+
+	accessorID, err := rotator(k8s, svr).Rotate(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, newToken.AccessorID, accessorID)
+	secret := readSecret(t, k8s)
+	require.Equal(t, newToken.SecretID, string(secret.Data[secretKey]))
+	require.Len(t, secret.Data, 1)
+	_, _, err = client(t, svr, bootToken).ACL().TokenReadSelf(nil)
+	require.True(t, isACLNotFound(err), "expected the old token to be revoked, got %v", err)
+
+	// Resuming again after the revocation only cleans up the Secret.
+	k8s = fake.NewSimpleClientset(bootstrapSecret(map[string]string{
+		secretKey: newToken.SecretID,
+		secretKey + secretrotation.PreviousKeySuffix: bootToken,
+	}))
+	_, err = rotator(k8s, svr).Rotate(context.Background())
+	require.NoError(t, err)
+	require.Len(t, readSecret(t, k8s).Data, 1)
+}
+
+func TestRotate_Errors(t *testing.T) {
+	t.Parallel()
+	servers := []*consul.ServerConfig{{Address: "127.0.0.1:8500"}}
+
+	r := &Rotator{
+		Clientset:  fake.NewSimpleClientset(),
+		Namespace:  ns,
+		SecretName: secretName,
+		SecretKey:  secretKey,
+		Servers:    servers,
+	}
+	_, err := r.Rotate(context.Background())
+	require.EqualError(t, err, `reading bootstrap Secret "consul-bootstrap-acl-token": secrets "consul-bootstrap-acl-token" not found`)
+
+	r.Clientset = fake.NewSimpleClientset(bootstrapSecret(map[string]string{"other": "value"}))
+	_, err = r.Rotate(context.Background())
+	require.EqualError(t, err, `bootstrap Secret "consul-bootstrap-acl-token" has no token in key "token"`)
+
+	// A concurrent update of the Secret stops the rotation before Consul is
+	// called.
+	k8s := fake.NewSimpleClientset(bootstrapSecret(map[string]string{secretKey: bootToken}))
+	k8s.PrependReactor("update", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8serrors.NewConflict(schema.GroupResource{Resource: "secrets"}, secretName, nil)
+	})
+	r.Clientset = k8s
+	_, err = r.Rotate(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "run the rotation again to resume it")
+
+	r.Servers = nil
+	_, err = r.Rotate(context.Background())
+	require.EqualError(t, err, "no Consul servers to rotate the bootstrap token on")
+}
+
+func testServer(t *testing.T) *testutil.TestServer {
+	svr, err := testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
+		c.ACL.Enabled = true
+		c.ACL.Tokens.InitialManagement = bootToken
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = svr.Stop()
+	})
+	svr.WaitForLeader(t)
+	return svr
+}
+
+func rotator(k8s *fake.Clientset, svr *testutil.TestServer) *Rotator {
+	return &Rotator{
+		Clientset:     k8s,
+		Namespace:     ns,
+		SecretName:    secretName,
+		SecretKey:     secretKey,
+		Servers:       []*consul.ServerConfig{{Address: svr.HTTPAddr}},
+		RetryInterval: 100 * time.Millisecond,
+	}
+}
+
+func client(t *testing.T, svr *testutil.TestServer, token string) *api.Client {
+	c, err := api.NewClient(&api.Config{Address: svr.HTTPAddr, Token: token})
+	require.NoError(t, err)
+	return c
+}
+
+func bootstrapSecret(data map[string]string) *apiv1.Secret {
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: ns},
+		Data:       map[string][]byte{},
+	}
+	for k, v := range data {
+		secret.Data[k] = []byte(v)
+	}
+	return secret
+}
+
+func readSecret(t *testing.T, k8s *fake.Clientset) *apiv1.Secret {
+	secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), secretName, metav1.GetOptions{})
+	require.NoError(t, err)
+	return secret
+}
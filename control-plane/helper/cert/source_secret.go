@@ -0,0 +1,111 @@
+package cert
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// defaultSecretResync is how often SecretSource reads the Secret again in
+	// case an update was missed by the watch.
+	defaultSecretResync = 5 * time.Minute
+
+	// caCertKey is the key of the CA certificate in TLS Secrets issued by
+	// cert-manager.
+	caCertKey = "ca.crt"
+)
+
+// SecretSource loads certificates from a Kubernetes TLS Secret that is managed
+// by another system, for example cert-manager. The Secret must have the
+// tls.crt, tls.key and ca.crt keys.
+//
+// The Secret is watched so that renewed certificates are returned as soon as
+// the Secret is updated.
+type SecretSource struct {
+	Clientset kubernetes.Interface
+	Name      string
+	Namespace string
+
+	// resync overrides defaultSecretResync if set (only set in tests).
+	resync time.Duration
+}
+
+// Certificate implements Source.
+func (s *SecretSource) Certificate(ctx context.Context, last *Bundle) (Bundle, error) {
+	resync := s.resync
+	if resync == 0 {
+		resync = defaultSecretResync
+	}
+
+	for {
+		secret, err := s.Clientset.CoreV1().Secrets(s.Namespace).Get(ctx, s.Name, metav1.GetOptions{})
+		if err != nil {
+			return Bundle{}, fmt.Errorf("reading Secret %s/%s: %w", s.Namespace, s.Name, err)
+		}
+		result, err := secretBundle(secret)
+		if err != nil {
+			return Bundle{}, err
+		}
+		if !last.Equal(&result) {
+			return result, nil
+		}
+
+		// The certificates are unchanged so we block until the Secret is
+		// updated.
+		w, err := s.Clientset.CoreV1().Secrets(s.Namespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", s.Name).String(),
+			ResourceVersion: secret.ResourceVersion,
+		})
+		if err != nil {
+			return Bundle{}, fmt.Errorf("watching Secret %s/%s: %w", s.Namespace, s.Name, err)
+		}
+		s.waitForUpdate(ctx, w, resync)
+		w.Stop()
+		if ctx.Err() != nil {
+			return Bundle{}, ctx.Err()
+		}
+	}
+}
+
+// waitForUpdate returns when the watch has an event for the Secret, when the
+// watch is closed or when the resync interval has passed.
+func (s *SecretSource) waitForUpdate(ctx context.Context, w watch.Interface, resync time.Duration) {
+	timer := time.NewTimer(resync)
+	defer timer.Stop()
+	for {
+		select {
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return
+			}
+			if secret, isSecret := event.Object.(*corev1.Secret); isSecret && secret.Name == s.Name {
+				return
+			}
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// secretBundle returns the certificates stored in the TLS Secret.
+func secretBundle(secret *corev1.Secret) (Bundle, error) {
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey, caCertKey} {
+		if len(secret.Data[key]) == 0 {
+			return Bundle{}, fmt.Errorf("Secret %s/%s has no %s", secret.Namespace, secret.Name, key)
+		}
+	}
+	return Bundle{
+		Cert:   secret.Data[corev1.TLSCertKey],
+		Key:    secret.Data[corev1.TLSPrivateKeyKey],
+		CACert: secret.Data[caCertKey],
+	}, nil
+}
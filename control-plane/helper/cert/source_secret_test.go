@@ -0,0 +1,101 @@
+package cert
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestSecretSource(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset(testTLSSecret("cert-1"))
+	source := &SecretSource{Clientset: k8s, Name: "webhook-cert", Namespace: "default"}
+
+	bundle, err := source.Certificate(context.Background(), nil)
+	require.NoError(t, err)
+	require.Equal(t, Bundle{Cert: []byte("cert-1"), Key: []byte("key"), CACert: []byte("ca")}, bundle)
+
+	// The next call blocks until the Secret is renewed.
+	resultCh := make(chan Bundle, 1)
+	go func() {
+		next, err := source.Certificate(context.Background(), &bundle)
+		require.NoError(t, err)
+		resultCh <- next
+	}()
+	select {
+	case <-resultCh:
+		t.Fatal("should not return the unchanged certificate")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	_, err = k8s.CoreV1().Secrets("default").Update(context.Background(), testTLSSecret("cert-2"), metav1.UpdateOptions{})
+	require.NoError(t, err)
+	select {
+	case next := <-resultCh:
+		require.Equal(t, []byte("cert-2"), next.Cert)
+	case <-time.After(2 * time.Second):
+		t.Fatal("should've received the renewed certificate")
+	}
+}
+
+// Test that updates missed by the watch are picked up when the Secret is read
+// again.
+func TestSecretSource_resync(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset(testTLSSecret("cert-1"))
+	source := &SecretSource{Clientset: k8s, Name: "webhook-cert", Namespace: "default", resync: 50 * time.Millisecond}
+	bundle, err := source.Certificate(context.Background(), nil)
+	require.NoError(t, err)
+
+	// Update the Secret before the watch is started.
+	_, err = k8s.CoreV1().Secrets("default").Update(context.Background(), testTLSSecret("cert-2"), metav1.UpdateOptions{})
+	require.NoError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	next, err := source.Certificate(ctx, &bundle)
+	require.NoError(t, err)
+	require.Equal(t, []byte("cert-2"), next.Cert)
+}
+
+func TestSecretSource_cancel(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset(testTLSSecret("cert-1"))
+	source := &SecretSource{Clientset: k8s, Name: "webhook-cert", Namespace: "default"}
+	bundle, err := source.Certificate(context.Background(), nil)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = source.Certificate(ctx, &bundle)
+	require.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestSecretSource_errors(t *testing.T) {
+	t.Parallel()
+	source := &SecretSource{Clientset: fake.NewSimpleClientset(), Name: "webhook-cert", Namespace: "default"}
+	_, err := source.Certificate(context.Background(), nil)
+	require.EqualError(t, err, `reading Secret default/webhook-cert: secrets "webhook-cert" not found`)
+
+	secret := testTLSSecret("cert-1")
+	delete(secret.Data, "ca.crt")
+	source.Clientset = fake.NewSimpleClientset(secret)
+	_, err = source.Certificate(context.Background(), nil)
+	require.EqualError(t, err, "Secret default/webhook-cert has no ca.crt")
+}
+
+func testTLSSecret(cert string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "webhook-cert", Namespace: "default"},
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       []byte(cert),
+			corev1.TLSPrivateKeyKey: []byte("key"),
+			"ca.crt":                []byte("ca"),
+		},
+	}
+}
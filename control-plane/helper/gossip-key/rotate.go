@@ -0,0 +1,208 @@
+package gossipkey
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/consul"
+	secretrotation "github.com/hashicorp/consul-k8s/control-plane/helper/secret-rotation"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"k8s.io/client-go/kubernetes"
+)
+
+// GenerateKey returns a random 32 byte gossip encryption key encoded in
+// base64.
+func GenerateKey() (string, error) {
+	// This code was copied from Consul's Keygen command:
+	// https://github.com/hashicorp/consul/blob/d652cc86e3d0322102c2b5e9026c6a60f36c17a5/command/keygen/keygen.go
+
+	key := make([]byte, 32)
+	n, err := rand.Reader.Read(key)
+
+	if err != nil {
+		return "", fmt.Errorf("error reading random data: %s", err)
+	}
+	if n != 32 {
+		return "", fmt.Errorf("couldn't read enough entropy")
+	}
+
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// Rotator replaces the gossip encryption key stored in a Kubernetes Secret
+// with a new key through the keyring API of Consul.
+//
+// The progress of a rotation is kept in the Secret by a
+// secretrotation.Rotator. The new key is activated by installing it on every
+// member of every gossip pool and making it the primary key, so agents that
+// start once it is stored in the Secret use it. The old key is retired by
+// removing it from the keyring once every member uses the new key.
+type Rotator struct {
+	Clientset  kubernetes.Interface
+	Namespace  string
+	SecretName string
+	SecretKey  string
+
+	// Server holds the configuration of a Consul client for a server. The
+	// keyring operations are forwarded to every member of the cluster by the
+	// server. Its token needs the operator:write permission when ACLs are
+	// enabled.
+	Server           *consul.ServerConfig
+	ConsulAPITimeout time.Duration
+
+	// RetryInterval is how long to wait before listing the keyring again when
+	// a member does not have the new key yet. Defaults to 1s.
+	RetryInterval time.Duration
+
+	Log hclog.Logger
+}
+
+// Rotate rotates the gossip encryption key, resuming an interrupted rotation
+// if there is one.
+func (r *Rotator) Rotate(ctx context.Context) error {
+	if r.Server == nil {
+		return errors.New("no Consul server to rotate the gossip encryption key on")
+	}
+	if r.RetryInterval == 0 {
+		r.RetryInterval = 1 * time.Second
+	}
+	if r.Log == nil {
+		r.Log = hclog.NewNullLogger()
+	}
+
+	client, err := consul.NewClient(r.Server.APIConfig(), r.ConsulAPITimeout)
+	if err != nil {
+		return fmt.Errorf("creating Consul client for address %s: %w", r.Server.Address, err)
+	}
+
+	secretRotator := &secretrotation.Rotator{
+		Clientset:  r.Clientset,
+		Namespace:  r.Namespace,
+		SecretName: r.SecretName,
+		SecretKey:  r.SecretKey,
+		Kind:       "gossip encryption",
+		Value:      "key",
+		Log:        r.Log,
+	}
+	if _, err := secretRotator.Rotate(ctx, &keyringSteps{Rotator: r, client: client}); err != nil {
+		return err
+	}
+	r.Log.Info("Rotated gossip encryption key")
+	return nil
+}
+
+// keyringSteps rotates the key through the keyring API of a server.
+type keyringSteps struct {
+	*Rotator
+	client *api.Client
+}
+
+// Generate returns a new key.
+func (s *keyringSteps) Generate() (string, error) {
+	return GenerateKey()
+}
+
+// Activate installs the new key on every member and makes it the primary key.
+func (s *keyringSteps) Activate(ctx context.Context, _, pending string) error {
+	if err := s.client.Operator().KeyringInstall(pending, nil); err != nil {
+		return fmt.Errorf("installing the new gossip encryption key: %w", err)
+	}
+	if err := s.waitForKey(ctx, s.client, pending, false); err != nil {
+		return err
+	}
+	s.Log.Info("Installed new gossip encryption key on every member")
+
+	if err := s.client.Operator().KeyringUse(pending, nil); err != nil {
+		return fmt.Errorf("making the new gossip encryption key primary: %w", err)
+	}
+	return nil
+}
+
+// Retire removes the previous key once every member uses the new key as its
+// primary key.
+func (s *keyringSteps) Retire(ctx context.Context, current, previous string, resumed bool) error {
+	if resumed {
+		// The new key was made primary before it was stored, but members
+		// that joined since then may have been given another primary key.
+		if err := s.client.Operator().KeyringUse(current, nil); err != nil {
+			return fmt.Errorf("making the new gossip encryption key primary: %w", err)
+		}
+	}
+	if err := s.waitForKey(ctx, s.client, current, true); err != nil {
+		return err
+	}
+	return s.removeKey(s.client, previous)
+}
+
+// waitForKey waits until every member of every gossip pool has the key
+// installed, or uses it as its primary key if primary is true.
+func (r *Rotator) waitForKey(ctx context.Context, client *api.Client, key string, primary bool) error {
+	for {
+		err := checkKey(client, key, primary)
+		if err == nil {
+			return nil
+		}
+		r.Log.Info("Gossip encryption key not on every member yet", "primary", primary, "err", err)
+		select {
+		case <-time.After(r.RetryInterval):
+		case <-ctx.Done():
+			return fmt.Errorf("waiting for every member to have the new gossip encryption key: %w", err)
+		}
+	}
+}
+
+// removeKey removes the old key from the keyring unless it was already removed
+// by an interrupted rotation.
+func (r *Rotator) removeKey(client *api.Client, key string) error {
+	rings, err := client.Operator().KeyringList(nil)
+	if err != nil {
+		return fmt.Errorf("listing the gossip encryption keys: %w", err)
+	}
+	installed := false
+	for _, ring := range rings {
+		if ring.Keys[key] > 0 {
+			installed = true
+		}
+	}
+	if !installed {
+		r.Log.Info("Previous gossip encryption key is already removed")
+		return nil
+	}
+	if err := client.Operator().KeyringRemove(key, nil); err != nil {
+		return fmt.Errorf("removing the previous gossip encryption key: %w", err)
+	}
+	r.Log.Info("Removed previous gossip encryption key")
+	return nil
+}
+
+// checkKey returns an error unless every member of every gossip pool has the
+// key installed, or uses it as its primary key if primary is true.
+func checkKey(client *api.Client, key string, primary bool) error {
+	rings, err := client.Operator().KeyringList(nil)
+	if err != nil {
+		return err
+	}
+	for _, ring := range rings {
+		keys := ring.Keys
+		if primary {
+			// Servers older than Consul 1.10 don't report primary keys.
+			if ring.PrimaryKeys == nil {
+				continue
+			}
+			keys = ring.PrimaryKeys
+		}
+		if keys[key] < ring.NumNodes {
+			pool := "LAN"
+			if ring.WAN {
+				pool = "WAN"
+			}
+			return fmt.Errorf("%d of %d members of the %s pool of datacenter %s have the key", keys[key], ring.NumNodes, pool, ring.Datacenter)
+		}
+	}
+	return nil
+}
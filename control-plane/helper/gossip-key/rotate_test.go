@@ -0,0 +1,322 @@
+package gossipkey
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/consul"
+	secretrotation "github.com/hashicorp/consul-k8s/control-plane/helper/secret-rotation"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	ns         = "default"
+	secretName = "consul-gossip-encryption-key"
+	secretKey  = "key"
+	oldKey     = "H6MLnzGpvjPhkgVyqsqXDlwzN+zGhvvBDYpAvdDjuHk="
+	newKey     = "YOpkRrJhPVfyG6C1IeHgCQgX/KUxOz0aTuuy3D09UA4="
+)
+
+func TestRotate(t *testing.T) {
+	t.Parallel()
+	keyring := newFakeKeyring(t, 3, oldKey)
+	k8s := fake.NewSimpleClientset(gossipSecret(map[string]string{secretKey: oldKey}))
+
+	require.NoError(t, rotator(k8s, keyring).Rotate(context.Background()))
+
+	secret := readSecret(t, k8s)
+	rotated := string(secret.Data[secretKey])
+	require.NotEqual(t, oldKey, rotated)
+	require.Len(t, secret.Data, 1)
+	require.Equal(t, []string{"install", "use", "remove"}, keyring.calls)
+	require.Equal(t, map[string]int{rotated: 3}, keyring.keys)
+	require.Equal(t, rotated, keyring.primary)
+}
+
+// Test that the new key is only made primary once every member has it and
+// that the old key is only removed once every member uses the new key.
+func TestRotate_WaitsForEveryMember(t *testing.T) {
+	t.Parallel()
+	keyring := newFakeKeyring(t, 3, oldKey)
+	keyring.lag = true
+	k8s := fake.NewSimpleClientset(gossipSecret(map[string]string{secretKey: oldKey}))
+
+	require.NoError(t, rotator(k8s, keyring).Rotate(context.Background()))
+	require.Equal(t, []string{"install", "use", "remove"}, keyring.calls)
+	require.Equal(t, []int{3, 3}, keyring.membersAtCall)
+}
+
+func TestRotate_Timeout(t *testing.T) {
+	t.Parallel()
+	keyring := newFakeKeyring(t, 3, oldKey)
+	keyring.stuck = true
+	k8s := fake.NewSimpleClientset(gossipSecret(map[string]string{secretKey: oldKey}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	err := rotator(k8s, keyring).Rotate(ctx)
+	require.EqualError(t, err, "waiting for every member to have the new gossip encryption key: 1 of 3 members of the LAN pool of datacenter dc1 have the key")
+
+	// The new key is kept in the Secret so that the rotation can be resumed.
+	secret := readSecret(t, k8s)
+	require.Equal(t, oldKey, string(secret.Data[secretKey]))
+	require.NotEmpty(t, secret.Data[secretKey+secretrotation.PendingKeySuffix])
+	require.Equal(t, []string{"install"}, keyring.calls)
+}
+
+// Test that a rotation interrupted before the new key was made primary
+// installs the key that was recorded as pending.
+func TestRotate_ResumePending(t *testing.T) {
+	t.Parallel()
+	keyring := newFakeKeyring(t, 3, oldKey)
+	k8s := fake.NewSimpleClientset(gossipSecret(map[string]string{
+		secretKey: oldKey,
+		secretKey + secretrotation.PendingKeySuffix: newKey,
+	}))
+
+	require.NoError(t, rotator(k8s, keyring).Rotate(context.Background()))
+	secret := readSecret(t, k8s)
+	require.Equal(t, newKey, string(secret.Data[secretKey]))
+	require.Len(t, secret.Data, 1)
+	require.Equal(t, map[string]int{newKey: 3}, keyring.keys)
+}
+
+// Test that a rotation interrupted after the new key was stored removes the
+// previous key.
+func TestRotate_ResumePrevious(t *testing.T) {
+	t.Parallel()
+	keyring := newFakeKeyring(t, 3, oldKey)
+	keyring.keys[newKey] = 3
+	keyring.primary = newKey
+	k8s := fake.NewSimpleClientset(gossipSecret(map[string]string{
+		secretKey: newKey,
+		secretKey + secretrotation.PreviousKeySuffix: oldKey,
+	}))
+
+	require.NoError(t, rotator(k8s, keyring).Rotate(context.Background()))
+	require.Len(t, readSecret(t, k8s).Data, 1)
+	require.Equal(t, []string{"use", "remove"}, keyring.calls)
+	require.Equal(t, map[string]int{newKey: 3}, keyring.keys)
+
+	// Resuming again after the removal only cleans up the Secret.
+	k8s = fake.NewSimpleClientset(gossipSecret(map[string]string{
+		secretKey: newKey,
+		secretKey + secretrotation.PreviousKeySuffix: oldKey,
+	}))
+	require.NoError(t, rotator(k8s, keyring).Rotate(context.Background()))
+	require.Len(t, readSecret(t, k8s).Data, 1)
+	require.Equal(t, []string{"use", "remove", "use"}, keyring.calls)
+}
+
+func TestRotate_Errors(t *testing.T) {
+	t.Parallel()
+	keyring := newFakeKeyring(t, 1, oldKey)
+
+	r := rotator(fake.NewSimpleClientset(), keyring)
+	err := r.Rotate(context.Background())
+	require.EqualError(t, err, `reading gossip encryption Secret "consul-gossip-encryption-key": secrets "consul-gossip-encryption-key" not found`)
+
+	r.Clientset = fake.NewSimpleClientset(gossipSecret(map[string]string{"other": "value"}))
+	err = r.Rotate(context.Background())
+	require.EqualError(t, err, `gossip encryption Secret "consul-gossip-encryption-key" has no key in key "key"`)
+
+	// A concurrent update of the Secret stops the rotation before Consul is
+	// called.
+	k8s := fake.NewSimpleClientset(gossipSecret(map[string]string{secretKey: oldKey}))
+	k8s.PrependReactor("update", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8serrors.NewConflict(schema.GroupResource{Resource: "secrets"}, secretName, nil)
+	})
+	r.Clientset = k8s
+	err = r.Rotate(context.Background())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "run the rotation again to resume it")
+	require.Empty(t, keyring.calls)
+
+	r.Server = nil
+	err = r.Rotate(context.Background())
+	require.EqualError(t, err, "no Consul server to rotate the gossip encryption key on")
+}
+
+// Test the rotation against the keyring of a Consul server.
+func TestRotate_ConsulServer(t *testing.T) {
+	t.Parallel()
+	svr, err := testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
+		c.Encrypt = oldKey
+	})
+	require.NoError(t, err)
+	defer svr.Stop()
+	svr.WaitForLeader(t)
+
+	k8s := fake.NewSimpleClientset(gossipSecret(map[string]string{secretKey: oldKey}))
+	r := &Rotator{
+		Clientset:     k8s,
+		Namespace:     ns,
+		SecretName:    secretName,
+		SecretKey:     secretKey,
+		Server:        &consul.ServerConfig{Address: svr.HTTPAddr},
+		RetryInterval: 100 * time.Millisecond,
+	}
+	require.NoError(t, r.Rotate(context.Background()))
+	rotated := string(readSecret(t, k8s).Data[secretKey])
+
+	client, err := api.NewClient(&api.Config{Address: svr.HTTPAddr})
+	require.NoError(t, err)
+	rings, err := client.Operator().KeyringList(nil)
+	require.NoError(t, err)
+	for _, ring := range rings {
+		require.Equal(t, map[string]int{rotated: ring.NumNodes}, ring.Keys)
+	}
+}
+
+// fakeKeyring serves the keyring API of a Consul server for a single LAN pool.
+type fakeKeyring struct {
+	mu       sync.Mutex
+	numNodes int
+	keys     map[string]int
+	primary  string
+
+	// primaryNodes is the number of members that use the primary key.
+	primaryNodes int
+
+	// lag makes a newly installed or used key reach one more member on every
+	// list. stuck makes it never reach more than one member.
+	lag   bool
+	stuck bool
+
+	// calls holds the keyring operations in order and membersAtCall the number
+	// of members that had the key when it was used or when the primary key was
+	// used when the previous key was removed.
+	calls         []string
+	membersAtCall []int
+	addr          string
+}
+
+func newFakeKeyring(t *testing.T, numNodes int, key string) *fakeKeyring {
+	k := &fakeKeyring{
+		numNodes:     numNodes,
+		keys:         map[string]int{key: numNodes},
+		primary:      key,
+		primaryNodes: numNodes,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(k.serveHTTP))
+	t.Cleanup(srv.Close)
+	k.addr = srv.URL
+	return k
+}
+
+func (k *fakeKeyring) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if r.URL.Path != "/v1/operator/keyring" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodGet {
+		k.spread()
+		primaryKeys := map[string]int{k.primary: k.primaryNodes}
+		_ = json.NewEncoder(w).Encode([]*api.KeyringResponse{{
+			Datacenter:  "dc1",
+			Keys:        k.keys,
+			PrimaryKeys: primaryKeys,
+			NumNodes:    k.numNodes,
+		}})
+		return
+	}
+
+	var req struct{ Key string }
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		k.calls = append(k.calls, "install")
+		if k.keys[req.Key] == 0 {
+			k.keys[req.Key] = k.reached()
+		}
+	case http.MethodPut:
+		k.calls = append(k.calls, "use")
+		if k.keys[req.Key] == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		if k.primary != req.Key {
+			k.membersAtCall = append(k.membersAtCall, k.keys[req.Key])
+			k.primary = req.Key
+			k.primaryNodes = k.reached()
+		}
+	case http.MethodDelete:
+		k.calls = append(k.calls, "remove")
+		if req.Key == k.primary {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		k.membersAtCall = append(k.membersAtCall, k.primaryNodes)
+		delete(k.keys, req.Key)
+	}
+}
+
+// reached returns the number of members a new key reaches immediately.
+func (k *fakeKeyring) reached() int {
+	if k.lag || k.stuck {
+		return 1
+	}
+	return k.numNodes
+}
+
+// spread gives keys that lag one more member.
+func (k *fakeKeyring) spread() {
+	if !k.lag {
+		return
+	}
+	for key, n := range k.keys {
+		if n < k.numNodes {
+			k.keys[key] = n + 1
+		}
+	}
+	if k.primaryNodes < k.numNodes {
+		k.primaryNodes++
+	}
+}
+
+func rotator(k8s *fake.Clientset, keyring *fakeKeyring) *Rotator {
+	return &Rotator{
+		Clientset:     k8s,
+		Namespace:     ns,
+		SecretName:    secretName,
+		SecretKey:     secretKey,
+		Server:        &consul.ServerConfig{Address: keyring.addr},
+		RetryInterval: 10 * time.Millisecond,
+	}
+}
+
+func gossipSecret(data map[string]string) *apiv1.Secret {
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: ns},
+		Data:       map[string][]byte{},
+	}
+	for k, v := range data {
+		secret.Data[k] = []byte(v)
+	}
+	return secret
+}
+
+func readSecret(t *testing.T, k8s *fake.Clientset) *apiv1.Secret {
+	secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), secretName, metav1.GetOptions{})
+	require.NoError(t, err)
+	return secret
+}
@@ -18,34 +18,58 @@ func UpdateWithCABundle(ctx context.Context, clientset kubernetes.Interface, web
 	if len(caCert) == 0 {
 		return errors.New("no CA certificate in the bundle")
 	}
-	value := base64.StdEncoding.EncodeToString(caCert)
 	webhookCfg, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, webhookConfigName, metav1.GetOptions{})
 
 	if err != nil {
 		return err
 	}
+	patchesJson, err := caBundlePatch(len(webhookCfg.Webhooks), caCert)
+	if err != nil {
+		return err
+	}
+
+	if _, err = clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Patch(ctx, webhookConfigName, types.JSONPatchType, patchesJson, metav1.PatchOptions{}); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateValidatingWithCABundle iterates over every webhook on the specified validating webhook
+// configuration and updates their caBundle with the specified CA.
+func UpdateValidatingWithCABundle(ctx context.Context, clientset kubernetes.Interface, webhookConfigName string, caCert []byte) error {
+	if len(caCert) == 0 {
+		return errors.New("no CA certificate in the bundle")
+	}
+	webhookCfg, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, webhookConfigName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	patchesJson, err := caBundlePatch(len(webhookCfg.Webhooks), caCert)
+	if err != nil {
+		return err
+	}
+
+	_, err = clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Patch(ctx, webhookConfigName, types.JSONPatchType, patchesJson, metav1.PatchOptions{})
+	return err
+}
+
+// caBundlePatch returns a JSON patch that sets the caBundle of the given number of webhooks.
+func caBundlePatch(webhooks int, caCert []byte) ([]byte, error) {
 	type patch struct {
 		Op    string `json:"op,omitempty"`
 		Path  string `json:"path,omitempty"`
 		Value string `json:"value,omitempty"`
 	}
 
+	value := base64.StdEncoding.EncodeToString(caCert)
 	var patches []patch
-	for i := range webhookCfg.Webhooks {
+	for i := 0; i < webhooks; i++ {
 		patches = append(patches, patch{
 			Op:    "add",
 			Path:  fmt.Sprintf("/webhooks/%d/clientConfig/caBundle", i),
 			Value: value,
 		})
 	}
-	patchesJson, err := json.Marshal(patches)
-	if err != nil {
-		return err
-	}
-
-	if _, err = clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Patch(ctx, webhookConfigName, types.JSONPatchType, patchesJson, metav1.PatchOptions{}); err != nil {
-		return err
-	}
-
-	return nil
+	return json.Marshal(patches)
 }
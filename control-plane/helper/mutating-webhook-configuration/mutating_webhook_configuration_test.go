@@ -42,3 +42,39 @@ func TestUpdateWithCABundle_patchesExistingConfiguration(t *testing.T) {
 	require.NoError(t, err)
 	require.Equal(t, caBundleOne, mwcFetched.Webhooks[0].ClientConfig.CABundle)
 }
+
+func TestUpdateValidatingWithCABundle_emptyCertReturnsError(t *testing.T) {
+	var bytes []byte
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+
+	err := UpdateValidatingWithCABundle(ctx, clientset, "foo", bytes)
+	require.EqualError(t, err, "no CA certificate in the bundle")
+}
+
+func TestUpdateValidatingWithCABundle_patchesExistingConfiguration(t *testing.T) {
+	caBundle := []byte("ca-bundle-for-vwc")
+	ctx := context.Background()
+	vwc := &admissionv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "vwc-one",
+		},
+		Webhooks: []admissionv1.ValidatingWebhook{
+			{
+				Name: "webhook-one-under-test",
+			},
+			{
+				Name: "webhook-two-under-test",
+			},
+		},
+	}
+	clientset := fake.NewSimpleClientset(vwc)
+
+	err := UpdateValidatingWithCABundle(ctx, clientset, vwc.Name, caBundle)
+	require.NoError(t, err)
+	vwcFetched, err := clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, vwc.Name, metav1.GetOptions{})
+	require.NoError(t, err)
+	for _, webhook := range vwcFetched.Webhooks {
+		require.Equal(t, caBundle, webhook.ClientConfig.CABundle)
+	}
+}
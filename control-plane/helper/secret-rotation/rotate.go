@@ -0,0 +1,142 @@
+package secretrotation
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/hashicorp/go-hclog"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// PendingKeySuffix is appended to the key of the value in the Secret to
+	// store the new value until it replaces the current one.
+	PendingKeySuffix = ".pending"
+
+	// PreviousKeySuffix is appended to the key of the value in the Secret to
+	// store the replaced value until it is retired.
+	PreviousKeySuffix = ".previous"
+)
+
+// Steps are the steps of a rotation that depend on what is rotated. Activate
+// and Retire are run again with the same values when an interrupted rotation
+// is resumed, so they must succeed if they already ran.
+type Steps interface {
+	// Generate returns a new value.
+	Generate() (string, error)
+
+	// Activate makes the pending value usable everywhere the current value
+	// is, before the pending value replaces the current value in the Secret.
+	Activate(ctx context.Context, current, pending string) error
+
+	// Retire stops the use of the previous value once the current value
+	// replaced it in the Secret. resumed is true when an interrupted rotation
+	// is resumed with this step.
+	Retire(ctx context.Context, current, previous string, resumed bool) error
+}
+
+// Rotator replaces a value stored in a key of a Kubernetes Secret with a new
+// value.
+//
+// The progress of a rotation is kept in the Secret itself, so a rotation that
+// was interrupted is resumed by running Rotate again:
+//
+//  1. The new value is written to the pending key.
+//  2. The new value is activated.
+//  3. The new value replaces the current one, which is moved to the previous
+//     key. This happens in a single update of the Secret.
+//  4. The previous value is retired and the previous key is removed.
+//
+// Every update of the Secret is conditional on the version that was read, so
+// concurrent rotations fail instead of losing a value.
+type Rotator struct {
+	Clientset  kubernetes.Interface
+	Namespace  string
+	SecretName string
+	SecretKey  string
+
+	// Kind and Value name what is rotated in logs and errors, e.g. "gossip
+	// encryption" and "key".
+	Kind  string
+	Value string
+
+	Log hclog.Logger
+}
+
+// Rotate rotates the value, resuming an interrupted rotation if there is one.
+// It returns the new value.
+func (r *Rotator) Rotate(ctx context.Context, steps Steps) (string, error) {
+	if r.Log == nil {
+		r.Log = hclog.NewNullLogger()
+	}
+	pendingKey := r.SecretKey + PendingKeySuffix
+	previousKey := r.SecretKey + PreviousKeySuffix
+
+	secret, err := r.Clientset.CoreV1().Secrets(r.Namespace).Get(ctx, r.SecretName, metav1.GetOptions{})
+	if err != nil {
+		return "", fmt.Errorf("reading %s Secret %q: %w", r.Kind, r.SecretName, err)
+	}
+	current := string(secret.Data[r.SecretKey])
+	if current == "" {
+		return "", fmt.Errorf("%s Secret %q has no %s in key %q", r.Kind, r.SecretName, r.Value, r.SecretKey)
+	}
+	pending := string(secret.Data[pendingKey])
+	previous := string(secret.Data[previousKey])
+
+	resumed := previous != ""
+	if !resumed {
+		if pending == "" {
+			pending, err = steps.Generate()
+			if err != nil {
+				return "", fmt.Errorf("generating the new %s %s: %w", r.Kind, r.Value, err)
+			}
+			secret.Data[pendingKey] = []byte(pending)
+			if secret, err = r.updateSecret(ctx, secret); err != nil {
+				return "", err
+			}
+			r.Log.Info(fmt.Sprintf("Starting %s %s rotation", r.Kind, r.Value))
+		} else {
+			r.Log.Info(fmt.Sprintf("Resuming %s %s rotation with the pending %s", r.Kind, r.Value, r.Value), "key", pendingKey)
+		}
+
+		if err := steps.Activate(ctx, current, pending); err != nil {
+			return "", err
+		}
+
+		secret.Data[r.SecretKey] = []byte(pending)
+		secret.Data[previousKey] = []byte(current)
+		delete(secret.Data, pendingKey)
+		if secret, err = r.updateSecret(ctx, secret); err != nil {
+			return "", err
+		}
+		r.Log.Info(fmt.Sprintf("Updated %s Secret with the new %s", r.Kind, r.Value), "secret", r.SecretName)
+		previous, current = current, pending
+	} else {
+		r.Log.Info(fmt.Sprintf("Resuming %s %s rotation with the retirement of the previous %s", r.Kind, r.Value, r.Value), "key", previousKey)
+	}
+
+	if err := steps.Retire(ctx, current, previous, resumed); err != nil {
+		return "", err
+	}
+	delete(secret.Data, previousKey)
+	if _, err := r.updateSecret(ctx, secret); err != nil {
+		return "", err
+	}
+	return current, nil
+}
+
+// updateSecret writes the Secret back. The update fails if the Secret changed
+// since it was read.
+func (r *Rotator) updateSecret(ctx context.Context, secret *apiv1.Secret) (*apiv1.Secret, error) {
+	updated, err := r.Clientset.CoreV1().Secrets(r.Namespace).Update(ctx, secret, metav1.UpdateOptions{})
+	if k8serrors.IsConflict(err) {
+		return nil, fmt.Errorf("%s Secret %q was modified during the rotation, run the rotation again to resume it: %w", r.Kind, r.SecretName, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("updating %s Secret %q: %w", r.Kind, r.SecretName, err)
+	}
+	return updated, nil
+}
@@ -0,0 +1,160 @@
+package secretrotation
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+const (
+	ns         = "default"
+	secretName = "consul-secret"
+	secretKey  = "value"
+)
+
+func TestRotate(t *testing.T) {
+	k8s := fake.NewSimpleClientset(testSecret(map[string]string{secretKey: "old"}))
+	steps := &fakeSteps{t: t, k8s: k8s, next: "new"}
+
+	current, err := rotator(k8s).Rotate(context.Background(), steps)
+	require.NoError(t, err)
+	require.Equal(t, "new", current)
+	require.Equal(t, []string{"generate", "activate old new", "retire new old false"}, steps.calls)
+	require.Equal(t, map[string][]byte{secretKey: []byte("new")}, readSecret(t, k8s).Data)
+}
+
+func TestRotate_ResumePending(t *testing.T) {
+	k8s := fake.NewSimpleClientset(testSecret(map[string]string{
+		secretKey:                    "old",
+		secretKey + PendingKeySuffix: "pending",
+	}))
+	steps := &fakeSteps{t: t, k8s: k8s}
+
+	current, err := rotator(k8s).Rotate(context.Background(), steps)
+	require.NoError(t, err)
+	require.Equal(t, "pending", current)
+	require.Equal(t, []string{"activate old pending", "retire pending old false"}, steps.calls)
+	require.Equal(t, map[string][]byte{secretKey: []byte("pending")}, readSecret(t, k8s).Data)
+}
+
+func TestRotate_ResumePrevious(t *testing.T) {
+	k8s := fake.NewSimpleClientset(testSecret(map[string]string{
+		secretKey:                     "new",
+		secretKey + PreviousKeySuffix: "old",
+	}))
+	steps := &fakeSteps{t: t, k8s: k8s}
+
+	current, err := rotator(k8s).Rotate(context.Background(), steps)
+	require.NoError(t, err)
+	require.Equal(t, "new", current)
+	require.Equal(t, []string{"retire new old true"}, steps.calls)
+	require.Equal(t, map[string][]byte{secretKey: []byte("new")}, readSecret(t, k8s).Data)
+}
+
+// Test that the Secret keeps the progress of a rotation that fails, so that
+// the rotation can be resumed.
+func TestRotate_StepErrors(t *testing.T) {
+	k8s := fake.NewSimpleClientset(testSecret(map[string]string{secretKey: "old"}))
+	steps := &fakeSteps{t: t, k8s: k8s, next: "new", activateErr: errors.New("activate failed")}
+	_, err := rotator(k8s).Rotate(context.Background(), steps)
+	require.EqualError(t, err, "activate failed")
+	require.Equal(t, map[string][]byte{secretKey: []byte("old"), secretKey + PendingKeySuffix: []byte("new")}, readSecret(t, k8s).Data)
+
+	steps = &fakeSteps{t: t, k8s: k8s, retireErr: errors.New("retire failed")}
+	_, err = rotator(k8s).Rotate(context.Background(), steps)
+	require.EqualError(t, err, "retire failed")
+	require.Equal(t, map[string][]byte{secretKey: []byte("new"), secretKey + PreviousKeySuffix: []byte("old")}, readSecret(t, k8s).Data)
+
+	steps = &fakeSteps{t: t, k8s: k8s}
+	_, err = rotator(k8s).Rotate(context.Background(), steps)
+	require.NoError(t, err)
+	require.Equal(t, []string{"retire new old true"}, steps.calls)
+}
+
+func TestRotate_Errors(t *testing.T) {
+	k8s := fake.NewSimpleClientset()
+	_, err := rotator(k8s).Rotate(context.Background(), &fakeSteps{t: t, k8s: k8s})
+	require.EqualError(t, err, `reading test Secret "consul-secret": secrets "consul-secret" not found`)
+
+	k8s = fake.NewSimpleClientset(testSecret(map[string]string{}))
+	_, err = rotator(k8s).Rotate(context.Background(), &fakeSteps{t: t, k8s: k8s})
+	require.EqualError(t, err, `test Secret "consul-secret" has no value in key "value"`)
+
+	k8s = fake.NewSimpleClientset(testSecret(map[string]string{secretKey: "old"}))
+	k8s.PrependReactor("update", "secrets", func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, k8serrors.NewConflict(schema.GroupResource{Resource: "secrets"}, secretName, errors.New("modified"))
+	})
+	_, err = rotator(k8s).Rotate(context.Background(), &fakeSteps{t: t, k8s: k8s, next: "new"})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `test Secret "consul-secret" was modified during the rotation, run the rotation again to resume it`)
+}
+
+// fakeSteps records the steps that are run and checks that the Secret holds
+// the progress of the rotation when they run.
+type fakeSteps struct {
+	t   *testing.T
+	k8s *fake.Clientset
+
+	next        string
+	activateErr error
+	retireErr   error
+	calls       []string
+}
+
+func (s *fakeSteps) Generate() (string, error) {
+	s.calls = append(s.calls, "generate")
+	return s.next, nil
+}
+
+func (s *fakeSteps) Activate(_ context.Context, current, pending string) error {
+	s.calls = append(s.calls, "activate "+current+" "+pending)
+	require.Equal(s.t, pending, string(readSecret(s.t, s.k8s).Data[secretKey+PendingKeySuffix]))
+	return s.activateErr
+}
+
+func (s *fakeSteps) Retire(_ context.Context, current, previous string, resumed bool) error {
+	if resumed {
+		s.calls = append(s.calls, "retire "+current+" "+previous+" true")
+	} else {
+		s.calls = append(s.calls, "retire "+current+" "+previous+" false")
+	}
+	require.Equal(s.t, previous, string(readSecret(s.t, s.k8s).Data[secretKey+PreviousKeySuffix]))
+	return s.retireErr
+}
+
+func rotator(k8s *fake.Clientset) *Rotator {
+	return &Rotator{
+		Clientset:  k8s,
+		Namespace:  ns,
+		SecretName: secretName,
+		SecretKey:  secretKey,
+		Kind:       "test",
+		Value:      "value",
+	}
+}
+
+func testSecret(data map[string]string) *apiv1.Secret {
+	secret := &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: ns},
+		Data:       map[string][]byte{},
+	}
+	for k, v := range data {
+		secret.Data[k] = []byte(v)
+	}
+	return secret
+}
+
+func readSecret(t *testing.T, k8s *fake.Clientset) *apiv1.Secret {
+	secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), secretName, metav1.GetOptions{})
+	require.NoError(t, err)
+	return secret
+}
@@ -45,9 +45,10 @@ type Command struct {
 	flagACLDir            string
 	flagTokenSinkFile     string
 
-	flagACLAuthMethod string // Auth Method to use for ACLs.
-	flagLogLevel      string
-	flagLogJSON       bool
+	flagACLAuthMethod   string // Auth Method to use for ACLs.
+	flagBearerTokenFile string // Location of the bearer token to login with.
+	flagLogLevel        string
+	flagLogJSON         bool
 
 	bearerTokenFile   string // Location of the bearer token. Default is defaultBearerTokenFile.
 	flagComponentName string // Name of the component to be used as metadata to ACL Login.
@@ -85,6 +86,9 @@ func (c *Command) init() {
 	c.flags.StringVar(&c.flagNamespace, "k8s-namespace", "", "Name of Kubernetes namespace where the token Kubernetes secret is stored.")
 	c.flags.StringVar(&c.flagPrimaryDatacenter, "primary-datacenter", "", "Name of the primary datacenter when federation is enabled and the command is run in a secondary datacenter.")
 	c.flags.StringVar(&c.flagACLAuthMethod, "acl-auth-method", "", "Name of the auth method to login with.")
+	c.flags.StringVar(&c.flagBearerTokenFile, "bearer-token-file", "",
+		"Path to the service account token to login with, e.g. a projected token with the audience of a jwt auth method. "+
+			"Defaults to "+defaultBearerTokenFile+".")
 	c.flags.StringVar(&c.flagComponentName, "component-name", "",
 		"Name of the component to pass to ACL Login as metadata.")
 	c.flags.Var((*flags.AppendSliceValue)(&c.flagServerAddresses), "server-address",
@@ -120,6 +124,9 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
+	if c.flagBearerTokenFile != "" {
+		c.bearerTokenFile = c.flagBearerTokenFile
+	}
 	if c.bearerTokenFile == "" {
 		c.bearerTokenFile = defaultBearerTokenFile
 	}
@@ -14,6 +14,7 @@ import (
 	"github.com/hashicorp/consul-k8s/control-plane/subcommand/common"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
@@ -241,6 +242,27 @@ func TestRun_PerformsConsulLogin(t *testing.T) {
 	require.Equal(t, "token created via login: {\"component\":\"foo\"}", tok.Description)
 }
 
+// Test that the token of -bearer-token-file is used to login.
+func TestRun_BearerTokenFile(t *testing.T) {
+	t.Parallel()
+	var logs bytes.Buffer
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		k8sClient: fake.NewSimpleClientset(),
+		logger:    hclog.New(&hclog.LoggerOptions{Output: &logs}),
+	}
+
+	code := cmd.Run([]string{
+		"-token-sink-file", filepath.Join(t.TempDir(), "acl-token"),
+		"-acl-auth-method", componentAuthMethod,
+		"-bearer-token-file", "/consul/acl-auth-method/token",
+		"-consul-api-timeout", "5s",
+	})
+	require.Equal(t, 1, code)
+	require.Contains(t, logs.String(), "unable to read bearer token file: /consul/acl-auth-method/token")
+}
+
 // TestRun_WithAclAuthMethodDefinedWritesConfigJsonWithTokenMatchingSinkFile
 // executes the consul login path and validates the token is written to
 // acl-config.json and matches the token written to sink file.
@@ -33,6 +33,7 @@ type Command struct {
 	flagACLAuthMethod          string // Auth Method to use for ACLs, if enabled.
 	flagPodName                string // Pod name.
 	flagPodNamespace           string // Pod namespace.
+	flagPodUID                 string // Pod UID.
 	flagAuthMethodNamespace    string // Consul namespace the auth-method is defined in.
 	flagConsulServiceNamespace string // Consul destination namespace for the service.
 	flagServiceAccountName     string // Service account name.
@@ -59,6 +60,7 @@ func (c *Command) init() {
 	c.flagSet.StringVar(&c.flagACLAuthMethod, "acl-auth-method", "", "Name of the auth method to login to.")
 	c.flagSet.StringVar(&c.flagPodName, "pod-name", "", "Name of the pod.")
 	c.flagSet.StringVar(&c.flagPodNamespace, "pod-namespace", "", "Name of the pod namespace.")
+	c.flagSet.StringVar(&c.flagPodUID, "pod-uid", "", "UID of the pod. It is recorded in the metadata of the ACL token.")
 	c.flagSet.StringVar(&c.flagAuthMethodNamespace, "auth-method-namespace", "", "Consul namespace the auth-method is defined in")
 	c.flagSet.StringVar(&c.flagConsulServiceNamespace, "consul-service-namespace", "", "Consul destination namespace of the service.")
 	c.flagSet.StringVar(&c.flagServiceAccountName, "service-account-name", "", "Service account name on the pod.")
@@ -117,6 +119,9 @@ func (c *Command) Run(args []string) int {
 	if c.flagACLAuthMethod != "" {
 		// loginMeta is the default metadata that we pass to the consul login API.
 		loginMeta := map[string]string{"pod": fmt.Sprintf("%s/%s", c.flagPodNamespace, c.flagPodName)}
+		if c.flagPodUID != "" {
+			loginMeta[connectinject.TokenMetaPodUIDKey] = c.flagPodUID
+		}
 		loginParams := common.LoginParams{
 			AuthMethod:      c.flagACLAuthMethod,
 			Namespace:       c.flagAuthMethodNamespace,
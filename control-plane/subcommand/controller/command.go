@@ -52,6 +52,20 @@ type Command struct {
 	flagNSMirroringPrefix          string
 	flagCrossNSACLPolicy           string
 
+	// Flags to support managing ACL objects through custom resources.
+	flagEnableACLResources               bool
+	flagACLResourcesTokenSecretName      string
+	flagACLResourcesTokenSecretKey       string
+	flagACLResourcesTokenSecretNamespace string
+
+	// Flags to support writing config entries with per-namespace ACL tokens.
+	flagEnableNamespaceTokens    bool
+	flagNamespaceTokenSecretName string
+	flagNamespaceTokenSecretKey  string
+
+	// Flags to support generating ServiceIntentions from NetworkPolicies.
+	flagEnableNetworkPolicyIntentions bool
+
 	once sync.Once
 	help string
 }
@@ -86,6 +100,25 @@ func (c *Command) init() {
 	c.flagSet.StringVar(&c.flagCrossNSACLPolicy, "consul-cross-namespace-acl-policy", "",
 		"[Enterprise Only] Name of the ACL policy to attach to all created Consul namespaces to allow service "+
 			"discovery across Consul namespaces. Only necessary if ACLs are enabled.")
+	c.flagSet.BoolVar(&c.flagEnableACLResources, "enable-acl-resources", false,
+		"Enables the ACLPolicy, ACLRole and ACLBindingRule controllers and webhooks.")
+	c.flagSet.StringVar(&c.flagACLResourcesTokenSecretName, "acl-resources-token-secret-name", "",
+		"Name of the Kubernetes secret containing the ACL token used to manage ACL objects. "+
+			"If not set, the controller's own ACL token is used.")
+	c.flagSet.StringVar(&c.flagACLResourcesTokenSecretKey, "acl-resources-token-secret-key", "token",
+		"Key of the Kubernetes secret containing the ACL token used to manage ACL objects.")
+	c.flagSet.StringVar(&c.flagACLResourcesTokenSecretNamespace, "acl-resources-token-secret-namespace", "default",
+		"Namespace of the Kubernetes secret containing the ACL token used to manage ACL objects.")
+	c.flagSet.BoolVar(&c.flagEnableNamespaceTokens, "enable-namespace-tokens", false,
+		"Enables writing config entries with an ACL token read from a secret in the Kubernetes namespace of "+
+			"each custom resource rather than with the controller's own ACL token.")
+	c.flagSet.StringVar(&c.flagNamespaceTokenSecretName, "namespace-token-secret-name", "consul-controller-token",
+		fmt.Sprintf("Name of the Kubernetes secret in each namespace containing the ACL token used to write its config entries. "+
+			"Can be overridden per namespace with the %q label on the namespace.", controller.NamespaceTokenSecretLabel))
+	c.flagSet.StringVar(&c.flagNamespaceTokenSecretKey, "namespace-token-secret-key", "token",
+		"Key of the Kubernetes secret in each namespace containing the ACL token used to write its config entries.")
+	c.flagSet.BoolVar(&c.flagEnableNetworkPolicyIntentions, "enable-network-policy-intentions", false,
+		"Enables generating ServiceIntentions resources from Kubernetes NetworkPolicies.")
 	c.flagSet.StringVar(&c.flagWebhookTLSCertDir, "webhook-tls-cert-dir", "",
 		"Directory that contains the TLS cert and key required for the webhook. The cert and key files must be named 'tls.crt' and 'tls.key' respectively.")
 	c.flagSet.BoolVar(&c.flagEnableWebhooks, "enable-webhooks", true,
@@ -163,6 +196,11 @@ func (c *Command) Run(args []string) int {
 		EnableNSMirroring:          c.flagEnableNSMirroring,
 		NSMirroringPrefix:          c.flagNSMirroringPrefix,
 		CrossNSACLPolicy:           c.flagCrossNSACLPolicy,
+		ConsulPartition:            c.httpFlags.Partition(),
+		EnableNamespaceTokens:      c.flagEnableNamespaceTokens,
+		NamespaceTokenSecretName:   c.flagNamespaceTokenSecretName,
+		NamespaceTokenSecretKey:    c.flagNamespaceTokenSecretKey,
+		APIReader:                  mgr.GetAPIReader(),
 	}
 	if err = (&controller.ServiceDefaultsController{
 		ConfigEntryController: configEntryReconciler,
@@ -255,6 +293,63 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
+	var aclResourceReconciler *controller.ACLResourceController
+	if c.flagEnableACLResources {
+		aclResourceReconciler = &controller.ACLResourceController{
+			ConsulClient:               consulClient,
+			DatacenterName:             c.flagDatacenter,
+			TokenSecretName:            c.flagACLResourcesTokenSecretName,
+			TokenSecretKey:             c.flagACLResourcesTokenSecretKey,
+			TokenSecretNamespace:       c.flagACLResourcesTokenSecretNamespace,
+			APIReader:                  mgr.GetAPIReader(),
+			EnableConsulNamespaces:     c.flagEnableNamespaces,
+			ConsulDestinationNamespace: c.flagConsulDestinationNamespace,
+			EnableNSMirroring:          c.flagEnableNSMirroring,
+			NSMirroringPrefix:          c.flagNSMirroringPrefix,
+			CrossNSACLPolicy:           c.flagCrossNSACLPolicy,
+		}
+		if err = (&controller.ACLPolicyController{
+			ACLResourceController: aclResourceReconciler,
+			Client:                mgr.GetClient(),
+			Log:                   ctrl.Log.WithName("controller").WithName(common.ACLPolicy),
+			Scheme:                mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", common.ACLPolicy)
+			return 1
+		}
+		if err = (&controller.ACLRoleController{
+			ACLResourceController: aclResourceReconciler,
+			Client:                mgr.GetClient(),
+			Log:                   ctrl.Log.WithName("controller").WithName(common.ACLRole),
+			Scheme:                mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", common.ACLRole)
+			return 1
+		}
+		if err = (&controller.ACLBindingRuleController{
+			ACLResourceController: aclResourceReconciler,
+			Client:                mgr.GetClient(),
+			Log:                   ctrl.Log.WithName("controller").WithName(common.ACLBindingRule),
+			Scheme:                mgr.GetScheme(),
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", common.ACLBindingRule)
+			return 1
+		}
+	}
+
+	if c.flagEnableNetworkPolicyIntentions {
+		if err = (&controller.NetworkPolicyController{
+			Client:     mgr.GetClient(),
+			Log:        ctrl.Log.WithName("controller").WithName("networkpolicy"),
+			Scheme:     mgr.GetScheme(),
+			Recorder:   mgr.GetEventRecorderFor("networkpolicy-controller"),
+			ConsulMeta: consulMeta,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "networkpolicy")
+			return 1
+		}
+	}
+
 	if c.flagEnableWebhooks {
 		// This webhook server sets up a Cert Watcher on the CertDir. This watches for file changes and updates the webhook certificates
 		// automatically when new certificates are available.
@@ -331,6 +426,29 @@ func (c *Command) Run(args []string) int {
 				Logger:       ctrl.Log.WithName("webhooks").WithName(common.TerminatingGateway),
 				ConsulMeta:   consulMeta,
 			}})
+		if c.flagEnableACLResources {
+			mgr.GetWebhookServer().Register("/mutate-v1alpha1-aclpolicy",
+				&webhook.Admission{Handler: &v1alpha1.ACLPolicyWebhook{
+					Client:       mgr.GetClient(),
+					ConsulClient: consulClient,
+					Logger:       ctrl.Log.WithName("webhooks").WithName(common.ACLPolicy),
+					ConsulMeta:   consulMeta,
+				}})
+			mgr.GetWebhookServer().Register("/mutate-v1alpha1-aclrole",
+				&webhook.Admission{Handler: &v1alpha1.ACLRoleWebhook{
+					Client:       mgr.GetClient(),
+					ConsulClient: consulClient,
+					Logger:       ctrl.Log.WithName("webhooks").WithName(common.ACLRole),
+					ConsulMeta:   consulMeta,
+				}})
+			mgr.GetWebhookServer().Register("/mutate-v1alpha1-aclbindingrule",
+				&webhook.Admission{Handler: &v1alpha1.ACLBindingRuleWebhook{
+					Client:       mgr.GetClient(),
+					ConsulClient: consulClient,
+					Logger:       ctrl.Log.WithName("webhooks").WithName(common.ACLBindingRule),
+					ConsulMeta:   consulMeta,
+				}})
+		}
 	}
 	// +kubebuilder:scaffold:builder
 
@@ -386,6 +504,9 @@ func (c *Command) validateFlags() error {
 	if c.flagDatacenter == "" {
 		return errors.New("Invalid arguments: -datacenter must be set")
 	}
+	if c.flagEnableNamespaceTokens && (c.flagNamespaceTokenSecretName == "" || c.flagNamespaceTokenSecretKey == "") {
+		return errors.New("Invalid arguments: -namespace-token-secret-name and -namespace-token-secret-key must be set when -enable-namespace-tokens is true")
+	}
 	if c.httpFlags.ConsulAPITimeout() <= 0 {
 		return errors.New("-consul-api-timeout must be set to a value greater than 0")
 	}
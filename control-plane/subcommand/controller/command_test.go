@@ -35,6 +35,11 @@ func TestRun_FlagValidation(t *testing.T) {
 				"-consul-api-timeout", "5s", "-log-level", "invalid"},
 			expErr: `unknown log level "invalid": unrecognized level: "invalid"`,
 		},
+		{
+			flags: []string{"-webhook-tls-cert-dir", "/foo", "-datacenter", "foo",
+				"-enable-namespace-tokens", "-namespace-token-secret-name", ""},
+			expErr: "-namespace-token-secret-name and -namespace-token-secret-key must be set when -enable-namespace-tokens is true",
+		},
 	}
 
 	for _, c := range cases {
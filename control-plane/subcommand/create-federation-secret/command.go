@@ -7,6 +7,8 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,11 +20,13 @@ import (
 	"github.com/hashicorp/consul-k8s/control-plane/subcommand/flags"
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-multierror"
 	"github.com/mitchellh/cli"
 	corev1 "k8s.io/api/core/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 const (
@@ -31,6 +35,14 @@ const (
 	fedSecretCAKeyKey            = "caKey"
 	fedSecretServerConfigKey     = "serverConfigJSON"
 	fedSecretReplicationTokenKey = "replicationToken"
+
+	// remoteKubeconfigKey is the key of the kubeconfig in the secrets of
+	// -remote-kubeconfig-secret.
+	remoteKubeconfigKey = "kubeconfig"
+
+	// localCluster is the cluster label of the metrics of the federation
+	// secret in this cluster.
+	localCluster = "local"
 )
 
 var retryInterval = 1 * time.Second
@@ -62,12 +74,28 @@ type Command struct {
 	flagLogJSON                bool
 	flagMeshGatewayServiceName string
 
+	// flagRemoteKubeconfigSecrets are the names of the secrets with the
+	// kubeconfigs of the remote clusters the federation secret is also
+	// written to.
+	flagRemoteKubeconfigSecrets []string
+
+	// flags for the refresh mode.
+	flagRefresh         bool
+	flagRefreshInterval time.Duration
+	flagListen          string
+
 	k8sClient    kubernetes.Interface
 	consulClient *api.Client
 
-	once sync.Once
-	help string
-	ctx  context.Context
+	// newRemoteClient overrides creating the clients of the remote clusters
+	// from their kubeconfigs (only set in tests).
+	newRemoteClient func(kubeconfig []byte) (kubernetes.Interface, error)
+
+	once    sync.Once
+	help    string
+	ctx     context.Context
+	sigCh   chan os.Signal
+	metrics *refreshMetrics
 }
 
 func (c *Command) init() {
@@ -88,6 +116,17 @@ func (c *Command) init() {
 		"Name of Kubernetes namespace where Consul is deployed.")
 	c.flags.StringVar(&c.flagMeshGatewayServiceName, "mesh-gateway-service-name", "",
 		"Name of the mesh gateway service registered into Consul.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagRemoteKubeconfigSecrets), "remote-kubeconfig-secret",
+		"Name of a secret in -k8s-namespace with the kubeconfig of a remote cluster under the 'kubeconfig' key. "+
+			"The federation secret is also written to that cluster, in the namespace of the current context of the "+
+			"kubeconfig or else in -k8s-namespace. This flag may be provided multiple times.")
+	c.flags.BoolVar(&c.flagRefresh, "refresh", false,
+		"If true, keeps running and rewrites the federation secret whenever its data changes "+
+			"instead of writing it once.")
+	c.flags.DurationVar(&c.flagRefreshInterval, "refresh-interval", time.Minute,
+		"The interval between reads of the federation data when -refresh is set.")
+	c.flags.StringVar(&c.flagListen, "listen", ":8080",
+		"Address to bind the listener for the metrics to when -refresh is set.")
 	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\".")
@@ -122,53 +161,13 @@ func (c *Command) Run(args []string) int {
 		c.ctx = context.Background()
 	}
 
-	// The initial secret struct. We will be filling in its data map
-	// as we continue.
-	federationSecret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      fmt.Sprintf("%s-federation", c.flagResourcePrefix),
-			Namespace: c.flagK8sNamespace,
-			Labels:    map[string]string{common.CLILabelKey: common.CLILabelValue},
-		},
-		Type: "Opaque",
-		Data: make(map[string][]byte),
-	}
-
-	// Add gossip encryption key if it exists.
-	if c.flagGossipKeyFile != "" {
-		logger.Info("Retrieving gossip encryption key data")
-		gossipKey, err := os.ReadFile(c.flagGossipKeyFile)
-		if err != nil {
-			c.UI.Error(fmt.Sprintf("Error reading gossip encryption key file: %s", err))
-			return 1
-		}
-		if len(gossipKey) == 0 {
-			c.UI.Error(fmt.Sprintf("gossip key file %q was empty", c.flagGossipKeyFile))
-			return 1
-		}
-		federationSecret.Data[fedSecretGossipKey] = gossipKey
-		logger.Info("Gossip encryption key retrieved successfully")
-	}
-
-	// Add server CA cert.
-	logger.Info("Retrieving server CA cert data")
-	caCert, err := os.ReadFile(c.flagServerCACertFile)
+	// Read the gossip encryption key and the server CA first so that missing
+	// files are reported before connecting to anything.
+	federationData, err := c.readFiles(logger)
 	if err != nil {
-		c.UI.Error(fmt.Sprintf("Error reading server CA cert file: %s", err))
-		return 1
-	}
-	federationSecret.Data[fedSecretCACertKey] = caCert
-	logger.Info("Server CA cert retrieved successfully")
-
-	// Add server CA key.
-	logger.Info("Retrieving server CA key data")
-	caKey, err := os.ReadFile(c.flagServerCAKeyFile)
-	if err != nil {
-		c.UI.Error(fmt.Sprintf("Error reading server CA key file: %s", err))
+		c.UI.Error(err.Error())
 		return 1
 	}
-	federationSecret.Data[fedSecretCAKeyKey] = caKey
-	logger.Info("Server CA key retrieved successfully")
 
 	// Create the Kubernetes clientset.
 	if c.k8sClient == nil {
@@ -193,7 +192,7 @@ func (c *Command) Run(args []string) int {
 			logger.Error("error retrieving replication token", "err", err)
 			return 1
 		}
-		federationSecret.Data[fedSecretReplicationTokenKey] = replicationToken
+		federationData[fedSecretReplicationTokenKey] = replicationToken
 	}
 
 	// Set up Consul client because we need to make calls to Consul to retrieve
@@ -218,44 +217,191 @@ func (c *Command) Run(args []string) int {
 		}
 	}
 
+	if c.flagRefresh {
+		return c.refresh(logger)
+	}
+
+	serverCfg, err := c.serverConfig(logger)
+	if err != nil {
+		logger.Error("Error creating the server config", "err", err)
+		return 1
+	}
+	federationData[fedSecretServerConfigKey] = serverCfg
+
+	if err := c.writeSecrets(logger, federationData); err != nil {
+		logger.Error("Error creating/updating federation secret", "err", err)
+		return 1
+	}
+	return 0
+}
+
+// readFiles returns the gossip encryption key, if there is one, and the server
+// CA certificate and key read from their files, keyed by their federation
+// secret keys.
+func (c *Command) readFiles(logger hclog.Logger) (map[string][]byte, error) {
+	data := make(map[string][]byte)
+
+	// Add gossip encryption key if it exists.
+	if c.flagGossipKeyFile != "" {
+		logger.Info("Retrieving gossip encryption key data")
+		gossipKey, err := os.ReadFile(c.flagGossipKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading gossip encryption key file: %s", err)
+		}
+		if len(gossipKey) == 0 {
+			return nil, fmt.Errorf("gossip key file %q was empty", c.flagGossipKeyFile)
+		}
+		data[fedSecretGossipKey] = gossipKey
+		logger.Info("Gossip encryption key retrieved successfully")
+	}
+
+	// Add server CA cert.
+	logger.Info("Retrieving server CA cert data")
+	caCert, err := os.ReadFile(c.flagServerCACertFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading server CA cert file: %s", err)
+	}
+	data[fedSecretCACertKey] = caCert
+	logger.Info("Server CA cert retrieved successfully")
+
+	// Add server CA key.
+	logger.Info("Retrieving server CA key data")
+	caKey, err := os.ReadFile(c.flagServerCAKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading server CA key file: %s", err)
+	}
+	data[fedSecretCAKeyKey] = caKey
+	logger.Info("Server CA key retrieved successfully")
+	return data, nil
+}
+
+// serverConfig returns the JSON config for the Consul servers of secondary
+// datacenters with the name of the primary datacenter and the addresses of its
+// mesh gateways.
+func (c *Command) serverConfig(logger hclog.Logger) ([]byte, error) {
 	// Get the datacenter's name. We assume this is the primary datacenter
 	// because users should only be running this in the primary datacenter.
 	logger.Info("Retrieving datacenter name from Consul")
-	datacenter := c.consulDatacenter(logger)
+	datacenter, err := c.consulDatacenter(logger)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the datacenter name: %s", err)
+	}
 	logger.Info("Successfully retrieved datacenter name")
 
 	// Get the mesh gateway addresses.
 	logger.Info("Retrieving mesh gateway addresses from Consul")
 	meshGWAddrs, err := c.meshGatewayAddrs(logger)
 	if err != nil {
-		logger.Error("Error looking up mesh gateways", "err", err)
-		return 1
+		return nil, fmt.Errorf("error looking up mesh gateways: %s", err)
 	}
 	logger.Info("Found mesh gateway addresses", "addrs", strings.Join(meshGWAddrs, ","))
 
 	// Generate a JSON config from the datacenter and mesh gateway addresses
 	// that can be set as a config file by Consul servers in secondary datacenters.
-	serverCfg, err := c.serverCfg(datacenter, meshGWAddrs)
+	return c.serverCfg(datacenter, meshGWAddrs)
+}
+
+// writeSecrets creates or updates the federation secret with the data in this
+// cluster and in every remote cluster. It returns an error if any of the
+// secrets couldn't be written.
+func (c *Command) writeSecrets(logger hclog.Logger, data map[string][]byte) error {
+	var result error
+	if err := c.writeSecret(logger, localCluster, c.k8sClient, c.flagK8sNamespace, data); err != nil {
+		result = multierror.Append(result, err)
+	}
+	for _, secretName := range c.flagRemoteKubeconfigSecrets {
+		client, namespace, err := c.remoteClient(secretName)
+		if err != nil {
+			c.recordUpdate(secretName, "error")
+		} else {
+			err = c.writeSecret(logger, secretName, client, namespace, data)
+		}
+		if err != nil {
+			result = multierror.Append(result, fmt.Errorf("cluster of kubeconfig secret %q: %s", secretName, err))
+		}
+	}
+	return result
+}
+
+// writeSecret creates the federation secret in the namespace of the cluster or
+// updates it if its data differs.
+func (c *Command) writeSecret(logger hclog.Logger, cluster string, client kubernetes.Interface, namespace string, data map[string][]byte) error {
+	name := c.federationSecretName()
+	secret, err := client.CoreV1().Secrets(namespace).Get(c.ctx, name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		logger.Info("Creating Kubernetes secret", "name", name, "ns", namespace, "cluster", cluster)
+		_, err = client.CoreV1().Secrets(namespace).Create(c.ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+				Labels:    map[string]string{common.CLILabelKey: common.CLILabelValue},
+			},
+			Type: "Opaque",
+			Data: data,
+		}, metav1.CreateOptions{})
+	} else if err == nil {
+		if reflect.DeepEqual(secret.Data, data) {
+			logger.Debug("Federation secret is up to date", "name", name, "ns", namespace, "cluster", cluster)
+			return nil
+		}
+		logger.Info("Updating Kubernetes secret", "name", name, "ns", namespace, "cluster", cluster)
+		if secret.Labels == nil {
+			secret.Labels = map[string]string{}
+		}
+		secret.Labels[common.CLILabelKey] = common.CLILabelValue
+		secret.Data = data
+		_, err = client.CoreV1().Secrets(namespace).Update(c.ctx, secret, metav1.UpdateOptions{})
+	}
 	if err != nil {
-		logger.Error("Unable to create server config json", "err", err)
-		return 1
+		c.recordUpdate(cluster, "error")
+		return err
 	}
-	federationSecret.Data[fedSecretServerConfigKey] = serverCfg
+	c.recordUpdate(cluster, "success")
+	logger.Info("Successfully created/updated federation secret", "name", name, "ns", namespace, "cluster", cluster)
+	return nil
+}
 
-	// Now create the Kubernetes secret.
-	logger.Info("Creating/updating Kubernetes secret", "name", federationSecret.ObjectMeta.Name, "ns", c.flagK8sNamespace)
-	_, err = c.k8sClient.CoreV1().Secrets(c.flagK8sNamespace).Create(c.ctx, federationSecret, metav1.CreateOptions{})
-	if k8serrors.IsAlreadyExists(err) {
-		logger.Info("Secret already exists, updating instead")
-		_, err = c.k8sClient.CoreV1().Secrets(c.flagK8sNamespace).Update(c.ctx, federationSecret, metav1.UpdateOptions{})
+// remoteClient returns a client for the remote cluster of the kubeconfig in
+// the secret and the namespace to write the federation secret to. That is the
+// namespace of the current context of the kubeconfig, or else -k8s-namespace.
+func (c *Command) remoteClient(secretName string) (kubernetes.Interface, string, error) {
+	secret, err := c.k8sClient.CoreV1().Secrets(c.flagK8sNamespace).Get(c.ctx, secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("error reading kubeconfig secret: %s", err)
+	}
+	kubeconfig, ok := secret.Data[remoteKubeconfigKey]
+	if !ok {
+		return nil, "", fmt.Errorf("expected key '%s' in kubeconfig secret not set", remoteKubeconfigKey)
 	}
 
+	cfg, err := clientcmd.Load(kubeconfig)
 	if err != nil {
-		logger.Error("Error creating/updating federation secret", "err", err)
-		return 1
+		return nil, "", fmt.Errorf("error parsing kubeconfig: %s", err)
 	}
-	logger.Info("Successfully created/updated federation secret", "name", federationSecret.ObjectMeta.Name, "ns", c.flagK8sNamespace)
-	return 0
+	namespace := c.flagK8sNamespace
+	if kubeContext, ok := cfg.Contexts[cfg.CurrentContext]; ok && kubeContext.Namespace != "" {
+		namespace = kubeContext.Namespace
+	}
+
+	newClient := c.newRemoteClient
+	if newClient == nil {
+		newClient = func(kubeconfig []byte) (kubernetes.Interface, error) {
+			restCfg, err := clientcmd.RESTConfigFromKubeConfig(kubeconfig)
+			if err != nil {
+				return nil, err
+			}
+			return kubernetes.NewForConfig(restCfg)
+		}
+	}
+	client, err := newClient(kubeconfig)
+	if err != nil {
+		return nil, "", fmt.Errorf("error initializing Kubernetes client: %s", err)
+	}
+	return client, namespace, nil
+}
+
+func (c *Command) federationSecretName() string {
+	return fmt.Sprintf("%s-federation", c.flagResourcePrefix)
 }
 
 func (c *Command) validateFlags(args []string) error {
@@ -286,6 +432,9 @@ func (c *Command) validateFlags(args []string) error {
 	if c.http.ConsulAPITimeout() <= 0 {
 		return errors.New("-consul-api-timeout must be set to a value greater than 0")
 	}
+	if c.flagRefresh && c.flagRefreshInterval <= 0 {
+		return errors.New("-refresh-interval must be greater than 0")
+	}
 	return nil
 }
 
@@ -322,7 +471,7 @@ func (c *Command) replicationToken(logger hclog.Logger) ([]byte, error) {
 			return nil
 		}
 		return nil
-	}, backoff.NewConstantBackOff(retryInterval))
+	}, backoff.WithContext(backoff.NewConstantBackOff(retryInterval), c.ctx))
 	// Unable to find the secret before timing out.
 	if err != nil {
 		return nil, err
@@ -341,7 +490,7 @@ func (c *Command) meshGatewayAddrs(logger hclog.Logger) ([]string, error) {
 	var meshGWSvcs []*api.CatalogService
 
 	// Run in a retry in case the mesh gateways haven't yet been registered.
-	_ = backoff.Retry(func() error {
+	err := backoff.Retry(func() error {
 		var err error
 		meshGWSvcs, _, err = c.consulClient.Catalog().Service(c.flagMeshGatewayServiceName, "", nil)
 		if err != nil {
@@ -353,7 +502,10 @@ func (c *Command) meshGatewayAddrs(logger hclog.Logger) ([]string, error) {
 			return errors.New("")
 		}
 		return nil
-	}, backoff.NewConstantBackOff(retryInterval))
+	}, backoff.WithContext(backoff.NewConstantBackOff(retryInterval), c.ctx))
+	if err != nil {
+		return nil, err
+	}
 
 	// Use a map to collect the addresses to ensure uniqueness.
 	meshGatewayAddrs := make(map[string]bool)
@@ -368,6 +520,8 @@ func (c *Command) meshGatewayAddrs(logger hclog.Logger) ([]string, error) {
 	for addr := range meshGatewayAddrs {
 		uniqMeshGatewayAddrs = append(uniqMeshGatewayAddrs, addr)
 	}
+	// Sort the addresses so that the server config only changes when they do.
+	sort.Strings(uniqMeshGatewayAddrs)
 	return uniqMeshGatewayAddrs, nil
 }
 
@@ -384,7 +538,7 @@ func (c *Command) serverCfg(datacenter string, gatewayAddrs []string) ([]byte, e
 }
 
 // consulDatacenter returns the current datacenter.
-func (c *Command) consulDatacenter(logger hclog.Logger) string {
+func (c *Command) consulDatacenter(logger hclog.Logger) (string, error) {
 	// withLog is a helper method we'll use in the retry loop below to ensure
 	// that errors are logged.
 	var withLog = func(fn func() error) func() error {
@@ -399,7 +553,7 @@ func (c *Command) consulDatacenter(logger hclog.Logger) string {
 
 	// Run in a retry because the Consul clients may not be running yet.
 	var dc string
-	_ = backoff.Retry(withLog(func() error {
+	err := backoff.Retry(withLog(func() error {
 		agentCfg, err := c.consulClient.Agent().Self()
 		if err != nil {
 			return err
@@ -419,9 +573,9 @@ func (c *Command) consulDatacenter(logger hclog.Logger) string {
 			return fmt.Errorf("value of Config.Datacenter was empty string: %s", agentCfg)
 		}
 		return nil
-	}), backoff.NewConstantBackOff(retryInterval))
+	}), backoff.WithContext(backoff.NewConstantBackOff(retryInterval), c.ctx))
 
-	return dc
+	return dc, err
 }
 
 // validateCAFileFlag returns an error if the -ca-file flag (or its env var
@@ -453,4 +607,8 @@ Usage: consul-k8s-control-plane create-federation-secret [options]
   datacenter to federate with the primary. This command should only be run in the
   primary datacenter.
 
+  With -refresh, keeps running and rewrites the secret whenever the CA, the gossip
+  encryption key, the replication token or the mesh gateway addresses change. The
+  age of the federation data is exposed as a Prometheus metric on /metrics.
+
 `
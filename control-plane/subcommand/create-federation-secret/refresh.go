@@ -0,0 +1,154 @@
+package createfederationsecret
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/subcommand/common"
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// refreshMetrics are the metrics of the refresh mode.
+type refreshMetrics struct {
+	lastRefresh prometheus.Gauge
+	updates     *prometheus.CounterVec
+
+	mu            sync.Mutex
+	lastRefreshAt time.Time
+}
+
+func newRefreshMetrics(reg prometheus.Registerer) *refreshMetrics {
+	m := &refreshMetrics{
+		lastRefresh: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "consul_federation_secret_last_refresh_timestamp_seconds",
+			Help: "The Unix time the federation data was last read and written to every cluster.",
+		}),
+		updates: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "consul_federation_secret_updates_total",
+			Help: "The number of creations and updates of the federation secret by cluster and result.",
+		}, []string{"cluster", "result"}),
+		lastRefreshAt: time.Now(),
+	}
+	age := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "consul_federation_secret_age_seconds",
+		Help: "The number of seconds since the federation data was last read and written to every cluster.",
+	}, func() float64 {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		return time.Since(m.lastRefreshAt).Seconds()
+	})
+	reg.MustRegister(m.lastRefresh, m.updates, age)
+	return m
+}
+
+// refreshed records that the federation data was read and written to every
+// cluster at the time.
+func (m *refreshMetrics) refreshed(at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastRefreshAt = at
+	m.lastRefresh.Set(float64(at.Unix()))
+}
+
+// recordUpdate counts a write of the federation secret to the cluster when
+// running in the refresh mode.
+func (c *Command) recordUpdate(cluster, result string) {
+	if c.metrics != nil {
+		c.metrics.updates.WithLabelValues(cluster, result).Inc()
+	}
+}
+
+// refresh reads the federation data every refresh interval until the process
+// is interrupted and rewrites the federation secrets when it changed.
+func (c *Command) refresh(logger hclog.Logger) int {
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, syscall.SIGINT, syscall.SIGTERM)
+	}
+
+	reg := prometheus.NewRegistry()
+	c.metrics = newRefreshMetrics(reg)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: c.flagListen, Handler: mux}
+	go func() {
+		logger.Info("Listening", "address", c.flagListen)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error("Error listening", "err", err)
+		}
+	}()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-c.sigCh:
+			logger.Info(fmt.Sprintf("%s received, shutting down", sig))
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// The sources are read every interval, so their progress is only logged
+	// at the debug level.
+	sourceLog := logger
+	if !logger.IsDebug() {
+		sourceLog, _ = common.Logger("warn", c.flagLogJSON)
+	}
+
+	logger.Info("Refreshing the federation secret", "interval", c.flagRefreshInterval)
+	ticker := time.NewTicker(c.flagRefreshInterval)
+	defer ticker.Stop()
+	for {
+		c.refreshOnce(ctx, logger, sourceLog)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
+
+// refreshOnce reads the federation data and writes it to the federation
+// secrets that differ. Errors are logged and retried on the next refresh.
+func (c *Command) refreshOnce(parent context.Context, logger, sourceLog hclog.Logger) {
+	var cancel context.CancelFunc
+	c.ctx, cancel = context.WithTimeout(parent, c.flagRefreshInterval)
+	defer cancel()
+
+	data, err := c.readFiles(sourceLog)
+	if err != nil {
+		logger.Error("Error reading the federation data", "err", err)
+		return
+	}
+	if c.flagExportReplicationToken {
+		token, err := c.replicationToken(sourceLog)
+		if err != nil {
+			logger.Error("Error reading the federation data", "err", err)
+			return
+		}
+		data[fedSecretReplicationTokenKey] = token
+	}
+	serverCfg, err := c.serverConfig(sourceLog)
+	if err != nil {
+		logger.Error("Error reading the federation data", "err", err)
+		return
+	}
+	data[fedSecretServerConfigKey] = serverCfg
+
+	if err := c.writeSecrets(logger, data); err != nil {
+		logger.Error("Error creating/updating federation secret", "err", err)
+		return
+	}
+	c.metrics.refreshed(time.Now())
+}
@@ -0,0 +1,250 @@
+package createfederationsecret
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/subcommand/common"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const remoteKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: remote
+  cluster:
+    server: https://remote.example.com
+contexts:
+- name: remote
+  context:
+    cluster: remote
+    namespace: consul-remote
+current-context: remote
+`
+
+func TestRun_RefreshFlagValidation(t *testing.T) {
+	t.Parallel()
+	f := common.WriteTempFile(t, "ca")
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	exitCode := cmd.Run([]string{
+		"-resource-prefix=prefix",
+		"-k8s-namespace=default",
+		"-server-ca-cert-file=file",
+		"-server-ca-key-file=file",
+		"-ca-file", f,
+		"-mesh-gateway-service-name=name",
+		"-consul-api-timeout=5s",
+		"-refresh",
+		"-refresh-interval=0s",
+	})
+	require.Equal(t, 1, exitCode, ui.ErrorWriter.String())
+	require.Contains(t, ui.ErrorWriter.String(), "-refresh-interval must be greater than 0")
+}
+
+// Test that the refresh mode writes the federation secret and rewrites it when
+// the mesh gateway addresses change until it is interrupted.
+func TestRun_Refresh(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsul(t, "1.1.1.1")
+	k8s := fake.NewSimpleClientset()
+	caFile := common.WriteTempFile(t, "ca-cert")
+
+	sigCh := make(chan os.Signal, 1)
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, k8sClient: k8s, consulClient: consul.client(t), sigCh: sigCh}
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-resource-prefix=prefix",
+			"-k8s-namespace=default",
+			"-server-ca-cert-file", caFile,
+			"-server-ca-key-file", common.WriteTempFile(t, "ca-key"),
+			"-ca-file", caFile,
+			"-mesh-gateway-service-name=mesh-gateway",
+			"-consul-api-timeout=5s",
+			"-refresh",
+			"-refresh-interval=100ms",
+			"-listen=127.0.0.1:0",
+		})
+	}()
+
+	requireServerConfig := func(t *testing.T, expCfg string) {
+		retry.Run(t, func(r *retry.R) {
+			secret, err := k8s.CoreV1().Secrets("default").Get(context.Background(), "prefix-federation", metav1.GetOptions{})
+			require.NoError(r, err)
+			require.Equal(r, expCfg, string(secret.Data[fedSecretServerConfigKey]))
+			require.Equal(r, "ca-cert", string(secret.Data[fedSecretCACertKey]))
+			require.Equal(r, "ca-key", string(secret.Data[fedSecretCAKeyKey]))
+		})
+	}
+	requireServerConfig(t, `{"primary_datacenter":"dc1","primary_gateways":["1.1.1.1:443"]}`)
+
+	consul.setGateways("2.2.2.2", "1.1.1.1")
+	requireServerConfig(t, `{"primary_datacenter":"dc1","primary_gateways":["1.1.1.1:443","2.2.2.2:443"]}`)
+
+	sigCh <- syscall.SIGTERM
+	select {
+	case exitCode := <-exitCh:
+		require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("refresh did not stop")
+	}
+}
+
+func TestRefreshOnce(t *testing.T) {
+	t.Parallel()
+	consul := newFakeConsul(t, "1.1.1.1")
+	k8s := fake.NewSimpleClientset(
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "prefix-" + common.ACLReplicationTokenName + "-acl-token", Namespace: "default"},
+			Data:       map[string][]byte{common.ACLTokenSecretKey: []byte("replication-token")},
+		},
+		&v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "remote-kubeconfig", Namespace: "default"},
+			Data:       map[string][]byte{remoteKubeconfigKey: []byte(remoteKubeconfig)},
+		},
+	)
+	remote := fake.NewSimpleClientset()
+	gossipKeyFile := common.WriteTempFile(t, "gossip-key")
+
+	cmd := Command{
+		UI:           cli.NewMockUi(),
+		k8sClient:    k8s,
+		consulClient: consul.client(t),
+		newRemoteClient: func(kubeconfig []byte) (kubernetes.Interface, error) {
+			require.Equal(t, remoteKubeconfig, string(kubeconfig))
+			return remote, nil
+		},
+	}
+	cmd.init()
+	require.NoError(t, cmd.flags.Parse([]string{
+		"-resource-prefix=prefix",
+		"-k8s-namespace=default",
+		"-server-ca-cert-file", common.WriteTempFile(t, "ca-cert"),
+		"-server-ca-key-file", common.WriteTempFile(t, "ca-key"),
+		"-gossip-key-file", gossipKeyFile,
+		"-export-replication-token",
+		"-mesh-gateway-service-name=mesh-gateway",
+		"-remote-kubeconfig-secret=remote-kubeconfig",
+		"-refresh",
+	}))
+	reg := prometheus.NewRegistry()
+	cmd.metrics = newRefreshMetrics(reg)
+	log := hclog.NewNullLogger()
+
+	cmd.refreshOnce(context.Background(), log, log)
+	expData := map[string][]byte{
+		fedSecretGossipKey:           []byte("gossip-key"),
+		fedSecretCACertKey:           []byte("ca-cert"),
+		fedSecretCAKeyKey:            []byte("ca-key"),
+		fedSecretReplicationTokenKey: []byte("replication-token"),
+		fedSecretServerConfigKey:     []byte(`{"primary_datacenter":"dc1","primary_gateways":["1.1.1.1:443"]}`),
+	}
+	local, err := k8s.CoreV1().Secrets("default").Get(context.Background(), "prefix-federation", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, expData, local.Data)
+	// The federation secret is written to the namespace of the kubeconfig.
+	remoteSecret, err := remote.CoreV1().Secrets("consul-remote").Get(context.Background(), "prefix-federation", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, expData, remoteSecret.Data)
+	require.Equal(t, float64(1), promtestutil.ToFloat64(cmd.metrics.updates.WithLabelValues(localCluster, "success")))
+	require.Equal(t, float64(1), promtestutil.ToFloat64(cmd.metrics.updates.WithLabelValues("remote-kubeconfig", "success")))
+	lastRefresh := promtestutil.ToFloat64(cmd.metrics.lastRefresh)
+	require.NotZero(t, lastRefresh)
+
+	// The secrets aren't updated while the data is unchanged.
+	cmd.refreshOnce(context.Background(), log, log)
+	require.Equal(t, float64(1), promtestutil.ToFloat64(cmd.metrics.updates.WithLabelValues(localCluster, "success")))
+	require.Equal(t, float64(1), promtestutil.ToFloat64(cmd.metrics.updates.WithLabelValues("remote-kubeconfig", "success")))
+
+	// A rotated gossip encryption key is written to every cluster.
+	require.NoError(t, os.WriteFile(gossipKeyFile, []byte("new-gossip-key"), 0600))
+	cmd.refreshOnce(context.Background(), log, log)
+	remoteSecret, err = remote.CoreV1().Secrets("consul-remote").Get(context.Background(), "prefix-federation", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, "new-gossip-key", string(remoteSecret.Data[fedSecretGossipKey]))
+	require.Equal(t, float64(2), promtestutil.ToFloat64(cmd.metrics.updates.WithLabelValues(localCluster, "success")))
+	require.Equal(t, float64(2), promtestutil.ToFloat64(cmd.metrics.updates.WithLabelValues("remote-kubeconfig", "success")))
+
+	// A remote cluster that can't be written to fails the refresh but the
+	// other clusters are still updated.
+	require.NoError(t, k8s.CoreV1().Secrets("default").Delete(context.Background(), "remote-kubeconfig", metav1.DeleteOptions{}))
+	consul.setGateways("2.2.2.2")
+	cmd.metrics.refreshed(time.Unix(1, 0))
+	cmd.refreshOnce(context.Background(), log, log)
+	local, err = k8s.CoreV1().Secrets("default").Get(context.Background(), "prefix-federation", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, `{"primary_datacenter":"dc1","primary_gateways":["2.2.2.2:443"]}`, string(local.Data[fedSecretServerConfigKey]))
+	require.Equal(t, float64(1), promtestutil.ToFloat64(cmd.metrics.updates.WithLabelValues("remote-kubeconfig", "error")))
+	require.Equal(t, float64(1), promtestutil.ToFloat64(cmd.metrics.lastRefresh))
+}
+
+// fakeConsul serves the Consul APIs used to build the server config.
+type fakeConsul struct {
+	mu       sync.Mutex
+	gateways []string
+	addr     string
+}
+
+func newFakeConsul(t *testing.T, gateways ...string) *fakeConsul {
+	c := &fakeConsul{gateways: gateways}
+	srv := httptest.NewServer(http.HandlerFunc(c.serveHTTP))
+	t.Cleanup(srv.Close)
+	c.addr = srv.URL
+	return c
+}
+
+func (c *fakeConsul) setGateways(gateways ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.gateways = gateways
+}
+
+func (c *fakeConsul) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch r.URL.Path {
+	case "/v1/agent/self":
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"Config": map[string]interface{}{"Datacenter": "dc1"},
+		})
+	case "/v1/catalog/service/mesh-gateway":
+		var svcs []*api.CatalogService
+		for _, ip := range c.gateways {
+			svcs = append(svcs, &api.CatalogService{
+				ServiceID: "mesh-gateway-" + ip,
+				ServiceTaggedAddresses: map[string]api.ServiceAddress{
+					"wan": {Address: ip, Port: 443},
+				},
+			})
+		}
+		_ = json.NewEncoder(w).Encode(svcs)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (c *fakeConsul) client(t *testing.T) *api.Client {
+	client, err := api.NewClient(&api.Config{Address: c.addr})
+	require.NoError(t, err)
+	return client
+}
@@ -2,12 +2,11 @@ package gossipencryptionautogenerate
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"flag"
 	"fmt"
 	"sync"
 
+	gossipkey "github.com/hashicorp/consul-k8s/control-plane/helper/gossip-key"
 	"github.com/hashicorp/consul-k8s/control-plane/subcommand"
 	"github.com/hashicorp/consul-k8s/control-plane/subcommand/common"
 	"github.com/hashicorp/consul-k8s/control-plane/subcommand/flags"
@@ -100,7 +99,7 @@ func (c *Command) Run(args []string) int {
 		return 0
 	}
 
-	gossipSecret, err := generateGossipSecret()
+	gossipSecret, err := gossipkey.GenerateKey()
 	if err != nil {
 		c.UI.Error(fmt.Sprintf("Failed to generate gossip secret: %v", err))
 		return 1
@@ -186,24 +185,6 @@ func (c *Command) doesKubernetesSecretExist() (bool, error) {
 	return true, nil
 }
 
-// generateGossipSecret generates a random 32 byte secret returned as a base64 encoded string.
-func generateGossipSecret() (string, error) {
-	// This code was copied from Consul's Keygen command:
-	// https://github.com/hashicorp/consul/blob/d652cc86e3d0322102c2b5e9026c6a60f36c17a5/command/keygen/keygen.go
-
-	key := make([]byte, 32)
-	n, err := rand.Reader.Read(key)
-
-	if err != nil {
-		return "", fmt.Errorf("error reading random data: %s", err)
-	}
-	if n != 32 {
-		return "", fmt.Errorf("couldn't read enough entropy")
-	}
-
-	return base64.StdEncoding.EncodeToString(key), nil
-}
-
 const synopsis = "Generate and store a secret for gossip encryption."
 const help = `
 Usage: consul-k8s-control-plane gossip-encryption-autogenerate [options]
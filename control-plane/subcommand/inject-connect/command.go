@@ -10,6 +10,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
 	connectinject "github.com/hashicorp/consul-k8s/control-plane/connect-inject"
@@ -22,6 +23,7 @@ import (
 	"go.uber.org/zap/zapcore"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	"k8s.io/client-go/kubernetes"
@@ -29,6 +31,7 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	ctrlmetrics "sigs.k8s.io/controller-runtime/pkg/metrics"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
 )
 
@@ -44,6 +47,8 @@ type Command struct {
 	flagEnvoyImage            string // Docker image for Envoy
 	flagConsulK8sImage        string // Docker image for consul-k8s
 	flagACLAuthMethod         string // Auth Method to use for ACLs, if enabled
+	flagACLAuthMethodJWTAud   string // Audience of the projected token for a jwt Auth Method
+	flagACLAuthMethodJWTExp   int64  // Lifetime of the projected token for a jwt Auth Method
 	flagWriteServiceDefaults  bool   // True to enable central config injection
 	flagDefaultProtocol       string // Default protocol for use with central config
 	flagConsulCACert          string // [Deprecated] Path to CA Certificate to use when communicating with Consul clients
@@ -52,6 +57,10 @@ type Command struct {
 	flagLogLevel              string
 	flagLogJSON               bool
 
+	flagEnableOrphanGC      bool          // Delete ACL tokens and services left behind by deleted pods
+	flagOrphanGCInterval    time.Duration // Time between two runs of the orphan garbage collector
+	flagOrphanGCGracePeriod time.Duration // How long a pod must be missing before its tokens and services are deleted
+
 	flagAllowK8sNamespacesList []string // K8s namespaces to explicitly inject
 	flagDenyK8sNamespacesList  []string // K8s namespaces to deny injection (has precedence)
 
@@ -153,6 +162,17 @@ func (c *Command) init() {
 		"Extra envoy command line args to be set when starting envoy (e.g \"--log-level debug --disable-hot-restart\").")
 	c.flagSet.StringVar(&c.flagACLAuthMethod, "acl-auth-method", "",
 		"The name of the Kubernetes Auth Method to use for connectInjection if ACLs are enabled.")
+	c.flagSet.StringVar(&c.flagACLAuthMethodJWTAud, "acl-auth-method-jwt-audience", "",
+		"The audience of the projected service account token to log in with if -acl-auth-method is a jwt auth method.")
+	c.flagSet.Int64Var(&c.flagACLAuthMethodJWTExp, "acl-auth-method-jwt-expiration-seconds", 0,
+		"The requested lifetime in seconds of the projected service account token. Defaults to the Kubernetes default.")
+	c.flagSet.BoolVar(&c.flagEnableOrphanGC, "enable-orphan-gc", false,
+		"Periodically delete the ACL tokens and service instances of pods that no longer exist, for example "+
+			"because the endpoints controller was not running when the pods were deleted.")
+	c.flagSet.DurationVar(&c.flagOrphanGCInterval, "orphan-gc-interval", 5*time.Minute,
+		"Time between two runs of the orphaned ACL token and service garbage collector.")
+	c.flagSet.DurationVar(&c.flagOrphanGCGracePeriod, "orphan-gc-grace-period", 5*time.Minute,
+		"How long the pod of an ACL token or service instance must have been missing before the garbage collector deletes it.")
 	c.flagSet.BoolVar(&c.flagWriteServiceDefaults, "enable-central-config", false,
 		"Write a service-defaults config for every Connect service using protocol from -default-protocol or Pod annotation.")
 	c.flagSet.StringVar(&c.flagDefaultProtocol, "default-protocol", "",
@@ -417,6 +437,21 @@ func (c *Command) Run(args []string) int {
 		DefaultPrometheusScrapePath: c.flagDefaultPrometheusScrapePath,
 	}
 
+	// The UID of the kube-system namespace identifies the cluster in the meta
+	// of the service instances. The garbage collector can't tell the
+	// instances of this cluster on failed nodes apart without it.
+	var clusterID string
+	kubeSystem, err := c.clientset.CoreV1().Namespaces().Get(ctx, metav1.NamespaceSystem, metav1.GetOptions{})
+	if err != nil {
+		if c.flagEnableOrphanGC {
+			setupLog.Error(err, "unable to read the cluster ID for the orphan garbage collector")
+			return 1
+		}
+		setupLog.Error(err, "unable to read the cluster ID, service instances are registered without it")
+	} else {
+		clusterID = string(kubeSystem.UID)
+	}
+
 	if err = (&connectinject.EndpointsController{
 		Client:                     mgr.GetClient(),
 		ConsulClient:               c.consulClient,
@@ -439,6 +474,7 @@ func (c *Command) Run(args []string) int {
 		Scheme:                     mgr.GetScheme(),
 		ReleaseName:                c.flagReleaseName,
 		ReleaseNamespace:           c.flagReleaseNamespace,
+		ClusterID:                  clusterID,
 		Context:                    ctx,
 		ConsulAPITimeout:           c.http.ConsulAPITimeout(),
 	}).SetupWithManager(mgr); err != nil {
@@ -446,6 +482,32 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
+	if c.flagEnableOrphanGC {
+		if err = mgr.Add(&connectinject.GarbageCollector{
+			Client:                 mgr.GetClient(),
+			ConsulClient:           c.consulClient,
+			ConsulClientCfg:        cfg,
+			ConsulScheme:           consulURL.Scheme,
+			ConsulPort:             consulURL.Port(),
+			ConsulAPITimeout:       c.http.ConsulAPITimeout(),
+			EnableConsulNamespaces: c.flagEnableNamespaces,
+			ConsulPartition:        c.http.Partition(),
+			ClusterID:              clusterID,
+			AllowK8sNamespacesSet:  allowK8sNamespaces,
+			DenyK8sNamespacesSet:   denyK8sNamespaces,
+			ReleaseName:            c.flagReleaseName,
+			ReleaseNamespace:       c.flagReleaseNamespace,
+			AuthMethod:             c.flagACLAuthMethod,
+			Interval:               c.flagOrphanGCInterval,
+			GracePeriod:            c.flagOrphanGCGracePeriod,
+			Registerer:             ctrlmetrics.Registry,
+			Log:                    ctrl.Log.WithName("orphan-gc"),
+		}); err != nil {
+			setupLog.Error(err, "unable to add orphan garbage collector")
+			return 1
+		}
+	}
+
 	if err = mgr.AddReadyzCheck("ready", connectinject.ReadinessCheck{CertDir: c.flagCertDir}.Ready); err != nil {
 		setupLog.Error(err, "unable to create readiness check", "controller", connectinject.EndpointsController{})
 		return 1
@@ -503,6 +565,8 @@ func (c *Command) Run(args []string) int {
 			ImageConsulK8S:                c.flagConsulK8sImage,
 			RequireAnnotation:             !c.flagDefaultInject,
 			AuthMethod:                    c.flagACLAuthMethod,
+			AuthMethodJWTAudience:         c.flagACLAuthMethodJWTAud,
+			AuthMethodJWTExpirySeconds:    c.flagACLAuthMethodJWTExp,
 			ConsulCACert:                  string(consulCACert),
 			DefaultProxyCPURequest:        sidecarProxyCPURequest,
 			DefaultProxyCPULimit:          sidecarProxyCPULimit,
@@ -594,6 +658,19 @@ func (c *Command) validateFlags() error {
 	if c.http.ConsulAPITimeout() <= 0 {
 		return errors.New("-consul-api-timeout must be set to a value greater than 0")
 	}
+
+	if c.flagACLAuthMethodJWTAud != "" && c.flagACLAuthMethod == "" {
+		return errors.New("-acl-auth-method must be set if -acl-auth-method-jwt-audience is set")
+	}
+	if c.flagEnableOrphanGC && c.flagOrphanGCInterval <= 0 {
+		return errors.New("-orphan-gc-interval must be greater than 0 if -enable-orphan-gc is true")
+	}
+	if c.flagOrphanGCGracePeriod < 0 {
+		return errors.New("-orphan-gc-grace-period must not be negative")
+	}
+	if c.flagACLAuthMethodJWTExp != 0 && c.flagACLAuthMethodJWTExp < 600 {
+		return errors.New("-acl-auth-method-jwt-expiration-seconds must be at least 600 if set")
+	}
 	return nil
 }
 func (c *Command) parseAndValidateResourceFlags() (corev1.ResourceRequirements, corev1.ResourceRequirements, error) {
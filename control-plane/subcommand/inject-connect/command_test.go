@@ -183,6 +183,31 @@ func TestRun_FlagValidation(t *testing.T) {
 			},
 			expErr: "-default-envoy-proxy-concurrency must be >= 0 if set",
 		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-consul-api-timeout", "5s", "-acl-auth-method-jwt-audience=consul",
+			},
+			expErr: "-acl-auth-method must be set if -acl-auth-method-jwt-audience is set",
+		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-consul-api-timeout", "5s", "-enable-orphan-gc", "-orphan-gc-interval=0s",
+			},
+			expErr: "-orphan-gc-interval must be greater than 0 if -enable-orphan-gc is true",
+		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-consul-api-timeout", "5s", "-orphan-gc-grace-period=-1m",
+			},
+			expErr: "-orphan-gc-grace-period must not be negative",
+		},
+		{
+			flags: []string{"-consul-k8s-image", "foo", "-consul-image", "foo", "-envoy-image", "envoy:1.16.0",
+				"-consul-api-timeout", "5s", "-acl-auth-method=auth", "-acl-auth-method-jwt-audience=consul",
+				"-acl-auth-method-jwt-expiration-seconds=60",
+			},
+			expErr: "-acl-auth-method-jwt-expiration-seconds must be at least 600 if set",
+		},
 	}
 
 	for _, c := range cases {
@@ -0,0 +1,104 @@
+package rotatebootstraptoken
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	ns             = "default"
+	resourcePrefix = "release-name-consul"
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{},
+			expErr: "-server-address must be set at least once",
+		},
+		{
+			flags:  []string{"-server-address=localhost"},
+			expErr: "-resource-prefix or -bootstrap-token-secret-name must be set",
+		},
+		{
+			flags:  []string{"-server-address=localhost", "-resource-prefix=prefix"},
+			expErr: "-k8s-namespace must be set",
+		},
+		{
+			flags:  []string{"-server-address=localhost", "-bootstrap-token-secret-name=token", "-k8s-namespace=default", "-bootstrap-token-secret-key="},
+			expErr: "-bootstrap-token-secret-key must not be empty",
+		},
+		{
+			flags:  []string{"-server-address=localhost", "-resource-prefix=prefix", "-k8s-namespace=default"},
+			expErr: "-consul-api-timeout must be set to a value greater than 0",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			responseCode := cmd.Run(c.flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	bootToken := "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+	svr, err := testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
+		c.ACL.Enabled = true
+		c.ACL.Tokens.InitialManagement = bootToken
+	})
+	require.NoError(t, err)
+	defer svr.Stop()
+	svr.WaitForLeader(t)
+
+	k8s := fake.NewSimpleClientset(&apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: resourcePrefix + "-bootstrap-acl-token"},
+		Data:       map[string][]byte{"token": []byte(bootToken)},
+	})
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, clientset: k8s, retryInterval: 100 * time.Millisecond}
+	responseCode := cmd.Run([]string{
+		"-resource-prefix=" + resourcePrefix,
+		"-k8s-namespace=" + ns,
+		"-server-address", strings.Split(svr.HTTPAddr, ":")[0],
+		"-server-port", strings.Split(svr.HTTPAddr, ":")[1],
+		"-consul-api-timeout=5s",
+	})
+	require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+
+	secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), resourcePrefix+"-bootstrap-acl-token", metav1.GetOptions{})
+	require.NoError(t, err)
+	newToken := string(secret.Data["token"])
+	require.NotEqual(t, bootToken, newToken)
+
+	consul, err := api.NewClient(&api.Config{Address: svr.HTTPAddr, Token: newToken})
+	require.NoError(t, err)
+	_, _, err = consul.ACL().TokenReadSelf(nil)
+	require.NoError(t, err)
+
+	consul, err = api.NewClient(&api.Config{Address: svr.HTTPAddr, Token: bootToken})
+	require.NoError(t, err)
+	_, _, err = consul.ACL().TokenReadSelf(nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "ACL not found")
+}
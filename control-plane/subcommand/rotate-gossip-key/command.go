@@ -0,0 +1,215 @@
+package rotategossipkey
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/consul"
+	gossipkey "github.com/hashicorp/consul-k8s/control-plane/helper/gossip-key"
+	"github.com/hashicorp/consul-k8s/control-plane/subcommand"
+	"github.com/hashicorp/consul-k8s/control-plane/subcommand/common"
+	"github.com/hashicorp/consul-k8s/control-plane/subcommand/flags"
+	"github.com/hashicorp/go-discover"
+	"github.com/mitchellh/cli"
+	"k8s.io/client-go/kubernetes"
+)
+
+type Command struct {
+	UI cli.Ui
+
+	flags *flag.FlagSet
+	k8s   *flags.K8SFlags
+
+	flagK8sNamespace string
+	flagSecretName   string
+	flagSecretKey    string
+	flagACLTokenFile string
+
+	// Flags to configure Consul connection
+	flagServerAddresses     []string
+	flagServerPort          uint
+	flagConsulCACert        string
+	flagConsulTLSServerName string
+	flagUseHTTPS            bool
+	flagConsulAPITimeout    time.Duration
+
+	flagLogLevel string
+	flagLogJSON  bool
+	flagTimeout  time.Duration
+
+	clientset kubernetes.Interface
+
+	// retryInterval is how often the keyring is listed while waiting for
+	// every member to have the new key. It is exposed for setting in tests.
+	retryInterval time.Duration
+
+	once sync.Once
+	help string
+
+	providers map[string]discover.Provider
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+	c.flags.StringVar(&c.flagK8sNamespace, "k8s-namespace", "",
+		"Name of Kubernetes namespace where the gossip encryption Secret is stored.")
+	c.flags.StringVar(&c.flagSecretName, "secret-name", "",
+		"Name of the Secret that stores the gossip encryption key.")
+	c.flags.StringVar(&c.flagSecretKey, "secret-key", "key",
+		"Key of the gossip encryption key in the Secret.")
+	c.flags.StringVar(&c.flagACLTokenFile, "acl-token-file", "",
+		"Path to a file containing an ACL token with the operator:write permission. Required when ACLs are enabled.")
+
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagServerAddresses), "server-address",
+		"The IP, DNS name or the cloud auto-join string of the Consul server(s). If providing IPs or DNS names, may be specified multiple times. "+
+			"At least one value is required. The keyring operations are sent to the first server, which forwards them to every member.")
+	c.flags.UintVar(&c.flagServerPort, "server-port", 8500, "The HTTP or HTTPS port of the Consul server. Defaults to 8500.")
+	c.flags.StringVar(&c.flagConsulCACert, "consul-ca-cert", "",
+		"Path to the PEM-encoded CA certificate of the Consul cluster.")
+	c.flags.StringVar(&c.flagConsulTLSServerName, "consul-tls-server-name", "",
+		"The server name to set as the SNI header when sending HTTPS requests to Consul.")
+	c.flags.BoolVar(&c.flagUseHTTPS, "use-https", false,
+		"Toggle for using HTTPS for all API calls to Consul.")
+	c.flags.DurationVar(&c.flagConsulAPITimeout, "consul-api-timeout", 0,
+		"The time in seconds that the consul API client will wait for a response from the API before cancelling the request.")
+
+	c.flags.DurationVar(&c.flagTimeout, "timeout", 10*time.Minute,
+		"How long we'll try to rotate the gossip encryption key for before timing out, e.g. 1ms, 2s, 3m")
+	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
+		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
+			"\"debug\", \"info\", \"warn\", and \"error\".")
+	c.flags.BoolVar(&c.flagLogJSON, "log-json", false,
+		"Enable or disable JSON output format for logging.")
+
+	c.k8s = &flags.K8SFlags{}
+	flags.Merge(c.flags, c.k8s.Flags())
+	c.help = flags.Usage(help, c.flags)
+
+	// Default retry to 1s. This is exposed for setting in tests.
+	if c.retryInterval == 0 {
+		c.retryInterval = 1 * time.Second
+	}
+}
+
+func (c *Command) Synopsis() string { return synopsis }
+
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+// Run rotates the gossip encryption key.
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if err := c.validateFlags(); err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	log, err := common.Logger(c.flagLogLevel, c.flagLogJSON)
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+
+	var token string
+	if c.flagACLTokenFile != "" {
+		raw, err := os.ReadFile(c.flagACLTokenFile)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Unable to read ACL token from file %q: %s", c.flagACLTokenFile, err))
+			return 1
+		}
+		token = strings.TrimSpace(string(raw))
+	}
+
+	// The ClientSet might already be set if we're in a test.
+	if c.clientset == nil {
+		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
+			return 1
+		}
+		c.clientset, err = kubernetes.NewForConfig(config)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+			return 1
+		}
+	}
+
+	serverAddresses, err := common.GetResolvedServerAddresses(c.flagServerAddresses, c.providers, log)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Unable to discover any Consul addresses from %q: %s", c.flagServerAddresses[0], err))
+		return 1
+	}
+
+	rotator := &gossipkey.Rotator{
+		Clientset:  c.clientset,
+		Namespace:  c.flagK8sNamespace,
+		SecretName: c.flagSecretName,
+		SecretKey:  c.flagSecretKey,
+		Server: &consul.ServerConfig{
+			Address:       fmt.Sprintf("%s:%d", serverAddresses[0], c.flagServerPort),
+			UseHTTPS:      c.flagUseHTTPS,
+			CAFile:        c.flagConsulCACert,
+			TLSServerName: c.flagConsulTLSServerName,
+			Token:         token,
+		},
+		ConsulAPITimeout: c.flagConsulAPITimeout,
+		RetryInterval:    c.retryInterval,
+		Log:              log,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.flagTimeout)
+	defer cancel()
+	if err := rotator.Rotate(ctx); err != nil {
+		c.UI.Error(fmt.Sprintf("Error rotating gossip encryption key: %s", err))
+		return 1
+	}
+	return 0
+}
+
+func (c *Command) validateFlags() error {
+	if len(c.flagServerAddresses) == 0 {
+		return errors.New("-server-address must be set at least once")
+	}
+	if c.flagSecretName == "" {
+		return errors.New("-secret-name must be set")
+	}
+	if c.flagK8sNamespace == "" {
+		return errors.New("-k8s-namespace must be set")
+	}
+	if c.flagSecretKey == "" {
+		return errors.New("-secret-key must not be empty")
+	}
+	if c.flagConsulAPITimeout <= 0 {
+		return errors.New("-consul-api-timeout must be set to a value greater than 0")
+	}
+	return nil
+}
+
+const synopsis = "Rotate the gossip encryption key."
+const help = `
+Usage: consul-k8s-control-plane rotate-gossip-key [options]
+
+  Replaces the gossip encryption key stored in a Kubernetes Secret with a new
+  key. The new key is installed in the keyring and made the primary key once
+  every member has it, then stored in the Secret. The old key is removed from
+  the keyring once every member uses the new key.
+
+  The progress of the rotation is stored in the Secret. If the rotation is
+  interrupted, running the command again resumes it.
+
+`
@@ -0,0 +1,167 @@
+package rotategossipkey
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	apiv1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const (
+	ns         = "default"
+	secretName = "release-name-consul-gossip-encryption-key"
+	gossipKey  = "H6MLnzGpvjPhkgVyqsqXDlwzN+zGhvvBDYpAvdDjuHk="
+)
+
+func TestRun_FlagValidation(t *testing.T) {
+	t.Parallel()
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{},
+			expErr: "-server-address must be set at least once",
+		},
+		{
+			flags:  []string{"-server-address=localhost"},
+			expErr: "-secret-name must be set",
+		},
+		{
+			flags:  []string{"-server-address=localhost", "-secret-name=gossip"},
+			expErr: "-k8s-namespace must be set",
+		},
+		{
+			flags:  []string{"-server-address=localhost", "-secret-name=gossip", "-k8s-namespace=default", "-secret-key="},
+			expErr: "-secret-key must not be empty",
+		},
+		{
+			flags:  []string{"-server-address=localhost", "-secret-name=gossip", "-k8s-namespace=default"},
+			expErr: "-consul-api-timeout must be set to a value greater than 0",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(t *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			responseCode := cmd.Run(c.flags)
+			require.Equal(t, 1, responseCode, ui.ErrorWriter.String())
+			require.Contains(t, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+	svr, err := testutil.NewTestServerConfigT(t, func(c *testutil.TestServerConfig) {
+		c.Encrypt = gossipKey
+	})
+	require.NoError(t, err)
+	defer svr.Stop()
+	svr.WaitForLeader(t)
+
+	k8s := fake.NewSimpleClientset(gossipSecret())
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, clientset: k8s, retryInterval: 100 * time.Millisecond}
+	responseCode := cmd.Run([]string{
+		"-secret-name=" + secretName,
+		"-k8s-namespace=" + ns,
+		"-server-address", strings.Split(svr.HTTPAddr, ":")[0],
+		"-server-port", strings.Split(svr.HTTPAddr, ":")[1],
+		"-consul-api-timeout=5s",
+	})
+	require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+
+	secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), secretName, metav1.GetOptions{})
+	require.NoError(t, err)
+	newKey := string(secret.Data["key"])
+	require.NotEqual(t, gossipKey, newKey)
+
+	consul, err := api.NewClient(&api.Config{Address: svr.HTTPAddr})
+	require.NoError(t, err)
+	rings, err := consul.Operator().KeyringList(nil)
+	require.NoError(t, err)
+	for _, ring := range rings {
+		require.Equal(t, map[string]int{newKey: ring.NumNodes}, ring.Keys)
+	}
+}
+
+// Test that the keyring requests use the token of -acl-token-file.
+func TestRun_ACLTokenFile(t *testing.T) {
+	t.Parallel()
+	token := "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(tokenFile, []byte(token+"\n"), 0600))
+
+	// A keyring of a single member that only accepts the token.
+	var mu sync.Mutex
+	keys := map[string]int{gossipKey: 1}
+	primary := gossipKey
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("X-Consul-Token") != token {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if r.Method == http.MethodGet {
+			_ = json.NewEncoder(w).Encode([]*api.KeyringResponse{{Datacenter: "dc1", Keys: keys, PrimaryKeys: map[string]int{primary: 1}, NumNodes: 1}})
+			return
+		}
+		var req struct{ Key string }
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		switch r.Method {
+		case http.MethodPost:
+			keys[req.Key] = 1
+		case http.MethodPut:
+			primary = req.Key
+		case http.MethodDelete:
+			delete(keys, req.Key)
+		}
+	}))
+	defer srv.Close()
+	serverURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	k8s := fake.NewSimpleClientset(gossipSecret())
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, clientset: k8s, retryInterval: 10 * time.Millisecond}
+	responseCode := cmd.Run([]string{
+		"-secret-name=" + secretName,
+		"-k8s-namespace=" + ns,
+		"-server-address", serverURL.Hostname(),
+		"-server-port", serverURL.Port(),
+		"-consul-api-timeout=5s",
+		"-acl-token-file=" + tokenFile,
+	})
+	require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+
+	secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), secretName, metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{string(secret.Data["key"]): 1}, keys)
+	require.Equal(t, string(secret.Data["key"]), primary)
+}
+
+func gossipSecret() *apiv1.Secret {
+	return &apiv1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: secretName, Namespace: ns},
+		Data:       map[string][]byte{"key": []byte(gossipKey)},
+	}
+}
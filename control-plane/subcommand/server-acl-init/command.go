@@ -26,6 +26,7 @@ import (
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 )
 
 type Command struct {
@@ -46,9 +47,12 @@ type Command struct {
 	flagSyncCatalog        bool
 	flagSyncConsulNodeName string
 
-	flagConnectInject       bool
-	flagAuthMethodHost      string
-	flagBindingRuleSelector string
+	flagConnectInject         bool
+	flagAuthMethodHost        string
+	flagBindingRuleSelector   string
+	flagAuthMethodType        string
+	flagAuthMethodJWTAudience string
+	flagAuthMethodJWTIssuer   string
 
 	flagController bool
 
@@ -98,6 +102,19 @@ type Command struct {
 	flagLogJSON  bool
 	flagTimeout  time.Duration
 
+	// Flags to support the reconcile mode.
+	flagReconcile         bool          // Keep reconciling the ACLs after the initial configuration
+	flagReconcileInterval time.Duration // Interval between reconcile passes
+	flagListen            string        // Address to serve the metrics on
+	flagPodName           string        // Name of the pod to record Events on
+
+	// Flags to support the dry run mode.
+	flagDryRun                  bool   // Print the ACL changes instead of writing them
+	flagDryRunFormat            string // Format of the printed changes
+	flagDryRunOffline           bool   // Plan without connecting to Consul or Kubernetes
+	flagDryRunDatacenter        string // Datacenter to plan for when offline
+	flagDryRunPrimaryDatacenter string // Primary datacenter to plan for when offline
+
 	// flagFederation is used to determine which ACL policies to write and whether or not to provide suffixing
 	// to the policy names when creating the policy in cases where federation is used.
 	// flagFederation indicates if federation has been enabled in the cluster.
@@ -105,6 +122,10 @@ type Command struct {
 
 	clientset kubernetes.Interface
 
+	// oidcDiscovery reads a service account issuer discovery endpoint of
+	// Kubernetes. It is set in tests since the fake clientset can't.
+	oidcDiscovery func(path string) ([]byte, error)
+
 	// ctx is cancelled when the command timeout is reached.
 	ctx           context.Context
 	retryDuration time.Duration
@@ -112,6 +133,21 @@ type Command struct {
 	// log
 	log hclog.Logger
 
+	// sigCh receives the signals that stop the reconcile mode.
+	sigCh chan os.Signal
+
+	// reconciling is true during the reconcile passes after the initial
+	// configuration. repairs holds the ACL objects repaired by the current
+	// pass.
+	reconciling bool
+	repairs     []string
+	metrics     *reconcileMetrics
+	events      record.EventRecorder
+
+	// plan records the ACL objects instead of writing them when -dry-run
+	// is set.
+	plan *aclPlan
+
 	once sync.Once
 	help string
 
@@ -145,6 +181,16 @@ func (c *Command) init() {
 			"If not provided, the default cluster Kubernetes service will be used.")
 	c.flags.StringVar(&c.flagBindingRuleSelector, "acl-binding-rule-selector", "",
 		"Selector string for connectInject ACL Binding Rule.")
+	c.flags.StringVar(&c.flagAuthMethodType, "auth-method-type", authMethodTypeKubernetes,
+		"Type of the auth methods, either \"kubernetes\" or \"jwt\". Kubernetes auth methods make Consul servers "+
+			"call the TokenReview API of Kubernetes. JWT auth methods validate projected service account tokens "+
+			"against the keys of the service account issuer, which are read from Kubernetes and written to Consul.")
+	c.flags.StringVar(&c.flagAuthMethodJWTAudience, "auth-method-jwt-audience", "",
+		"Audience that projected service account tokens must have to log in with the jwt auth methods. "+
+			"Required if -auth-method-type is jwt.")
+	c.flags.StringVar(&c.flagAuthMethodJWTIssuer, "auth-method-jwt-issuer", "",
+		"Issuer that projected service account tokens must have to log in with the jwt auth methods. "+
+			"Defaults to the issuer of the service account issuer discovery document of Kubernetes.")
 
 	c.flags.BoolVar(&c.flagController, "controller", false,
 		"Toggle for configuring ACL login for the controller.")
@@ -219,6 +265,29 @@ func (c *Command) init() {
 
 	c.flags.DurationVar(&c.flagTimeout, "timeout", 10*time.Minute,
 		"How long we'll try to bootstrap ACLs for before timing out, e.g. 1ms, 2s, 3m")
+	c.flags.BoolVar(&c.flagReconcile, "reconcile", false,
+		"If true, keeps running after the initial configuration and periodically reconciles the ACL "+
+			"policies, roles, binding rules, auth methods and tokens with the configuration derived from "+
+			"the flags, repairing any drift. The bootstrap token is never recreated.")
+	c.flags.DurationVar(&c.flagReconcileInterval, "reconcile-interval", 5*time.Minute,
+		"The interval between reconcile passes when -reconcile is set. Each pass times out after the interval.")
+	c.flags.StringVar(&c.flagListen, "listen", ":8080",
+		"Address to bind the listener for the metrics to when -reconcile is set.")
+	c.flags.StringVar(&c.flagPodName, "pod-name", "",
+		"Name of the pod to record Kubernetes Events on when -reconcile is set. Events are not recorded if empty.")
+	c.flags.BoolVar(&c.flagDryRun, "dry-run", false,
+		"If true, prints the ACL policies, roles, binding rules, auth methods and tokens that would be "+
+			"added, changed or removed instead of writing them. Consul is only read, ACLs must already be "+
+			"bootstrapped and the server tokens are left out.")
+	c.flags.StringVar(&c.flagDryRunFormat, "dry-run-format", planFormatText,
+		"Format of the changes printed when -dry-run is set. Supported values are \"text\" and \"json\".")
+	c.flags.BoolVar(&c.flagDryRunOffline, "dry-run-offline", false,
+		"If true with -dry-run, the ACL objects are derived from the flags only, without connecting to "+
+			"Consul or Kubernetes, and are all printed as added. The credentials of the auth methods are left out.")
+	c.flags.StringVar(&c.flagDryRunDatacenter, "dry-run-datacenter", "dc1",
+		"The datacenter to plan for when -dry-run-offline is set.")
+	c.flags.StringVar(&c.flagDryRunPrimaryDatacenter, "dry-run-primary-datacenter", "",
+		"The primary datacenter to plan for when -dry-run-offline is set. Defaults to -dry-run-datacenter.")
 	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\".")
@@ -306,6 +375,17 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
+	if c.flagDryRunOffline {
+		// None of the calls to the client are made when planning offline.
+		clientConfig := api.DefaultConfig()
+		consulClient, err := consul.NewClient(clientConfig, c.flagConsulAPITimeout)
+		if err != nil {
+			c.log.Error(fmt.Sprintf("Error creating Consul client: %s", err))
+			return 1
+		}
+		return c.dryRun(consulClient, clientConfig, aclReplicationToken, partitionToken)
+	}
+
 	// The ClientSet might already be set if we're in a test.
 	if c.clientset == nil {
 		if err := c.configureKubeClient(); err != nil {
@@ -348,10 +428,19 @@ func (c *Command) Run(args []string) int {
 			}
 		}
 
-		bootstrapToken, err = c.bootstrapServers(serverAddresses, bootstrapToken, bootTokenSecretName, scheme)
-		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+		if c.flagDryRun {
+			// The dry run mode only reads from Consul so it can't bootstrap
+			// the servers.
+			if bootstrapToken == "" {
+				c.log.Error(fmt.Sprintf("ACLs are not bootstrapped: -dry-run requires the bootstrap token from Secret %q or -bootstrap-token-file", bootTokenSecretName))
+				return 1
+			}
+		} else {
+			bootstrapToken, err = c.bootstrapServers(serverAddresses, bootstrapToken, bootTokenSecretName, scheme)
+			if err != nil {
+				c.log.Error(err.Error())
+				return 1
+			}
 		}
 	}
 
@@ -374,13 +463,35 @@ func (c *Command) Run(args []string) int {
 		c.log.Error(fmt.Sprintf("Error creating Consul client for addr %q: %s", serverAddr, err))
 		return 1
 	}
+	if c.flagDryRun {
+		return c.dryRun(consulClient, clientConfig, aclReplicationToken, partitionToken)
+	}
+	if err := c.configureACLs(consulClient, clientConfig, aclReplicationToken, partitionToken); err != nil {
+		c.log.Error(err.Error())
+		return 1
+	}
+	c.log.Info("server-acl-init completed successfully")
+
+	if c.flagReconcile {
+		return c.reconcile(consulClient, clientConfig, aclReplicationToken, partitionToken)
+	}
+	return 0
+}
+
+// configureACLs creates or updates the ACL policies, roles, binding rules,
+// auth methods and tokens of the components once the servers are
+// bootstrapped. consulClient must use a token that can manage ACLs.
+func (c *Command) configureACLs(consulClient *api.Client, clientConfig *api.Config, aclReplicationToken, partitionToken string) error {
 	consulDC, primaryDC, err := c.consulDatacenterList(consulClient)
 	if err != nil {
-		c.log.Error("Error getting datacenter name", "err", err)
-		return 1
+		return fmt.Errorf("error getting datacenter name: %s", err)
 	}
 	c.log.Info("Current datacenter", "datacenter", consulDC, "primaryDC", primaryDC)
 	primary := consulDC == primaryDC
+	if c.plan != nil {
+		c.plan.datacenter = consulDC
+		c.plan.primary = primary
+	}
 
 	if c.flagEnablePartitions && c.flagPartitionName == consulDefaultPartition && primary {
 		// Partition token is local because only the Primary datacenter can have Admin Partitions.
@@ -390,8 +501,7 @@ func (c *Command) Run(args []string) int {
 			err = c.createLocalACL("partitions", partitionRules, consulDC, primary, consulClient)
 		}
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
@@ -404,8 +514,7 @@ func (c *Command) Run(args []string) int {
 	if c.flagEnableNamespaces {
 		crossNamespaceRule, err := c.crossNamespaceRules()
 		if err != nil {
-			c.log.Error("Error templating cross namespace rules", "err", err)
-			return 1
+			return fmt.Errorf("error templating cross namespace rules: %s", err)
 		}
 		policyTmpl := api.ACLPolicy{
 			Name:        "cross-namespace-policy",
@@ -417,8 +526,7 @@ func (c *Command) Run(args []string) int {
 				return c.createOrUpdateACLPolicy(policyTmpl, consulClient)
 			})
 		if err != nil {
-			c.log.Error("Error creating or updating the cross namespace policy", "err", err)
-			return 1
+			return fmt.Errorf("error creating or updating the cross namespace policy: %s", err)
 		}
 
 		// Apply this to the PolicyDefaults for the Consul `default` namespace
@@ -431,16 +539,15 @@ func (c *Command) Run(args []string) int {
 			Name: consulDefaultNamespace,
 			ACLs: &aclConfig,
 		}
-		_, _, err = consulClient.Namespaces().Update(&consulNamespace, &api.WriteOptions{})
-		if err != nil {
+		if c.plan != nil {
+			c.plan.namespaces = append(c.plan.namespaces, plannedNamespace{namespace: consulNamespace})
+		} else if _, _, err = consulClient.Namespaces().Update(&consulNamespace, &api.WriteOptions{}); err != nil {
 			if strings.Contains(strings.ToLower(err.Error()), "unexpected response code: 404") {
 				// If this returns a 404 it's most likely because they're not running
 				// Consul Enterprise.
-				c.log.Error("Error updating the default namespace to include the cross namespace policy - ensure you're running Consul Enterprise with namespaces enabled", "err", err)
-			} else {
-				c.log.Error("Error updating the default namespace to include the cross namespace policy", "err", err)
+				return fmt.Errorf("error updating the default namespace to include the cross namespace policy - ensure you're running Consul Enterprise with namespaces enabled: %s", err)
 			}
-			return 1
+			return fmt.Errorf("error updating the default namespace to include the cross namespace policy: %s", err)
 		}
 	}
 
@@ -449,31 +556,27 @@ func (c *Command) Run(args []string) int {
 	localComponentAuthMethodName := c.withPrefix("k8s-component-auth-method")
 	err = c.configureLocalComponentAuthMethod(consulClient, localComponentAuthMethodName)
 	if err != nil {
-		c.log.Error(err.Error())
-		return 1
+		return err
 	}
 
 	globalComponentAuthMethodName := fmt.Sprintf("%s-%s", localComponentAuthMethodName, consulDC)
-	if !primary && c.flagAuthMethodHost != "" {
+	if !primary && (c.flagAuthMethodHost != "" || c.flagAuthMethodType == authMethodTypeJWT) {
 		err = c.configureGlobalComponentAuthMethod(consulClient, globalComponentAuthMethodName, primaryDC)
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
 	if c.flagClient {
 		agentRules, err := c.agentRules()
 		if err != nil {
-			c.log.Error("Error templating client agent rules", "err", err)
-			return 1
+			return fmt.Errorf("error templating client agent rules: %s", err)
 		}
 
 		serviceAccountName := c.withPrefix("client")
 		err = c.createACLPolicyRoleAndBindingRule("client", agentRules, consulDC, primaryDC, false, primary, localComponentAuthMethodName, serviceAccountName, consulClient)
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
@@ -488,22 +591,19 @@ func (c *Command) Run(args []string) int {
 		}
 		anonTokenClient, err := consul.NewClient(anonTokenConfig, c.flagConsulAPITimeout)
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 
 		err = c.configureAnonymousPolicy(anonTokenClient)
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
 	if c.flagSyncCatalog {
 		syncRules, err := c.syncRules()
 		if err != nil {
-			c.log.Error("Error templating sync rules", "err", err)
-			return 1
+			return fmt.Errorf("error templating sync rules: %s", err)
 		}
 
 		serviceAccountName := c.withPrefix("sync-catalog")
@@ -522,8 +622,7 @@ func (c *Command) Run(args []string) int {
 			err = c.createACLPolicyRoleAndBindingRule("sync-catalog", syncRules, consulDC, primaryDC, localPolicy, primary, componentAuthMethodName, serviceAccountName, consulClient)
 		}
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
@@ -531,15 +630,13 @@ func (c *Command) Run(args []string) int {
 		connectAuthMethodName := c.withPrefix("k8s-auth-method")
 		err := c.configureConnectInjectAuthMethod(consulClient, connectAuthMethodName)
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 
 		// The endpoints controller needs an ACL token always.
 		injectRules, err := c.injectRules()
 		if err != nil {
-			c.log.Error("Error templating inject rules", "err", err)
-			return 1
+			return fmt.Errorf("error templating inject rules: %s", err)
 		}
 
 		serviceAccountName := c.withPrefix("connect-injector")
@@ -559,8 +656,7 @@ func (c *Command) Run(args []string) int {
 			err = c.createACLPolicyRoleAndBindingRule("connect-inject", injectRules, consulDC, primaryDC, localPolicy, primary, componentAuthMethodName, serviceAccountName, consulClient)
 		}
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
@@ -572,37 +668,32 @@ func (c *Command) Run(args []string) int {
 			err = c.createLocalACL("enterprise-license", entLicenseRules, consulDC, primary, consulClient)
 		}
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
 	if c.flagSnapshotAgent {
 		serviceAccountName := c.withPrefix("snapshot-agent")
 		if err := c.createACLPolicyRoleAndBindingRule("snapshot-agent", snapshotAgentRules, consulDC, primaryDC, localPolicy, primary, localComponentAuthMethodName, serviceAccountName, consulClient); err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
 	if c.flagAPIGatewayController {
 		rules, err := c.apiGatewayControllerRules()
 		if err != nil {
-			c.log.Error("Error templating api gateway rules", "err", err)
-			return 1
+			return fmt.Errorf("error templating api gateway rules: %s", err)
 		}
 		serviceAccountName := c.withPrefix("api-gateway-controller")
 		if err := c.createACLPolicyRoleAndBindingRule("api-gateway-controller", rules, consulDC, primaryDC, localPolicy, primary, localComponentAuthMethodName, serviceAccountName, consulClient); err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
 	if c.flagMeshGateway {
 		rules, err := c.meshGatewayRules()
 		if err != nil {
-			c.log.Error("Error templating mesh gateway rules", "err", err)
-			return 1
+			return fmt.Errorf("error templating mesh gateway rules: %s", err)
 		}
 		serviceAccountName := c.withPrefix("mesh-gateway")
 
@@ -614,8 +705,7 @@ func (c *Command) Run(args []string) int {
 		}
 		err = c.createACLPolicyRoleAndBindingRule("mesh-gateway", rules, consulDC, primaryDC, globalPolicy, primary, authMethodName, serviceAccountName, consulClient)
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
@@ -631,8 +721,7 @@ func (c *Command) Run(args []string) int {
 		}
 		err := c.configureGateway(params, consulClient)
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
@@ -648,16 +737,14 @@ func (c *Command) Run(args []string) int {
 		}
 		err := c.configureGateway(params, consulClient)
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
 	if c.flagCreateACLReplicationToken {
 		rules, err := c.aclReplicationRules()
 		if err != nil {
-			c.log.Error("Error templating acl replication token rules", "err", err)
-			return 1
+			return fmt.Errorf("error templating acl replication token rules: %s", err)
 		}
 		// Policy must be global because it replicates from the primary DC
 		// and so the primary DC needs to be able to accept the token.
@@ -667,16 +754,14 @@ func (c *Command) Run(args []string) int {
 			err = c.createGlobalACL(common.ACLReplicationTokenName, rules, consulDC, primary, consulClient)
 		}
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
 
 	if c.flagController {
 		rules, err := c.controllerRules()
 		if err != nil {
-			c.log.Error("Error templating controller token rules", "err", err)
-			return 1
+			return fmt.Errorf("error templating controller token rules: %s", err)
 		}
 
 		serviceAccountName := c.withPrefix("controller")
@@ -691,12 +776,10 @@ func (c *Command) Run(args []string) int {
 		}
 		err = c.createACLPolicyRoleAndBindingRule("controller", rules, consulDC, primaryDC, globalPolicy, primary, authMethodName, serviceAccountName, consulClient)
 		if err != nil {
-			c.log.Error(err.Error())
-			return 1
+			return err
 		}
 	}
-	c.log.Info("server-acl-init completed successfully")
-	return 0
+	return nil
 }
 
 // configureGlobalComponentAuthMethod sets up an AuthMethod in the primary datacenter,
@@ -725,13 +808,32 @@ func (c *Command) configureLocalComponentAuthMethod(consulClient *api.Client, au
 
 // createAuthMethod creates the desired Authmethod.
 func (c *Command) createAuthMethod(consulClient *api.Client, authMethod *api.ACLAuthMethod, writeOptions *api.WriteOptions) error {
+	if c.plan != nil {
+		c.plan.authMethods = append(c.plan.authMethods, plannedAuthMethod{
+			authMethod: *authMethod,
+			opts:       api.QueryOptions{Datacenter: writeOptions.Datacenter, Namespace: writeOptions.Namespace},
+		})
+		return nil
+	}
 	return c.untilSucceeds(fmt.Sprintf("creating auth method %s", authMethod.Name),
 		func() error {
-			var err error
+			if c.reconciling {
+				existing, _, err := consulClient.ACL().AuthMethodRead(authMethod.Name, &api.QueryOptions{
+					Datacenter: writeOptions.Datacenter,
+					Namespace:  writeOptions.Namespace,
+				})
+				if err != nil {
+					return err
+				}
+				if existing == nil {
+					c.recordRepair(repairKindAuthMethod, authMethod.Name)
+				}
+			}
+
 			// `AuthMethodCreate` will also be able to update an existing
 			// AuthMethod based on the name provided. This means that any
 			// configuration changes will correctly update the AuthMethod.
-			_, _, err = consulClient.ACL().AuthMethodCreate(authMethod, writeOptions)
+			_, _, err := consulClient.ACL().AuthMethodCreate(authMethod, writeOptions)
 			return err
 		})
 }
@@ -882,6 +984,13 @@ func (c *Command) withPrefix(resource string) string {
 // consulDatacenterList returns the current datacenter name and the primary datacenter using the
 // /agent/self API endpoint.
 func (c *Command) consulDatacenterList(client *api.Client) (string, string, error) {
+	if c.flagDryRunOffline {
+		primaryDC := c.flagDryRunPrimaryDatacenter
+		if primaryDC == "" {
+			primaryDC = c.flagDryRunDatacenter
+		}
+		return c.flagDryRunDatacenter, primaryDC, nil
+	}
 	var agentCfg map[string]map[string]interface{}
 	err := c.untilSucceeds("calling /agent/self to get datacenter",
 		func() error {
@@ -943,7 +1052,7 @@ func (c *Command) createAnonymousPolicy(isPrimary bool) bool {
 }
 
 func (c *Command) validateFlags() error {
-	if len(c.flagServerAddresses) == 0 {
+	if len(c.flagServerAddresses) == 0 && !c.flagDryRunOffline {
 		return errors.New("-server-address must be set at least once")
 	}
 
@@ -983,6 +1092,30 @@ func (c *Command) validateFlags() error {
 		return errors.New("-consul-api-timeout must be set to a value greater than 0")
 	}
 
+	switch c.flagAuthMethodType {
+	case authMethodTypeKubernetes:
+	case authMethodTypeJWT:
+		if c.flagAuthMethodJWTAudience == "" {
+			return errors.New("-auth-method-jwt-audience must be set if -auth-method-type is jwt")
+		}
+	default:
+		return fmt.Errorf("-auth-method-type must be %q or %q", authMethodTypeKubernetes, authMethodTypeJWT)
+	}
+
+	if c.flagReconcile && c.flagReconcileInterval <= 0 {
+		return errors.New("-reconcile-interval must be set to a value greater than 0")
+	}
+
+	if c.flagDryRun && c.flagReconcile {
+		return errors.New("-dry-run and -reconcile cannot both be set")
+	}
+	if c.flagDryRunOffline && !c.flagDryRun {
+		return errors.New("-dry-run must be set if -dry-run-offline is set")
+	}
+	if c.flagDryRunFormat != planFormatText && c.flagDryRunFormat != planFormatJSON {
+		return fmt.Errorf("-dry-run-format=%s is invalid: must be %q or %q", c.flagDryRunFormat, planFormatText, planFormatJSON)
+	}
+
 	return nil
 }
 
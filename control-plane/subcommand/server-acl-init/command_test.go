@@ -97,6 +97,62 @@ func TestRun_FlagValidation(t *testing.T) {
 			ExpErr: "-sync-consul-node-name=5r9OPGfSRXUdGzNjBdAwmhCBrzHDNYs4XjZVR4wp7lSLIzqwS0ta51nBLIN0TMPV-too-long is invalid: node name will not be discoverable " +
 				"via DNS due to it being too long. Valid lengths are between 1 and 63 bytes",
 		},
+		{
+			Flags: []string{
+				"-server-address=localhost",
+				"-resource-prefix=prefix",
+				"-consul-api-timeout=5s",
+				"-reconcile",
+				"-reconcile-interval=0s",
+			},
+			ExpErr: "-reconcile-interval must be set to a value greater than 0",
+		},
+		{
+			Flags: []string{
+				"-server-address=localhost",
+				"-resource-prefix=prefix",
+				"-consul-api-timeout=5s",
+				"-reconcile",
+				"-dry-run",
+			},
+			ExpErr: "-dry-run and -reconcile cannot both be set",
+		},
+		{
+			Flags: []string{
+				"-resource-prefix=prefix",
+				"-consul-api-timeout=5s",
+				"-dry-run-offline",
+			},
+			ExpErr: "-dry-run must be set if -dry-run-offline is set",
+		},
+		{
+			Flags: []string{
+				"-server-address=localhost",
+				"-resource-prefix=prefix",
+				"-consul-api-timeout=5s",
+				"-dry-run",
+				"-dry-run-format=yaml",
+			},
+			ExpErr: "-dry-run-format=yaml is invalid: must be \"text\" or \"json\"",
+		},
+		{
+			Flags: []string{
+				"-server-address=localhost",
+				"-resource-prefix=prefix",
+				"-consul-api-timeout=5s",
+				"-auth-method-type=oidc",
+			},
+			ExpErr: "-auth-method-type must be \"kubernetes\" or \"jwt\"",
+		},
+		{
+			Flags: []string{
+				"-server-address=localhost",
+				"-resource-prefix=prefix",
+				"-consul-api-timeout=5s",
+				"-auth-method-type=jwt",
+			},
+			ExpErr: "-auth-method-jwt-audience must be set if -auth-method-type is jwt",
+		},
 	}
 
 	for _, c := range cases {
@@ -43,6 +43,16 @@ func (c *Command) configureConnectInjectAuthMethod(consulClient *api.Client, aut
 			err = c.untilSucceeds(fmt.Sprintf("checking or creating namespace %s",
 				c.flagConsulInjectDestinationNamespace),
 				func() error {
+					if c.plan != nil {
+						c.plan.namespaces = append(c.plan.namespaces, plannedNamespace{
+							namespace: api.Namespace{
+								Name: c.flagConsulInjectDestinationNamespace,
+								ACLs: &api.NamespaceACLConfig{PolicyDefaults: []api.ACLLink{{Name: "cross-namespace-policy"}}},
+							},
+							createOnly: true,
+						})
+						return nil
+					}
 					_, err := namespaces.EnsureExists(consulClient, c.flagConsulInjectDestinationNamespace, "cross-namespace-policy")
 					return err
 				})
@@ -52,15 +62,7 @@ func (c *Command) configureConnectInjectAuthMethod(consulClient *api.Client, aut
 		}
 	}
 
-	err = c.untilSucceeds(fmt.Sprintf("creating auth method %s", authMethodTmpl.Name),
-		func() error {
-			var err error
-			// `AuthMethodCreate` will also be able to update an existing
-			// AuthMethod based on the name provided. This means that any
-			// configuration changes will correctly update the AuthMethod.
-			_, _, err = consulClient.ACL().AuthMethodCreate(&authMethodTmpl, &writeOptions)
-			return err
-		})
+	err = c.createAuthMethod(consulClient, &authMethodTmpl, &writeOptions)
 	if err != nil {
 		return err
 	}
@@ -71,8 +73,8 @@ func (c *Command) configureConnectInjectAuthMethod(consulClient *api.Client, aut
 		Description: "Kubernetes binding rule",
 		AuthMethod:  authMethodName,
 		BindType:    api.BindingRuleBindTypeService,
-		BindName:    "${serviceaccount.name}",
-		Selector:    c.flagBindingRuleSelector,
+		BindName:    c.authMethodSelector("${serviceaccount.name}"),
+		Selector:    c.authMethodSelector(c.flagBindingRuleSelector),
 	}
 	return c.createConnectBindingRule(consulClient, authMethodName, &abr)
 }
@@ -81,6 +83,53 @@ func (c *Command) configureConnectInjectAuthMethod(consulClient *api.Client, aut
 // jwt token. It is common for both the connect inject auth method and the component auth method
 // with the option to add namespace specific configuration to the auth method template via `useNS`.
 func (c *Command) createAuthMethodTmpl(authMethodName string, useNS bool) (api.ACLAuthMethod, error) {
+	if c.flagAuthMethodType == authMethodTypeJWT {
+		return c.createJWTAuthMethodTmpl(authMethodName, useNS)
+	}
+
+	// The credentials are read from Kubernetes so they are left out when
+	// planning offline.
+	saSecret := &apiv1.Secret{}
+	if !c.flagDryRunOffline {
+		var err error
+		saSecret, err = c.authMethodServiceAccountSecret()
+		if err != nil {
+			return api.ACLAuthMethod{}, err
+		}
+	}
+
+	kubernetesHost := defaultKubernetesHost
+
+	// Check if custom auth method Host and CACert are provided
+	if c.flagAuthMethodHost != "" {
+		kubernetesHost = c.flagAuthMethodHost
+	}
+
+	// Now we're ready to set up Consul's auth method.
+	authMethodTmpl := api.ACLAuthMethod{
+		Name:        authMethodName,
+		Description: "Kubernetes Auth Method",
+		Type:        "kubernetes",
+		Config: map[string]interface{}{
+			"Host":              kubernetesHost,
+			"CACert":            string(saSecret.Data["ca.crt"]),
+			"ServiceAccountJWT": string(saSecret.Data["token"]),
+		},
+	}
+
+	// Add options for mirroring namespaces, this is only used by the connect inject auth method
+	// and so can be disabled for the component auth method.
+	if useNS && c.flagEnableNamespaces && c.flagEnableInjectK8SNSMirroring {
+		authMethodTmpl.Config["MapNamespaces"] = true
+		authMethodTmpl.Config["ConsulNamespacePrefix"] = c.flagInjectK8SNSMirroringPrefix
+	}
+
+	return authMethodTmpl, nil
+}
+
+// authMethodServiceAccountSecret returns the Secret holding the JWT token of
+// the auth method's ServiceAccount.
+func (c *Command) authMethodServiceAccountSecret() (*apiv1.Secret, error) {
 	// Get the Secret name for the auth method ServiceAccount.
 	var authMethodServiceAccount *apiv1.ServiceAccount
 	serviceAccountName := c.withPrefix("auth-method")
@@ -91,7 +140,7 @@ func (c *Command) createAuthMethodTmpl(authMethodName string, useNS bool) (api.A
 			return err
 		})
 	if err != nil {
-		return api.ACLAuthMethod{}, err
+		return nil, err
 	}
 
 	var saSecret *apiv1.Secret
@@ -124,41 +173,13 @@ func (c *Command) createAuthMethodTmpl(authMethodName string, useNS bool) (api.A
 		}
 	}
 	if err != nil {
-		return api.ACLAuthMethod{}, err
+		return nil, err
 	}
 
 	// This is unlikely to happen since we now deploy the secret through Helm, but should catch any corner-cases
 	// where the secret is not deployed for some reason.
 	if saSecret == nil {
-		return api.ACLAuthMethod{},
-			fmt.Errorf("found no secret of type 'kubernetes.io/service-account-token' associated with the %s service account", serviceAccountName)
+		return nil, fmt.Errorf("found no secret of type 'kubernetes.io/service-account-token' associated with the %s service account", serviceAccountName)
 	}
-
-	kubernetesHost := defaultKubernetesHost
-
-	// Check if custom auth method Host and CACert are provided
-	if c.flagAuthMethodHost != "" {
-		kubernetesHost = c.flagAuthMethodHost
-	}
-
-	// Now we're ready to set up Consul's auth method.
-	authMethodTmpl := api.ACLAuthMethod{
-		Name:        authMethodName,
-		Description: "Kubernetes Auth Method",
-		Type:        "kubernetes",
-		Config: map[string]interface{}{
-			"Host":              kubernetesHost,
-			"CACert":            string(saSecret.Data["ca.crt"]),
-			"ServiceAccountJWT": string(saSecret.Data["token"]),
-		},
-	}
-
-	// Add options for mirroring namespaces, this is only used by the connect inject auth method
-	// and so can be disabled for the component auth method.
-	if useNS && c.flagEnableNamespaces && c.flagEnableInjectK8SNSMirroring {
-		authMethodTmpl.Config["MapNamespaces"] = true
-		authMethodTmpl.Config["ConsulNamespacePrefix"] = c.flagInjectK8SNSMirroringPrefix
-	}
-
-	return authMethodTmpl, nil
+	return saSecret, nil
 }
@@ -75,7 +75,7 @@ func (c *Command) addRoleAndBindingRule(client *api.Client, serviceAccountName s
 	abr := &api.ACLBindingRule{
 		Description: fmt.Sprintf("Binding Rule for %s", serviceAccountName),
 		AuthMethod:  authMethodName,
-		Selector:    fmt.Sprintf("serviceaccount.name==%q", serviceAccountName),
+		Selector:    c.authMethodSelector(fmt.Sprintf("serviceaccount.name==%q", serviceAccountName)),
 		BindType:    api.BindingRuleBindTypeRole,
 		BindName:    aclRoleName,
 	}
@@ -89,6 +89,10 @@ func (c *Command) addRoleAndBindingRule(client *api.Client, serviceAccountName s
 // updateOrCreateACLRole will query to see if existing role is in place and update them
 // or create them if they do not yet exist.
 func (c *Command) updateOrCreateACLRole(client *api.Client, role *api.ACLRole) error {
+	if c.plan != nil {
+		c.plan.roles = append(c.plan.roles, *role)
+		return nil
+	}
 	err := c.untilSucceeds(fmt.Sprintf("update or create acl role for %s", role.Name),
 		func() error {
 			var err error
@@ -98,6 +102,12 @@ func (c *Command) updateOrCreateACLRole(client *api.Client, role *api.ACLRole) e
 				return err
 			}
 			if aclRole != nil {
+				// Add the policies of the role that are missing but keep any
+				// other policies since users may attach their own to roles.
+				if missing := missingRolePolicies(aclRole, role); len(missing) > 0 {
+					c.recordRepair(repairKindRole, role.Name)
+					aclRole.Policies = append(aclRole.Policies, missing...)
+				}
 				_, _, err := client.ACL().RoleUpdate(aclRole, &api.WriteOptions{})
 				if err != nil {
 					c.log.Error("unable to update role", err)
@@ -110,11 +120,31 @@ func (c *Command) updateOrCreateACLRole(client *api.Client, role *api.ACLRole) e
 				c.log.Error("unable to create role", err)
 				return err
 			}
+			c.recordRepair(repairKindRole, role.Name)
 			return err
 		})
 	return err
 }
 
+// missingRolePolicies returns the policies of the desired role that are not
+// linked to the existing role.
+func missingRolePolicies(existing, desired *api.ACLRole) []*api.ACLRolePolicyLink {
+	var missing []*api.ACLRolePolicyLink
+	for _, want := range desired.Policies {
+		found := false
+		for _, have := range existing.Policies {
+			if have.Name == want.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, want)
+		}
+	}
+	return missing
+}
+
 // createConnectBindingRule will query to see if existing binding rules are in place and update them
 // or create them if they do not yet exist.
 func (c *Command) createConnectBindingRule(client *api.Client, authMethodName string, abr *api.ACLBindingRule) error {
@@ -133,6 +163,14 @@ func (c *Command) createConnectBindingRule(client *api.Client, authMethodName st
 }
 
 func (c *Command) createOrUpdateBindingRule(client *api.Client, authMethodName string, abr *api.ACLBindingRule, queryOptions *api.QueryOptions, writeOptions *api.WriteOptions) error {
+	if c.plan != nil {
+		opts := api.QueryOptions{Namespace: queryOptions.Namespace}
+		if writeOptions != nil {
+			opts.Datacenter = writeOptions.Datacenter
+		}
+		c.plan.bindingRules = append(c.plan.bindingRules, plannedBindingRule{bindingRule: *abr, opts: opts})
+		return nil
+	}
 	var existingRules []*api.ACLBindingRule
 	err := c.untilSucceeds(fmt.Sprintf("listing binding rules for auth method %s", authMethodName),
 		func() error {
@@ -154,6 +192,9 @@ func (c *Command) createOrUpdateBindingRule(client *api.Client, authMethodName s
 		for _, existingRule := range existingRules {
 			if existingRule.BindName == abr.BindName && existingRule.Description == abr.Description {
 				abr.ID = existingRule.ID
+				if existingRule.Selector != abr.Selector || existingRule.BindType != abr.BindType {
+					c.recordRepair(repairKindBindingRule, abr.Description)
+				}
 			}
 		}
 
@@ -164,6 +205,7 @@ func (c *Command) createOrUpdateBindingRule(client *api.Client, authMethodName s
 		// same auth method.
 		if abr.ID == "" {
 			c.log.Info("unable to find a matching ACL binding rule to update. creating ACL binding rule.")
+			c.recordRepair(repairKindBindingRule, abr.Description)
 			err = c.untilSucceeds(fmt.Sprintf("creating acl binding rule for %s", authMethodName),
 				func() error {
 					_, _, err := client.ACL().BindingRuleCreate(abr, writeOptions)
@@ -178,6 +220,7 @@ func (c *Command) createOrUpdateBindingRule(client *api.Client, authMethodName s
 		}
 	} else {
 		// Otherwise create the binding rule
+		c.recordRepair(repairKindBindingRule, abr.Description)
 		err = c.untilSucceeds(fmt.Sprintf("creating acl binding rule for %s", authMethodName),
 			func() error {
 				_, _, err := client.ACL().BindingRuleCreate(abr, writeOptions)
@@ -243,6 +286,10 @@ func (c *Command) createACL(name, rules string, localToken bool, dc string, isPr
 		Policies:    []*api.ACLTokenPolicyLink{{Name: policyTmpl.Name}},
 		Local:       localToken,
 	}
+	if c.plan != nil {
+		c.plan.tokens = append(c.plan.tokens, tokenTmpl)
+		return nil
+	}
 
 	// Check if the replication token already exists in some form.
 	// When secretID is not provided, we assume that replication token should exist
@@ -251,9 +298,12 @@ func (c *Command) createACL(name, rules string, localToken bool, dc string, isPr
 	if secretID == "" {
 		// Check if the secret already exists, if so, we assume the ACL has already been
 		// created and return.
-		_, err = c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(c.ctx, secretName, metav1.GetOptions{})
+		secret, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(c.ctx, secretName, metav1.GetOptions{})
 		if err == nil {
 			c.log.Info(fmt.Sprintf("Secret %q already exists", secretName))
+			if c.reconciling {
+				return c.repairToken(consulClient, tokenTmpl, string(secret.Data[common.ACLTokenSecretKey]))
+			}
 			return nil
 		}
 	} else {
@@ -268,6 +318,7 @@ func (c *Command) createACL(name, rules string, localToken bool, dc string, isPr
 		}
 	}
 
+	c.recordRepair(repairKindToken, policyTmpl.Name)
 	var token string
 	err = c.untilSucceeds(fmt.Sprintf("creating token for policy %s", policyTmpl.Name),
 		func() error {
@@ -301,9 +352,36 @@ func (c *Command) createACL(name, rules string, localToken bool, dc string, isPr
 	return nil
 }
 
+// repairToken recreates the token with the given secret ID from tokenTmpl
+// if it was deleted from Consul while its Kubernetes secret still exists.
+func (c *Command) repairToken(consulClient *api.Client, tokenTmpl api.ACLToken, secretID string) error {
+	if secretID == "" {
+		return nil
+	}
+	return c.untilSucceeds(fmt.Sprintf("checking token for policy %s", tokenTmpl.Policies[0].Name),
+		func() error {
+			_, _, err := consulClient.ACL().TokenReadSelf(&api.QueryOptions{Token: secretID})
+			if err == nil || !isACLNotFoundErr(err) {
+				return err
+			}
+			c.recordRepair(repairKindToken, tokenTmpl.Policies[0].Name)
+			tokenTmpl.SecretID = secretID
+			_, _, err = consulClient.ACL().TokenCreate(&tokenTmpl, &api.WriteOptions{})
+			return err
+		})
+}
+
 func (c *Command) createOrUpdateACLPolicy(policy api.ACLPolicy, consulClient *api.Client) error {
+	if c.plan != nil {
+		c.plan.policies = append(c.plan.policies, policy)
+		return nil
+	}
+
 	// Attempt to create the ACL policy.
 	_, _, err := consulClient.ACL().PolicyCreate(&policy, &api.WriteOptions{})
+	if err == nil {
+		c.recordRepair(repairKindPolicy, policy.Name)
+	}
 
 	// With the introduction of Consul namespaces, if someone upgrades into a
 	// Consul version with namespace support or changes any of their namespace
@@ -312,7 +390,7 @@ func (c *Command) createOrUpdateACLPolicy(policy api.ACLPolicy, consulClient *ap
 	// Allowing the Consul node name to be configurable also requires any sync
 	// policy to be updated in case the node name has changed.
 	if isPolicyExistsErr(err, policy.Name) {
-		if c.flagEnableNamespaces || c.flagSyncCatalog {
+		if c.flagEnableNamespaces || c.flagSyncCatalog || c.flagReconcile {
 			c.log.Info(fmt.Sprintf("Policy %q already exists, updating", policy.Name))
 
 			// The policy ID is required in any PolicyUpdate call, so first we need to
@@ -341,6 +419,16 @@ func (c *Command) createOrUpdateACLPolicy(policy api.ACLPolicy, consulClient *ap
 					policy.Name, policy.Description)
 			}
 
+			if c.reconciling {
+				existing, _, err := consulClient.ACL().PolicyRead(policy.ID, &api.QueryOptions{})
+				if err != nil {
+					return err
+				}
+				if existing != nil && (existing.Rules != policy.Rules || !stringSlicesEqual(existing.Datacenters, policy.Datacenters)) {
+					c.recordRepair(repairKindPolicy, policy.Name)
+				}
+			}
+
 			// Update the policy now that we've found its ID
 			_, _, err = consulClient.ACL().PolicyUpdate(&policy, &api.WriteOptions{})
 			return err
@@ -359,3 +447,23 @@ func isPolicyExistsErr(err error, policyName string) bool {
 		strings.Contains(err.Error(), "Unexpected response code: 500") &&
 		strings.Contains(err.Error(), fmt.Sprintf("Invalid Policy: A Policy with Name %q already exists", policyName))
 }
+
+// isACLNotFoundErr returns true if err is due to an ACL token that doesn't
+// exist.
+func isACLNotFoundErr(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "ACL not found")
+}
+
+// stringSlicesEqual returns true if a and b hold the same strings in the
+// same order. A nil and an empty slice are equal.
+func stringSlicesEqual(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
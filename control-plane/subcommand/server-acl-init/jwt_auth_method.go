@@ -0,0 +1,239 @@
+package serveraclinit
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+)
+
+const (
+	authMethodTypeKubernetes = "kubernetes"
+	authMethodTypeJWT        = "jwt"
+
+	// oidcDiscoveryPath and jwksPath are the paths of the service account
+	// issuer discovery endpoints of the Kubernetes API.
+	oidcDiscoveryPath = "/.well-known/openid-configuration"
+	jwksPath          = "/openid/v1/jwks"
+)
+
+// jwtClaimMappings maps the claims of projected service account tokens to the
+// fields of the jwt auth method that binding rules select on.
+var jwtClaimMappings = map[string]string{
+	"/kubernetes.io/serviceaccount/name": "serviceaccount_name",
+	"/kubernetes.io/serviceaccount/uid":  "serviceaccount_uid",
+	"/kubernetes.io/namespace":           "serviceaccount_namespace",
+}
+
+// jwtSelectorReplacer translates the fields of the kubernetes auth method in
+// selectors and bind names to the mapped claims of the jwt auth method, so that
+// the same selectors can be used with both types.
+var jwtSelectorReplacer = strings.NewReplacer(
+	"serviceaccount.name", "value.serviceaccount_name",
+	"serviceaccount.uid", "value.serviceaccount_uid",
+	"serviceaccount.namespace", "value.serviceaccount_namespace",
+)
+
+// authMethodSelector returns the selector or bind name written for the
+// kubernetes auth method in terms of the configured auth method type.
+func (c *Command) authMethodSelector(selector string) string {
+	if c.flagAuthMethodType == authMethodTypeJWT {
+		return jwtSelectorReplacer.Replace(selector)
+	}
+	return selector
+}
+
+// createJWTAuthMethodTmpl sets up a jwt auth method that validates projected
+// service account tokens with the audience of -auth-method-jwt-audience. The
+// keys that sign the tokens are read from the service account issuer discovery
+// endpoints of the Kubernetes API, so that Consul servers don't need to reach
+// the Kubernetes API.
+func (c *Command) createJWTAuthMethodTmpl(authMethodName string, useNS bool) (api.ACLAuthMethod, error) {
+	issuer := c.flagAuthMethodJWTIssuer
+	var keys []string
+	// The keys are read from Kubernetes so they are left out when planning
+	// offline.
+	if !c.flagDryRunOffline {
+		discoveredIssuer, discoveredKeys, err := c.serviceAccountIssuerKeys()
+		if err != nil {
+			return api.ACLAuthMethod{}, err
+		}
+		if issuer == "" {
+			issuer = discoveredIssuer
+		}
+		keys = discoveredKeys
+	}
+
+	authMethodTmpl := api.ACLAuthMethod{
+		Name:        authMethodName,
+		Description: "Kubernetes JWT Auth Method",
+		Type:        authMethodTypeJWT,
+		Config: map[string]interface{}{
+			"JWTValidationPubKeys": keys,
+			"BoundIssuer":          issuer,
+			"BoundAudiences":       []string{c.flagAuthMethodJWTAudience},
+			"ClaimMappings":        jwtClaimMappings,
+		},
+	}
+
+	// The jwt auth method has no option to map namespaces, so the Consul
+	// namespace is bound from the Kubernetes namespace claim instead.
+	if useNS && c.flagEnableNamespaces && c.flagEnableInjectK8SNSMirroring {
+		authMethodTmpl.NamespaceRules = []*api.ACLAuthMethodNamespaceRule{
+			{BindNamespace: c.flagInjectK8SNSMirroringPrefix + "${value.serviceaccount_namespace}"},
+		}
+	}
+
+	return authMethodTmpl, nil
+}
+
+// serviceAccountIssuerKeys returns the issuer of the service account tokens
+// of the cluster and the PEM encoded public keys that sign them.
+func (c *Command) serviceAccountIssuerKeys() (string, []string, error) {
+	var discovery struct {
+		Issuer string `json:"issuer"`
+	}
+	err := c.untilSucceeds("getting the service account issuer discovery document",
+		func() error {
+			raw, err := c.getOIDCDiscovery(oidcDiscoveryPath)
+			if err != nil {
+				return err
+			}
+			return json.Unmarshal(raw, &discovery)
+		})
+	if err != nil {
+		return "", nil, err
+	}
+
+	var keys []string
+	err = c.untilSucceeds("getting the service account issuer keys",
+		func() error {
+			raw, err := c.getOIDCDiscovery(jwksPath)
+			if err != nil {
+				return err
+			}
+			keys, err = jwksToPEM(raw)
+			return err
+		})
+	if err != nil {
+		return "", nil, err
+	}
+	return discovery.Issuer, keys, nil
+}
+
+// getOIDCDiscovery reads a service account issuer discovery endpoint of the
+// Kubernetes API.
+func (c *Command) getOIDCDiscovery(path string) ([]byte, error) {
+	if c.oidcDiscovery != nil {
+		return c.oidcDiscovery(path)
+	}
+	return c.clientset.CoreV1().RESTClient().Get().AbsPath(path).DoRaw(c.ctx)
+}
+
+// jsonWebKey holds the fields of a JSON Web Key that are used by the public
+// keys of service account issuers.
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwksToPEM returns the signing keys of the JSON Web Key Set as PEM encoded
+// public keys.
+func jwksToPEM(raw []byte) ([]string, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &set); err != nil {
+		return nil, fmt.Errorf("parsing JSON Web Key Set: %s", err)
+	}
+
+	var keys []string
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		pub, err := key.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("parsing key %q: %s", key.Kid, err)
+		}
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			return nil, fmt.Errorf("encoding key %q: %s", key.Kid, err)
+		}
+		keys = append(keys, string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})))
+	}
+	if len(keys) == 0 {
+		return nil, errors.New("JSON Web Key Set has no signing keys")
+	}
+	return keys, nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBase64URLInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %s", err)
+		}
+		e, err := decodeBase64URLInt(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent: %s", err)
+		}
+		if !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBase64URLInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x coordinate: %s", err)
+		}
+		y, err := decodeBase64URLInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y coordinate: %s", err)
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBase64URLInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,244 @@
+package serveraclinit
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const testIssuer = "https://kubernetes.default.svc.cluster.local"
+
+// Test that the jwt auth methods accept projected service account tokens with
+// the audience and that the binding rules select on their claims.
+func TestRun_JWTAuthMethod(t *testing.T) {
+	t.Parallel()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	k8s, testSvr := completeSetup(t)
+	defer testSvr.Stop()
+
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:            ui,
+		clientset:     k8s,
+		oidcDiscovery: testOIDCDiscovery(t, &key.PublicKey),
+	}
+	responseCode := cmd.Run([]string{
+		"-timeout=1m",
+		"-resource-prefix=" + resourcePrefix,
+		"-k8s-namespace=" + ns,
+		"-server-address", strings.Split(testSvr.HTTPAddr, ":")[0],
+		"-server-port", strings.Split(testSvr.HTTPAddr, ":")[1],
+		"-consul-api-timeout=5s",
+		"-sync-catalog",
+		"-connect-inject",
+		"-acl-binding-rule-selector=serviceaccount.name!=default",
+		"-auth-method-type=jwt",
+		"-auth-method-jwt-audience=consul",
+	})
+	require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+
+	secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), resourcePrefix+"-bootstrap-acl-token", metav1.GetOptions{})
+	require.NoError(t, err)
+	consul, err := api.NewClient(&api.Config{Address: testSvr.HTTPAddr, Token: string(secret.Data["token"])})
+	require.NoError(t, err)
+
+	authMethod, _, err := consul.ACL().AuthMethodRead(resourcePrefix+"-k8s-component-auth-method", nil)
+	require.NoError(t, err)
+	require.Equal(t, "jwt", authMethod.Type)
+	require.Equal(t, testIssuer, authMethod.Config["BoundIssuer"])
+	require.Equal(t, []interface{}{"consul"}, authMethod.Config["BoundAudiences"])
+	require.Len(t, authMethod.Config["JWTValidationPubKeys"], 1)
+
+	rules, _, err := consul.ACL().BindingRuleList(resourcePrefix+"-k8s-auth-method", nil)
+	require.NoError(t, err)
+	require.Len(t, rules, 1)
+	require.Equal(t, "value.serviceaccount_name!=default", rules[0].Selector)
+	require.Equal(t, "${value.serviceaccount_name}", rules[0].BindName)
+
+	// A projected token of the catalog sync service account logs in with its role.
+	anonymous, err := api.NewClient(&api.Config{Address: testSvr.HTTPAddr})
+	require.NoError(t, err)
+	token, _, err := anonymous.ACL().Login(&api.ACLLoginParams{
+		AuthMethod:  resourcePrefix + "-k8s-component-auth-method",
+		BearerToken: signTestJWT(t, key, "consul", resourcePrefix+"-sync-catalog"),
+	}, nil)
+	require.NoError(t, err)
+	require.Len(t, token.Roles, 1)
+	require.Equal(t, resourcePrefix+"-sync-catalog-acl-role", token.Roles[0].Name)
+
+	// Tokens with another audience are rejected.
+	_, _, err = anonymous.ACL().Login(&api.ACLLoginParams{
+		AuthMethod:  resourcePrefix + "-k8s-component-auth-method",
+		BearerToken: signTestJWT(t, key, "kubernetes", resourcePrefix+"-sync-catalog"),
+	}, nil)
+	require.Error(t, err)
+}
+
+// Test that the jwt auth method is planned from the flags when offline.
+func TestRun_DryRunOfflineJWTAuthMethod(t *testing.T) {
+	t.Parallel()
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	responseCode := cmd.Run([]string{
+		"-resource-prefix=" + resourcePrefix,
+		"-consul-api-timeout=5s",
+		"-dry-run",
+		"-dry-run-offline",
+		"-dry-run-format=json",
+		"-client=false",
+		"-connect-inject",
+		"-acl-binding-rule-selector=serviceaccount.name!=default",
+		"-auth-method-type=jwt",
+		"-auth-method-jwt-audience=consul",
+		"-auth-method-jwt-issuer=" + testIssuer,
+	})
+	require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+
+	var plan testPlan
+	require.NoError(t, json.Unmarshal([]byte(ui.OutputWriter.String()), &plan))
+	var authMethods, bindingRules []map[string]string
+	for _, c := range plan.Changes {
+		switch c.Kind {
+		case planKindAuthMethod:
+			authMethods = append(authMethods, c.Desired)
+		case planKindBindingRule:
+			bindingRules = append(bindingRules, c.Desired)
+		}
+	}
+	require.Len(t, authMethods, 2)
+	for _, am := range authMethods {
+		require.Equal(t, "jwt", am["type"])
+		require.Equal(t, testIssuer, am["config.BoundIssuer"])
+		require.Equal(t, "[consul]", am["config.BoundAudiences"])
+		require.Contains(t, am["config.ClaimMappings"], "/kubernetes.io/serviceaccount/name:serviceaccount_name")
+	}
+	require.Contains(t, bindingRules, map[string]string{
+		"auth method": resourcePrefix + "-k8s-auth-method",
+		"selector":    "value.serviceaccount_name!=default",
+		"bind type":   "service",
+		"bind name":   "${value.serviceaccount_name}",
+	})
+}
+
+func TestJWKSToPEM(t *testing.T) {
+	t.Parallel()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	jwks := fmt.Sprintf(`{"keys": [%s, %s, {"use": "enc", "kty": "oct"}]}`, rsaJWK(&rsaKey.PublicKey), ecJWK(&ecKey.PublicKey))
+	keys, err := jwksToPEM([]byte(jwks))
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+
+	for i, expected := range []interface{}{&rsaKey.PublicKey, &ecKey.PublicKey} {
+		block, _ := pem.Decode([]byte(keys[i]))
+		require.NotNil(t, block)
+		require.Equal(t, "PUBLIC KEY", block.Type)
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		require.NoError(t, err)
+		require.Equal(t, expected, pub)
+	}
+
+	cases := map[string]string{
+		`{"keys": []}`:                                        "JSON Web Key Set has no signing keys",
+		`{"keys": [{"kid": "a", "kty": "oct"}]}`:              `parsing key "a": unsupported key type "oct"`,
+		`{"keys": [{"kid": "a", "kty": "RSA", "e": "AQAB"}]}`: `parsing key "a": invalid modulus: empty value`,
+		`{"keys": [{"kid": "a", "kty": "EC", "crv": "P-256", "x": "AQ", "y": "AQ"}]}`: `parsing key "a": point is not on the curve`,
+		`not json`: "parsing JSON Web Key Set: invalid character 'o' in literal null (expecting 'u')",
+	}
+	for jwks, expErr := range cases {
+		_, err := jwksToPEM([]byte(jwks))
+		require.EqualError(t, err, expErr)
+	}
+}
+
+func TestAuthMethodSelector(t *testing.T) {
+	t.Parallel()
+	cmd := Command{flagAuthMethodType: authMethodTypeKubernetes}
+	require.Equal(t, `serviceaccount.name=="sync"`, cmd.authMethodSelector(`serviceaccount.name=="sync"`))
+
+	cmd.flagAuthMethodType = authMethodTypeJWT
+	require.Equal(t, `value.serviceaccount_name=="sync"`, cmd.authMethodSelector(`serviceaccount.name=="sync"`))
+	require.Equal(t, `value.serviceaccount_namespace!=default and value.serviceaccount_uid!=""`,
+		cmd.authMethodSelector(`serviceaccount.namespace!=default and serviceaccount.uid!=""`))
+	require.Equal(t, "${value.serviceaccount_name}", cmd.authMethodSelector("${serviceaccount.name}"))
+	require.Equal(t, "", cmd.authMethodSelector(""))
+}
+
+// testOIDCDiscovery returns a function serving the service account issuer
+// discovery endpoints with the key.
+func testOIDCDiscovery(t *testing.T, key *rsa.PublicKey) func(string) ([]byte, error) {
+	return func(path string) ([]byte, error) {
+		switch path {
+		case oidcDiscoveryPath:
+			return []byte(fmt.Sprintf(`{"issuer": %q, "jwks_uri": "https://10.0.0.1:443/openid/v1/jwks"}`, testIssuer)), nil
+		case jwksPath:
+			return []byte(fmt.Sprintf(`{"keys": [%s]}`, rsaJWK(key))), nil
+		}
+		t.Errorf("unexpected discovery path %s", path)
+		return nil, fmt.Errorf("not found")
+	}
+}
+
+// signTestJWT returns a projected service account token of the service account
+// in ns with the audience.
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, audience, serviceAccount string) string {
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": "test"})
+	require.NoError(t, err)
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": testIssuer,
+		"aud": []string{audience},
+		"sub": fmt.Sprintf("system:serviceaccount:%s:%s", ns, serviceAccount),
+		"iat": now.Unix(),
+		"nbf": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+		"kubernetes.io": map[string]interface{}{
+			"namespace": ns,
+			"serviceaccount": map[string]string{
+				"name": serviceAccount,
+				"uid":  "9b1e2b8c-6f0e-4c53-9d2e-2f5b4b6b1a11",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	require.NoError(t, err)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func rsaJWK(key *rsa.PublicKey) string {
+	return fmt.Sprintf(`{"use": "sig", "kty": "RSA", "kid": "test", "alg": "RS256", "n": %q, "e": %q}`,
+		base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()))
+}
+
+func ecJWK(key *ecdsa.PublicKey) string {
+	return fmt.Sprintf(`{"use": "sig", "kty": "EC", "kid": "test-ec", "crv": "P-256", "x": %q, "y": %q}`,
+		base64.RawURLEncoding.EncodeToString(key.X.Bytes()),
+		base64.RawURLEncoding.EncodeToString(key.Y.Bytes()))
+}
@@ -0,0 +1,585 @@
+package serveraclinit
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+const (
+	// Kinds of the ACL objects in a plan.
+	planKindNamespace   = "namespace"
+	planKindPolicy      = "policy"
+	planKindAuthMethod  = "auth method"
+	planKindRole        = "role"
+	planKindBindingRule = "binding rule"
+	planKindToken       = "token"
+
+	// Actions of the changes in a plan.
+	planActionAdd    = "add"
+	planActionChange = "change"
+	planActionRemove = "remove"
+
+	// Formats of the plan output.
+	planFormatText = "text"
+	planFormatJSON = "json"
+
+	// anonymousTokenAccessorID is the accessor ID of the anonymous token.
+	anonymousTokenAccessorID = "00000000-0000-0000-0000-000000000002"
+)
+
+// Config keys of the Kubernetes auth methods that hold credentials. Their
+// values are never printed.
+var sensitiveAuthMethodConfigKeys = map[string]bool{
+	"CACert":            true,
+	"ServiceAccountJWT": true,
+}
+
+// aclPlan holds the ACL objects that server-acl-init would write when it runs
+// with -dry-run. They are recorded instead of being written to Consul.
+type aclPlan struct {
+	datacenter string
+	primary    bool
+
+	namespaces   []plannedNamespace
+	policies     []api.ACLPolicy
+	authMethods  []plannedAuthMethod
+	roles        []api.ACLRole
+	bindingRules []plannedBindingRule
+	tokens       []api.ACLToken
+}
+
+// plannedNamespace is a Consul namespace of a plan. Namespaces that are
+// createOnly are only created if they don't exist and are never updated.
+type plannedNamespace struct {
+	namespace  api.Namespace
+	createOnly bool
+}
+
+// plannedAuthMethod is an auth method of a plan with the options it is
+// written with.
+type plannedAuthMethod struct {
+	authMethod api.ACLAuthMethod
+	opts       api.QueryOptions
+}
+
+// plannedBindingRule is a binding rule of a plan with the options it is
+// written with.
+type plannedBindingRule struct {
+	bindingRule api.ACLBindingRule
+	opts        api.QueryOptions
+}
+
+// planField is a field of an ACL object in a plan. Fields are printed in
+// order.
+type planField struct {
+	Name  string
+	Value string
+}
+
+// planObject is an ACL object of a plan, described by its fields.
+type planObject struct {
+	Kind   string
+	Name   string
+	Fields []planField
+	// createOnly is true for objects that server-acl-init only creates if
+	// they don't exist, such as tokens, so they are never changed.
+	createOnly bool
+}
+
+// planChange is a change that server-acl-init would make to an ACL object.
+// Current is empty for added objects and Desired is empty for removed ones.
+type planChange struct {
+	Action  string
+	Kind    string
+	Name    string
+	Current []planField
+	Desired []planField
+}
+
+// MarshalJSON encodes the fields of the change as objects.
+func (p planChange) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Action  string            `json:"action"`
+		Kind    string            `json:"kind"`
+		Name    string            `json:"name"`
+		Current map[string]string `json:"current,omitempty"`
+		Desired map[string]string `json:"desired,omitempty"`
+	}{
+		Action:  p.Action,
+		Kind:    p.Kind,
+		Name:    p.Name,
+		Current: fieldsMap(p.Current),
+		Desired: fieldsMap(p.Desired),
+	})
+}
+
+// dryRun configures the ACLs with a plan that records the ACL objects
+// instead of writing them, then prints how they differ from the ones in
+// Consul. When offline, nothing is read from Consul.
+func (c *Command) dryRun(consulClient *api.Client, clientConfig *api.Config, aclReplicationToken, partitionToken string) int {
+	c.plan = &aclPlan{}
+	if err := c.configureACLs(consulClient, clientConfig, aclReplicationToken, partitionToken); err != nil {
+		c.log.Error(err.Error())
+		return 1
+	}
+
+	currentClient := consulClient
+	if c.flagDryRunOffline {
+		currentClient = nil
+	}
+	changes, err := c.plan.diff(currentClient, c.flagResourcePrefix)
+	if err != nil {
+		c.log.Error(fmt.Sprintf("Error reading the current ACLs: %s", err))
+		return 1
+	}
+	out, err := formatPlan(changes, c.flagDryRunFormat)
+	if err != nil {
+		c.log.Error(fmt.Sprintf("Error formatting the plan: %s", err))
+		return 1
+	}
+	c.UI.Output(out)
+	return 0
+}
+
+// objects returns the desired ACL objects of the plan in the order they are
+// written.
+func (p *aclPlan) objects() []planObject {
+	var objects []planObject
+	for _, ns := range p.namespaces {
+		objects = append(objects, planObject{Kind: planKindNamespace, Name: ns.namespace.Name, Fields: namespaceFields(&ns.namespace), createOnly: ns.createOnly})
+	}
+	for i := range p.policies {
+		objects = append(objects, planObject{Kind: planKindPolicy, Name: p.policies[i].Name, Fields: policyFields(&p.policies[i])})
+	}
+	for i := range p.authMethods {
+		objects = append(objects, planObject{Kind: planKindAuthMethod, Name: p.authMethods[i].authMethod.Name, Fields: authMethodFields(&p.authMethods[i].authMethod)})
+	}
+	for i := range p.roles {
+		objects = append(objects, planObject{Kind: planKindRole, Name: p.roles[i].Name, Fields: roleFields(&p.roles[i], nil)})
+	}
+	for i := range p.bindingRules {
+		objects = append(objects, planObject{Kind: planKindBindingRule, Name: p.bindingRules[i].bindingRule.Description, Fields: bindingRuleFields(&p.bindingRules[i].bindingRule)})
+	}
+	for i := range p.tokens {
+		token := &p.tokens[i]
+		objects = append(objects, planObject{Kind: planKindToken, Name: tokenName(token.AccessorID, token.Description), Fields: tokenFields(token.AccessorID, token.Description, token.Policies, token.Local), createOnly: token.AccessorID != anonymousTokenAccessorID})
+	}
+	return objects
+}
+
+// diff returns the changes between the ACL objects of the plan and the
+// ones in Consul. If client is nil, every object of the plan is added.
+// Objects that look like they were created by server-acl-init with the given
+// resource prefix but are no longer part of the plan are removed.
+func (p *aclPlan) diff(client *api.Client, resourcePrefix string) ([]planChange, error) {
+	current := make(map[string]map[string][]planField)
+	if client != nil {
+		var err error
+		current, err = p.current(client, resourcePrefix)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var changes []planChange
+	for _, desired := range p.objects() {
+		existing, ok := current[desired.Kind][desired.Name]
+		delete(current[desired.Kind], desired.Name)
+		switch {
+		case !ok:
+			changes = append(changes, planChange{Action: planActionAdd, Kind: desired.Kind, Name: desired.Name, Desired: desired.Fields})
+		case !desired.createOnly && !fieldsEqual(existing, desired.Fields):
+			changes = append(changes, planChange{Action: planActionChange, Kind: desired.Kind, Name: desired.Name, Current: existing, Desired: desired.Fields})
+		}
+	}
+
+	// The objects left are the ones that are no longer desired.
+	for _, kind := range []string{planKindNamespace, planKindPolicy, planKindAuthMethod, planKindRole, planKindBindingRule, planKindToken} {
+		var names []string
+		for name := range current[kind] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			changes = append(changes, planChange{Action: planActionRemove, Kind: kind, Name: name, Current: current[kind][name]})
+		}
+	}
+	return changes, nil
+}
+
+// current reads the ACL objects of the plan from Consul along with the ones
+// that are managed by server-acl-init but not part of the plan. They are
+// returned by kind and name.
+func (p *aclPlan) current(client *api.Client, resourcePrefix string) (map[string]map[string][]planField, error) {
+	current := map[string]map[string][]planField{
+		planKindNamespace:   {},
+		planKindPolicy:      {},
+		planKindAuthMethod:  {},
+		planKindRole:        {},
+		planKindBindingRule: {},
+		planKindToken:       {},
+	}
+	desired := make(map[string]map[string]bool)
+	for _, o := range p.objects() {
+		if desired[o.Kind] == nil {
+			desired[o.Kind] = make(map[string]bool)
+		}
+		desired[o.Kind][o.Name] = true
+	}
+
+	// Policies and roles are replicated across datacenters, so the ones of
+	// other datacenters are not removed.
+	datacenters, err := client.Catalog().Datacenters()
+	if err != nil {
+		return nil, fmt.Errorf("error listing datacenters: %s", err)
+	}
+	otherDatacenter := func(name string) bool {
+		for _, dc := range datacenters {
+			if dc != p.datacenter && strings.HasSuffix(name, "-"+dc) {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, ns := range p.namespaces {
+		existing, _, err := client.Namespaces().Read(ns.namespace.Name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error reading namespace %q: %s", ns.namespace.Name, err)
+		}
+		if existing != nil {
+			current[planKindNamespace][existing.Name] = namespaceFields(existing)
+		}
+	}
+
+	policies, _, err := client.ACL().PolicyList(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing policies: %s", err)
+	}
+	for _, entry := range policies {
+		managed := entry.Description == fmt.Sprintf("%s Token Policy", entry.Name) && !otherDatacenter(entry.Name)
+		if !desired[planKindPolicy][entry.Name] && !managed {
+			continue
+		}
+		policy, _, err := client.ACL().PolicyRead(entry.ID, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error reading policy %q: %s", entry.Name, err)
+		}
+		if policy != nil {
+			current[planKindPolicy][policy.Name] = policyFields(policy)
+		}
+	}
+
+	for _, am := range p.authMethods {
+		opts := am.opts
+		existing, _, err := client.ACL().AuthMethodRead(am.authMethod.Name, &opts)
+		if err != nil {
+			return nil, fmt.Errorf("error reading auth method %q: %s", am.authMethod.Name, err)
+		}
+		if existing != nil {
+			current[planKindAuthMethod][existing.Name] = authMethodFields(existing)
+		}
+	}
+	authMethods, _, err := client.ACL().AuthMethodList(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing auth methods: %s", err)
+	}
+	for _, entry := range authMethods {
+		if desired[planKindAuthMethod][entry.Name] || entry.Type != "kubernetes" || !strings.HasPrefix(entry.Name, resourcePrefix+"-") {
+			continue
+		}
+		existing, _, err := client.ACL().AuthMethodRead(entry.Name, nil)
+		if err != nil {
+			return nil, fmt.Errorf("error reading auth method %q: %s", entry.Name, err)
+		}
+		if existing != nil {
+			current[planKindAuthMethod][existing.Name] = authMethodFields(existing)
+		}
+	}
+
+	// Only the policies of the plan are compared since users may attach
+	// their own policies to the roles.
+	desiredRolePolicies := make(map[string][]*api.ACLRolePolicyLink)
+	for _, role := range p.roles {
+		desiredRolePolicies[role.Name] = role.Policies
+	}
+	roles, _, err := client.ACL().RoleList(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing roles: %s", err)
+	}
+	for _, role := range roles {
+		if policies, ok := desiredRolePolicies[role.Name]; ok {
+			current[planKindRole][role.Name] = roleFields(role, policies)
+		} else if strings.HasPrefix(role.Description, fmt.Sprintf("ACL Role for %s-", resourcePrefix)) && !otherDatacenter(role.Name) {
+			current[planKindRole][role.Name] = roleFields(role, nil)
+		}
+	}
+
+	listed := make(map[string]bool)
+	for _, br := range p.bindingRules {
+		opts := br.opts
+		key := fmt.Sprintf("%s/%s/%s", br.bindingRule.AuthMethod, opts.Datacenter, opts.Namespace)
+		if listed[key] {
+			continue
+		}
+		listed[key] = true
+		rules, _, err := client.ACL().BindingRuleList(br.bindingRule.AuthMethod, &opts)
+		if err != nil {
+			return nil, fmt.Errorf("error listing binding rules of auth method %q: %s", br.bindingRule.AuthMethod, err)
+		}
+		for _, rule := range rules {
+			managed := rule.Description == "Kubernetes binding rule" ||
+				strings.HasPrefix(rule.Description, fmt.Sprintf("Binding Rule for %s-", resourcePrefix))
+			if desired[planKindBindingRule][rule.Description] || managed {
+				current[planKindBindingRule][rule.Description] = bindingRuleFields(rule)
+			}
+		}
+	}
+
+	tokens, _, err := client.ACL().TokenList(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error listing tokens: %s", err)
+	}
+	for _, token := range tokens {
+		name := tokenName(token.AccessorID, token.Description)
+		// Global tokens of other datacenters are replicated, so only local
+		// tokens are removed outside of the primary datacenter.
+		managed := len(token.Policies) == 1 && token.Description == fmt.Sprintf("%s Token", token.Policies[0].Name) &&
+			(p.primary || token.Local)
+		if desired[planKindToken][name] || managed {
+			current[planKindToken][name] = tokenFields(token.AccessorID, token.Description, token.Policies, token.Local)
+		}
+	}
+	return current, nil
+}
+
+// formatPlan formats the changes as text or JSON.
+func formatPlan(changes []planChange, format string) (string, error) {
+	if format == planFormatJSON {
+		if changes == nil {
+			changes = []planChange{}
+		}
+		out, err := json.MarshalIndent(struct {
+			Changes []planChange `json:"changes"`
+		}{changes}, "", "  ")
+		return string(out), err
+	}
+
+	counts := make(map[string]int)
+	for _, change := range changes {
+		counts[change.Action]++
+	}
+	if len(changes) == 0 {
+		return "No changes. The ACLs in Consul match the configuration.", nil
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Plan: %d to add, %d to change, %d to remove.\n", counts[planActionAdd], counts[planActionChange], counts[planActionRemove])
+	if counts[planActionRemove] > 0 {
+		b.WriteString("Objects to remove are no longer managed by server-acl-init, which does not delete them.\n")
+	}
+	for _, change := range changes {
+		b.WriteString("\n")
+		switch change.Action {
+		case planActionAdd:
+			fmt.Fprintf(&b, "+ %s %q\n", change.Kind, change.Name)
+			writeFields(&b, change.Desired)
+		case planActionRemove:
+			fmt.Fprintf(&b, "- %s %q\n", change.Kind, change.Name)
+			writeFields(&b, change.Current)
+		case planActionChange:
+			fmt.Fprintf(&b, "~ %s %q\n", change.Kind, change.Name)
+			current := fieldsMap(change.Current)
+			for _, f := range change.Desired {
+				if current[f.Name] == f.Value {
+					continue
+				}
+				if strings.Contains(current[f.Name], "\n") || strings.Contains(f.Value, "\n") {
+					fmt.Fprintf(&b, "    %s:\n", f.Name)
+					writeIndented(&b, unifiedDiff(current[f.Name], f.Value), "      ")
+				} else {
+					fmt.Fprintf(&b, "    %s: %q => %q\n", f.Name, current[f.Name], f.Value)
+				}
+			}
+			desired := fieldsMap(change.Desired)
+			for _, f := range change.Current {
+				if _, ok := desired[f.Name]; !ok {
+					fmt.Fprintf(&b, "    %s: %q => %q\n", f.Name, f.Value, "")
+				}
+			}
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// writeFields writes the fields of an object. Multi-line values such as
+// policy rules are written as indented blocks.
+func writeFields(b *strings.Builder, fields []planField) {
+	for _, f := range fields {
+		if strings.Contains(f.Value, "\n") {
+			fmt.Fprintf(b, "    %s:\n", f.Name)
+			writeIndented(b, f.Value, "      ")
+		} else {
+			fmt.Fprintf(b, "    %s: %q\n", f.Name, f.Value)
+		}
+	}
+}
+
+func writeIndented(b *strings.Builder, s, indent string) {
+	for _, line := range strings.Split(strings.TrimSuffix(s, "\n"), "\n") {
+		b.WriteString(strings.TrimRight(indent+line, " "))
+		b.WriteString("\n")
+	}
+}
+
+// unifiedDiff returns the unified diff of the current and desired values
+// with all the lines as context so that the full value is shown.
+func unifiedDiff(current, desired string) string {
+	a := difflib.SplitLines(current)
+	b := difflib.SplitLines(desired)
+	context := len(a)
+	if len(b) > context {
+		context = len(b)
+	}
+	diff, _ := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        a,
+		B:        b,
+		FromFile: "current",
+		ToFile:   "desired",
+		Context:  context,
+	})
+	return diff
+}
+
+func namespaceFields(ns *api.Namespace) []planField {
+	var policyDefaults []string
+	if ns.ACLs != nil {
+		for _, link := range ns.ACLs.PolicyDefaults {
+			policyDefaults = append(policyDefaults, link.Name)
+		}
+	}
+	sort.Strings(policyDefaults)
+	return []planField{
+		{Name: "policy defaults", Value: strings.Join(policyDefaults, ", ")},
+	}
+}
+
+func policyFields(policy *api.ACLPolicy) []planField {
+	return []planField{
+		{Name: "description", Value: policy.Description},
+		{Name: "datacenters", Value: strings.Join(policy.Datacenters, ", ")},
+		{Name: "rules", Value: policy.Rules},
+	}
+}
+
+func authMethodFields(am *api.ACLAuthMethod) []planField {
+	fields := []planField{
+		{Name: "type", Value: am.Type},
+		{Name: "description", Value: am.Description},
+		{Name: "token locality", Value: am.TokenLocality},
+	}
+	var keys []string
+	for k := range am.Config {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		value := fmt.Sprintf("%v", am.Config[k])
+		if value == "" {
+			continue
+		}
+		if sensitiveAuthMethodConfigKeys[k] {
+			// Only a digest of credentials is shown so that changes can
+			// still be noticed.
+			sum := sha256.Sum256([]byte(value))
+			value = fmt.Sprintf("(sensitive, sha256 %x)", sum[:8])
+		}
+		fields = append(fields, planField{Name: "config." + k, Value: value})
+	}
+	return fields
+}
+
+// roleFields returns the fields of the role. If only is not nil, only the
+// policies of the role that are linked in only are included.
+func roleFields(role *api.ACLRole, only []*api.ACLRolePolicyLink) []planField {
+	included := make(map[string]bool)
+	for _, link := range only {
+		included[link.Name] = true
+	}
+	var policies []string
+	for _, link := range role.Policies {
+		if only == nil || included[link.Name] {
+			policies = append(policies, link.Name)
+		}
+	}
+	sort.Strings(policies)
+	return []planField{
+		{Name: "description", Value: role.Description},
+		{Name: "policies", Value: strings.Join(policies, ", ")},
+	}
+}
+
+func bindingRuleFields(rule *api.ACLBindingRule) []planField {
+	return []planField{
+		{Name: "auth method", Value: rule.AuthMethod},
+		{Name: "selector", Value: rule.Selector},
+		{Name: "bind type", Value: string(rule.BindType)},
+		{Name: "bind name", Value: rule.BindName},
+	}
+}
+
+// tokenFields returns the fields of a token. The description of the
+// anonymous token is left out since server-acl-init only sets its policies.
+func tokenFields(accessorID, description string, policyLinks []*api.ACLTokenPolicyLink, local bool) []planField {
+	var policies []string
+	for _, link := range policyLinks {
+		policies = append(policies, link.Name)
+	}
+	sort.Strings(policies)
+	var fields []planField
+	if accessorID != anonymousTokenAccessorID {
+		fields = append(fields, planField{Name: "description", Value: description})
+	}
+	return append(fields,
+		planField{Name: "policies", Value: strings.Join(policies, ", ")},
+		planField{Name: "local", Value: fmt.Sprintf("%t", local)},
+	)
+}
+
+// tokenName returns the name of a token in a plan. Tokens don't have names
+// so their description is used, except for the anonymous token.
+func tokenName(accessorID, description string) string {
+	if accessorID == anonymousTokenAccessorID {
+		return "anonymous"
+	}
+	return description
+}
+
+func fieldsEqual(a, b []planField) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func fieldsMap(fields []planField) map[string]string {
+	if fields == nil {
+		return nil
+	}
+	m := make(map[string]string, len(fields))
+	for _, f := range fields {
+		m[f.Name] = f.Value
+	}
+	return m
+}
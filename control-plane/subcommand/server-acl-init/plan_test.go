@@ -0,0 +1,268 @@
+package serveraclinit
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/consul-k8s/control-plane/subcommand/common"
+	"github.com/hashicorp/consul/api"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/require"
+)
+
+type testPlan struct {
+	Changes []struct {
+		Action  string            `json:"action"`
+		Kind    string            `json:"kind"`
+		Name    string            `json:"name"`
+		Current map[string]string `json:"current"`
+		Desired map[string]string `json:"desired"`
+	} `json:"changes"`
+}
+
+// actions returns the changes of the plan as "<action> <kind> <name>".
+func (p testPlan) actions() []string {
+	var actions []string
+	for _, c := range p.Changes {
+		actions = append(actions, c.Action+" "+c.Kind+" "+c.Name)
+	}
+	return actions
+}
+
+// Test that the plan is derived from the flags only when offline.
+func TestRun_DryRunOffline(t *testing.T) {
+	t.Parallel()
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	responseCode := cmd.Run([]string{
+		"-resource-prefix=" + resourcePrefix,
+		"-consul-api-timeout=5s",
+		"-dry-run",
+		"-dry-run-offline",
+		"-dry-run-format=json",
+		"-dry-run-datacenter=dc2",
+		"-client=false",
+		"-sync-catalog",
+		"-sync-consul-node-name=sync-node",
+	})
+	require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+
+	var plan testPlan
+	require.NoError(t, json.Unmarshal([]byte(ui.OutputWriter.String()), &plan))
+	require.Equal(t, []string{
+		"add policy sync-catalog-policy",
+		"add auth method " + resourcePrefix + "-k8s-component-auth-method",
+		"add role " + resourcePrefix + "-sync-catalog-acl-role",
+		"add binding rule Binding Rule for " + resourcePrefix + "-sync-catalog",
+	}, plan.actions())
+
+	policy := plan.Changes[0].Desired
+	require.Equal(t, "dc2", policy["datacenters"])
+	require.Contains(t, policy["rules"], `node "sync-node"`)
+
+	// The credentials of the auth method are left out.
+	require.Equal(t, map[string]string{
+		"type":           "kubernetes",
+		"description":    "Kubernetes Auth Method",
+		"token locality": "",
+		"config.Host":    defaultKubernetesHost,
+	}, plan.Changes[1].Desired)
+}
+
+// Test that the dry run reports the drift of the ACLs in Consul without
+// writing to Consul.
+func TestRun_DryRun(t *testing.T) {
+	t.Parallel()
+	bootToken := "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+	tokenFile := common.WriteTempFile(t, bootToken)
+	k8s, testSvr := completeBootstrappedSetup(t, bootToken)
+	defer testSvr.Stop()
+	setUpK8sServiceAccount(t, k8s, ns)
+
+	flags := []string{
+		"-timeout=1m",
+		"-resource-prefix=" + resourcePrefix,
+		"-k8s-namespace=" + ns,
+		"-bootstrap-token-file=" + tokenFile,
+		"-server-address", strings.Split(testSvr.HTTPAddr, ":")[0],
+		"-server-port", strings.Split(testSvr.HTTPAddr, ":")[1],
+		"-consul-api-timeout=5s",
+		"-sync-catalog",
+	}
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, clientset: k8s}
+	require.Equal(t, 0, cmd.Run(flags), ui.ErrorWriter.String())
+
+	consul, err := api.NewClient(&api.Config{Address: testSvr.HTTPAddr, Token: bootToken})
+	require.NoError(t, err)
+
+	// Drift from the configuration.
+	syncPolicy := policyExists(t, "sync-catalog-policy", consul)
+	_, _, err = consul.ACL().PolicyUpdate(&api.ACLPolicy{
+		ID:          syncPolicy.ID,
+		Name:        syncPolicy.Name,
+		Description: syncPolicy.Description,
+		Rules:       `service_prefix "" { policy = "read" }`,
+	}, nil)
+	require.NoError(t, err)
+	clientRole, _, err := consul.ACL().RoleReadByName(resourcePrefix+"-client-acl-role", nil)
+	require.NoError(t, err)
+	_, err = consul.ACL().RoleDelete(clientRole.ID, nil)
+	require.NoError(t, err)
+
+	ui = cli.NewMockUi()
+	cmd = Command{UI: ui, clientset: k8s}
+	responseCode := cmd.Run(append(flags, "-dry-run", "-dry-run-format=json", "-sync-catalog=false", "-snapshot-agent"))
+	require.Equal(t, 0, responseCode, ui.ErrorWriter.String())
+
+	var plan testPlan
+	require.NoError(t, json.Unmarshal([]byte(ui.OutputWriter.String()), &plan))
+	require.ElementsMatch(t, []string{
+		"add policy snapshot-agent-policy",
+		"add role " + resourcePrefix + "-client-acl-role",
+		"add role " + resourcePrefix + "-snapshot-agent-acl-role",
+		"add binding rule Binding Rule for " + resourcePrefix + "-snapshot-agent",
+		"remove policy sync-catalog-policy",
+		"remove role " + resourcePrefix + "-sync-catalog-acl-role",
+		"remove binding rule Binding Rule for " + resourcePrefix + "-sync-catalog",
+	}, plan.actions())
+
+	// Nothing was written.
+	role, _, err := consul.ACL().RoleReadByName(resourcePrefix+"-client-acl-role", nil)
+	require.NoError(t, err)
+	require.Nil(t, role)
+	policies, _, err := consul.ACL().PolicyList(nil)
+	require.NoError(t, err)
+	for _, p := range policies {
+		require.NotEqual(t, "snapshot-agent-policy", p.Name)
+	}
+
+	// The drift of the rules is reported when the policy is still desired.
+	ui = cli.NewMockUi()
+	cmd = Command{UI: ui, clientset: k8s}
+	require.Equal(t, 0, cmd.Run(append(flags, "-dry-run")), ui.ErrorWriter.String())
+	out := ui.OutputWriter.String()
+	require.Contains(t, out, `~ policy "sync-catalog-policy"`)
+	require.Contains(t, out, `-service_prefix "" { policy = "read" }`)
+	require.Contains(t, out, `+ role "`+resourcePrefix+`-client-acl-role"`)
+}
+
+func TestFormatPlan(t *testing.T) {
+	t.Parallel()
+	changes := []planChange{
+		{
+			Action: planActionAdd,
+			Kind:   planKindPolicy,
+			Name:   "sync-catalog-policy",
+			Desired: policyFields(&api.ACLPolicy{
+				Description: "sync-catalog-policy Token Policy",
+				Rules:       "node \"k8s-sync\" {\n  policy = \"write\"\n}\n",
+			}),
+		},
+		{
+			Action:  planActionChange,
+			Kind:    planKindRole,
+			Name:    "consul-client-acl-role",
+			Current: roleFields(&api.ACLRole{Description: "ACL Role for consul-client"}, nil),
+			Desired: roleFields(&api.ACLRole{Description: "ACL Role for consul-client", Policies: []*api.ACLRolePolicyLink{{Name: "client-policy"}}}, nil),
+		},
+		{
+			Action:  planActionChange,
+			Kind:    planKindPolicy,
+			Name:    "client-policy",
+			Current: policyFields(&api.ACLPolicy{Description: "client-policy Token Policy", Rules: "node_prefix \"\" {\n  policy = \"read\"\n}"}),
+			Desired: policyFields(&api.ACLPolicy{Description: "client-policy Token Policy", Rules: "node_prefix \"\" {\n  policy = \"write\"\n}"}),
+		},
+		{
+			Action:  planActionRemove,
+			Kind:    planKindToken,
+			Name:    "enterprise-license-token Token",
+			Current: tokenFields("", "enterprise-license-token Token", []*api.ACLTokenPolicyLink{{Name: "enterprise-license-token"}}, true),
+		},
+	}
+
+	out, err := formatPlan(changes, planFormatText)
+	require.NoError(t, err)
+	require.Equal(t, `Plan: 1 to add, 2 to change, 1 to remove.
+Objects to remove are no longer managed by server-acl-init, which does not delete them.
+
++ policy "sync-catalog-policy"
+    description: "sync-catalog-policy Token Policy"
+    datacenters: ""
+    rules:
+      node "k8s-sync" {
+        policy = "write"
+      }
+
+~ role "consul-client-acl-role"
+    policies: "" => "client-policy"
+
+~ policy "client-policy"
+    rules:
+      --- current
+      +++ desired
+      @@ -1,3 +1,3 @@
+       node_prefix "" {
+      -  policy = "read"
+      +  policy = "write"
+       }
+
+- token "enterprise-license-token Token"
+    description: "enterprise-license-token Token"
+    policies: "enterprise-license-token"
+    local: "true"`, out)
+
+	out, err = formatPlan(nil, planFormatText)
+	require.NoError(t, err)
+	require.Equal(t, "No changes. The ACLs in Consul match the configuration.", out)
+
+	out, err = formatPlan(nil, planFormatJSON)
+	require.NoError(t, err)
+	require.JSONEq(t, `{"changes": []}`, out)
+}
+
+func TestPlanDiff_Offline(t *testing.T) {
+	t.Parallel()
+	plan := &aclPlan{
+		policies: []api.ACLPolicy{{Name: "client-policy", Rules: "rules"}},
+		tokens: []api.ACLToken{
+			{AccessorID: anonymousTokenAccessorID, Policies: []*api.ACLTokenPolicyLink{{Name: "anonymous-token-policy"}}},
+		},
+	}
+	changes, err := plan.diff(nil, resourcePrefix)
+	require.NoError(t, err)
+	require.Equal(t, []planChange{
+		{Action: planActionAdd, Kind: planKindPolicy, Name: "client-policy", Desired: policyFields(&plan.policies[0])},
+		{Action: planActionAdd, Kind: planKindToken, Name: "anonymous", Desired: []planField{
+			{Name: "policies", Value: "anonymous-token-policy"},
+			{Name: "local", Value: "false"},
+		}},
+	}, changes)
+}
+
+func TestAuthMethodFields_Sensitive(t *testing.T) {
+	t.Parallel()
+	fields := authMethodFields(&api.ACLAuthMethod{
+		Type: "kubernetes",
+		Config: map[string]interface{}{
+			"Host":              "https://kubernetes.default.svc",
+			"CACert":            "ca-cert",
+			"ServiceAccountJWT": "jwt-token",
+			"MapNamespaces":     true,
+		},
+	})
+	values := fieldsMap(fields)
+	require.Equal(t, "https://kubernetes.default.svc", values["config.Host"])
+	require.Equal(t, "true", values["config.MapNamespaces"])
+	require.True(t, strings.HasPrefix(values["config.ServiceAccountJWT"], "(sensitive, sha256 "))
+	require.NotContains(t, values["config.ServiceAccountJWT"], "jwt-token")
+	require.NotContains(t, values["config.CACert"], "ca-cert")
+
+	// A change of the credentials is noticed.
+	other := fieldsMap(authMethodFields(&api.ACLAuthMethod{
+		Type:   "kubernetes",
+		Config: map[string]interface{}{"ServiceAccountJWT": "other-jwt-token"},
+	}))
+	require.NotEqual(t, values["config.ServiceAccountJWT"], other["config.ServiceAccountJWT"])
+}
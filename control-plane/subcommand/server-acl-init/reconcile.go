@@ -0,0 +1,228 @@
+package serveraclinit
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/consul"
+	"github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// Kinds of ACL objects that are counted when their drift is repaired.
+	repairKindPolicy      = "policy"
+	repairKindRole        = "role"
+	repairKindBindingRule = "binding_rule"
+	repairKindAuthMethod  = "auth_method"
+	repairKindToken       = "token"
+
+	// Reasons of the Kubernetes Events recorded while reconciling.
+	eventReasonDriftRepaired   = "ACLDriftRepaired"
+	eventReasonReconcileFailed = "ACLReconcileFailed"
+)
+
+// reconcileMetrics are the metrics of the reconcile mode.
+type reconcileMetrics struct {
+	runs        *prometheus.CounterVec
+	repairs     *prometheus.CounterVec
+	lastSuccess prometheus.Gauge
+}
+
+func newReconcileMetrics(reg prometheus.Registerer) *reconcileMetrics {
+	m := &reconcileMetrics{
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "consul_acl_reconcile_runs_total",
+			Help: "The number of ACL reconcile passes by result.",
+		}, []string{"result"}),
+		repairs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "consul_acl_reconcile_repairs_total",
+			Help: "The number of ACL objects that were missing or had drifted from the desired configuration and were repaired.",
+		}, []string{"kind"}),
+		lastSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "consul_acl_reconcile_last_success_timestamp_seconds",
+			Help: "The Unix time of the last ACL reconcile pass without errors.",
+		}),
+	}
+	reg.MustRegister(m.runs, m.repairs, m.lastSuccess)
+	return m
+}
+
+// reconcile periodically re-applies the ACL configuration until the process
+// is interrupted. The bootstrap token is read again before every pass so that
+// the passes follow its rotation, but it is never recreated: if it is deleted
+// from Consul each pass fails until it's restored.
+func (c *Command) reconcile(consulClient *api.Client, clientConfig *api.Config, aclReplicationToken, partitionToken string) int {
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, syscall.SIGINT, syscall.SIGTERM)
+	}
+
+	reg := prometheus.NewRegistry()
+	c.metrics = newReconcileMetrics(reg)
+	c.metrics.lastSuccess.SetToCurrentTime()
+	if c.events == nil {
+		c.events = c.eventRecorder()
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: c.flagListen, Handler: mux}
+	go func() {
+		c.log.Info("Listening", "address", c.flagListen)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			c.log.Error("Error listening", "err", err)
+		}
+	}()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-c.sigCh:
+			c.log.Info(fmt.Sprintf("%s received, shutting down", sig))
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	c.reconciling = true
+	c.log.Info("Reconciling ACLs", "interval", c.flagReconcileInterval)
+	ticker := time.NewTicker(c.flagReconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			consulClient = c.reconcileOnce(ctx, consulClient, clientConfig, aclReplicationToken, partitionToken)
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
+
+// reconcileOnce runs a single reconcile pass and returns the Consul client
+// to use for the next one. It times out after the reconcile interval so that
+// passes don't pile up.
+func (c *Command) reconcileOnce(parent context.Context, consulClient *api.Client, clientConfig *api.Config, aclReplicationToken, partitionToken string) *api.Client {
+	var cancel context.CancelFunc
+	c.ctx, cancel = context.WithTimeout(parent, c.flagReconcileInterval)
+	defer cancel()
+	c.repairs = nil
+
+	client, err := c.reconcileClient(consulClient, clientConfig)
+	if err == nil {
+		consulClient = client
+		err = c.configureACLs(consulClient, clientConfig, aclReplicationToken, partitionToken)
+	}
+	if parent.Err() != nil {
+		// The pass was interrupted by the shutdown.
+		return consulClient
+	}
+
+	if len(c.repairs) > 0 {
+		c.recordEvent(corev1.EventTypeNormal, eventReasonDriftRepaired,
+			fmt.Sprintf("Repaired %d ACL objects: %s", len(c.repairs), strings.Join(c.repairs, ", ")))
+	}
+	if err != nil {
+		c.log.Error("Error reconciling ACLs", "err", err)
+		c.metrics.runs.WithLabelValues("error").Inc()
+		c.recordEvent(corev1.EventTypeWarning, eventReasonReconcileFailed, fmt.Sprintf("Error reconciling ACLs: %s", err))
+		return consulClient
+	}
+	c.log.Info("Reconciled ACLs", "repaired", len(c.repairs))
+	c.metrics.runs.WithLabelValues("success").Inc()
+	c.metrics.lastSuccess.SetToCurrentTime()
+	return consulClient
+}
+
+// reconcileClient returns a Consul client using the current bootstrap token.
+// The token is read again from the file or Secret it was initially read from,
+// so that the passes keep working after the token is rotated. The token of
+// clientConfig is updated if it changed.
+func (c *Command) reconcileClient(consulClient *api.Client, clientConfig *api.Config) (*api.Client, error) {
+	var token string
+	var err error
+	switch {
+	case c.flagACLReplicationTokenFile != "" && !c.flagCreateACLReplicationToken:
+		// The replication token is used instead of a bootstrap token.
+		return consulClient, nil
+	case c.flagBootstrapTokenFile != "":
+		token, err = loadTokenFromFile(c.flagBootstrapTokenFile)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		secretName := c.withPrefix("bootstrap-acl-token")
+		token, err = c.getBootstrapToken(secretName)
+		if err != nil {
+			return nil, fmt.Errorf("reading bootstrap token from Secret %q: %w", secretName, err)
+		}
+		if token == "" {
+			return nil, fmt.Errorf("bootstrap token Secret %q not found", secretName)
+		}
+	}
+
+	if token == clientConfig.Token {
+		return consulClient, nil
+	}
+	config := *clientConfig
+	config.Token = token
+	client, err := consul.NewClient(&config, c.flagConsulAPITimeout)
+	if err != nil {
+		return nil, err
+	}
+	c.log.Info("Bootstrap token changed, reconciling with the new token")
+	clientConfig.Token = token
+	return client, nil
+}
+
+// recordRepair records that an ACL object was missing or had drifted and was
+// repaired. Repairs are only recorded by the reconcile passes after the
+// initial configuration.
+func (c *Command) recordRepair(kind, name string) {
+	if !c.reconciling {
+		return
+	}
+	c.log.Info("Repairing ACL drift", "kind", kind, "name", name)
+	c.repairs = append(c.repairs, fmt.Sprintf("%s %s", strings.ReplaceAll(kind, "_", " "), name))
+	if c.metrics != nil {
+		c.metrics.repairs.WithLabelValues(kind).Inc()
+	}
+}
+
+// eventRecorder returns a recorder of Kubernetes Events on the pod of the
+// process or nil if the pod name isn't set.
+func (c *Command) eventRecorder() record.EventRecorder {
+	if c.flagPodName == "" {
+		return nil
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: c.clientset.CoreV1().Events(c.flagK8sNamespace)})
+	return broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "server-acl-init"})
+}
+
+// recordEvent records a Kubernetes Event on the pod of the process if
+// Events are recorded.
+func (c *Command) recordEvent(eventType, reason, message string) {
+	if c.events == nil {
+		return
+	}
+	c.events.Event(&corev1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  c.flagK8sNamespace,
+		Name:       c.flagPodName,
+	}, eventType, reason, message)
+}
@@ -0,0 +1,222 @@
+package serveraclinit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/subcommand/common"
+	"github.com/hashicorp/consul/api"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+// Test that the reconcile mode recreates deleted ACL objects, records an
+// Event about it and never replaces the bootstrap token.
+func TestRun_Reconcile(t *testing.T) {
+	t.Parallel()
+	k8s, testSvr := completeSetup(t)
+	defer testSvr.Stop()
+	setUpK8sServiceAccount(t, k8s, ns)
+
+	events := record.NewFakeRecorder(100)
+	sigCh := make(chan os.Signal, 1)
+	ui := cli.NewMockUi()
+	cmd := Command{
+		UI:        ui,
+		clientset: k8s,
+		events:    events,
+		sigCh:     sigCh,
+	}
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-resource-prefix=" + resourcePrefix,
+			"-k8s-namespace=" + ns,
+			"-server-address", strings.Split(testSvr.HTTPAddr, ":")[0],
+			"-server-port", strings.Split(testSvr.HTTPAddr, ":")[1],
+			"-sync-catalog",
+			"-consul-api-timeout", "5s",
+			"-reconcile",
+			"-reconcile-interval", "500ms",
+			"-listen", "127.0.0.1:0",
+			"-pod-name", "server-acl-init",
+		})
+	}()
+
+	// Wait for the initial configuration.
+	var bootToken string
+	retry.Run(t, func(r *retry.R) {
+		secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), resourcePrefix+"-bootstrap-acl-token", metav1.GetOptions{})
+		require.NoError(r, err)
+		bootToken = string(secret.Data["token"])
+		require.NotEmpty(r, bootToken)
+	})
+	consul, err := api.NewClient(&api.Config{
+		Address: testSvr.HTTPAddr,
+		Token:   bootToken,
+	})
+	require.NoError(t, err)
+
+	var role *api.ACLRole
+	retry.Run(t, func(r *retry.R) {
+		role, _, err = consul.ACL().RoleReadByName(resourcePrefix+"-sync-catalog-acl-role", nil)
+		require.NoError(r, err)
+		require.NotNil(r, role)
+	})
+
+	// Delete the role of catalog sync and wait for it to be recreated.
+	_, err = consul.ACL().RoleDelete(role.ID, nil)
+	require.NoError(t, err)
+	retry.Run(t, func(r *retry.R) {
+		recreated, _, err := consul.ACL().RoleReadByName(resourcePrefix+"-sync-catalog-acl-role", nil)
+		require.NoError(r, err)
+		require.NotNil(r, recreated)
+		require.NotEqual(r, role.ID, recreated.ID)
+	})
+
+	select {
+	case event := <-events.Events:
+		require.Contains(t, event, eventReasonDriftRepaired)
+		require.Contains(t, event, resourcePrefix+"-sync-catalog-acl-role")
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a drift repaired event")
+	}
+
+	// The bootstrap token is unchanged.
+	secret, err := k8s.CoreV1().Secrets(ns).Get(context.Background(), resourcePrefix+"-bootstrap-acl-token", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, bootToken, string(secret.Data["token"]))
+
+	sigCh <- syscall.SIGTERM
+	require.Equal(t, 0, <-exitCh, ui.ErrorWriter.String())
+}
+
+// Test that the Consul client of the reconcile passes follows the rotation of
+// the bootstrap token.
+func TestReconcileClient(t *testing.T) {
+	t.Parallel()
+
+	t.Run("secret", func(t *testing.T) {
+		secret := &v1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: resourcePrefix + "-bootstrap-acl-token", Namespace: ns},
+			Data:       map[string][]byte{common.ACLTokenSecretKey: []byte("old-token")},
+		}
+		k8s := fake.NewSimpleClientset(secret)
+		cmd := Command{
+			log:                hclog.NewNullLogger(),
+			clientset:          k8s,
+			ctx:                context.Background(),
+			flagResourcePrefix: resourcePrefix,
+			flagK8sNamespace:   ns,
+		}
+		clientConfig := &api.Config{Address: "127.0.0.1:8500", Token: "old-token"}
+		consulClient, err := api.NewClient(clientConfig)
+		require.NoError(t, err)
+
+		client, err := cmd.reconcileClient(consulClient, clientConfig)
+		require.NoError(t, err)
+		require.Same(t, consulClient, client)
+
+		secret.Data[common.ACLTokenSecretKey] = []byte("new-token")
+		_, err = k8s.CoreV1().Secrets(ns).Update(context.Background(), secret, metav1.UpdateOptions{})
+		require.NoError(t, err)
+		client, err = cmd.reconcileClient(consulClient, clientConfig)
+		require.NoError(t, err)
+		require.NotSame(t, consulClient, client)
+		require.Equal(t, "new-token", clientConfig.Token)
+
+		require.NoError(t, k8s.CoreV1().Secrets(ns).Delete(context.Background(), secret.Name, metav1.DeleteOptions{}))
+		_, err = cmd.reconcileClient(client, clientConfig)
+		require.EqualError(t, err, fmt.Sprintf("bootstrap token Secret %q not found", secret.Name))
+	})
+
+	t.Run("file", func(t *testing.T) {
+		tokenFile := common.WriteTempFile(t, "old-token")
+		cmd := Command{
+			log:                    hclog.NewNullLogger(),
+			flagBootstrapTokenFile: tokenFile,
+		}
+		clientConfig := &api.Config{Address: "127.0.0.1:8500", Token: "old-token"}
+		consulClient, err := api.NewClient(clientConfig)
+		require.NoError(t, err)
+
+		client, err := cmd.reconcileClient(consulClient, clientConfig)
+		require.NoError(t, err)
+		require.Same(t, consulClient, client)
+
+		require.NoError(t, os.WriteFile(tokenFile, []byte("new-token\n"), 0600))
+		client, err = cmd.reconcileClient(consulClient, clientConfig)
+		require.NoError(t, err)
+		require.NotSame(t, consulClient, client)
+		require.Equal(t, "new-token", clientConfig.Token)
+	})
+}
+
+func TestRecordRepair(t *testing.T) {
+	t.Parallel()
+	reg := prometheus.NewRegistry()
+	cmd := Command{
+		log:     hclog.NewNullLogger(),
+		metrics: newReconcileMetrics(reg),
+	}
+
+	// Repairs are not recorded by the initial configuration.
+	cmd.recordRepair(repairKindPolicy, "sync-catalog-policy")
+	require.Empty(t, cmd.repairs)
+
+	cmd.reconciling = true
+	cmd.recordRepair(repairKindPolicy, "sync-catalog-policy")
+	cmd.recordRepair(repairKindBindingRule, "Binding Rule for sync-catalog")
+	require.Equal(t, []string{"policy sync-catalog-policy", "binding rule Binding Rule for sync-catalog"}, cmd.repairs)
+
+	expected := `
+# HELP consul_acl_reconcile_repairs_total The number of ACL objects that were missing or had drifted from the desired configuration and were repaired.
+# TYPE consul_acl_reconcile_repairs_total counter
+consul_acl_reconcile_repairs_total{kind="binding_rule"} 1
+consul_acl_reconcile_repairs_total{kind="policy"} 1
+`
+	require.NoError(t, promtestutil.GatherAndCompare(reg, strings.NewReader(expected), "consul_acl_reconcile_repairs_total"))
+}
+
+func TestRecordEvent(t *testing.T) {
+	t.Parallel()
+
+	// No Events are recorded without a recorder.
+	cmd := Command{}
+	cmd.recordEvent("Normal", eventReasonDriftRepaired, "Repaired 1 ACL objects: role foo")
+
+	events := record.NewFakeRecorder(1)
+	cmd = Command{events: events, flagPodName: "server-acl-init", flagK8sNamespace: ns}
+	cmd.recordEvent("Warning", eventReasonReconcileFailed, "Error reconciling ACLs: timeout")
+	require.Equal(t, "Warning ACLReconcileFailed Error reconciling ACLs: timeout", <-events.Events)
+}
+
+func TestMissingRolePolicies(t *testing.T) {
+	t.Parallel()
+	existing := &api.ACLRole{
+		Policies: []*api.ACLRolePolicyLink{
+			{ID: "1", Name: "gateway-policy"},
+			{ID: "2", Name: "user-policy"},
+		},
+	}
+
+	require.Empty(t, missingRolePolicies(existing, &api.ACLRole{
+		Policies: []*api.ACLRolePolicyLink{{Name: "gateway-policy"}},
+	}))
+	require.Equal(t, []*api.ACLRolePolicyLink{{Name: "other-policy"}}, missingRolePolicies(existing, &api.ACLRole{
+		Policies: []*api.ACLRolePolicyLink{{Name: "gateway-policy"}, {Name: "other-policy"}},
+	}))
+}
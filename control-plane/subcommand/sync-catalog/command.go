@@ -2,17 +2,23 @@ package synccatalog
 
 import (
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"path"
 	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	mapset "github.com/deckarep/golang-set"
+	"github.com/hashicorp/consul-k8s/control-plane/api/v1alpha1"
+	catalogmetrics "github.com/hashicorp/consul-k8s/control-plane/catalog/metrics"
 	catalogtoconsul "github.com/hashicorp/consul-k8s/control-plane/catalog/to-consul"
 	catalogtok8s "github.com/hashicorp/consul-k8s/control-plane/catalog/to-k8s"
 	"github.com/hashicorp/consul-k8s/control-plane/helper/controller"
@@ -22,9 +28,13 @@ import (
 	"github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
 	"github.com/mitchellh/cli"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 // Command is the command for syncing the K8S and Consul service
@@ -41,19 +51,43 @@ type Command struct {
 	flagConsulDomain          string
 	flagConsulK8STag          string
 	flagConsulNodeName        string
+	flagConsulNodePerK8SNode  bool
+	flagSyncTopologyMeta      bool
 	flagK8SDefault            bool
 	flagK8SServicePrefix      string
 	flagConsulServicePrefix   string
 	flagK8SSourceNamespace    string
 	flagK8SWriteNamespace     string
+	flagK8SServiceType        string
+	flagToK8SAllowTags        []string
+	flagToK8SAllowMetaKeys    []string
+	flagToK8SAllowServices    []string
+	flagToK8SDenyServices     []string
+	flagToK8SRenameRules      []string
+	flagToK8SFilter           string
+	flagToK8SFilterConfigMap  string
+	flagToK8SFilterKey        string
 	flagConsulWritePeriod     time.Duration
 	flagSyncClusterIPServices bool
+	flagSyncReadinessChecks   bool
+	flagEnableSyncedServices  bool
+	flagEnableIngress         bool
 	flagSyncLBEndpoints       bool
 	flagNodePortSyncType      string
+	flagServicePortSyncType   string
+	flagSyncEndpointSlices    bool
 	flagAddK8SNamespaceSuffix bool
 	flagLogLevel              string
 	flagLogJSON               bool
 
+	// Flags to support leader election
+	flagEnableLeaderElection        bool          // Only sync from the replica that holds the Lease
+	flagLeaderElectionNamespace     string        // Namespace of the Lease
+	flagLeaderElectionID            string        // Name of the Lease
+	flagLeaderElectionLeaseDuration time.Duration // Time standbys wait before taking over an unrenewed Lease
+	flagLeaderElectionRenewDeadline time.Duration // Time the leader retries renewing the Lease before giving up
+	flagLeaderElectionRetryPeriod   time.Duration // Time between attempts to acquire or renew the Lease
+
 	// Flags to support namespaces
 	flagEnableNamespaces           bool     // Use namespacing on all components
 	flagConsulDestinationNamespace string   // Consul namespace to register everything if not mirroring
@@ -62,9 +96,20 @@ type Command struct {
 	flagEnableK8SNSMirroring       bool     // Enables mirroring of k8s namespaces into Consul
 	flagK8SNSMirroringPrefix       string   // Prefix added to Consul namespaces created when mirroring
 	flagCrossNamespaceACLPolicy    string   // The name of the ACL policy to add to every created namespace if ACLs are enabled
+	flagConsulSourceNamespaces     []string // Consul namespaces to sync services to k8s from
+	flagConsulSourcePartitions     []string // Consul admin partitions to sync services to k8s from
+	flagEnableConsulNSMirroring    bool     // Enables mirroring of Consul namespaces into k8s
+	flagConsulNSMirroringPrefix    string   // Prefix added to k8s namespaces when mirroring Consul namespaces
+	flagK8SNamespaceMapping        []string // Mappings of Consul namespaces to k8s namespaces
 
 	consulClient *api.Client
 	clientset    kubernetes.Interface
+	ctrlClient   client.WithWatch
+
+	// leader is the leader election of the process and metrics is the
+	// registry of its metrics. Both are set up by Run.
+	leader  *leaderElection
+	metrics *prometheus.Registry
 
 	once   sync.Once
 	sigCh  chan os.Signal
@@ -74,7 +119,7 @@ type Command struct {
 
 func (c *Command) init() {
 	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
-	c.flags.StringVar(&c.flagListen, "listen", ":8080", "Address to bind listener to.")
+	c.flags.StringVar(&c.flagListen, "listen", ":8080", "Address to bind the listener for the health check and metrics to.")
 	c.flags.BoolVar(&c.flagToConsul, "to-consul", true,
 		"If true, K8S services will be synced to Consul.")
 	c.flags.BoolVar(&c.flagToK8S, "to-k8s", true,
@@ -95,6 +140,37 @@ func (c *Command) init() {
 	c.flags.StringVar(&c.flagK8SWriteNamespace, "k8s-write-namespace", metav1.NamespaceDefault,
 		"The Kubernetes namespace to write to for services from Consul. "+
 			"If this is not set then it will default to the default namespace.")
+	c.flags.StringVar(&c.flagK8SServiceType, "k8s-service-type", string(catalogtok8s.ExternalName),
+		"The type of Kubernetes services to create for services from Consul. Valid options are "+
+			"ExternalName, ClusterIP and Headless. ExternalName services point at the Consul DNS entry "+
+			"of the service. ClusterIP and Headless services have EndpointSlices with the addresses "+
+			"of the healthy Consul service instances.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagToK8SAllowTags), "to-k8s-allow-tag",
+		"Only sync Consul services with this tag to Kubernetes. May be specified multiple times. "+
+			"Services with any of the tags or any of the meta keys of -to-k8s-allow-meta-key are synced.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagToK8SAllowMetaKeys), "to-k8s-allow-meta-key",
+		"Only sync Consul services with this service meta key to Kubernetes. May be specified multiple times. "+
+			"Services with any of the meta keys or any of the tags of -to-k8s-allow-tag are synced.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagToK8SAllowServices), "to-k8s-allow-service",
+		"Glob pattern of Consul service names to sync to Kubernetes, e.g. 'web-*'. May be specified "+
+			"multiple times. If not set, all services are allowed.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagToK8SDenyServices), "to-k8s-deny-service",
+		"Glob pattern of Consul service names not to sync to Kubernetes. May be specified multiple times. "+
+			"Takes precedence over -to-k8s-allow-service.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagToK8SRenameRules), "to-k8s-rename",
+		"Rule to rename Consul services synced to Kubernetes in the form <regexp>=<replacement>, e.g. "+
+			"'^legacy-(.*)$=$1'. May be specified multiple times. The first matching rule is applied "+
+			"before -k8s-service-prefix is prepended.")
+	c.flags.StringVar(&c.flagToK8SFilter, "to-k8s-filter", "",
+		"Filter expression in Consul's filtering syntax that Consul services must match to be synced "+
+			"to Kubernetes, e.g. 'ServiceMeta.team == \"a\"'.")
+	c.flags.StringVar(&c.flagToK8SFilterConfigMap, "to-k8s-filter-configmap", "",
+		"Name of a ConfigMap in the namespace of -k8s-write-namespace that holds a filter expression in "+
+			"Consul's filtering syntax that Consul services must match to be synced to Kubernetes. "+
+			"Changes to the ConfigMap are applied without a restart. The previous filter is kept if Consul rejects "+
+			"the filter or the ConfigMap is deleted.")
+	c.flags.StringVar(&c.flagToK8SFilterKey, "to-k8s-filter-configmap-key", "filter",
+		"Key of the filter expression in the ConfigMap of -to-k8s-filter-configmap.")
 	c.flags.StringVar(&c.flagConsulDomain, "consul-domain", "consul",
 		"The domain for Consul services to use when writing services to "+
 			"Kubernetes. Defaults to consul.")
@@ -103,6 +179,14 @@ func (c *Command) init() {
 	c.flags.StringVar(&c.flagConsulNodeName, "consul-node-name", "k8s-sync",
 		"The Consul node name to register for catalog sync. Defaults to k8s-sync. To be discoverable "+
 			"via DNS, the name should only contain alpha-numerics and dashes.")
+	c.flags.BoolVar(&c.flagConsulNodePerK8SNode, "consul-node-per-k8s-node", false,
+		"If true, service instances of Kubernetes endpoints are registered on one Consul node per "+
+			"Kubernetes node named <consul-node-name>-<Kubernetes node name> instead of on the "+
+			"-consul-node-name node. The Consul nodes carry the address, zone and region of their "+
+			"Kubernetes node.")
+	c.flags.BoolVar(&c.flagSyncTopologyMeta, "sync-topology-meta", false,
+		"If true, the zone and region labels of the Kubernetes node of each endpoint are added "+
+			"to the meta of its service instance in Consul.")
 	c.flags.DurationVar(&c.flagConsulWritePeriod, "consul-write-interval", 30*time.Second,
 		"The interval to perform syncing operations creating Consul services, formatted "+
 			"as a time.Duration. All changes are merged and write calls are only made "+
@@ -110,12 +194,29 @@ func (c *Command) init() {
 	c.flags.BoolVar(&c.flagSyncClusterIPServices, "sync-clusterip-services", true,
 		"If true, all valid ClusterIP services in K8S are synced by default. If false, "+
 			"ClusterIP services are not synced to Consul.")
+	c.flags.BoolVar(&c.flagSyncReadinessChecks, "sync-readiness-checks", false,
+		"If true, not ready endpoints are also synced to Consul and each service instance "+
+			"is registered with a health check that reflects the readiness of its Kubernetes endpoint.")
+	c.flags.BoolVar(&c.flagEnableSyncedServices, "enable-synced-services", false,
+		"If true, SyncedService resources configure the sync of the services they reference "+
+			"and report the status of their sync. Requires the SyncedService CRD to be installed.")
+	c.flags.BoolVar(&c.flagEnableIngress, "enable-ingress", false,
+		"If true, the rules of networking.k8s.io/v1 Ingresses are synced to Consul as services "+
+			"pointing at the load balancer addresses of the Ingresses.")
 	c.flags.BoolVar(&c.flagSyncLBEndpoints, "sync-lb-services-endpoints", false,
 		"If true, LoadBalancer service endpoints instead of ingress addresses will be synced to Consul. If false, "+
 			"LoadBalancer endpoints are not synced to Consul.")
 	c.flags.StringVar(&c.flagNodePortSyncType, "node-port-sync-type", "ExternalOnly",
 		"Defines the type of sync for NodePort services. Valid options are ExternalOnly, "+
 			"InternalOnly and ExternalFirst.")
+	c.flags.StringVar(&c.flagServicePortSyncType, "service-port-sync-type", string(catalogtoconsul.ServicePortSingle),
+		"Defines how the ports of services are synced to Consul. Valid options are Single, which registers "+
+			"a single port, PerPort, which registers a service named <name>-<port name> per port of "+
+			"multi-port services, and Meta, which sets the port-<port name> meta of each instance to the "+
+			"port it listens on. Can be overridden per service with the consul.hashicorp.com/service-port-sync annotation.")
+	c.flags.BoolVar(&c.flagSyncEndpointSlices, "sync-endpoint-slices", false,
+		"If true, the EndpointSlices of services are watched instead of their Endpoints to sync "+
+			"their instances to Consul.")
 	c.flags.BoolVar(&c.flagAddK8SNamespaceSuffix, "add-k8s-namespace-suffix", false,
 		"If true, Kubernetes namespace will be appended to service names synced to Consul separated by a dash. "+
 			"If false, no suffix will be appended to the service names in Consul. "+
@@ -143,6 +244,40 @@ func (c *Command) init() {
 		"[Enterprise Only] Name of the ACL policy to attach to all created Consul namespaces to allow service "+
 			"discovery across Consul namespaces. Only necessary if ACLs are enabled.")
 
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagConsulSourceNamespaces), "consul-source-namespace",
+		"[Enterprise Only] Consul namespace to sync services to Kubernetes from. May be specified multiple "+
+			"times. '*' syncs services from all namespaces. Defaults to the namespace of the Consul client.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagConsulSourcePartitions), "consul-source-partition",
+		"[Enterprise Only] Consul admin partition to sync services to Kubernetes from. May be specified multiple "+
+			"times. '*' syncs services from all partitions. Defaults to the partition of the Consul client.")
+	c.flags.BoolVar(&c.flagEnableConsulNSMirroring, "enable-consul-namespace-mirroring", false,
+		"[Enterprise Only] Enables writing services from Consul to the Kubernetes namespace with the same name "+
+			"as their Consul namespace. The Kubernetes namespaces must exist.")
+	c.flags.StringVar(&c.flagConsulNSMirroringPrefix, "consul-namespace-mirroring-prefix", "",
+		"[Enterprise Only] Prefix that will be added to the Kubernetes namespaces that Consul namespaces "+
+			"are mirrored to if mirroring is enabled.")
+	c.flags.Var((*flags.AppendSliceValue)(&c.flagK8SNamespaceMapping), "k8s-namespace-mapping",
+		"[Enterprise Only] Mapping of a Consul namespace to the Kubernetes namespace to write its services to, "+
+			"in the form <consul namespace>=<k8s namespace> or <partition>/<consul namespace>=<k8s namespace>. "+
+			"May be specified multiple times. Takes precedence over mirroring. Services of namespaces that are "+
+			"not mapped or mirrored are written to -k8s-write-namespace.")
+
+	c.flags.BoolVar(&c.flagEnableLeaderElection, "enable-leader-election", false,
+		"If true, replicas of the sync process elect a leader with a Kubernetes Lease. Only the leader "+
+			"syncs, the other replicas keep their state up to date and take over if the leader goes away.")
+	c.flags.StringVar(&c.flagLeaderElectionNamespace, "leader-election-namespace", "",
+		"The Kubernetes namespace of the leader election Lease. Defaults to -k8s-write-namespace.")
+	c.flags.StringVar(&c.flagLeaderElectionID, "leader-election-id", "consul-sync-catalog",
+		"The name of the leader election Lease. Replicas with the same Lease elect a single leader.")
+	c.flags.DurationVar(&c.flagLeaderElectionLeaseDuration, "leader-election-lease-duration", 15*time.Second,
+		"The time standby replicas wait after the last renewal of the Lease before taking over.")
+	c.flags.DurationVar(&c.flagLeaderElectionRenewDeadline, "leader-election-renew-deadline", 10*time.Second,
+		"The time the leader retries renewing the Lease before giving up leadership. "+
+			"Must be less than -leader-election-lease-duration.")
+	c.flags.DurationVar(&c.flagLeaderElectionRetryPeriod, "leader-election-retry-period", 2*time.Second,
+		"The time between attempts to acquire or renew the Lease. "+
+			"Must be less than -leader-election-renew-deadline.")
+
 	c.http = &flags.HTTPFlags{}
 	c.k8s = &flags.K8SFlags{}
 	flags.Merge(c.flags, c.http.Flags())
@@ -176,17 +311,32 @@ func (c *Command) Run(args []string) int {
 	}
 
 	// Create the k8s clientset
-	if c.clientset == nil {
+	if c.clientset == nil || (c.flagEnableSyncedServices && c.ctrlClient == nil) {
 		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
 			return 1
 		}
 
-		c.clientset, err = kubernetes.NewForConfig(config)
-		if err != nil {
-			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
-			return 1
+		if c.clientset == nil {
+			c.clientset, err = kubernetes.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
+		}
+
+		if c.flagEnableSyncedServices && c.ctrlClient == nil {
+			scheme := runtime.NewScheme()
+			if err := v1alpha1.AddToScheme(scheme); err != nil {
+				c.UI.Error(fmt.Sprintf("Error adding SyncedService types to scheme: %s", err))
+				return 1
+			}
+			c.ctrlClient, err = client.NewWithWatch(config, client.Options{Scheme: scheme})
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
 		}
 	}
 
@@ -221,9 +371,49 @@ func (c *Command) Run(args []string) int {
 	c.logger.Info("K8s namespace syncing configuration", "k8s namespaces allowed to be synced", allowSet,
 		"k8s namespaces denied from syncing", denySet)
 
+	// Set up leader election. Everything that writes to Consul or Kubernetes
+	// only starts once this process is the leader, everything else starts
+	// right away so that a standby can take over quickly.
+	c.metrics = prometheus.NewRegistry()
+	leaderGauge := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "consul_sync_catalog_leader",
+		Help: "Whether this process is the leader that syncs the catalogs (1) or a standby (0).",
+	})
+	c.metrics.MustRegister(leaderGauge)
+	syncMetrics := catalogmetrics.New(c.metrics)
+	identity, err := os.Hostname()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error getting hostname for leader election identity: %s", err))
+		return 1
+	}
+	leaderNamespace := c.flagLeaderElectionNamespace
+	if leaderNamespace == "" {
+		leaderNamespace = c.flagK8SWriteNamespace
+	}
+	c.leader = &leaderElection{
+		Log:           c.logger.Named("leader-election"),
+		Enabled:       c.flagEnableLeaderElection,
+		Client:        c.clientset,
+		Namespace:     leaderNamespace,
+		Name:          c.flagLeaderElectionID,
+		Identity:      identity,
+		LeaseDuration: c.flagLeaderElectionLeaseDuration,
+		RenewDeadline: c.flagLeaderElectionRenewDeadline,
+		RetryPeriod:   c.flagLeaderElectionRetryPeriod,
+		LeaderGauge:   leaderGauge,
+	}
+
 	// Create the context we'll use to cancel everything
 	ctx, cancelF := context.WithCancel(context.Background())
 
+	leaderDoneCh := make(chan struct{})
+	go func() {
+		defer close(leaderDoneCh)
+		if err := c.leader.Run(ctx); err != nil {
+			c.UI.Error(fmt.Sprintf("Error running leader election: %s", err))
+		}
+	}()
+
 	// Start the K8S-to-Consul syncer
 	var toConsulCh chan struct{}
 	if c.flagToConsul {
@@ -251,38 +441,92 @@ func (c *Command) Run(args []string) int {
 			ServicePollPeriod:        c.flagConsulWritePeriod * 2,
 			ConsulK8STag:             c.flagConsulK8STag,
 			ConsulNodeName:           c.flagConsulNodeName,
+			ConsulNodePerK8SNode:     c.flagConsulNodePerK8SNode,
 			ConsulNodeServicesClient: svcsClient,
+			SyncOnStart:              true,
+			Metrics:                  syncMetrics,
 		}
-		go syncer.Run(ctx)
-
 		// Build the controller and start it
+		serviceResource := &catalogtoconsul.ServiceResource{
+			Log:                        c.logger.Named("to-consul/source"),
+			Client:                     c.clientset,
+			Syncer:                     syncer,
+			Ctx:                        ctx,
+			AllowK8sNamespacesSet:      allowSet,
+			DenyK8sNamespacesSet:       denySet,
+			ExplicitEnable:             !c.flagK8SDefault,
+			ClusterIPSync:              c.flagSyncClusterIPServices,
+			LoadBalancerEndpointsSync:  c.flagSyncLBEndpoints,
+			SyncReadinessChecks:        c.flagSyncReadinessChecks,
+			NodePortSync:               catalogtoconsul.NodePortSyncType(c.flagNodePortSyncType),
+			ServicePortSync:            catalogtoconsul.ServicePortSyncType(c.flagServicePortSyncType),
+			EndpointSlicesSync:         c.flagSyncEndpointSlices,
+			ConsulK8STag:               c.flagConsulK8STag,
+			ConsulServicePrefix:        c.flagConsulServicePrefix,
+			AddK8SNamespaceSuffix:      c.flagAddK8SNamespaceSuffix,
+			EnableNamespaces:           c.flagEnableNamespaces,
+			ConsulDestinationNamespace: c.flagConsulDestinationNamespace,
+			EnableK8SNSMirroring:       c.flagEnableK8SNSMirroring,
+			K8SNSMirroringPrefix:       c.flagK8SNSMirroringPrefix,
+			ConsulNodeName:             c.flagConsulNodeName,
+			ConsulNodePerK8SNode:       c.flagConsulNodePerK8SNode,
+			SyncTopologyMeta:           c.flagSyncTopologyMeta,
+			Metrics:                    syncMetrics,
+		}
 		ctl := &controller.Controller{
-			Log: c.logger.Named("to-consul/controller"),
-			Resource: &catalogtoconsul.ServiceResource{
-				Log:                        c.logger.Named("to-consul/source"),
-				Client:                     c.clientset,
-				Syncer:                     syncer,
-				Ctx:                        ctx,
-				AllowK8sNamespacesSet:      allowSet,
-				DenyK8sNamespacesSet:       denySet,
-				ExplicitEnable:             !c.flagK8SDefault,
-				ClusterIPSync:              c.flagSyncClusterIPServices,
-				LoadBalancerEndpointsSync:  c.flagSyncLBEndpoints,
-				NodePortSync:               catalogtoconsul.NodePortSyncType(c.flagNodePortSyncType),
-				ConsulK8STag:               c.flagConsulK8STag,
-				ConsulServicePrefix:        c.flagConsulServicePrefix,
-				AddK8SNamespaceSuffix:      c.flagAddK8SNamespaceSuffix,
-				EnableNamespaces:           c.flagEnableNamespaces,
-				ConsulDestinationNamespace: c.flagConsulDestinationNamespace,
-				EnableK8SNSMirroring:       c.flagEnableK8SNSMirroring,
-				K8SNSMirroringPrefix:       c.flagK8SNSMirroringPrefix,
-				ConsulNodeName:             c.flagConsulNodeName,
-			},
+			Log:      c.logger.Named("to-consul/controller"),
+			Resource: serviceResource,
+		}
+
+		var syncedServiceCtl *controller.Controller
+		if c.flagEnableSyncedServices {
+			syncedServiceResource := &catalogtoconsul.SyncedServiceResource{
+				Log:     c.logger.Named("to-consul/synced-services"),
+				Client:  c.ctrlClient,
+				Service: serviceResource,
+				Ctx:     ctx,
+			}
+			syncer.SyncResultHandler = syncedServiceResource.HandleSyncResult
+			syncedServiceCtl = &controller.Controller{
+				Log: c.logger.Named("to-consul/synced-services-controller"),
+				Resource: &leaderResource{
+					Resource: syncedServiceResource,
+					LeaderCh: c.leader.LeaderCh(),
+				},
+			}
 		}
 
+		var ingressCtl *controller.Controller
+		if c.flagEnableIngress {
+			ingressCtl = &controller.Controller{
+				Log: c.logger.Named("to-consul/ingress-controller"),
+				Resource: &catalogtoconsul.IngressResource{
+					Log:     c.logger.Named("to-consul/ingress"),
+					Client:  c.clientset,
+					Service: serviceResource,
+					Ctx:     ctx,
+				},
+			}
+		}
+		// The syncer keeps receiving the registrations of the services
+		// while on standby so it can reconcile right away once leader.
+		go func() {
+			select {
+			case <-c.leader.LeaderCh():
+				syncer.Run(ctx)
+			case <-ctx.Done():
+			}
+		}()
+
 		toConsulCh = make(chan struct{})
 		go func() {
 			defer close(toConsulCh)
+			if syncedServiceCtl != nil {
+				go syncedServiceCtl.Run(ctx.Done())
+			}
+			if ingressCtl != nil {
+				go ingressCtl.Run(ctx.Done())
+			}
 			ctl.Run(ctx.Done())
 		}()
 	}
@@ -290,27 +534,79 @@ func (c *Command) Run(args []string) int {
 	// Start Consul-to-K8S sync
 	var toK8SCh chan struct{}
 	if c.flagToK8S {
+		// The mapping and rename rules were validated with the flags.
+		k8sNamespaceMapping, _ := parseK8SNamespaceMapping(c.flagK8SNamespaceMapping)
+		renameRules, _ := parseRenameRules(c.flagToK8SRenameRules)
 		sink := &catalogtok8s.K8SSink{
-			Client:    c.clientset,
-			Namespace: c.flagK8SWriteNamespace,
-			Log:       c.logger.Named("to-k8s/sink"),
-			Ctx:       ctx,
+			Client:       c.clientset,
+			Namespace:    c.flagK8SWriteNamespace,
+			Log:          c.logger.Named("to-k8s/sink"),
+			Ctx:          ctx,
+			ServiceType:  catalogtok8s.ServiceSyncType(c.flagK8SServiceType),
+			ConsulClient: c.consulClient,
+
+			// Only map namespaces if configured so that services in other
+			// Kube namespaces are left alone otherwise.
+			EnableNamespaces:        c.flagEnableNamespaces && (c.flagEnableConsulNSMirroring || len(c.flagK8SNamespaceMapping) > 0),
+			K8SNamespaceMapping:     k8sNamespaceMapping,
+			EnableConsulNSMirroring: c.flagEnableConsulNSMirroring,
+			ConsulNSMirroringPrefix: c.flagConsulNSMirroringPrefix,
+			Metrics:                 syncMetrics,
 		}
 
 		source := &catalogtok8s.Source{
-			Client:       c.consulClient,
-			Domain:       c.flagConsulDomain,
-			Sink:         sink,
-			Prefix:       c.flagK8SServicePrefix,
-			Log:          c.logger.Named("to-k8s/source"),
-			ConsulK8STag: c.flagConsulK8STag,
+			Client:           c.consulClient,
+			Domain:           c.flagConsulDomain,
+			Sink:             sink,
+			Prefix:           c.flagK8SServicePrefix,
+			Log:              c.logger.Named("to-k8s/source"),
+			ConsulK8STag:     c.flagConsulK8STag,
+			EnableNamespaces: c.flagEnableNamespaces,
+			ConsulNamespaces: c.flagConsulSourceNamespaces,
+			ConsulPartitions: c.flagConsulSourcePartitions,
+			AllowTags:        c.flagToK8SAllowTags,
+			AllowMetaKeys:    c.flagToK8SAllowMetaKeys,
+			AllowServices:    c.flagToK8SAllowServices,
+			DenyServices:     c.flagToK8SDenyServices,
+			Filter:           c.flagToK8SFilter,
+			RenameRules:      renameRules,
+			Metrics:          syncMetrics,
+		}
+
+		// Load the filter from the ConfigMap before starting the source so
+		// that filtered out services are never synced.
+		if c.flagToK8SFilterConfigMap != "" {
+			filterConfigMap := &catalogtok8s.FilterConfigMap{
+				Client:    c.clientset,
+				Namespace: c.flagK8SWriteNamespace,
+				Name:      c.flagToK8SFilterConfigMap,
+				Key:       c.flagToK8SFilterKey,
+				Source:    source,
+				Log:       c.logger.Named("to-k8s/filter"),
+				Ctx:       ctx,
+			}
+			if err := filterConfigMap.Load(); err != nil {
+				c.UI.Error(fmt.Sprintf("Error reading filter ConfigMap %q: %s", c.flagToK8SFilterConfigMap, err))
+				cancelF()
+				return 1
+			}
+
+			filterCtl := &controller.Controller{
+				Log:      c.logger.Named("to-k8s/filter-controller"),
+				Resource: filterConfigMap,
+			}
+			go filterCtl.Run(ctx.Done())
 		}
 		go source.Run(ctx)
 
-		// Build the controller and start it
+		// Build the controller and start it. The sink only writes to
+		// Kubernetes once this process is the leader.
 		ctl := &controller.Controller{
-			Log:      c.logger.Named("to-k8s/controller"),
-			Resource: sink,
+			Log: c.logger.Named("to-k8s/controller"),
+			Resource: &leaderResource{
+				Resource: sink,
+				LeaderCh: c.leader.LeaderCh(),
+			},
 		}
 
 		toK8SCh = make(chan struct{})
@@ -324,6 +620,7 @@ func (c *Command) Run(args []string) int {
 	go func() {
 		mux := http.NewServeMux()
 		mux.HandleFunc("/health/ready", c.handleReady)
+		mux.Handle("/metrics", promhttp.HandlerFor(c.metrics, promhttp.HandlerOpts{}))
 		var handler http.Handler = mux
 
 		c.UI.Info(fmt.Sprintf("Listening on %q...", c.flagListen))
@@ -339,6 +636,7 @@ func (c *Command) Run(args []string) int {
 		if toK8SCh != nil {
 			<-toK8SCh
 		}
+		<-leaderDoneCh
 		return 1
 
 	// Unexpected exit
@@ -347,6 +645,30 @@ func (c *Command) Run(args []string) int {
 		if toConsulCh != nil {
 			<-toConsulCh
 		}
+		<-leaderDoneCh
+		return 1
+
+	// Leadership lost or leader election failed. Exit so that this process
+	// restarts as a standby since another replica may be syncing now.
+	case <-c.leader.LostCh():
+		c.logger.Error("leadership lost, shutting down")
+		cancelF()
+		if toConsulCh != nil {
+			<-toConsulCh
+		}
+		if toK8SCh != nil {
+			<-toK8SCh
+		}
+		<-leaderDoneCh
+		return 1
+	case <-leaderDoneCh:
+		cancelF()
+		if toConsulCh != nil {
+			<-toConsulCh
+		}
+		if toK8SCh != nil {
+			<-toK8SCh
+		}
 		return 1
 
 	// Interrupted/terminated, gracefully exit
@@ -359,11 +681,16 @@ func (c *Command) Run(args []string) int {
 		if toK8SCh != nil {
 			<-toK8SCh
 		}
+		<-leaderDoneCh
 		return 0
 	}
 }
 
 func (c *Command) handleReady(rw http.ResponseWriter, req *http.Request) {
+	// Standbys are ready as well so that they can be rolled out next to the
+	// leader. The header tells them apart.
+	rw.Header().Set(leaderHeader, strconv.FormatBool(c.leader.IsLeader()))
+
 	// The main readiness check is whether sync can talk to
 	// the consul cluster, in this case querying for the leader
 	_, err := c.consulClient.Status().Leader()
@@ -411,9 +738,53 @@ func (c *Command) validateFlags() error {
 		)
 	}
 
+	if _, err := parseK8SNamespaceMapping(c.flagK8SNamespaceMapping); err != nil {
+		return err
+	}
+
+	for _, pattern := range append(c.flagToK8SAllowServices, c.flagToK8SDenyServices...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("service pattern %q is invalid: %s", pattern, err)
+		}
+	}
+
+	if _, err := parseRenameRules(c.flagToK8SRenameRules); err != nil {
+		return err
+	}
+
+	if !catalogtoconsul.ValidServicePortSyncType(catalogtoconsul.ServicePortSyncType(c.flagServicePortSyncType)) {
+		return fmt.Errorf("-service-port-sync-type=%s is invalid: valid options are Single, PerPort and Meta",
+			c.flagServicePortSyncType)
+	}
+
+	if c.flagEnableLeaderElection {
+		if c.flagLeaderElectionID == "" {
+			return errors.New("-leader-election-id must be set when leader election is enabled")
+		}
+		if c.flagLeaderElectionRenewDeadline >= c.flagLeaderElectionLeaseDuration {
+			return fmt.Errorf("-leader-election-renew-deadline=%s is invalid: must be less than -leader-election-lease-duration=%s",
+				c.flagLeaderElectionRenewDeadline, c.flagLeaderElectionLeaseDuration)
+		}
+		if c.flagLeaderElectionRetryPeriod <= 0 || c.flagLeaderElectionRetryPeriod >= c.flagLeaderElectionRenewDeadline {
+			return fmt.Errorf("-leader-election-retry-period=%s is invalid: must be greater than 0 and less than -leader-election-renew-deadline=%s",
+				c.flagLeaderElectionRetryPeriod, c.flagLeaderElectionRenewDeadline)
+		}
+	}
+
+	switch catalogtok8s.ServiceSyncType(c.flagK8SServiceType) {
+	case catalogtok8s.ExternalName, catalogtok8s.ClusterIP, catalogtok8s.Headless:
+	default:
+		return fmt.Errorf("-k8s-service-type=%s is invalid: valid options are %s, %s and %s",
+			c.flagK8SServiceType, catalogtok8s.ExternalName, catalogtok8s.ClusterIP, catalogtok8s.Headless)
+	}
+
 	return nil
 }
 
+// leaderHeader is the header of /health/ready responses that tells whether
+// the process is the leader.
+const leaderHeader = "X-Consul-Sync-Leader"
+
 const synopsis = "Sync Kubernetes services and Consul services."
 const help = `
 Usage: consul-k8s-control-plane sync-catalog [options]
@@ -424,3 +795,30 @@ Usage: consul-k8s-control-plane sync-catalog [options]
   K8S services.
 
 `
+
+// parseK8SNamespaceMapping parses the values of -k8s-namespace-mapping.
+func parseK8SNamespaceMapping(values []string) (map[string]string, error) {
+	mapping := make(map[string]string, len(values))
+	for _, v := range values {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("-k8s-namespace-mapping=%s is invalid: must be in the form "+
+				"<consul namespace>=<k8s namespace> or <partition>/<consul namespace>=<k8s namespace>", v)
+		}
+		mapping[parts[0]] = parts[1]
+	}
+	return mapping, nil
+}
+
+// parseRenameRules parses the values of -to-k8s-rename.
+func parseRenameRules(values []string) ([]catalogtok8s.RenameRule, error) {
+	var rules []catalogtok8s.RenameRule
+	for _, v := range values {
+		rule, err := catalogtok8s.ParseRenameRule(v)
+		if err != nil {
+			return nil, fmt.Errorf("-to-k8s-rename=%s is invalid: %w", v, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
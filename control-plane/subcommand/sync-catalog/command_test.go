@@ -36,6 +36,35 @@ func TestRun_FlagValidation(t *testing.T) {
 			ExpErr: "-consul-node-name=5r9OPGfSRXUdGzNjBdAwmhCBrzHDNYs4XjZVR4wp7lSLIzqwS0ta51nBLIN0TMPV-too-long is invalid: node name will not be discoverable " +
 				"via DNS due to it being too long. Valid lengths are between 1 and 63 bytes",
 		},
+		{
+			Flags: []string{"-k8s-namespace-mapping=ns1"},
+			ExpErr: "-k8s-namespace-mapping=ns1 is invalid: must be in the form " +
+				"<consul namespace>=<k8s namespace> or <partition>/<consul namespace>=<k8s namespace>",
+		},
+		{
+			Flags:  []string{"-to-k8s-deny-service=[web"},
+			ExpErr: `service pattern "[web" is invalid: syntax error in pattern`,
+		},
+		{
+			Flags:  []string{"-to-k8s-rename=web"},
+			ExpErr: `-to-k8s-rename=web is invalid: rename rule "web" must be in the form <pattern>=<replacement>`,
+		},
+		{
+			Flags:  []string{"-k8s-service-type=NodePort"},
+			ExpErr: "-k8s-service-type=NodePort is invalid: valid options are ExternalName, ClusterIP and Headless",
+		},
+		{
+			Flags:  []string{"-service-port-sync-type=Foo"},
+			ExpErr: "-service-port-sync-type=Foo is invalid: valid options are Single, PerPort and Meta",
+		},
+		{
+			Flags:  []string{"-enable-leader-election", "-leader-election-renew-deadline=20s"},
+			ExpErr: "-leader-election-renew-deadline=20s is invalid: must be less than -leader-election-lease-duration=15s",
+		},
+		{
+			Flags:  []string{"-enable-leader-election", "-leader-election-retry-period=10s"},
+			ExpErr: "-leader-election-retry-period=10s is invalid: must be greater than 0 and less than -leader-election-renew-deadline=10s",
+		},
 	}
 
 	for _, c := range cases {
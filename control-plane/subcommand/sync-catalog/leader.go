@@ -0,0 +1,193 @@
+package synccatalog
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/helper/controller"
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// leaderElection coordinates the replicas of the sync process with a
+// Kubernetes Lease. Only the leader writes to Consul and Kubernetes. The
+// other replicas are standbys that keep their informers and sync state
+// warm so that they can take over as soon as they acquire the Lease.
+//
+// If leader election is disabled, the process is the leader from the start.
+type leaderElection struct {
+	Log hclog.Logger
+
+	// Enabled set to false makes the process the leader without acquiring
+	// a Lease.
+	Enabled bool
+
+	Client    kubernetes.Interface
+	Namespace string
+	Name      string
+	Identity  string
+
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+
+	// LeaderGauge is set to 1 while the process is the leader and 0
+	// otherwise if set.
+	LeaderGauge prometheus.Gauge
+
+	once     sync.Once
+	lock     sync.Mutex
+	leader   bool
+	leaderCh chan struct{}
+	lostCh   chan struct{}
+}
+
+// Run runs the leader election until ctx is cancelled or leadership is
+// lost. The Lease is released when ctx is cancelled so that a standby can
+// take over right away.
+func (l *leaderElection) Run(ctx context.Context) error {
+	l.once.Do(l.init)
+
+	if !l.Enabled {
+		l.setLeader(true)
+		<-ctx.Done()
+		return nil
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta: metav1.ObjectMeta{
+				Namespace: l.Namespace,
+				Name:      l.Name,
+			},
+			Client: l.Client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{
+				Identity: l.Identity,
+			},
+		},
+		LeaseDuration:   l.LeaseDuration,
+		RenewDeadline:   l.RenewDeadline,
+		RetryPeriod:     l.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            l.Name,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				l.Log.Info("acquired leadership", "lease", l.Namespace+"/"+l.Name, "identity", l.Identity)
+				l.setLeader(true)
+			},
+			OnStoppedLeading: func() {
+				// Leadership is released on purpose when ctx is cancelled.
+				if ctx.Err() != nil {
+					l.setLeader(false)
+					return
+				}
+				l.lose()
+			},
+			OnNewLeader: func(identity string) {
+				if identity != l.Identity {
+					l.Log.Info("new leader elected", "lease", l.Namespace+"/"+l.Name, "leader", identity)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	l.Log.Info("waiting for leadership", "lease", l.Namespace+"/"+l.Name, "identity", l.Identity)
+	elector.Run(ctx)
+	return nil
+}
+
+// IsLeader returns true while the process is the leader.
+func (l *leaderElection) IsLeader() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.leader
+}
+
+// LeaderCh returns a channel that is closed once the process becomes the
+// leader.
+func (l *leaderElection) LeaderCh() <-chan struct{} {
+	l.once.Do(l.init)
+	return l.leaderCh
+}
+
+// LostCh returns a channel that is closed if the process loses the
+// leadership it held. The process must exit then since another replica
+// may have started writing.
+func (l *leaderElection) LostCh() <-chan struct{} {
+	l.once.Do(l.init)
+	return l.lostCh
+}
+
+func (l *leaderElection) init() {
+	l.leaderCh = make(chan struct{})
+	l.lostCh = make(chan struct{})
+	if l.LeaderGauge != nil {
+		l.LeaderGauge.Set(0)
+	}
+}
+
+func (l *leaderElection) setLeader(leader bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if leader && !l.leader {
+		close(l.leaderCh)
+	}
+	l.leader = leader
+
+	if l.LeaderGauge != nil {
+		if leader {
+			l.LeaderGauge.Set(1)
+		} else {
+			l.LeaderGauge.Set(0)
+		}
+	}
+}
+
+// lose gives up leadership that was lost and closes the channel returned
+// by LostCh.
+func (l *leaderElection) lose() {
+	l.lock.Lock()
+	leader := l.leader
+	l.lock.Unlock()
+	if !leader {
+		return
+	}
+
+	l.Log.Error("lost leadership", "lease", l.Namespace+"/"+l.Name, "identity", l.Identity)
+	l.setLeader(false)
+	close(l.lostCh)
+}
+
+// leaderResource wraps a controller.Resource so that its informer and
+// callbacks run right away but its background process, which does the
+// writes, only runs once the process is the leader.
+type leaderResource struct {
+	controller.Resource
+
+	// LeaderCh is closed once the process is the leader.
+	LeaderCh <-chan struct{}
+}
+
+// Run implements the controller.Backgrounder interface.
+func (r *leaderResource) Run(stopCh <-chan struct{}) {
+	bg, ok := r.Resource.(controller.Backgrounder)
+	if !ok {
+		return
+	}
+
+	select {
+	case <-r.LeaderCh:
+	case <-stopCh:
+		return
+	}
+	bg.Run(stopCh)
+}
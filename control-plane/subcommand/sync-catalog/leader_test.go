@@ -0,0 +1,169 @@
+package synccatalog
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/helper/controller"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/go-hclog"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Test that the process is the leader right away without leader election.
+func TestLeaderElection_disabled(t *testing.T) {
+	t.Parallel()
+
+	gauge := prometheus.NewGauge(prometheus.GaugeOpts{Name: "leader"})
+	l := &leaderElection{
+		Log:         hclog.NewNullLogger(),
+		LeaderGauge: gauge,
+	}
+	stop := runLeaderElection(t, l)
+	defer stop()
+
+	select {
+	case <-l.LeaderCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("did not become the leader")
+	}
+	require.True(t, l.IsLeader())
+	require.Equal(t, float64(1), promtestutil.ToFloat64(gauge))
+}
+
+// Test that only one replica is the leader and that a standby takes over
+// once the leader releases the Lease.
+func TestLeaderElection_failover(t *testing.T) {
+	t.Parallel()
+
+	client := fake.NewSimpleClientset()
+	gaugeA := prometheus.NewGauge(prometheus.GaugeOpts{Name: "leader"})
+	gaugeB := prometheus.NewGauge(prometheus.GaugeOpts{Name: "leader"})
+	a := testLeaderElection(client, "a", gaugeA)
+	b := testLeaderElection(client, "b", gaugeB)
+
+	stopA := runLeaderElection(t, a)
+	select {
+	case <-a.LeaderCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("a did not become the leader")
+	}
+
+	stopB := runLeaderElection(t, b)
+	defer stopB()
+
+	// b stays on standby while a renews the Lease.
+	time.Sleep(1 * time.Second)
+	require.True(t, a.IsLeader())
+	require.False(t, b.IsLeader())
+	require.Equal(t, float64(1), promtestutil.ToFloat64(gaugeA))
+	require.Equal(t, float64(0), promtestutil.ToFloat64(gaugeB))
+
+	// Stopping a releases the Lease and b takes over.
+	stopA()
+	select {
+	case <-b.LeaderCh():
+	case <-time.After(5 * time.Second):
+		t.Fatal("b did not take over")
+	}
+	require.False(t, a.IsLeader())
+	require.Equal(t, float64(0), promtestutil.ToFloat64(gaugeA))
+	require.Equal(t, float64(1), promtestutil.ToFloat64(gaugeB))
+
+	// a stopped on purpose so it didn't lose leadership.
+	select {
+	case <-a.LostCh():
+		t.Fatal("a reported lost leadership on shutdown")
+	default:
+	}
+}
+
+// Test that the background process of a leaderResource only runs once the
+// process is the leader.
+func TestLeaderResource(t *testing.T) {
+	t.Parallel()
+
+	leaderCh := make(chan struct{})
+	bg := &testBackgroundResource{
+		Resource: controller.NewResource(
+			cache.NewSharedIndexInformer(nil, nil, 0, nil),
+			func(string, interface{}) error { return nil },
+			func(string, interface{}) error { return nil },
+		),
+		runCh: make(chan struct{}),
+	}
+
+	r := &leaderResource{Resource: bg, LeaderCh: leaderCh}
+	stopCh := make(chan struct{})
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		r.Run(stopCh)
+	}()
+
+	select {
+	case <-bg.runCh:
+		t.Fatal("background process ran before leadership")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(leaderCh)
+	retry.Run(t, func(r *retry.R) {
+		select {
+		case <-bg.runCh:
+		default:
+			r.Fatal("background process did not run")
+		}
+	})
+
+	close(stopCh)
+	<-doneCh
+}
+
+func testLeaderElection(client kubernetes.Interface, identity string, gauge prometheus.Gauge) *leaderElection {
+	return &leaderElection{
+		Log:           hclog.NewNullLogger(),
+		Enabled:       true,
+		Client:        client,
+		Namespace:     "default",
+		Name:          "consul-sync-catalog",
+		Identity:      identity,
+		LeaseDuration: 1 * time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   100 * time.Millisecond,
+		LeaderGauge:   gauge,
+	}
+}
+
+// runLeaderElection runs the leader election and returns a function that
+// stops it.
+func runLeaderElection(t *testing.T, l *leaderElection) func() {
+	ctx, cancelF := context.WithCancel(context.Background())
+	doneCh := make(chan struct{})
+	go func() {
+		defer close(doneCh)
+		require.NoError(t, l.Run(ctx))
+	}()
+	return func() {
+		cancelF()
+		<-doneCh
+	}
+}
+
+// testBackgroundResource is a controller.Resource with a background process
+// that closes runCh when it runs.
+type testBackgroundResource struct {
+	controller.Resource
+	runCh chan struct{}
+}
+
+func (r *testBackgroundResource) Run(stopCh <-chan struct{}) {
+	close(r.runCh)
+	<-stopCh
+}
@@ -49,12 +49,21 @@ type Command struct {
 	flagK8sNamespace string
 	flagNamePrefix   string
 
+	// flags for the rotation mode.
+	flagRotate            bool
+	flagRotateInterval    time.Duration
+	flagRenewBefore       time.Duration
+	flagServerStatefulSet string
+	flagListen            string
+
 	// log
 	log          hclog.Logger
 	flagLogLevel string
 	flagLogJSON  bool
 
-	ctx context.Context
+	ctx     context.Context
+	sigCh   chan os.Signal
+	metrics *rotateMetrics
 
 	once sync.Once
 	help string
@@ -91,6 +100,10 @@ func (c *Command) Run(args []string) int {
 		}
 	}
 
+	if c.flagRotate {
+		return c.rotate()
+	}
+
 	var cancel context.CancelFunc
 	c.ctx, cancel = context.WithCancel(context.Background())
 	defer cancel()
@@ -170,32 +183,61 @@ func (c *Command) Run(args []string) int {
 		c.log.Info("using existing CA")
 	}
 
-	var hosts []string
-	var name string
-	var caBytes, keyBytes []byte
+	// The CA secrets aren't read again because
+	// we created them above in case they are nil.
+	ca, pk, err = c.loadCA()
+	if err != nil {
+		c.log.Error(err.Error())
+		return 1
+	}
 
+	serverCert, serverKey, err := c.generateServerCert(ca, pk)
+	if err != nil {
+		c.log.Error(err.Error())
+		return 1
+	}
+	if err := c.saveServerCert(serverCert, serverKey, nil); err != nil {
+		c.log.Error(err.Error())
+		return 1
+	}
+
+	return 0
+}
+
+// loadCA returns the CA certificate and private key from the provided files
+// or else from the Kubernetes secrets.
+func (c *Command) loadCA() (string, string, error) {
 	if c.flagCaFile != "" && c.flagKeyFile != "" {
-		c.log.Info("reading CA certificate from provided file")
-		caBytes, err = os.ReadFile(c.flagCaFile)
+		caBytes, err := os.ReadFile(c.flagCaFile)
 		if err != nil {
-			c.log.Error("error reading provided CA file", "err", err)
-			return 1
+			return "", "", fmt.Errorf("error reading provided CA file: %s", err)
 		}
-		c.log.Info("reading CA private key from provided file")
-		keyBytes, err = os.ReadFile(c.flagKeyFile)
+		keyBytes, err := os.ReadFile(c.flagKeyFile)
 		if err != nil {
-			c.log.Error("error reading provided private key file", "err", err)
-			return 1
+			return "", "", fmt.Errorf("error reading provided private key file: %s", err)
+		}
+		return string(caBytes), string(keyBytes), nil
+	}
+
+	if c.caCertSecret == nil || c.caKeySecret == nil {
+		var err error
+		c.caCertSecret, err = c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(c.ctx, fmt.Sprintf("%s-ca-cert", c.flagNamePrefix), metav1.GetOptions{})
+		if err != nil {
+			return "", "", fmt.Errorf("error reading CA certificate secret from kubernetes: %s", err)
+		}
+		c.caKeySecret, err = c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(c.ctx, fmt.Sprintf("%s-ca-key", c.flagNamePrefix), metav1.GetOptions{})
+		if err != nil {
+			return "", "", fmt.Errorf("error reading CA private key secret from kubernetes: %s", err)
 		}
-	} else {
-		// We assume that these secrets aren't nil becase
-		// we created them above in case they are nil.
-		caBytes = c.caCertSecret.Data[corev1.TLSCertKey]
-		keyBytes = c.caKeySecret.Data[corev1.TLSPrivateKeyKey]
 	}
-	ca = string(caBytes)
-	pk = string(keyBytes)
+	return string(c.caCertSecret.Data[corev1.TLSCertKey]), string(c.caKeySecret.Data[corev1.TLSPrivateKeyKey]), nil
+}
 
+// generateServerCert returns a new server certificate and private key signed
+// by the CA. Its SANs are derived from the domain, datacenter and additional
+// DNS name and IP address flags.
+func (c *Command) generateServerCert(ca, pk string) (string, string, error) {
+	var hosts []string
 	for _, d := range c.flagDNSNames {
 		if len(d) > 0 {
 			hosts = append(hosts, strings.TrimSpace(d))
@@ -208,38 +250,41 @@ func (c *Command) Run(args []string) int {
 		}
 	}
 
-	name = fmt.Sprintf("server.%s.%s", c.flagDC, c.flagDomain)
+	name := fmt.Sprintf("server.%s.%s", c.flagDC, c.flagDomain)
 	hosts = append(hosts, name, "localhost", "127.0.0.1")
 
 	c.log.Info("parsing certificate signer from CA private key")
 	signer, err := cert.ParseSigner(pk)
 	if err != nil {
-		c.log.Error("error parsing signer from private key", "err", err)
-		return 1
+		return "", "", fmt.Errorf("error parsing signer from private key: %s", err)
 	}
 
 	c.log.Info("parsing CA certificate from PEM string")
 	caCert, err := cert.ParseCert([]byte(ca))
 	if err != nil {
-		c.log.Error("error parsing CA certificate from PEM string", "err", err)
-		return 1
+		return "", "", fmt.Errorf("error parsing CA certificate from PEM string: %s", err)
 	}
 
 	c.log.Info("generating server certificate and private key")
 	serverCert, serverKey, err := cert.GenerateCert(name, c.getDaysAsDuration(), caCert, signer, hosts)
 	if err != nil {
-		c.log.Error("error generating server certificate and private key", "err", err)
-		return 1
+		return "", "", fmt.Errorf("error generating server certificate and private key: %s", err)
 	}
+	return serverCert, serverKey, nil
+}
 
-	serverCertSecret, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(c.ctx, fmt.Sprintf("%s-server-cert", c.flagNamePrefix), metav1.GetOptions{})
+// saveServerCert creates or updates the server certificate secret with the
+// certificate and private key. The annotations are added to the secret.
+func (c *Command) saveServerCert(serverCert, serverKey string, annotations map[string]string) error {
+	serverCertSecret, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(c.ctx, c.serverCertSecretName(), metav1.GetOptions{})
 	if err != nil && k8serrors.IsNotFound(err) {
 		c.log.Info("creating server certificate and private key secret")
 		_, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Create(c.ctx, &corev1.Secret{
 			ObjectMeta: metav1.ObjectMeta{
-				Namespace: c.flagK8sNamespace,
-				Name:      fmt.Sprintf("%s-server-cert", c.flagNamePrefix),
-				Labels:    map[string]string{common.CLILabelKey: common.CLILabelValue},
+				Namespace:   c.flagK8sNamespace,
+				Name:        c.serverCertSecretName(),
+				Labels:      map[string]string{common.CLILabelKey: common.CLILabelValue},
+				Annotations: annotations,
 			},
 			Data: map[string][]byte{
 				corev1.TLSCertKey:       []byte(serverCert),
@@ -248,8 +293,7 @@ func (c *Command) Run(args []string) int {
 			Type: corev1.SecretTypeTLS,
 		}, metav1.CreateOptions{})
 		if err != nil {
-			c.log.Error("error creating server certificate secret in kubernetes", "err", err)
-			return 1
+			return fmt.Errorf("error creating server certificate secret in kubernetes: %s", err)
 		}
 	} else if err == nil {
 		serverCertSecret.Data = map[string][]byte{
@@ -262,19 +306,26 @@ func (c *Command) Run(args []string) int {
 		} else {
 			serverCertSecret.ObjectMeta.Labels[common.CLILabelKey] = common.CLILabelValue
 		}
+		for k, v := range annotations {
+			if serverCertSecret.ObjectMeta.Annotations == nil {
+				serverCertSecret.ObjectMeta.Annotations = map[string]string{}
+			}
+			serverCertSecret.ObjectMeta.Annotations[k] = v
+		}
 
 		c.log.Info("updating server certificate and private key secret")
 		_, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Update(c.ctx, serverCertSecret, metav1.UpdateOptions{})
 		if err != nil {
-			c.log.Error("error updating server certificate secret in kubernetes", "err", err)
-			return 1
+			return fmt.Errorf("error updating server certificate secret in kubernetes: %s", err)
 		}
 	} else {
-		c.log.Error("error reading server certificate secret from kubernetes", "err", err)
-		return 1
+		return fmt.Errorf("error reading server certificate secret from kubernetes: %s", err)
 	}
+	return nil
+}
 
-	return 0
+func (c *Command) serverCertSecretName() string {
+	return fmt.Sprintf("%s-server-cert", c.flagNamePrefix)
 }
 
 // getDaysAsDuration returns number of days the certificate
@@ -301,6 +352,18 @@ func (c *Command) init() {
 		"localhost is always included. This flag may be provided multiple times.")
 	c.flags.Var(&c.flagIPAddresses, "additional-ipaddress", "Additional IP address to add to the Consul server certificate as the Subject Alternative Name. "+
 		"127.0.0.1 is always included. This flag may be provided multiple times.")
+	c.flags.BoolVar(&c.flagRotate, "rotate", false,
+		"If true, keeps running and renews the server certificate from the existing CA when it is about to expire "+
+			"instead of issuing it once. The CA is never created in this mode.")
+	c.flags.DurationVar(&c.flagRotateInterval, "rotate-interval", time.Hour,
+		"The interval between checks of the server certificate expiry when -rotate is set.")
+	c.flags.DurationVar(&c.flagRenewBefore, "renew-before", 30*24*time.Hour,
+		"How long before it expires the server certificate is renewed when -rotate is set.")
+	c.flags.StringVar(&c.flagServerStatefulSet, "server-statefulset", "",
+		"Name of the Consul server StatefulSet to restart after the server certificate is renewed when -rotate is set. "+
+			"The servers are not restarted if empty.")
+	c.flags.StringVar(&c.flagListen, "listen", ":8080",
+		"Address to bind the listener for the metrics to when -rotate is set.")
 	c.flags.StringVar(&c.flagLogLevel, "log-level", "info",
 		"Log verbosity level. Supported values (in order of detail) are \"trace\", "+
 			"\"debug\", \"info\", \"warn\", and \"error\".")
@@ -357,6 +420,14 @@ func (c *Command) validateFlags() error {
 	if c.flagDays <= 0 {
 		return errors.New("-days must be a positive integer")
 	}
+	if c.flagRotate {
+		if c.flagRotateInterval <= 0 {
+			return errors.New("-rotate-interval must be greater than 0")
+		}
+		if c.flagRenewBefore <= 0 || c.flagRenewBefore >= time.Duration(c.flagDays)*24*time.Hour {
+			return errors.New("-renew-before must be greater than 0 and less than -days")
+		}
+	}
 
 	return nil
 }
@@ -369,4 +440,8 @@ Usage: consul-k8s-control-plane tls-init [options]
   for the Consul server. It manages the rotation of the Server certificates on subsequent
   runs. It can be provided with the CA certificate and key files on disk or can manage it's own CA.
 
+  With -rotate, it keeps running and renews the Server certificate from the existing CA
+  when it is within -renew-before of its expiry, then restarts the servers of
+  -server-statefulset. The expiry is exposed as a Prometheus metric on /metrics.
+
 `
@@ -0,0 +1,189 @@
+package tls_init
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/helper/cert"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// rotatedAtAnnotation is set to the time of the last renewal on the server
+// certificate secret and, once the servers are restarted, on the pod template
+// of the server StatefulSet. A restart that failed after the renewal is
+// retried while they differ.
+const rotatedAtAnnotation = "consul.hashicorp.com/server-cert-rotated-at"
+
+// rotateMetrics are the metrics of the rotation mode.
+type rotateMetrics struct {
+	expiry    prometheus.Gauge
+	rotations *prometheus.CounterVec
+}
+
+func newRotateMetrics(reg prometheus.Registerer) *rotateMetrics {
+	m := &rotateMetrics{
+		expiry: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "consul_server_tls_cert_expiry_timestamp_seconds",
+			Help: "The Unix time the Consul server certificate expires at.",
+		}),
+		rotations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "consul_server_tls_cert_rotations_total",
+			Help: "The number of Consul server certificate renewals by result.",
+		}, []string{"result"}),
+	}
+	reg.MustRegister(m.expiry, m.rotations)
+	return m
+}
+
+// rotate checks the expiry of the server certificate every rotate interval
+// until the process is interrupted and renews it when it is due.
+func (c *Command) rotate() int {
+	if c.sigCh == nil {
+		c.sigCh = make(chan os.Signal, 1)
+		signal.Notify(c.sigCh, syscall.SIGINT, syscall.SIGTERM)
+	}
+
+	reg := prometheus.NewRegistry()
+	c.metrics = newRotateMetrics(reg)
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(reg, promhttp.HandlerOpts{}))
+	server := &http.Server{Addr: c.flagListen, Handler: mux}
+	go func() {
+		c.log.Info("Listening", "address", c.flagListen)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			c.log.Error("Error listening", "err", err)
+		}
+	}()
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case sig := <-c.sigCh:
+			c.log.Info(fmt.Sprintf("%s received, shutting down", sig))
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	c.log.Info("Rotating the server certificate", "interval", c.flagRotateInterval, "renew-before", c.flagRenewBefore)
+	ticker := time.NewTicker(c.flagRotateInterval)
+	defer ticker.Stop()
+	for {
+		c.rotateOnce(ctx)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
+
+// rotateOnce renews the server certificate if it expires within the renew
+// before duration and restarts the servers if they haven't been restarted
+// since the last renewal. Errors are logged and retried on the next check.
+func (c *Command) rotateOnce(parent context.Context) {
+	var cancel context.CancelFunc
+	c.ctx, cancel = context.WithTimeout(parent, c.flagRotateInterval)
+	defer cancel()
+
+	if err := c.renewServerCert(); err != nil {
+		c.log.Error("Error renewing the server certificate", "err", err)
+		c.metrics.rotations.WithLabelValues("error").Inc()
+		return
+	}
+	if err := c.restartServers(); err != nil {
+		c.log.Error("Error restarting the servers", "err", err)
+	}
+}
+
+// renewServerCert issues a new server certificate from the current CA when
+// the certificate in the secret expires within the renew before duration or
+// can't be parsed.
+func (c *Command) renewServerCert() error {
+	secret, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(c.ctx, c.serverCertSecretName(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error reading server certificate secret from kubernetes: %s", err)
+	}
+	serverCert, err := cert.ParseCert(secret.Data[corev1.TLSCertKey])
+	if err != nil {
+		c.log.Warn("Unable to parse the server certificate, renewing it", "err", err)
+	} else {
+		c.metrics.expiry.Set(float64(serverCert.NotAfter.Unix()))
+		if time.Until(serverCert.NotAfter) > c.flagRenewBefore {
+			c.log.Debug("Server certificate is not due for renewal", "expiry", serverCert.NotAfter)
+			return nil
+		}
+		c.log.Info("Renewing the server certificate", "expiry", serverCert.NotAfter)
+	}
+
+	// The CA is read again for every renewal in case it was replaced.
+	c.caCertSecret, c.caKeySecret = nil, nil
+	ca, pk, err := c.loadCA()
+	if err != nil {
+		return err
+	}
+	certPEM, keyPEM, err := c.generateServerCert(ca, pk)
+	if err != nil {
+		return err
+	}
+	rotatedAt := time.Now().UTC().Format(time.RFC3339)
+	if err := c.saveServerCert(certPEM, keyPEM, map[string]string{rotatedAtAnnotation: rotatedAt}); err != nil {
+		return err
+	}
+
+	newCert, err := cert.ParseCert([]byte(certPEM))
+	if err != nil {
+		return err
+	}
+	c.metrics.expiry.Set(float64(newCert.NotAfter.Unix()))
+	c.metrics.rotations.WithLabelValues("success").Inc()
+	c.log.Info("Renewed the server certificate", "expiry", newCert.NotAfter)
+	return nil
+}
+
+// restartServers triggers a rolling restart of the server StatefulSet if its
+// pod template hasn't been annotated with the time of the last renewal, so
+// that the servers load the renewed certificate.
+func (c *Command) restartServers() error {
+	if c.flagServerStatefulSet == "" {
+		return nil
+	}
+	secret, err := c.clientset.CoreV1().Secrets(c.flagK8sNamespace).Get(c.ctx, c.serverCertSecretName(), metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error reading server certificate secret from kubernetes: %s", err)
+	}
+	rotatedAt := secret.Annotations[rotatedAtAnnotation]
+	if rotatedAt == "" {
+		// The certificate was only ever issued by tls-init before the
+		// servers were started.
+		return nil
+	}
+
+	statefulSet, err := c.clientset.AppsV1().StatefulSets(c.flagK8sNamespace).Get(c.ctx, c.flagServerStatefulSet, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("error reading server statefulset %q: %s", c.flagServerStatefulSet, err)
+	}
+	if statefulSet.Spec.Template.Annotations[rotatedAtAnnotation] == rotatedAt {
+		return nil
+	}
+
+	c.log.Info("Restarting the servers to load the renewed certificate", "statefulset", c.flagServerStatefulSet)
+	patch := fmt.Sprintf(`{"spec":{"template":{"metadata":{"annotations":{%q:%q}}}}}`, rotatedAtAnnotation, rotatedAt)
+	_, err = c.clientset.AppsV1().StatefulSets(c.flagK8sNamespace).Patch(c.ctx, c.flagServerStatefulSet, types.StrategicMergePatchType, []byte(patch), metav1.PatchOptions{})
+	if err != nil {
+		return fmt.Errorf("error restarting server statefulset %q: %s", c.flagServerStatefulSet, err)
+	}
+	return nil
+}
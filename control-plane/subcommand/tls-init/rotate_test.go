@@ -0,0 +1,229 @@
+package tls_init
+
+import (
+	"context"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul-k8s/control-plane/helper/cert"
+	"github.com/hashicorp/consul/sdk/testutil/retry"
+	"github.com/hashicorp/go-hclog"
+	"github.com/mitchellh/cli"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestRun_RotateFlagValidation(t *testing.T) {
+	t.Parallel()
+
+	cases := []struct {
+		flags  []string
+		expErr string
+	}{
+		{
+			flags:  []string{"-name-prefix", "consul", "-rotate", "-rotate-interval", "0s"},
+			expErr: "-rotate-interval must be greater than 0",
+		},
+		{
+			flags:  []string{"-name-prefix", "consul", "-rotate", "-renew-before", "0s"},
+			expErr: "-renew-before must be greater than 0 and less than -days",
+		},
+		{
+			flags:  []string{"-name-prefix", "consul", "-rotate", "-days", "30", "-renew-before", "720h"},
+			expErr: "-renew-before must be greater than 0 and less than -days",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.expErr, func(tt *testing.T) {
+			ui := cli.NewMockUi()
+			cmd := Command{UI: ui}
+			exitCode := cmd.Run(c.flags)
+			require.Equal(tt, 1, exitCode, ui.ErrorWriter.String())
+			require.Contains(tt, ui.ErrorWriter.String(), c.expErr)
+		})
+	}
+}
+
+// Test that the rotation mode renews an expiring certificate and restarts
+// the servers until it is interrupted.
+func TestRun_Rotate(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset()
+	createTestCerts(t, k8s, 24*time.Hour)
+
+	sigCh := make(chan os.Signal, 1)
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui, clientset: k8s, sigCh: sigCh}
+	exitCh := make(chan int, 1)
+	go func() {
+		exitCh <- cmd.Run([]string{
+			"-name-prefix=consul",
+			"-rotate",
+			"-listen=127.0.0.1:0",
+			"-server-statefulset=consul-server",
+		})
+	}()
+
+	retry.Run(t, func(r *retry.R) {
+		statefulSet, err := k8s.AppsV1().StatefulSets("default").Get(context.Background(), "consul-server", metav1.GetOptions{})
+		require.NoError(r, err)
+		require.NotEmpty(r, statefulSet.Spec.Template.Annotations[rotatedAtAnnotation])
+	})
+
+	sigCh <- syscall.SIGTERM
+	select {
+	case exitCode := <-exitCh:
+		require.Equal(t, 0, exitCode, ui.ErrorWriter.String())
+	case <-time.After(5 * time.Second):
+		t.Fatal("rotation did not stop")
+	}
+}
+
+func TestRotateOnce(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		validity     time.Duration
+		rotatedAt    string
+		expRenewed   bool
+		expRestarted bool
+	}{
+		"certificate expiring within renew before": {
+			validity:     24 * time.Hour,
+			expRenewed:   true,
+			expRestarted: true,
+		},
+		"certificate not due for renewal": {
+			validity:     365 * 24 * time.Hour,
+			expRenewed:   false,
+			expRestarted: false,
+		},
+		"restart pending since the last renewal": {
+			validity:     365 * 24 * time.Hour,
+			rotatedAt:    "2022-09-01T00:00:00Z",
+			expRenewed:   false,
+			expRestarted: true,
+		},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			k8s := fake.NewSimpleClientset()
+			oldCert := createTestCerts(t, k8s, c.validity)
+			if c.rotatedAt != "" {
+				secret, err := k8s.CoreV1().Secrets("default").Get(context.Background(), "consul-server-cert", metav1.GetOptions{})
+				require.NoError(t, err)
+				secret.Annotations = map[string]string{rotatedAtAnnotation: c.rotatedAt}
+				_, err = k8s.CoreV1().Secrets("default").Update(context.Background(), secret, metav1.UpdateOptions{})
+				require.NoError(t, err)
+			}
+
+			cmd := Command{UI: cli.NewMockUi(), clientset: k8s, log: hclog.NewNullLogger()}
+			cmd.init()
+			require.NoError(t, cmd.flags.Parse([]string{"-name-prefix=consul", "-rotate", "-server-statefulset=consul-server"}))
+			reg := prometheus.NewRegistry()
+			cmd.metrics = newRotateMetrics(reg)
+			cmd.rotateOnce(context.Background())
+
+			secret, err := k8s.CoreV1().Secrets("default").Get(context.Background(), "consul-server-cert", metav1.GetOptions{})
+			require.NoError(t, err)
+			serverCert, err := cert.ParseCert(secret.Data[corev1.TLSCertKey])
+			require.NoError(t, err)
+			require.Equal(t, float64(serverCert.NotAfter.Unix()), promtestutil.ToFloat64(cmd.metrics.expiry))
+
+			statefulSet, err := k8s.AppsV1().StatefulSets("default").Get(context.Background(), "consul-server", metav1.GetOptions{})
+			require.NoError(t, err)
+			restartedAt := statefulSet.Spec.Template.Annotations[rotatedAtAnnotation]
+
+			if c.expRenewed {
+				require.NotEqual(t, oldCert, string(secret.Data[corev1.TLSCertKey]))
+				require.WithinDuration(t, time.Now().Add(1825*24*time.Hour), serverCert.NotAfter, time.Hour)
+				require.ElementsMatch(t, []string{"server.dc1.consul", "localhost"}, serverCert.DNSNames)
+				require.Equal(t, float64(1), promtestutil.ToFloat64(cmd.metrics.rotations.WithLabelValues("success")))
+			} else {
+				require.Equal(t, oldCert, string(secret.Data[corev1.TLSCertKey]))
+				require.Equal(t, float64(0), promtestutil.ToFloat64(cmd.metrics.rotations.WithLabelValues("success")))
+			}
+			if c.expRestarted {
+				require.NotEmpty(t, restartedAt)
+				require.Equal(t, secret.Annotations[rotatedAtAnnotation], restartedAt)
+			} else {
+				require.Empty(t, restartedAt)
+			}
+		})
+	}
+}
+
+func TestRotateOnce_MissingCA(t *testing.T) {
+	t.Parallel()
+	k8s := fake.NewSimpleClientset()
+	createTestCerts(t, k8s, 24*time.Hour)
+	require.NoError(t, k8s.CoreV1().Secrets("default").Delete(context.Background(), "consul-ca-key", metav1.DeleteOptions{}))
+
+	cmd := Command{UI: cli.NewMockUi(), clientset: k8s, log: hclog.NewNullLogger()}
+	cmd.init()
+	require.NoError(t, cmd.flags.Parse([]string{"-name-prefix=consul", "-rotate", "-server-statefulset=consul-server"}))
+	reg := prometheus.NewRegistry()
+	cmd.metrics = newRotateMetrics(reg)
+	cmd.rotateOnce(context.Background())
+
+	expected := `
+# HELP consul_server_tls_cert_rotations_total The number of Consul server certificate renewals by result.
+# TYPE consul_server_tls_cert_rotations_total counter
+consul_server_tls_cert_rotations_total{result="error"} 1
+`
+	require.NoError(t, promtestutil.GatherAndCompare(reg, strings.NewReader(expected), "consul_server_tls_cert_rotations_total"))
+}
+
+// createTestCerts creates the CA secrets, a server certificate secret with a
+// certificate valid for the duration and the server statefulset. It returns
+// the server certificate.
+func createTestCerts(t *testing.T, k8s kubernetes.Interface, validity time.Duration) string {
+	_, caKey, caCert, _, err := cert.GenerateCA("Consul Agent CA")
+	require.NoError(t, err)
+	signer, err := cert.ParseSigner(caKey)
+	require.NoError(t, err)
+	ca, err := cert.ParseCert([]byte(caCert))
+	require.NoError(t, err)
+	serverCert, serverKey, err := cert.GenerateCert("server.dc1.consul", validity, ca, signer, []string{"server.dc1.consul"})
+	require.NoError(t, err)
+
+	secrets := []*corev1.Secret{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-ca-cert", Namespace: "default"},
+			Data:       map[string][]byte{corev1.TLSCertKey: []byte(caCert)},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-ca-key", Namespace: "default"},
+			Data:       map[string][]byte{corev1.TLSPrivateKeyKey: []byte(caKey)},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "consul-server-cert", Namespace: "default"},
+			Data: map[string][]byte{
+				corev1.TLSCertKey:       []byte(serverCert),
+				corev1.TLSPrivateKeyKey: []byte(serverKey),
+			},
+			Type: corev1.SecretTypeTLS,
+		},
+	}
+	for _, secret := range secrets {
+		_, err := k8s.CoreV1().Secrets("default").Create(context.Background(), secret, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+	_, err = k8s.AppsV1().StatefulSets("default").Create(context.Background(), &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "consul-server", Namespace: "default"},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+	return serverCert
+}
@@ -23,8 +23,11 @@ import (
 	"github.com/hashicorp/go-multierror"
 	"github.com/mitchellh/cli"
 	corev1 "k8s.io/api/core/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -46,7 +49,8 @@ type Command struct {
 	flagDeploymentName      string
 	flagDeploymentNamespace string
 
-	clientset kubernetes.Interface
+	clientset       kubernetes.Interface
+	apiextClientset apiextclientset.Interface
 
 	once   sync.Once
 	help   string
@@ -110,17 +114,26 @@ func (c *Command) Run(args []string) int {
 		return 1
 	}
 
-	// Create the Kubernetes clientset
-	if c.clientset == nil {
+	// Create the Kubernetes clientsets
+	if c.clientset == nil || c.apiextClientset == nil {
 		config, err := subcommand.K8SConfig(c.k8s.KubeConfig())
 		if err != nil {
 			c.UI.Error(fmt.Sprintf("Error retrieving Kubernetes auth: %s", err))
 			return 1
 		}
-		c.clientset, err = kubernetes.NewForConfig(config)
-		if err != nil {
-			c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
-			return 1
+		if c.clientset == nil {
+			c.clientset, err = kubernetes.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes client: %s", err))
+				return 1
+			}
+		}
+		if c.apiextClientset == nil {
+			c.apiextClientset, err = apiextclientset.NewForConfig(config)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error initializing Kubernetes apiextensions client: %s", err))
+				return 1
+			}
 		}
 	}
 
@@ -149,7 +162,7 @@ func (c *Command) Run(args []string) int {
 	defer cancelFunc()
 
 	for i, config := range configs {
-		if err := config.validate(ctx, c.clientset); err != nil {
+		if err := config.validate(ctx, c.clientset, c.apiextClientset); err != nil {
 			c.UI.Error(fmt.Sprintf("Error parsing config at index %d: %s", i, err))
 			return 1
 		}
@@ -168,6 +181,12 @@ func (c *Command) Run(args []string) int {
 	for _, config := range configs {
 		if c.source != nil {
 			certSource = c.source
+		} else if config.ExternalCert {
+			certSource = &cert.SecretSource{
+				Clientset: c.clientset,
+				Name:      config.SecretName,
+				Namespace: config.SecretNamespace,
+			}
 		} else {
 			certSource = &cert.GenSource{
 				Name:   "Consul Webhook Certificates",
@@ -180,7 +199,7 @@ func (c *Command) Run(args []string) int {
 		certNotify := &cert.Notify{Source: certSource, Ch: certCh, WebhookConfigName: config.Name, SecretName: config.SecretName, SecretNamespace: config.SecretNamespace}
 		notifiers = append(notifiers, certNotify)
 		go certNotify.Start(ctx)
-		go c.certWatcher(ctx, certCh, c.clientset, c.logger)
+		go c.certWatcher(ctx, certCh, config, c.logger)
 	}
 
 	// We define a signal handler for OS interrupts, and when an SIGINT or SIGTERM is received,
@@ -196,13 +215,13 @@ func (c *Command) Run(args []string) int {
 }
 
 // certWatcher listens for a new MetaBundle on the ch channel for all webhooks and updates
-// MutatingWebhooksConfigs and Secrets when a new Bundle is available on the channel.
-func (c *Command) certWatcher(ctx context.Context, ch <-chan cert.MetaBundle, clientset kubernetes.Interface, log hclog.Logger) {
+// the webhook configurations, CRDs and Secrets of the config when a new Bundle is available on the channel.
+func (c *Command) certWatcher(ctx context.Context, ch <-chan cert.MetaBundle, config webhookConfig, log hclog.Logger) {
 	var bundle cert.MetaBundle
 	for {
 		select {
 		case bundle = <-ch:
-			log.Info(fmt.Sprintf("Updated certificate bundle received for %s; Updating webhook certs.", bundle.WebhookConfigName))
+			log.Info(fmt.Sprintf("Updated certificate bundle received for %s; Updating webhook certs.", config.description()))
 			// Bundle is updated, set it up
 
 		case <-time.After(defaultRetryDuration):
@@ -217,17 +236,30 @@ func (c *Command) certWatcher(ctx context.Context, ch <-chan cert.MetaBundle, cl
 			return
 		}
 
-		if err := c.reconcileCertificates(ctx, clientset, bundle, log); err != nil {
+		if err := c.reconcileCertificates(ctx, config, bundle, log); err != nil {
 			log.Error("failed to reconcile certificates", "err", err)
 		}
 	}
 }
 
 // reconcileCertificates ensures the secret in the MetaBundle has the latest certificate from the MetaBundle and the caBundles on the
-// MutatingWebhookConfiguration have the latest CA certificate from the MetaBundle. It updates them if they are outdated and exits early
-// if they are up-to date.
-func (c *Command) reconcileCertificates(ctx context.Context, clientset kubernetes.Interface, bundle cert.MetaBundle, log hclog.Logger) error {
-	iterLog := log.With("mutatingwebhookconfig", bundle.WebhookConfigName, "secret", bundle.SecretName, "secretNS", bundle.SecretNamespace)
+// webhook configurations and CRDs of the config have the latest CA certificate from the MetaBundle. It updates them if they are outdated
+// and exits early if they are up-to date. The secret of an external certificate is managed by its issuer so only the caBundles are updated.
+func (c *Command) reconcileCertificates(ctx context.Context, config webhookConfig, bundle cert.MetaBundle, log hclog.Logger) error {
+	clientset := c.clientset
+	iterLog := log.With("webhooks", config.description(), "secret", bundle.SecretName, "secretNS", bundle.SecretNamespace)
+
+	if config.ExternalCert {
+		if c.caBundlesUpdated(ctx, config, bundle.CACert) {
+			return nil
+		}
+		iterLog.Info("Updating webhook configuration with new CA")
+		if err := c.updateCABundles(ctx, config, bundle.CACert); err != nil {
+			iterLog.Error("Error updating webhook configuration", "err", err)
+			return err
+		}
+		return nil
+	}
 
 	deployment, err := clientset.AppsV1().Deployments(c.flagDeploymentNamespace).Get(ctx, c.flagDeploymentName, metav1.GetOptions{})
 	if err != nil {
@@ -263,9 +295,9 @@ func (c *Command) reconcileCertificates(ctx context.Context, clientset kubernete
 		}
 
 		iterLog.Info("Updating webhook configuration")
-		err = mutatingwebhookconfiguration.UpdateWithCABundle(ctx, c.clientset, bundle.WebhookConfigName, bundle.CACert)
+		err = c.updateCABundles(ctx, config, bundle.CACert)
 		if err != nil {
-			iterLog.Error("Error updating webhook configuration")
+			iterLog.Error("Error updating webhook configuration", "err", err)
 			return err
 		}
 		return nil
@@ -275,7 +307,7 @@ func (c *Command) reconcileCertificates(ctx context.Context, clientset kubernete
 	}
 
 	// Don't update secret if the certificate and key are unchanged.
-	if bytes.Equal(certSecret.Data[corev1.TLSCertKey], bundle.Cert) && bytes.Equal(certSecret.Data[corev1.TLSPrivateKeyKey], bundle.Key) && c.webhookUpdated(ctx, bundle, clientset) {
+	if bytes.Equal(certSecret.Data[corev1.TLSCertKey], bundle.Cert) && bytes.Equal(certSecret.Data[corev1.TLSPrivateKeyKey], bundle.Key) && c.caBundlesUpdated(ctx, config, bundle.CACert) {
 		return nil
 	}
 
@@ -306,7 +338,7 @@ func (c *Command) reconcileCertificates(ctx context.Context, clientset kubernete
 	}
 
 	iterLog.Info("Updating webhook configuration with new CA")
-	err = mutatingwebhookconfiguration.UpdateWithCABundle(ctx, clientset, bundle.WebhookConfigName, bundle.CACert)
+	err = c.updateCABundles(ctx, config, bundle.CACert)
 	if err != nil {
 		iterLog.Error("Error updating webhook configuration", "err", err)
 		return err
@@ -314,15 +346,77 @@ func (c *Command) reconcileCertificates(ctx context.Context, clientset kubernete
 	return nil
 }
 
-// webhookUpdated verifies if every caBundle on the specified webhook configuration matches the desired CA certificate.
-// It returns true if the CA is up-to date and false if it needs to be updated.
-func (c *Command) webhookUpdated(ctx context.Context, bundle cert.MetaBundle, clientset kubernetes.Interface) bool {
-	webhookCfg, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, bundle.WebhookConfigName, metav1.GetOptions{})
-	if err != nil {
-		return false
+// updateCABundles sets the caBundle of every webhook on the webhook configurations and the conversion webhooks of the CRDs of the
+// config to the CA certificate.
+func (c *Command) updateCABundles(ctx context.Context, config webhookConfig, caCert []byte) error {
+	if config.Name != "" {
+		if err := mutatingwebhookconfiguration.UpdateWithCABundle(ctx, c.clientset, config.Name, caCert); err != nil {
+			return err
+		}
+	}
+	for _, name := range config.ValidatingWebhookConfigNames {
+		if err := mutatingwebhookconfiguration.UpdateValidatingWithCABundle(ctx, c.clientset, name, caCert); err != nil {
+			return err
+		}
+	}
+	for _, name := range config.CRDNames {
+		if len(caCert) == 0 {
+			return errors.New("no CA certificate in the bundle")
+		}
+		patch, err := json.Marshal(map[string]interface{}{
+			"spec": map[string]interface{}{
+				"conversion": map[string]interface{}{
+					"webhook": map[string]interface{}{
+						"clientConfig": map[string]interface{}{
+							"caBundle": caCert,
+						},
+					},
+				},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := c.apiextClientset.ApiextensionsV1().CustomResourceDefinitions().Patch(ctx, name, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+			return err
+		}
 	}
-	for _, webhook := range webhookCfg.Webhooks {
-		if !bytes.Equal(webhook.ClientConfig.CABundle, bundle.CACert) {
+	return nil
+}
+
+// caBundlesUpdated verifies if every caBundle on the webhook configurations and the conversion webhooks of the CRDs of the config
+// matches the desired CA certificate. It returns true if the CA is up-to date and false if it needs to be updated.
+func (c *Command) caBundlesUpdated(ctx context.Context, config webhookConfig, caCert []byte) bool {
+	if config.Name != "" {
+		webhookCfg, err := c.clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, config.Name, metav1.GetOptions{})
+		if err != nil {
+			return false
+		}
+		for _, webhook := range webhookCfg.Webhooks {
+			if !bytes.Equal(webhook.ClientConfig.CABundle, caCert) {
+				return false
+			}
+		}
+	}
+	for _, name := range config.ValidatingWebhookConfigNames {
+		webhookCfg, err := c.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false
+		}
+		for _, webhook := range webhookCfg.Webhooks {
+			if !bytes.Equal(webhook.ClientConfig.CABundle, caCert) {
+				return false
+			}
+		}
+	}
+	for _, name := range config.CRDNames {
+		crd, err := c.apiextClientset.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false
+		}
+		conversion := crd.Spec.Conversion
+		if conversion == nil || conversion.Webhook == nil || conversion.Webhook.ClientConfig == nil ||
+			!bytes.Equal(conversion.Webhook.ClientConfig.CABundle, caCert) {
 			return false
 		}
 	}
@@ -330,21 +424,50 @@ func (c *Command) webhookUpdated(ctx context.Context, bundle cert.MetaBundle, cl
 }
 
 type webhookConfig struct {
+	// Name is the name of the MutatingWebhookConfiguration. It may only be
+	// empty if there are validating webhook configurations or CRDs to update.
 	Name            string   `json:"name,omitempty"`
 	TLSAutoHosts    []string `json:"tlsAutoHosts,omitempty"`
 	SecretName      string   `json:"secretName,omitempty"`
 	SecretNamespace string   `json:"secretNamespace,omitempty"`
+
+	// ValidatingWebhookConfigNames are the names of the
+	// ValidatingWebhookConfigurations that are served with the certificate.
+	ValidatingWebhookConfigNames []string `json:"validatingWebhookConfigNames,omitempty"`
+	// CRDNames are the names of the CustomResourceDefinitions whose conversion
+	// webhook is served with the certificate.
+	CRDNames []string `json:"crdNames,omitempty"`
+	// ExternalCert is true if the certificate is issued by another system,
+	// for example cert-manager. The certificate is then read from the Secret,
+	// which must have the tls.crt, tls.key and ca.crt keys, instead of being
+	// generated and written to it.
+	ExternalCert bool `json:"externalCert,omitempty"`
 }
 
-func (c webhookConfig) validate(ctx context.Context, client kubernetes.Interface) error {
+func (c webhookConfig) validate(ctx context.Context, client kubernetes.Interface, apiextClient apiextclientset.Interface) error {
 	var err *multierror.Error
 	if c.Name == "" {
-		err = multierror.Append(err, errors.New(`config.Name cannot be ""`))
+		if len(c.ValidatingWebhookConfigNames) == 0 && len(c.CRDNames) == 0 {
+			err = multierror.Append(err, errors.New(`config.Name cannot be ""`))
+		}
 	} else {
 		if _, err2 := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, c.Name, metav1.GetOptions{}); err2 != nil && k8serrors.IsNotFound(err2) {
 			err = multierror.Append(err, fmt.Errorf("MutatingWebhookConfiguration with name \"%s\" must exist in cluster", c.Name))
 		}
 	}
+	for _, name := range c.ValidatingWebhookConfigNames {
+		if _, err2 := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{}); err2 != nil && k8serrors.IsNotFound(err2) {
+			err = multierror.Append(err, fmt.Errorf("ValidatingWebhookConfiguration with name \"%s\" must exist in cluster", name))
+		}
+	}
+	for _, name := range c.CRDNames {
+		crd, err2 := apiextClient.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, name, metav1.GetOptions{})
+		if err2 != nil && k8serrors.IsNotFound(err2) {
+			err = multierror.Append(err, fmt.Errorf("CustomResourceDefinition with name \"%s\" must exist in cluster", name))
+		} else if err2 == nil && (crd.Spec.Conversion == nil || crd.Spec.Conversion.Strategy != apiextv1.WebhookConverter) {
+			err = multierror.Append(err, fmt.Errorf("CustomResourceDefinition with name \"%s\" must use the Webhook conversion strategy", name))
+		}
+	}
 	if c.SecretName == "" {
 		err = multierror.Append(err, errors.New(`config.SecretName cannot be ""`))
 	}
@@ -365,6 +488,18 @@ func (c webhookConfig) validate(ctx context.Context, client kubernetes.Interface
 	return nil
 }
 
+// description returns the names of the webhook configurations and CRDs of the
+// config for logging.
+func (c webhookConfig) description() string {
+	var names []string
+	if c.Name != "" {
+		names = append(names, c.Name)
+	}
+	names = append(names, c.ValidatingWebhookConfigNames...)
+	names = append(names, c.CRDNames...)
+	return strings.Join(names, ", ")
+}
+
 func (c *Command) Help() string {
 	c.once.Do(c.init)
 	return c.help
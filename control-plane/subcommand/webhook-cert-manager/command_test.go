@@ -15,6 +15,9 @@ import (
 	admissionv1 "k8s.io/api/admissionregistration/v1"
 	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
+	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apiextclientset "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset"
+	apiextfake "k8s.io/apiextensions-apiserver/pkg/client/clientset/clientset/fake"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
@@ -82,8 +85,9 @@ func testSignalHandling(sig os.Signal) func(*testing.T) {
 		k8s := fake.NewSimpleClientset(webhookOne, webhookTwo, deployment)
 		ui := cli.NewMockUi()
 		cmd := Command{
-			UI:        ui,
-			clientset: k8s,
+			UI:              ui,
+			clientset:       k8s,
+			apiextClientset: apiextfake.NewSimpleClientset(),
 		}
 		cmd.init()
 
@@ -205,8 +209,9 @@ func TestRun_SecretDoesNotExist(t *testing.T) {
 	k8s := fake.NewSimpleClientset(webhookOne, webhookTwo, deployment)
 	ui := cli.NewMockUi()
 	cmd := Command{
-		UI:        ui,
-		clientset: k8s,
+		UI:              ui,
+		clientset:       k8s,
+		apiextClientset: apiextfake.NewSimpleClientset(),
 	}
 	cmd.init()
 
@@ -333,8 +338,9 @@ func TestRun_SecretExists(t *testing.T) {
 	k8s := fake.NewSimpleClientset(webhookOne, webhookTwo, secretOne, secretTwo, deployment)
 	ui := cli.NewMockUi()
 	cmd := Command{
-		UI:        ui,
-		clientset: k8s,
+		UI:              ui,
+		clientset:       k8s,
+		apiextClientset: apiextfake.NewSimpleClientset(),
 	}
 	cmd.init()
 
@@ -432,9 +438,10 @@ func TestRun_SecretUpdates(t *testing.T) {
 	oneSec := 1 * time.Second
 
 	cmd := Command{
-		UI:         ui,
-		clientset:  k8s,
-		certExpiry: &oneSec,
+		UI:              ui,
+		clientset:       k8s,
+		apiextClientset: apiextfake.NewSimpleClientset(),
+		certExpiry:      &oneSec,
 	}
 	cmd.init()
 
@@ -545,9 +552,10 @@ func TestRun_WebhookConfigModified(t *testing.T) {
 
 	// Start the command.
 	cmd := Command{
-		UI:         cli.NewMockUi(),
-		clientset:  k8s,
-		certExpiry: &certExpiry,
+		UI:              cli.NewMockUi(),
+		clientset:       k8s,
+		apiextClientset: apiextfake.NewSimpleClientset(),
+		certExpiry:      &certExpiry,
 	}
 
 	configFile := common.WriteTempFile(t, configFile)
@@ -587,6 +595,151 @@ func TestRun_WebhookConfigModified(t *testing.T) {
 	})
 }
 
+// Test that the caBundles of validating webhook configurations and of the
+// conversion webhooks of CRDs are updated along with the certificate.
+func TestRun_ValidatingWebhookAndCRD(t *testing.T) {
+	t.Parallel()
+
+	deploymentName := "deployment"
+	deploymentNamespace := "deploy-ns"
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      deploymentName,
+			Namespace: deploymentNamespace,
+			UID:       types.UID("this-is-a-uid"),
+		},
+	}
+	validatingWebhook := &admissionv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "validatingWebhook",
+		},
+		Webhooks: []admissionv1.ValidatingWebhook{
+			{
+				Name: "webhook-under-test",
+			},
+		},
+	}
+	crd := conversionCRD("crd", []byte("bootstrapped-CA"))
+
+	k8s := fake.NewSimpleClientset(validatingWebhook, deployment)
+	apiext := apiextfake.NewSimpleClientset(crd)
+	ctx := context.Background()
+
+	cmd := Command{
+		UI:              cli.NewMockUi(),
+		clientset:       k8s,
+		apiextClientset: apiext,
+		source:          &mocks.MockCertSource{},
+	}
+	exitCh := runCommandAsynchronously(&cmd, []string{
+		"-config-file", common.WriteTempFile(t, configFileValidatingWebhookAndCRD),
+		"-deployment-name", deploymentName,
+		"-deployment-namespace", deploymentNamespace,
+	})
+	defer stopCommand(t, &cmd, exitCh)
+
+	timer := &retry.Timer{Timeout: 5 * time.Second, Wait: 500 * time.Millisecond}
+	retry.RunWith(timer, t, func(r *retry.R) {
+		secret, err := k8s.CoreV1().Secrets("default").Get(ctx, "secret-deploy-1", metav1.GetOptions{})
+		require.NoError(r, err)
+		require.Contains(r, string(secret.Data[v1.TLSCertKey]), "certificate-string")
+
+		webhookConfig, err := k8s.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, "validatingWebhook", metav1.GetOptions{})
+		require.NoError(r, err)
+		require.Contains(r, string(webhookConfig.Webhooks[0].ClientConfig.CABundle), "ca-certificate-string")
+
+		crd, err := apiext.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, "crd", metav1.GetOptions{})
+		require.NoError(r, err)
+		require.Contains(r, string(crd.Spec.Conversion.Webhook.ClientConfig.CABundle), "ca-certificate-string")
+	})
+}
+
+// Test that an external certificate is read from its Secret, that the Secret
+// is not modified and that renewals of the certificate update the caBundles.
+func TestRun_ExternalCert(t *testing.T) {
+	t.Parallel()
+
+	deploymentName := "deployment"
+	deploymentNamespace := "deploy-ns"
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      deploymentName,
+			Namespace: deploymentNamespace,
+			UID:       types.UID("this-is-a-uid"),
+		},
+	}
+	webhook := &admissionv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "webhookOne",
+		},
+		Webhooks: []admissionv1.MutatingWebhook{
+			{
+				Name: "webhook-under-test",
+			},
+		},
+	}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "secret-deploy-1",
+			Namespace: "default",
+		},
+		Data: map[string][]byte{
+			v1.TLSCertKey:       []byte("issued-cert"),
+			v1.TLSPrivateKeyKey: []byte("issued-key"),
+			"ca.crt":            []byte("issuer-ca"),
+		},
+		Type: v1.SecretTypeTLS,
+	}
+	crd := conversionCRD("crd", nil)
+
+	k8s := fake.NewSimpleClientset(webhook, secret, deployment)
+	apiext := apiextfake.NewSimpleClientset(crd)
+	ctx := context.Background()
+
+	cmd := Command{
+		UI:              cli.NewMockUi(),
+		clientset:       k8s,
+		apiextClientset: apiext,
+	}
+	exitCh := runCommandAsynchronously(&cmd, []string{
+		"-config-file", common.WriteTempFile(t, configFileExternalCert),
+		"-deployment-name", deploymentName,
+		"-deployment-namespace", deploymentNamespace,
+	})
+	defer stopCommand(t, &cmd, exitCh)
+
+	requireCABundles := func(t *testing.T, caBundle string) {
+		timer := &retry.Timer{Timeout: 5 * time.Second, Wait: 100 * time.Millisecond}
+		retry.RunWith(timer, t, func(r *retry.R) {
+			webhookConfig, err := k8s.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, "webhookOne", metav1.GetOptions{})
+			require.NoError(r, err)
+			require.Equal(r, caBundle, string(webhookConfig.Webhooks[0].ClientConfig.CABundle))
+
+			crd, err := apiext.ApiextensionsV1().CustomResourceDefinitions().Get(ctx, "crd", metav1.GetOptions{})
+			require.NoError(r, err)
+			require.Equal(r, caBundle, string(crd.Spec.Conversion.Webhook.ClientConfig.CABundle))
+		})
+	}
+	requireCABundles(t, "issuer-ca")
+
+	// Renew the certificate as its issuer would.
+	secret.Data = map[string][]byte{
+		v1.TLSCertKey:       []byte("renewed-cert"),
+		v1.TLSPrivateKeyKey: []byte("renewed-key"),
+		"ca.crt":            []byte("renewed-issuer-ca"),
+	}
+	_, err := k8s.CoreV1().Secrets("default").Update(ctx, secret, metav1.UpdateOptions{})
+	require.NoError(t, err)
+	requireCABundles(t, "renewed-issuer-ca")
+
+	// The Secret is left to its issuer.
+	fetched, err := k8s.CoreV1().Secrets("default").Get(ctx, "secret-deploy-1", metav1.GetOptions{})
+	require.NoError(t, err)
+	require.Equal(t, secret.Data, fetched.Data)
+	require.Empty(t, fetched.OwnerReferences)
+	require.Empty(t, fetched.Labels)
+}
+
 // This test verifies that when there is an error while attempting to update
 // the certs or the webhook config, it retries the update every second until
 // it succeeds.
@@ -622,9 +775,10 @@ func TestCertWatcher(t *testing.T) {
 	ui := cli.NewMockUi()
 
 	cmd := Command{
-		UI:        ui,
-		clientset: k8s,
-		source:    certSource,
+		UI:              ui,
+		clientset:       k8s,
+		apiextClientset: apiextfake.NewSimpleClientset(),
+		source:          certSource,
 	}
 	cmd.init()
 
@@ -673,12 +827,22 @@ func TestValidate(t *testing.T) {
 			Name: "webhook-config-name",
 		},
 	}
-	client := fake.NewSimpleClientset(webhook)
+	validatingWebhook := &admissionv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "validating-webhook-config-name",
+		},
+	}
+	client := fake.NewSimpleClientset(webhook, validatingWebhook)
+	apiextClient := apiextfake.NewSimpleClientset(
+		conversionCRD("crd-name", nil),
+		&apiextv1.CustomResourceDefinition{ObjectMeta: metav1.ObjectMeta{Name: "no-conversion-crd-name"}},
+	)
 
 	cases := map[string]struct {
-		config    webhookConfig
-		clientset kubernetes.Interface
-		expErr    string
+		config          webhookConfig
+		clientset       kubernetes.Interface
+		apiextClientset apiextclientset.Interface
+		expErr          string
 	}{
 		"name": {
 			config: webhookConfig{
@@ -729,14 +893,74 @@ func TestValidate(t *testing.T) {
 			},
 			expErr: `config.Name cannot be "", config.SecretName cannot be "", config.SecretNameSpace cannot be ""`,
 		},
+		"nonExistantVWC": {
+			config: webhookConfig{
+				ValidatingWebhookConfigNames: []string{"validating-webhook-config-name", "other-name"},
+				SecretName:                   "secret-name",
+				SecretNamespace:              "default",
+			},
+			clientset:       client,
+			apiextClientset: apiextClient,
+			expErr:          `ValidatingWebhookConfiguration with name "other-name" must exist in cluster`,
+		},
+		"nonExistantCRD": {
+			config: webhookConfig{
+				CRDNames:        []string{"crd-name", "other-name"},
+				SecretName:      "secret-name",
+				SecretNamespace: "default",
+			},
+			clientset:       client,
+			apiextClientset: apiextClient,
+			expErr:          `CustomResourceDefinition with name "other-name" must exist in cluster`,
+		},
+		"crdWithoutConversionWebhook": {
+			config: webhookConfig{
+				CRDNames:        []string{"no-conversion-crd-name"},
+				SecretName:      "secret-name",
+				SecretNamespace: "default",
+			},
+			clientset:       client,
+			apiextClientset: apiextClient,
+			expErr:          `CustomResourceDefinition with name "no-conversion-crd-name" must use the Webhook conversion strategy`,
+		},
 	}
 
 	for name, c := range cases {
 		t.Run(name, func(tt *testing.T) {
-			err := c.config.validate(context.Background(), c.clientset)
+			err := c.config.validate(context.Background(), c.clientset, c.apiextClientset)
 			require.EqualError(tt, err, c.expErr)
 		})
 	}
+
+	// A config without a MutatingWebhookConfiguration is valid if it has
+	// validating webhook configurations or CRDs.
+	err := webhookConfig{
+		ValidatingWebhookConfigNames: []string{"validating-webhook-config-name"},
+		CRDNames:                     []string{"crd-name"},
+		SecretName:                   "secret-name",
+		SecretNamespace:              "default",
+	}.validate(context.Background(), client, apiextClient)
+	require.NoError(t, err)
+}
+
+// conversionCRD returns a CustomResourceDefinition with a conversion webhook
+// that has the caBundle.
+func conversionCRD(name string, caBundle []byte) *apiextv1.CustomResourceDefinition {
+	return &apiextv1.CustomResourceDefinition{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: name,
+		},
+		Spec: apiextv1.CustomResourceDefinitionSpec{
+			Conversion: &apiextv1.CustomResourceConversion{
+				Strategy: apiextv1.WebhookConverter,
+				Webhook: &apiextv1.WebhookConversion{
+					ClientConfig: &apiextv1.WebhookClientConfig{
+						CABundle: caBundle,
+					},
+				},
+			},
+		},
+	}
 }
 
 // This function starts the command asynchronously and returns a non-blocking chan.
@@ -801,3 +1025,31 @@ const configFileUpdates = `[
     "secretNamespace": "default"
   }
 ]`
+
+const configFileValidatingWebhookAndCRD = `[
+  {
+    "validatingWebhookConfigNames": [
+      "validatingWebhook"
+    ],
+    "crdNames": [
+      "crd"
+    ],
+    "tlsAutoHosts": [
+      "foo"
+    ],
+    "secretName": "secret-deploy-1",
+    "secretNamespace": "default"
+  }
+]`
+
+const configFileExternalCert = `[
+  {
+    "name": "webhookOne",
+    "crdNames": [
+      "crd"
+    ],
+    "externalCert": true,
+    "secretName": "secret-deploy-1",
+    "secretNamespace": "default"
+  }
+]`